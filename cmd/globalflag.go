@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"flag"
+	"strings"
+
+	"github.com/goccy/go-json"
+	"github.com/urfave/cli/v2"
+	"github.com/urfave/cli/v2/altsrc"
+)
+
+type (
+	// rawStringSlice is a flag value that collects each value given as is
+	//
+	// Unlike cli.StringSlice, values aren't split on commas or trimmed, as they
+	// may be arbitrary text (e.g. NAME=VALUE pairs).
+	rawStringSlice struct {
+		values []string
+	}
+
+	// rawStringSliceFlag is a repeatable flag whose values are never split, which
+	// can also be set from a list in the config file
+	rawStringSliceFlag struct {
+		*cli.GenericFlag
+		set *flag.FlagSet
+	}
+)
+
+// rawStringSlicePrefix marks a serialized rawStringSlice, which replaces the
+// values when set (e.g. when cli copies the values between a flag's aliases)
+const rawStringSlicePrefix = "raw:::"
+
+func (r *rawStringSlice) Set(value string) error {
+	if serialized, ok := strings.CutPrefix(value, rawStringSlicePrefix); ok {
+		return json.Unmarshal([]byte(serialized), &r.values)
+	}
+
+	r.values = append(r.values, value)
+	return nil
+}
+
+func (r *rawStringSlice) String() string {
+	return strings.Join(r.values, " ")
+}
+
+// Serialize returns the values in a form Set replaces them with, for cli.Serializer
+func (r *rawStringSlice) Serialize() string {
+	serialized, _ := json.Marshal(r.values)
+	return rawStringSlicePrefix + string(serialized)
+}
+
+// newRawStringSliceFlag returns a rawStringSliceFlag, whose values are read with rawStringSliceValues
+func newRawStringSliceFlag(name string, aliases []string, usage string) *rawStringSliceFlag {
+	return &rawStringSliceFlag{
+		GenericFlag: &cli.GenericFlag{
+			Name:    name,
+			Aliases: aliases,
+			Usage:   usage,
+			Value:   &rawStringSlice{},
+		},
+	}
+}
+
+// Apply adds the flag to set, keeping set so values can be applied from the
+// config file later
+func (f *rawStringSliceFlag) Apply(set *flag.FlagSet) error {
+	f.set = set
+	return f.GenericFlag.Apply(set)
+}
+
+// ApplyInputSourceValue sets the flag's values from a list in the config file,
+// unless the flag was given on the command line
+func (f *rawStringSliceFlag) ApplyInputSourceValue(cCtx *cli.Context, isc altsrc.InputSourceContext) error {
+	if f.set == nil || cCtx.IsSet(f.Name) {
+		return nil
+	}
+
+	for _, name := range f.Names() {
+		values, err := isc.StringSlice(name)
+		if err != nil {
+			return err
+		}
+
+		for _, value := range values {
+			err := f.set.Set(f.Name, value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// rawStringSliceValues returns the values of a rawStringSliceFlag
+func rawStringSliceValues(c *cli.Context, name string) []string {
+	values, ok := c.Generic(name).(*rawStringSlice)
+	if !ok {
+		return nil
+	}
+
+	return values.values
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+	"github.com/urfave/cli/v2/altsrc"
+)
+
+func TestRawStringSliceFlag(t *testing.T) {
+	tests := []struct {
+		name           string
+		args           []string
+		config         string
+		expectedValues []string
+	}{
+		{
+			name:           "Repeated on the command line",
+			args:           []string{"--global", "owners=alice,bob", "--global", " greeting = Hi "},
+			expectedValues: []string{"owners=alice,bob", " greeting = Hi "},
+		},
+		{
+			name:           "From the config file",
+			config:         "runner:\n  globals:\n    - owners=alice,bob\n    - env=production\n",
+			expectedValues: []string{"owners=alice,bob", "env=production"},
+		},
+		{
+			name:           "Command line overrides the config file",
+			args:           []string{"--global", "env=staging"},
+			config:         "runner:\n  globals:\n    - env=production\n",
+			expectedValues: []string{"env=staging"},
+		},
+		{
+			name:           "Not given",
+			expectedValues: nil,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			if tc.config != "" {
+				err := os.WriteFile(configPath, []byte(tc.config), 0o644)
+				require.NoError(t, err, "Test setup: Should write config file")
+			}
+
+			flags := []cli.Flag{
+				&cli.StringFlag{Name: configFlagName, Value: configPath},
+				newRawStringSliceFlag("global", []string{"runner.globals"}, "Globals"),
+			}
+
+			var values []string
+			app := &cli.App{
+				Flags: flags,
+				Before: func(c *cli.Context) error {
+					if tc.config == "" {
+						return nil
+					}
+
+					inputSource, err := altsrc.NewYamlSourceFromFile(configPath)
+					if err != nil {
+						return err
+					}
+					return altsrc.ApplyInputSourceValues(c, inputSource, flags)
+				},
+				Action: func(c *cli.Context) error {
+					values = rawStringSliceValues(c, "global")
+					return nil
+				},
+			}
+
+			err := app.Run(append([]string{"hops"}, tc.args...))
+			require.NoError(t, err, "App should run without error")
+			assert.Equal(t, tc.expectedValues, values)
+		})
+	}
+}
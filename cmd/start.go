@@ -2,6 +2,9 @@ package cmd
 
 import (
 	"context"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/urfave/cli/v2"
 	"github.com/urfave/cli/v2/altsrc"
@@ -38,13 +41,48 @@ func initStartCommand(commonFlags []cli.Flag) *cli.Command {
 		Before:      before,
 		Flags:       startFlags,
 		Action: func(c *cli.Context) error {
-			ctx := context.Background()
+			// Stop cleanly on interrupt, letting in-flight work complete
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
 			logger := logs.InitLogger(c.Bool("debug"))
 
 			hopsServer := &hops.HopsServer{
 				HTTPServerConf: hops.HTTPServerConf{
-					Address: c.String("address"),
-					Serve:   c.Bool("serve-console"),
+					AccessLog: hops.AccessLogConf{
+						SkipPaths:     c.StringSlice("access-log-skip-paths"),
+						SlowThreshold: c.Duration("slow-request-threshold"),
+					},
+					Address:    c.String("address"),
+					AuthTokens: c.StringSlice("auth-tokens"),
+					BasePath:   c.String("base-path"),
+					Console: hops.ConsoleConf{
+						Dir:   c.String("console-dir"),
+						Proxy: c.String("console-proxy"),
+					},
+					CORS: hops.CORSConf{
+						AllowAll:         c.Bool("cors-allow-all"),
+						AllowCredentials: c.Bool("cors-allow-credentials"),
+						AllowedHeaders:   c.StringSlice("cors-allowed-headers"),
+						AllowedMethods:   c.StringSlice("cors-allowed-methods"),
+						AllowedOrigins:   c.StringSlice("cors-allowed-origins"),
+					},
+					RateLimit: hops.RateLimitConf{
+						Rate:        c.Float64("rate-limit"),
+						Burst:       c.Int("rate-limit-burst"),
+						GlobalRate:  c.Float64("global-rate-limit"),
+						GlobalBurst: c.Int("global-rate-limit-burst"),
+					},
+					Replay: hops.ReplayConf{
+						AllowReplayOfReplays: c.Bool("allow-replay-of-replays"),
+					},
+					Serve:           c.Bool("serve-console"),
+					ShutdownTimeout: c.Duration("shutdown-timeout"),
+					TLS: hops.TLSConf{
+						CertFile:        c.String("tls-cert-file"),
+						KeyFile:         c.String("tls-key-file"),
+						RedirectAddress: c.String("tls-redirect-address"),
+					},
 				},
 				HopsPath: c.String("hops"),
 				HTTPAppConf: hops.HTTPAppConf{
@@ -55,14 +93,32 @@ func initStartCommand(commonFlags []cli.Flag) *cli.Command {
 					PortForward: c.Bool("portforward"),
 					Serve:       c.Bool("serve-k8sapp"),
 				},
-				KeyFilePath: c.String("keyfile"),
-				Logger:      logger,
-				ReplayEvent: c.String("replay-event"),
+				KeyFilePath:  c.String("keyfile"),
+				Logger:       logger,
+				ReplayEvent:  c.String("replay-event"),
+				ReplayFull:   c.Bool("replay-full"),
+				ReplayMode:   c.String("replay-mode"),
+				ReplayTiming: c.Bool("replay-timing"),
+				StreamName:   c.String("stream-name"),
 				RunnerConf: hops.RunnerConf{
-					Serve: c.Bool("serve-runner"),
-					Local: c.Bool("local"),
+					BundleCacheSize:     c.Int("bundle-cache-size"),
+					Concurrency:         c.Int("concurrency"),
+					DispatchTimeout:     c.Duration("dispatch-timeout"),
+					DryRun:              c.Bool("dry-run"),
+					DryRunShadowSubject: c.String("dry-run-shadow-subject"),
+					FailFast:            c.Bool("fail-fast"),
+					Globals:             rawStringSliceValues(c, "global"),
+					Serve:               c.Bool("serve-runner"),
+					Local:               c.Bool("local"),
+					LogInputs:           c.Bool("log-inputs"),
+					LogInputsLimit:      c.Int("log-inputs-limit"),
+					MaxEvaluations:      c.Int("max-evaluations"),
+					RedactKeys:          c.StringSlice("redact-keys"),
+					SequenceState:       c.Bool("sequence-state"),
+					SequenceTimeout:     c.Duration("sequence-timeout"),
 				},
-				Watch: c.Bool("watch"),
+				Watch:            c.Bool("watch"),
+				WebhookFunctions: c.Bool("webhook-functions"),
 			}
 
 			return hopsServer.Start(ctx)
@@ -72,6 +128,14 @@ func initStartCommand(commonFlags []cli.Flag) *cli.Command {
 
 func initStartFlags(commonFlags []cli.Flag) []cli.Flag {
 	startFlags := []cli.Flag{
+		altsrc.NewStringSliceFlag(
+			&cli.StringSliceFlag{
+				Name:    "access-log-skip-paths",
+				Aliases: []string{"console.access_log_skip_paths"},
+				Usage:   "Paths (and anything under them) left out of the access log, relative to the base path, e.g. frequently polled health checks",
+				Value:   cli.NewStringSlice("/health", "/healthz"),
+			},
+		),
 		altsrc.NewStringFlag(
 			&cli.StringFlag{
 				Name:    "address",
@@ -80,6 +144,142 @@ func initStartFlags(commonFlags []cli.Flag) []cli.Flag {
 				Value:   "127.0.0.1:8916",
 			},
 		),
+		altsrc.NewBoolFlag(
+			&cli.BoolFlag{
+				Name:    "allow-replay-of-replays",
+				Aliases: []string{"console.allow_replay_of_replays"},
+				Usage:   "Allow sequences that are themselves replays to be replayed through the API (default: rejected with 409 Conflict)",
+			},
+		),
+		altsrc.NewStringSliceFlag(
+			&cli.StringSliceFlag{
+				Name:    "auth-tokens",
+				Aliases: []string{"console.auth_tokens"},
+				Usage:   "Bearer tokens accepted by the tasks API. Give several to rotate tokens without downtime (default: no authentication)",
+				EnvVars: []string{"HOPS_AUTH_TOKENS"},
+			},
+		),
+		altsrc.NewStringFlag(
+			&cli.StringFlag{
+				Name:    "base-path",
+				Aliases: []string{"console.base_path"},
+				Usage:   "Path prefix to serve the console and APIs under, e.g. when behind a reverse proxy at a sub-path",
+			},
+		),
+		altsrc.NewIntFlag(
+			&cli.IntFlag{
+				Name:    "bundle-cache-size",
+				Aliases: []string{"runner.bundle_cache_size"},
+				Usage:   "Bytes of messages the runner caches for recent sequences, so only new messages are fetched as each arrives (default: not cached)",
+			},
+		),
+		altsrc.NewIntFlag(
+			&cli.IntFlag{
+				Name:    "concurrency",
+				Aliases: []string{"runner.concurrency"},
+				Usage:   "Number of sequences the runner processes at once. Keep processing time for this many well within the consumer's AckWait (default: one at a time)",
+			},
+		),
+		altsrc.NewStringFlag(
+			&cli.StringFlag{
+				Name:    "console-dir",
+				Aliases: []string{"console.dir"},
+				Usage:   "Directory to serve the console's UI from, e.g. a custom built UI (default: the UI built into hops)",
+			},
+		),
+		altsrc.NewStringFlag(
+			&cli.StringFlag{
+				Name:    "console-proxy",
+				Aliases: []string{"console.proxy"},
+				Usage:   "URL to reverse proxy the console's UI from, e.g. a dev server when working on the UI (default: the UI built into hops)",
+			},
+		),
+		altsrc.NewBoolFlag(
+			&cli.BoolFlag{
+				Name:    "cors-allow-all",
+				Aliases: []string{"console.cors_allow_all"},
+				Usage:   "Allow cross-origin requests from any origin. Convenient for local development, insecure when deployed",
+			},
+		),
+		altsrc.NewBoolFlag(
+			&cli.BoolFlag{
+				Name:    "cors-allow-credentials",
+				Aliases: []string{"console.cors_allow_credentials"},
+				Usage:   "Allow cross-origin requests to include credentials such as cookies. Ignored with --cors-allow-all",
+			},
+		),
+		altsrc.NewStringSliceFlag(
+			&cli.StringSliceFlag{
+				Name:    "cors-allowed-headers",
+				Aliases: []string{"console.cors_allowed_headers"},
+				Usage:   "Request headers cross-origin clients may send (default: Accept, Authorization, Content-Type, X-CSRF-Token)",
+			},
+		),
+		altsrc.NewStringSliceFlag(
+			&cli.StringSliceFlag{
+				Name:    "cors-allowed-methods",
+				Aliases: []string{"console.cors_allowed_methods"},
+				Usage:   "Methods cross-origin clients may use (default: GET, POST, PUT, DELETE, OPTIONS)",
+			},
+		),
+		altsrc.NewStringSliceFlag(
+			&cli.StringSliceFlag{
+				Name:    "cors-allowed-origins",
+				Aliases: []string{"console.cors_allowed_origins"},
+				Usage:   "Origins cross-origin requests are allowed from, each with up to one '*' wildcard e.g. https://*.example.com (default: localhost on any port)",
+			},
+		),
+		altsrc.NewDurationFlag(
+			&cli.DurationFlag{
+				Name:    "dispatch-timeout",
+				Aliases: []string{"runner.dispatch_timeout"},
+				Usage:   "How long the runner waits for each call to be dispatched before retrying",
+				Value:   hops.DefaultDispatchTimeout,
+			},
+		),
+		altsrc.NewBoolFlag(
+			&cli.BoolFlag{
+				Name:    "dry-run",
+				Aliases: []string{"runner.dry_run"},
+				Usage:   "Log the calls the runner would dispatch without publishing them. Calls depending on results never fire, as no results arrive",
+			},
+		),
+		altsrc.NewStringFlag(
+			&cli.StringFlag{
+				Name:    "dry-run-shadow-subject",
+				Aliases: []string{"runner.dry_run_shadow_subject"},
+				Usage:   "With --dry-run, also publish a record of each call the runner would dispatch to this subject, as SUBJECT.SEQUENCE_ID.CALL_SLUG",
+			},
+		),
+		altsrc.NewBoolFlag(
+			&cli.BoolFlag{
+				Name:    "fail-fast",
+				Aliases: []string{"runner.fail_fast"},
+				Usage:   "Stop evaluating a sequence's on blocks as soon as one fails, cancelling any still running, rather than evaluating them all",
+			},
+		),
+		// Values may contain commas, so the flag is repeated rather than split
+		newRawStringSliceFlag(
+			"global",
+			[]string{"runner.globals"},
+			"NAME=VALUE pair available to every on and call block as 'global.NAME', repeated for each global",
+		),
+		altsrc.NewFloat64Flag(
+			&cli.Float64Flag{
+				Name:    "global-rate-limit",
+				Aliases: []string{"console.global_rate_limit"},
+				Usage:   "Tasks per second that may be run by all clients together (0 disables the global limit)",
+				Value:   hops.DefaultGlobalRateLimit,
+			},
+		),
+		altsrc.NewIntFlag(
+			&cli.IntFlag{
+				Name:    "global-rate-limit-burst",
+				Aliases: []string{"console.global_rate_limit_burst"},
+				Usage:   "Tasks that may be run by all clients together at once, before being rate limited",
+				Value:   hops.DefaultGlobalRateLimitBurst,
+			},
+		),
 		altsrc.NewBoolFlag(
 			&cli.BoolFlag{
 				Name:    "local",
@@ -87,12 +287,113 @@ func initStartFlags(commonFlags []cli.Flag) []cli.Flag {
 				Usage:   "Start in local mode, creating a temporary stream of events and not handling new inbound requests from your connected apps",
 			},
 		),
+		altsrc.NewBoolFlag(
+			&cli.BoolFlag{
+				Name:    "log-inputs",
+				Aliases: []string{"runner.log_inputs"},
+				Usage:   "Log the inputs of dispatched calls at debug level, with --redact-keys and secrets redacted. Inputs may be large or sensitive, so are not logged by default",
+			},
+		),
+		altsrc.NewIntFlag(
+			&cli.IntFlag{
+				Name:    "log-inputs-limit",
+				Aliases: []string{"runner.log_inputs_limit"},
+				Usage:   "Size in bytes that inputs logged with --log-inputs are truncated to",
+				Value:   hops.DefaultInputLogLimit,
+			},
+		),
+		altsrc.NewIntFlag(
+			&cli.IntFlag{
+				Name:    "max-evaluations",
+				Aliases: []string{"runner.max_evaluations"},
+				Usage:   "Max number of sequences the runner evaluates at once, with any more waiting their turn (default: same as --concurrency)",
+			},
+		),
+		altsrc.NewFloat64Flag(
+			&cli.Float64Flag{
+				Name:    "rate-limit",
+				Aliases: []string{"console.rate_limit"},
+				Usage:   "Requests per second each client may make to the tasks API (0 disables rate limiting)",
+				Value:   hops.DefaultRateLimit,
+			},
+		),
+		altsrc.NewIntFlag(
+			&cli.IntFlag{
+				Name:    "rate-limit-burst",
+				Aliases: []string{"console.rate_limit_burst"},
+				Usage:   "Requests each client may make to the tasks API at once, before being rate limited",
+				Value:   hops.DefaultRateLimitBurst,
+			},
+		),
+		altsrc.NewStringSliceFlag(
+			&cli.StringSliceFlag{
+				Name:    "redact-keys",
+				Aliases: []string{"runner.redact_keys"},
+				Usage:   "Keys whose values are redacted from logged message content and sequences served by the API (default: authorization, password, token)",
+			},
+		),
 		altsrc.NewStringFlag(
 			&cli.StringFlag{
 				Name:  "replay-event",
 				Usage: "Replay a specific source event against current hops configs. Takes a source event ID",
 			},
 		),
+		altsrc.NewBoolFlag(
+			&cli.BoolFlag{
+				Name:  "replay-full",
+				Usage: "Replay every message in the sequence of --replay-event in order, rather than just the source event",
+			},
+		),
+		altsrc.NewStringFlag(
+			&cli.StringFlag{
+				Name:  "replay-mode",
+				Usage: "How --replay-event is evaluated. 'full' dispatches calls as normal, 'evaluate' only records the calls that would be dispatched",
+				Value: "full",
+			},
+		),
+		altsrc.NewBoolFlag(
+			&cli.BoolFlag{
+				Name:  "replay-timing",
+				Usage: "When used with --replay-full, space replayed messages by their original timing",
+			},
+		),
+		altsrc.NewBoolFlag(
+			&cli.BoolFlag{
+				Name:    "sequence-state",
+				Aliases: []string{"runner.sequence_state"},
+				Usage:   "Record each sequence's dispatched calls and completion in KV, so restarted runners don't re-evaluate from scratch",
+			},
+		),
+		altsrc.NewDurationFlag(
+			&cli.DurationFlag{
+				Name:    "sequence-timeout",
+				Aliases: []string{"runner.sequence_timeout"},
+				Usage:   "How long after its event each on block may run for, unless it sets its own 'timeout' (default: no timeout)",
+			},
+		),
+		altsrc.NewDurationFlag(
+			&cli.DurationFlag{
+				Name:    "shutdown-timeout",
+				Aliases: []string{"console.shutdown_timeout"},
+				Usage:   "How long in-flight console/API requests are given to complete when stopping",
+				Value:   hops.DefaultShutdownTimeout,
+			},
+		),
+		altsrc.NewDurationFlag(
+			&cli.DurationFlag{
+				Name:    "slow-request-threshold",
+				Aliases: []string{"console.slow_request_threshold"},
+				Usage:   "Duration beyond which console/API requests are logged at warn level (0 never does)",
+				Value:   logs.DefaultSlowRequestThreshold,
+			},
+		),
+		altsrc.NewStringFlag(
+			&cli.StringFlag{
+				Name:    "stream-name",
+				Aliases: []string{"nats.stream_name"},
+				Usage:   "Name of the JetStream stream holding the account's messages, which may be shared with other accounts (default: the account ID)",
+			},
+		),
 		altsrc.NewBoolFlag(
 			&cli.BoolFlag{
 				Name:     "serve-console",
@@ -146,6 +447,29 @@ func initStartFlags(commonFlags []cli.Flag) []cli.Flag {
 				Category: k8sCategory,
 			},
 		),
+		altsrc.NewStringFlag(
+			&cli.StringFlag{
+				Name:    "tls-cert-file",
+				Aliases: []string{"console.tls_cert_file"},
+				Usage:   "Path to a TLS certificate to serve the console/API over HTTPS with. Reloaded whenever it changes",
+				Action:  expandHomePath("tls-cert-file"),
+			},
+		),
+		altsrc.NewStringFlag(
+			&cli.StringFlag{
+				Name:    "tls-key-file",
+				Aliases: []string{"console.tls_key_file"},
+				Usage:   "Path to the private key of --tls-cert-file",
+				Action:  expandHomePath("tls-key-file"),
+			},
+		),
+		altsrc.NewStringFlag(
+			&cli.StringFlag{
+				Name:    "tls-redirect-address",
+				Aliases: []string{"console.tls_redirect_address"},
+				Usage:   "Address to redirect HTTP requests to HTTPS from, when serving over TLS (e.g. 0.0.0.0:80)",
+			},
+		),
 		altsrc.NewBoolFlag(
 			&cli.BoolFlag{
 				Name:  "watch",
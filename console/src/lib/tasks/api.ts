@@ -7,6 +7,12 @@ export interface Task {
 	params: Param[];
 }
 
+export interface TaskList {
+	tasks: Task[];
+	total: number;
+	next_cursor?: string;
+}
+
 export interface TaskSummary {
 	display_name: string;
 	name: string;
@@ -45,7 +51,20 @@ export interface NumberParam extends BaseParam {
 export type Param = StrParam | TextParam | BoolParam | NumberParam;
 
 export interface TaskRunResponse {
-	errors: { [key: string]: string[] };
 	message: string;
 	sequence_id: string;
 }
+
+export interface ErrorDetail {
+	field?: string;
+	message: string;
+}
+
+export interface ErrorResponse {
+	error: {
+		code: string;
+		message: string;
+		details: ErrorDetail[];
+		request_id?: string;
+	};
+}
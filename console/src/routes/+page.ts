@@ -2,12 +2,28 @@ import type { PageLoad } from './$types';
 import { PUBLIC_BACKEND_URL } from '$env/static/public';
 import ky from 'ky';
 
+import type { Task, TaskList } from '$lib/tasks/api';
+
+// Matches TaskListLimit, the largest page the backend serves
+const taskPageSize = 100;
+
 export const load: PageLoad = async () => {
-	let tasks: unknown;
+	let tasks: Task[] = [];
 
 	try {
-		tasks = await ky.get(`${PUBLIC_BACKEND_URL}/tasks`).json();
-		tasks ??= [];
+		let cursor: string | undefined;
+		do {
+			const searchParams: Record<string, string | number> = { limit: taskPageSize };
+			if (cursor) {
+				searchParams.cursor = cursor;
+			}
+
+			const taskList: TaskList = await ky
+				.get(`${PUBLIC_BACKEND_URL}/tasks`, { searchParams })
+				.json();
+			tasks.push(...(taskList.tasks ?? []));
+			cursor = taskList.next_cursor;
+		} while (cursor);
 	} catch (error) {
 		// TODO: Would be better to let users know something went wrong,
 		//       rather than just show empty
@@ -1,21 +1,20 @@
 import { error } from '@sveltejs/kit';
 import type { PageLoad } from './$types';
 import { PUBLIC_BACKEND_URL } from '$env/static/public';
-import ky from 'ky';
+import ky, { HTTPError } from 'ky';
 
 import type { Task } from '$lib/tasks/api';
 
-// TODO: Create an endpoint for fetching a single task entry
-//       on the backend and use that instead.
 export const load: PageLoad = async ({ params }) => {
-	const tasks: Task[] = await ky.get(`${PUBLIC_BACKEND_URL}/tasks`).json();
+	let task: Task;
 
-	const task = tasks.find((task) => {
-		return task.name === params.task_name;
-	});
-
-	if (task === undefined) {
-		throw error(404, 'Task not found');
+	try {
+		task = await ky.get(`${PUBLIC_BACKEND_URL}/tasks/${encodeURIComponent(params.task_name)}`).json();
+	} catch (err) {
+		if (err instanceof HTTPError && err.response.status === 404) {
+			throw error(404, 'Task not found');
+		}
+		throw err;
 	}
 
 	return { task: task };
@@ -0,0 +1,207 @@
+package dsl
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	"github.com/zclconf/go-cty/cty/gocty"
+)
+
+// CallsVar is the variable dependent calls reference their prerequisites'
+// results by, e.g. `calls.first.output`
+const CallsVar = "calls"
+
+// callDependencies returns the results of a call's prerequisites, keyed by call
+// name, once every one has completed
+//
+// ready is false whilst any prerequisite has no result yet. failed is the name
+// of a prerequisite that errored or was skipped (i.e. is disabled, its if wasn't
+// met or it depends on a failed call), if any, as its dependents can never run.
+func callDependencies(evalctx *hcl.EvalContext, dependsOn []string, skipped []CallAST) (results map[string]cty.Value, ready bool, failed string) {
+	results = map[string]cty.Value{}
+
+	for _, name := range dependsOn {
+		for _, call := range skipped {
+			if call.Name == name {
+				return nil, false, name
+			}
+		}
+
+		result, ok := evalctx.Variables[name]
+		if !ok || !result.Type().IsObjectType() {
+			return nil, false, ""
+		}
+
+		errored := valueAtPath(result, "errored")
+		if !errored.IsNull() && errored.Type() == cty.Bool && errored.True() {
+			return nil, false, name
+		}
+
+		completed := valueAtPath(result, "completed")
+		if completed.IsNull() || completed.Type() != cty.Bool || !completed.True() {
+			return nil, false, ""
+		}
+
+		results[name] = result
+	}
+
+	return results, true, ""
+}
+
+// decodeDependsOnAttr decodes the names of the calls a call depends on, e.g.
+// `depends_on = ["first"]`
+func decodeDependsOnAttr(attr *hcl.Attribute) ([]string, error) {
+	if attr == nil {
+		return nil, nil
+	}
+
+	val, d := attr.Expr.Value(nil)
+	if d.HasErrors() {
+		return nil, ParseError{Diagnostics: d}
+	}
+
+	val, err := convert.Convert(val, cty.List(cty.String))
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a list of call names: %w", DependsOnAttr, err)
+	}
+
+	var dependsOn []string
+	err = gocty.FromCtyValue(val, &dependsOn)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a list of call names: %w", DependsOnAttr, err)
+	}
+
+	return dependsOn, nil
+}
+
+// validateCallDependencies checks the depends_on of every call in a block names
+// another call in the block without forming a cycle, and that calls only
+// reference the results of calls they depend on via `calls`
+//
+// Dependencies are what hold a call back until its prerequisites complete, so
+// requiring them keeps the order calls are dispatched in explicit.
+//
+// Returns the indexes of the blocks in the order they should be decoded, which
+// is their own besides calls coming after those they depend on, so whether a
+// prerequisite was skipped is known when its dependents are decoded.
+func validateCallDependencies(callBlocks hcl.Blocks) ([]int, error) {
+	names := make([]string, len(callBlocks))
+	dependencies := map[string][]string{}
+
+	for idx, callBlock := range callBlocks {
+		call, bc, err := decodeCallDeclaration(callBlock, idx, "")
+		if err != nil {
+			return nil, err
+		}
+		name := call.Name
+		names[idx] = name
+
+		dependsOn, err := decodeDependsOnAttr(bc.Attributes[DependsOnAttr])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s for call '%s': %w", DependsOnAttr, name, err)
+		}
+		dependencies[name] = dependsOn
+
+		traversals := callInputsVariables(bc)
+		if ifAttr := bc.Attributes[IfAttr]; ifAttr != nil {
+			traversals = append(traversals, ifAttr.Expr.Variables()...)
+		}
+
+		err = validateCallsReferences(name, traversals, dependsOn)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range names {
+		for _, dependency := range dependencies[name] {
+			if dependency == name {
+				return nil, fmt.Errorf("Call '%s' can't depend on itself", name)
+			}
+			if _, ok := dependencies[dependency]; !ok {
+				return nil, fmt.Errorf("Call '%s' depends on unknown call '%s'", name, dependency)
+			}
+		}
+	}
+
+	// Calls in a cycle would wait on each other forever
+	visiting := map[string]bool{}
+	visited := map[string]bool{}
+	order := []int{}
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		if visited[name] {
+			return nil
+		}
+		if visiting[name] {
+			return fmt.Errorf("Calls depend on each other in a cycle, including call '%s'", name)
+		}
+
+		visiting[name] = true
+		for _, dependency := range dependencies[name] {
+			err := visit(dependency)
+			if err != nil {
+				return err
+			}
+		}
+		visiting[name] = false
+		visited[name] = true
+
+		for idx, blockName := range names {
+			if blockName == name {
+				order = append(order, idx)
+			}
+		}
+
+		return nil
+	}
+
+	for _, name := range names {
+		err := visit(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
+// validateCallsReferences checks every `calls.<name>` reference in traversals
+// is to a call in dependsOn
+func validateCallsReferences(callName string, traversals []hcl.Traversal, dependsOn []string) error {
+	for _, traversal := range traversals {
+		if traversal.RootName() != CallsVar {
+			continue
+		}
+
+		rel := traversal.SimpleSplit().Rel
+		if len(rel) == 0 {
+			return fmt.Errorf("Call '%s' must reference a call by name, e.g. %s.call_name.output", callName, CallsVar)
+		}
+
+		attrStep, ok := rel[0].(hcl.TraverseAttr)
+		if !ok {
+			return fmt.Errorf("Call '%s' must reference a call by name, e.g. %s.call_name.output", callName, CallsVar)
+		}
+
+		found := false
+		for _, dependency := range dependsOn {
+			if dependency == attrStep.Name {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return fmt.Errorf(
+				"Call '%s' references %s.%s without depending on it, add \"%s\" to its %s",
+				callName, CallsVar, attrStep.Name, attrStep.Name, DependsOnAttr,
+			)
+		}
+	}
+
+	return nil
+}
@@ -14,7 +14,7 @@ func DecodeDoneBlock(ctx context.Context, hop *HopAST, on *OnAST, block *hcl.Blo
 
 	bc, d := block.Body.Content(doneSchema)
 	if d.HasErrors() {
-		return done, errors.New(d.Error())
+		return done, ParseError{Diagnostics: d}
 	}
 
 	errorVal, err := decodeErrorAttr(bc.Attributes[ErrorAttr], evalctx, logger)
@@ -37,3 +37,22 @@ func Env(envVarName, defaultValue cty.Value) (cty.Value, error) {
 
 	return ctyVal, nil
 }
+
+// envDefaultFunc has the signature of EnvFunc, but always returns the default
+// value rather than reading the environment
+var envDefaultFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{
+			Name: "envVarName",
+			Type: cty.String,
+		},
+		{
+			Name: "defaultValue",
+			Type: cty.String,
+		},
+	},
+	Type: function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		return args[1], nil
+	},
+})
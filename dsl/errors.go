@@ -0,0 +1,19 @@
+package dsl
+
+import (
+	"github.com/hashicorp/hcl/v2"
+)
+
+// ParseError is returned when hops files can't be read or decoded, keeping the
+// HCL diagnostics so callers can report the file, position and severity of
+// each problem, e.g. to an editor
+//
+// Retrieve it from returned errors with errors.As.
+type ParseError struct {
+	Diagnostics hcl.Diagnostics
+}
+
+// Error returns the diagnostics as HCL formats them
+func (p ParseError) Error() string {
+	return p.Diagnostics.Error()
+}
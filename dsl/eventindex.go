@@ -0,0 +1,63 @@
+package dsl
+
+import (
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+)
+
+// EventIndex records the events that a hops config's on blocks could match,
+// so events that can't match any needn't be parsed
+//
+// The index errs on the side of matching: anything it can't be sure of, such as
+// a malformed on block, matches every event. A nil index matches every event.
+type EventIndex struct {
+	actions   map[string]map[string]bool
+	anyAction map[string]bool
+	matchAll  bool
+}
+
+// NewEventIndex indexes the event types and actions of the on blocks in a
+// hops config's body
+func NewEventIndex(content *hcl.BodyContent) *EventIndex {
+	index := &EventIndex{
+		actions:   map[string]map[string]bool{},
+		anyAction: map[string]bool{},
+	}
+
+	if content == nil {
+		index.matchAll = true
+		return index
+	}
+
+	for _, block := range content.Blocks.OfType(OnID) {
+		if len(block.Labels) == 0 || ValidateLabels(block.Labels[0]) != nil {
+			index.matchAll = true
+			return index
+		}
+
+		// Matches the event and action as DecodeOnBlock does
+		eventType, action, hasAction := strings.Cut(block.Labels[0], "_")
+		if !hasAction {
+			index.anyAction[eventType] = true
+			continue
+		}
+
+		if index.actions[eventType] == nil {
+			index.actions[eventType] = map[string]bool{}
+		}
+		index.actions[eventType][action] = true
+	}
+
+	return index
+}
+
+// Matches returns whether any on block could match an event of the given type
+// and action. Events without a type match, so that parsing reports them.
+func (e *EventIndex) Matches(eventType string, action string) bool {
+	if e == nil || e.matchAll || eventType == "" {
+		return true
+	}
+
+	return e.anyAction[eventType] || e.actions[eventType][action]
+}
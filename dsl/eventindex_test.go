@@ -0,0 +1,115 @@
+package dsl
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hiphops-io/hops/logs"
+)
+
+func TestEventIndex(t *testing.T) {
+	content := `on change {
+  call app_handler {}
+}
+
+on pullrequest_opened {
+  call app_handler {}
+}
+
+on pullrequest_closed {
+  call app_handler {}
+}
+
+task deploy {}
+`
+	index := readTestHops(t, content).EventIndex
+
+	tests := []struct {
+		name        string
+		eventType   string
+		action      string
+		expectMatch bool
+	}{
+		{
+			name:        "Event type without action matches any action",
+			eventType:   "change",
+			action:      "merged",
+			expectMatch: true,
+		},
+		{
+			name:        "Event type without action matches no action",
+			eventType:   "change",
+			expectMatch: true,
+		},
+		{
+			name:        "Event type and action",
+			eventType:   "pullrequest",
+			action:      "closed",
+			expectMatch: true,
+		},
+		{
+			name:        "Event type with unmatched action",
+			eventType:   "pullrequest",
+			action:      "edited",
+			expectMatch: false,
+		},
+		{
+			name:        "Unmatched event type",
+			eventType:   "heartbeat",
+			expectMatch: false,
+		},
+		{
+			name:        "Event type matching a block's full label",
+			eventType:   "pullrequest_opened",
+			expectMatch: false,
+		},
+		{
+			name:        "Missing event type is parsed to report it",
+			expectMatch: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectMatch, index.Matches(tc.eventType, tc.action))
+		})
+	}
+
+	var nilIndex *EventIndex
+	assert.True(t, nilIndex.Matches("heartbeat", ""), "Nil index should match every event")
+	assert.True(t, NewEventIndex(nil).Matches("heartbeat", ""), "Index without content should match every event")
+}
+
+// BenchmarkNonMatchingEvent compares the per-message cost of finding that no on
+// block matches an event by parsing (as before the index) and with the index
+func BenchmarkNonMatchingEvent(b *testing.B) {
+	logger := logs.NoOpLogger()
+	ctx := context.Background()
+
+	hops, err := ReadHopsFilePath("./testdata/valid")
+	require.NoError(b, err, "Test setup: Should read hops files")
+
+	eventBundle := map[string][]byte{
+		"event": []byte(`{"hops": {"source": "hiphops", "event": "heartbeat", "action": "ping"}}`),
+	}
+
+	b.Run("ParseHops", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			hop, err := ParseHops(ctx, hops, eventBundle, nil, logger)
+			if err != nil || len(hop.Ons) != 0 {
+				b.Fatal("Event should parse without matching any on block")
+			}
+		}
+	})
+
+	b.Run("EventIndex", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if hops.EventIndex.Matches("heartbeat", "ping") {
+				b.Fatal("Event should not match any on block")
+			}
+		}
+	})
+}
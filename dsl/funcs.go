@@ -1,12 +1,17 @@
 package dsl
 
 import (
+	"fmt"
+	"sync"
+
 	"github.com/hashicorp/hcl/v2/ext/tryfunc"
 	"github.com/zclconf/go-cty/cty"
 	"github.com/zclconf/go-cty/cty/function"
 	"github.com/zclconf/go-cty/cty/function/stdlib"
 )
 
+var registerMu sync.Mutex
+
 // StatelessFunctions can be instantiated once
 // TODO: Add encode/decode b64
 var StatelessFunctions = map[string]function.Function{
@@ -38,6 +43,7 @@ var StatelessFunctions = map[string]function.Function{
 	"lookup":          stdlib.LookupFunc,
 	"lower":           stdlib.LowerFunc,
 	"max":             stdlib.MaxFunc,
+	"md5":             Md5Func,
 	"merge":           stdlib.MergeFunc,
 	"min":             stdlib.MinFunc,
 	"range":           stdlib.RangeFunc,
@@ -48,12 +54,16 @@ var StatelessFunctions = map[string]function.Function{
 	"setintersection": stdlib.SetIntersectionFunc,
 	"setproduct":      stdlib.SetProductFunc,
 	"setunion":        stdlib.SetUnionFunc,
+	"sha1":            Sha1Func,
+	"sha256":          Sha256Func,
 	"slice":           stdlib.SliceFunc,
 	"sort":            stdlib.SortFunc,
 	"split":           stdlib.SplitFunc,
 	"strlen":          stdlib.StrlenFunc,
 	"substr":          stdlib.SubstrFunc,
 	"timeadd":         stdlib.TimeAddFunc,
+	"timecmp":         TimeCmpFunc,
+	"timezone":        TimezoneFunc,
 	"title":           stdlib.TitleFunc,
 	"tobool":          stdlib.MakeToFunc(cty.Bool),
 	"tolist":          stdlib.MakeToFunc(cty.List(cty.DynamicPseudoType)),
@@ -67,6 +77,8 @@ var StatelessFunctions = map[string]function.Function{
 	"trimsuffix":      stdlib.TrimSuffixFunc,
 	"try":             tryfunc.TryFunc,
 	"upper":           stdlib.UpperFunc,
+	"unixtime":        UnixTimeFunc,
+	"uuid5":           Uuid5Func,
 	"values":          stdlib.ValuesFunc,
 	"xglob":           ExclusiveGlobFunc,
 	"zipmap":          stdlib.ZipmapFunc,
@@ -87,3 +99,26 @@ func StatefulFunctions(hops *HopsFiles, hopsDirectory string) map[string]functio
 
 	return statefulFunctions
 }
+
+// RegisterFunctions makes optional functions (e.g. WebhookFunctions) available
+// to all hops configs
+//
+// Must be called before any hops configs are parsed. Returns an error without
+// registering anything if a function of the same name is already available.
+func RegisterFunctions(funcs map[string]function.Function) error {
+	registerMu.Lock()
+	defer registerMu.Unlock()
+
+	for name, fn := range funcs {
+		existing, ok := StatelessFunctions[name]
+		if ok && existing != fn {
+			return fmt.Errorf("Unable to register function '%s', a function with that name already exists", name)
+		}
+	}
+
+	for name, fn := range funcs {
+		StatelessFunctions[name] = fn
+	}
+
+	return nil
+}
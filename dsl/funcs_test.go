@@ -0,0 +1,88 @@
+package dsl
+
+import (
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestJSONFuncs(t *testing.T) {
+	// Webhook payloads often carry JSON serialised into a string field
+	event := cty.ObjectVal(map[string]cty.Value{
+		"payload":   cty.StringVal(`{"deploy":{"env":"prod","regions":["eu","us"],"replicas":3,"canary":true}}`),
+		"malformed": cty.StringVal(`{"deploy":`),
+	})
+
+	tests := []struct {
+		name      string
+		expr      string
+		expected  cty.Value
+		expectErr bool
+	}{
+		{
+			name:     "Decode nested field",
+			expr:     `jsondecode(event.payload).deploy.env`,
+			expected: cty.StringVal("prod"),
+		},
+		{
+			name:     "Decode nested list",
+			expr:     `jsondecode(event.payload).deploy.regions[1]`,
+			expected: cty.StringVal("us"),
+		},
+		{
+			name:     "Condition on decoded values",
+			expr:     `jsondecode(event.payload).deploy.canary && jsondecode(event.payload).deploy.replicas > 2`,
+			expected: cty.True,
+		},
+		{
+			name:     "Encode nested structure",
+			expr:     `jsonencode({a = {b = [1, "two", true]}, c = null})`,
+			expected: cty.StringVal(`{"a":{"b":[1,"two",true]},"c":null}`),
+		},
+		{
+			name:     "Round trip",
+			expr:     `jsonencode(jsondecode(event.payload))`,
+			expected: cty.StringVal(`{"deploy":{"canary":true,"env":"prod","regions":["eu","us"],"replicas":3}}`),
+		},
+		{
+			name:      "Decode malformed JSON",
+			expr:      `jsondecode(event.malformed)`,
+			expectErr: true,
+		},
+		{
+			name:      "Decode a non-string",
+			expr:      `jsondecode({a = 1})`,
+			expectErr: true,
+		},
+		{
+			name:     "Fall back from malformed JSON",
+			expr:     `try(jsondecode(event.malformed).deploy.env, "none")`,
+			expected: cty.StringVal("none"),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			evalctx := &hcl.EvalContext{
+				Functions: StatelessFunctions,
+				Variables: map[string]cty.Value{"event": event},
+			}
+
+			expr, d := hclsyntax.ParseExpression([]byte(tc.expr), "test.hops", hcl.InitialPos)
+			require.False(t, d.HasErrors(), d.Error())
+
+			val, d := expr.Value(evalctx)
+			if tc.expectErr {
+				assert.True(t, d.HasErrors(), "Expression should error")
+				return
+			}
+
+			require.False(t, d.HasErrors(), d.Error())
+			assert.True(t, tc.expected.RawEquals(val), "Expected %#v, got %#v", tc.expected, val)
+		})
+	}
+}
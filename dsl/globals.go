@@ -0,0 +1,32 @@
+package dsl
+
+import (
+	"context"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+// GlobalVar is the namespace global variables are referenced under, e.g. `global.region`
+const GlobalVar = "global"
+
+type globalsCtxKey struct{}
+
+// ContextWithGlobals returns a copy of ctx in which hops configs are parsed with
+// globals available to every block as `global.<name>`
+//
+// Globals are for account level config (e.g. a default region), so take
+// precedence over any event bundle or scoped variable also named global.
+func ContextWithGlobals(ctx context.Context, globals map[string]cty.Value) context.Context {
+	return context.WithValue(ctx, globalsCtxKey{}, globals)
+}
+
+// globalsFromContext returns the globals set with ContextWithGlobals as an
+// object, or false if there are none
+func globalsFromContext(ctx context.Context) (cty.Value, bool) {
+	globals, ok := ctx.Value(globalsCtxKey{}).(map[string]cty.Value)
+	if !ok || globals == nil {
+		return cty.NilVal, false
+	}
+
+	return cty.ObjectVal(globals), true
+}
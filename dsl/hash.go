@@ -0,0 +1,91 @@
+package dsl
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+)
+
+// Md5Func is a cty.Function that returns the hex encoded MD5 hash of a string
+var Md5Func = hashFunc(md5.New)
+
+// Sha1Func is a cty.Function that returns the hex encoded SHA1 hash of a string
+var Sha1Func = hashFunc(sha1.New)
+
+// Sha256Func is a cty.Function that returns the hex encoded SHA256 hash of a string
+var Sha256Func = hashFunc(sha256.New)
+
+// Uuid5Func is a cty.Function that returns a name based (version 5) UUID
+//
+// The namespace is either a UUID or one of the well known namespaces "dns",
+// "url", "oid" or "x500". The same namespace and name always give the same UUID,
+// making it suitable for deriving stable identifiers from event fields.
+var Uuid5Func = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{
+			Name: "namespace",
+			Type: cty.String,
+		},
+		{
+			Name: "name",
+			Type: cty.String,
+		},
+	},
+	Type: function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		return Uuid5(args[0], args[1])
+	},
+})
+
+func Uuid5(namespace, name cty.Value) (cty.Value, error) {
+	var space uuid.UUID
+
+	switch strings.ToLower(namespace.AsString()) {
+	case "dns":
+		space = uuid.NameSpaceDNS
+	case "url":
+		space = uuid.NameSpaceURL
+	case "oid":
+		space = uuid.NameSpaceOID
+	case "x500":
+		space = uuid.NameSpaceX500
+	default:
+		var err error
+		space, err = uuid.Parse(namespace.AsString())
+		if err != nil {
+			return cty.UnknownVal(cty.String), fmt.Errorf("Invalid namespace '%s', must be a UUID or one of dns, url, oid, x500", namespace.AsString())
+		}
+	}
+
+	id := uuid.NewSHA1(space, []byte(name.AsString()))
+
+	return cty.StringVal(id.String()), nil
+}
+
+// hashFunc creates a cty.Function returning the hex encoded hash of a string
+// using the given hash implementation
+func hashFunc(newHash func() hash.Hash) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{
+			{
+				Name: "str",
+				Type: cty.String,
+			},
+		},
+		Type: function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			h := newHash()
+			h.Write([]byte(args[0].AsString()))
+
+			return cty.StringVal(hex.EncodeToString(h.Sum(nil))), nil
+		},
+	})
+}
@@ -0,0 +1,138 @@
+package dsl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+)
+
+// Expected values are fixed, so any change in output across platforms or
+// versions (which would break workflows relying on stable ids) fails the tests
+func TestHashFuncs(t *testing.T) {
+	tests := []struct {
+		name     string
+		fn       function.Function
+		input    string
+		expected string
+	}{
+		{
+			name:     "md5",
+			fn:       Md5Func,
+			input:    "hello",
+			expected: "5d41402abc4b2a76b9719d911017c592",
+		},
+		{
+			name:     "md5 unicode",
+			fn:       Md5Func,
+			input:    "héllo 👋",
+			expected: "ff1e9a243972ae4915e6a59f86f8b748",
+		},
+		{
+			name:     "md5 empty",
+			fn:       Md5Func,
+			input:    "",
+			expected: "d41d8cd98f00b204e9800998ecf8427e",
+		},
+		{
+			name:     "sha1",
+			fn:       Sha1Func,
+			input:    "hello",
+			expected: "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d",
+		},
+		{
+			name:     "sha1 unicode",
+			fn:       Sha1Func,
+			input:    "héllo 👋",
+			expected: "e5fa5e7125f7410648e158f929daa508f499302c",
+		},
+		{
+			name:     "sha256",
+			fn:       Sha256Func,
+			input:    "hello",
+			expected: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		},
+		{
+			name:     "sha256 unicode",
+			fn:       Sha256Func,
+			input:    "héllo 👋",
+			expected: "241bff4036211b66e25dc44c43c7305feb99e7a62b953f03c3597ad0593c508f",
+		},
+		{
+			name:     "sha256 empty",
+			fn:       Sha256Func,
+			input:    "",
+			expected: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.fn.Call([]cty.Value{cty.StringVal(tc.input)})
+			require.NoError(t, err)
+			assert.Equal(t, cty.StringVal(tc.expected), got)
+		})
+	}
+}
+
+func TestUuid5(t *testing.T) {
+	tests := []struct {
+		name      string
+		namespace string
+		input     string
+		expected  string
+		expectErr bool
+	}{
+		{
+			name:      "Well known namespace",
+			namespace: "dns",
+			input:     "www.example.com",
+			expected:  "2ed6657d-e927-568b-95e1-2665a8aea6a2",
+		},
+		{
+			name:      "Well known namespace is case insensitive",
+			namespace: "DNS",
+			input:     "www.example.com",
+			expected:  "2ed6657d-e927-568b-95e1-2665a8aea6a2",
+		},
+		{
+			name:      "Well known namespace as UUID",
+			namespace: "6ba7b811-9dad-11d1-80b4-00c04fd430c8",
+			input:     "https://github.com/hiphops-io/hops",
+			expected:  "d3797a7f-581b-5db3-95fa-976cc7dd5b43",
+		},
+		{
+			name:      "url namespace",
+			namespace: "url",
+			input:     "https://github.com/hiphops-io/hops",
+			expected:  "d3797a7f-581b-5db3-95fa-976cc7dd5b43",
+		},
+		{
+			name:      "Custom namespace",
+			namespace: "0395b0b2-0dcd-4dfb-89f8-65a36d32d9f3",
+			input:     "hiphops-io/hops:abc123",
+			expected:  "d87fa466-7f92-59f8-9ed5-fc1a7327ff5b",
+		},
+		{
+			name:      "Invalid namespace",
+			namespace: "not-a-namespace",
+			input:     "hiphops-io/hops:abc123",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Uuid5Func.Call([]cty.Value{cty.StringVal(tc.namespace), cty.StringVal(tc.input)})
+			if tc.expectErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, cty.StringVal(tc.expected), got)
+		})
+	}
+}
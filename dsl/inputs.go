@@ -0,0 +1,218 @@
+package dsl
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/manterfield/fast-ctyjson/ctyjson"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// validateCallInputs checks a call gives its inputs in at most one form, either
+// an inputs attribute or a single inputs block
+//
+// Inputs attributes that don't reference any variables are evaluated, so
+// inputs that aren't objects are caught without an event (see checkInputsValue).
+func validateCallInputs(block *hcl.Block, bc *hcl.BodyContent) error {
+	inputsBlocks := bc.Blocks.OfType(InputsID)
+	attr := bc.Attributes[InputsAttr]
+
+	if attr != nil && len(inputsBlocks) > 0 {
+		return fmt.Errorf("Call %s can't have both an '%s' attribute and block", block.DefRange.String(), InputsAttr)
+	}
+	if len(inputsBlocks) > 1 {
+		return fmt.Errorf("Only one '%s' block is allowed per call: %s", InputsID, inputsBlocks[1].DefRange.String())
+	}
+
+	if attr == nil || len(attr.Expr.Variables()) > 0 {
+		return nil
+	}
+
+	// Anything that can't be evaluated yet is left to be reported once it is
+	evalctx := &hcl.EvalContext{Functions: StatelessFunctions}
+	val, d := attr.Expr.Value(evalctx)
+	if d.HasErrors() {
+		return nil
+	}
+	scalar, err := DecodeConditionalAttr(bc.Attributes[ScalarInputsAttr], false, evalctx)
+	if err != nil {
+		return nil
+	}
+
+	return checkInputsValue(attr, val, scalar)
+}
+
+// checkInputsValue returns an error if the value of an inputs attribute isn't an
+// object (or null), unless scalar inputs are allowed
+//
+// Handlers almost always expect an object of named inputs, so anything else is
+// usually a mistake (e.g. `inputs = "text"`) that would otherwise only surface
+// when the handler runs.
+func checkInputsValue(attr *hcl.Attribute, val cty.Value, scalar bool) error {
+	if scalar || val.IsNull() {
+		return nil
+	}
+
+	ty := val.Type()
+	if ty.IsObjectType() || ty.IsMapType() || ty.Equals(cty.DynamicPseudoType) {
+		return nil
+	}
+
+	return ParseError{Diagnostics: hcl.Diagnostics{{
+		Severity: hcl.DiagError,
+		Summary:  "Inputs must be an object",
+		Detail: fmt.Sprintf(
+			"Handlers expect inputs as an object of named values, e.g. %s = { text = \"Hi\" }, not a %s. Set %s = true on the call if its handler expects other JSON values.",
+			InputsAttr, ty.FriendlyName(), ScalarInputsAttr,
+		),
+		Subject: attr.Expr.Range().Ptr(),
+	}}}
+}
+
+// decodeCallInputs evaluates the inputs of a call as JSON, returning nil if it
+// has none
+//
+// Inputs given as a block are decoded as an object of the block's attributes,
+// with nested blocks as nested objects, e.g. these are identical:
+//
+//	inputs = { channel = "#ops", message = { text = "Hi" } }
+//
+//	inputs {
+//	  channel = "#ops"
+//	  message {
+//	    text = "Hi"
+//	  }
+//	}
+//
+// Inputs attributes must be objects unless the call sets scalar_inputs = true.
+func decodeCallInputs(bc *hcl.BodyContent, evalctx *hcl.EvalContext) ([]byte, error) {
+	var val cty.Value
+
+	if attr := bc.Attributes[InputsAttr]; attr != nil {
+		v, d := attr.Expr.Value(evalctx)
+		if d.HasErrors() {
+			return nil, ParseError{Diagnostics: d}
+		}
+
+		scalar, err := DecodeConditionalAttr(bc.Attributes[ScalarInputsAttr], false, evalctx)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s: %w", ScalarInputsAttr, err)
+		}
+		err = checkInputsValue(attr, v, scalar)
+		if err != nil {
+			return nil, err
+		}
+		val = v
+	} else if inputsBlocks := bc.Blocks.OfType(InputsID); len(inputsBlocks) > 0 {
+		v, err := decodeInputsBody(inputsBlocks[0].Body, evalctx)
+		if err != nil {
+			return nil, err
+		}
+		val = v
+	} else {
+		return nil, nil
+	}
+
+	jsonVal := ctyjson.SimpleJSONValue{Value: val}
+	return jsonVal.MarshalJSON()
+}
+
+// decodeInputsBody evaluates the body of an inputs block (or a block nested
+// within it) as an object
+func decodeInputsBody(body hcl.Body, evalctx *hcl.EvalContext) (cty.Value, error) {
+	vals := map[string]cty.Value{}
+
+	syntaxBody, ok := body.(*hclsyntax.Body)
+	if !ok {
+		// Bodies not written in native syntax (e.g. JSON) only have attributes
+		attrs, d := body.JustAttributes()
+		if d.HasErrors() {
+			return cty.NilVal, ParseError{Diagnostics: d}
+		}
+
+		for name, attr := range attrs {
+			val, d := attr.Expr.Value(evalctx)
+			if d.HasErrors() {
+				return cty.NilVal, ParseError{Diagnostics: d}
+			}
+			vals[name] = val
+		}
+
+		return cty.ObjectVal(vals), nil
+	}
+
+	for name, attr := range syntaxBody.Attributes {
+		val, d := attr.Expr.Value(evalctx)
+		if d.HasErrors() {
+			return cty.NilVal, ParseError{Diagnostics: d}
+		}
+		vals[name] = val
+	}
+
+	for _, block := range syntaxBody.Blocks {
+		if len(block.Labels) > 0 {
+			return cty.NilVal, ParseError{Diagnostics: hcl.Diagnostics{{
+				Severity: hcl.DiagError,
+				Summary:  "Unexpected block labels",
+				Detail:   fmt.Sprintf("Blocks within '%s' are objects, so can't have labels", InputsID),
+				Subject:  block.LabelRanges[0].Ptr(),
+			}}}
+		}
+
+		if _, ok := vals[block.Type]; ok {
+			return cty.NilVal, ParseError{Diagnostics: hcl.Diagnostics{{
+				Severity: hcl.DiagError,
+				Summary:  "Duplicate input",
+				Detail:   fmt.Sprintf("The input '%s' is already set within this block", block.Type),
+				Subject:  block.TypeRange.Ptr(),
+			}}}
+		}
+
+		val, err := decodeInputsBody(block.Body, evalctx)
+		if err != nil {
+			return cty.NilVal, err
+		}
+		vals[block.Type] = val
+	}
+
+	return cty.ObjectVal(vals), nil
+}
+
+// callInputsVariables returns the variables referenced by the inputs of a call,
+// in either form
+func callInputsVariables(bc *hcl.BodyContent) []hcl.Traversal {
+	if attr := bc.Attributes[InputsAttr]; attr != nil {
+		return attr.Expr.Variables()
+	}
+
+	traversals := []hcl.Traversal{}
+	for _, block := range bc.Blocks.OfType(InputsID) {
+		traversals = append(traversals, bodyVariables(block.Body)...)
+	}
+
+	return traversals
+}
+
+func bodyVariables(body hcl.Body) []hcl.Traversal {
+	traversals := []hcl.Traversal{}
+
+	syntaxBody, ok := body.(*hclsyntax.Body)
+	if !ok {
+		attrs, _ := body.JustAttributes()
+		for _, attr := range attrs {
+			traversals = append(traversals, attr.Expr.Variables()...)
+		}
+
+		return traversals
+	}
+
+	for _, attr := range syntaxBody.Attributes {
+		traversals = append(traversals, attr.Expr.Variables()...)
+	}
+	for _, block := range syntaxBody.Blocks {
+		traversals = append(traversals, bodyVariables(block.Body)...)
+	}
+
+	return traversals
+}
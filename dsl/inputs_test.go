@@ -0,0 +1,141 @@
+package dsl
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hiphops-io/hops/logs"
+)
+
+func TestParseInputsBlock(t *testing.T) {
+	logger := logs.NoOpLogger()
+	ctx := context.Background()
+
+	eventData, err := os.ReadFile("./testdata/raw_change_event.json")
+	require.NoError(t, err)
+
+	parseInputs := func(t *testing.T, inputs string) ([]byte, error) {
+		content := fmt.Sprintf("on change_merged {\n  name = \"pipeline\"\n\n  call app_handler {\n    %s\n  }\n}\n", inputs)
+		hopsFiles := readTestHops(t, content)
+
+		hop, err := ParseHops(ctx, hopsFiles, map[string][]byte{"event": eventData}, nil, logger)
+		if err != nil {
+			return nil, err
+		}
+
+		require.Len(t, hop.Ons, 1)
+		require.Len(t, hop.Ons[0].Calls, 1)
+		return hop.Ons[0].Calls[0].Inputs, nil
+	}
+
+	t.Run("Identical to the attribute", func(t *testing.T) {
+		fromAttr, err := parseInputs(t, `inputs = {
+      branch  = event.branch
+      count   = 2
+      enabled = true
+      message = {
+        text   = "Merged ${event.branch}"
+        labels = ["a", "b"]
+      }
+    }`)
+		require.NoError(t, err)
+
+		fromBlock, err := parseInputs(t, `inputs {
+      branch  = event.branch
+      count   = 2
+      enabled = true
+
+      message {
+        text   = "Merged ${event.branch}"
+        labels = ["a", "b"]
+      }
+    }`)
+		require.NoError(t, err)
+
+		assert.Equal(t, string(fromAttr), string(fromBlock), "Both forms should give the same JSON")
+		assert.Contains(t, string(fromBlock), `"text":"Merged `)
+	})
+
+	t.Run("Empty block", func(t *testing.T) {
+		inputs, err := parseInputs(t, "inputs {}")
+		require.NoError(t, err)
+		assert.JSONEq(t, `{}`, string(inputs))
+	})
+
+	t.Run("No inputs", func(t *testing.T) {
+		inputs, err := parseInputs(t, "")
+		require.NoError(t, err)
+		assert.Nil(t, inputs)
+	})
+
+	t.Run("Null inputs", func(t *testing.T) {
+		inputs, err := parseInputs(t, "inputs = null")
+		require.NoError(t, err)
+		assert.JSONEq(t, `null`, string(inputs))
+	})
+
+	t.Run("Scalar inputs allowed", func(t *testing.T) {
+		inputs, err := parseInputs(t, "inputs        = [event.branch]\n    scalar_inputs = true")
+		require.NoError(t, err)
+		assert.Contains(t, string(inputs), `["`)
+	})
+
+	invalid := []struct {
+		name        string
+		inputs      string
+		expectedErr string
+	}{
+		{
+			name:        "Both forms",
+			inputs:      "inputs = { a = 1 }\n    inputs {\n      b = 2\n    }",
+			expectedErr: "can't have both an 'inputs' attribute and block",
+		},
+		{
+			name:        "Multiple blocks",
+			inputs:      "inputs {\n      a = 1\n    }\n    inputs {\n      b = 2\n    }",
+			expectedErr: "Only one 'inputs' block is allowed per call",
+		},
+		{
+			name:        "Duplicate input",
+			inputs:      "inputs {\n      a = 1\n      a {\n        b = 2\n      }\n    }",
+			expectedErr: "The input 'a' is already set",
+		},
+		{
+			name:        "Labelled block",
+			inputs:      "inputs {\n      a \"label\" {\n        b = 2\n      }\n    }",
+			expectedErr: "can't have labels",
+		},
+		{
+			name:        "Scalar attribute",
+			inputs:      `inputs = "text"`,
+			expectedErr: "Inputs must be an object",
+		},
+		{
+			name:        "Evaluated list",
+			inputs:      "inputs = [event.branch]",
+			expectedErr: "not a tuple",
+		},
+		{
+			name:        "Invalid scalar_inputs",
+			inputs:      "inputs        = 1\n    scalar_inputs = \"yes\"",
+			expectedErr: "Invalid scalar_inputs",
+		},
+		{
+			name:        "Calls reference without dependency",
+			inputs:      "inputs {\n      value = calls.first.output\n    }",
+			expectedErr: "references calls.first without depending on it",
+		},
+	}
+
+	for _, tc := range invalid {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseInputs(t, tc.inputs)
+			assert.ErrorContains(t, err, tc.expectedErr)
+		})
+	}
+}
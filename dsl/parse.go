@@ -2,23 +2,34 @@ package dsl
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/gosimple/slug"
 	"github.com/hashicorp/hcl/v2"
-	"github.com/manterfield/fast-ctyjson/ctyjson"
 	"github.com/rs/zerolog"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
 	"github.com/zclconf/go-cty/cty/gocty"
 )
 
 const hopsMetadataKey = "hops"
 
-func ParseHops(ctx context.Context, hops *HopsFiles, eventBundle map[string][]byte, logger zerolog.Logger) (*HopAST, error) {
+// ParseHops decodes the hops config against the event bundle of a sequence
+//
+// Secrets referenced with `secret()` are looked up from the given provider, falling
+// back to an EnvSecretProvider with DefaultSecretEnvPrefix if nil.
+//
+// `now()` gives the time parsing started, from the clock set with ContextWithClock
+// if any, so conditions based on it are evaluated as of when calls are dispatched.
+//
+// Globals set with ContextWithGlobals are available to every block as `global`.
+func ParseHops(ctx context.Context, hops *HopsFiles, eventBundle map[string][]byte, secrets SecretProvider, logger zerolog.Logger) (*HopAST, error) {
 	hop := &HopAST{
 		SlugRegister: make(map[string]bool),
+		StartedAt:    clockFromContext(ctx)().UTC(),
 	}
 
 	ctxVariables, err := eventBundleToCty(eventBundle, "-")
@@ -26,20 +37,31 @@ func ParseHops(ctx context.Context, hops *HopsFiles, eventBundle map[string][]by
 		return nil, err
 	}
 
-	evalctx := &hcl.EvalContext{
+	if globals, ok := globalsFromContext(ctx); ok {
+		ctxVariables[GlobalVar] = globals
+	}
+
+	if secrets == nil {
+		secrets = NewEnvSecretProvider(DefaultSecretEnvPrefix)
+	}
+
+	rootEvalctx := &hcl.EvalContext{
 		Functions: StatelessFunctions,
 		Variables: ctxVariables,
 	}
 
+	// The secret function records resolved secrets on the hop and now() is fixed
+	// to the parse's start, so neither can be shared between parses
+	evalctx := rootEvalctx.NewChild()
+	evalctx.Functions = map[string]function.Function{
+		"now":    NowFunc(hop.StartedAt),
+		"secret": SecretFunc(secrets, hop),
+	}
+	evalctx.Variables = ctxVariables
+
 	err = DecodeHopsBody(ctx, hop, hops, evalctx, logger)
 	if err != nil {
 		logger.Error().Err(err).Msg("Failed to decode hops file")
-
-		logger.Debug().Msg("Parse failed on pipeline, dumping state:")
-		for k, v := range eventBundle {
-			logger.Debug().RawJSON(k, v).Msgf("%s message content", k)
-		}
-
 		return hop, err
 	}
 
@@ -59,25 +81,10 @@ func DecodeHopsBody(ctx context.Context, hop *HopAST, hops *HopsFiles, evalctx *
 }
 
 func DecodeOnBlock(ctx context.Context, hop *HopAST, hops *HopsFiles, block *hcl.Block, idx int, evalctx *hcl.EvalContext, logger zerolog.Logger) error {
-	on := &OnAST{}
-
-	bc, d := block.Body.Content(OnSchema)
-	if d.HasErrors() {
-		return errors.New(d.Error())
-	}
-
-	on.EventType = block.Labels[0]
-	name, err := DecodeNameAttr(bc.Attributes[NameAttr])
+	on, bc, err := decodeOnDeclaration(block, idx)
 	if err != nil {
 		return err
 	}
-	// If no name is given, append stringified index of the block
-	if name == "" {
-		name = fmt.Sprintf("%s%d", on.EventType, idx)
-	}
-
-	on.Name = name
-	on.Slug = slugify(on.Name)
 
 	err = ValidateLabels(on.EventType, on.Name)
 	if err != nil {
@@ -90,6 +97,17 @@ func DecodeOnBlock(ctx context.Context, hop *HopAST, hops *HopsFiles, block *hcl
 		hop.SlugRegister[on.Slug] = true
 	}
 
+	// Disabled blocks are skipped before any matching, so they can be switched
+	// off without being removed
+	enabled, err := DecodeConditionalAttr(bc.Attributes[EnabledAttr], true, evalctx)
+	if err != nil {
+		return fmt.Errorf("Invalid %s for '%s': %w", EnabledAttr, on.Slug, err)
+	}
+	if !enabled {
+		logger.Debug().Msgf("%s disabled", on.Slug)
+		return nil
+	}
+
 	// TODO: This should be done once outside of the on block and passed in as an argument
 	eventType, eventAction, err := parseEventVar(evalctx.Variables)
 	if err != nil {
@@ -109,6 +127,13 @@ func DecodeOnBlock(ctx context.Context, hop *HopAST, hops *HopsFiles, block *hcl
 	evalctx = blockEvalContext(evalctx, hops, block)
 	evalctx = scopedEvalContext(evalctx, on.EventType, on.Name)
 
+	// Outputs are mapped before anything else in the block is decoded, so every
+	// expression sees the mapped results
+	evalctx, err = mapCallOutputs(bc, evalctx)
+	if err != nil {
+		return err
+	}
+
 	ifClause := bc.Attributes[IfAttr]
 	val, err := DecodeConditionalAttr(ifClause, true, evalctx)
 	if err != nil {
@@ -123,6 +148,11 @@ func DecodeOnBlock(ctx context.Context, hop *HopAST, hops *HopsFiles, block *hcl
 
 	on.IfClause = val
 
+	on.Timeout, err = decodeTimeoutAttr(bc.Attributes[TimeoutAttr], evalctx)
+	if err != nil {
+		return fmt.Errorf("Invalid %s for '%s': %w", TimeoutAttr, on.Slug, err)
+	}
+
 	logger.Info().Msgf("%s matches event", on.Slug)
 
 	// Evaluate done blocks first, as we don't want to dispatch further calls
@@ -142,8 +172,29 @@ func DecodeOnBlock(ctx context.Context, hop *HopAST, hops *HopsFiles, block *hcl
 	}
 
 	callBlocks := bc.Blocks.OfType(CallID)
-	for idx, callBlock := range callBlocks {
-		err := DecodeCallBlock(ctx, hop, on, callBlock, idx, evalctx, logger)
+	if len(callBlocks) == 0 && len(doneBlocks) == 0 {
+		// Valid, but usually left over from editing rather than intended
+		logger.Warn().Msgf("%s matches event but has no calls or done block, so does nothing", on.Slug)
+	}
+
+	order, err := validateCallDependencies(callBlocks)
+	if err != nil {
+		return fmt.Errorf("Invalid calls in '%s': %w", on.Slug, err)
+	}
+
+	for _, idx := range order {
+		err := DecodeCallBlock(ctx, hop, on, callBlocks[idx], idx, evalctx, logger)
+		if err != nil {
+			return err
+		}
+	}
+
+	onErrorBlocks := bc.Blocks.OfType(OnErrorID)
+	if len(onErrorBlocks) > 1 {
+		return fmt.Errorf("Only one '%s' block is allowed per 'on' block: %s", OnErrorID, on.Slug)
+	}
+	for _, onErrorBlock := range onErrorBlocks {
+		err := DecodeOnErrorBlock(ctx, hop, on, onErrorBlock, evalctx, logger)
 		if err != nil {
 			return err
 		}
@@ -153,25 +204,76 @@ func DecodeOnBlock(ctx context.Context, hop *HopAST, hops *HopsFiles, block *hcl
 	return nil
 }
 
-func DecodeCallBlock(ctx context.Context, hop *HopAST, on *OnAST, block *hcl.Block, idx int, evalctx *hcl.EvalContext, logger zerolog.Logger) error {
-	call := &CallAST{}
-
-	bc, d := block.Body.Content(callSchema)
+// DecodeOnErrorBlock decodes the calls of an on_error block, adding them to the
+// on block's calls if any of its calls have failed
+//
+// The calls can reference the first failed call as `failed` (with `slug`, `name`
+// and `error` attributes), and all failed calls as the list `failures`. Their
+// slugs are prefixed with on_error, so their results can be referenced as
+// `on_error.call_name`.
+func DecodeOnErrorBlock(ctx context.Context, hop *HopAST, on *OnAST, block *hcl.Block, evalctx *hcl.EvalContext, logger zerolog.Logger) error {
+	bc, d := block.Body.Content(onErrorSchema)
 	if d.HasErrors() {
-		return errors.New(d.Error())
+		return ParseError{Diagnostics: d}
 	}
 
-	call.TaskType = block.Labels[0]
-	name, err := DecodeNameAttr(bc.Attributes[NameAttr])
+	for _, callBlock := range bc.Blocks.OfType(CallID) {
+		callBc, d := callBlock.Body.Content(callSchema)
+		if d.HasErrors() {
+			return ParseError{Diagnostics: d}
+		}
+		if callBc.Attributes[DependsOnAttr] != nil {
+			return fmt.Errorf("'%s' is not supported by calls in '%s' blocks: %s", DependsOnAttr, OnErrorID, on.Slug)
+		}
+	}
+
+	failures := []cty.Value{}
+	for _, call := range on.Calls {
+		failure, ok := callFailure(evalctx, call)
+		if ok {
+			failures = append(failures, failure)
+		}
+	}
+
+	if len(failures) == 0 {
+		return nil
+	}
+
+	logger.Info().Msgf("%s has %d failed call(s), running '%s' calls", on.Slug, len(failures), OnErrorID)
+
+	errorEvalctx := evalctx.NewChild()
+	errorEvalctx.Variables = map[string]cty.Value{
+		"failed":   failures[0],
+		"failures": cty.TupleVal(failures),
+	}
+
+	// Calls are decoded as if from an on block nested in this one, so they're
+	// slugged and classified separately before joining the on block's calls
+	errorOn := &OnAST{Slug: slugify(on.Slug, OnErrorID)}
+	for idx, callBlock := range bc.Blocks.OfType(CallID) {
+		err := DecodeCallBlock(ctx, hop, errorOn, callBlock, idx, errorEvalctx, logger)
+		if err != nil {
+			return err
+		}
+	}
+
+	on.Calls = append(on.Calls, errorOn.Calls...)
+	on.Skipped = append(on.Skipped, errorOn.Skipped...)
+	on.Waiting = append(on.Waiting, errorOn.Waiting...)
+
+	return nil
+}
+
+func DecodeCallBlock(ctx context.Context, hop *HopAST, on *OnAST, block *hcl.Block, idx int, evalctx *hcl.EvalContext, logger zerolog.Logger) error {
+	call, bc, err := decodeCallDeclaration(block, idx, on.Slug)
 	if err != nil {
 		return err
 	}
-	if name == "" {
-		name = fmt.Sprintf("%s%d", call.TaskType, idx)
-	}
 
-	call.Name = name
-	call.Slug = slugify(on.Slug, call.Name)
+	err = validateCallInputs(block, bc)
+	if err != nil {
+		return err
+	}
 
 	err = ValidateLabels(call.TaskType, call.Name)
 	if err != nil {
@@ -184,18 +286,64 @@ func DecodeCallBlock(ctx context.Context, hop *HopAST, on *OnAST, block *hcl.Blo
 		hop.SlugRegister[call.Slug] = true
 	}
 
+	enabled, err := DecodeConditionalAttr(bc.Attributes[EnabledAttr], true, evalctx)
+	if err != nil {
+		return fmt.Errorf("Invalid %s for '%s': %w", EnabledAttr, call.Slug, err)
+	}
+	if !enabled {
+		logger.Debug().Msgf("%s disabled", call.Slug)
+		on.Skipped = append(on.Skipped, *call)
+		return nil
+	}
+
+	// Calls with dependencies are held back until every prerequisite completes,
+	// then see their results as `calls.<name>`. A failed or skipped prerequisite
+	// means they can never be dispatched.
+	dependsOn, err := decodeDependsOnAttr(bc.Attributes[DependsOnAttr])
+	if err != nil {
+		return fmt.Errorf("Invalid %s for '%s': %w", DependsOnAttr, call.Slug, err)
+	}
+	if len(dependsOn) > 0 {
+		for _, dependency := range dependsOn {
+			call.DependsOn = append(call.DependsOn, slugify(on.Slug, dependency))
+		}
+
+		results, ready, failed := callDependencies(evalctx, dependsOn, on.Skipped)
+		if failed != "" {
+			logger.Debug().Msgf("%s depends on failed or skipped call '%s', skipping", call.Slug, failed)
+			on.Skipped = append(on.Skipped, *call)
+			return nil
+		}
+		if !ready {
+			logger.Debug().Msgf("%s waiting on calls it depends on", call.Slug)
+			on.Waiting = append(on.Waiting, *call)
+			return nil
+		}
+
+		evalctx = evalctx.NewChild()
+		evalctx.Variables = map[string]cty.Value{
+			CallsVar: cty.ObjectVal(results),
+		}
+	}
+
+	// An 'if' that can't be evaluated usually references the result of a call
+	// that hasn't finished yet, so the call is held back until a later message
+	// in the sequence makes it evaluable
 	ifClause := bc.Attributes[IfAttr]
 	val, err := DecodeConditionalAttr(ifClause, true, evalctx)
 	if err != nil {
 		logger.Debug().Msgf(
-			"%s 'if' not ready for evaluation, defaulting to false: %s",
+			"%s 'if' not ready for evaluation, waiting: %s",
 			call.Slug,
 			err.Error(),
 		)
+		on.Waiting = append(on.Waiting, *call)
+		return nil
 	}
 
 	if !val {
 		logger.Debug().Msgf("%s 'if' not met", call.Slug)
+		on.Skipped = append(on.Skipped, *call)
 		return nil
 	}
 
@@ -203,21 +351,9 @@ func DecodeCallBlock(ctx context.Context, hop *HopAST, on *OnAST, block *hcl.Blo
 
 	logger.Info().Msgf("%s matches event", call.Slug)
 
-	inputs := bc.Attributes["inputs"]
-	if inputs != nil {
-		val, d := inputs.Expr.Value(evalctx)
-		if d.HasErrors() {
-			return errors.New(d.Error())
-		}
-
-		jsonVal := ctyjson.SimpleJSONValue{Value: val}
-		inputs, err := jsonVal.MarshalJSON()
-
-		if err != nil {
-			return err
-		}
-
-		call.Inputs = inputs
+	call.Inputs, err = decodeCallInputs(bc, evalctx)
+	if err != nil {
+		return err
 	}
 
 	on.Calls = append(on.Calls, *call)
@@ -232,7 +368,7 @@ func DecodeNameAttr(attr *hcl.Attribute) (string, error) {
 
 	val, diag := attr.Expr.Value(nil)
 	if diag.HasErrors() {
-		return "", errors.New(diag.Error())
+		return "", ParseError{Diagnostics: diag}
 	}
 
 	var value string
@@ -252,7 +388,7 @@ func DecodeConditionalAttr(attr *hcl.Attribute, defaultValue bool, ctx *hcl.Eval
 
 	v, diag := attr.Expr.Value(ctx)
 	if diag.HasErrors() {
-		return false, errors.New(diag.Error())
+		return false, ParseError{Diagnostics: diag}
 	}
 
 	var value bool
@@ -265,6 +401,201 @@ func DecodeConditionalAttr(attr *hcl.Attribute, defaultValue bool, ctx *hcl.Eval
 	return value, nil
 }
 
+// callFailure returns details of a call if its result is in the eval context and
+// it errored
+func callFailure(evalctx *hcl.EvalContext, call CallAST) (cty.Value, bool) {
+	result, ok := evalctx.Variables[call.Name]
+	if !ok {
+		return cty.NilVal, false
+	}
+
+	errored := valueAtPath(result, "errored")
+	if errored.IsNull() || errored.Type() != cty.Bool || !errored.True() {
+		return cty.NilVal, false
+	}
+
+	errMsg := valueAtPath(result, "hops", "error")
+	if errMsg.IsNull() || errMsg.Type() != cty.String {
+		errMsg = cty.StringVal("")
+	}
+
+	failure := cty.ObjectVal(map[string]cty.Value{
+		"error": errMsg,
+		"name":  cty.StringVal(call.Name),
+		"slug":  cty.StringVal(call.Slug),
+	})
+
+	return failure, true
+}
+
+// decodeCallDeclaration decodes the parts of a call block that don't depend on
+// the event being parsed: its content, task type, name and slug within the block
+// slugged onSlug
+//
+// The name defaults to the task type suffixed with the block's index. Labels and
+// inputs are left for the caller to validate.
+func decodeCallDeclaration(block *hcl.Block, idx int, onSlug string) (*CallAST, *hcl.BodyContent, error) {
+	bc, d := block.Body.Content(callSchema)
+	if d.HasErrors() {
+		return nil, nil, ParseError{Diagnostics: d}
+	}
+
+	call := &CallAST{TaskType: block.Labels[0]}
+	name, err := DecodeNameAttr(bc.Attributes[NameAttr])
+	if err != nil {
+		return nil, nil, err
+	}
+	if name == "" {
+		name = fmt.Sprintf("%s%d", call.TaskType, idx)
+	}
+
+	call.Name = name
+	call.Slug = slugify(onSlug, call.Name)
+
+	return call, bc, nil
+}
+
+// decodeOnDeclaration decodes the parts of an on block that don't depend on the
+// event being parsed: its content, event type, name and slug
+//
+// The name defaults to the event type suffixed with the block's index. Labels are
+// left for the caller to validate.
+func decodeOnDeclaration(block *hcl.Block, idx int) (*OnAST, *hcl.BodyContent, error) {
+	bc, d := block.Body.Content(OnSchema)
+	if d.HasErrors() {
+		return nil, nil, ParseError{Diagnostics: d}
+	}
+
+	on := &OnAST{EventType: block.Labels[0]}
+	name, err := DecodeNameAttr(bc.Attributes[NameAttr])
+	if err != nil {
+		return nil, nil, err
+	}
+	if name == "" {
+		name = fmt.Sprintf("%s%d", on.EventType, idx)
+	}
+
+	on.Name = name
+	on.Slug = slugify(on.Name)
+
+	return on, bc, nil
+}
+
+// decodeTimeoutAttr decodes a duration attribute such as "10m", returning 0 if
+// the attribute isn't set
+func decodeTimeoutAttr(attr *hcl.Attribute, evalctx *hcl.EvalContext) (time.Duration, error) {
+	if attr == nil {
+		return 0, nil
+	}
+
+	val, diag := attr.Expr.Value(evalctx)
+	if diag.HasErrors() {
+		return 0, ParseError{Diagnostics: diag}
+	}
+
+	var value string
+	err := gocty.FromCtyValue(val, &value)
+	if err != nil {
+		return 0, err
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("Must be positive, got '%s'", value)
+	}
+
+	return timeout, nil
+}
+
+// mapCallOutputs returns an eval context in which the results of an on block's
+// calls (including those of its on_error block) carry their mapped outputs
+//
+// Each call with an output attribute has it evaluated once the call has completed,
+// with the call's result available as `result`. The value is added to the
+// result as `output`, so later expressions can reference `call_name.output`.
+// Errors if the mapping can't be evaluated, e.g. as it references missing fields.
+func mapCallOutputs(bc *hcl.BodyContent, evalctx *hcl.EvalContext) (*hcl.EvalContext, error) {
+	// Variables may be shared with other blocks' eval contexts, so aren't modified in place
+	variables := make(map[string]cty.Value, len(evalctx.Variables))
+	for name, val := range evalctx.Variables {
+		variables[name] = val
+	}
+
+	err := mapOutputs(bc.Blocks.OfType(CallID), variables, evalctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// on_error call results are nested under on_error, matching their slugs
+	onErrorResults, ok := variables[OnErrorID]
+	if ok && onErrorResults.Type().IsObjectType() {
+		for _, onErrorBlock := range bc.Blocks.OfType(OnErrorID) {
+			onErrorBc, d := onErrorBlock.Body.Content(onErrorSchema)
+			if d.HasErrors() {
+				return nil, ParseError{Diagnostics: d}
+			}
+
+			onErrorVars := onErrorResults.AsValueMap()
+			err := mapOutputs(onErrorBc.Blocks.OfType(CallID), onErrorVars, evalctx)
+			if err != nil {
+				return nil, err
+			}
+
+			variables[OnErrorID] = cty.ObjectVal(onErrorVars)
+		}
+	}
+
+	mappedEvalctx := evalctx.NewChild()
+	mappedEvalctx.Variables = variables
+
+	return mappedEvalctx, nil
+}
+
+// mapOutputs adds the mapped output to the result of each completed call with
+// an output attribute, updating results in place
+func mapOutputs(callBlocks hcl.Blocks, results map[string]cty.Value, evalctx *hcl.EvalContext) error {
+	for idx, callBlock := range callBlocks {
+		call, bc, err := decodeCallDeclaration(callBlock, idx, "")
+		if err != nil {
+			return err
+		}
+
+		output := bc.Attributes[OutputAttr]
+		if output == nil {
+			continue
+		}
+
+		// Failed calls have no output to map
+		result, ok := results[call.Name]
+		if !ok || !result.Type().IsObjectType() {
+			continue
+		}
+		completed := valueAtPath(result, "completed")
+		if completed.IsNull() || completed.Type() != cty.Bool || !completed.True() {
+			continue
+		}
+
+		outputEvalctx := evalctx.NewChild()
+		outputEvalctx.Variables = map[string]cty.Value{
+			ResultAttr: result,
+		}
+
+		val, d := output.Expr.Value(outputEvalctx)
+		if d.HasErrors() {
+			return fmt.Errorf("Unable to map output of call '%s': %w", call.Name, ParseError{Diagnostics: d})
+		}
+
+		resultVals := result.AsValueMap()
+		resultVals[OutputAttr] = val
+		results[call.Name] = cty.ObjectVal(resultVals)
+	}
+
+	return nil
+}
+
 func slugify(parts ...string) string {
 	joined := strings.Join(parts, "-")
 	return slug.Make(joined)
@@ -291,7 +622,7 @@ func blockEvalContext(evalCtx *hcl.EvalContext, hops *HopsFiles, block *hcl.Bloc
 //
 // This function effectively fakes relative/local variables by checking where
 // we are in the hops code (defined by scopePath) and bringing any nested variables matching
-// that path to the top level.
+// that path to the top level. Globals stay available at the top level, whatever the scope.
 func scopedEvalContext(evalCtx *hcl.EvalContext, scopePath ...string) *hcl.EvalContext {
 	scopedVars := evalCtx.Variables
 
@@ -301,6 +632,16 @@ func scopedEvalContext(evalCtx *hcl.EvalContext, scopePath ...string) *hcl.EvalC
 		}
 	}
 
+	if globals, ok := evalCtx.Variables[GlobalVar]; ok {
+		// Scoped variables may be shared with other eval contexts, so aren't modified in place
+		withGlobals := make(map[string]cty.Value, len(scopedVars)+1)
+		for name, val := range scopedVars {
+			withGlobals[name] = val
+		}
+		withGlobals[GlobalVar] = globals
+		scopedVars = withGlobals
+	}
+
 	scopedEvalCtx := evalCtx.NewChild()
 	scopedEvalCtx.Variables = scopedVars
 
@@ -1,13 +1,20 @@
 package dsl
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/hcl/v2"
 	"github.com/hiphops-io/hops/logs"
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
 )
 
 func TestValidParse(t *testing.T) {
@@ -33,7 +40,7 @@ func TestValidParse(t *testing.T) {
 		hopsFiles, err := ReadHopsFilePath(hopsFile)
 		assert.NoError(t, err)
 
-		hop, err := ParseHops(ctx, hopsFiles, eventBundle, logger)
+		hop, err := ParseHops(ctx, hopsFiles, eventBundle, nil, logger)
 		assert.NoError(t, err)
 
 		// Test we parsed the correct number of matching on blocks.
@@ -61,6 +68,12 @@ func TestValidParse(t *testing.T) {
 		call = hop.Ons[0].Calls[1]
 		assert.Equal(t, `a_sensor-index_id_call2`, call.Slug)
 
+		// Calls that didn't match are classified by why
+		require.Len(t, hop.Ons[0].Skipped, 1)
+		assert.Equal(t, `a_sensor-second_task`, hop.Ons[0].Skipped[0].Slug)
+		require.Len(t, hop.Ons[0].Waiting, 1)
+		assert.Equal(t, `a_sensor-depends`, hop.Ons[0].Waiting[0].Slug, "Call referencing a missing result should wait")
+
 		// Ensure the done block is empty
 		assert.Nil(t, hop.Ons[0].Done)
 	}
@@ -91,7 +104,7 @@ func TestValidParseResponseStep(t *testing.T) {
 	hopsFiles, err := ReadHopsFilePath(hopsFile)
 	assert.NoError(t, err)
 
-	hop, err := ParseHops(ctx, hopsFiles, eventBundle, logger)
+	hop, err := ParseHops(ctx, hopsFiles, eventBundle, nil, logger)
 	assert.NoError(t, err)
 
 	// Test we parsed the correct number of matching on blocks.
@@ -102,6 +115,8 @@ func TestValidParseResponseStep(t *testing.T) {
 
 	// Ensure the slugs align with what we want
 	assert.Equal(t, hop.Ons[0].Calls[0].Slug, "a_sensor-first_task")
+	assert.Equal(t, hop.Ons[0].Calls[2].Slug, "a_sensor-depends", "Waiting call should be dispatchable once its result is available")
+	assert.Empty(t, hop.Ons[0].Waiting)
 
 	// Ensure the done block is empty
 	assert.Nil(t, hop.Ons[0].Done)
@@ -130,7 +145,7 @@ func TestValidParseDone(t *testing.T) {
 	hopsFiles, err := ReadHopsFilePath(hopsFile)
 	assert.NoError(t, err)
 
-	hop, err := ParseHops(ctx, hopsFiles, eventBundle, logger)
+	hop, err := ParseHops(ctx, hopsFiles, eventBundle, nil, logger)
 	assert.NoError(t, err)
 
 	// Test we parsed the correct number of matching on blocks.
@@ -147,6 +162,481 @@ func TestValidParseDone(t *testing.T) {
 	assert.NoError(t, done.Error)
 }
 
+func TestParseOnError(t *testing.T) {
+	logger := logs.NoOpLogger()
+	ctx := context.Background()
+
+	content := `on change_merged {
+  name = "pipeline"
+
+  call app_deploy {
+    name = "deploy"
+  }
+
+  call app_test {
+    name = "test"
+  }
+
+  on_error {
+    call slack_post {
+      name = "notify"
+
+      inputs = {
+        text     = "${failed.slug} failed: ${failed.error}"
+        failures = length(failures)
+      }
+    }
+  }
+}
+`
+	hopsFiles := readTestHops(t, content)
+
+	eventData, err := os.ReadFile("./testdata/raw_change_event.json")
+	require.NoError(t, err)
+
+	succeeded := []byte(`{"completed": true, "errored": false, "hops": {"error": ""}}`)
+	failed := []byte(`{"completed": false, "errored": true, "hops": {"error": "Deployment rejected"}}`)
+
+	tests := []struct {
+		name          string
+		bundle        map[string][]byte
+		expectedCalls []string
+		expectedText  string
+	}{
+		{
+			name:          "No results",
+			bundle:        map[string][]byte{},
+			expectedCalls: []string{"pipeline-deploy", "pipeline-test"},
+		},
+		{
+			name: "All succeeded",
+			bundle: map[string][]byte{
+				"pipeline-deploy": succeeded,
+				"pipeline-test":   succeeded,
+			},
+			expectedCalls: []string{"pipeline-deploy", "pipeline-test"},
+		},
+		{
+			name: "One failed",
+			bundle: map[string][]byte{
+				"pipeline-deploy": failed,
+				"pipeline-test":   succeeded,
+			},
+			expectedCalls: []string{"pipeline-deploy", "pipeline-test", "pipeline-on_error-notify"},
+			expectedText:  "pipeline-deploy failed: Deployment rejected",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.bundle["event"] = eventData
+
+			hop, err := ParseHops(ctx, hopsFiles, tc.bundle, nil, logger)
+			require.NoError(t, err)
+			require.Len(t, hop.Ons, 1)
+
+			callSlugs := []string{}
+			for _, call := range hop.Ons[0].Calls {
+				callSlugs = append(callSlugs, call.Slug)
+			}
+			assert.Equal(t, tc.expectedCalls, callSlugs)
+
+			if tc.expectedText == "" {
+				return
+			}
+
+			errorCall := hop.Ons[0].Calls[len(hop.Ons[0].Calls)-1]
+			assert.JSONEq(t, fmt.Sprintf(`{"text": %q, "failures": 1}`, tc.expectedText), string(errorCall.Inputs))
+		})
+	}
+}
+
+func TestParseCallOutput(t *testing.T) {
+	logger := logs.NoOpLogger()
+	ctx := context.Background()
+
+	content := `on change_merged {
+  name = "pipeline"
+
+  call github_get_pr {
+    name = "pr"
+
+    output = {
+      number = result.json.number
+      title  = upper(result.json.title)
+    }
+  }
+
+  call slack_post {
+    name = "notify"
+    if   = pr.completed
+
+    inputs = {
+      text = "PR #${pr.output.number}: ${pr.output.title}"
+    }
+  }
+}
+`
+	hopsFiles := readTestHops(t, content)
+
+	eventData, err := os.ReadFile("./testdata/raw_change_event.json")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		bundle         map[string][]byte
+		expectedCalls  []string
+		expectedInputs string
+		expectErr      bool
+	}{
+		{
+			name:          "No result",
+			bundle:        map[string][]byte{},
+			expectedCalls: []string{"pipeline-pr"},
+		},
+		{
+			name: "Completed result is mapped",
+			bundle: map[string][]byte{
+				"pipeline-pr": []byte(`{"completed": true, "errored": false, "json": {"number": 42, "title": "Fix bug", "body": "Long description"}}`),
+			},
+			expectedCalls:  []string{"pipeline-pr", "pipeline-notify"},
+			expectedInputs: `{"text": "PR #42: FIX BUG"}`,
+		},
+		{
+			name: "Failed result is not mapped",
+			bundle: map[string][]byte{
+				"pipeline-pr": []byte(`{"completed": false, "errored": true, "hops": {"error": "Not found"}}`),
+			},
+			expectedCalls: []string{"pipeline-pr"},
+		},
+		{
+			name: "Mapping references missing field",
+			bundle: map[string][]byte{
+				"pipeline-pr": []byte(`{"completed": true, "errored": false, "json": {"number": 42}}`),
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.bundle["event"] = eventData
+
+			hop, err := ParseHops(ctx, hopsFiles, tc.bundle, nil, logger)
+			if tc.expectErr {
+				assert.ErrorContains(t, err, "Unable to map output of call 'pr'")
+				return
+			}
+
+			require.NoError(t, err)
+			require.Len(t, hop.Ons, 1)
+
+			callSlugs := []string{}
+			for _, call := range hop.Ons[0].Calls {
+				callSlugs = append(callSlugs, call.Slug)
+			}
+			assert.Equal(t, tc.expectedCalls, callSlugs)
+
+			if tc.expectedInputs == "" {
+				return
+			}
+
+			notifyCall := hop.Ons[0].Calls[len(hop.Ons[0].Calls)-1]
+			assert.JSONEq(t, tc.expectedInputs, string(notifyCall.Inputs))
+		})
+	}
+}
+
+func TestParseCallDependsOn(t *testing.T) {
+	logger := logs.NoOpLogger()
+	ctx := context.Background()
+
+	content := `on change_merged {
+  name = "pipeline"
+
+  call github_get_pr {
+    name = "pr"
+
+    output = {
+      number = result.json.number
+    }
+  }
+
+  call slack_post {
+    name       = "notify"
+    depends_on = ["pr"]
+
+    inputs = {
+      text = "PR #${calls.pr.output.number}"
+    }
+  }
+}
+`
+	hopsFiles := readTestHops(t, content)
+
+	eventData, err := os.ReadFile("./testdata/raw_change_event.json")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name            string
+		bundle          map[string][]byte
+		expectedCalls   []string
+		expectedSkipped []string
+		expectedWaiting []string
+		expectedInputs  string
+	}{
+		{
+			name:            "No result",
+			bundle:          map[string][]byte{},
+			expectedCalls:   []string{"pipeline-pr"},
+			expectedSkipped: []string{},
+			expectedWaiting: []string{"pipeline-notify"},
+		},
+		{
+			name: "Prerequisite completed",
+			bundle: map[string][]byte{
+				"pipeline-pr": []byte(`{"completed": true, "errored": false, "json": {"number": 42}}`),
+			},
+			expectedCalls:   []string{"pipeline-pr", "pipeline-notify"},
+			expectedSkipped: []string{},
+			expectedWaiting: []string{},
+			expectedInputs:  `{"text": "PR #42"}`,
+		},
+		{
+			name: "Prerequisite failed",
+			bundle: map[string][]byte{
+				"pipeline-pr": []byte(`{"completed": false, "errored": true, "hops": {"error": "Not found"}}`),
+			},
+			expectedCalls:   []string{"pipeline-pr"},
+			expectedSkipped: []string{"pipeline-notify"},
+			expectedWaiting: []string{},
+		},
+	}
+
+	slugs := func(calls []CallAST) []string {
+		callSlugs := []string{}
+		for _, call := range calls {
+			callSlugs = append(callSlugs, call.Slug)
+		}
+		return callSlugs
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.bundle["event"] = eventData
+
+			hop, err := ParseHops(ctx, hopsFiles, tc.bundle, nil, logger)
+			require.NoError(t, err)
+			require.Len(t, hop.Ons, 1)
+
+			on := hop.Ons[0]
+			assert.Equal(t, tc.expectedCalls, slugs(on.Calls))
+			assert.Equal(t, tc.expectedSkipped, slugs(on.Skipped))
+			assert.Equal(t, tc.expectedWaiting, slugs(on.Waiting))
+
+			if tc.expectedInputs == "" {
+				return
+			}
+
+			notifyCall := on.Calls[len(on.Calls)-1]
+			assert.Equal(t, []string{"pipeline-pr"}, notifyCall.DependsOn)
+			assert.JSONEq(t, tc.expectedInputs, string(notifyCall.Inputs))
+		})
+	}
+}
+
+func TestParseCallDependsOnSkipped(t *testing.T) {
+	logger := logs.NoOpLogger()
+	ctx := context.Background()
+
+	eventData, err := os.ReadFile("./testdata/raw_change_event.json")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name            string
+		calls           string
+		expectedCalls   []string
+		expectedSkipped []string
+	}{
+		{
+			name: "Prerequisite if not met",
+			calls: `call github_get_pr {
+    name = "pr"
+    if   = false
+  }
+
+  call slack_post {
+    name       = "notify"
+    depends_on = ["pr"]
+  }`,
+			expectedCalls:   []string{},
+			expectedSkipped: []string{"pipeline-pr", "pipeline-notify"},
+		},
+		{
+			name: "Prerequisite disabled",
+			calls: `call github_get_pr {
+    name    = "pr"
+    enabled = false
+  }
+
+  call slack_post {
+    name       = "notify"
+    depends_on = ["pr"]
+  }`,
+			expectedCalls:   []string{},
+			expectedSkipped: []string{"pipeline-pr", "pipeline-notify"},
+		},
+		{
+			name: "Prerequisite depends on a skipped call",
+			calls: `call github_get_pr {
+    name = "pr"
+    if   = false
+  }
+
+  call github_get_reviews {
+    name       = "reviews"
+    depends_on = ["pr"]
+  }
+
+  call slack_post {
+    name       = "notify"
+    depends_on = ["reviews"]
+  }`,
+			expectedCalls:   []string{},
+			expectedSkipped: []string{"pipeline-pr", "pipeline-reviews", "pipeline-notify"},
+		},
+		{
+			name: "Prerequisite declared after its dependent",
+			calls: `call slack_post {
+    name       = "notify"
+    depends_on = ["pr"]
+  }
+
+  call github_get_pr {
+    name = "pr"
+    if   = false
+  }
+
+  call github_get_checks {
+    name = "checks"
+  }`,
+			expectedCalls:   []string{"pipeline-checks"},
+			expectedSkipped: []string{"pipeline-pr", "pipeline-notify"},
+		},
+	}
+
+	slugs := func(calls []CallAST) []string {
+		callSlugs := []string{}
+		for _, call := range calls {
+			callSlugs = append(callSlugs, call.Slug)
+		}
+		return callSlugs
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			content := fmt.Sprintf("on change_merged {\n  name = \"pipeline\"\n\n  %s\n}\n", tc.calls)
+			hopsFiles := readTestHops(t, content)
+
+			hop, err := ParseHops(ctx, hopsFiles, map[string][]byte{"event": eventData}, nil, logger)
+			require.NoError(t, err)
+			require.Len(t, hop.Ons, 1)
+
+			on := hop.Ons[0]
+			assert.Equal(t, tc.expectedCalls, slugs(on.Calls))
+			assert.Equal(t, tc.expectedSkipped, slugs(on.Skipped))
+			assert.Empty(t, on.Waiting, "Dependents of skipped calls should never wait")
+		})
+	}
+}
+
+func TestParseInvalidCallDependsOn(t *testing.T) {
+	logger := logs.NoOpLogger()
+	ctx := context.Background()
+
+	eventData, err := os.ReadFile("./testdata/raw_change_event.json")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		calls       string
+		expectedErr string
+	}{
+		{
+			name: "Reference without dependency",
+			calls: `call app_first {
+    name = "first"
+  }
+
+  call app_second {
+    name   = "second"
+    inputs = { value = calls.first.output }
+  }`,
+			expectedErr: `Call 'second' references calls.first without depending on it, add "first" to its depends_on`,
+		},
+		{
+			name: "Unknown dependency",
+			calls: `call app_second {
+    name       = "second"
+    depends_on = ["first"]
+  }`,
+			expectedErr: "Call 'second' depends on unknown call 'first'",
+		},
+		{
+			name: "Self dependency",
+			calls: `call app_first {
+    name       = "first"
+    depends_on = ["first"]
+  }`,
+			expectedErr: "Call 'first' can't depend on itself",
+		},
+		{
+			name: "Cycle",
+			calls: `call app_first {
+    name       = "first"
+    depends_on = ["second"]
+  }
+
+  call app_second {
+    name       = "second"
+    depends_on = ["first"]
+  }`,
+			expectedErr: "Calls depend on each other in a cycle",
+		},
+		{
+			name: "Not a list of names",
+			calls: `call app_first {
+    name       = "first"
+    depends_on = "second"
+  }`,
+			expectedErr: "depends_on must be a list of call names",
+		},
+		{
+			name: "Within on_error",
+			calls: `call app_first {
+    name = "first"
+  }
+
+  on_error {
+    call app_notify {
+      depends_on = ["first"]
+    }
+  }`,
+			expectedErr: "'depends_on' is not supported by calls in 'on_error' blocks",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			content := fmt.Sprintf("on change_merged {\n  name = \"pipeline\"\n\n  %s\n}\n", tc.calls)
+			hopsFiles := readTestHops(t, content)
+
+			_, err := ParseHops(ctx, hopsFiles, map[string][]byte{"event": eventData}, nil, logger)
+			assert.ErrorContains(t, err, tc.expectedErr)
+		})
+	}
+}
+
 func TestInvalidParse(t *testing.T) {
 	hopsFile := "./testdata/invalid"
 	eventFile := "./testdata/raw_change_event.json"
@@ -163,11 +653,57 @@ func TestInvalidParse(t *testing.T) {
 	hopsFiles, err := ReadHopsFilePath(hopsFile)
 	assert.NoError(t, err)
 
-	hop, err := ParseHops(ctx, hopsFiles, eventBundle, logger)
+	hop, err := ParseHops(ctx, hopsFiles, eventBundle, nil, logger)
 	assert.Error(t, err)
 	assert.Nil(t, hop.Ons)
 }
 
+func TestParseError(t *testing.T) {
+	logger := logs.NoOpLogger()
+	ctx := context.Background()
+
+	eventData, err := os.ReadFile("./testdata/raw_change_event.json")
+	require.NoError(t, err)
+
+	t.Run("Decoding", func(t *testing.T) {
+		content := `on change_merged {
+  call app_handler {
+    unknown = true
+  }
+}
+`
+		hopsFiles := readTestHops(t, content)
+
+		_, err := ParseHops(ctx, hopsFiles, map[string][]byte{"event": eventData}, nil, logger)
+
+		parseErr := ParseError{}
+		require.ErrorAs(t, err, &parseErr, "Decode errors should keep their diagnostics")
+		require.Len(t, parseErr.Diagnostics, 1)
+
+		diag := parseErr.Diagnostics[0]
+		assert.Equal(t, hcl.DiagError, diag.Severity)
+		assert.Equal(t, filepath.Join("automation", "main.hops"), diag.Subject.Filename)
+		assert.Equal(t, 3, diag.Subject.Start.Line)
+		assert.Equal(t, parseErr.Diagnostics.Error(), err.Error(), "Error string should be the diagnostics as HCL formats them")
+	})
+
+	t.Run("Reading", func(t *testing.T) {
+		hopsDir := t.TempDir()
+		automationDir := filepath.Join(hopsDir, "automation")
+		err := os.MkdirAll(automationDir, 0o755)
+		require.NoError(t, err, "Test setup: Should create automation dir")
+		err = os.WriteFile(filepath.Join(automationDir, "main.hops"), []byte("on change_merged {\n"), 0o644)
+		require.NoError(t, err, "Test setup: Should write hops file")
+
+		_, err = ReadHopsFilePath(hopsDir)
+
+		parseErr := ParseError{}
+		require.ErrorAs(t, err, &parseErr, "Syntax errors should keep their diagnostics")
+		require.NotEmpty(t, parseErr.Diagnostics)
+		assert.Equal(t, 1, parseErr.Diagnostics[0].Subject.Start.Line)
+	})
+}
+
 func TestSlugify(t *testing.T) {
 	result := slugify("Hello World")
 	assert.Equal(t, "hello-world", result)
@@ -185,3 +721,193 @@ func TestSlugify(t *testing.T) {
 func TestResults(t *testing.T) {
 	t.Skip("Not implemented: Test result blocks have expected values")
 }
+
+// readTestHops is a test helper that writes hops content to an automation
+// directory and reads it back
+func readTestHops(t testing.TB, content string) *HopsFiles {
+	hopsDir := t.TempDir()
+	automationDir := filepath.Join(hopsDir, "automation")
+
+	err := os.MkdirAll(automationDir, 0o755)
+	require.NoError(t, err, "Test setup: Should create automation dir")
+	err = os.WriteFile(filepath.Join(automationDir, "main.hops"), []byte(content), 0o644)
+	require.NoError(t, err, "Test setup: Should write hops file")
+
+	hopsFiles, err := ReadHopsFilePath(hopsDir)
+	require.NoError(t, err, "Test setup: Should read hops files")
+
+	return hopsFiles
+}
+
+func TestParseOnTimeout(t *testing.T) {
+	logger := logs.NoOpLogger()
+	ctx := context.Background()
+
+	eventData, err := os.ReadFile("./testdata/raw_change_event.json")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name            string
+		timeout         string
+		expectedTimeout time.Duration
+		expectErr       bool
+	}{
+		{name: "Not set", expectedTimeout: 0},
+		{name: "Minutes", timeout: `timeout = "10m"`, expectedTimeout: 10 * time.Minute},
+		{name: "Compound", timeout: `timeout = "1h30m"`, expectedTimeout: 90 * time.Minute},
+		{name: "Invalid duration", timeout: `timeout = "soon"`, expectErr: true},
+		{name: "Not positive", timeout: `timeout = "0s"`, expectErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			content := fmt.Sprintf("on change_merged {\n  name = \"pipeline\"\n  %s\n}\n", tc.timeout)
+			hopsFiles := readTestHops(t, content)
+
+			hop, err := ParseHops(ctx, hopsFiles, map[string][]byte{"event": eventData}, nil, logger)
+			if tc.expectErr {
+				assert.ErrorContains(t, err, "Invalid timeout for 'pipeline'")
+				return
+			}
+
+			require.NoError(t, err)
+			require.Len(t, hop.Ons, 1)
+			assert.Equal(t, tc.expectedTimeout, hop.Ons[0].Timeout)
+		})
+	}
+}
+
+func TestParseEnabled(t *testing.T) {
+	logger := logs.NoOpLogger()
+	ctx := context.Background()
+
+	eventData, err := os.ReadFile("./testdata/raw_change_event.json")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name            string
+		onEnabled       string
+		callEnabled     string
+		expectedOns     int
+		expectedCalls   int
+		expectedSkipped int
+		expectErr       bool
+	}{
+		{name: "Enabled by default", expectedOns: 1, expectedCalls: 1},
+		{name: "Explicitly enabled", onEnabled: `enabled = true`, callEnabled: `enabled = true`, expectedOns: 1, expectedCalls: 1},
+		{name: "Disabled on block", onEnabled: `enabled = false`, expectedOns: 0},
+		{name: "Disabled call", callEnabled: `enabled = false`, expectedOns: 1, expectedSkipped: 1},
+		{name: "Disabled before if", onEnabled: "enabled = false\n  if = event.missing", expectedOns: 0},
+		{name: "Not a bool", onEnabled: `enabled = "nope"`, expectErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			content := fmt.Sprintf(
+				"on change_merged {\n  name = \"pipeline\"\n  %s\n\n  call app_handler {\n    %s\n  }\n}\n",
+				tc.onEnabled,
+				tc.callEnabled,
+			)
+			hopsFiles := readTestHops(t, content)
+
+			hop, err := ParseHops(ctx, hopsFiles, map[string][]byte{"event": eventData}, nil, logger)
+			if tc.expectErr {
+				assert.ErrorContains(t, err, "Invalid enabled for 'pipeline'")
+				return
+			}
+
+			require.NoError(t, err)
+			require.Len(t, hop.Ons, tc.expectedOns)
+			if tc.expectedOns == 0 {
+				return
+			}
+
+			assert.Len(t, hop.Ons[0].Calls, tc.expectedCalls)
+			assert.Len(t, hop.Ons[0].Skipped, tc.expectedSkipped, "Disabled calls should be skipped")
+		})
+	}
+}
+
+func TestParseGlobals(t *testing.T) {
+	logger := logs.NoOpLogger()
+
+	eventData, err := os.ReadFile("./testdata/raw_change_event.json")
+	require.NoError(t, err)
+
+	content := `on change_merged {
+  name = "deploy"
+  if   = global.region == "eu"
+
+  call app_handler {
+    inputs = {
+      region = global.region
+      pr     = event.pr_number
+    }
+  }
+}
+`
+	hopsFiles := readTestHops(t, content)
+	eventBundle := map[string][]byte{
+		"event":  eventData,
+		"global": []byte(`{"region": "us"}`),
+	}
+
+	t.Run("Globals are available to every block", func(t *testing.T) {
+		ctx := ContextWithGlobals(context.Background(), map[string]cty.Value{"region": cty.StringVal("eu")})
+
+		hop, err := ParseHops(ctx, hopsFiles, eventBundle, nil, logger)
+		require.NoError(t, err)
+		require.Len(t, hop.Ons, 1, "Globals should take precedence over the event bundle")
+		require.Len(t, hop.Ons[0].Calls, 1)
+		assert.JSONEq(t, `{"region": "eu", "pr": 662}`, string(hop.Ons[0].Calls[0].Inputs))
+	})
+
+	t.Run("Conditions on globals", func(t *testing.T) {
+		ctx := ContextWithGlobals(context.Background(), map[string]cty.Value{"region": cty.StringVal("us")})
+
+		hop, err := ParseHops(ctx, hopsFiles, eventBundle, nil, logger)
+		require.NoError(t, err)
+		assert.Empty(t, hop.Ons)
+	})
+}
+
+func TestParseWarnsWithoutCalls(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	defer zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	ctx := context.Background()
+
+	eventData, err := os.ReadFile("./testdata/raw_change_event.json")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		body       string
+		expectWarn bool
+	}{
+		{name: "No calls", expectWarn: true},
+		{name: "Only on_error calls", body: "on_error {\n    call app_handler {}\n  }", expectWarn: true},
+		{name: "Calls", body: "call app_handler {}"},
+		{name: "Done block", body: "done {\n    result = null\n  }"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var logBuf bytes.Buffer
+			logger := zerolog.New(&logBuf)
+
+			content := fmt.Sprintf("on change_merged {\n  name = \"pipeline\"\n  %s\n}\n", tc.body)
+			hopsFiles := readTestHops(t, content)
+
+			hop, err := ParseHops(ctx, hopsFiles, map[string][]byte{"event": eventData}, nil, logger)
+			require.NoError(t, err)
+			require.Len(t, hop.Ons, 1, "Empty on blocks should still be matched")
+
+			if tc.expectWarn {
+				assert.Contains(t, logBuf.String(), `"level":"warn","message":"pipeline matches event but has no calls or done block, so does nothing"`)
+			} else {
+				assert.NotContains(t, logBuf.String(), `"level":"warn"`)
+			}
+		})
+	}
+}
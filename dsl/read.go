@@ -24,7 +24,9 @@ type (
 	HopsFiles struct {
 		Hash        string
 		BodyContent *hcl.BodyContent
-		Files       []FileContent // Sorted by file name `File`
+		// EventIndex records the events the on blocks could match, built from BodyContent
+		EventIndex *EventIndex
+		Files      []FileContent // Sorted by file name `File`
 	}
 
 	FileContent struct {
@@ -67,6 +69,7 @@ func ReadHopsFilePath(filePath string) (*HopsFiles, error) {
 	hopsFiles := &HopsFiles{
 		Hash:        hash,
 		BodyContent: content,
+		EventIndex:  NewEventIndex(content),
 		Files:       files,
 	}
 
@@ -91,7 +94,7 @@ func ReadHopsFileContents(hopsFileContent []FileContent) (*hcl.BodyContent, stri
 		hopsFile, diags := parser.ParseHCL(file.Content, file.File)
 
 		if diags != nil && diags.HasErrors() {
-			return nil, "", errors.New(diags.Error())
+			return nil, "", ParseError{Diagnostics: diags}
 		}
 		hopsBodies = append(hopsBodies, hopsFile.Body)
 	}
@@ -99,7 +102,7 @@ func ReadHopsFileContents(hopsFileContent []FileContent) (*hcl.BodyContent, stri
 	body := hcl.MergeBodies(hopsBodies)
 	content, diags := body.Content(HopSchema)
 	if diags.HasErrors() {
-		return nil, "", errors.New(diags.Error())
+		return nil, "", ParseError{Diagnostics: diags}
 	}
 
 	if len(content.Blocks) == 0 {
@@ -1,7 +1,6 @@
 package dsl
 
 import (
-	"errors"
 	"fmt"
 
 	"github.com/hashicorp/hcl/v2"
@@ -16,7 +15,7 @@ func DecodeScheduleBlock(block *hcl.Block, hop *HopAST, evalctx *hcl.EvalContext
 
 	diag := gohcl.DecodeBody(block.Body, evalctx, &schedule)
 	if diag.HasErrors() {
-		return errors.New(diag.Error())
+		return ParseError{Diagnostics: diag}
 	}
 
 	schedule.Name = block.Labels[0]
@@ -37,7 +36,7 @@ func DecodeScheduleBlock(block *hcl.Block, hop *HopAST, evalctx *hcl.EvalContext
 	if found && inputAttr != nil {
 		val, d := inputAttr.Expr.Value(evalctx)
 		if d.HasErrors() {
-			return errors.New(d.Error())
+			return ParseError{Diagnostics: d}
 		}
 
 		jsonVal := ctyjson.SimpleJSONValue{Value: val}
@@ -9,10 +9,17 @@ import (
 )
 
 var (
-	ErrorAttr  = "error"
-	ResultAttr = "result"
-	IfAttr     = "if"
-	NameAttr   = "name"
+	DependsOnAttr = "depends_on"
+	EnabledAttr   = "enabled"
+	ErrorAttr     = "error"
+	ResultAttr    = "result"
+	IfAttr        = "if"
+	InputsAttr    = "inputs"
+	NameAttr      = "name"
+	OutputAttr    = "output"
+	TimeoutAttr   = "timeout"
+	// ScalarInputsAttr lets a call's inputs be any JSON value, not only an object
+	ScalarInputsAttr = "scalar_inputs"
 
 	HopSchema = &hcl.BodySchema{
 		Attributes: []hcl.AttributeSchema{},
@@ -42,23 +49,48 @@ var (
 			{
 				Type: DoneID,
 			},
+			{
+				Type: OnErrorID,
+			},
 		},
 		Attributes: []hcl.AttributeSchema{
 			{Name: "name", Required: false},
+			{Name: EnabledAttr, Required: false},
 			{Name: IfAttr, Required: false},
+			{Name: TimeoutAttr, Required: false},
+		},
+	}
+
+	OnErrorID     = "on_error"
+	onErrorSchema = &hcl.BodySchema{
+		Blocks: []hcl.BlockHeaderSchema{
+			{
+				Type:       CallID,
+				LabelNames: []string{"taskType"},
+			},
 		},
 	}
 
 	CallID     = "call"
 	callSchema = &hcl.BodySchema{
-		Blocks: []hcl.BlockHeaderSchema{},
+		Blocks: []hcl.BlockHeaderSchema{
+			{Type: InputsID},
+		},
 		Attributes: []hcl.AttributeSchema{
 			{Name: "name", Required: false},
+			{Name: EnabledAttr, Required: false},
 			{Name: IfAttr, Required: false},
-			{Name: "inputs", Required: false},
+			{Name: InputsAttr, Required: false},
+			{Name: OutputAttr, Required: false},
+			{Name: DependsOnAttr, Required: false},
+			{Name: ScalarInputsAttr, Required: false},
 		},
 	}
 
+	// InputsID is the block form of a call's inputs, an alternative to the
+	// inputs attribute for large payloads
+	InputsID = "inputs"
+
 	DoneID     = "done"
 	doneSchema = &hcl.BodySchema{
 		Blocks: []hcl.BlockHeaderSchema{},
@@ -94,6 +126,22 @@ type HopAST struct {
 	SlugRegister map[string]bool
 	StartedAt    time.Time
 	Tasks        []TaskAST
+	secrets      []string
+}
+
+// Secrets returns the secret values resolved whilst parsing the hop, e.g. to
+// redact them from JSON with logs.Redactor
+func (h *HopAST) Secrets() []string {
+	return append([]string(nil), h.secrets...)
+}
+
+// Redact replaces any secret values resolved whilst parsing the hop with RedactedValue
+func (h *HopAST) Redact(content string) string {
+	for _, secret := range h.secrets {
+		content = strings.ReplaceAll(content, secret, RedactedValue)
+	}
+
+	return content
 }
 
 func (h *HopAST) ListSchedules() []ScheduleAST {
@@ -117,6 +165,20 @@ func (h *HopAST) ListFileTasks(path string) []TaskAST {
 	return fileTasks
 }
 
+func (h *HopAST) addSecret(secret string) {
+	if secret == "" {
+		return
+	}
+
+	for _, existing := range h.secrets {
+		if existing == secret {
+			return
+		}
+	}
+
+	h.secrets = append(h.secrets, secret)
+}
+
 func (h *HopAST) GetTask(taskName string) (TaskAST, error) {
 	// TODO: This currently searches all tasks rather than map lookup. Improve in future
 	for _, task := range h.Tasks {
@@ -132,8 +194,17 @@ type OnAST struct {
 	Slug      string
 	EventType string
 	Name      string
-	Calls     []CallAST
-	Done      *DoneAST
+	// Calls are those ready to be dispatched
+	Calls []CallAST
+	Done  *DoneAST
+	// Skipped calls have an 'if' that evaluated to false
+	Skipped []CallAST
+	// Waiting calls have an 'if' that can't be evaluated yet, usually as it
+	// references results not yet in the sequence
+	Waiting []CallAST
+	// Timeout is how long after the source event the block may run for, or 0
+	// to use the runner's default
+	Timeout time.Duration
 	ConditionalAST
 }
 
@@ -142,6 +213,8 @@ type CallAST struct {
 	TaskType string
 	Name     string
 	Inputs   []byte
+	// DependsOn are the slugs of the calls that must complete before this one
+	DependsOn []string
 	ConditionalAST
 }
 
@@ -0,0 +1,82 @@
+package dsl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+)
+
+const (
+	// DefaultSecretEnvPrefix is the env var prefix used by the default EnvSecretProvider
+	DefaultSecretEnvPrefix = "HOPS_SECRET_"
+
+	// RedactedValue replaces secret values in logged content
+	RedactedValue = "[REDACTED]"
+)
+
+// ErrSecretNotFound is returned by a SecretProvider when a secret doesn't exist
+var ErrSecretNotFound = errors.New("Secret not found")
+
+type (
+	// EnvSecretProvider is a SecretProvider that reads secrets from env vars
+	//
+	// The env var read for a secret is the prefix followed by the upper cased secret name,
+	// e.g. `secret("github_token")` reads `HOPS_SECRET_GITHUB_TOKEN` by default.
+	EnvSecretProvider struct {
+		Prefix string
+	}
+
+	// SecretProvider looks up secrets for the `secret()` function
+	//
+	// Implementations should return ErrSecretNotFound if a secret doesn't exist.
+	SecretProvider interface {
+		Secret(name string) (string, error)
+	}
+)
+
+// NewEnvSecretProvider returns an EnvSecretProvider reading env vars with the given prefix
+func NewEnvSecretProvider(prefix string) *EnvSecretProvider {
+	return &EnvSecretProvider{Prefix: prefix}
+}
+
+func (e *EnvSecretProvider) Secret(name string) (string, error) {
+	val, ok := os.LookupEnv(e.Prefix + strings.ToUpper(name))
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+
+	return val, nil
+}
+
+// SecretFunc returns a cty.Function that looks up a secret by name from the provider
+//
+// It is stateful because resolved secret values are recorded on the hop,
+// allowing them to be redacted from logs with HopAST.Redact.
+func SecretFunc(provider SecretProvider, hop *HopAST) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{
+			{
+				Name: "name",
+				Type: cty.String,
+			},
+		},
+		Type: function.StaticReturnType(cty.String),
+		// Closure over provider and hop
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			name := args[0].AsString()
+
+			val, err := provider.Secret(name)
+			if err != nil {
+				return cty.StringVal(""), fmt.Errorf("Unable to get secret '%s': %w", name, err)
+			}
+
+			hop.addSecret(val)
+
+			return cty.StringVal(val), nil
+		},
+	})
+}
@@ -0,0 +1,83 @@
+package dsl
+
+import (
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+)
+
+type mapSecretProvider map[string]string
+
+func (m mapSecretProvider) Secret(name string) (string, error) {
+	val, ok := m[name]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+
+	return val, nil
+}
+
+func TestEnvSecretProvider(t *testing.T) {
+	t.Setenv("HOPS_SECRET_GITHUB_TOKEN", "abc123")
+
+	provider := NewEnvSecretProvider(DefaultSecretEnvPrefix)
+
+	val, err := provider.Secret("github_token")
+	assert.NoError(t, err)
+	assert.Equal(t, "abc123", val)
+
+	_, err = provider.Secret("missing")
+	assert.ErrorIs(t, err, ErrSecretNotFound)
+}
+
+func TestSecretFunc(t *testing.T) {
+	type testCase struct {
+		name      string
+		expr      string
+		expected  cty.Value
+		expectErr bool
+	}
+
+	tests := []testCase{
+		{
+			name:     "Existing secret",
+			expr:     `secret("token")`,
+			expected: cty.StringVal("abc123"),
+		},
+		{
+			name:      "Missing secret",
+			expr:      `secret("missing")`,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			hop := &HopAST{}
+			evalctx := &hcl.EvalContext{
+				Functions: map[string]function.Function{
+					"secret": SecretFunc(mapSecretProvider{"token": "abc123"}, hop),
+				},
+			}
+
+			expr, d := hclsyntax.ParseExpression([]byte(tc.expr), "test.hops", hcl.InitialPos)
+			require.False(t, d.HasErrors(), d.Error())
+
+			val, d := expr.Value(evalctx)
+			if tc.expectErr {
+				assert.True(t, d.HasErrors(), "Missing secrets should error")
+				return
+			}
+
+			require.False(t, d.HasErrors(), d.Error())
+			assert.Equal(t, tc.expected, val)
+			assert.Equal(t, "Bearer [REDACTED]", hop.Redact("Bearer abc123"), "Resolved secrets should be redacted")
+			assert.Equal(t, []string{"abc123"}, hop.Secrets())
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package dsl
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/hcl/v2"
+)
+
+type (
+	// HopsSummary lists the on blocks and calls declared in hops files, without
+	// evaluating them against an event
+	HopsSummary struct {
+		Diagnostics []string    `json:"diagnostics"`
+		Ons         []OnSummary `json:"ons"`
+
+		// diags holds the diagnostics with their positions, along with any warnings
+		diags hcl.Diagnostics
+	}
+
+	// OnSummary describes an on block as declared, with the slugs its calls are
+	// given when dispatched
+	OnSummary struct {
+		Calls     []string `json:"calls"`
+		EventType string   `json:"event_type"`
+		FilePath  string   `json:"file_path"`
+		Slug      string   `json:"slug"`
+	}
+)
+
+// SummariseHops lists the on blocks and calls in hops files
+//
+// Problems that would stop a sequence being parsed regardless of its event
+// (e.g. invalid names or duplicate slugs) are returned as diagnostics, rather
+// than ending the summary early.
+func SummariseHops(hops *HopsFiles) *HopsSummary {
+	summary := &HopsSummary{
+		Diagnostics: []string{},
+		Ons:         []OnSummary{},
+	}
+
+	if hops == nil || hops.BodyContent == nil {
+		return summary
+	}
+
+	slugs := map[string]bool{}
+	register := func(slug string, rng hcl.Range) {
+		if slugs[slug] {
+			summary.addDiagnostic(rng, fmt.Errorf("Duplicate slug: %s", slug))
+		}
+		slugs[slug] = true
+	}
+
+	for idx, block := range hops.BodyContent.Blocks.OfType(OnID) {
+		decoded, bc, err := decodeOnDeclaration(block, idx)
+		if err != nil {
+			summary.addDiagnostic(block.DefRange, err)
+			continue
+		}
+
+		on := OnSummary{
+			Calls:     []string{},
+			EventType: decoded.EventType,
+			FilePath:  block.DefRange.Filename,
+			Slug:      decoded.Slug,
+		}
+
+		err = ValidateLabels(decoded.EventType, decoded.Name)
+		if err != nil {
+			summary.addDiagnostic(block.DefRange, err)
+		}
+
+		register(on.Slug, block.DefRange)
+
+		on.Calls = append(on.Calls, summary.callSlugs(on.Slug, bc.Blocks.OfType(CallID), register)...)
+		if len(on.Calls) == 0 && len(bc.Blocks.OfType(DoneID)) == 0 {
+			summary.addWarning(block.DefRange, fmt.Sprintf("On block '%s' has no calls or done block, so does nothing", on.Slug))
+		}
+
+		onErrorBlocks := bc.Blocks.OfType(OnErrorID)
+		if len(onErrorBlocks) > 1 {
+			summary.addDiagnostic(block.DefRange, fmt.Errorf("Only one '%s' block is allowed per 'on' block: %s", OnErrorID, on.Slug))
+		}
+		for _, onErrorBlock := range onErrorBlocks {
+			errorBC, d := onErrorBlock.Body.Content(onErrorSchema)
+			if d.HasErrors() {
+				summary.addDiagnostic(onErrorBlock.DefRange, d)
+				continue
+			}
+
+			errorSlug := slugify(on.Slug, OnErrorID)
+			on.Calls = append(on.Calls, summary.callSlugs(errorSlug, errorBC.Blocks.OfType(CallID), register)...)
+		}
+
+		summary.Ons = append(summary.Ons, on)
+	}
+
+	return summary
+}
+
+func (s *HopsSummary) addDiagnostic(rng hcl.Range, err error) {
+	s.Diagnostics = append(s.Diagnostics, fmt.Sprintf("%s: %s", rng.String(), err.Error()))
+	s.diags = append(s.diags, diagnosticsFromError(err, rng)...)
+}
+
+// addWarning records a problem that doesn't stop sequences being parsed, so is
+// only reported by Validate
+func (s *HopsSummary) addWarning(rng hcl.Range, message string) {
+	s.diags = append(s.diags, &hcl.Diagnostic{
+		Severity: hcl.DiagWarning,
+		Summary:  message,
+		Subject:  rng.Ptr(),
+	})
+}
+
+// callSlugs returns the slugs of call blocks within the block slugged onSlug
+func (s *HopsSummary) callSlugs(onSlug string, callBlocks hcl.Blocks, register func(string, hcl.Range)) []string {
+	slugs := []string{}
+
+	for idx, callBlock := range callBlocks {
+		call, bc, err := decodeCallDeclaration(callBlock, idx, onSlug)
+		if err != nil {
+			s.addDiagnostic(callBlock.DefRange, err)
+			continue
+		}
+
+		err = ValidateLabels(call.TaskType, call.Name)
+		if err != nil {
+			s.addDiagnostic(callBlock.DefRange, err)
+		}
+
+		err = validateCallInputs(callBlock, bc)
+		if err != nil {
+			s.addDiagnostic(callBlock.DefRange, err)
+		}
+
+		register(call.Slug, callBlock.DefRange)
+		slugs = append(slugs, call.Slug)
+	}
+
+	return slugs
+}
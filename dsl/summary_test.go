@@ -0,0 +1,108 @@
+package dsl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummariseHops(t *testing.T) {
+	type testCase struct {
+		name            string
+		hops            string
+		expectedOns     []OnSummary
+		diagnosticsLike []string
+	}
+
+	tests := []testCase{
+		{
+			name: "Named and unnamed blocks",
+			hops: `on change_merged {
+  name = "deploy"
+
+  call k8s_apply {}
+
+  call slack_post {
+    name = "notify"
+  }
+
+  on_error {
+    call slack_post {
+      name = "alert"
+    }
+  }
+}
+
+on push {
+  call github_comment {}
+}
+`,
+			expectedOns: []OnSummary{
+				{
+					Calls:     []string{"deploy-k8s_apply0", "deploy-notify", "deploy-on_error-alert"},
+					EventType: "change_merged",
+					Slug:      "deploy",
+				},
+				{
+					Calls:     []string{"push1-github_comment0"},
+					EventType: "push",
+					Slug:      "push1",
+				},
+			},
+		},
+		{
+			name: "Duplicate slugs",
+			hops: `on push {
+  name = "dupe"
+}
+
+on pull {
+  name = "dupe"
+}
+`,
+			expectedOns: []OnSummary{
+				{Calls: []string{}, EventType: "push", Slug: "dupe"},
+				{Calls: []string{}, EventType: "pull", Slug: "dupe"},
+			},
+			diagnosticsLike: []string{"Duplicate slug: dupe"},
+		},
+		{
+			name: "Invalid call block",
+			hops: `on push {
+  name = "pipeline"
+
+  call app_handler {
+    unknown = true
+  }
+}
+`,
+			expectedOns: []OnSummary{
+				{Calls: []string{}, EventType: "push", Slug: "pipeline"},
+			},
+			diagnosticsLike: []string{"Unsupported argument"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			hopsFiles := readTestHops(t, tc.hops)
+
+			summary := SummariseHops(hopsFiles)
+
+			require.Len(t, summary.Ons, len(tc.expectedOns))
+			for i, on := range summary.Ons {
+				assert.True(t, strings.HasSuffix(on.FilePath, "main.hops"), "On block should record its file")
+
+				on.FilePath = ""
+				assert.Equal(t, tc.expectedOns[i], on)
+			}
+
+			require.Len(t, summary.Diagnostics, len(tc.diagnosticsLike))
+			for i, like := range tc.diagnosticsLike {
+				assert.Contains(t, summary.Diagnostics[i], like)
+			}
+		})
+	}
+}
@@ -2,7 +2,6 @@ package dsl
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"strings"
 
@@ -61,7 +60,7 @@ func DecodeTaskBlock(ctx context.Context, hop *HopAST, block *hcl.Block, evalctx
 
 	content, diag := block.Body.Content(taskSchema)
 	if diag.HasErrors() {
-		return errors.New(diag.Error())
+		return ParseError{Diagnostics: diag}
 	}
 
 	task.Name = block.Labels[0]
@@ -114,7 +113,7 @@ func decodeTaskStrAttribute(content *hcl.BodyContent, evalctx *hcl.EvalContext,
 	if attr != nil {
 		val, diag := attr.Expr.Value(evalctx)
 		if diag.HasErrors() {
-			return errors.New(diag.Error())
+			return ParseError{Diagnostics: diag}
 		}
 		*target = val.AsString()
 	}
@@ -127,7 +126,7 @@ func DecodeParamBlock(block *hcl.Block, task *TaskAST, hop *HopAST, evalctx *hcl
 
 	diag := gohcl.DecodeBody(block.Body, evalctx, &param)
 	if diag.HasErrors() {
-		return errors.New(diag.Error())
+		return ParseError{Diagnostics: diag}
 	}
 
 	param.Name = block.Labels[0]
@@ -213,7 +212,7 @@ func attrAsType[T any](val any, evalctx *hcl.EvalContext) (T, bool, error) {
 		exprVal, diag := valAttr.Expr.Value(evalctx)
 
 		if diag.HasErrors() {
-			return result, false, errors.New(diag.Error())
+			return result, false, ParseError{Diagnostics: diag}
 		}
 		ctyVal = exprVal
 	}
@@ -0,0 +1,138 @@
+package dsl
+
+import (
+	"context"
+	"fmt"
+	"time"
+	// Embedded so timezone() works on hosts without a time zone database
+	_ "time/tzdata"
+
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+)
+
+type clockCtxKey struct{}
+
+// TimeCmpFunc is a cty.Function that compares two RFC 3339 timestamps, returning
+// -1 if the first is earlier, 0 if they're the same instant and 1 if it's later
+var TimeCmpFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{
+			Name: "a",
+			Type: cty.String,
+		},
+		{
+			Name: "b",
+			Type: cty.String,
+		},
+	},
+	Type: function.StaticReturnType(cty.Number),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		a, err := parseTimestamp(args[0])
+		if err != nil {
+			return cty.UnknownVal(cty.Number), function.NewArgError(0, err)
+		}
+		b, err := parseTimestamp(args[1])
+		if err != nil {
+			return cty.UnknownVal(cty.Number), function.NewArgError(1, err)
+		}
+
+		switch {
+		case a.Before(b):
+			return cty.NumberIntVal(-1), nil
+		case a.After(b):
+			return cty.NumberIntVal(1), nil
+		default:
+			return cty.NumberIntVal(0), nil
+		}
+	},
+})
+
+// TimezoneFunc is a cty.Function that converts an RFC 3339 timestamp to the
+// same instant in an IANA time zone (e.g. "Europe/London"), so formatdate
+// gives the local date and time there
+var TimezoneFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{
+			Name: "timestamp",
+			Type: cty.String,
+		},
+		{
+			Name: "zone",
+			Type: cty.String,
+		},
+	},
+	Type: function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		timestamp, err := parseTimestamp(args[0])
+		if err != nil {
+			return cty.UnknownVal(cty.String), function.NewArgError(0, err)
+		}
+
+		location, err := time.LoadLocation(args[1].AsString())
+		if err != nil {
+			return cty.UnknownVal(cty.String), function.NewArgError(1, fmt.Errorf("Unknown time zone: %w", err))
+		}
+
+		return cty.StringVal(timestamp.In(location).Format(time.RFC3339)), nil
+	},
+})
+
+// UnixTimeFunc is a cty.Function that returns the seconds since the Unix epoch
+// of an RFC 3339 timestamp
+var UnixTimeFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{
+			Name: "timestamp",
+			Type: cty.String,
+		},
+	},
+	Type: function.StaticReturnType(cty.Number),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		timestamp, err := parseTimestamp(args[0])
+		if err != nil {
+			return cty.UnknownVal(cty.Number), function.NewArgError(0, err)
+		}
+
+		return cty.NumberIntVal(timestamp.Unix()), nil
+	},
+})
+
+// ContextWithClock returns a copy of ctx in which hops configs are parsed as if
+// the current time is given by clock, e.g. to freeze now() in tests
+func ContextWithClock(ctx context.Context, clock func() time.Time) context.Context {
+	return context.WithValue(ctx, clockCtxKey{}, clock)
+}
+
+// NowFunc returns a cty.Function that returns the given time as an RFC 3339
+// timestamp in UTC
+//
+// Each parse creates its own, so every call to now() whilst parsing gives the same time.
+func NowFunc(now time.Time) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{},
+		Type:   function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			return cty.StringVal(now.UTC().Format(time.RFC3339)), nil
+		},
+	})
+}
+
+// clockFromContext returns the clock set with ContextWithClock, or time.Now
+func clockFromContext(ctx context.Context) func() time.Time {
+	clock, ok := ctx.Value(clockCtxKey{}).(func() time.Time)
+	if !ok || clock == nil {
+		return time.Now
+	}
+
+	return clock
+}
+
+func parseTimestamp(val cty.Value) (time.Time, error) {
+	timestamp, err := time.Parse(time.RFC3339, val.AsString())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid RFC 3339 timestamp: %w", err)
+	}
+
+	return timestamp, nil
+}
@@ -0,0 +1,154 @@
+package dsl
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+
+	"github.com/hiphops-io/hops/logs"
+)
+
+func TestTimeFuncs(t *testing.T) {
+	tests := []struct {
+		name      string
+		fn        function.Function
+		args      []cty.Value
+		expected  cty.Value
+		expectErr bool
+	}{
+		{
+			name:     "timecmp earlier",
+			fn:       TimeCmpFunc,
+			args:     []cty.Value{cty.StringVal("2024-01-01T09:00:00Z"), cty.StringVal("2024-01-01T10:00:00Z")},
+			expected: cty.NumberIntVal(-1),
+		},
+		{
+			name:     "timecmp same instant in different zones",
+			fn:       TimeCmpFunc,
+			args:     []cty.Value{cty.StringVal("2024-01-01T09:00:00Z"), cty.StringVal("2024-01-01T10:00:00+01:00")},
+			expected: cty.NumberIntVal(0),
+		},
+		{
+			name:     "timecmp later",
+			fn:       TimeCmpFunc,
+			args:     []cty.Value{cty.StringVal("2024-01-02T00:00:00Z"), cty.StringVal("2024-01-01T23:59:59Z")},
+			expected: cty.NumberIntVal(1),
+		},
+		{
+			name:      "timecmp invalid timestamp",
+			fn:        TimeCmpFunc,
+			args:      []cty.Value{cty.StringVal("yesterday"), cty.StringVal("2024-01-01T00:00:00Z")},
+			expectErr: true,
+		},
+		{
+			name:     "timezone",
+			fn:       TimezoneFunc,
+			args:     []cty.Value{cty.StringVal("2024-07-01T12:00:00Z"), cty.StringVal("Europe/London")},
+			expected: cty.StringVal("2024-07-01T13:00:00+01:00"),
+		},
+		{
+			name:     "timezone UTC",
+			fn:       TimezoneFunc,
+			args:     []cty.Value{cty.StringVal("2024-07-01T13:00:00+01:00"), cty.StringVal("UTC")},
+			expected: cty.StringVal("2024-07-01T12:00:00Z"),
+		},
+		{
+			name:      "timezone unknown zone",
+			fn:        TimezoneFunc,
+			args:      []cty.Value{cty.StringVal("2024-07-01T12:00:00Z"), cty.StringVal("Mars/Olympus_Mons")},
+			expectErr: true,
+		},
+		{
+			name:     "unixtime",
+			fn:       UnixTimeFunc,
+			args:     []cty.Value{cty.StringVal("2024-01-01T00:00:00Z")},
+			expected: cty.NumberIntVal(1704067200),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := tc.fn.Call(tc.args)
+			if tc.expectErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.True(t, tc.expected.RawEquals(result), "Expected %#v, got %#v", tc.expected, result)
+		})
+	}
+}
+
+func TestParseTimeConditions(t *testing.T) {
+	logger := logs.NoOpLogger()
+
+	content := `on change_merged {
+  name = "business_hours"
+
+  if = (
+    formatdate("EEE", timezone(now(), "Europe/London")) != "Sat" &&
+    formatdate("EEE", timezone(now(), "Europe/London")) != "Sun" &&
+    tonumber(formatdate("h", timezone(now(), "Europe/London"))) >= 9 &&
+    tonumber(formatdate("h", timezone(now(), "Europe/London"))) < 17
+  )
+
+  call slack_post {
+    inputs = {
+      at = now()
+    }
+  }
+}
+`
+	hopsFiles := readTestHops(t, content)
+
+	eventData, err := os.ReadFile("./testdata/raw_change_event.json")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		now         time.Time
+		expectMatch bool
+	}{
+		{
+			name:        "Weekday during business hours",
+			now:         time.Date(2024, 7, 3, 10, 30, 0, 0, time.UTC), // Wednesday 11:30 in London
+			expectMatch: true,
+		},
+		{
+			name:        "Weekday before business hours in London",
+			now:         time.Date(2024, 7, 3, 7, 30, 0, 0, time.UTC), // Wednesday 08:30 in London
+			expectMatch: false,
+		},
+		{
+			name:        "Weekend",
+			now:         time.Date(2024, 7, 6, 10, 30, 0, 0, time.UTC), // Saturday
+			expectMatch: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := ContextWithClock(context.Background(), func() time.Time { return tc.now })
+
+			hop, err := ParseHops(ctx, hopsFiles, map[string][]byte{"event": eventData}, nil, logger)
+			require.NoError(t, err)
+			assert.Equal(t, tc.now, hop.StartedAt)
+
+			if !tc.expectMatch {
+				assert.Empty(t, hop.Ons, "Condition should not match")
+				return
+			}
+
+			require.Len(t, hop.Ons, 1, "Condition should match")
+			require.Len(t, hop.Ons[0].Calls, 1)
+			assert.JSONEq(t, `{"at": "`+tc.now.Format(time.RFC3339)+`"}`, string(hop.Ons[0].Calls[0].Inputs))
+		})
+	}
+}
@@ -1,14 +1,52 @@
 package dsl
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"regexp"
+	"sort"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/zclconf/go-cty/cty/function"
 )
 
 const MaxLabelLength = 50
 
+// Diagnostic severities, as returned by Validate
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
 var labelRegex = regexp.MustCompile(`^[a-z\d][a-z\d]*(?:_[a-z\d]+)*$`)
 
+type (
+	// Diagnostic is a problem found in hops files by Validate
+	Diagnostic struct {
+		// File is the file the problem is in, if known
+		File    string `json:"file,omitempty"`
+		Message string `json:"message"`
+		// Range is where in File the problem is, if known
+		Range    *DiagnosticRange `json:"range,omitempty"`
+		Severity string           `json:"severity"`
+	}
+
+	// DiagnosticRange is a span of a file, from Start up to (but excluding) End
+	DiagnosticRange struct {
+		End   DiagnosticPos `json:"end"`
+		Start DiagnosticPos `json:"start"`
+	}
+
+	// DiagnosticPos is a position in a file. Line and column start at 1, byte at 0.
+	DiagnosticPos struct {
+		Byte   int `json:"byte"`
+		Column int `json:"column"`
+		Line   int `json:"line"`
+	}
+)
+
 func ValidateLabels(labels ...string) error {
 	for _, label := range labels {
 		if len(label) > MaxLabelLength {
@@ -25,3 +63,146 @@ func ValidateLabels(labels ...string) error {
 
 	return nil
 }
+
+// Validate checks the content of hops files as it would be loaded, without
+// evaluating it against an event, returning every problem found, ordered by file
+// and position
+//
+// Files are checked against the schema, label rules and for duplicate slugs,
+// and their tasks and schedules are decoded. Unlike loading, every file and
+// block is checked even if others fail to parse. Nothing is persisted or run.
+func Validate(ctx context.Context, files []FileContent) []Diagnostic {
+	diags := hcl.Diagnostics{}
+	bodies := []hcl.Body{}
+	parser := hclparse.NewParser()
+
+	for _, file := range files {
+		if file.Type != HopsFile {
+			continue
+		}
+
+		hopsFile, d := parser.ParseHCL(file.Content, file.File)
+		diags = append(diags, d...)
+		if !d.HasErrors() {
+			bodies = append(bodies, hopsFile.Body)
+		}
+	}
+
+	content, d := hcl.MergeBodies(bodies).Content(HopSchema)
+	diags = append(diags, d...)
+
+	if len(content.Blocks) == 0 && !diags.HasErrors() {
+		diags = append(diags, &hcl.Diagnostic{
+			Severity: hcl.DiagWarning,
+			Summary:  "No on, task or schedule blocks are declared, so the hops files won't load",
+		})
+	}
+
+	hops := &HopsFiles{BodyContent: content, Files: files}
+
+	diags = append(diags, SummariseHops(hops).diags...)
+
+	// Blocks are decoded one at a time (rather than stopping at the first error,
+	// as when loading) so every problem is found, at its block if not more precise
+	evalctx := &hcl.EvalContext{
+		Functions: validateFunctions(),
+	}
+
+	taskHop := &HopAST{SlugRegister: map[string]bool{}}
+	for _, block := range content.Blocks.OfType(TaskID) {
+		err := DecodeTaskBlock(ctx, taskHop, block, blockEvalContext(evalctx, hops, block))
+		if err != nil {
+			diags = append(diags, diagnosticsFromError(err, block.DefRange)...)
+		}
+	}
+
+	scheduleHop := &HopAST{SlugRegister: map[string]bool{}}
+	for _, block := range content.Blocks.OfType(ScheduleID) {
+		err := DecodeScheduleBlock(block, scheduleHop, blockEvalContext(evalctx, hops, block))
+		if err != nil {
+			diags = append(diags, diagnosticsFromError(err, block.DefRange)...)
+		}
+	}
+
+	return newDiagnostics(diags)
+}
+
+// diagnosticsFromError returns the HCL diagnostics held by err, or a diagnostic
+// of err at rng if it holds none
+func diagnosticsFromError(err error, rng hcl.Range) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	if errors.As(err, &diags) {
+		return diags
+	}
+
+	var parseErr ParseError
+	if errors.As(err, &parseErr) {
+		return parseErr.Diagnostics
+	}
+
+	return hcl.Diagnostics{{
+		Severity: hcl.DiagError,
+		Summary:  err.Error(),
+		Subject:  rng.Ptr(),
+	}}
+}
+
+func newDiagnostics(diags hcl.Diagnostics) []Diagnostic {
+	diagnostics := []Diagnostic{}
+
+	for _, diag := range diags {
+		diagnostic := Diagnostic{
+			Message:  diag.Summary,
+			Severity: SeverityError,
+		}
+		if diag.Detail != "" {
+			diagnostic.Message = fmt.Sprintf("%s; %s", diag.Summary, diag.Detail)
+		}
+		if diag.Severity == hcl.DiagWarning {
+			diagnostic.Severity = SeverityWarning
+		}
+		if diag.Subject != nil {
+			diagnostic.File = diag.Subject.Filename
+			diagnostic.Range = &DiagnosticRange{
+				End:   DiagnosticPos{Byte: diag.Subject.End.Byte, Column: diag.Subject.End.Column, Line: diag.Subject.End.Line},
+				Start: DiagnosticPos{Byte: diag.Subject.Start.Byte, Column: diag.Subject.Start.Column, Line: diag.Subject.Start.Line},
+			}
+		}
+
+		diagnostics = append(diagnostics, diagnostic)
+	}
+
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		if diagnostics[i].File != diagnostics[j].File {
+			return diagnostics[i].File < diagnostics[j].File
+		}
+
+		return diagnostics[i].offset() < diagnostics[j].offset()
+	})
+
+	return diagnostics
+}
+
+// offset is the byte the diagnostic starts at, or -1 if its range is unknown
+func (d Diagnostic) offset() int {
+	if d.Range == nil {
+		return -1
+	}
+
+	return d.Range.Start.Byte
+}
+
+// validateFunctions returns the functions blocks are validated with
+//
+// These are the functions blocks load with, except env() returns its default.
+// Diagnostics are returned to whoever asked for validation, so must never
+// include the server's env vars (which may well be secrets).
+func validateFunctions() map[string]function.Function {
+	funcs := make(map[string]function.Function, len(StatelessFunctions))
+	for name, fn := range StatelessFunctions {
+		funcs[name] = fn
+	}
+	funcs["env"] = envDefaultFunc
+
+	return funcs
+}
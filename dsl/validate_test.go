@@ -0,0 +1,133 @@
+package dsl
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	type testCase struct {
+		name     string
+		files    map[string]string
+		expected []Diagnostic
+	}
+
+	tests := []testCase{
+		{
+			name: "Valid",
+			files: map[string]string{
+				"a/main.hops": `on push {
+  call github_comment {}
+}
+
+task deploy {}
+
+schedule nightly {
+  cron = "@daily"
+}
+`,
+			},
+			expected: []Diagnostic{},
+		},
+		{
+			name: "Every file is checked",
+			files: map[string]string{
+				"a/broken.hops": "on push {\n",
+				"b/main.hops":   "on push {\n  name = \"Bad Name\"\n  call github_comment {}\n}\n",
+			},
+			expected: []Diagnostic{
+				{File: "a/broken.hops", Severity: SeverityError},
+				{File: "b/main.hops", Severity: SeverityError},
+			},
+		},
+		{
+			name: "Duplicate slugs and invalid blocks",
+			files: map[string]string{
+				"a/main.hops": `on push {
+  name = "dupe"
+  call github_comment {}
+}
+
+on push {
+  name = "dupe"
+  call github_comment {}
+}
+
+schedule nightly {
+  cron = "never"
+}
+`,
+			},
+			expected: []Diagnostic{
+				{File: "a/main.hops", Severity: SeverityError, Range: &DiagnosticRange{Start: DiagnosticPos{Line: 6}}},
+				{File: "a/main.hops", Severity: SeverityError, Range: &DiagnosticRange{Start: DiagnosticPos{Line: 8}}},
+				{File: "a/main.hops", Severity: SeverityError, Range: &DiagnosticRange{Start: DiagnosticPos{Line: 11}}},
+			},
+		},
+		{
+			name: "Scalar inputs",
+			files: map[string]string{
+				"a/main.hops": `on push {
+  call github_comment {
+    inputs = "oops"
+  }
+
+  call raw_handler {
+    inputs        = ["fine"]
+    scalar_inputs = true
+  }
+
+  call app_handler {
+    inputs = event.payload
+  }
+}
+`,
+			},
+			expected: []Diagnostic{
+				{File: "a/main.hops", Severity: SeverityError, Range: &DiagnosticRange{Start: DiagnosticPos{Line: 3}}},
+			},
+		},
+		{
+			name: "Warnings",
+			files: map[string]string{
+				"a/main.hops": "on push {}\n\non pull_request {\n  on_error {\n    call app_handler {}\n  }\n}\n",
+			},
+			expected: []Diagnostic{
+				{File: "a/main.hops", Severity: SeverityWarning, Range: &DiagnosticRange{Start: DiagnosticPos{Line: 1}}},
+				{File: "a/main.hops", Severity: SeverityWarning, Range: &DiagnosticRange{Start: DiagnosticPos{Line: 3}}},
+			},
+		},
+		{
+			name:  "No blocks",
+			files: map[string]string{"a/main.hops": ""},
+			expected: []Diagnostic{
+				{Severity: SeverityWarning},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			files := []FileContent{}
+			for name, content := range tc.files {
+				files = append(files, FileContent{File: name, Content: []byte(content), Type: HopsFile})
+			}
+
+			diagnostics := Validate(context.Background(), files)
+			require.Len(t, diagnostics, len(tc.expected), "Diagnostics: %v", diagnostics)
+
+			for i, expected := range tc.expected {
+				diagnostic := diagnostics[i]
+				assert.Equal(t, expected.File, diagnostic.File)
+				assert.Equal(t, expected.Severity, diagnostic.Severity)
+				assert.NotEmpty(t, diagnostic.Message)
+				if expected.Range != nil && assert.NotNil(t, diagnostic.Range) {
+					assert.Equal(t, expected.Range.Start.Line, diagnostic.Range.Start.Line)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package dsl
+
+import (
+	"strings"
+
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	"github.com/zclconf/go-cty/cty/function"
+)
+
+// WebhookFunctions are optional helpers for reading common fields from the
+// webhook payloads of popular providers, saving workflow authors from navigating
+// the raw JSON themselves.
+//
+// They aren't available in hops configs by default, opt in with
+// RegisterFunctions(WebhookFunctions). Each takes the event and returns null if
+// the field isn't present, e.g.
+//
+//	if = github_branch(event) == "main"
+var WebhookFunctions = map[string]function.Function{
+	"github_branch":    GithubBranchFunc,
+	"github_pr_number": GithubPRNumberFunc,
+	"github_repo":      GithubRepoFunc,
+	"github_sender":    GithubSenderFunc,
+	"gitlab_branch":    GitlabBranchFunc,
+	"gitlab_mr_iid":    GitlabMRIIDFunc,
+	"gitlab_project":   GitlabProjectFunc,
+}
+
+// GithubBranchFunc returns the branch of a GitHub event. That's the head branch
+// of pull request events, or the pushed branch of push events.
+var GithubBranchFunc = webhookFunc(cty.String, func(event cty.Value) cty.Value {
+	branch := valueAtPath(event, "pull_request", "head", "ref")
+	if !branch.IsNull() {
+		return branch
+	}
+
+	return branchFromRef(valueAtPath(event, "ref"))
+})
+
+// GithubPRNumberFunc returns the pull request number of a GitHub event
+var GithubPRNumberFunc = webhookFunc(cty.Number, func(event cty.Value) cty.Value {
+	number := valueAtPath(event, "pull_request", "number")
+	if !number.IsNull() {
+		return number
+	}
+
+	// Pull request comments are sent as issue events, with the pull request
+	// identified by the issue
+	if valueAtPath(event, "issue", "pull_request").IsNull() {
+		return valueAtPath(event, "number")
+	}
+
+	return valueAtPath(event, "issue", "number")
+})
+
+// GithubRepoFunc returns the full name (owner/repo) of the repository of a GitHub event
+var GithubRepoFunc = webhookFunc(cty.String, func(event cty.Value) cty.Value {
+	return valueAtPath(event, "repository", "full_name")
+})
+
+// GithubSenderFunc returns the login of the user that triggered a GitHub event
+var GithubSenderFunc = webhookFunc(cty.String, func(event cty.Value) cty.Value {
+	return valueAtPath(event, "sender", "login")
+})
+
+// GitlabBranchFunc returns the branch of a GitLab event. That's the source branch
+// of merge request events, or the pushed branch of push events.
+var GitlabBranchFunc = webhookFunc(cty.String, func(event cty.Value) cty.Value {
+	if isGitlabMergeRequest(event) {
+		return valueAtPath(event, "object_attributes", "source_branch")
+	}
+
+	return branchFromRef(valueAtPath(event, "ref"))
+})
+
+// GitlabMRIIDFunc returns the project level ID (iid) of the merge request of a GitLab event
+var GitlabMRIIDFunc = webhookFunc(cty.Number, func(event cty.Value) cty.Value {
+	if isGitlabMergeRequest(event) {
+		return valueAtPath(event, "object_attributes", "iid")
+	}
+
+	// Comments on merge requests carry the merge request separately
+	return valueAtPath(event, "merge_request", "iid")
+})
+
+// GitlabProjectFunc returns the full path (namespace/project) of the project of a GitLab event
+var GitlabProjectFunc = webhookFunc(cty.String, func(event cty.Value) cty.Value {
+	return valueAtPath(event, "project", "path_with_namespace")
+})
+
+// webhookFunc creates a function taking an event and returning a value of retType,
+// which is null if impl can't find the value it's looking for
+func webhookFunc(retType cty.Type, impl func(event cty.Value) cty.Value) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{
+			{
+				Name:      "event",
+				Type:      cty.DynamicPseudoType,
+				AllowNull: true,
+			},
+		},
+		Type: function.StaticReturnType(retType),
+		Impl: func(args []cty.Value, _ cty.Type) (cty.Value, error) {
+			val := impl(args[0])
+			if val.IsNull() {
+				return cty.NullVal(retType), nil
+			}
+
+			return convert.Convert(val, retType)
+		},
+	})
+}
+
+// valueAtPath returns the value at path within an object or map, or null if it doesn't exist
+func valueAtPath(obj cty.Value, path ...string) cty.Value {
+	val := obj
+	for _, key := range path {
+		if val.IsNull() || !val.IsKnown() {
+			return cty.NullVal(cty.DynamicPseudoType)
+		}
+
+		valType := val.Type()
+		switch {
+		case valType.IsObjectType() && valType.HasAttribute(key):
+			val = val.GetAttr(key)
+		case valType.IsMapType() && val.HasIndex(cty.StringVal(key)).True():
+			val = val.Index(cty.StringVal(key))
+		default:
+			return cty.NullVal(cty.DynamicPseudoType)
+		}
+	}
+
+	if !val.IsKnown() {
+		return cty.NullVal(cty.DynamicPseudoType)
+	}
+
+	return val
+}
+
+// branchFromRef returns the branch name from a git ref, or null if the ref isn't a branch
+func branchFromRef(ref cty.Value) cty.Value {
+	if ref.IsNull() || ref.Type() != cty.String {
+		return cty.NullVal(cty.String)
+	}
+
+	branch, ok := strings.CutPrefix(ref.AsString(), "refs/heads/")
+	if !ok {
+		return cty.NullVal(cty.String)
+	}
+
+	return cty.StringVal(branch)
+}
+
+func isGitlabMergeRequest(event cty.Value) bool {
+	kind := valueAtPath(event, "object_kind")
+	return !kind.IsNull() && kind.Type() == cty.String && kind.AsString() == "merge_request"
+}
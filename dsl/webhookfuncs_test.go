@@ -0,0 +1,131 @@
+package dsl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+)
+
+func TestWebhookFunctions(t *testing.T) {
+	tests := []struct {
+		name     string
+		funcName string
+		event    string
+		expected cty.Value
+	}{
+		{
+			name:     "GitHub repo",
+			funcName: "github_repo",
+			event:    `{"repository": {"full_name": "hiphops-io/hops"}}`,
+			expected: cty.StringVal("hiphops-io/hops"),
+		},
+		{
+			name:     "GitHub pull request number",
+			funcName: "github_pr_number",
+			event:    `{"number": 12, "pull_request": {"number": 12}}`,
+			expected: cty.NumberIntVal(12),
+		},
+		{
+			name:     "GitHub pull request number from comment",
+			funcName: "github_pr_number",
+			event:    `{"issue": {"number": 7, "pull_request": {"url": "https://example.com"}}}`,
+			expected: cty.NumberIntVal(7),
+		},
+		{
+			name:     "GitHub issue comment has no pull request number",
+			funcName: "github_pr_number",
+			event:    `{"issue": {"number": 7}}`,
+			expected: cty.NullVal(cty.Number),
+		},
+		{
+			name:     "GitHub pull request branch",
+			funcName: "github_branch",
+			event:    `{"ref": "refs/heads/other", "pull_request": {"head": {"ref": "feature"}}}`,
+			expected: cty.StringVal("feature"),
+		},
+		{
+			name:     "GitHub push branch",
+			funcName: "github_branch",
+			event:    `{"ref": "refs/heads/main"}`,
+			expected: cty.StringVal("main"),
+		},
+		{
+			name:     "GitHub tag push has no branch",
+			funcName: "github_branch",
+			event:    `{"ref": "refs/tags/v1.0.0"}`,
+			expected: cty.NullVal(cty.String),
+		},
+		{
+			name:     "GitHub sender",
+			funcName: "github_sender",
+			event:    `{"sender": {"login": "octocat"}}`,
+			expected: cty.StringVal("octocat"),
+		},
+		{
+			name:     "GitLab project",
+			funcName: "gitlab_project",
+			event:    `{"project": {"path_with_namespace": "hiphops/hops"}}`,
+			expected: cty.StringVal("hiphops/hops"),
+		},
+		{
+			name:     "GitLab merge request iid",
+			funcName: "gitlab_mr_iid",
+			event:    `{"object_kind": "merge_request", "object_attributes": {"iid": 3, "source_branch": "feature"}}`,
+			expected: cty.NumberIntVal(3),
+		},
+		{
+			name:     "GitLab merge request iid from comment",
+			funcName: "gitlab_mr_iid",
+			event:    `{"object_kind": "note", "object_attributes": {"id": 99}, "merge_request": {"iid": 3}}`,
+			expected: cty.NumberIntVal(3),
+		},
+		{
+			name:     "GitLab merge request branch",
+			funcName: "gitlab_branch",
+			event:    `{"object_kind": "merge_request", "object_attributes": {"iid": 3, "source_branch": "feature"}}`,
+			expected: cty.StringVal("feature"),
+		},
+		{
+			name:     "GitLab push branch",
+			funcName: "gitlab_branch",
+			event:    `{"object_kind": "push", "ref": "refs/heads/main"}`,
+			expected: cty.StringVal("main"),
+		},
+		{
+			name:     "Missing field",
+			funcName: "github_repo",
+			event:    `{"repository": "not-an-object"}`,
+			expected: cty.NullVal(cty.String),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			event, err := AnyJSONToCtyValue([]byte(tc.event))
+			require.NoError(t, err, "Test setup: Event should convert to cty value")
+
+			val, err := WebhookFunctions[tc.funcName].Call([]cty.Value{event})
+			require.NoError(t, err)
+			assert.True(t, tc.expected.RawEquals(val), "Expected %#v, got %#v", tc.expected, val)
+		})
+	}
+}
+
+func TestRegisterFunctions(t *testing.T) {
+	err := RegisterFunctions(map[string]function.Function{
+		"github_repo": GithubRepoFunc,
+		"abs":         GithubRepoFunc,
+	})
+	assert.Error(t, err, "Functions should not replace existing functions")
+	assert.NotEqual(t, GithubRepoFunc, StatelessFunctions["abs"])
+
+	err = RegisterFunctions(WebhookFunctions)
+	require.NoError(t, err, "Webhook functions should be registered")
+	assert.Equal(t, GithubRepoFunc, StatelessFunctions["github_repo"])
+
+	err = RegisterFunctions(WebhookFunctions)
+	assert.NoError(t, err, "Registering the same functions again should be a no-op")
+}
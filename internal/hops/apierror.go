@@ -0,0 +1,104 @@
+package hops
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/goccy/go-json"
+
+	"github.com/hiphops-io/hops/logs"
+)
+
+// Codes of error responses, alongside those of auth (e.g. AuthCodeInvalidCredentials)
+const (
+	ErrorCodeBadRequest       = "bad_request"
+	ErrorCodeConflict         = "conflict"
+	ErrorCodeForbidden        = "forbidden"
+	ErrorCodeInternal         = "internal_error"
+	ErrorCodeInvalidInputs    = "invalid_inputs"
+	ErrorCodeMethodNotAllowed = "method_not_allowed"
+	ErrorCodeNotFound         = "not_found"
+	ErrorCodePayloadTooLarge  = "payload_too_large"
+	ErrorCodeRateLimited      = "rate_limited"
+	ErrorCodeUnavailable      = "unavailable"
+)
+
+type (
+	// ErrorResponse is the body of every error response from the API, e.g.
+	//
+	//	{"error": {"code": "not_found", "message": "Task 'x' not found", "details": []}}
+	ErrorResponse struct {
+		Error ErrorBody `json:"error"`
+	}
+
+	// ErrorBody describes an error. Code is stable for clients to match on, whilst
+	// message is for people and may change.
+	ErrorBody struct {
+		Code    string        `json:"code"`
+		Message string        `json:"message"`
+		Details []ErrorDetail `json:"details"`
+		// RequestID is the ID of the request that errored, as logged by the server
+		RequestID string `json:"request_id,omitempty"`
+	}
+
+	// ErrorDetail is one of the problems causing an error, e.g. an invalid task input
+	ErrorDetail struct {
+		Field   string `json:"field,omitempty"`
+		Message string `json:"message"`
+	}
+
+	// apiError is an error response yet to be written, see writeError
+	apiError struct {
+		code    string
+		details []ErrorDetail
+		message string
+		status  int
+	}
+)
+
+// writeError responds with status and an ErrorResponse, including the ID of
+// the request if it has one (see logs.RequestIDMiddleware)
+func writeError(w http.ResponseWriter, r *http.Request, status int, code string, message string, details ...ErrorDetail) {
+	body := ErrorBody{
+		Code:    code,
+		Message: message,
+		Details: append([]ErrorDetail{}, details...),
+	}
+	body.RequestID, _ = logs.RequestIDFromContext(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: body})
+}
+
+func (e *apiError) write(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, e.status, e.code, e.message, e.details...)
+}
+
+// fieldErrorDetails lists the messages of each field as details, ordered by field
+func fieldErrorDetails(fieldErrors map[string][]string) []ErrorDetail {
+	fields := make([]string, 0, len(fieldErrors))
+	for field := range fieldErrors {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	details := []ErrorDetail{}
+	for _, field := range fields {
+		for _, message := range fieldErrors[field] {
+			details = append(details, ErrorDetail{Field: field, Message: message})
+		}
+	}
+
+	return details
+}
+
+// notFound responds to requests for routes that don't exist
+func notFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusNotFound, ErrorCodeNotFound, "Not found")
+}
+
+// methodNotAllowed responds to requests for routes that don't accept the method
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+}
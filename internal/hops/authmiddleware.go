@@ -0,0 +1,116 @@
+package hops
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+const (
+	// AuthCodeInvalidCredentials is returned when a request's credentials are rejected
+	AuthCodeInvalidCredentials = "invalid_credentials"
+	// AuthCodeMissingCredentials is returned when a request has no credentials
+	AuthCodeMissingCredentials = "missing_credentials"
+)
+
+var (
+	// ErrInvalidCredentials is returned by an AuthValidator that rejects a request's credentials
+	ErrInvalidCredentials = errors.New("Invalid credentials")
+	// ErrMissingCredentials is returned by an AuthValidator that finds no credentials
+	// it understands on a request, so another validator may check it instead
+	ErrMissingCredentials = errors.New("Missing credentials")
+)
+
+type (
+	// AuthValidator authenticates requests by their credentials
+	AuthValidator interface {
+		// Validate returns nil if r is authenticated, ErrMissingCredentials if r has
+		// no credentials for the validator, or another error if they're rejected
+		Validate(r *http.Request) error
+	}
+
+	// BearerTokenValidator authenticates requests with any of a set of static
+	// bearer tokens, so tokens can be rotated by allowing the old and new at once
+	BearerTokenValidator struct {
+		tokens [][]byte
+	}
+)
+
+// NewBearerTokenValidator creates a BearerTokenValidator accepting any of tokens,
+// ignoring any that are empty
+func NewBearerTokenValidator(tokens ...string) *BearerTokenValidator {
+	b := &BearerTokenValidator{}
+
+	for _, token := range tokens {
+		token = strings.TrimSpace(token)
+		if token == "" {
+			continue
+		}
+
+		b.tokens = append(b.tokens, []byte(token))
+	}
+
+	return b
+}
+
+func (b *BearerTokenValidator) Validate(r *http.Request) error {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ErrMissingCredentials
+	}
+
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return ErrMissingCredentials
+	}
+
+	// Every token is compared in constant time, so timing doesn't reveal them
+	valid := 0
+	for _, allowed := range b.tokens {
+		valid |= subtle.ConstantTimeCompare([]byte(token), allowed)
+	}
+	if valid != 1 {
+		return ErrInvalidCredentials
+	}
+
+	return nil
+}
+
+// Auth rejects requests with 401 Unauthorized unless one of validators
+// authenticates them, responding with JSON including a machine-readable code
+//
+// Validators are tried in order, with requests allowed as soon as one accepts
+// them.
+func Auth(validators ...AuthValidator) func(http.Handler) http.Handler {
+	f := func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			code := AuthCodeMissingCredentials
+
+			for _, validator := range validators {
+				err := validator.Validate(r)
+				if err == nil {
+					h.ServeHTTP(w, r)
+					return
+				}
+				if !errors.Is(err, ErrMissingCredentials) {
+					code = AuthCodeInvalidCredentials
+				}
+			}
+
+			writeAuthError(w, r, code)
+		}
+		return http.HandlerFunc(fn)
+	}
+	return f
+}
+
+func writeAuthError(w http.ResponseWriter, r *http.Request, code string) {
+	message := ErrMissingCredentials.Error()
+	if code == AuthCodeInvalidCredentials {
+		message = ErrInvalidCredentials.Error()
+	}
+
+	w.Header().Set("WWW-Authenticate", `Bearer realm="hops"`)
+	writeError(w, r, http.StatusUnauthorized, code, message)
+}
@@ -0,0 +1,68 @@
+package hops
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuth(t *testing.T) {
+	handler := Auth(NewBearerTokenValidator("old-token", "new-token"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+		code          string
+	}{
+		{name: "Missing token", status: http.StatusUnauthorized, code: AuthCodeMissingCredentials},
+		{name: "Other scheme", authorization: "Basic dXNlcjpwYXNz", status: http.StatusUnauthorized, code: AuthCodeMissingCredentials},
+		{name: "Empty token", authorization: "Bearer ", status: http.StatusUnauthorized, code: AuthCodeMissingCredentials},
+		{name: "Wrong token", authorization: "Bearer guessed-token", status: http.StatusUnauthorized, code: AuthCodeInvalidCredentials},
+		{name: "Valid token", authorization: "Bearer new-token", status: http.StatusOK},
+		{name: "Rotated token", authorization: "Bearer old-token", status: http.StatusOK},
+		{name: "Case insensitive scheme", authorization: "bearer new-token", status: http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/tasks/mytask", nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			require.Equal(t, tc.status, rec.Code)
+			if tc.code == "" {
+				return
+			}
+
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			assert.NotEmpty(t, rec.Header().Get("WWW-Authenticate"))
+
+			response := ErrorResponse{}
+			err := json.Unmarshal(rec.Body.Bytes(), &response)
+			require.NoError(t, err, "Response should be valid JSON")
+			assert.Equal(t, tc.code, response.Error.Code)
+			assert.NotEmpty(t, response.Error.Message)
+		})
+	}
+}
+
+func TestBearerTokenValidatorNoTokens(t *testing.T) {
+	validator := NewBearerTokenValidator("", " ")
+
+	req := httptest.NewRequest(http.MethodGet, "/tasks", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	assert.ErrorIs(t, validator.Validate(req), ErrMissingCredentials)
+
+	req.Header.Set("Authorization", "Bearer anything")
+	assert.ErrorIs(t, validator.Validate(req), ErrInvalidCredentials, "Empty tokens should never be accepted")
+}
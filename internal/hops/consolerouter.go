@@ -1,59 +1,219 @@
 package hops
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"io"
 	"io/fs"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/hiphops-io/hops/assets"
 	"github.com/rs/zerolog"
 )
 
-type consoleController struct {
-	Logger     zerolog.Logger
-	PathPrefix string
-}
+var (
+	baseTagRegex = regexp.MustCompile(`(?i)<base\s[^>]*>`)
+	headTagRegex = regexp.MustCompile(`(?i)<head(\s[^>]*)?>`)
+)
+
+type (
+	// ConsoleConf sets where the console's UI assets are served from, defaulting
+	// to those embedded in the binary. At most one of Dir and Proxy may be set.
+	ConsoleConf struct {
+		// Dir serves the assets from a directory instead, e.g. a custom built UI
+		Dir string
+		// Proxy reverse proxies to a URL instead, e.g. a dev server when working on
+		// the UI. Paths under the console are appended to the URL's path.
+		Proxy string
+	}
+
+	consoleController struct {
+		Logger     zerolog.Logger
+		PathPrefix string
+	}
+)
 
 // The console router serves the single page app for the console.
 // It will serve the index.html file for any path that does not exist,
 // allowing client-side to handle routing
-func ConsoleRouter(logger zerolog.Logger) chi.Router {
+//
+// pathPrefix is the full path the router is mounted at (e.g. "/hops/console"),
+// which is given to the app as its base href. conf sets where the app is served
+// from, returning an error if the directory or URL it gives is invalid.
+func ConsoleRouter(logger zerolog.Logger, pathPrefix string, conf ConsoleConf) (chi.Router, error) {
 	r := chi.NewRouter()
 
 	controller := &consoleController{
 		Logger:     logger,
-		PathPrefix: "/console",
+		PathPrefix: pathPrefix,
 	}
 
-	r.HandleFunc("/*", controller.handle())
-	return r
-}
+	var handler http.HandlerFunc
+	switch {
+	case conf.Dir != "" && conf.Proxy != "":
+		return nil, errors.New("Console can be served from a directory or a proxy, not both")
+	case conf.Proxy != "":
+		target, err := url.Parse(conf.Proxy)
+		if err != nil || target.Host == "" || (target.Scheme != "http" && target.Scheme != "https") {
+			return nil, fmt.Errorf("Invalid console proxy URL '%s'", conf.Proxy)
+		}
 
-func (c *consoleController) loadContentDir() http.FileSystem {
-	content, err := fs.Sub(assets.Console, "console")
-	if err != nil {
-		c.Logger.Fatal().Msg("Unable to load console UI")
+		handler = controller.handleProxy(target)
+	case conf.Dir != "":
+		info, err := os.Stat(conf.Dir)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load console UI from '%s': %w", conf.Dir, err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("Unable to load console UI from '%s': not a directory", conf.Dir)
+		}
+
+		handler = controller.handle(os.DirFS(conf.Dir))
+	default:
+		content, err := fs.Sub(assets.Console, "console")
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load console UI: %w", err)
+		}
+
+		handler = controller.handle(content)
 	}
-	return http.FS(content)
+
+	r.HandleFunc("/*", handler)
+	return r, nil
 }
 
-func (c *consoleController) handle() func(http.ResponseWriter, *http.Request) {
-	content := c.loadContentDir()
-	fs := http.FileServer(content)
+func (c *consoleController) handle(content fs.FS) http.HandlerFunc {
+	fs := http.FileServer(http.FS(content))
 	statichandler := http.StripPrefix(c.PathPrefix, fs)
 
 	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case c.PathPrefix, c.PathPrefix + "/", c.PathPrefix + "/index.html":
+			c.serveIndex(w, r, content)
+			return
+		}
+
 		wt := &intercept404{ResponseWriter: w}
 		statichandler.ServeHTTP(wt, r)
 
 		if wt.statusCode == http.StatusNotFound {
-			r.URL.Path = c.PathPrefix
-			w.Header().Set("Content-Type", "text/html")
-			statichandler.ServeHTTP(w, r)
+			c.serveIndex(w, r, content)
 		}
 	}
 }
 
+// handleProxy reverse proxies to the app served at target, e.g. by a dev server.
+// Paths the target doesn't have are given its index, as with served assets.
+func (c *consoleController) handleProxy(target *url.URL) http.HandlerFunc {
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		director(r)
+		r.Host = target.Host
+		// HTML is read to set its base href, so mustn't be compressed
+		r.Header.Del("Accept-Encoding")
+	}
+	proxy.ModifyResponse = c.setProxiedBaseHref
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		c.Logger.Error().Err(err).Msg("Unable to proxy console request")
+		w.WriteHeader(http.StatusBadGateway)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, c.PathPrefix), "/")
+		r.URL.RawPath = ""
+
+		wt := &intercept404{ResponseWriter: w}
+		proxy.ServeHTTP(wt, r)
+
+		if wt.statusCode == http.StatusNotFound {
+			// The proxy adds to the headers already set for the 404, rather than replacing them
+			for key := range w.Header() {
+				w.Header().Del(key)
+			}
+
+			index := r.Clone(r.Context())
+			index.URL.Path = "/"
+			proxy.ServeHTTP(w, index)
+		}
+	}
+}
+
+// setProxiedBaseHref sets the base href of proxied HTML to the path prefix, as
+// serveIndex does for served assets
+func (c *consoleController) setProxiedBaseHref(resp *http.Response) error {
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		return nil
+	}
+
+	doc, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	doc = withBaseHref(doc, c.PathPrefix+"/")
+	resp.Body = io.NopCloser(bytes.NewReader(doc))
+	resp.ContentLength = int64(len(doc))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(doc)))
+
+	return nil
+}
+
+// serveIndex serves the app's index.html, with its base href set to the path prefix
+// so its relative URLs resolve when served behind a reverse proxy under a sub-path
+func (c *consoleController) serveIndex(w http.ResponseWriter, r *http.Request, content fs.FS) {
+	index, err := fs.ReadFile(content, "index.html")
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(withBaseHref(index, c.PathPrefix+"/"))
+}
+
+// withBaseHref sets the base href of a HTML document, replacing any existing base tag
+func withBaseHref(doc []byte, href string) []byte {
+	baseTag := []byte(`<base href="` + html.EscapeString(href) + `">`)
+
+	if baseTagRegex.Match(doc) {
+		return baseTagRegex.ReplaceAllLiteral(doc, baseTag)
+	}
+
+	head := headTagRegex.FindIndex(doc)
+	if head == nil {
+		return append(baseTag, doc...)
+	}
+
+	withBase := make([]byte, 0, len(doc)+len(baseTag))
+	withBase = append(withBase, doc[:head[1]]...)
+	withBase = append(withBase, baseTag...)
+	withBase = append(withBase, doc[head[1]:]...)
+
+	return withBase
+}
+
+// normaliseBasePath returns a base path with a leading slash and no trailing
+// slash, or an empty string for the root path
+func normaliseBasePath(basePath string) string {
+	basePath = strings.Trim(basePath, "/")
+	if basePath == "" {
+		return ""
+	}
+
+	return "/" + basePath
+}
+
 type intercept404 struct {
 	http.ResponseWriter
 	statusCode int
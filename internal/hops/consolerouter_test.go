@@ -0,0 +1,164 @@
+package hops
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hiphops-io/hops/logs"
+)
+
+const testConsoleIndex = `<html><head><title>Hops</title></head><body></body></html>`
+
+func TestConsoleRouter(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte(testConsoleIndex), 0644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "assets"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "assets", "app.js"), []byte("console.log('hops')"), 0644))
+
+	// A dev server serving the app under /console, as it's built
+	devServer := http.NewServeMux()
+	devServer.HandleFunc("/console/", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/console/":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(testConsoleIndex))
+		case "/console/assets/app.js":
+			w.Header().Set("Content-Type", "text/javascript")
+			w.Write([]byte("console.log('hops')"))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	proxied := httptest.NewServer(devServer)
+	defer proxied.Close()
+
+	type testCase struct {
+		name string
+		conf ConsoleConf
+	}
+
+	tests := []testCase{
+		{name: "Dir", conf: ConsoleConf{Dir: dir}},
+		{name: "Proxy", conf: ConsoleConf{Proxy: proxied.URL + "/console"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			router, err := ConsoleRouter(logs.NoOpLogger(), "/hops/console", tc.conf)
+			require.NoError(t, err)
+
+			get := func(path string) *httptest.ResponseRecorder {
+				rec := httptest.NewRecorder()
+				router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+				return rec
+			}
+
+			indexWithBase := `<html><head><base href="/hops/console/"><title>Hops</title></head><body></body></html>`
+
+			for _, path := range []string{"/hops/console", "/hops/console/", "/hops/console/tasks/deploy"} {
+				rec := get(path)
+				assert.Equal(t, http.StatusOK, rec.Code, path)
+				assert.Equal(t, indexWithBase, rec.Body.String(), "Index should be served for %s", path)
+				assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+			}
+
+			rec := get("/hops/console/assets/app.js")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "console.log('hops')", rec.Body.String())
+		})
+	}
+
+	t.Run("Embedded", func(t *testing.T) {
+		router, err := ConsoleRouter(logs.NoOpLogger(), "/console", ConsoleConf{})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/console/favicon.ico", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		file := filepath.Join(dir, "index.html")
+
+		for _, conf := range []ConsoleConf{
+			{Dir: dir, Proxy: proxied.URL},
+			{Dir: filepath.Join(dir, "missing")},
+			{Dir: file},
+			{Proxy: "localhost:5173"},
+			{Proxy: "ftp://localhost"},
+		} {
+			_, err := ConsoleRouter(logs.NoOpLogger(), "/console", conf)
+			assert.Error(t, err, "%+v should be invalid", conf)
+		}
+	})
+}
+
+func TestNormaliseBasePath(t *testing.T) {
+	type testCase struct {
+		name     string
+		basePath string
+		expected string
+	}
+
+	tests := []testCase{
+		{name: "Empty", basePath: "", expected: ""},
+		{name: "Root", basePath: "/", expected: ""},
+		{name: "No slashes", basePath: "hops", expected: "/hops"},
+		{name: "Trailing slash", basePath: "/hops/", expected: "/hops"},
+		{name: "Nested", basePath: "tools/hops", expected: "/tools/hops"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, normaliseBasePath(tc.basePath))
+		})
+	}
+}
+
+func TestWithBaseHref(t *testing.T) {
+	type testCase struct {
+		name     string
+		doc      string
+		href     string
+		expected string
+	}
+
+	tests := []testCase{
+		{
+			name:     "Inserted after head",
+			doc:      `<html><head lang="en"><title>Hops</title></head></html>`,
+			href:     "/hops/console/",
+			expected: `<html><head lang="en"><base href="/hops/console/"><title>Hops</title></head></html>`,
+		},
+		{
+			name:     "Existing base replaced",
+			doc:      `<html><head><base href="/console/" /></head></html>`,
+			href:     "/hops/console/",
+			expected: `<html><head><base href="/hops/console/"></head></html>`,
+		},
+		{
+			name:     "No head",
+			doc:      `<div></div>`,
+			href:     "/console/",
+			expected: `<base href="/console/"><div></div>`,
+		},
+		{
+			name:     "Href escaped",
+			doc:      `<head></head>`,
+			href:     `/"hops"/`,
+			expected: `<head><base href="/&#34;hops&#34;/"></head>`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, string(withBaseHref([]byte(tc.doc), tc.href)))
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package hops
+
+import (
+	"net/http"
+
+	"github.com/go-chi/cors"
+
+	"github.com/hiphops-io/hops/logs"
+)
+
+var (
+	// DefaultCORSAllowedHeaders are the request headers cross-origin clients may send by default
+	DefaultCORSAllowedHeaders = []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", logs.RequestIDHeader}
+	// DefaultCORSAllowedMethods are the methods cross-origin clients may use by default
+	DefaultCORSAllowedMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
+	// DefaultCORSAllowedOrigins only allows cross-origin requests from localhost on any port
+	DefaultCORSAllowedOrigins = []string{
+		"http://localhost",
+		"http://localhost:*",
+		"http://127.0.0.1",
+		"http://127.0.0.1:*",
+	}
+)
+
+// CORSConf configures which cross-origin clients may call the HTTP server
+type CORSConf struct {
+	// AllowAll allows requests from any origin, for local development only.
+	// Credentials can't be allowed from every origin, so AllowCredentials is ignored.
+	AllowAll         bool
+	AllowCredentials bool
+	// AllowedHeaders are the request headers allowed (empty uses DefaultCORSAllowedHeaders)
+	AllowedHeaders []string
+	// AllowedMethods are the methods allowed (empty uses DefaultCORSAllowedMethods)
+	AllowedMethods []string
+	// AllowedOrigins are the origins allowed, each with up to one '*' wildcard
+	// (empty uses DefaultCORSAllowedOrigins)
+	AllowedOrigins []string
+}
+
+// CORS applies the CORS policy of conf to requests, rejecting preflight requests
+// from disallowed origins with 403 Forbidden
+//
+// Requests that aren't preflights are passed on either way, without CORS headers
+// if disallowed, leaving browsers to withhold the response from the client.
+func CORS(conf CORSConf) func(http.Handler) http.Handler {
+	options := cors.Options{
+		AllowedHeaders:     conf.AllowedHeaders,
+		AllowedMethods:     conf.AllowedMethods,
+		AllowedOrigins:     conf.AllowedOrigins,
+		AllowCredentials:   conf.AllowCredentials,
+		ExposedHeaders:     []string{"Link", logs.RequestIDHeader},
+		MaxAge:             300,
+		OptionsPassthrough: true,
+	}
+
+	if len(options.AllowedHeaders) == 0 {
+		options.AllowedHeaders = DefaultCORSAllowedHeaders
+	}
+	if len(options.AllowedMethods) == 0 {
+		options.AllowedMethods = DefaultCORSAllowedMethods
+	}
+	if len(options.AllowedOrigins) == 0 {
+		options.AllowedOrigins = DefaultCORSAllowedOrigins
+	}
+	if conf.AllowAll {
+		options.AllowedOrigins = []string{"*"}
+		options.AllowCredentials = false
+	}
+
+	c := cors.New(options)
+
+	f := func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if !isPreflight(r) {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			// The origin, method and headers were all allowed if the preflight was answered
+			if w.Header().Get("Access-Control-Allow-Origin") == "" {
+				writeError(w, r, http.StatusForbidden, ErrorCodeForbidden, "Cross origin request not allowed")
+				return
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return c.Handler(http.HandlerFunc(fn))
+	}
+	return f
+}
+
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+}
@@ -0,0 +1,116 @@
+package hops
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORS(t *testing.T) {
+	tests := []struct {
+		name                string
+		conf                CORSConf
+		method              string
+		origin              string
+		requestMethod       string
+		expectedStatus      int
+		expectedAllowOrigin string
+		expectedCredentials string
+	}{
+		{
+			name:                "Default allows localhost preflight",
+			method:              http.MethodOptions,
+			origin:              "http://localhost:3000",
+			requestMethod:       http.MethodPost,
+			expectedStatus:      http.StatusNoContent,
+			expectedAllowOrigin: "http://localhost:3000",
+		},
+		{
+			name:           "Default rejects remote preflight",
+			method:         http.MethodOptions,
+			origin:         "https://evil.example.com",
+			requestMethod:  http.MethodPost,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Default serves remote request without CORS headers",
+			method:         http.MethodGet,
+			origin:         "https://evil.example.com",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:                "Configured origin wildcard",
+			conf:                CORSConf{AllowedOrigins: []string{"https://*.example.com"}},
+			method:              http.MethodOptions,
+			origin:              "https://console.example.com",
+			requestMethod:       http.MethodPost,
+			expectedStatus:      http.StatusNoContent,
+			expectedAllowOrigin: "https://console.example.com",
+		},
+		{
+			name:           "Configured origins replace localhost",
+			conf:           CORSConf{AllowedOrigins: []string{"https://*.example.com"}},
+			method:         http.MethodOptions,
+			origin:         "http://localhost:3000",
+			requestMethod:  http.MethodPost,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Disallowed method",
+			conf:           CORSConf{AllowedMethods: []string{http.MethodGet}},
+			method:         http.MethodOptions,
+			origin:         "http://localhost:3000",
+			requestMethod:  http.MethodPost,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:                "Credentials",
+			conf:                CORSConf{AllowCredentials: true},
+			method:              http.MethodOptions,
+			origin:              "http://localhost:3000",
+			requestMethod:       http.MethodPost,
+			expectedStatus:      http.StatusNoContent,
+			expectedAllowOrigin: "http://localhost:3000",
+			expectedCredentials: "true",
+		},
+		{
+			name:                "Allow all ignores credentials",
+			conf:                CORSConf{AllowAll: true, AllowCredentials: true},
+			method:              http.MethodOptions,
+			origin:              "https://evil.example.com",
+			requestMethod:       http.MethodPost,
+			expectedStatus:      http.StatusNoContent,
+			expectedAllowOrigin: "*",
+		},
+		{
+			name:                "Allowed actual request",
+			method:              http.MethodGet,
+			origin:              "http://127.0.0.1:8916",
+			expectedStatus:      http.StatusOK,
+			expectedAllowOrigin: "http://127.0.0.1:8916",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := CORS(tc.conf)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(tc.method, "/tasks/mytask", nil)
+			req.Header.Set("Origin", tc.origin)
+			if tc.requestMethod != "" {
+				req.Header.Set("Access-Control-Request-Method", tc.requestMethod)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.expectedStatus, rec.Code)
+			assert.Equal(t, tc.expectedAllowOrigin, rec.Header().Get("Access-Control-Allow-Origin"))
+			assert.Equal(t, tc.expectedCredentials, rec.Header().Get("Access-Control-Allow-Credentials"))
+		})
+	}
+}
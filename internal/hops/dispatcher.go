@@ -0,0 +1,203 @@
+package hops
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/rs/zerolog"
+
+	"github.com/hiphops-io/hops/dsl"
+	"github.com/hiphops-io/hops/logs"
+	"github.com/hiphops-io/hops/nats"
+)
+
+var (
+	_ Dispatcher = (*LoggingDispatcher)(nil)
+	_ Dispatcher = (*PublishDispatcher)(nil)
+	_ Dispatcher = (*ShadowDispatcher)(nil)
+)
+
+type (
+	// Dispatcher decides what dispatching a call means, e.g. publishing it as a
+	// request for a worker to handle
+	//
+	// The runner calls Dispatch concurrently for each call of an on block, bounding
+	// each by its dispatch timeout. Any error naks the message being processed so
+	// the sequence is re-evaluated, at which point calls that were dispatched are
+	// skipped. Dispatchers therefore needn't retry, though they may.
+	//
+	// The context carries the call's metadata, retrievable via CallMetaFromContext,
+	// and the sequence's logger, retrievable via zerolog.Ctx.
+	Dispatcher interface {
+		Dispatch(ctx context.Context, sequenceId string, call dsl.CallAST) error
+	}
+
+	// LoggingDispatcher logs the calls it's given rather than dispatching them,
+	// with the values of sensitive input keys redacted
+	LoggingDispatcher struct {
+		redactor *logs.Redactor
+	}
+
+	// PublishDispatcher publishes calls to the request channel, to be handled by
+	// workers. This is the runner's default dispatcher.
+	//
+	// Publishes are retried with backoff, so transient failures (e.g. stream leader
+	// elections) don't fail the whole message.
+	PublishDispatcher struct {
+		publisher publisher
+	}
+
+	// ShadowDispatcher logs calls as LoggingDispatcher does, also publishing a
+	// record of each to a shadow subject, so what a dry run would have dispatched
+	// can be compared with live traffic
+	ShadowDispatcher struct {
+		logging   *LoggingDispatcher
+		publisher shadowPublisher
+		subject   string
+	}
+
+	// publisher publishes call requests, allowing tests to swap in a flaky client
+	publisher interface {
+		PublishCall(ctx context.Context, data []byte, meta nats.CallMeta, subjTokens ...string) (*jetstream.PubAck, bool, error)
+	}
+
+	// shadowPublisher publishes records of the calls a dry run would have dispatched
+	shadowPublisher interface {
+		PublishShadow(ctx context.Context, shadowSubject string, wouldDispatch nats.WouldDispatchMsg, meta nats.CallMeta, sequenceId string, callSlug string) error
+	}
+
+	callMetaCtxKey struct{}
+)
+
+// NewLoggingDispatcher returns a LoggingDispatcher redacting inputs with redactor,
+// or with the default sensitive keys if redactor is nil
+func NewLoggingDispatcher(redactor *logs.Redactor) *LoggingDispatcher {
+	if redactor == nil {
+		redactor = logs.NewRedactor()
+	}
+
+	return &LoggingDispatcher{redactor: redactor}
+}
+
+// NewPublishDispatcher returns a PublishDispatcher publishing with natsClient
+func NewPublishDispatcher(natsClient *nats.Client) *PublishDispatcher {
+	return &PublishDispatcher{publisher: natsClient}
+}
+
+// NewShadowDispatcher returns a ShadowDispatcher publishing records under subject
+// with natsClient, and logging calls with the values of sensitive input keys
+// redacted by redactor (or the default sensitive keys if nil)
+func NewShadowDispatcher(natsClient *nats.Client, subject string, redactor *logs.Redactor) *ShadowDispatcher {
+	return &ShadowDispatcher{
+		logging:   NewLoggingDispatcher(redactor),
+		publisher: natsClient,
+		subject:   subject,
+	}
+}
+
+// Dispatch logs the call at info level, along with its subject and inputs
+func (l *LoggingDispatcher) Dispatch(ctx context.Context, sequenceId string, call dsl.CallAST) error {
+	subjTokens, err := callSubjectTokens(sequenceId, call)
+	if err != nil {
+		return err
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Bool("dry_run", true).
+		Strs("subject_tokens", subjTokens).
+		RawJSON("inputs", l.redactor.RedactJSON(call.Inputs)).
+		Msgf("Would dispatch call: %s", call.Slug)
+
+	return nil
+}
+
+// Dispatch publishes the call, retrying up to dispatchAttempts times
+//
+// Stops once ctx is done. Duplicates mean the call has already been dispatched,
+// so aren't failures and are never retried.
+func (p *PublishDispatcher) Dispatch(ctx context.Context, sequenceId string, call dsl.CallAST) error {
+	subjTokens, err := callSubjectTokens(sequenceId, call)
+	if err != nil {
+		return err
+	}
+
+	callMeta, _ := CallMetaFromContext(ctx)
+	logger := zerolog.Ctx(ctx)
+	backoff := dispatchBackoff
+
+	for attempt := 1; ; attempt++ {
+		_, _, err := p.publisher.PublishCall(ctx, call.Inputs, callMeta, subjTokens...)
+		if err == nil || attempt >= dispatchAttempts || ctx.Err() != nil {
+			return err
+		}
+
+		logger.Warn().Err(err).Int("attempt", attempt).Msgf("Unable to dispatch call, retrying: %s", call.Slug)
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return errors.Join(ctx.Err(), err)
+		}
+	}
+}
+
+// Dispatch logs the call, then publishes a record of it to the shadow subject
+//
+// Records hold the call's inputs unredacted, as requests would.
+func (s *ShadowDispatcher) Dispatch(ctx context.Context, sequenceId string, call dsl.CallAST) error {
+	err := s.logging.Dispatch(ctx, sequenceId, call)
+	if err != nil {
+		return err
+	}
+
+	app, handler, err := callTarget(call)
+	if err != nil {
+		return err
+	}
+
+	callMeta, _ := CallMetaFromContext(ctx)
+	wouldDispatch := nats.NewWouldDispatchMsg(app, handler, call.Inputs)
+
+	err = s.publisher.PublishShadow(ctx, s.subject, wouldDispatch, callMeta, sequenceId, call.Slug)
+	if err != nil {
+		return fmt.Errorf("Unable to publish shadow record of call %s: %w", call.Slug, err)
+	}
+
+	return nil
+}
+
+// CallMetaFromContext returns the metadata of the call being dispatched, if any
+func CallMetaFromContext(ctx context.Context) (nats.CallMeta, bool) {
+	callMeta, ok := ctx.Value(callMetaCtxKey{}).(nats.CallMeta)
+	return callMeta, ok
+}
+
+// ContextWithCallMeta returns a copy of ctx carrying the metadata of a call
+func ContextWithCallMeta(ctx context.Context, callMeta nats.CallMeta) context.Context {
+	return context.WithValue(ctx, callMetaCtxKey{}, callMeta)
+}
+
+// callSubjectTokens returns the subject tokens of a call's request message
+func callSubjectTokens(sequenceId string, call dsl.CallAST) ([]string, error) {
+	app, handler, err := callTarget(call)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{nats.ChannelRequest, sequenceId, call.Slug, app, handler}, nil
+}
+
+// callTarget returns the app and handler a call is for
+func callTarget(call dsl.CallAST) (string, string, error) {
+	app, handler, found := strings.Cut(call.TaskType, "_")
+	if !found {
+		return "", "", fmt.Errorf("Unable to parse app/handler from call %s", call.Name)
+	}
+
+	return app, handler, nil
+}
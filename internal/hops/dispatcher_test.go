@@ -0,0 +1,225 @@
+package hops
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hiphops-io/hops/dsl"
+	"github.com/hiphops-io/hops/logs"
+	"github.com/hiphops-io/hops/nats"
+	"github.com/hiphops-io/hops/nats/natstest"
+)
+
+func TestRunnerWithLoggingDispatcher(t *testing.T) {
+	ctx := context.Background()
+	natsClient, _ := setupRunnerClient(t)
+
+	// The NATS test setup disables logging globally, so re-enable it for this test
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	defer zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	var logBuf bytes.Buffer
+	logger := zerolog.New(&logBuf)
+
+	hopsDir := t.TempDir()
+	content, err := os.ReadFile("./testdata/simple.hops")
+	require.NoError(t, err, "Test setup: Should read hops file")
+	err = os.WriteFile(testHopsPath(t, hopsDir), content, 0o644)
+	require.NoError(t, err, "Test setup: Should write hops file")
+
+	hopsLoader, err := NewHopsFileLoader(hopsDir, false)
+	require.NoError(t, err, "Test setup: Hops files should load without error")
+
+	runner, err := NewRunner(natsClient, hopsLoader, logger, WithDispatcher(NewLoggingDispatcher(nil)))
+	require.NoError(t, err, "Test setup: Runner should initialise without error")
+
+	eventData, err := os.ReadFile("./testdata/source_testevent.json")
+	require.NoError(t, err, "Test setup: Should read source event")
+
+	requests, err := natsClient.NatsConn.SubscribeSync(nats.RequestFilterSubject(natsClient.AccountId(), natsClient.InterestTopic()))
+	require.NoError(t, err, "Test setup: Should subscribe to requests")
+	defer requests.Unsubscribe()
+
+	err = runner.SequenceCallback(ctx, "SEQ_ID", nats.MessageBundle{"event": eventData})
+	require.NoError(t, err, "Sequence should be processed without error")
+
+	err = natsClient.NatsConn.Flush()
+	require.NoError(t, err)
+
+	pending, _, err := requests.Pending()
+	require.NoError(t, err)
+	assert.Zero(t, pending, "The dispatcher should decide what dispatching means, so nothing is published")
+
+	assert.Contains(t, logBuf.String(), "Would dispatch call: simple_pipeline-should_dispatch")
+	assert.Contains(t, logBuf.String(), `"sequence_id":"SEQ_ID"`, "Calls should be logged with the sequence's logger")
+}
+
+func TestRunnerDryRunShadowSubject(t *testing.T) {
+	ctx := context.Background()
+	logger := logs.NoOpLogger()
+	natsClient, _ := setupRunnerClient(t)
+
+	hopsDir := t.TempDir()
+	content, err := os.ReadFile("./testdata/simple.hops")
+	require.NoError(t, err, "Test setup: Should read hops file")
+	err = os.WriteFile(testHopsPath(t, hopsDir), content, 0o644)
+	require.NoError(t, err, "Test setup: Should write hops file")
+
+	hopsLoader, err := NewHopsFileLoader(hopsDir, false)
+	require.NoError(t, err, "Test setup: Hops files should load without error")
+
+	runner, err := NewRunner(natsClient, hopsLoader, logger, WithDryRun(), WithShadowSubject("shadow"))
+	require.NoError(t, err, "Test setup: Runner should initialise without error")
+
+	eventData, err := os.ReadFile("./testdata/source_testevent.json")
+	require.NoError(t, err, "Test setup: Should read source event")
+
+	requests, err := natsClient.NatsConn.SubscribeSync(nats.RequestFilterSubject(natsClient.AccountId(), natsClient.InterestTopic()))
+	require.NoError(t, err, "Test setup: Should subscribe to requests")
+	defer requests.Unsubscribe()
+
+	shadows, err := natsClient.NatsConn.SubscribeSync("shadow.>")
+	require.NoError(t, err, "Test setup: Should subscribe to shadow subject")
+	defer shadows.Unsubscribe()
+
+	err = runner.SequenceCallback(ctx, "SEQ_ID", nats.MessageBundle{"event": eventData})
+	require.NoError(t, err, "Sequence should be processed without error")
+
+	shadowMsg, err := shadows.NextMsg(time.Second)
+	require.NoError(t, err, "Shadow record should be published")
+	assert.Equal(t, "shadow.SEQ_ID.simple_pipeline-should_dispatch", shadowMsg.Subject)
+	assert.Equal(t, "simple_pipeline", shadowMsg.Header.Get(nats.OnHeader))
+
+	wouldDispatch := nats.WouldDispatchMsg{}
+	err = json.Unmarshal(shadowMsg.Data, &wouldDispatch)
+	require.NoError(t, err, "Shadow record should be valid")
+	assert.Equal(t, "app", wouldDispatch.App)
+	assert.Equal(t, "anything", wouldDispatch.Handler)
+	assert.JSONEq(t, `{"foo": "bar"}`, string(wouldDispatch.Inputs))
+
+	err = natsClient.NatsConn.Flush()
+	require.NoError(t, err)
+
+	pending, _, err := requests.Pending()
+	require.NoError(t, err)
+	assert.Zero(t, pending, "Dry runs should never publish to the request channel")
+
+	pending, _, err = shadows.Pending()
+	require.NoError(t, err)
+	assert.Zero(t, pending, "Only calls that would be dispatched should be recorded")
+}
+
+func TestRunnerDryRunOwnConsumer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger := logs.NoOpLogger()
+	natsClient, localNats := setupRunnerClient(t)
+	dryClient := natstest.NewClient(t, localNats, nats.WithDryRunner(nats.DefaultConsumerName))
+
+	hopsDir := t.TempDir()
+	content, err := os.ReadFile("./testdata/simple.hops")
+	require.NoError(t, err, "Test setup: Should read hops file")
+	err = os.WriteFile(testHopsPath(t, hopsDir), content, 0o644)
+	require.NoError(t, err, "Test setup: Should write hops file")
+
+	hopsLoader, err := NewHopsFileLoader(hopsDir, false)
+	require.NoError(t, err, "Test setup: Hops files should load without error")
+
+	runner, err := NewRunner(dryClient, hopsLoader, logger, WithDryRun())
+	require.NoError(t, err, "Test setup: Runner should initialise without error")
+
+	assert.Nil(t, runner.cron, "Dry runs should not publish scheduled events")
+	_, err = natsClient.GetSysObject(runner.hopsFiles.Hash)
+	assert.Error(t, err, "Dry runs should not store their hops config")
+
+	requests, err := natsClient.NatsConn.SubscribeSync(nats.RequestFilterSubject(natsClient.AccountId(), natsClient.InterestTopic()))
+	require.NoError(t, err, "Test setup: Should subscribe to requests")
+	defer requests.Unsubscribe()
+
+	go runner.Run(ctx, nats.DefaultConsumerName)
+
+	eventData, err := os.ReadFile("./testdata/source_testevent.json")
+	require.NoError(t, err, "Test setup: Should read source event")
+	_, _, err = natsClient.Publish(ctx, eventData, nats.ChannelNotify, "SEQ_ID", nats.SourceEventId)
+	require.NoError(t, err, "Test setup: Source event should be published")
+
+	dryConsumer := dryClient.Consumers[nats.DefaultConsumerName]
+	require.Eventually(t, func() bool {
+		info, err := dryConsumer.Info(ctx)
+		return err == nil && info.AckFloor.Stream > 0
+	}, 5*time.Second, 10*time.Millisecond, "Dry run should ack the event on its own consumer")
+
+	liveInfo, err := natsClient.Consumers[nats.DefaultConsumerName].Info(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, liveInfo.AckFloor.Stream, "Dry runs should not ack messages on the live runner's consumer")
+	assert.EqualValues(t, 1, liveInfo.NumPending, "The event should still be waiting for live runners")
+
+	err = natsClient.NatsConn.Flush()
+	require.NoError(t, err)
+
+	pending, _, err := requests.Pending()
+	require.NoError(t, err)
+	assert.Zero(t, pending, "Dry runs should never dispatch calls")
+}
+
+func TestLoggingDispatcher(t *testing.T) {
+	// Other tests disable logging globally, so re-enable it for this test
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	defer zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	tests := []struct {
+		name        string
+		call        dsl.CallAST
+		expectedLog []string
+		expectErr   bool
+	}{
+		{
+			name: "Logs call",
+			call: dsl.CallAST{Slug: "pipeline-post", TaskType: "slack_post", Inputs: []byte(`{"channel": "general"}`)},
+			expectedLog: []string{
+				"Would dispatch call: pipeline-post",
+				`"subject_tokens":["request","SEQ_ID","pipeline-post","slack","post"]`,
+				`"channel":"general"`,
+			},
+		},
+		{
+			name:        "Redacts inputs",
+			call:        dsl.CallAST{Slug: "pipeline-post", TaskType: "slack_post", Inputs: []byte(`{"token": "abc123"}`)},
+			expectedLog: []string{logs.RedactedValue},
+		},
+		{
+			name:      "Invalid task type",
+			call:      dsl.CallAST{Slug: "pipeline-post", TaskType: "slack", Inputs: []byte(`{}`)},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var logBuf bytes.Buffer
+			logger := zerolog.New(&logBuf)
+			ctx := logger.WithContext(context.Background())
+
+			err := NewLoggingDispatcher(nil).Dispatch(ctx, "SEQ_ID", tc.call)
+			if tc.expectErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			for _, expected := range tc.expectedLog {
+				assert.Contains(t, logBuf.String(), expected)
+			}
+			assert.NotContains(t, logBuf.String(), "abc123", "Sensitive inputs should not be logged")
+		})
+	}
+}
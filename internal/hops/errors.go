@@ -1,11 +1,153 @@
 package hops
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/go-multierror"
+
+	"github.com/hiphops-io/hops/dsl"
+)
 
 type ErrFailedHopsParse struct {
-	message string
+	err error
 }
 
 func (e ErrFailedHopsParse) Error() string {
-	return fmt.Sprintf("Unable to parse hops: %s", e.message)
+	return fmt.Sprintf("Unable to parse hops: %s", e.err.Error())
+}
+
+// Unwrap returns the parse error, which is a dsl.ParseError if HCL diagnostics
+// were reported
+func (e ErrFailedHopsParse) Unwrap() error {
+	return e.err
+}
+
+// ErrHopsConfig is an error caused by a user's hops config rather than the
+// system evaluating it, such as a call without a valid app and handler
+//
+// Retrying would fail the same way, so on blocks failing with these are reported
+// in the sequence rather than having the message redelivered. Dispatchers may
+// return (or wrap) one for calls that can never be dispatched.
+type ErrHopsConfig struct {
+	Err error
+}
+
+func (e ErrHopsConfig) Error() string {
+	return e.Err.Error()
+}
+
+func (e ErrHopsConfig) Unwrap() error {
+	return e.Err
+}
+
+// ErrSystem is an error from the infrastructure a sequence is evaluated with,
+// such as NATS being unavailable, which is worth retrying later
+type ErrSystem struct {
+	Err error
+}
+
+func (e ErrSystem) Error() string {
+	return e.Err.Error()
+}
+
+func (e ErrSystem) Unwrap() error {
+	return e.Err
+}
+
+// evaluationError identifies the on block or call that an error evaluating a
+// sequence came from
+//
+// Errors from calls are wrapped again by their on block, so errorSource is used
+// to find both.
+type evaluationError struct {
+	call string
+	err  error
+	on   string
+}
+
+func (e *evaluationError) Error() string {
+	return e.err.Error()
+}
+
+func (e *evaluationError) Unwrap() error {
+	return e.err
+}
+
+// errorSource returns the slugs of the first on block and call that err (or
+// any error it wraps) came from, which are empty if unknown
+func errorSource(err error) (string, string) {
+	on, call := "", ""
+
+	var evalErr *evaluationError
+	for errors.As(err, &evalErr) {
+		if on == "" {
+			on = evalErr.on
+		}
+		if call == "" {
+			call = evalErr.call
+		}
+
+		err = evalErr.err
+	}
+
+	return on, call
+}
+
+// isHopsConfigError returns whether err is caused by the hops config alone,
+// i.e. it and every error it joins wraps an ErrHopsConfig or a dsl.ParseError
+//
+// Parse errors hold the HCL diagnostics of evaluating the config against the
+// sequence, such as an if that errors or inputs that aren't an object.
+//
+// errors.As can't be used, as it would match an ErrHopsConfig joined with
+// system errors too.
+func isHopsConfigError(err error) bool {
+	switch e := err.(type) {
+	case ErrHopsConfig, dsl.ParseError:
+		return true
+	case interface{ Unwrap() []error }:
+		errs := e.Unwrap()
+		for _, err := range errs {
+			if !isHopsConfigError(err) {
+				return false
+			}
+		}
+
+		return len(errs) > 0
+	case interface{ Unwrap() error }:
+		return isHopsConfigError(e.Unwrap())
+	default:
+		return false
+	}
+}
+
+// splitSensorErrors separates the errors of on blocks that failed due to the
+// hops config from the rest, which are merged into a single ErrSystem
+func splitSensorErrors(err error) ([]error, error) {
+	if err == nil {
+		return nil, nil
+	}
+
+	sensorErrs := []error{err}
+	if merr, ok := err.(*multierror.Error); ok {
+		sensorErrs = merr.WrappedErrors()
+	}
+
+	configErrs := []error{}
+	var systemErrs error
+	for _, sensorErr := range sensorErrs {
+		if isHopsConfigError(sensorErr) {
+			configErrs = append(configErrs, sensorErr)
+			continue
+		}
+
+		systemErrs = multierror.Append(systemErrs, sensorErr)
+	}
+
+	if systemErrs != nil {
+		return configErrs, ErrSystem{Err: systemErrs}
+	}
+
+	return configErrs, nil
 }
@@ -2,25 +2,44 @@ package hops
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/goccy/go-json"
+	"github.com/hiphops-io/hops/logs"
 	"github.com/hiphops-io/hops/nats"
 	"github.com/rs/zerolog"
 )
 
+// EventStreamHeartbeat is how often a comment is sent to idle event streams, so
+// proxies don't close them
+const EventStreamHeartbeat = 15 * time.Second
+
+// eventStreamBuffer is the number of messages buffered for each event stream,
+// beyond which messages are dropped until the client catches up
+const eventStreamBuffer = 64
+
 type (
 	EventsClient interface {
-		GetEventHistory(ctx context.Context, start time.Time, sourceOnly bool) ([]*nats.MsgMeta, error)
+		ListEventHistory(ctx context.Context, query nats.EventHistoryQuery) (*nats.EventHistoryPage, error)
+		SequencesWithResults(ctx context.Context, sequenceIds []string) (map[string]bool, error)
+		SubscribeActivity(ctx context.Context, sequenceId string, handler nats.ActivityHandler) error
 	}
 	eventController struct {
+		heartbeat    time.Duration
 		logger       zerolog.Logger
 		eventsClient EventsClient
+		redactor     *logs.Redactor
+		streamCtx    context.Context
 	}
 
+	EventRouterOpt func(*eventController)
+
 	// Event is arbitrary json struct of event
 	Event interface{}
 
@@ -29,58 +48,103 @@ type (
 		StartTimestamp time.Time   `json:"start_timestamp"`
 		EndTimestamp   time.Time   `json:"end_timestamp"`
 		EventItems     []EventItem `json:"event_items"`
+		// NextCursor fetches the next page of earlier events, if there are any
+		NextCursor string `json:"next_cursor,omitempty"`
 	}
 
 	// EventItem includes metadata for /events api endpoint
 	EventItem struct {
+		Action      string    `json:"action,omitempty"`
 		Event       Event     `json:"event"`
+		EventType   string    `json:"event_type,omitempty"`
 		SequenceId  string    `json:"sequence_id"`
 		Timestamp   time.Time `json:"timestamp"`
 		AppName     string    `json:"app_name"`
 		Channel     string    `json:"channel"`
 		Done        bool      `json:"done"`
 		HandlerName string    `json:"handler_name"`
-		MessageId   string    `json:"message_id"`
+		// HasResults is set on source events once any call in their sequence has a result
+		HasResults bool   `json:"has_results"`
+		MessageId  string `json:"message_id"`
+	}
+
+	// eventCursor is the position a page of events continues from, before the
+	// last event of the previous page
+	eventCursor struct {
+		Sequence  uint64    `json:"seq"`
+		Timestamp time.Time `json:"ts"`
 	}
 )
 
-func EventRouter(eventsClient EventsClient, logger zerolog.Logger) chi.Router {
+func EventRouter(eventsClient EventsClient, logger zerolog.Logger, opts ...EventRouterOpt) chi.Router {
 	r := chi.NewRouter()
 	controller := &eventController{
+		heartbeat:    EventStreamHeartbeat,
 		logger:       logger,
 		eventsClient: eventsClient,
+		redactor:     logs.NewRedactor(),
+		streamCtx:    context.Background(),
 	}
+
+	for _, opt := range opts {
+		opt(controller)
+	}
+
 	r.Get("/", controller.listEvents)
+	r.Get("/stream", controller.streamEvents)
 
 	return r
 }
 
-// listEvents returns a list of events in reverse chronological order, with a
-// default lookback of 1 hour and a limit of 100 events. (const nats.DefaultEventLookback,
-// const nats.GetEventHistoryEventLimit)
-func (c *eventController) listEvents(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
+// WithEventRedactor sets the redactor scrubbing sensitive values from the
+// payloads of events (defaults to logs.DefaultRedactKeys)
+func WithEventRedactor(redactor *logs.Redactor) EventRouterOpt {
+	return func(c *eventController) {
+		c.redactor = redactor
+	}
+}
 
-	query := r.URL.Query()
+// WithStreamContext ends event streams once ctx is done, e.g. when the server
+// is shutting down, as streams are otherwise only ended by clients
+func WithStreamContext(ctx context.Context) EventRouterOpt {
+	return func(c *eventController) {
+		c.streamCtx = ctx
+	}
+}
 
-	sourceOnly := false
-	if query.Get("sourceonly") == "true" {
-		sourceOnly = true
+// listEvents returns a page of events in reverse chronological order, with a
+// default lookback of 1 hour (const nats.DefaultEventLookback) and at most 100
+// events (const nats.GetEventHistoryEventLimit)
+//
+// Query params are:
+//   - sourceonly: "true" to only list source events
+//   - event: only list source events of a type, e.g. "github" or "github_push"
+//   - after, before: RFC 3339 timestamps bounding when events were received, at
+//     most 24 hours apart (const nats.MaxEventLookback)
+//   - limit: the page size, up to 100
+//   - cursor: the next_cursor of the previous page, to list earlier events
+func (c *eventController) listEvents(w http.ResponseWriter, r *http.Request) {
+	query, err := eventHistoryQuery(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrorCodeBadRequest, err.Error())
+		return
 	}
-	// default lookback
-	start := time.Now().Add(nats.DefaultEventLookback)
 
-	msgs, err := c.eventsClient.GetEventHistory(ctx, start, sourceOnly)
+	page, err := c.eventsClient.ListEventHistory(r.Context(), query)
+	if errors.Is(err, nats.ErrEventLookbackTooLong) {
+		writeError(w, r, http.StatusBadRequest, ErrorCodeBadRequest, err.Error())
+		return
+	}
 	if err != nil {
 		c.logger.Error().Err(err).Msg("Error getting event history")
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, ErrorCodeInternal, "Unable to get event history")
 		return
 	}
 
-	eventLog, err := eventLogFromMsgMetas(msgs, start)
+	eventLog, err := c.eventLogFromPage(r.Context(), page, query)
 	if err != nil {
 		c.logger.Error().Err(err).Msg("Error reading event history")
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, ErrorCodeInternal, "Unable to read event history")
 		return
 	}
 
@@ -88,19 +152,89 @@ func (c *eventController) listEvents(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(eventLog)
 }
 
-func eventLogFromMsgMetas(msgs []*nats.MsgMeta, start time.Time) (*EventLog, error) {
-	events := []EventItem{}
+// streamEvents streams live activity as server-sent events, each a JSON
+// nats.Activity, until the client disconnects
+//
+// Query params are:
+//   - sequence_id: only stream activity in a sequence
+func (c *eventController) streamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, http.StatusInternalServerError, ErrorCodeInternal, "Streaming is not supported")
+		return
+	}
 
-	n := len(msgs)
+	// Cancelling unsubscribes, however the stream ends
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
 
-	// max 100 messages
-	if n > 100 {
-		n = 100
+	activities := make(chan nats.Activity, eventStreamBuffer)
+	err := c.eventsClient.SubscribeActivity(ctx, r.URL.Query().Get("sequence_id"), func(activity nats.Activity) {
+		select {
+		case activities <- activity:
+		default:
+			// Slow clients miss messages rather than holding up the subscription
+		}
+	})
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrorCodeBadRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(c.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.streamCtx.Done():
+			return
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+		case activity := <-activities:
+			var data []byte
+			data, err = json.Marshal(activity)
+			if err != nil {
+				c.logger.Error().Err(err).Msg("Error encoding activity")
+				continue
+			}
+			_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+		}
+
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// eventLogFromPage describes a page of events, with their payloads redacted
+func (c *eventController) eventLogFromPage(ctx context.Context, page *nats.EventHistoryPage, query nats.EventHistoryQuery) (*EventLog, error) {
+	events := []EventItem{}
+
+	// Every sequence of the page is checked for results at once
+	sourceSequenceIds := []string{}
+	for _, m := range page.Events {
+		if m.Channel == nats.ChannelNotify && m.MessageId == nats.SourceEventId {
+			sourceSequenceIds = append(sourceSequenceIds, m.SequenceId)
+		}
+	}
+	withResults, err := c.eventsClient.SequencesWithResults(ctx, sourceSequenceIds)
+	if err != nil {
+		return nil, fmt.Errorf("Error checking results of sequences: %w", err)
 	}
 
-	for _, m := range msgs[:n] {
+	for _, m := range page.Events {
 		var event Event
-		err := json.Unmarshal([]byte(m.Msg().Data()), &event)
+		err := json.Unmarshal(c.redactor.RedactJSON(m.Msg().Data()), &event)
 		if err != nil {
 			return nil, fmt.Errorf("Error unmarshalling event: %v", err)
 		}
@@ -114,14 +248,150 @@ func eventLogFromMsgMetas(msgs []*nats.MsgMeta, start time.Time) (*EventLog, err
 			HandlerName: m.HandlerName,
 			MessageId:   m.MessageId,
 		}
+
+		if m.Channel == nats.ChannelNotify && m.MessageId == nats.SourceEventId {
+			sourceMeta, err := nats.ParseSourceMeta(m.Msg().Data())
+			if err == nil {
+				eventItem.EventType = sourceMeta.Event
+				eventItem.Action = sourceMeta.Action
+			}
+
+			eventItem.HasResults = withResults[m.SequenceId]
+		}
+
 		events = append(events, eventItem)
 	}
 
 	eventLog := EventLog{
 		EventItems:     events,
-		StartTimestamp: start,
-		EndTimestamp:   time.Now(),
+		StartTimestamp: query.After,
+		EndTimestamp:   query.Before,
+	}
+
+	if page.More && len(page.Events) > 0 {
+		last := page.Events[len(page.Events)-1]
+		eventLog.NextCursor = encodeEventCursor(eventCursor{Sequence: last.StreamSequence, Timestamp: last.Timestamp})
 	}
 
 	return &eventLog, nil
 }
+
+// eventHistoryQuery reads the events to list from the query params of a request
+func eventHistoryQuery(r *http.Request) (nats.EventHistoryQuery, error) {
+	params := r.URL.Query()
+
+	query := nats.EventHistoryQuery{
+		Before:     time.Now(),
+		EventType:  params.Get("event"),
+		SourceOnly: params.Get("sourceonly") == "true",
+	}
+
+	if limitParam := params.Get("limit"); limitParam != "" {
+		limit, err := strconv.Atoi(limitParam)
+		if err != nil || limit < 1 || limit > nats.GetEventHistoryEventLimit {
+			return query, fmt.Errorf("limit must be a number from 1 to %d", nats.GetEventHistoryEventLimit)
+		}
+		query.Limit = limit
+	}
+
+	if beforeParam := params.Get("before"); beforeParam != "" {
+		before, err := time.Parse(time.RFC3339Nano, beforeParam)
+		if err != nil {
+			return query, fmt.Errorf("before must be an RFC 3339 timestamp: %w", err)
+		}
+		query.Before = before
+	}
+
+	if cursorParam := params.Get("cursor"); cursorParam != "" {
+		cursor, err := decodeEventCursor(cursorParam)
+		if err != nil {
+			return query, err
+		}
+
+		// Events received at the same time as the cursor's are still listed if
+		// they came before it in the stream
+		cursorBefore := cursor.Timestamp.Add(time.Nanosecond)
+		if cursorBefore.Before(query.Before) {
+			query.Before = cursorBefore
+		}
+		query.BeforeSequence = cursor.Sequence
+	}
+
+	query.After = query.Before.Add(nats.DefaultEventLookback)
+	if afterParam := params.Get("after"); afterParam != "" {
+		after, err := time.Parse(time.RFC3339Nano, afterParam)
+		if err != nil {
+			return query, fmt.Errorf("after must be an RFC 3339 timestamp: %w", err)
+		}
+		query.After = after
+	}
+
+	return query, nil
+}
+
+func decodeEventCursor(encoded string) (eventCursor, error) {
+	cursor := eventCursor{}
+
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return cursor, errors.New("Invalid cursor")
+	}
+
+	err = json.Unmarshal(data, &cursor)
+	if err != nil || cursor.Sequence == 0 {
+		return cursor, errors.New("Invalid cursor")
+	}
+
+	return cursor, nil
+}
+
+func encodeEventCursor(cursor eventCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// eventOpenAPIPaths describes the routes of EventRouter, served under /events
+func eventOpenAPIPaths() openAPIPaths {
+	return openAPIPaths{
+		"/events": {
+			"get": {
+				OperationID: "listEvents",
+				Summary:     "List a page of events, latest first",
+				Tags:        []string{"events"},
+				Parameters: []openAPIParameter{
+					queryParam("sourceonly", "Only list source events if true", "boolean"),
+					queryParam("event", "Only list source events of a type, e.g. github or github_push", "string"),
+					queryParam("after", fmt.Sprintf("Only list events received after an RFC 3339 timestamp, at most %s before before", -nats.MaxEventLookback), "string"),
+					queryParam("before", "Only list events received before an RFC 3339 timestamp", "string"),
+					queryParam("limit", fmt.Sprintf("The page size, up to %d", nats.GetEventHistoryEventLimit), "integer"),
+					queryParam("cursor", "The next_cursor of the previous page, to list earlier events", "string"),
+				},
+				Responses: responses(
+					map[int]openAPIResponse{http.StatusOK: jsonResponse("A page of events", EventLog{})},
+					http.StatusBadRequest, http.StatusTooManyRequests, http.StatusInternalServerError,
+				),
+				protected: true,
+			},
+		},
+		"/events/stream": {
+			"get": {
+				OperationID: "streamEvents",
+				Summary:     "Stream live activity as server-sent events, each data a JSON activity",
+				Tags:        []string{"events"},
+				Parameters: []openAPIParameter{
+					queryParam("sequence_id", "Only stream activity in a sequence", "string"),
+				},
+				Responses: responses(
+					map[int]openAPIResponse{
+						http.StatusOK: {
+							Description: "A stream of activity, until the client disconnects",
+							Content:     map[string]openAPIMediaType{"text/event-stream": {Schema: schemaOf(nats.Activity{})}},
+						},
+					},
+					http.StatusBadRequest, http.StatusTooManyRequests, http.StatusInternalServerError,
+				),
+				protected: true,
+			},
+		},
+	}
+}
@@ -0,0 +1,235 @@
+package hops
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hiphops-io/hops/logs"
+	"github.com/hiphops-io/hops/nats"
+)
+
+func TestEventRouterListEvents(t *testing.T) {
+	ctx := context.Background()
+	natsClient, _ := setupRunnerClient(t)
+
+	seeded := []struct {
+		event  string
+		action string
+	}{
+		{event: "github", action: "push"},
+		{event: "github", action: "pullrequest"},
+		{event: "slack", action: "message"},
+		{event: "github", action: "push"},
+		{event: "slack", action: "message"},
+	}
+
+	sequenceIds := []string{}
+	for i, s := range seeded {
+		sourceEvent, sequenceId, err := nats.CreateSourceEvent(map[string]any{"index": i, "api_token": "s3cret"}, "test", s.event, s.action, "")
+		require.NoError(t, err, "Test setup: Should create source event")
+
+		_, _, err = natsClient.Publish(ctx, sourceEvent, nats.ChannelNotify, sequenceId, nats.SourceEventId)
+		require.NoError(t, err, "Test setup: Should publish source event")
+
+		sequenceIds = append(sequenceIds, sequenceId)
+	}
+
+	// Only the first sequence has a result
+	_, _, err := natsClient.Publish(ctx, []byte(`{}`), nats.ChannelNotify, sequenceIds[0], "call", nats.DoneMessageId)
+	require.NoError(t, err, "Test setup: Should publish result")
+
+	router := EventRouter(natsClient, logs.NoOpLogger())
+
+	listEvents := func(t *testing.T, params url.Values) (*httptest.ResponseRecorder, EventLog) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?"+params.Encode(), nil))
+
+		eventLog := EventLog{}
+		if rec.Code == http.StatusOK {
+			err := json.Unmarshal(rec.Body.Bytes(), &eventLog)
+			require.NoError(t, err, "Response should be valid JSON")
+		}
+
+		return rec, eventLog
+	}
+
+	t.Run("Paginates most recent first", func(t *testing.T) {
+		params := url.Values{"sourceonly": {"true"}, "limit": {"2"}}
+		listed := []string{}
+		pages := 0
+
+		for {
+			rec, eventLog := listEvents(t, params)
+			require.Equal(t, http.StatusOK, rec.Code)
+			pages++
+
+			for _, item := range eventLog.EventItems {
+				listed = append(listed, item.SequenceId)
+			}
+
+			if eventLog.NextCursor == "" {
+				break
+			}
+			require.Len(t, eventLog.EventItems, 2, "Only full pages should have a next cursor")
+			require.Less(t, pages, 5, "Pagination should end")
+
+			params.Set("cursor", eventLog.NextCursor)
+		}
+
+		assert.Equal(t, 3, pages)
+		expected := []string{sequenceIds[4], sequenceIds[3], sequenceIds[2], sequenceIds[1], sequenceIds[0]}
+		assert.Equal(t, expected, listed)
+	})
+
+	t.Run("Describes source events", func(t *testing.T) {
+		rec, eventLog := listEvents(t, url.Values{"sourceonly": {"true"}})
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Len(t, eventLog.EventItems, 5)
+		assert.Empty(t, eventLog.NextCursor)
+
+		last := eventLog.EventItems[4]
+		assert.Equal(t, sequenceIds[0], last.SequenceId)
+		assert.Equal(t, "github", last.EventType)
+		assert.Equal(t, "push", last.Action)
+		assert.True(t, last.HasResults)
+		assert.False(t, eventLog.EventItems[0].HasResults)
+	})
+
+	t.Run("Redacts payloads", func(t *testing.T) {
+		rec, _ := listEvents(t, url.Values{})
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.NotContains(t, rec.Body.String(), "s3cret", "Sensitive values should be redacted")
+		assert.Contains(t, rec.Body.String(), logs.RedactedValue)
+	})
+
+	t.Run("Filters by event type", func(t *testing.T) {
+		rec, eventLog := listEvents(t, url.Values{"event": {"github"}})
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Len(t, eventLog.EventItems, 3)
+
+		rec, eventLog = listEvents(t, url.Values{"event": {"github_push"}})
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Len(t, eventLog.EventItems, 2)
+		assert.Equal(t, sequenceIds[3], eventLog.EventItems[0].SequenceId)
+		assert.Equal(t, sequenceIds[0], eventLog.EventItems[1].SequenceId)
+	})
+
+	t.Run("Filters by time", func(t *testing.T) {
+		future := time.Now().Add(time.Hour).Format(time.RFC3339)
+		rec, eventLog := listEvents(t, url.Values{"after": {future}, "before": {time.Now().Add(2 * time.Hour).Format(time.RFC3339)}})
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, eventLog.EventItems)
+
+		past := time.Now().Add(-time.Minute).Format(time.RFC3339)
+		rec, eventLog = listEvents(t, url.Values{"before": {past}})
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, eventLog.EventItems)
+	})
+
+	t.Run("Rejects invalid params", func(t *testing.T) {
+		invalid := []url.Values{
+			{"limit": {"0"}},
+			{"limit": {"1000"}},
+			{"limit": {"lots"}},
+			{"before": {"yesterday"}},
+			{"cursor": {"not-a-cursor"}},
+			{"after": {time.Now().Add(-48 * time.Hour).Format(time.RFC3339)}},
+		}
+
+		for _, params := range invalid {
+			rec, _ := listEvents(t, params)
+			assert.Equal(t, http.StatusBadRequest, rec.Code, "Params %v should be rejected", params)
+		}
+	})
+}
+
+func TestEventRouterStreamEvents(t *testing.T) {
+	ctx := context.Background()
+	natsClient, _ := setupRunnerClient(t)
+
+	withFastHeartbeat := func(c *eventController) {
+		c.heartbeat = 50 * time.Millisecond
+	}
+	server := httptest.NewServer(EventRouter(natsClient, logs.NoOpLogger(), withFastHeartbeat))
+	defer server.Close()
+
+	subscriptions := natsClient.NatsConn.NumSubscriptions()
+
+	resp, err := http.Get(server.URL + "/stream?sequence_id=watched")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	require.Eventually(t, func() bool {
+		return natsClient.NatsConn.NumSubscriptions() > subscriptions
+	}, time.Second, 10*time.Millisecond, "Stream should subscribe")
+
+	_, _, err = natsClient.Publish(ctx, []byte(`{}`), nats.ChannelNotify, "other", nats.SourceEventId)
+	require.NoError(t, err, "Test setup: Should publish source event")
+	_, _, err = natsClient.Publish(ctx, []byte(`{}`), nats.ChannelNotify, "watched", nats.SourceEventId)
+	require.NoError(t, err, "Test setup: Should publish source event")
+	// Only the notify and request channels are activity
+	err = natsClient.NatsConn.Publish(strings.Join([]string{natsClient.AccountId(), natsClient.InterestTopic(), "other", "watched", "call"}, "."), []byte(`{}`))
+	require.NoError(t, err, "Test setup: Should publish to another channel")
+	_, _, err = natsClient.Publish(ctx, []byte(`{}`), nats.ChannelRequest, "watched", "call", "app", "handler")
+	require.NoError(t, err, "Test setup: Should publish request")
+	_, _, err = natsClient.Publish(ctx, []byte(`{}`), nats.ChannelNotify, "watched", "call", nats.DoneMessageId)
+	require.NoError(t, err, "Test setup: Should publish result")
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	activities := []nats.Activity{}
+	heartbeats := 0
+	timeout := time.After(5 * time.Second)
+	for len(activities) < 3 || heartbeats == 0 {
+		select {
+		case line := <-lines:
+			if line == ": heartbeat" {
+				heartbeats++
+			}
+			if data, ok := strings.CutPrefix(line, "data: "); ok {
+				activity := nats.Activity{}
+				require.NoError(t, json.Unmarshal([]byte(data), &activity), "Events should be JSON")
+				activities = append(activities, activity)
+			}
+		case <-timeout:
+			require.FailNow(t, "Timed out waiting for streamed events")
+		}
+	}
+
+	require.Len(t, activities, 3, "Only activity in the sequence should be streamed")
+	for i, expectedType := range []string{nats.ActivityEvent, nats.ActivityRequest, nats.ActivityResult} {
+		assert.Equal(t, expectedType, activities[i].Type)
+		assert.Equal(t, "watched", activities[i].SequenceId)
+		assert.NotEmpty(t, activities[i].Subject)
+		assert.False(t, activities[i].Timestamp.IsZero())
+	}
+
+	resp.Body.Close()
+	assert.Eventually(t, func() bool {
+		return natsClient.NatsConn.NumSubscriptions() == subscriptions
+	}, time.Second, 10*time.Millisecond, "Disconnecting should unsubscribe")
+
+	invalidResp, err := http.Get(server.URL + "/stream?sequence_id=a.b")
+	require.NoError(t, err)
+	invalidResp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, invalidResp.StatusCode)
+}
@@ -1,29 +1,158 @@
 package hops
 
 import (
+	"context"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
 
 	"github.com/hiphops-io/hops/nats"
 )
 
-func Healthcheck(natsClient *nats.Client, endpoint string) func(http.Handler) http.Handler {
+// HealthCheckTimeout bounds the checks made for each request to the health endpoint
+const HealthCheckTimeout = 5 * time.Second
+
+// HealthHops is the name of the component reporting whether hops files are loaded
+const HealthHops = "hops"
+
+type (
+	// HealthClient reports the health of the NATS components hops depends on
+	HealthClient interface {
+		CheckConnection() bool
+		Health(ctx context.Context) []nats.ComponentHealth
+		Paused() bool
+	}
+
+	// HealthResponse is the body of the health endpoint
+	HealthResponse struct {
+		Components []nats.ComponentHealth `json:"components"`
+		// Paused is set whilst consuming is paused for maintenance, which doesn't
+		// make hops unhealthy (see nats.Client.Pause)
+		Paused bool   `json:"paused"`
+		Status string `json:"status"`
+	}
+)
+
+// Healthcheck serves the health endpoints under basePath, passing other requests on
+//
+// /health reports the status of each component hops depends on as a
+// HealthResponse, responding 200 OK if all are healthy and 503 otherwise.
+// Components are only named with their status unless the verbose query param is
+// set, which adds details such as errors and round trip times. As details can
+// reveal the server's setup, verbose requests must pass the protection
+// middlewares (e.g. auth) first. hopsHealth reports the status of the loaded
+// hops files.
+//
+// /healthz only checks the NATS connection, so is cheap enough for liveness and
+// readiness probes.
+func Healthcheck(natsClient HealthClient, hopsHealth func() nats.ComponentHealth, basePath string, protection ...func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	var verboseHealth http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, r, natsClient, hopsHealth, true)
+	})
+	for i := len(protection) - 1; i >= 0; i-- {
+		verboseHealth = protection[i](verboseHealth)
+	}
+
 	f := func(h http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
-			if (r.Method == "GET" || r.Method == "HEAD") && strings.EqualFold(r.URL.Path, endpoint) {
-				w.Header().Set("Content-Type", "text/plain")
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			switch {
+			case strings.EqualFold(r.URL.Path, basePath+"/healthz"):
 				if !natsClient.CheckConnection() {
-					w.WriteHeader(http.StatusInternalServerError)
-					w.Write([]byte("Not connected to NATS server"))
+					writeError(w, r, http.StatusServiceUnavailable, ErrorCodeUnavailable, "Not connected to NATS server")
 					return
 				}
+				w.Header().Set("Content-Type", "text/plain")
 				w.WriteHeader(http.StatusOK)
 				w.Write([]byte("OK"))
-				return
+
+			case strings.EqualFold(r.URL.Path, basePath+"/health"):
+				verbose, _ := strconv.ParseBool(r.URL.Query().Get("verbose"))
+				if verbose {
+					verboseHealth.ServeHTTP(w, r)
+					return
+				}
+				writeHealth(w, r, natsClient, hopsHealth, false)
+
+			default:
+				h.ServeHTTP(w, r)
 			}
-			h.ServeHTTP(w, r)
 		}
 		return http.HandlerFunc(fn)
 	}
 	return f
 }
+
+func writeHealth(w http.ResponseWriter, r *http.Request, natsClient HealthClient, hopsHealth func() nats.ComponentHealth, verbose bool) {
+	ctx, cancel := context.WithTimeout(r.Context(), HealthCheckTimeout)
+	defer cancel()
+
+	response := HealthResponse{
+		Components: append(natsClient.Health(ctx), hopsHealth()),
+		Paused:     natsClient.Paused(),
+		Status:     nats.HealthOK,
+	}
+
+	for i, component := range response.Components {
+		if component.Status != nats.HealthOK {
+			response.Status = nats.HealthDown
+		}
+
+		if !verbose {
+			response.Components[i] = nats.ComponentHealth{Name: component.Name, Status: component.Status}
+		}
+	}
+
+	status := http.StatusOK
+	if response.Status != nats.HealthOK {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// healthOpenAPIPaths describes the routes served by Healthcheck
+func healthOpenAPIPaths() openAPIPaths {
+	return openAPIPaths{
+		"/health": {
+			"get": {
+				OperationID: "getHealth",
+				Summary:     "Report the status of each component hops depends on",
+				Tags:        []string{"health"},
+				Parameters: []openAPIParameter{
+					queryParam("verbose", "Include details of each component, such as errors and round trip times, which requires auth if the server has it", "boolean"),
+				},
+				Responses: responses(
+					map[int]openAPIResponse{
+						http.StatusOK:                 jsonResponse("Every component is healthy", HealthResponse{}),
+						http.StatusServiceUnavailable: jsonResponse("A component is unhealthy", HealthResponse{}),
+					},
+					http.StatusTooManyRequests,
+				),
+				partlyProtected: true,
+			},
+		},
+		"/healthz": {
+			"get": {
+				OperationID: "getHealthz",
+				Summary:     "Check the connection to NATS, for liveness and readiness probes",
+				Tags:        []string{"health"},
+				Responses: responses(
+					map[int]openAPIResponse{
+						http.StatusOK: {Description: "Connected to NATS", Content: map[string]openAPIMediaType{"text/plain": {Schema: &jsonSchema{Type: "string"}}}},
+					},
+					http.StatusServiceUnavailable,
+				),
+			},
+		},
+	}
+}
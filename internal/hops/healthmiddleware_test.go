@@ -0,0 +1,150 @@
+package hops
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hiphops-io/hops/nats"
+)
+
+type fakeHealthClient struct {
+	components []nats.ComponentHealth
+	connected  bool
+	paused     bool
+}
+
+func (f *fakeHealthClient) CheckConnection() bool {
+	return f.connected
+}
+
+func (f *fakeHealthClient) Health(ctx context.Context) []nats.ComponentHealth {
+	return append([]nats.ComponentHealth{}, f.components...)
+}
+
+func (f *fakeHealthClient) Paused() bool {
+	return f.paused
+}
+
+func TestHealthcheck(t *testing.T) {
+	healthyClient := &fakeHealthClient{
+		connected: true,
+		components: []nats.ComponentHealth{
+			{Name: nats.HealthNats, Status: nats.HealthOK, Detail: "nats://localhost:4222", RTTMs: 0.5},
+			{Name: nats.HealthJetStream, Status: nats.HealthOK, Detail: "1 streams, 2 consumers"},
+			{Name: nats.HealthStream, Status: nats.HealthOK},
+		},
+	}
+	jetStreamDownClient := &fakeHealthClient{
+		connected: true,
+		components: []nats.ComponentHealth{
+			{Name: nats.HealthNats, Status: nats.HealthOK, RTTMs: 0.5},
+			{Name: nats.HealthJetStream, Status: nats.HealthDown, Detail: "JetStream unavailable: context deadline exceeded"},
+			{Name: nats.HealthStream, Status: nats.HealthDown, Detail: "JetStream unavailable"},
+		},
+	}
+	hopsHealthy := func() nats.ComponentHealth {
+		return nats.ComponentHealth{Name: HealthHops, Status: nats.HealthOK, Detail: "hash"}
+	}
+
+	serve := func(t *testing.T, client HealthClient, path string) *httptest.ResponseRecorder {
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		})
+
+		rec := httptest.NewRecorder()
+		Healthcheck(client, hopsHealthy, "/base")(next).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	health := func(t *testing.T, rec *httptest.ResponseRecorder) HealthResponse {
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+		response := HealthResponse{}
+		err := json.Unmarshal(rec.Body.Bytes(), &response)
+		require.NoError(t, err, "Response should be valid JSON")
+		return response
+	}
+
+	t.Run("Healthy", func(t *testing.T) {
+		rec := serve(t, healthyClient, "/base/health")
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		response := health(t, rec)
+		assert.Equal(t, nats.HealthOK, response.Status)
+		assert.Equal(t, []nats.ComponentHealth{
+			{Name: nats.HealthNats, Status: nats.HealthOK},
+			{Name: nats.HealthJetStream, Status: nats.HealthOK},
+			{Name: nats.HealthStream, Status: nats.HealthOK},
+			{Name: HealthHops, Status: nats.HealthOK},
+		}, response.Components, "Details should only be given when verbose")
+	})
+
+	t.Run("JetStream down", func(t *testing.T) {
+		rec := serve(t, jetStreamDownClient, "/base/health?verbose=1")
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+		response := health(t, rec)
+		assert.Equal(t, nats.HealthDown, response.Status)
+		require.Len(t, response.Components, 4)
+		assert.Equal(t, nats.ComponentHealth{Name: nats.HealthNats, Status: nats.HealthOK, RTTMs: 0.5}, response.Components[0])
+		assert.Equal(t, nats.HealthDown, response.Components[1].Status)
+		assert.Contains(t, response.Components[1].Detail, "JetStream unavailable")
+		assert.Equal(t, nats.HealthDown, response.Components[2].Status)
+		assert.Equal(t, HealthHops, response.Components[3].Name)
+		assert.Equal(t, "hash", response.Components[3].Detail)
+	})
+
+	t.Run("Verbose is protected", func(t *testing.T) {
+		protected := Healthcheck(healthyClient, hopsHealthy, "/base", Auth(NewBearerTokenValidator("secret")))(http.NotFoundHandler())
+		request := func(path string, token string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+
+			rec := httptest.NewRecorder()
+			protected.ServeHTTP(rec, req)
+			return rec
+		}
+
+		rec := request("/base/health", "")
+		assert.Equal(t, http.StatusOK, rec.Code, "Only verbose checks should be protected")
+
+		rec = request("/base/health?verbose=1", "")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.NotContains(t, rec.Body.String(), "nats://localhost:4222")
+
+		rec = request("/base/health?verbose=1", "secret")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "nats://localhost:4222", health(t, rec).Components[0].Detail)
+	})
+
+	t.Run("Paused", func(t *testing.T) {
+		pausedClient := *healthyClient
+		pausedClient.paused = true
+
+		rec := serve(t, &pausedClient, "/base/health")
+		assert.Equal(t, http.StatusOK, rec.Code, "Pausing shouldn't make hops unhealthy")
+		assert.True(t, health(t, rec).Paused)
+	})
+
+	t.Run("Lightweight check", func(t *testing.T) {
+		rec := serve(t, jetStreamDownClient, "/base/healthz")
+		assert.Equal(t, http.StatusOK, rec.Code, "Only the connection should be checked")
+		assert.Equal(t, "OK", rec.Body.String())
+
+		rec = serve(t, &fakeHealthClient{}, "/base/healthz")
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	})
+
+	t.Run("Other requests", func(t *testing.T) {
+		rec := serve(t, healthyClient, "/health")
+		assert.Equal(t, http.StatusTeapot, rec.Code, "Requests outside the base path should be passed on")
+	})
+}
@@ -2,51 +2,104 @@ package hops
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"github.com/go-chi/cors"
 	"github.com/goccy/go-json"
+	"github.com/google/uuid"
 	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/hlog"
 
 	"github.com/hiphops-io/hops/dsl"
 	"github.com/hiphops-io/hops/logs"
 	"github.com/hiphops-io/hops/nats"
 )
 
+// DefaultShutdownTimeout is how long in-flight requests are given to complete
+// once the HTTP server is stopped
+const DefaultShutdownTimeout = 5 * time.Second
+
 type (
+	// AccessLogConf configures the HTTP server's access log
+	AccessLogConf struct {
+		// SkipPaths are left out of the access log, along with anything under
+		// them, relative to the base path (e.g. "/health")
+		SkipPaths []string
+		// SlowThreshold is the duration beyond which requests are logged at warn
+		// level (0 never does)
+		SlowThreshold time.Duration
+	}
+
 	HTTPServer struct {
-		hopsFiles      *dsl.HopsFiles
-		hopsFileLoader *HopsFileLoader
-		logger         zerolog.Logger
-		mu             sync.RWMutex
-		natsClient     *nats.Client
-		server         *http.Server
-		taskHops       *dsl.HopAST
-		tolerantParse  bool // tolerantParse makes failed hops parsing non-fatal (useful in --watch mode)
-		updatedAt      int64
+		accessLog       AccessLogConf
+		auth            func(http.Handler) http.Handler
+		basePath        string
+		console         ConsoleConf
+		cors            CORSConf
+		globalRateLimit func(http.Handler) http.Handler
+		hopsFiles       *dsl.HopsFiles
+		hopsFileLoader  *HopsFileLoader
+		logger          zerolog.Logger
+		mu              sync.RWMutex
+		natsClient      *nats.Client
+		openAPI         []byte
+		parseErr        error
+		rateLimit       func(http.Handler) http.Handler
+		redactor        *logs.Redactor
+		redirectServer  *http.Server
+		replay          ReplayConf
+		runnerMetrics   *CounterMetrics
+		server          *http.Server
+		shutdownTimeout time.Duration
+		stopStreams     context.CancelFunc
+		taskHops        *dsl.HopAST
+		tlsConf         *TLSConf
+		tolerantParse   bool // tolerantParse makes failed hops parsing non-fatal (useful in --watch mode)
+		updatedAt       int64
+	}
+
+	HTTPServerOpt func(*HTTPServer)
+
+	debugHopsFile struct {
+		File string `json:"file"`
+		Type string `json:"type"`
+	}
+
+	debugHopsResponse struct {
+		Diagnostics []string        `json:"diagnostics"`
+		Files       []debugHopsFile `json:"files"`
+		Hash        string          `json:"hash"`
+		Ons         []dsl.OnSummary `json:"ons"`
+		Tasks       []string        `json:"tasks"`
+		UpdatedAt   int64           `json:"updated_at"`
 	}
 
 	taskRunResponse struct {
-		Errors     map[string][]string `json:"errors"`
-		Message    string              `json:"message"`
-		SequenceID string              `json:"sequence_id"`
-		statusCode int
+		Message    string `json:"message"`
+		SequenceID string `json:"sequence_id"`
 	}
 )
 
-func NewHTTPServer(addr string, hopsFileLoader *HopsFileLoader, tolerantParse bool, natsClient *nats.Client, logger zerolog.Logger) (*HTTPServer, error) {
+func NewHTTPServer(addr string, hopsFileLoader *HopsFileLoader, tolerantParse bool, natsClient *nats.Client, logger zerolog.Logger, opts ...HTTPServerOpt) (*HTTPServer, error) {
 	h := &HTTPServer{
-		hopsFileLoader: hopsFileLoader,
-		logger:         logger,
-		natsClient:     natsClient,
-		tolerantParse:  tolerantParse,
-		taskHops:       &dsl.HopAST{},
+		hopsFileLoader:  hopsFileLoader,
+		logger:          logger,
+		natsClient:      natsClient,
+		shutdownTimeout: DefaultShutdownTimeout,
+		tolerantParse:   tolerantParse,
+		taskHops:        &dsl.HopAST{},
+	}
+
+	for _, opt := range opts {
+		opt(h)
 	}
 
 	err := h.Reload(context.Background())
@@ -55,40 +108,118 @@ func NewHTTPServer(addr string, hopsFileLoader *HopsFileLoader, tolerantParse bo
 	}
 
 	r := chi.NewRouter()
-	r.Use(middleware.Recoverer)
+	r.NotFound(notFound)
+	r.MethodNotAllowed(methodNotAllowed)
+	// Requests are given IDs before anything else, so panics can be traced
+	r.Use(logs.AccessLogMiddleware(logger, h.accessLogOpts()...))
+	r.Use(Recoverer)
 	r.Use(middleware.RedirectSlashes)
-	r.Use(logs.AccessLogMiddleware(logger))
-	r.Use(Healthcheck(natsClient, "/health"))
-	// TODO: Make CORS configurable and lock down by default. As-is it could be
-	// insecure for production/deployed use.
-	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
-		AllowCredentials: false,
-		MaxAge:           300,
-	}))
-
-	r.Get("/updated-at", h.getUpdatedAt)
-
-	// Serve the single page app for the console from the UI dir
-	r.Mount("/console", ConsoleRouter(logger))
+	r.Use(Healthcheck(natsClient, h.hopsHealth, h.basePath, h.protection()...))
+	r.Use(CORS(h.cors))
+
+	// Everything is served under the base path, so redirects (which use the
+	// full request path) keep the prefix
+	var routes chi.Router = r
+	if h.basePath != "" {
+		routes = chi.NewRouter()
+		r.Mount(h.basePath, routes)
+	}
+
+	routes.Get("/updated-at", h.getUpdatedAt)
+
+	// Describe the API, for clients and people building them
+	h.openAPI, err = json.Marshal(newOpenAPISpec(h.basePath, h.auth != nil))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to describe the API: %w", err)
+	}
+	routes.Get("/openapi.json", h.getOpenAPI)
+	routes.Get("/docs", h.getAPIDocs)
+
+	// Serve the single page app for the console, embedded unless set with WithConsole
+	consoleRouter, err := ConsoleRouter(logger, h.basePath+"/console", h.console)
+	if err != nil {
+		return nil, err
+	}
+	routes.Mount("/console", consoleRouter)
 
 	// Serve the tasks API
-	r.Route("/tasks", func(r chi.Router) {
-		r.Post("/{taskName}", h.runTask)
+	routes.Route("/tasks", func(r chi.Router) {
+		h.protect(r)
+
+		// Running tasks publishes to the account's stream, so is also limited globally
+		runs := r
+		if h.globalRateLimit != nil {
+			runs = r.With(h.globalRateLimit)
+		}
+		runs.Post("/{taskName}", h.runTask)
+		runs.Post("/{taskName}/run", h.startTask)
 		r.Get("/", h.listTasks)
+		r.Get("/{taskName}", h.getTask)
+	})
+
+	// Validate hops content without loading it
+	routes.Route("/validate", func(r chi.Router) {
+		h.protect(r)
+
+		r.Post("/", h.validateHops)
 	})
 
-	// Serve the events API
-	r.Mount("/events", EventRouter(natsClient, logger))
+	// Serve diagnostics of the loaded hops files
+	routes.Route("/debug", func(r chi.Router) {
+		h.protect(r)
+
+		r.Get("/hops", h.getDebugHops)
+		r.Get("/metrics", h.getDebugMetrics)
+	})
+
+	// Serve the events API, protected like the tasks API as events hold raw payloads
+	// Event streams never complete by themselves, so are ended on shutdown
+	streamCtx, stopStreams := context.WithCancel(context.Background())
+	h.stopStreams = stopStreams
+	eventOpts := []EventRouterOpt{WithStreamContext(streamCtx)}
+	if h.redactor != nil {
+		eventOpts = append(eventOpts, WithEventRedactor(h.redactor))
+	}
+	routes.With(h.protection()...).Mount("/events", EventRouter(natsClient, logger, eventOpts...))
+	// Serve the sequences API, protected like the tasks API as sequences hold raw
+	// payloads and inputs. Replays publish to the account's stream, and can
+	// dispatch calls again, so are also limited globally like running tasks.
+	sequenceOpts := []SequenceRouterOpt{}
+	if h.globalRateLimit != nil {
+		sequenceOpts = append(sequenceOpts, WithReplayMiddleware(h.globalRateLimit))
+	}
+	if h.redactor != nil {
+		sequenceOpts = append(sequenceOpts, WithRedactor(h.redactor))
+	}
+	if h.replay.AllowReplayOfReplays {
+		sequenceOpts = append(sequenceOpts, WithReplayOfReplays())
+	}
+	routes.With(h.protection()...).Mount("/sequences", SequenceRouter(natsClient, logger, sequenceOpts...))
 
 	h.server = &http.Server{
 		Addr:    addr,
 		Handler: r,
 	}
 
+	if h.tlsConf != nil {
+		certs, err := newCertReloader(h.tlsConf.CertFile, h.tlsConf.KeyFile, logger)
+		if err != nil {
+			return nil, err
+		}
+
+		h.server.TLSConfig = &tls.Config{
+			GetCertificate: certs.GetCertificate,
+			MinVersion:     tls.VersionTLS12,
+		}
+
+		if h.tlsConf.RedirectAddress != "" {
+			h.redirectServer = &http.Server{
+				Addr:    h.tlsConf.RedirectAddress,
+				Handler: httpsRedirect(addr),
+			}
+		}
+	}
+
 	return h, nil
 }
 
@@ -100,13 +231,18 @@ func (h *HTTPServer) Reload(ctx context.Context) error {
 	// Serve the tasks API
 	taskHops, err := dsl.ParseHopsTasks(ctx, hopsFiles)
 	if err != nil && h.tolerantParse {
+		// The previously loaded files are kept, with the error shown in diagnostics
+		h.mu.Lock()
+		h.parseErr = err
+		h.mu.Unlock()
 		return nil
 	} else if err != nil {
-		return ErrFailedHopsParse{err.Error()}
+		return ErrFailedHopsParse{err: err}
 	}
 
 	h.mu.Lock()
 	h.hopsFiles = hopsFiles
+	h.parseErr = nil
 	h.taskHops = taskHops
 	h.updatedAt = time.Now().UnixMicro()
 	h.mu.Unlock()
@@ -114,13 +250,110 @@ func (h *HTTPServer) Reload(ctx context.Context) error {
 	return nil
 }
 
-func (h *HTTPServer) Serve() error {
-	h.logger.Info().Msgf("Console available on http://%s/console", h.server.Addr)
-	return h.server.ListenAndServe()
+// Serve serves the console and APIs, over TLS if configured with WithTLS, until
+// ctx is cancelled. In-flight requests are then given the shutdown timeout to
+// complete (see WithShutdownTimeout), returning any error shutting down.
+func (h *HTTPServer) Serve(ctx context.Context) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- h.listenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	h.logger.Info().Msg("Shutting down console")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), h.shutdownTimeout)
+	defer cancel()
+
+	return h.Shutdown(shutdownCtx)
 }
 
+// Shutdown stops the server, waiting for in-flight requests to complete until
+// ctx is done
 func (h *HTTPServer) Shutdown(ctx context.Context) error {
-	return h.server.Shutdown(ctx)
+	h.stopStreams()
+
+	var redirectErr error
+	if h.redirectServer != nil {
+		redirectErr = h.redirectServer.Shutdown(ctx)
+	}
+
+	return errors.Join(h.server.Shutdown(ctx), redirectErr)
+}
+
+// getDebugHops describes the loaded hops files, so it's possible to tell why a
+// workflow isn't loaded without reading logs
+func (h *HTTPServer) getDebugHops(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	hopsFiles, parseErr, taskHops, updatedAt := h.hopsFiles, h.parseErr, h.taskHops, h.updatedAt
+	h.mu.RUnlock()
+
+	summary := dsl.SummariseHops(hopsFiles)
+
+	response := debugHopsResponse{
+		Diagnostics: []string{},
+		Files:       []debugHopsFile{},
+		Ons:         summary.Ons,
+		Tasks:       []string{},
+		UpdatedAt:   updatedAt,
+	}
+
+	if parseErr != nil {
+		response.Diagnostics = append(response.Diagnostics, fmt.Sprintf("Unable to parse hops files: %s", parseErr.Error()))
+	}
+	response.Diagnostics = append(response.Diagnostics, summary.Diagnostics...)
+
+	if hopsFiles != nil {
+		response.Hash = hopsFiles.Hash
+		for _, file := range hopsFiles.Files {
+			response.Files = append(response.Files, debugHopsFile{File: file.File, Type: file.Type})
+		}
+	}
+
+	for _, task := range taskHops.ListTasks() {
+		response.Tasks = append(response.Tasks, task.Name)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// getDebugMetrics serves the metrics of the runner in this process, if any
+func (h *HTTPServer) getDebugMetrics(w http.ResponseWriter, r *http.Request) {
+	if h.runnerMetrics == nil {
+		writeError(w, r, http.StatusNotFound, ErrorCodeNotFound, "No runner is collecting metrics in this process")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.runnerMetrics.Snapshot())
+}
+
+// hopsHealth reports whether the hops files are loaded, as hops files that fail
+// to parse when reloaded leave the previous files in place
+func (h *HTTPServer) hopsHealth() nats.ComponentHealth {
+	h.mu.RLock()
+	hopsFiles, parseErr := h.hopsFiles, h.parseErr
+	h.mu.RUnlock()
+
+	component := nats.ComponentHealth{Name: HealthHops, Status: nats.HealthOK}
+	switch {
+	case parseErr != nil:
+		component.Status = nats.HealthDown
+		component.Detail = fmt.Sprintf("Unable to parse hops files: %s", parseErr.Error())
+	case hopsFiles == nil:
+		component.Status = nats.HealthDown
+		component.Detail = "No hops files loaded"
+	default:
+		component.Detail = hopsFiles.Hash
+	}
+
+	return component
 }
 
 func (h *HTTPServer) getUpdatedAt(w http.ResponseWriter, r *http.Request) {
@@ -132,9 +365,38 @@ func (h *HTTPServer) getUpdatedAt(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(updatedAt)
 }
 
+func (h *HTTPServer) listenAndServe() error {
+	if h.server.TLSConfig == nil {
+		h.logger.Info().Str("mode", "http").Msgf("Console available on http://%s%s/console", h.server.Addr, h.basePath)
+		return h.server.ListenAndServe()
+	}
+
+	if h.redirectServer != nil {
+		go func() {
+			h.logger.Info().Msgf("Redirecting HTTP on %s to HTTPS", h.redirectServer.Addr)
+
+			err := h.redirectServer.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				h.logger.Error().Err(err).Msg("Unable to serve HTTP to HTTPS redirects")
+			}
+		}()
+	}
+
+	h.logger.Info().Str("mode", "tls").Msgf("Console available on https://%s%s/console", h.server.Addr, h.basePath)
+	return h.server.ListenAndServeTLS("", "")
+}
+
+// listTasks returns a page of tasks ordered by name (see taskListQuery for params),
+// or every task as a bare array if no page or search is asked for
 func (h *HTTPServer) listTasks(w http.ResponseWriter, r *http.Request) {
 	var tasks []dsl.TaskAST
 
+	query, err := taskListQueryFromRequest(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrorCodeBadRequest, err.Error())
+		return
+	}
+
 	filePathParam := r.URL.Query().Get("filepath")
 
 	if filePathParam == "" {
@@ -144,9 +406,7 @@ func (h *HTTPServer) listTasks(w http.ResponseWriter, r *http.Request) {
 	} else {
 		filePath, err := url.QueryUnescape(filePathParam)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			msg := fmt.Sprintf("Invalid file path given: %s", err.Error())
-			w.Write([]byte(msg))
+			writeError(w, r, http.StatusBadRequest, ErrorCodeBadRequest, fmt.Sprintf("Invalid file path given: %s", err.Error()))
 			return
 		}
 
@@ -155,96 +415,358 @@ func (h *HTTPServer) listTasks(w http.ResponseWriter, r *http.Request) {
 		h.mu.RUnlock()
 	}
 
+	list := query.page(tasks)
+
+	w.Header().Set("Content-Type", "application/json")
+	if !query.paged {
+		json.NewEncoder(w).Encode(list.Tasks)
+		return
+	}
+	json.NewEncoder(w).Encode(list)
+}
+
+// getTask returns a single task, including its full params schema
+func (h *HTTPServer) getTask(w http.ResponseWriter, r *http.Request) {
+	taskName := chi.URLParam(r, "taskName")
+
+	h.mu.RLock()
+	task, err := h.taskHops.GetTask(taskName)
+	h.mu.RUnlock()
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, ErrorCodeNotFound, err.Error())
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(tasks)
+	json.NewEncoder(w).Encode(task)
 }
 
 func (h *HTTPServer) runTask(w http.ResponseWriter, r *http.Request) {
-	runResponse := taskRunResponse{}
+	sequenceID, apiErr := h.publishTaskEvent(r, false)
+	h.writeTaskRunResponse(w, r, http.StatusOK, sequenceID, apiErr)
+}
 
+// startTask runs a task in a new sequence, even if it's been run with the same
+// inputs before, responding with 202 Accepted once its event is published
+func (h *HTTPServer) startTask(w http.ResponseWriter, r *http.Request) {
+	sequenceID, apiErr := h.publishTaskEvent(r, true)
+	h.writeTaskRunResponse(w, r, http.StatusAccepted, sequenceID, apiErr)
+}
+
+// publishTaskEvent validates the inputs given to a task and publishes its source
+// event, starting a sequence. If fresh, the sequence is new even if the same
+// inputs have been given before.
+func (h *HTTPServer) publishTaskEvent(r *http.Request, fresh bool) (string, *apiError) {
 	taskName := chi.URLParam(r, "taskName")
 	if taskName == "" {
-		runResponse.statusCode = http.StatusBadRequest
-		runResponse.Message = "Task name is required"
-		h.writeTaskRunResponse(w, runResponse)
-		return
+		return "", &apiError{status: http.StatusBadRequest, code: ErrorCodeBadRequest, message: "Task name is required"}
 	}
 
 	var taskInput map[string]any
 	err := json.NewDecoder(r.Body).Decode(&taskInput)
 	if err != nil {
-		runResponse.statusCode = http.StatusBadRequest
-		runResponse.Message = "Unable to parse payload JSON"
-		h.writeTaskRunResponse(w, runResponse)
-		return
+		return "", &apiError{status: http.StatusBadRequest, code: ErrorCodeBadRequest, message: "Unable to parse payload JSON"}
+	}
+	if taskInput == nil {
+		taskInput = map[string]any{}
 	}
 
 	h.mu.RLock()
 	task, err := h.taskHops.GetTask(taskName)
+	hopsHash := ""
+	if h.hopsFiles != nil {
+		hopsHash = h.hopsFiles.Hash
+	}
 	h.mu.RUnlock()
 
 	if err != nil {
-		runResponse.statusCode = http.StatusNotFound
-		runResponse.Message = "Not found"
-		h.writeTaskRunResponse(w, runResponse)
-		return
+		return "", &apiError{status: http.StatusNotFound, code: ErrorCodeNotFound, message: err.Error()}
 	}
 
 	// Validate the input
 	validationMessages := task.ValidateInput(taskInput)
 	if len(validationMessages) > 0 {
-		runResponse.statusCode = http.StatusBadRequest
-		runResponse.Message = fmt.Sprintf("Invalid inputs for %s", task.Name)
-		runResponse.Errors = validationMessages
-		h.writeTaskRunResponse(w, runResponse)
-		return
+		return "", &apiError{
+			status:  http.StatusBadRequest,
+			code:    ErrorCodeInvalidInputs,
+			message: fmt.Sprintf("Invalid inputs for %s", task.Name),
+			details: fieldErrorDetails(validationMessages),
+		}
+	}
+
+	// Build a source event, carrying the request ID so the sequence's logs can be
+	// correlated with the request's
+	sourceMeta := nats.SourceMeta{
+		Source: "hiphops",
+		Event:  "task",
+		Action: task.Name,
+	}
+	sourceMeta.RequestId, _ = logs.RequestIDFromContext(r.Context())
+	if fresh {
+		sourceMeta.HopsHash = hopsHash
+		sourceMeta.Unique = uuid.NewString()
 	}
 
-	// Build a source event
-	sourceEvent, sequenceID, err := dsl.CreateSourceEvent(taskInput, "hiphops", "task", task.Name)
+	sourceEvent, sequenceID, err := nats.CreateSourceEventWithMeta(taskInput, sourceMeta)
 	if err != nil {
-		runResponse.statusCode = http.StatusInternalServerError
-		runResponse.Message = "Unable to create event"
-		h.writeTaskRunResponse(w, runResponse)
-		return
+		return "", &apiError{status: http.StatusInternalServerError, code: ErrorCodeInternal, message: "Unable to create event"}
 	}
 
 	// Push the event message to the topic, including the hash as sequence ID and "event" as event ID
-	_, _, err = h.natsClient.Publish(r.Context(), sourceEvent, nats.ChannelNotify, sequenceID, "event")
+	_, _, err = h.natsClient.Publish(r.Context(), sourceEvent, nats.ChannelNotify, sequenceID, nats.SourceEventId)
 	if err != nil {
-		runResponse.statusCode = http.StatusInternalServerError
-		runResponse.Message = fmt.Sprintf("Unable to publish event: %s", err.Error())
-		h.writeTaskRunResponse(w, runResponse)
-		return
+		return "", &apiError{status: http.StatusInternalServerError, code: ErrorCodeInternal, message: fmt.Sprintf("Unable to publish event: %s", err.Error())}
 	}
 
-	runResponse.statusCode = http.StatusOK
-	runResponse.Message = "OK"
-	runResponse.SequenceID = sequenceID
-	h.writeTaskRunResponse(w, runResponse)
+	return sequenceID, nil
 }
 
-func (h *HTTPServer) writeTaskRunResponse(w http.ResponseWriter, runResponse taskRunResponse) {
-	// We only explicitly write non-200 status codes. This allows us to
-	// properly convey failed encoding to end users without sending headers twice.
-	isBadStatus := runResponse.statusCode != http.StatusOK
+// accessLogOpts configures the access log, which always leaves out the
+// console's assets
+func (h *HTTPServer) accessLogOpts() []logs.AccessLogOpt {
+	skipPaths := []string{h.basePath + "/console"}
+	for _, path := range h.accessLog.SkipPaths {
+		skipPaths = append(skipPaths, h.basePath+"/"+strings.TrimPrefix(path, "/"))
+	}
 
-	w.Header().Set("Content-Type", "application/json")
+	return []logs.AccessLogOpt{
+		logs.WithSkipPaths(skipPaths...),
+		logs.WithSlowThreshold(h.accessLog.SlowThreshold),
+	}
+}
+
+// protect applies the access controls of the tasks API to a route group
+func (h *HTTPServer) protect(r chi.Router) {
+	r.Use(h.protection()...)
+}
+
+// protection returns the access control middlewares of the tasks API
+func (h *HTTPServer) protection() []func(http.Handler) http.Handler {
+	middlewares := []func(http.Handler) http.Handler{}
+
+	// Rate limiting comes first, so it also limits guessing credentials
+	if h.rateLimit != nil {
+		middlewares = append(middlewares, h.rateLimit)
+	}
+	if h.auth != nil {
+		middlewares = append(middlewares, h.auth)
+	}
 
-	if isBadStatus {
-		w.WriteHeader(runResponse.statusCode)
-		h.logger.Error().Msg(runResponse.Message)
+	return middlewares
+}
+
+// writeTaskRunResponse responds with status and the sequence started by a task
+// run, or with apiErr if the run failed
+func (h *HTTPServer) writeTaskRunResponse(w http.ResponseWriter, r *http.Request, status int, sequenceID string, apiErr *apiError) {
+	if apiErr != nil {
+		hlog.FromRequest(r).Error().Str("code", apiErr.code).Msg(apiErr.message)
+		apiErr.write(w, r)
+		return
 	}
 
-	err := json.NewEncoder(w).Encode(runResponse)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(taskRunResponse{Message: "OK", SequenceID: sequenceID})
 	if err != nil {
-		h.logger.Error().Err(err).Msg("Error encoding task response")
+		hlog.FromRequest(r).Error().Err(err).Msg("Error encoding task response")
+	}
+}
 
-		// A bad status will already have been written, so we'll default to that
-		if !isBadStatus {
-			w.WriteHeader(http.StatusInternalServerError)
+// serverOpenAPIPaths describes the routes served by the HTTP server itself
+func serverOpenAPIPaths() openAPIPaths {
+	taskName := pathParam("taskName", "The name of the task")
+	taskRun := func(id string, summary string, status int) *openAPIOperation {
+		return &openAPIOperation{
+			OperationID: id,
+			Summary:     summary,
+			Tags:        []string{"tasks"},
+			Parameters:  []openAPIParameter{taskName},
+			RequestBody: &openAPIRequestBody{
+				Description: "The task's params, by name",
+				Content:     jsonContent(&jsonSchema{Type: "object", AdditionalProperties: &jsonSchema{}}),
+			},
+			Responses: responses(
+				map[int]openAPIResponse{status: jsonResponse("The task's event is published, starting a sequence", taskRunResponse{})},
+				http.StatusBadRequest, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError,
+			),
+			protected: true,
 		}
-		w.Write([]byte(`{"message":"Internal server error"}`))
-		return
+	}
+
+	return openAPIPaths{
+		"/debug/hops": {
+			"get": {
+				OperationID: "getDebugHops",
+				Summary:     "Describe the loaded hops files",
+				Tags:        []string{"debug"},
+				Responses: responses(
+					map[int]openAPIResponse{http.StatusOK: jsonResponse("The loaded hops files", debugHopsResponse{})},
+					http.StatusTooManyRequests,
+				),
+				protected: true,
+			},
+		},
+		"/debug/metrics": {
+			"get": {
+				OperationID: "getDebugMetrics",
+				Summary:     "Report the metrics of the runner in this process",
+				Tags:        []string{"debug"},
+				Responses: responses(
+					map[int]openAPIResponse{http.StatusOK: jsonResponse("The runner's metrics", MetricsSnapshot{})},
+					http.StatusNotFound, http.StatusTooManyRequests,
+				),
+				protected: true,
+			},
+		},
+		"/tasks": {
+			"get": {
+				OperationID: "listTasks",
+				Summary:     "List a page of tasks, ordered by name",
+				Description: "Responds with a TaskList page if any of q, limit or cursor are given. Otherwise every task is listed as a bare array, as it was before tasks were paginated.",
+				Tags:        []string{"tasks"},
+				Parameters: []openAPIParameter{
+					queryParam("q", "Only list tasks whose name, display name, summary or description contain the text", "string"),
+					queryParam("limit", fmt.Sprintf("The page size, up to %d", TaskListLimit), "integer"),
+					queryParam("cursor", "The next_cursor of the previous page", "string"),
+					queryParam("filepath", "Only list tasks in files under the path", "string"),
+				},
+				Responses: responses(
+					map[int]openAPIResponse{http.StatusOK: {
+						Description: "A page of tasks, or every task if unpaged",
+						Content:     jsonContent(&jsonSchema{OneOf: []*jsonSchema{schemaOf(TaskList{}), schemaOf([]dsl.TaskAST{})}}),
+					}},
+					http.StatusBadRequest, http.StatusTooManyRequests,
+				),
+				protected: true,
+			},
+		},
+		"/tasks/{taskName}": {
+			"get": {
+				OperationID: "getTask",
+				Summary:     "Get a task, including its params",
+				Tags:        []string{"tasks"},
+				Parameters:  []openAPIParameter{taskName},
+				Responses: responses(
+					map[int]openAPIResponse{http.StatusOK: jsonResponse("The task", dsl.TaskAST{})},
+					http.StatusNotFound, http.StatusTooManyRequests,
+				),
+				protected: true,
+			},
+			"post": taskRun("runTask", "Run a task, unless it's been run with the same params before", http.StatusOK),
+		},
+		"/tasks/{taskName}/run": {
+			"post": taskRun("startTask", "Run a task in a new sequence, even if it's been run with the same params before", http.StatusAccepted),
+		},
+		"/updated-at": {
+			"get": {
+				OperationID: "getUpdatedAt",
+				Summary:     "Get when the hops files were last loaded, in microseconds since the Unix epoch",
+				Tags:        []string{"meta"},
+				Responses: responses(map[int]openAPIResponse{
+					http.StatusOK: {Description: "When the hops files were last loaded", Content: jsonContent(&jsonSchema{Type: "integer", Format: "int64"})},
+				}),
+			},
+		},
+	}
+}
+
+// WithAccessLog sets which requests are left out of the access log, and which
+// are logged as slow
+func WithAccessLog(conf AccessLogConf) HTTPServerOpt {
+	return func(h *HTTPServer) {
+		h.accessLog = conf
+	}
+}
+
+// WithAuth requires requests to the tasks API to be authenticated by one of
+// validators (see Auth). The health check (unless verbose) and console assets
+// stay open.
+func WithAuth(validators ...AuthValidator) HTTPServerOpt {
+	return func(h *HTTPServer) {
+		h.auth = Auth(validators...)
+	}
+}
+
+// WithBasePath serves every route under a path prefix (e.g. "/hops", serving
+// the console at "/hops/console"), for deployments behind a reverse proxy under
+// a sub-path. The proxy should pass the full path through.
+func WithBasePath(basePath string) HTTPServerOpt {
+	return func(h *HTTPServer) {
+		h.basePath = normaliseBasePath(basePath)
+	}
+}
+
+// WithConsole sets where the console's UI assets are served from, which
+// defaults to those embedded in the binary
+func WithConsole(conf ConsoleConf) HTTPServerOpt {
+	return func(h *HTTPServer) {
+		h.console = conf
+	}
+}
+
+// WithCORS sets which cross-origin clients may call the server, which defaults to
+// only those served from localhost
+func WithCORS(conf CORSConf) HTTPServerOpt {
+	return func(h *HTTPServer) {
+		h.cors = conf
+	}
+}
+
+// WithGlobalRateLimit limits how often tasks can be run by all clients together,
+// using the allowance tracked in store (see RateLimit and GlobalKey). Clients that
+// are also limited by WithRateLimit are checked against their own limit first.
+func WithGlobalRateLimit(store RateLimitStore) HTTPServerOpt {
+	return func(h *HTTPServer) {
+		h.globalRateLimit = RateLimit(store, GlobalKey)
+	}
+}
+
+// WithRateLimit limits how often each client can call the tasks API, using the
+// allowances tracked in store (see RateLimit)
+func WithRateLimit(store RateLimitStore, keyFunc RateLimitKeyFunc) HTTPServerOpt {
+	return func(h *HTTPServer) {
+		h.rateLimit = RateLimit(store, keyFunc)
+	}
+}
+
+// WithRedactKeys sets the keys whose values are scrubbed from the payloads of
+// events and sequences served by the API (defaults to logs.DefaultRedactKeys)
+func WithRedactKeys(keys ...string) HTTPServerOpt {
+	return func(h *HTTPServer) {
+		h.redactor = logs.NewRedactor(keys...)
+	}
+}
+
+// WithReplays configures replaying sequences through the API. Replays are
+// protected like the tasks API and limited like running tasks.
+func WithReplays(conf ReplayConf) HTTPServerOpt {
+	return func(h *HTTPServer) {
+		h.replay = conf
+	}
+}
+
+// WithRunnerMetrics serves the metrics collected by the runner in this process
+// at /debug/metrics
+func WithRunnerMetrics(metrics *CounterMetrics) HTTPServerOpt {
+	return func(h *HTTPServer) {
+		h.runnerMetrics = metrics
+	}
+}
+
+// WithShutdownTimeout sets how long in-flight requests are given to complete
+// once the server is stopped, defaulting to DefaultShutdownTimeout
+func WithShutdownTimeout(timeout time.Duration) HTTPServerOpt {
+	return func(h *HTTPServer) {
+		h.shutdownTimeout = timeout
+	}
+}
+
+// WithTLS serves over TLS with the cert and key files in conf, which are checked
+// when the server is created and reloaded whenever they change. Optionally also
+// serves redirects from HTTP to HTTPS.
+func WithTLS(conf TLSConf) HTTPServerOpt {
+	return func(h *HTTPServer) {
+		h.tlsConf = &conf
 	}
 }
@@ -0,0 +1,599 @@
+package hops
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hiphops-io/hops/dsl"
+	"github.com/hiphops-io/hops/logs"
+	"github.com/hiphops-io/hops/nats"
+)
+
+func TestHTTPServerAuth(t *testing.T) {
+	natsClient, _ := setupRunnerClient(t)
+
+	hopsDir := t.TempDir()
+	err := os.WriteFile(testHopsPath(t, hopsDir), []byte("task deploy {}\n"), 0o644)
+	require.NoError(t, err, "Test setup: Should write hops file")
+
+	hopsLoader, err := NewHopsFileLoader(hopsDir, false)
+	require.NoError(t, err, "Test setup: Hops files should load without error")
+
+	server, err := NewHTTPServer(
+		"127.0.0.1:0",
+		hopsLoader,
+		false,
+		natsClient,
+		logs.NoOpLogger(),
+		WithAuth(NewBearerTokenValidator("secret")),
+	)
+	require.NoError(t, err, "Test setup: Server should initialise")
+
+	tests := []struct {
+		name   string
+		path   string
+		token  string
+		status int
+	}{
+		{name: "Open route", path: "/updated-at", status: http.StatusOK},
+		{name: "Tasks", path: "/tasks", status: http.StatusUnauthorized},
+		{name: "Tasks with token", path: "/tasks", token: "secret", status: http.StatusOK},
+		{name: "Events", path: "/events", status: http.StatusUnauthorized},
+		{name: "Events with token", path: "/events", token: "secret", status: http.StatusOK},
+		{name: "Event stream", path: "/events/stream", status: http.StatusUnauthorized},
+		{name: "Sequence", path: "/sequences/SEQ_ID", status: http.StatusUnauthorized},
+		{name: "Sequence with token", path: "/sequences/SEQ_ID", token: "secret", status: http.StatusNotFound},
+		{name: "Sequence wildcard", path: "/sequences/*", status: http.StatusUnauthorized},
+		{name: "Sequence wildcard with token", path: "/sequences/*", token: "secret", status: http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			server.server.Handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.status, rec.Code)
+		})
+	}
+}
+
+func TestHTTPServerDebugHops(t *testing.T) {
+	hopsDir := t.TempDir()
+	hopsContent := `on testevent {
+  name = "pipeline"
+
+  call app_handler {}
+}
+
+task deploy {}
+`
+	err := os.WriteFile(testHopsPath(t, hopsDir), []byte(hopsContent), 0o644)
+	require.NoError(t, err, "Test setup: Should write hops file")
+
+	hopsLoader, err := NewHopsFileLoader(hopsDir, false)
+	require.NoError(t, err, "Test setup: Hops files should load without error")
+
+	h := &HTTPServer{hopsFileLoader: hopsLoader, logger: logs.NoOpLogger()}
+	err = h.Reload(context.Background())
+	require.NoError(t, err, "Test setup: Hops files should parse without error")
+
+	rec := httptest.NewRecorder()
+	h.getDebugHops(rec, httptest.NewRequest(http.MethodGet, "/debug/hops", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	response := debugHopsResponse{}
+	err = json.Unmarshal(rec.Body.Bytes(), &response)
+	require.NoError(t, err, "Response should be valid JSON")
+
+	assert.Equal(t, h.hopsFiles.Hash, response.Hash)
+	require.Len(t, response.Files, 1)
+	assert.Equal(t, filepath.Join("automation", "main.hops"), response.Files[0].File, "Files should be relative to the hops dir")
+	require.Len(t, response.Ons, 1)
+	assert.Equal(t, "pipeline", response.Ons[0].Slug)
+	assert.Equal(t, []string{"pipeline-app_handler0"}, response.Ons[0].Calls)
+	assert.Equal(t, []string{"deploy"}, response.Tasks)
+	assert.Empty(t, response.Diagnostics)
+
+	// Tolerant parse failures keep the last loaded files and surface the error
+	h.tolerantParse = true
+	err = os.WriteFile(testHopsPath(t, hopsDir), []byte("task deploy {\n  unknown = true\n}\n"), 0o644)
+	require.NoError(t, err, "Test setup: Should write hops file")
+	err = hopsLoader.Reload(context.Background(), true)
+	require.NoError(t, err, "Test setup: Hops files should reload without error")
+	err = h.Reload(context.Background())
+	require.NoError(t, err, "Tolerant reload should not error")
+
+	rec = httptest.NewRecorder()
+	h.getDebugHops(rec, httptest.NewRequest(http.MethodGet, "/debug/hops", nil))
+
+	response = debugHopsResponse{}
+	err = json.Unmarshal(rec.Body.Bytes(), &response)
+	require.NoError(t, err, "Response should be valid JSON")
+
+	require.Len(t, response.Diagnostics, 1)
+	assert.Contains(t, response.Diagnostics[0], "Unable to parse hops files")
+	assert.Equal(t, []string{"deploy"}, response.Tasks, "Previously loaded tasks should still be served")
+}
+
+func TestHTTPServerDebugMetrics(t *testing.T) {
+	h := &HTTPServer{logger: logs.NoOpLogger()}
+
+	rec := httptest.NewRecorder()
+	h.getDebugMetrics(rec, httptest.NewRequest(http.MethodGet, "/debug/metrics", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code, "Metrics should not be found without a runner")
+
+	metrics := NewCounterMetrics()
+	metrics.ObserveCalls(2, 1, 0)
+	WithRunnerMetrics(metrics)(h)
+
+	rec = httptest.NewRecorder()
+	h.getDebugMetrics(rec, httptest.NewRequest(http.MethodGet, "/debug/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	response := MetricsSnapshot{}
+	err := json.Unmarshal(rec.Body.Bytes(), &response)
+	require.NoError(t, err, "Response should be valid JSON")
+	assert.Equal(t, uint64(2), response.CallsDispatched)
+	assert.Equal(t, uint64(1), response.CallsSkipped)
+}
+
+func TestHTTPServerGracefulShutdown(t *testing.T) {
+	natsClient, _ := setupRunnerClient(t)
+
+	hopsDir := t.TempDir()
+	err := os.WriteFile(testHopsPath(t, hopsDir), []byte("task deploy {}\n"), 0o644)
+	require.NoError(t, err, "Test setup: Should write hops file")
+
+	hopsLoader, err := NewHopsFileLoader(hopsDir, false)
+	require.NoError(t, err, "Test setup: Hops files should load without error")
+
+	addr := freeAddr(t)
+	server, err := NewHTTPServer(addr, hopsLoader, false, natsClient, logs.NoOpLogger(), WithShutdownTimeout(5*time.Second))
+	require.NoError(t, err, "Test setup: Server should initialise")
+
+	// Swap in a slow handler, signalling once the request is in flight
+	started := make(chan struct{})
+	server.server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(500 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(ctx)
+	}()
+
+	type result struct {
+		body string
+		err  error
+	}
+	responses := make(chan result, 1)
+	go func() {
+		var resp *http.Response
+		var err error
+		for i := 0; i < 50; i++ {
+			resp, err = http.Get("http://" + addr + "/slow")
+			if err == nil {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		if err != nil {
+			responses <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		responses <- result{body: string(body), err: err}
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Slow request should have started")
+	}
+	cancel()
+
+	res := <-responses
+	require.NoError(t, res.err, "In-flight request should complete after cancellation")
+	assert.Equal(t, "done", res.body)
+
+	select {
+	case err := <-serveErr:
+		assert.NoError(t, err, "Serve should return without error once shut down")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve should return once shut down")
+	}
+}
+
+func TestHTTPServerStartTask(t *testing.T) {
+	natsClient, _ := setupRunnerClient(t)
+
+	hopsDir := t.TempDir()
+	hopsContent := `task deploy {
+  param env {
+    type     = "string"
+    required = true
+  }
+}
+`
+	err := os.WriteFile(testHopsPath(t, hopsDir), []byte(hopsContent), 0o644)
+	require.NoError(t, err, "Test setup: Should write hops file")
+
+	hopsLoader, err := NewHopsFileLoader(hopsDir, false)
+	require.NoError(t, err, "Test setup: Hops files should load without error")
+
+	server, err := NewHTTPServer("127.0.0.1:0", hopsLoader, false, natsClient, logs.NoOpLogger())
+	require.NoError(t, err, "Test setup: Server should initialise")
+
+	startTaskPath := func(path string, body string, header http.Header) (*httptest.ResponseRecorder, taskRunResponse) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		server.server.Handler.ServeHTTP(rec, req)
+
+		response := taskRunResponse{}
+		err := json.Unmarshal(rec.Body.Bytes(), &response)
+		require.NoError(t, err, "Response should be valid JSON")
+
+		return rec, response
+	}
+
+	startTaskError := func(taskName string, body string) (*httptest.ResponseRecorder, ErrorResponse) {
+		rec := httptest.NewRecorder()
+		server.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/tasks/"+taskName+"/run", strings.NewReader(body)))
+
+		response := ErrorResponse{}
+		err := json.Unmarshal(rec.Body.Bytes(), &response)
+		require.NoError(t, err, "Response should be valid JSON")
+
+		return rec, response
+	}
+
+	startTask := func(taskName string, body string) (*httptest.ResponseRecorder, taskRunResponse) {
+		return startTaskPath("/tasks/"+taskName+"/run", body, nil)
+	}
+
+	sourceMeta := func(t *testing.T, sequenceId string) nats.SourceMeta {
+		msg, err := natsClient.GetMsg(context.Background(), nats.ChannelNotify, sequenceId, nats.SourceEventId)
+		require.NoError(t, err, "Source event should be published")
+
+		meta, err := nats.ParseSourceMeta(msg.Data)
+		require.NoError(t, err, "Source event should be valid JSON")
+
+		return meta
+	}
+
+	t.Run("Invalid params", func(t *testing.T) {
+		rec, response := startTaskError("deploy", `{"env": 1}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, ErrorCodeInvalidInputs, response.Error.Code)
+		assert.Equal(t, []ErrorDetail{{Field: "env", Message: dsl.InvalidNotString}}, response.Error.Details)
+
+		rec, response = startTaskError("deploy", `{}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, []ErrorDetail{{Field: "env", Message: dsl.InvalidRequired}}, response.Error.Details)
+	})
+
+	t.Run("Unknown task", func(t *testing.T) {
+		rec, response := startTaskError("missing", `{}`)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Equal(t, ErrorCodeNotFound, response.Error.Code)
+	})
+
+	t.Run("Publishes source event", func(t *testing.T) {
+		rec, response := startTask("deploy", `{"env": "prod"}`)
+		require.Equal(t, http.StatusAccepted, rec.Code)
+		require.NotEmpty(t, response.SequenceID)
+
+		msg, err := natsClient.GetMsg(context.Background(), nats.ChannelNotify, response.SequenceID, nats.SourceEventId)
+		require.NoError(t, err, "Source event should be published")
+		assert.True(t, strings.HasSuffix(msg.Subject, ".notify."+response.SequenceID+".event"), "Unexpected subject %s", msg.Subject)
+
+		event := struct {
+			Env  string          `json:"env"`
+			Hops nats.SourceMeta `json:"hops"`
+		}{}
+		err = json.Unmarshal(msg.Data, &event)
+		require.NoError(t, err, "Source event should be valid JSON")
+
+		assert.Equal(t, "prod", event.Env)
+		assert.Equal(t, "hiphops", event.Hops.Source)
+		assert.Equal(t, "task", event.Hops.Event)
+		assert.Equal(t, "deploy", event.Hops.Action)
+		assert.Equal(t, server.hopsFiles.Hash, event.Hops.HopsHash)
+
+		// Running with the same params again starts a new sequence
+		rec, repeatResponse := startTask("deploy", `{"env": "prod"}`)
+		require.Equal(t, http.StatusAccepted, rec.Code)
+		assert.NotEqual(t, response.SequenceID, repeatResponse.SequenceID)
+	})
+
+	t.Run("Carries the request ID", func(t *testing.T) {
+		header := http.Header{logs.RequestIDHeader: []string{"req-123"}}
+		rec, response := startTaskPath("/tasks/deploy/run", `{"env": "staging"}`, header)
+		require.Equal(t, http.StatusAccepted, rec.Code)
+		assert.Equal(t, "req-123", rec.Header().Get(logs.RequestIDHeader), "Given request ID should be returned")
+		assert.Equal(t, "req-123", sourceMeta(t, response.SequenceID).RequestId, "Source event should carry the request ID")
+
+		rec, response = startTaskPath("/tasks/deploy", `{"env": "dev"}`, nil)
+		require.Equal(t, http.StatusOK, rec.Code)
+		requestId := rec.Header().Get(logs.RequestIDHeader)
+		assert.NotEmpty(t, requestId, "Requests without an ID should be given one")
+		assert.Equal(t, requestId, sourceMeta(t, response.SequenceID).RequestId)
+
+		// Request IDs don't change the sequence of identical runs
+		_, repeatResponse := startTaskPath("/tasks/deploy", `{"env": "dev"}`, nil)
+		assert.Equal(t, response.SequenceID, repeatResponse.SequenceID)
+	})
+}
+
+func TestHTTPServerGlobalRateLimit(t *testing.T) {
+	natsClient, _ := setupRunnerClient(t)
+
+	hopsDir := t.TempDir()
+	err := os.WriteFile(testHopsPath(t, hopsDir), []byte("task deploy {}\n"), 0o644)
+	require.NoError(t, err, "Test setup: Should write hops file")
+
+	hopsLoader, err := NewHopsFileLoader(hopsDir, false)
+	require.NoError(t, err, "Test setup: Hops files should load without error")
+
+	server, err := NewHTTPServer(
+		"127.0.0.1:0",
+		hopsLoader,
+		false,
+		natsClient,
+		logs.NoOpLogger(),
+		WithGlobalRateLimit(NewMemoryRateLimitStore(0.01, 3)),
+	)
+	require.NoError(t, err, "Test setup: Server should initialise")
+
+	serve := func(method string, path string, remoteAddr string) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(`{}`))
+		req.RemoteAddr = remoteAddr
+		server.server.Handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Every run is from a different client, so only the global limit applies
+	var wg sync.WaitGroup
+	codes := make(chan int, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes <- serve(http.MethodPost, "/tasks/deploy/run", fmt.Sprintf("10.0.0.%d:1234", i))
+		}(i)
+	}
+	wg.Wait()
+	close(codes)
+
+	counts := map[int]int{}
+	for code := range codes {
+		counts[code]++
+	}
+	assert.Equal(t, map[int]int{http.StatusAccepted: 3, http.StatusTooManyRequests: 7}, counts)
+
+	assert.Equal(t, http.StatusTooManyRequests, serve(http.MethodPost, "/tasks/deploy", "10.0.1.1:1234"), "Every way of running tasks should be limited")
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/tasks/deploy", "10.0.1.1:1234"), "Reading tasks should not be limited globally")
+}
+
+func TestHTTPServerErrorResponses(t *testing.T) {
+	natsClient, _ := setupRunnerClient(t)
+
+	hopsDir := t.TempDir()
+	err := os.WriteFile(testHopsPath(t, hopsDir), []byte("task deploy {}\n"), 0o644)
+	require.NoError(t, err, "Test setup: Should write hops file")
+
+	hopsLoader, err := NewHopsFileLoader(hopsDir, false)
+	require.NoError(t, err, "Test setup: Hops files should load without error")
+
+	server, err := NewHTTPServer(
+		"127.0.0.1:0",
+		hopsLoader,
+		false,
+		natsClient,
+		logs.NoOpLogger(),
+		WithRateLimit(NewMemoryRateLimitStore(0.01, 3), nil),
+	)
+	require.NoError(t, err, "Test setup: Server should initialise")
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		code   string
+	}{
+		{name: "Invalid query", method: http.MethodGet, path: "/tasks?limit=0", status: http.StatusBadRequest, code: ErrorCodeBadRequest},
+		{name: "Invalid payload", method: http.MethodPost, path: "/tasks/deploy", body: "{", status: http.StatusBadRequest, code: ErrorCodeBadRequest},
+		{name: "Unknown task", method: http.MethodPost, path: "/tasks/missing", body: "{}", status: http.StatusNotFound, code: ErrorCodeNotFound},
+		{name: "Unknown route", method: http.MethodGet, path: "/missing", status: http.StatusNotFound, code: ErrorCodeNotFound},
+		{name: "Unknown method", method: http.MethodDelete, path: "/updated-at", status: http.StatusMethodNotAllowed, code: ErrorCodeMethodNotAllowed},
+		// The burst of the rate limit is used up by the requests to /tasks before
+		{name: "Rate limited", method: http.MethodGet, path: "/tasks", status: http.StatusTooManyRequests, code: ErrorCodeRateLimited},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			req.Header.Set(logs.RequestIDHeader, "req-123")
+			server.server.Handler.ServeHTTP(rec, req)
+
+			require.Equal(t, tc.status, rec.Code)
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+			response := map[string]map[string]any{}
+			err := json.Unmarshal(rec.Body.Bytes(), &response)
+			require.NoError(t, err, "Response should be valid JSON")
+			require.Contains(t, response, "error", "Errors should be in the envelope")
+
+			body := response["error"]
+			assert.Equal(t, tc.code, body["code"])
+			assert.NotEmpty(t, body["message"])
+			assert.Equal(t, []any{}, body["details"], "Details should always be a list")
+			assert.Equal(t, "req-123", body["request_id"])
+		})
+	}
+}
+
+func TestHTTPServerListTasks(t *testing.T) {
+	natsClient, _ := setupRunnerClient(t)
+
+	hopsDir := t.TempDir()
+	hopsContent := `task rollback {
+  summary = "Roll back a release"
+}
+
+task deploy {
+  description = "Deploys the app to an environment"
+
+  param env {
+    type     = "string"
+    required = true
+  }
+}
+
+task backup_db {
+  display_name = "Back up database"
+}
+
+task clear_cache {}
+`
+	err := os.WriteFile(testHopsPath(t, hopsDir), []byte(hopsContent), 0o644)
+	require.NoError(t, err, "Test setup: Should write hops file")
+
+	hopsLoader, err := NewHopsFileLoader(hopsDir, false)
+	require.NoError(t, err, "Test setup: Hops files should load without error")
+
+	server, err := NewHTTPServer("127.0.0.1:0", hopsLoader, false, natsClient, logs.NoOpLogger())
+	require.NoError(t, err, "Test setup: Server should initialise")
+
+	get := func(t *testing.T, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		server.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+		return rec
+	}
+
+	listTasks := func(t *testing.T, query string) TaskList {
+		rec := get(t, "/tasks?"+query)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		taskList := TaskList{}
+		err := json.Unmarshal(rec.Body.Bytes(), &taskList)
+		require.NoError(t, err, "Response should be valid JSON")
+		return taskList
+	}
+
+	taskNames := func(taskList TaskList) []string {
+		names := []string{}
+		for _, task := range taskList.Tasks {
+			names = append(names, task.Name)
+		}
+		return names
+	}
+
+	t.Run("Lists every task as an array if unpaged", func(t *testing.T) {
+		rec := get(t, "/tasks")
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		tasks := []dsl.TaskAST{}
+		err := json.Unmarshal(rec.Body.Bytes(), &tasks)
+		require.NoError(t, err, "Response should be a JSON array of tasks")
+		assert.Equal(t, []string{"backup_db", "clear_cache", "deploy", "rollback"}, taskNames(TaskList{Tasks: tasks}))
+	})
+
+	t.Run("Lists tasks by name", func(t *testing.T) {
+		taskList := listTasks(t, "limit=10")
+		assert.Equal(t, []string{"backup_db", "clear_cache", "deploy", "rollback"}, taskNames(taskList))
+		assert.Equal(t, 4, taskList.Total)
+		assert.Empty(t, taskList.NextCursor)
+	})
+
+	t.Run("Paginates", func(t *testing.T) {
+		taskList := listTasks(t, "limit=3")
+		assert.Equal(t, []string{"backup_db", "clear_cache", "deploy"}, taskNames(taskList))
+		assert.Equal(t, 4, taskList.Total)
+		require.NotEmpty(t, taskList.NextCursor)
+
+		taskList = listTasks(t, "limit=3&cursor="+taskList.NextCursor)
+		assert.Equal(t, []string{"rollback"}, taskNames(taskList))
+		assert.Empty(t, taskList.NextCursor)
+	})
+
+	t.Run("Searches", func(t *testing.T) {
+		tests := map[string][]string{
+			"DEPLOY":   {"deploy"},
+			"database": {"backup_db"},
+			"release":  {"rollback"},
+			"app to":   {"deploy"},
+			"missing":  {},
+		}
+
+		for search, expected := range tests {
+			taskList := listTasks(t, "q="+url.QueryEscape(search))
+			assert.Equal(t, expected, taskNames(taskList), "Search for '%s'", search)
+			assert.Equal(t, len(expected), taskList.Total)
+		}
+	})
+
+	t.Run("Rejects invalid params", func(t *testing.T) {
+		for _, query := range []string{"limit=0", "limit=1000", "limit=lots", "cursor=%21"} {
+			rec := get(t, "/tasks?"+query)
+			assert.Equal(t, http.StatusBadRequest, rec.Code, "Query '%s' should be rejected", query)
+		}
+	})
+
+	t.Run("Gets a task", func(t *testing.T) {
+		rec := get(t, "/tasks/deploy")
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		task := dsl.TaskAST{}
+		err := json.Unmarshal(rec.Body.Bytes(), &task)
+		require.NoError(t, err, "Response should be valid JSON")
+		assert.Equal(t, "deploy", task.Name)
+		require.Len(t, task.Params, 1)
+		assert.Equal(t, "env", task.Params[0].Name)
+		assert.True(t, task.Params[0].Required)
+	})
+
+	t.Run("Unknown task", func(t *testing.T) {
+		rec := get(t, "/tasks/missing")
+		require.Equal(t, http.StatusNotFound, rec.Code)
+
+		response := ErrorResponse{}
+		err := json.Unmarshal(rec.Body.Bytes(), &response)
+		require.NoError(t, err, "Response should be valid JSON")
+		assert.Equal(t, ErrorCodeNotFound, response.Error.Code)
+		assert.Equal(t, "Task 'missing' not found", response.Error.Message)
+	})
+}
@@ -0,0 +1,144 @@
+package hops
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hiphops-io/hops/worker"
+)
+
+// DefaultRunnerDurationBuckets are the histogram bucket upper bounds used by
+// NewCounterMetrics when none are given
+//
+// Runner work is mostly parsing and publishing, so these are much finer than
+// the buckets used for worker handlers.
+var DefaultRunnerDurationBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+type (
+	// CounterMetrics is an in-memory Metrics implementation using atomic counters
+	//
+	// Snapshot can be used to expose the collected metrics however the host process likes.
+	CounterMetrics struct {
+		bundleFetch     *worker.Histogram
+		callsDispatched atomic.Uint64
+		callsErrored    atomic.Uint64
+		callsSkipped    atomic.Uint64
+		parse           *worker.Histogram
+		sensorsFailed   atomic.Uint64
+		sequence        *worker.Histogram
+		sequences       sync.Map // map[string]*atomic.Uint64
+	}
+
+	// Metrics receives observations about the sequences processed by a Runner
+	//
+	// Implementations are called concurrently, and should bridge to whatever
+	// the host process uses for metrics (e.g. Prometheus or expvar).
+	Metrics interface {
+		// ObserveBundleFetch is called with the time taken to fetch a sequence's
+		// message bundle, when the client reports it
+		ObserveBundleFetch(duration time.Duration)
+
+		// ObserveCalls is called once per matched on block with the number of
+		// calls dispatched, skipped (already dispatched or 'if' not met) and
+		// errored whilst dispatching
+		ObserveCalls(dispatched int, skipped int, errored int)
+
+		// ObserveParse is called with the time taken to parse the hops config
+		// against a sequence
+		ObserveParse(duration time.Duration)
+
+		// ObserveSensorFailures is called with the number of on blocks that failed
+		// due to the hops config whilst evaluating a sequence, if any did
+		ObserveSensorFailures(failed int)
+
+		// ObserveSequence is called after a sequence is evaluated with the
+		// end-to-end callback duration and outcome (worker.OutcomeSuccess or
+		// worker.OutcomeFailure)
+		ObserveSequence(duration time.Duration, outcome string)
+	}
+
+	// MetricsSnapshot is a point in time copy of all metrics held by CounterMetrics
+	MetricsSnapshot struct {
+		BundleFetch     worker.HistogramSnapshot `json:"bundle_fetch"`
+		CallsDispatched uint64                   `json:"calls_dispatched"`
+		CallsErrored    uint64                   `json:"calls_errored"`
+		CallsSkipped    uint64                   `json:"calls_skipped"`
+		Parse           worker.HistogramSnapshot `json:"parse"`
+		SensorsFailed   uint64                   `json:"sensors_failed"`
+		Sequence        worker.HistogramSnapshot `json:"sequence"`
+		Sequences       map[string]uint64        `json:"sequences"`
+	}
+)
+
+// NewCounterMetrics creates a CounterMetrics with the given histogram bucket
+// upper bounds, or DefaultRunnerDurationBuckets if none are given
+func NewCounterMetrics(buckets ...time.Duration) *CounterMetrics {
+	if len(buckets) == 0 {
+		buckets = DefaultRunnerDurationBuckets
+	}
+
+	buckets = append([]time.Duration{}, buckets...)
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+
+	return &CounterMetrics{
+		bundleFetch: worker.NewHistogram(buckets),
+		parse:       worker.NewHistogram(buckets),
+		sequence:    worker.NewHistogram(buckets),
+	}
+}
+
+func (c *CounterMetrics) ObserveBundleFetch(duration time.Duration) {
+	c.bundleFetch.Observe(duration)
+}
+
+func (c *CounterMetrics) ObserveCalls(dispatched int, skipped int, errored int) {
+	c.callsDispatched.Add(uint64(dispatched))
+	c.callsSkipped.Add(uint64(skipped))
+	c.callsErrored.Add(uint64(errored))
+}
+
+func (c *CounterMetrics) ObserveParse(duration time.Duration) {
+	c.parse.Observe(duration)
+}
+
+func (c *CounterMetrics) ObserveSensorFailures(failed int) {
+	c.sensorsFailed.Add(uint64(failed))
+}
+
+func (c *CounterMetrics) ObserveSequence(duration time.Duration, outcome string) {
+	c.sequence.Observe(duration)
+
+	counter, _ := c.sequences.LoadOrStore(outcome, &atomic.Uint64{})
+	counter.(*atomic.Uint64).Add(1)
+}
+
+// Snapshot returns a copy of the current metrics
+func (c *CounterMetrics) Snapshot() MetricsSnapshot {
+	sequences := map[string]uint64{}
+	c.sequences.Range(func(key, value any) bool {
+		sequences[key.(string)] = value.(*atomic.Uint64).Load()
+		return true
+	})
+
+	return MetricsSnapshot{
+		BundleFetch:     c.bundleFetch.Snapshot(),
+		CallsDispatched: c.callsDispatched.Load(),
+		CallsErrored:    c.callsErrored.Load(),
+		CallsSkipped:    c.callsSkipped.Load(),
+		Parse:           c.parse.Snapshot(),
+		SensorsFailed:   c.sensorsFailed.Load(),
+		Sequence:        c.sequence.Snapshot(),
+		Sequences:       sequences,
+	}
+}
@@ -0,0 +1,101 @@
+package hops
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hiphops-io/hops/logs"
+	"github.com/hiphops-io/hops/nats"
+	"github.com/hiphops-io/hops/worker"
+)
+
+func TestCounterMetrics(t *testing.T) {
+	metrics := NewCounterMetrics(time.Millisecond, time.Second)
+
+	metrics.ObserveSequence(500*time.Microsecond, worker.OutcomeSuccess)
+	metrics.ObserveSequence(100*time.Millisecond, worker.OutcomeSuccess)
+	metrics.ObserveSequence(time.Minute, worker.OutcomeFailure)
+	metrics.ObserveParse(time.Millisecond)
+	metrics.ObserveCalls(2, 1, 0)
+	metrics.ObserveCalls(1, 0, 1)
+
+	snapshot := metrics.Snapshot()
+
+	assert.Equal(t, map[string]uint64{worker.OutcomeSuccess: 2, worker.OutcomeFailure: 1}, snapshot.Sequences)
+	assert.Equal(t, uint64(3), snapshot.Sequence.Count)
+	assert.Equal(t, []uint64{1, 1, 1}, snapshot.Sequence.Counts, "Durations should be counted in the correct buckets")
+	assert.Equal(t, []uint64{1, 0, 0}, snapshot.Parse.Counts, "Bucket upper bounds should be inclusive")
+	assert.Equal(t, uint64(0), snapshot.BundleFetch.Count)
+
+	assert.Equal(t, uint64(3), snapshot.CallsDispatched)
+	assert.Equal(t, uint64(1), snapshot.CallsSkipped)
+	assert.Equal(t, uint64(1), snapshot.CallsErrored)
+}
+
+func TestRunnerMetrics(t *testing.T) {
+	ctx := context.Background()
+	logger := logs.NoOpLogger()
+	natsClient, _ := setupRunnerClient(t)
+
+	hopsDir := t.TempDir()
+	content, err := os.ReadFile("./testdata/simple.hops")
+	require.NoError(t, err, "Test setup: Should read hops file")
+	err = os.WriteFile(testHopsPath(t, hopsDir), content, 0o644)
+	require.NoError(t, err, "Test setup: Should write hops file")
+
+	hopsLoader, err := NewHopsFileLoader(hopsDir, false)
+	require.NoError(t, err, "Test setup: Hops files should load without error")
+
+	metrics := NewCounterMetrics()
+	runner, err := NewRunner(natsClient, hopsLoader, logger, WithMetrics(metrics))
+	require.NoError(t, err, "Test setup: Runner should initialise without error")
+
+	eventData, err := os.ReadFile("./testdata/source_testevent.json")
+	require.NoError(t, err, "Test setup: Should read source event")
+
+	msgs := []struct {
+		data  []byte
+		msgId string
+	}{
+		{data: eventData, msgId: "event"},
+		{data: []byte(`{"completed": true}`), msgId: "unrelated_one"},
+		{data: []byte(`{"completed": true}`), msgId: "unrelated_two"},
+	}
+
+	for _, msg := range msgs {
+		puback, _, err := natsClient.Publish(ctx, msg.data, nats.ChannelNotify, "SEQ_ID", msg.msgId)
+		require.NoError(t, err, "Test setup: Message should be published")
+
+		incomingMsg := &nats.MsgMeta{
+			AccountId:      natsClient.AccountId(),
+			InterestTopic:  natsClient.InterestTopic(),
+			SequenceId:     "SEQ_ID",
+			StreamSequence: puback.Sequence,
+		}
+		msgBundle, err := natsClient.FetchMessageBundle(ctx, incomingMsg)
+		require.NoError(t, err, "Test setup: Message bundle should be fetched without error")
+
+		err = runner.SequenceCallback(ctx, "SEQ_ID", msgBundle)
+		require.NoError(t, err, "Sequence should be processed without error")
+	}
+
+	// Sequences without a source event fail to parse
+	err = runner.SequenceCallback(ctx, "OTHER_SEQ_ID", nats.MessageBundle{"unrelated": []byte(`{"completed": true}`)})
+	require.Error(t, err, "Test setup: Sequence without a source event should fail")
+
+	snapshot := metrics.Snapshot()
+
+	assert.Equal(t, map[string]uint64{worker.OutcomeSuccess: 3, worker.OutcomeFailure: 1}, snapshot.Sequences, "Every evaluated sequence should be counted")
+	assert.Equal(t, uint64(4), snapshot.Sequence.Count)
+	assert.Equal(t, uint64(4), snapshot.Parse.Count)
+	assert.Equal(t, uint64(0), snapshot.BundleFetch.Count, "Bundle fetch time is only known when consuming from the stream")
+
+	assert.Equal(t, uint64(1), snapshot.CallsDispatched, "The call should be dispatched once")
+	assert.Equal(t, uint64(2), snapshot.CallsSkipped, "Later messages should skip the already dispatched call")
+	assert.Equal(t, uint64(0), snapshot.CallsErrored)
+}
@@ -0,0 +1,368 @@
+package hops
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// openAPIVersion is the version of the OpenAPI specification the API is described with
+const openAPIVersion = "3.0.3"
+
+// openAPIDocsPage renders the spec at openapi.json with Redoc, relative to the
+// page so it works under any base path
+//
+// Redoc is loaded from its CDN rather than embedded, keeping its bundle (around
+// 1MB) out of the binary, so browsers need access to cdn.redoc.ly to render the
+// docs. Where they don't, the page links to the spec instead.
+const openAPIDocsPage = `<!DOCTYPE html>
+<html>
+  <head>
+    <title>Hops API</title>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+  </head>
+  <body>
+    <redoc spec-url="openapi.json"></redoc>
+    <p id="redoc-unavailable" hidden>
+      The docs are rendered by Redoc, loaded from cdn.redoc.ly, which isn't reachable.
+      The API is described by <a href="openapi.json">openapi.json</a>.
+    </p>
+    <script
+      src="https://cdn.redoc.ly/redoc/v2.1.3/bundles/redoc.standalone.js"
+      onerror="document.getElementById('redoc-unavailable').hidden = false"
+    ></script>
+  </body>
+</html>
+`
+
+// bearerAuthScheme names the security scheme of routes protected by WithAuth
+const bearerAuthScheme = "bearerAuth"
+
+type (
+	// openAPISpec is an OpenAPI 3 description of the HTTP API, served at /openapi.json
+	//
+	// Operations are described next to their handlers, as openAPIPaths, and
+	// their schemas generated from the types they respond with.
+	openAPISpec struct {
+		OpenAPI    string            `json:"openapi"`
+		Info       openAPIInfo       `json:"info"`
+		Servers    []openAPIServer   `json:"servers,omitempty"`
+		Paths      openAPIPaths      `json:"paths"`
+		Components openAPIComponents `json:"components"`
+	}
+
+	openAPIInfo struct {
+		Title   string `json:"title"`
+		Version string `json:"version"`
+	}
+
+	openAPIServer struct {
+		URL string `json:"url"`
+	}
+
+	// openAPIPaths are the operations of each path, keyed by path then lower
+	// case method
+	openAPIPaths map[string]map[string]*openAPIOperation
+
+	openAPIOperation struct {
+		OperationID string                     `json:"operationId"`
+		Summary     string                     `json:"summary"`
+		Description string                     `json:"description,omitempty"`
+		Tags        []string                   `json:"tags,omitempty"`
+		Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+		RequestBody *openAPIRequestBody        `json:"requestBody,omitempty"`
+		Responses   map[string]openAPIResponse `json:"responses"`
+		Security    []map[string][]string      `json:"security,omitempty"`
+		// partlyProtected operations only require auth for some requests (e.g.
+		// verbose health checks), so may also be called without it
+		partlyProtected bool
+		// protected operations require auth if the server is given WithAuth
+		protected bool
+	}
+
+	openAPIParameter struct {
+		Name        string      `json:"name"`
+		In          string      `json:"in"`
+		Description string      `json:"description,omitempty"`
+		Required    bool        `json:"required,omitempty"`
+		Schema      *jsonSchema `json:"schema"`
+	}
+
+	openAPIRequestBody struct {
+		Description string                      `json:"description,omitempty"`
+		Required    bool                        `json:"required,omitempty"`
+		Content     map[string]openAPIMediaType `json:"content"`
+	}
+
+	openAPIResponse struct {
+		Ref         string                      `json:"$ref,omitempty"`
+		Description string                      `json:"description,omitempty"`
+		Content     map[string]openAPIMediaType `json:"content,omitempty"`
+	}
+
+	openAPIMediaType struct {
+		Schema *jsonSchema `json:"schema"`
+	}
+
+	openAPIComponents struct {
+		Responses       map[string]openAPIResponse       `json:"responses"`
+		Schemas         map[string]*jsonSchema           `json:"schemas"`
+		SecuritySchemes map[string]openAPISecurityScheme `json:"securitySchemes,omitempty"`
+	}
+
+	openAPISecurityScheme struct {
+		Type   string `json:"type"`
+		Scheme string `json:"scheme"`
+	}
+
+	jsonSchema struct {
+		Ref                  string                 `json:"$ref,omitempty"`
+		Type                 string                 `json:"type,omitempty"`
+		Format               string                 `json:"format,omitempty"`
+		Description          string                 `json:"description,omitempty"`
+		Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+		Items                *jsonSchema            `json:"items,omitempty"`
+		AdditionalProperties *jsonSchema            `json:"additionalProperties,omitempty"`
+		OneOf                []*jsonSchema          `json:"oneOf,omitempty"`
+	}
+)
+
+// newOpenAPISpec describes the routes of the HTTP server, served under basePath.
+// Protected operations require bearer auth if authenticated.
+func newOpenAPISpec(basePath string, authenticated bool) openAPISpec {
+	spec := openAPISpec{
+		OpenAPI: openAPIVersion,
+		Info:    openAPIInfo{Title: "Hops API", Version: "1"},
+		Paths:   openAPIPaths{},
+		Components: openAPIComponents{
+			Responses: map[string]openAPIResponse{},
+			Schemas: map[string]*jsonSchema{
+				"ErrorResponse": schemaOf(ErrorResponse{}),
+			},
+		},
+	}
+
+	if basePath != "" {
+		spec.Servers = []openAPIServer{{URL: basePath}}
+	}
+
+	for status, description := range map[int]string{
+		http.StatusBadRequest:            "The request is invalid",
+		http.StatusUnauthorized:          "The request's credentials are missing or invalid",
+		http.StatusNotFound:              "Not found",
+		http.StatusConflict:              "The request conflicts with the current state",
+		http.StatusRequestEntityTooLarge: "The request body is too large",
+		http.StatusTooManyRequests:       "Rate limited, retry after the Retry-After header's seconds",
+		http.StatusInternalServerError:   "The server failed to handle the request",
+		http.StatusServiceUnavailable:    "A component the server depends on is unavailable",
+	} {
+		spec.Components.Responses[errorResponseName(status)] = openAPIResponse{
+			Description: description,
+			Content:     jsonContent(&jsonSchema{Ref: "#/components/schemas/ErrorResponse"}),
+		}
+	}
+
+	if authenticated {
+		spec.Components.SecuritySchemes = map[string]openAPISecurityScheme{
+			bearerAuthScheme: {Type: "http", Scheme: "bearer"},
+		}
+	}
+
+	for _, paths := range []openAPIPaths{
+		eventOpenAPIPaths(),
+		healthOpenAPIPaths(),
+		metaOpenAPIPaths(),
+		sequenceOpenAPIPaths(),
+		serverOpenAPIPaths(),
+		validateOpenAPIPaths(),
+	} {
+		for path, operations := range paths {
+			if spec.Paths[path] == nil {
+				spec.Paths[path] = map[string]*openAPIOperation{}
+			}
+
+			for method, operation := range operations {
+				if (operation.protected || operation.partlyProtected) && authenticated {
+					operation.Security = []map[string][]string{{bearerAuthScheme: {}}}
+					if operation.partlyProtected {
+						// An empty requirement makes auth optional
+						operation.Security = append([]map[string][]string{{}}, operation.Security...)
+					}
+					operation.Responses[strconv.Itoa(http.StatusUnauthorized)] = errorResponse(http.StatusUnauthorized)
+				}
+				spec.Paths[path][method] = operation
+			}
+		}
+	}
+
+	return spec
+}
+
+// getOpenAPI responds with the OpenAPI description of the API
+func (h *HTTPServer) getOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(h.openAPI)
+}
+
+// getAPIDocs responds with a page rendering the OpenAPI description of the API
+func (h *HTTPServer) getAPIDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(openAPIDocsPage))
+}
+
+// metaOpenAPIPaths describes the routes describing the API
+func metaOpenAPIPaths() openAPIPaths {
+	return openAPIPaths{
+		"/docs": {
+			"get": {
+				OperationID: "getAPIDocs",
+				Summary:     "Browse this description of the API",
+				Tags:        []string{"meta"},
+				Responses: responses(map[int]openAPIResponse{
+					http.StatusOK: {
+						Description: "A page rendering the API's description",
+						Content:     map[string]openAPIMediaType{"text/html": {Schema: &jsonSchema{Type: "string"}}},
+					},
+				}),
+			},
+		},
+		"/openapi.json": {
+			"get": {
+				OperationID: "getOpenAPI",
+				Summary:     "Get this description of the API",
+				Tags:        []string{"meta"},
+				Responses: responses(map[int]openAPIResponse{
+					http.StatusOK: {Description: "The OpenAPI description of the API", Content: jsonContent(&jsonSchema{Type: "object"})},
+				}),
+			},
+		},
+	}
+}
+
+// responses describes the responses of an operation by status, along with the
+// standard error responses of errorStatuses
+func responses(described map[int]openAPIResponse, errorStatuses ...int) map[string]openAPIResponse {
+	all := map[string]openAPIResponse{}
+	for status, response := range described {
+		all[strconv.Itoa(status)] = response
+	}
+	for _, status := range errorStatuses {
+		all[strconv.Itoa(status)] = errorResponse(status)
+	}
+
+	return all
+}
+
+// jsonResponse describes a JSON response with the schema of v
+func jsonResponse(description string, v any) openAPIResponse {
+	return openAPIResponse{Description: description, Content: jsonContent(schemaOf(v))}
+}
+
+func jsonContent(schema *jsonSchema) map[string]openAPIMediaType {
+	return map[string]openAPIMediaType{"application/json": {Schema: schema}}
+}
+
+// errorResponse refers to the standard error response of status
+func errorResponse(status int) openAPIResponse {
+	return openAPIResponse{Ref: "#/components/responses/" + errorResponseName(status)}
+}
+
+func errorResponseName(status int) string {
+	return strings.ReplaceAll(http.StatusText(status), " ", "")
+}
+
+func pathParam(name string, description string) openAPIParameter {
+	return openAPIParameter{Name: name, In: "path", Description: description, Required: true, Schema: &jsonSchema{Type: "string"}}
+}
+
+func queryParam(name string, description string, schemaType string) openAPIParameter {
+	return openAPIParameter{Name: name, In: "query", Description: description, Schema: &jsonSchema{Type: schemaType}}
+}
+
+var (
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	timeType       = reflect.TypeOf(time.Time{})
+)
+
+// schemaOf generates the JSON schema of the JSON encoding of v, from its type
+func schemaOf(v any) *jsonSchema {
+	return schemaOfType(reflect.TypeOf(v), map[reflect.Type]bool{})
+}
+
+func schemaOfType(t reflect.Type, seen map[reflect.Type]bool) *jsonSchema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType:
+		return &jsonSchema{Type: "string", Format: "date-time"}
+	case rawMessageType:
+		// Raw JSON could be anything
+		return &jsonSchema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &jsonSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: "number"}
+	case reflect.String:
+		return &jsonSchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		// Bytes are encoded as base64 strings
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &jsonSchema{Type: "string", Format: "byte"}
+		}
+		return &jsonSchema{Type: "array", Items: schemaOfType(t.Elem(), seen)}
+	case reflect.Map:
+		return &jsonSchema{Type: "object", AdditionalProperties: schemaOfType(t.Elem(), seen)}
+	case reflect.Struct:
+		// Recursive types are left open, rather than described forever
+		if seen[t] {
+			return &jsonSchema{Type: "object"}
+		}
+		seen[t] = true
+		defer delete(seen, t)
+
+		schema := &jsonSchema{Type: "object", Properties: map[string]*jsonSchema{}}
+		addStructProperties(schema, t, seen)
+		return schema
+	default:
+		// Interfaces hold any JSON value
+		return &jsonSchema{}
+	}
+}
+
+// addStructProperties adds the JSON encoded fields of struct type t to schema,
+// including those of embedded structs
+func addStructProperties(schema *jsonSchema, t reflect.Type, seen map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			addStructProperties(schema, field.Type, seen)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		schema.Properties[name] = schemaOfType(field.Type, seen)
+	}
+}
@@ -0,0 +1,165 @@
+package hops
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hiphops-io/hops/logs"
+)
+
+func TestOpenAPIDescribesRoutes(t *testing.T) {
+	natsClient, _ := setupRunnerClient(t)
+
+	hopsDir := t.TempDir()
+	err := os.WriteFile(testHopsPath(t, hopsDir), []byte("task deploy {}\n"), 0o644)
+	require.NoError(t, err, "Test setup: Should write hops file")
+
+	hopsLoader, err := NewHopsFileLoader(hopsDir, false)
+	require.NoError(t, err, "Test setup: Hops files should load without error")
+
+	server, err := NewHTTPServer(
+		"127.0.0.1:0",
+		hopsLoader,
+		false,
+		natsClient,
+		logs.NoOpLogger(),
+		WithAuth(NewBearerTokenValidator("secret")),
+	)
+	require.NoError(t, err, "Test setup: Server should initialise")
+
+	rec := httptest.NewRecorder()
+	server.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	spec := openAPISpec{}
+	err = json.Unmarshal(rec.Body.Bytes(), &spec)
+	require.NoError(t, err, "Spec should be valid JSON")
+	assert.Equal(t, openAPIVersion, spec.OpenAPI)
+	assert.Contains(t, spec.Components.Schemas, "ErrorResponse", "Error envelope should be described")
+	assert.Equal(t, openAPISecurityScheme{Type: "http", Scheme: "bearer"}, spec.Components.SecuritySchemes[bearerAuthScheme])
+	assert.Equal(t, []map[string][]string{{}, {bearerAuthScheme: {}}}, spec.Paths["/health"]["get"].Security, "Partly protected routes should make auth optional")
+	assert.False(t, isProtected(t, server.server.Handler, http.MethodGet, "/health"))
+	assert.True(t, isProtected(t, server.server.Handler, http.MethodGet, "/health?verbose=1"))
+
+	// Every route served should be described, and every route described served
+	// (except the health checks, which are served by middleware). Routes are
+	// protected if they refuse requests without credentials, which is checked
+	// against their description.
+	routes := map[string]bool{}
+	protected := map[string]bool{}
+	err = chi.Walk(server.server.Handler.(chi.Router), func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		if strings.HasPrefix(route, "/console") {
+			return nil
+		}
+		if route != "/" {
+			route = strings.TrimSuffix(route, "/")
+		}
+
+		routes[strings.ToLower(method)+" "+route] = true
+		protected[strings.ToLower(method)+" "+route] = isProtected(t, server.server.Handler, method, route)
+		return nil
+	})
+	require.NoError(t, err, "Routes should be walked")
+	require.NotEmpty(t, routes, "Routes should be served")
+
+	described := map[string]bool{}
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			described[method+" "+path] = true
+		}
+	}
+
+	for route := range routes {
+		assert.True(t, described[route], "Route '%s' should be described", route)
+	}
+	for path, operations := range spec.Paths {
+		for method, operation := range operations {
+			route := method + " " + path
+			if !routes[route] {
+				continue
+			}
+
+			assert.Equal(t, protected[route], len(operation.Security) > 0, "Route '%s' should be described as protected only if it is", route)
+		}
+	}
+	for route := range described {
+		if route == "get /health" || route == "get /healthz" {
+			continue
+		}
+		assert.True(t, routes[route], "Route '%s' should be served", route)
+	}
+
+	rec = httptest.NewRecorder()
+	server.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get("Content-Type"), "text/html")
+	assert.Contains(t, rec.Body.String(), `spec-url="openapi.json"`)
+	assert.Contains(t, rec.Body.String(), `href="openapi.json"`, "The spec should be linked if Redoc can't load")
+}
+
+// isProtected returns whether the route refuses requests without credentials
+//
+// Path params are filled with placeholders, as auth is checked before they are.
+func isProtected(t *testing.T, handler http.Handler, method string, route string) bool {
+	path := regexp.MustCompile(`\{[^}]+\}`).ReplaceAllString(route, "placeholder")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader("{}")))
+	if rec.Code != http.StatusUnauthorized {
+		return false
+	}
+
+	response := ErrorResponse{}
+	err := json.Unmarshal(rec.Body.Bytes(), &response)
+	require.NoError(t, err, "Unauthorized response should be valid JSON")
+
+	return response.Error.Code == AuthCodeMissingCredentials
+}
+
+func TestSchemaOf(t *testing.T) {
+	type nested struct {
+		Next *nested `json:"next"`
+	}
+	type embedded struct {
+		ID string `json:"id"`
+	}
+	type example struct {
+		embedded
+		Count   int               `json:"count,omitempty"`
+		Data    json.RawMessage   `json:"data"`
+		Ignored string            `json:"-"`
+		Labels  map[string]string `json:"labels"`
+		Nested  nested            `json:"nested"`
+		Raw     []byte            `json:"raw"`
+		private string
+	}
+
+	schema := schemaOf(example{})
+
+	assert.Equal(t, "object", schema.Type)
+	assert.ElementsMatch(t, []string{"count", "data", "id", "labels", "nested", "raw"}, keys(schema.Properties))
+	assert.Equal(t, &jsonSchema{Type: "integer"}, schema.Properties["count"])
+	assert.Equal(t, &jsonSchema{}, schema.Properties["data"], "Raw JSON could be any value")
+	assert.Equal(t, &jsonSchema{Type: "string", Format: "byte"}, schema.Properties["raw"])
+	assert.Equal(t, &jsonSchema{Type: "string"}, schema.Properties["labels"].AdditionalProperties)
+	assert.Equal(t, &jsonSchema{Type: "object"}, schema.Properties["nested"].Properties["next"], "Recursive types should be left open")
+}
+
+func keys[T any](m map[string]T) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
@@ -0,0 +1,174 @@
+package hops
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRateLimit is the default number of requests per second allowed per client
+	DefaultRateLimit = 5
+	// DefaultRateLimitBurst is the default number of requests a client may make at once
+	DefaultRateLimitBurst = 20
+	// DefaultGlobalRateLimit is the default number of tasks per second that may be
+	// run by all clients together
+	DefaultGlobalRateLimit = 50
+	// DefaultGlobalRateLimitBurst is the default number of tasks that may be run at once
+	DefaultGlobalRateLimitBurst = 100
+
+	// globalRateLimitKey is the key every request shares when limited globally
+	globalRateLimitKey = "global"
+
+	// How often idle clients are removed from a MemoryRateLimitStore
+	rateLimitSweepInterval = time.Minute
+)
+
+type (
+	// MemoryRateLimitStore is an in-memory token bucket RateLimitStore
+	//
+	// Limits are only shared by requests to the same process. Use a RateLimitStore
+	// backed by shared storage to rate limit across instances.
+	MemoryRateLimitStore struct {
+		buckets   map[string]*tokenBucket
+		burst     float64
+		lastSweep time.Time
+		mu        sync.Mutex
+		now       func() time.Time
+		rate      float64
+	}
+
+	// RateLimitConf sets how often the tasks API may be called (see RateLimit)
+	RateLimitConf struct {
+		// Rate is the requests per second allowed per client to the tasks API (0 disables)
+		Rate  float64
+		Burst int
+		// GlobalRate is the tasks per second that may be run by all clients together,
+		// as each run publishes an event to the account's stream (0 disables)
+		GlobalRate  float64
+		GlobalBurst int
+	}
+
+	// RateLimitKeyFunc returns the key a request is rate limited by
+	RateLimitKeyFunc func(*http.Request) string
+
+	// RateLimitStore tracks how many requests each client may make
+	RateLimitStore interface {
+		// Take uses up one request for key, returning whether the request is allowed
+		// and if not, how long until it would be
+		Take(key string) (bool, time.Duration)
+	}
+
+	tokenBucket struct {
+		tokens    float64
+		updatedAt time.Time
+	}
+)
+
+// NewMemoryRateLimitStore creates a MemoryRateLimitStore allowing rate requests per
+// second for each key, with bursts of up to burst requests
+func NewMemoryRateLimitStore(rate float64, burst int) *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets:   map[string]*tokenBucket{},
+		burst:     float64(burst),
+		lastSweep: time.Now(),
+		now:       time.Now,
+		rate:      rate,
+	}
+}
+
+func (m *MemoryRateLimitStore) Take(key string) (bool, time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+
+	bucket, ok := m.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: m.burst, updatedAt: now}
+		m.buckets[key] = bucket
+	}
+
+	m.refill(bucket, now)
+
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / m.rate * float64(time.Second))
+		return false, wait
+	}
+
+	bucket.tokens--
+	return true, 0
+}
+
+// refill adds the tokens accrued by a bucket since it was last updated
+func (m *MemoryRateLimitStore) refill(bucket *tokenBucket, now time.Time) {
+	elapsed := now.Sub(bucket.updatedAt).Seconds()
+	bucket.tokens = math.Min(m.burst, bucket.tokens+elapsed*m.rate)
+	bucket.updatedAt = now
+}
+
+// sweep periodically removes the buckets of clients that have stopped making requests,
+// so memory use doesn't grow with every client ever seen
+func (m *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+
+	for key, bucket := range m.buckets {
+		m.refill(bucket, now)
+		if bucket.tokens >= m.burst {
+			delete(m.buckets, key)
+		}
+	}
+
+	m.lastSweep = now
+}
+
+// ClientIPKey rate limits requests by the IP address of the client
+func ClientIPKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// GlobalKey rate limits every request together, capping the total request rate
+func GlobalKey(r *http.Request) string {
+	return globalRateLimitKey
+}
+
+// RateLimit rejects requests with 429 Too Many Requests once a client exceeds its
+// allowance in store, setting Retry-After to the number of seconds until it may retry
+//
+// Clients are identified by keyFunc, which defaults to ClientIPKey if nil.
+func RateLimit(store RateLimitStore, keyFunc RateLimitKeyFunc) func(http.Handler) http.Handler {
+	if keyFunc == nil {
+		keyFunc = ClientIPKey
+	}
+
+	f := func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			allowed, retryAfter := store.Take(keyFunc(r))
+			if !allowed {
+				retrySeconds := int(math.Ceil(retryAfter.Seconds()))
+				if retrySeconds < 1 {
+					retrySeconds = 1
+				}
+
+				w.Header().Set("Retry-After", strconv.Itoa(retrySeconds))
+				writeError(w, r, http.StatusTooManyRequests, ErrorCodeRateLimited, "Too many requests, retry later")
+				return
+			}
+
+			h.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+	return f
+}
@@ -0,0 +1,112 @@
+package hops
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryRateLimitStore(1, 2)
+	store.now = func() time.Time { return now }
+
+	handler := RateLimit(store, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/tasks/mytask", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	type step struct {
+		advance    time.Duration
+		remoteAddr string
+		status     int
+		retryAfter string
+	}
+
+	steps := []step{
+		{remoteAddr: "10.0.0.1:1234", status: http.StatusOK},
+		{remoteAddr: "10.0.0.1:1235", status: http.StatusOK},
+		{remoteAddr: "10.0.0.1:1236", status: http.StatusTooManyRequests, retryAfter: "1"},
+		{remoteAddr: "10.0.0.2:1234", status: http.StatusOK},
+		{advance: time.Second, remoteAddr: "10.0.0.1:1234", status: http.StatusOK},
+		{remoteAddr: "10.0.0.1:1234", status: http.StatusTooManyRequests, retryAfter: "1"},
+	}
+
+	for i, s := range steps {
+		now = now.Add(s.advance)
+
+		rec := request(s.remoteAddr)
+		assert.Equal(t, s.status, rec.Code, "Step %d", i)
+		assert.Equal(t, s.retryAfter, rec.Header().Get("Retry-After"), "Step %d", i)
+	}
+}
+
+func TestRateLimitConcurrent(t *testing.T) {
+	now := time.Now()
+	clientStore := NewMemoryRateLimitStore(1, 5)
+	clientStore.now = func() time.Time { return now }
+	globalStore := NewMemoryRateLimitStore(1, 8)
+	globalStore.now = func() time.Time { return now }
+
+	var handled atomic.Int32
+	handler := RateLimit(clientStore, nil)(RateLimit(globalStore, GlobalKey)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handled.Add(1)
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	// Two clients each burst 20 requests at once
+	var wg sync.WaitGroup
+	codes := make(chan int, 40)
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			req := httptest.NewRequest(http.MethodPost, "/tasks/mytask", nil)
+			req.RemoteAddr = fmt.Sprintf("10.0.0.%d:%d", i%2, 1000+i)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			codes <- rec.Code
+		}(i)
+	}
+	wg.Wait()
+	close(codes)
+
+	counts := map[int]int{}
+	for code := range codes {
+		counts[code]++
+	}
+
+	assert.Equal(t, 8, counts[http.StatusOK], "Clients should be allowed 5 each, up to the global burst of 8")
+	assert.Equal(t, 32, counts[http.StatusTooManyRequests])
+	assert.Equal(t, int32(8), handled.Load())
+}
+
+func TestMemoryRateLimitStoreSweep(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryRateLimitStore(1, 1)
+	store.now = func() time.Time { return now }
+
+	allowed, _ := store.Take("idle")
+	assert.True(t, allowed)
+
+	now = now.Add(rateLimitSweepInterval + time.Second)
+	allowed, _ = store.Take("active")
+	assert.True(t, allowed)
+
+	assert.NotContains(t, store.buckets, "idle", "Idle client should have been swept")
+	assert.Contains(t, store.buckets, "active")
+}
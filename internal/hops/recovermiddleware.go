@@ -0,0 +1,37 @@
+package hops
+
+import (
+	"net/http"
+	"runtime/debug"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// Recoverer recovers from panics in later handlers, responding 500 with an
+// ErrorResponse rather than a stack dump
+//
+// The panic and its stack are logged with the request's logger, so it should
+// come after logs.AccessLogMiddleware to include the request ID. As with
+// net/http, http.ErrAbortHandler is re-panicked to abort the response.
+func Recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rvr := recover()
+			if rvr == nil {
+				return
+			}
+			if rvr == http.ErrAbortHandler {
+				panic(rvr)
+			}
+
+			hlog.FromRequest(r).Error().
+				Interface("panic", rvr).
+				Str("stack", string(debug.Stack())).
+				Msg("Recovered from panic handling request")
+
+			writeError(w, r, http.StatusInternalServerError, ErrorCodeInternal, "Internal server error")
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,48 @@
+package hops
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hiphops-io/hops/logs"
+)
+
+func TestRecoverer(t *testing.T) {
+	panicking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("something went wrong")
+	})
+	handler := logs.AccessLogMiddleware(logs.NoOpLogger())(Recoverer(panicking))
+
+	t.Run("Responds with an error", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/tasks", nil)
+		req.Header.Set(logs.RequestIDHeader, "req-123")
+		handler.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+		assert.NotContains(t, rec.Body.String(), "something went wrong", "Panics should not be leaked")
+
+		response := ErrorResponse{}
+		err := json.Unmarshal(rec.Body.Bytes(), &response)
+		require.NoError(t, err, "Response should be valid JSON")
+		assert.Equal(t, ErrorCodeInternal, response.Error.Code)
+		assert.Equal(t, "req-123", response.Error.RequestID)
+		assert.Equal(t, []ErrorDetail{}, response.Error.Details)
+	})
+
+	t.Run("Aborts handlers", func(t *testing.T) {
+		aborting := Recoverer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		}))
+
+		assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+			aborting.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		})
+	})
+}
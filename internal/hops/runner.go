@@ -10,35 +10,95 @@ import (
 
 	"github.com/goccy/go-json"
 	"github.com/hashicorp/go-multierror"
+	natsgo "github.com/nats-io/nats.go"
 	"github.com/patrickmn/go-cache"
 	"github.com/robfig/cron"
 	"github.com/rs/zerolog"
+	"github.com/zclconf/go-cty/cty"
 
 	"github.com/hiphops-io/hops/dsl"
+	"github.com/hiphops-io/hops/logs"
 	"github.com/hiphops-io/hops/nats"
+	"github.com/hiphops-io/hops/worker"
 )
 
 const (
+	// DefaultDispatchTimeout is how long a call may take to be dispatched by default
+	DefaultDispatchTimeout = 5 * time.Second
+	// DefaultInputLogLimit is the size in bytes that call inputs are truncated to
+	// when logged by WithInputLogging, unless another limit is given
+	DefaultInputLogLimit = 4 * 1024
+
+	// Max attempts made to publish each call, and the delay before the first retry
+	// (doubling for each retry after). All attempts share the call's dispatch timeout.
+	dispatchAttempts = 3
+	dispatchBackoff  = 100 * time.Millisecond
+
 	hopsKeyPrefix = "hopsconf-"
+
+	// Max number of on blocks evaluated at once for a single message
+	maxConcurrentSensors = 10
+
+	// How long dispatch waits on the OnDispatch hook before moving on
+	onDispatchTimeout = time.Second
 )
 
-type Runner struct {
-	cache          *cache.Cache
-	cron           *cron.Cron
-	hopsFileLoader *HopsFileLoader
-	hopsFiles      *dsl.HopsFiles
-	hopsLock       sync.RWMutex
-	logger         zerolog.Logger
-	natsClient     *nats.Client
-	schedules      []*Schedule
-}
+type (
+	Runner struct {
+		cache           *cache.Cache
+		cancelRun       context.CancelFunc
+		cron            *cron.Cron
+		dispatchTimeout time.Duration
+		dispatcher      Dispatcher
+		dryRun          bool
+		evalSlots       chan struct{}
+		failFast        bool
+		globals         map[string]cty.Value
+		hopsFileLoader  *HopsFileLoader
+		hopsFiles       *dsl.HopsFiles
+		hopsLock        sync.RWMutex
+		inputLogLimit   int
+		logger          zerolog.Logger
+		metrics         Metrics
+		natsClient      *nats.Client
+		onDispatch      OnDispatchFunc
+		redactor        *logs.Redactor
+		runErr          error
+		runMu           sync.Mutex
+		schedules       []*Schedule
+		secrets         dsl.SecretProvider
+		sequenceState   SequenceStateStore
+		sequenceTimeout time.Duration
+		shadowSubject   string
+		stopRun         context.CancelFunc
+		stopped         chan struct{}
+	}
+
+	// OnDispatchFunc observes a call the runner has dispatched, along with the
+	// subject of its request and its inputs. See WithOnDispatch.
+	OnDispatchFunc func(ctx context.Context, call dsl.CallAST, subject string, inputs []byte)
 
-func NewRunner(natsClient *nats.Client, hopsFileLoader *HopsFileLoader, logger zerolog.Logger) (*Runner, error) {
+	RunnerOpt func(*Runner)
+)
+
+func NewRunner(natsClient *nats.Client, hopsFileLoader *HopsFileLoader, logger zerolog.Logger, opts ...RunnerOpt) (*Runner, error) {
 	r := &Runner{
-		logger:         logger,
-		natsClient:     natsClient,
-		hopsFileLoader: hopsFileLoader,
-		cache:          cache.New(5*time.Minute, 10*time.Minute),
+		logger:          logger,
+		natsClient:      natsClient,
+		hopsFileLoader:  hopsFileLoader,
+		cache:           cache.New(5*time.Minute, 10*time.Minute),
+		dispatchTimeout: DefaultDispatchTimeout,
+		dispatcher:      NewPublishDispatcher(natsClient),
+		redactor:        logs.NewRedactor(),
+		secrets:         dsl.NewEnvSecretProvider(dsl.DefaultSecretEnvPrefix),
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	if r.dryRun {
+		r.dispatcher = r.dryRunDispatcher()
 	}
 
 	err := r.Reload(context.Background())
@@ -69,12 +129,28 @@ func (r *Runner) Reload(ctx context.Context) error {
 		return fmt.Errorf("Unable to create schedules %w", err)
 	}
 
-	r.setCron()
+	// Scheduled events would be acted on by live runners, so dry runs don't publish them
+	if !r.dryRun {
+		r.setCron()
+	}
 
 	return nil
 }
 
+// Run consumes and evaluates sequences until ctx is cancelled or Stop is called
 func (r *Runner) Run(ctx context.Context, fromConsumer string) error {
+	stopped := make(chan struct{})
+	defer close(stopped)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ctx, stop := nats.ContextWithStop(ctx)
+
+	r.runMu.Lock()
+	r.cancelRun = cancel
+	r.stopRun = stop
+	r.stopped = stopped
+	r.runMu.Unlock()
 
 	defer func() {
 		if r.cron != nil {
@@ -82,48 +158,334 @@ func (r *Runner) Run(ctx context.Context, fromConsumer string) error {
 		}
 	}()
 
-	return r.natsClient.ConsumeSequences(ctx, fromConsumer, r)
+	err := r.natsClient.ConsumeSequences(ctx, fromConsumer, r)
+
+	r.runMu.Lock()
+	r.runErr = err
+	r.runMu.Unlock()
+
+	return err
+}
+
+// SetRedactKeys sets the keys whose values are scrubbed from message content before
+// it is logged (defaults to logs.DefaultRedactKeys)
+//
+// Should be called before Run.
+func (r *Runner) SetRedactKeys(keys ...string) {
+	r.redactor = logs.NewRedactor(keys...)
+
+	if r.dryRun {
+		r.dispatcher = r.dryRunDispatcher()
+	}
+}
+
+// SetSecretProvider sets where secrets referenced by hops configs are looked up from
+//
+// Defaults to reading env vars prefixed with dsl.DefaultSecretEnvPrefix.
+// Should be called before Run.
+func (r *Runner) SetSecretProvider(secrets dsl.SecretProvider) {
+	r.secrets = secrets
 }
 
+// SequenceCallback evaluates the hops config against a sequence's message bundle,
+// dispatching any calls that are ready
+//
+// On blocks failing due to the hops config (ErrHopsConfig) are reported in the
+// sequence and metrics without failing the others, so nil is returned and the
+// message acked. Failures of the system evaluating the sequence are returned as
+// ErrSystem, so the message is redelivered and every on block re-evaluated,
+// with calls already dispatched skipped. Errors parsing the hops config are
+// reported the same way if caused by the config (see isHopsConfigError), and
+// returned as is otherwise.
 func (r *Runner) SequenceCallback(
 	ctx context.Context,
 	sequenceId string,
 	msgBundle nats.MessageBundle,
-) error {
+) (err error) {
 	logger := r.logger.With().Str("sequence_id", sequenceId).Logger()
 
+	// Sequences beyond the limit wait for a slot rather than being nak'd, so
+	// they're evaluated as soon as possible without being redelivered
+	if r.evalSlots != nil {
+		select {
+		case r.evalSlots <- struct{}{}:
+			defer func() { <-r.evalSlots }()
+		case <-ctx.Done():
+			return ErrSystem{Err: fmt.Errorf("Sequence not evaluated: %w", ctx.Err())}
+		}
+	}
+
+	if r.metrics != nil {
+		startedAt := time.Now()
+		defer func() {
+			outcome := worker.OutcomeSuccess
+			if err != nil {
+				outcome = worker.OutcomeFailure
+			}
+			r.metrics.ObserveSequence(time.Since(startedAt), outcome)
+		}()
+
+		if fetchDuration, ok := nats.BundleFetchDurationFromContext(ctx); ok {
+			r.metrics.ObserveBundleFetch(fetchDuration)
+		}
+	}
+
+	// Events that no on block could match are skipped without parsing, so noisy
+	// events cost next to nothing. Nothing is dispatched or published for them,
+	// including the sequence's hops config assignment if it has none yet.
+	sourceMeta, _ := nats.ParseSourceMeta(msgBundle[nats.SourceEventId])
+	if sourceMeta.RequestId != "" {
+		logger = logger.With().Str("request_id", sourceMeta.RequestId).Logger()
+	}
+	if !r.couldMatch(msgBundle, sourceMeta) {
+		logger.Debug().Str("event", sourceMeta.Event).Str("action", sourceMeta.Action).Msg("No on blocks match event, skipping evaluation")
+		return nil
+	}
+
+	// Completed sequences can't dispatch anything more, so needn't be evaluated
+	state := r.loadSequenceState(ctx, sequenceId, logger)
+	if state.isComplete() {
+		logger.Debug().Msg("Sequence is already complete, skipping evaluation")
+		return nil
+	}
+	defer r.saveSequenceState(ctx, state, logger)
+
 	hops, err := r.sequenceHops(ctx, sequenceId, msgBundle)
 	if err != nil {
-		return fmt.Errorf("Unable to fetch assigned hops file for sequence: %w", err)
+		return ErrSystem{Err: fmt.Errorf("Unable to fetch assigned hops file for sequence: %w", err)}
+	}
+
+	// The assigned config may differ from the runner's current one
+	if !hops.EventIndex.Matches(sourceMeta.Event, sourceMeta.Action) {
+		logger.Debug().Str("event", sourceMeta.Event).Str("action", sourceMeta.Action).Msg("No on blocks match event, skipping evaluation")
+		return nil
+	}
+
+	parseStartedAt := time.Now()
+	if r.globals != nil {
+		ctx = dsl.ContextWithGlobals(ctx, r.globals)
+	}
+	hop, err := dsl.ParseHops(ctx, hops, msgBundle.WithoutRequests(), r.secrets, logger)
+	if r.metrics != nil {
+		r.metrics.ObserveParse(time.Since(parseStartedAt))
+	}
+	if err != nil {
+		r.logBundle(hop, msgBundle, logger)
+		err = fmt.Errorf("Error parsing hops config: %w", err)
+		r.publishSequenceError(ctx, err, hops.Hash, sequenceId, msgBundle, logger)
+
+		// As with on blocks, evaluating the config would fail the same way if retried
+		if isHopsConfigError(err) {
+			logger.Error().Err(err).Msg("Hops config failed to evaluate for sequence, it will not be retried")
+			return nil
+		}
+
+		return err
+	}
+
+	logger.Debug().Msg("Successfully parsed hops file")
+
+	// Replays may be evaluated only, recording the calls that would be dispatched
+	// without publishing any requests. The mode is carried in the replayed event.
+	evaluateOnly := sourceMeta.EvaluateOnly()
+	if strings.HasPrefix(sequenceId, nats.ReplaySequencePrefix) || sourceMeta.Replay != nil {
+		logger = logger.With().Bool("replay", true).Bool("evaluate_only", evaluateOnly).Logger()
 	}
 
-	hop, err := dsl.ParseHops(ctx, hops, msgBundle, logger)
+	seqMeta := sequenceCallMeta(sequenceId, hops.Hash, sourceMeta)
+	expiry := r.newSequenceExpiry(ctx, sequenceId, logger)
+
+	err = runSensors(ctx, hop.Ons, maxConcurrentSensors, r.failFast, func(ctx context.Context, sensor *dsl.OnAST) error {
+		// Sensors run concurrently, so every line is tagged with the sensor it's from
+		sensorLogger := logger.With().Str("on", sensor.Slug).Logger()
+
+		done, err := r.checkIfDone(ctx, sensor, sequenceId, msgBundle, sensorLogger)
+		if !done && expiry.expire(sensor, msgBundle) {
+			sensorLogger.Warn().Msg("On block has timed out, no further calls will be dispatched")
+			return nil
+		}
+		if !done {
+			callMeta := seqMeta
+			callMeta.On = sensor.Slug
+
+			err = r.dispatchCalls(ctx, sensor, sequenceId, msgBundle, callMeta, evaluateOnly, state, hop.Secrets(), sensorLogger)
+		}
+		if err != nil {
+			return &evaluationError{on: sensor.Slug, err: err}
+		}
+
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("Error parsing hops config: %w", err)
+		r.publishSequenceError(ctx, err, hops.Hash, sequenceId, msgBundle, logger)
 	}
 
-	r.logger.Debug().Msg("Successfully parsed hops file")
+	// On blocks failing due to the hops config would fail the same way if
+	// retried, so are only reported. Any other failure has the message redelivered.
+	configErrs, err := splitSensorErrors(err)
+	for _, configErr := range configErrs {
+		on, call := errorSource(configErr)
+		logger.Error().Err(configErr).Str("on", on).Str("call", call).Msg("On block failed due to its hops config, it will not be retried")
+	}
+	r.observeSensorFailures(len(configErrs))
+	if err != nil {
+		return err
+	}
 
-	// TODO: Run all sensors concurrently via goroutines
-	var mergedErrors error
-	for i := range hop.Ons {
-		sensor := &hop.Ons[i]
+	complete, err := r.checkIfComplete(ctx, hop, sequenceId, msgBundle, state, expiry, logger)
+	if err != nil {
+		return ErrSystem{Err: err}
+	}
 
-		done, err := r.checkIfDone(ctx, sensor, sequenceId, msgBundle, logger)
+	if !complete {
+		err = r.scheduleDeadline(ctx, sequenceId, expiry, logger)
 		if err != nil {
-			mergedErrors = multierror.Append(mergedErrors, err)
+			return ErrSystem{Err: err}
+		}
+	}
+
+	return nil
+}
+
+// scheduleDeadline has the sequence evaluated again once the earliest timeout of
+// its pending on blocks passes, so it times out even if nothing else arrives
+//
+// Dry runs don't publish anything for live runners to act on, so never schedule one.
+func (r *Runner) scheduleDeadline(ctx context.Context, sequenceId string, expiry *sequenceExpiry, logger zerolog.Logger) error {
+	deadline := expiry.nextDeadline()
+	if r.dryRun || deadline.IsZero() {
+		return nil
+	}
+
+	sent, err := r.natsClient.PublishDeadline(ctx, deadline, sequenceId)
+	if err != nil {
+		return fmt.Errorf("Unable to schedule sequence deadline: %w", err)
+	}
+
+	if sent {
+		logger.Debug().Time("deadline", deadline).Msg("Sequence will be evaluated again at its next timeout")
+	}
+
+	return nil
+}
+
+// Stop stops the runner consuming, then waits for the sequences it's evaluating
+// to finish, returning any error Run returned
+//
+// If ctx is done first, the sequences still being evaluated are cancelled (and
+// so redelivered later) and the context's error is returned. The NATS client
+// is left open as it may be shared, but can be closed once Stop returns.
+// Does nothing if the runner has not been run.
+func (r *Runner) Stop(ctx context.Context) error {
+	r.runMu.Lock()
+	cancel, stop, stopped := r.cancelRun, r.stopRun, r.stopped
+	r.runMu.Unlock()
+
+	if stopped == nil {
+		return nil
+	}
+
+	stop()
+
+	select {
+	case <-stopped:
+		r.runMu.Lock()
+		defer r.runMu.Unlock()
+
+		return r.runErr
+	case <-ctx.Done():
+		cancel()
+		return fmt.Errorf("Timed out waiting for sequences to finish: %w", ctx.Err())
+	}
+}
+
+// checkIfComplete publishes the sequence's completion event once every matched
+// on block is done or timed out, with a failure status if any call or done block
+// errored, or a timeout status if any on block timed out. Returns whether the
+// sequence is complete.
+func (r *Runner) checkIfComplete(ctx context.Context, hop *dsl.HopAST, sequenceId string, msgBundle nats.MessageBundle, state *trackedSequenceState, expiry *sequenceExpiry, logger zerolog.Logger) (bool, error) {
+	failed := []string{}
+
+	for _, sensor := range hop.Ons {
+		if sensor.Done != nil {
+			if sensor.Done.Error != nil {
+				failed = append(failed, sensor.Slug)
+			}
+			continue
+		}
+
+		// Timed out on blocks won't wait for their pending calls
+		if expiry.isExpired(sensor.Slug) {
+			continue
 		}
-		if done {
+
+		for _, call := range sensor.Calls {
+			if _, ok := msgBundle[call.Slug]; !ok {
+				return false, nil
+			}
+		}
+	}
+
+	// Calls dispatched before a done block matched are counted too, though they
+	// may not have results yet
+	dispatched := msgBundle.DispatchedCalls()
+	for _, callSlug := range dispatched {
+		resultB, ok := msgBundle[callSlug]
+		if !ok {
 			continue
 		}
 
-		err = r.dispatchCalls(ctx, sensor, sequenceId, logger)
+		result, err := nats.ParseResultMsg(resultB)
+		if err != nil || result.Errored {
+			failed = append(failed, callSlug)
+		}
+	}
+
+	expiredOns, expiredCalls := expiry.summary()
+
+	if r.dryRun {
+		logger.Info().Bool("dry_run", true).Strs("failed", failed).Strs("expired", expiredCalls).Msg("Sequence would be complete")
+		return true, nil
+	}
+
+	startedAt := time.Now()
+	sourceMsg, err := r.natsClient.GetMsg(ctx, nats.ChannelNotify, sequenceId, nats.SourceEventId)
+	if err != nil {
+		logger.Warn().Err(err).Msg("Unable to find source event of sequence, timing will be inaccurate")
+	} else {
+		startedAt = sourceMsg.Time
+	}
+
+	if len(expiredOns) > 0 {
+		timeout := nats.NewTimeoutMsg(startedAt, expiredOns, expiredCalls)
+		sent, err := r.natsClient.PublishTimeout(ctx, timeout, sequenceId)
 		if err != nil {
-			mergedErrors = multierror.Append(mergedErrors, err)
+			return false, fmt.Errorf("Unable to publish sequence timeout: %w", err)
+		}
+
+		if sent {
+			logger.Warn().Strs("ons", expiredOns).Strs("expired", expiredCalls).Msg("Sequence timed out")
 		}
 	}
 
-	return mergedErrors
+	completion := nats.NewCompletionMsg(startedAt, len(dispatched), failed)
+	if len(expiredOns) > 0 {
+		completion.Expired = expiredCalls
+		completion.Status = nats.StatusTimeout
+	}
+	sent, err := r.natsClient.PublishCompletion(ctx, completion, sequenceId)
+	if err != nil {
+		return false, fmt.Errorf("Unable to publish sequence completion: %w", err)
+	}
+
+	// Completion is recorded even if another runner sent it first
+	state.markComplete()
+
+	if sent {
+		logger.Info().Str("status", completion.Status).Msg("Sequence is complete")
+	}
+
+	return true, nil
 }
 
 func (r *Runner) checkIfDone(ctx context.Context, sensor *dsl.OnAST, sequenceId string, msgBundle nats.MessageBundle, logger zerolog.Logger) (bool, error) {
@@ -143,6 +505,11 @@ func (r *Runner) checkIfDone(ctx context.Context, sensor *dsl.OnAST, sequenceId
 	}
 
 	if done {
+		// Anything still waiting references results that can no longer arrive
+		for _, call := range sensor.Waiting {
+			logger.Debug().Msgf("Call will not be dispatched, as its 'if' was never ready for evaluation: %s", call.Slug)
+		}
+
 		done := &dsl.DoneAST{
 			Result: []byte("{}"),
 		}
@@ -153,8 +520,27 @@ func (r *Runner) checkIfDone(ctx context.Context, sensor *dsl.OnAST, sequenceId
 	return false, nil
 }
 
+// couldMatch returns whether any on block could match a sequence's source event
+//
+// Sequences already assigned a hops config are assumed to, as they're checked
+// once it's resolved. Otherwise the runner's current config is checked, as that's
+// the config the sequence would be assigned.
+func (r *Runner) couldMatch(msgBundle nats.MessageBundle, sourceMeta nats.SourceMeta) bool {
+	if _, ok := msgBundle[nats.HopsMessageId]; ok {
+		return true
+	}
+
+	r.hopsLock.RLock()
+	defer r.hopsLock.RUnlock()
+
+	return r.hopsFiles.EventIndex.Matches(sourceMeta.Event, sourceMeta.Action)
+}
+
 func (r *Runner) dispatchDone(ctx context.Context, onSlug string, done *dsl.DoneAST, sequenceId string, logger zerolog.Logger) error {
-	logger = logger.With().Str("on", onSlug).Logger()
+	if r.dryRun {
+		logger.Info().Bool("dry_run", true).Msg("Pipeline would be done")
+		return nil
+	}
 
 	err, sent := r.natsClient.PublishResult(
 		ctx,
@@ -178,54 +564,238 @@ func (r *Runner) dispatchDone(ctx context.Context, onSlug string, done *dsl.Done
 	return nil
 }
 
-func (r *Runner) dispatchCalls(ctx context.Context, sensor *dsl.OnAST, sequenceId string, logger zerolog.Logger) error {
+// dispatchCalls dispatches the calls of an on block that haven't been dispatched already
+//
+// secrets are redacted from logged content, along with sensitive keys.
+func (r *Runner) dispatchCalls(ctx context.Context, sensor *dsl.OnAST, sequenceId string, msgBundle nats.MessageBundle, callMeta nats.CallMeta, evaluateOnly bool, state *trackedSequenceState, secrets []string, logger zerolog.Logger) error {
 	var wg sync.WaitGroup
 	var errs error
 
-	logger = logger.With().Str("on", sensor.Slug).Logger()
-	logger.Info().Msg("Running on calls")
+	// Every message in a sequence re-evaluates its on blocks, so calls dispatched
+	// by earlier messages are skipped rather than published again. The sequence
+	// state catches dispatches the bundle doesn't include yet.
+	calls := []dsl.CallAST{}
+	for _, call := range sensor.Calls {
+		if msgBundle.HasRequest(call.Slug) || state.isDispatched(call.Slug) {
+			logger.Debug().Msgf("Call already dispatched: %s", call.Slug)
+			continue
+		}
+
+		calls = append(calls, call)
+	}
 
-	numTasks := len(sensor.Calls)
-	errorchan := make(chan error, numTasks)
+	alreadyDispatched := len(sensor.Calls) - len(calls)
 
-	for _, call := range sensor.Calls {
+	if len(calls) == 0 {
+		r.observeCalls(0, alreadyDispatched+len(sensor.Skipped), 0)
+		return nil
+	}
+
+	// Waiting calls aren't tracked between callbacks. The message carrying the result
+	// they're waiting on triggers its own callback, which re-evaluates them with the
+	// result included. That holds even if the result arrives whilst this callback
+	// is still running, as messages are only marked superseded by newer messages.
+	logger.Info().
+		Int("dispatching", len(calls)).
+		Int("already_dispatched", alreadyDispatched).
+		Int("skipped", len(sensor.Skipped)).
+		Int("waiting", len(sensor.Waiting)).
+		Msg("Running on calls")
+
+	errorchan := make(chan error, len(calls))
+
+	for _, call := range calls {
 		call := call
 		wg.Add(1)
-		go r.dispatchCall(ctx, &wg, call, sequenceId, errorchan, logger)
+		go r.dispatchCall(ctx, &wg, call, sequenceId, callMeta, evaluateOnly, state, secrets, errorchan, logger)
 	}
 
 	wg.Wait()
 	close(errorchan)
 
+	errored := 0
 	for err := range errorchan {
+		if err != nil {
+			errored++
+		}
 		errs = errors.Join(errs, err)
 	}
 
+	r.observeCalls(len(calls)-errored, alreadyDispatched+len(sensor.Skipped), errored)
+
 	return errs
 }
 
-func (r *Runner) dispatchCall(ctx context.Context, wg *sync.WaitGroup, call dsl.CallAST, sequenceId string, errorchan chan<- error, logger zerolog.Logger) {
+func (r *Runner) dispatchCall(ctx context.Context, wg *sync.WaitGroup, call dsl.CallAST, sequenceId string, callMeta nats.CallMeta, evaluateOnly bool, state *trackedSequenceState, secrets []string, errorchan chan<- error, logger zerolog.Logger) {
 	defer wg.Done()
 
-	app, handler, found := strings.Cut(call.TaskType, "_")
-	if !found {
-		errorchan <- fmt.Errorf("Unable to parse app/handler from call %s", call.Name)
+	app, handler, err := callTarget(call)
+	if err != nil {
+		errorchan <- &evaluationError{call: call.Slug, err: ErrHopsConfig{Err: err}}
+		return
+	}
+
+	if evaluateOnly {
+		subjTokens := []string{nats.ChannelRequest, sequenceId, call.Slug, app, handler}
+		wouldDispatch := nats.NewWouldDispatchMsg(app, handler, call.Inputs)
+		sent, err := r.natsClient.PublishWouldDispatch(ctx, wouldDispatch, callMeta, sequenceId, call.Slug)
+		if err != nil {
+			errorchan <- &evaluationError{call: call.Slug, err: fmt.Errorf("Unable to record call %s: %w", call.Slug, err)}
+			return
+		}
+
+		if sent {
+			logger.Info().
+				Strs("subject_tokens", subjTokens).
+				RawJSON("inputs", r.redactor.RedactJSON(call.Inputs, secrets...)).
+				Msgf("Would dispatch call: %s", call.Slug)
+		}
+		errorchan <- nil
+		return
+	}
+
+	// Stop early if the sequence is no longer being processed, e.g. on shutdown
+	if ctx.Err() != nil {
+		errorchan <- &evaluationError{call: call.Slug, err: fmt.Errorf("Call %s not dispatched: %w", call.Slug, ctx.Err())}
 		return
 	}
 
-	_, _, err := r.natsClient.Publish(ctx, call.Inputs, nats.ChannelRequest, sequenceId, call.Slug, app, handler)
+	// Each call is bounded, so one slow dispatch can't hold up acking the message
+	// being processed. Any error naks that message, so timed out calls are retried.
+	dispatchCtx, cancel := context.WithTimeout(ctx, r.dispatchTimeout)
+	defer cancel()
+	dispatchCtx = ContextWithCallMeta(logger.WithContext(dispatchCtx), callMeta)
+
+	err = r.dispatcher.Dispatch(dispatchCtx, sequenceId, call)
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		logger.Warn().Dur("timeout", r.dispatchTimeout).Msgf("Timed out dispatching call: %s", call.Slug)
+		errorchan <- &evaluationError{call: call.Slug, err: fmt.Errorf("Timed out dispatching call %s after %s: %w", call.Slug, r.dispatchTimeout, err)}
+		return
+	}
 	if err != nil {
-		errorchan <- err
+		errorchan <- &evaluationError{call: call.Slug, err: fmt.Errorf("Unable to dispatch call %s: %w", call.Slug, err)}
 		return
 	}
 
-	if err == nil {
+	if !r.dryRun {
+		state.markDispatched(call.Slug)
 		logger.Info().Msgf("Dispatched call: %s", call.Slug)
+		r.logInputs(call, secrets, logger)
+		r.notifyDispatch(ctx, call, sequenceId, callMeta, logger)
 	}
-
 	errorchan <- nil
 }
 
+// dryRunDispatcher returns the dispatcher used by dry runs, which logs the calls
+// they would dispatch (and records them to the shadow subject, if set) without
+// publishing any requests
+func (r *Runner) dryRunDispatcher() Dispatcher {
+	if r.shadowSubject != "" {
+		return NewShadowDispatcher(r.natsClient, r.shadowSubject, r.redactor)
+	}
+
+	return NewLoggingDispatcher(r.redactor)
+}
+
+// logInputs logs the inputs of a dispatched call at debug level, if enabled with
+// WithInputLogging. Sensitive keys and secrets are redacted, and inputs larger
+// than the limit are truncated.
+func (r *Runner) logInputs(call dsl.CallAST, secrets []string, logger zerolog.Logger) {
+	if r.inputLogLimit <= 0 {
+		return
+	}
+
+	event := logger.Debug()
+	if !event.Enabled() {
+		return
+	}
+
+	inputs := string(r.redactor.RedactJSON(call.Inputs, secrets...))
+
+	event = event.Str("call", call.Slug).Int("inputs_size", len(call.Inputs))
+	if len(inputs) > r.inputLogLimit {
+		// Truncated inputs are no longer valid JSON, so are logged as a string
+		event = event.Str("inputs", strings.ToValidUTF8(inputs[:r.inputLogLimit], "")).Bool("inputs_truncated", true)
+	} else {
+		event = event.RawJSON("inputs", []byte(inputs))
+	}
+
+	event.Msgf("Dispatched call inputs: %s", call.Slug)
+}
+
+// logBundle dumps the content of a message bundle at debug level, with sensitive
+// keys and any secrets resolved whilst parsing redacted
+func (r *Runner) logBundle(hop *dsl.HopAST, msgBundle nats.MessageBundle, logger zerolog.Logger) {
+	logger.Debug().Msg("Parse failed on pipeline, dumping state:")
+
+	var secrets []string
+	if hop != nil {
+		secrets = hop.Secrets()
+	}
+
+	for k, v := range msgBundle {
+		logger.Debug().RawJSON(k, r.redactor.RedactJSON(v, secrets...)).Msgf("%s message content", k)
+	}
+}
+
+// notifyDispatch passes a dispatched call to the OnDispatch hook, if set
+//
+// Hooks are waited on for up to onDispatchTimeout and recovered if they panic,
+// so a misbehaving hook can't hold up or fail dispatch.
+func (r *Runner) notifyDispatch(ctx context.Context, call dsl.CallAST, sequenceId string, callMeta nats.CallMeta, logger zerolog.Logger) {
+	if r.onDispatch == nil {
+		return
+	}
+
+	subjTokens, err := callSubjectTokens(sequenceId, call)
+	if err != nil {
+		return
+	}
+	subject := strings.Join(append([]string{r.natsClient.AccountId(), r.natsClient.InterestTopic()}, subjTokens...), ".")
+
+	hookCtx, cancel := context.WithTimeout(ctx, onDispatchTimeout)
+	defer cancel()
+	hookCtx = ContextWithCallMeta(logger.WithContext(hookCtx), callMeta)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			if p := recover(); p != nil {
+				logger.Error().Interface("panic", p).Msgf("OnDispatch hook panicked for call: %s", call.Slug)
+			}
+		}()
+
+		r.onDispatch(hookCtx, call, subject, call.Inputs)
+	}()
+
+	select {
+	case <-done:
+	case <-hookCtx.Done():
+		logger.Warn().Dur("timeout", onDispatchTimeout).Msgf("OnDispatch hook did not return in time for call: %s", call.Slug)
+	}
+}
+
+// observeCalls reports the outcome of dispatching an on block's calls to the
+// runner's metrics, if set
+func (r *Runner) observeCalls(dispatched int, skipped int, errored int) {
+	if r.metrics == nil {
+		return
+	}
+
+	r.metrics.ObserveCalls(dispatched, skipped, errored)
+}
+
+// observeSensorFailures reports the number of on blocks that failed due to the
+// hops config to the runner's metrics, if set and any failed
+func (r *Runner) observeSensorFailures(failed int) {
+	if r.metrics == nil || failed == 0 {
+		return
+	}
+
+	r.metrics.ObserveSensorFailures(failed)
+}
+
 // prepareHopsSchedules parses the schedule blocks in a hops config and inits
 // the cron schedules ready for running
 //
@@ -252,6 +822,31 @@ func (r *Runner) prepareHopsSchedules() error {
 	return nil
 }
 
+// publishSequenceError reports an error evaluating a sequence as an event in the
+// sequence, so it's visible beyond the logs of this runner
+//
+// Only the first error is reported, as retries of a failing message usually
+// fail the same way. Failures to report are logged rather than returned, so
+// reporting errors can never cause more errors.
+func (r *Runner) publishSequenceError(ctx context.Context, err error, hopsHash string, sequenceId string, msgBundle nats.MessageBundle, logger zerolog.Logger) {
+	if _, ok := msgBundle[nats.ErrorMessageId]; ok {
+		return
+	}
+
+	on, call := errorSource(err)
+
+	if r.dryRun {
+		logger.Info().Bool("dry_run", true).Str("on", on).Str("call", call).Msg("Would report sequence error")
+		return
+	}
+
+	seqErr := nats.NewSequenceErrorMsg(err, hopsHash, on, call)
+	_, pubErr := r.natsClient.PublishSequenceError(ctx, seqErr, sequenceId)
+	if pubErr != nil {
+		logger.Warn().Err(pubErr).Msg("Unable to report sequence error")
+	}
+}
+
 func (r *Runner) setCron() {
 
 	if r.cron != nil {
@@ -268,6 +863,11 @@ func (r *Runner) setCron() {
 
 // sequenceHops attempts to assign the local hops config to a sequence,
 // returning either the newly assigned hops body or the existing one if present.
+//
+// This ensures a sequence is evaluated against the same version of the hops config
+// throughout, even if the runner's config changes part way through. If the assigned
+// version is no longer stored, the runner's current config is used instead. Any
+// other error fetching it is returned, so the sequence is retried.
 func (r *Runner) sequenceHops(ctx context.Context, sequenceId string, msgBundle nats.MessageBundle) (*dsl.HopsFiles, error) {
 	key, err := r.sequenceHopsKey(ctx, sequenceId, msgBundle)
 	if err != nil {
@@ -283,7 +883,24 @@ func (r *Runner) sequenceHops(ctx context.Context, sequenceId string, msgBundle
 
 	// No cached copy, fetch from object store
 	r.logger.Debug().Msg("Using remote stored hops config")
-	return r.sequenceHopsStored(key)
+	content, err = r.sequenceHopsStored(key)
+	if err == nil {
+		return content, nil
+	}
+	if !errors.Is(err, natsgo.ErrObjectNotFound) {
+		return nil, err
+	}
+
+	r.hopsLock.RLock()
+	defer r.hopsLock.RUnlock()
+
+	r.logger.Warn().Err(err).Str("sequence_id", sequenceId).Msgf(
+		"Hops config '%s' assigned to pipeline is not stored, using current hops config '%s' instead",
+		key,
+		r.hopsFiles.Hash,
+	)
+
+	return r.hopsFiles, nil
 }
 
 // sequenceHopsKey gets or sets the hops key for a sequence, returning the final key
@@ -297,6 +914,12 @@ func (r *Runner) sequenceHopsKey(ctx context.Context, sequenceId string, msgBund
 		return hopsKeyFromBytes(hopsKeyB)
 	}
 
+	// Assigning a config would stop a live runner assigning its own, so dry runs
+	// use the local config without claiming the sequence
+	if r.dryRun {
+		return hash, nil
+	}
+
 	tokens := nats.SequenceHopsKeyTokens(sequenceId)
 
 	jsonHash := fmt.Sprintf("\"%s\"", hash)
@@ -365,6 +988,7 @@ func (r *Runner) sequenceHopsStored(key string) (*dsl.HopsFiles, error) {
 	hopsFiles := &dsl.HopsFiles{
 		Hash:        key,
 		BodyContent: hopsContent,
+		EventIndex:  dsl.NewEventIndex(hopsContent),
 		Files:       hopsFilesContent,
 	}
 	r.cache.Set(key, hopsFiles, cache.DefaultExpiration)
@@ -374,17 +998,20 @@ func (r *Runner) sequenceHopsStored(key string) (*dsl.HopsFiles, error) {
 
 // storeHops stores the current hopsfiles in object storage and local cache
 //
+// Dry runs only cache them locally, as their config is never assigned to sequences.
 // This function should only ever be called within a write lock on r.hopsLock
 func (r *Runner) storeHops() error {
-	hopsFileB, err := json.Marshal(r.hopsFiles.Files)
-	if err != nil {
-		return err
-	}
+	if !r.dryRun {
+		hopsFileB, err := json.Marshal(r.hopsFiles.Files)
+		if err != nil {
+			return err
+		}
 
-	// Store in object store
-	_, err = r.natsClient.PutSysObject(r.hopsFiles.Hash, hopsFileB)
-	if err != nil {
-		return err
+		// Store in object store
+		_, err = r.natsClient.PutSysObject(r.hopsFiles.Hash, hopsFileB)
+		if err != nil {
+			return err
+		}
 	}
 
 	// Pre-populate local cache (local hops cache item should never expire)
@@ -394,6 +1021,94 @@ func (r *Runner) storeHops() error {
 	return nil
 }
 
+// runSensors calls fn for each sensor, running up to limit at once
+//
+// Errors are merged in the order the sensors are declared, regardless of the
+// order they finish in. Once ctx is cancelled, sensors that haven't started are
+// skipped with the context's error.
+//
+// With failFast, the first error cancels the context given to the sensors still
+// running and skips those that haven't started. Only the errors that caused this
+// are returned, as sensors stopped because of them haven't failed themselves.
+func runSensors(ctx context.Context, sensors []dsl.OnAST, limit int, failFast bool, fn func(context.Context, *dsl.OnAST) error) error {
+	errs := make([]error, len(sensors))
+	sem := make(chan struct{}, limit)
+
+	sensorCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var failedMu sync.Mutex
+	failed := false
+
+	var wg sync.WaitGroup
+	for i := range sensors {
+		i := i
+
+		// Checked first, as select picks at random when a slot is free as well
+		if sensorCtx.Err() != nil {
+			errs[i] = ctx.Err()
+			continue
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-sensorCtx.Done():
+			errs[i] = ctx.Err()
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			err := fn(sensorCtx, &sensors[i])
+			if err == nil || !failFast {
+				errs[i] = err
+				return
+			}
+
+			failedMu.Lock()
+			defer failedMu.Unlock()
+
+			// Siblings cancelled by an earlier failure return the context's error
+			if failed && ctx.Err() == nil && errors.Is(err, context.Canceled) {
+				return
+			}
+
+			errs[i] = err
+			failed = true
+			cancel()
+		}()
+	}
+
+	wg.Wait()
+
+	var mergedErrors error
+	for _, err := range errs {
+		if err != nil {
+			mergedErrors = multierror.Append(mergedErrors, err)
+		}
+	}
+
+	return mergedErrors
+}
+
+// sequenceCallMeta describes the sequence being evaluated, for the headers of the
+// calls it dispatches. The event and action are empty if the source event
+// couldn't be read.
+func sequenceCallMeta(sequenceId string, hopsHash string, sourceMeta nats.SourceMeta) nats.CallMeta {
+	return nats.CallMeta{
+		Action:     sourceMeta.Action,
+		Event:      sourceMeta.Event,
+		HopsHash:   hopsHash,
+		RequestId:  sourceMeta.RequestId,
+		SequenceId: sequenceId,
+	}
+}
+
 func hopsKeyFromBytes(keyB []byte) (string, error) {
 	key := ""
 	err := json.Unmarshal(keyB, &key)
@@ -402,3 +1117,146 @@ func hopsKeyFromBytes(keyB []byte) (string, error) {
 	}
 	return key, err
 }
+
+// WithDispatchTimeout sets how long publishing each call may take before it's
+// abandoned and the message being processed is retried (defaults to DefaultDispatchTimeout)
+func WithDispatchTimeout(timeout time.Duration) RunnerOpt {
+	return func(r *Runner) {
+		r.dispatchTimeout = timeout
+	}
+}
+
+// WithDispatcher sets what dispatching a call means (defaults to a PublishDispatcher)
+func WithDispatcher(dispatcher Dispatcher) RunnerOpt {
+	return func(r *Runner) {
+		r.dispatcher = dispatcher
+	}
+}
+
+// WithDryRun makes the runner log the calls it would dispatch rather than
+// publishing them, so hops configs can be tested against live events without
+// triggering any tasks
+//
+// Sequences are otherwise evaluated and acked as normal, so the runner's client
+// should consume with a consumer of its own (see nats.WithDryRunner) rather than
+// taking messages from live runners. As no calls are dispatched, no results
+// arrive, so calls that depend on results never fire. Dry runs also don't store
+// their hops config or publish scheduled events.
+// Use WithShadowSubject to publish what would be dispatched as well.
+func WithDryRun() RunnerOpt {
+	return func(r *Runner) {
+		r.dryRun = true
+	}
+}
+
+// WithFailFast stops evaluating a sequence's on blocks as soon as one fails, rather
+// than evaluating every on block and collecting their errors (the default)
+//
+// On blocks are evaluated concurrently, so those already running when one fails
+// have their context cancelled, and any calls they haven't dispatched yet aren't.
+// On blocks yet to start are skipped. Only the first failures are reported, and
+// retried or not as usual, with calls already dispatched skipped on retry.
+func WithFailFast() RunnerOpt {
+	return func(r *Runner) {
+		r.failFast = true
+	}
+}
+
+// WithGlobals makes globals available to every on and call block as
+// `global.<name>`, e.g. for account level config that isn't part of events
+func WithGlobals(globals map[string]cty.Value) RunnerOpt {
+	return func(r *Runner) {
+		r.globals = globals
+	}
+}
+
+// WithInputLogging logs the inputs of every dispatched call at debug level, after
+// redacting sensitive keys (see SetRedactKeys) and any secrets. Inputs over limit
+// bytes are truncated, with limits below 1 using DefaultInputLogLimit.
+//
+// Inputs may be large or hold sensitive values the redaction misses, so aren't
+// logged by default.
+func WithInputLogging(limit int) RunnerOpt {
+	return func(r *Runner) {
+		if limit < 1 {
+			limit = DefaultInputLogLimit
+		}
+
+		r.inputLogLimit = limit
+	}
+}
+
+// WithMaxEvaluations limits how many sequences are evaluated at once, across all
+// callers of SequenceCallback. Sequences over the limit wait until one finishes.
+//
+// Consuming WithSequenceConcurrency already limits sequences fetched from the
+// stream, so this bounds the memory used by parsing regardless of where sequences
+// come from. Limits below 1 are ignored.
+func WithMaxEvaluations(limit int) RunnerOpt {
+	return func(r *Runner) {
+		if limit < 1 {
+			return
+		}
+
+		r.evalSlots = make(chan struct{}, limit)
+	}
+}
+
+// WithMetrics sets the metrics implementation that receives observations about
+// the sequences processed by the runner. Metrics are not collected unless set.
+func WithMetrics(metrics Metrics) RunnerOpt {
+	return func(r *Runner) {
+		r.metrics = metrics
+	}
+}
+
+// WithOnDispatch calls hook with every call the runner dispatches, e.g. to record
+// an audit trail of dispatched calls without parsing NATS traffic
+//
+// The hook is called synchronously once the call has been published, so only
+// successfully dispatched calls are observed. Calls are dispatched concurrently,
+// so hooks must be safe for concurrent use. Dispatch waits on each hook for up to
+// a second, cancelling its context after that, and recovers any panic. Calls
+// republished when a message is redelivered may be observed more than once.
+// Dry runs and evaluate-only replays don't dispatch calls, so don't call hook.
+func WithOnDispatch(hook OnDispatchFunc) RunnerOpt {
+	return func(r *Runner) {
+		r.onDispatch = hook
+	}
+}
+
+// WithSequenceState records each sequence's dispatched calls, completion and last
+// evaluation time in store, which is consulted before re-evaluating the sequence.
+// This avoids duplicate dispatches when a message bundle lags behind, e.g. after
+// a runner restarts mid-sequence, and skips evaluating completed sequences.
+//
+// State is advisory: losing it falls back to evaluating from the bundle alone.
+// Dry runs don't use it.
+func WithSequenceState(store SequenceStateStore) RunnerOpt {
+	return func(r *Runner) {
+		r.sequenceState = store
+	}
+}
+
+// WithSequenceTimeout sets how long after its source event each on block in a
+// sequence may run for, unless the block sets its own timeout. Defaults to no timeout.
+//
+// Timeouts are checked whenever a sequence is evaluated, and the sequence is
+// scheduled to be evaluated again once the next one passes (see
+// nats.Client.PublishDeadline). Once passed, the block dispatches no further calls
+// and stops waiting on pending ones, which are recorded as expired in the
+// sequence's timeout and completion events.
+func WithSequenceTimeout(timeout time.Duration) RunnerOpt {
+	return func(r *Runner) {
+		r.sequenceTimeout = timeout
+	}
+}
+
+// WithShadowSubject makes dry runs publish a record of each call they would
+// dispatch to subject.SEQUENCE_ID.CALL_SLUG via core NATS, as well as logging it.
+// Has no effect unless WithDryRun is given too.
+func WithShadowSubject(subject string) RunnerOpt {
+	return func(r *Runner) {
+		r.shadowSubject = subject
+	}
+}
@@ -2,8 +2,29 @@
 package hops
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/hashicorp/go-multierror"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hiphops-io/hops/dsl"
+	"github.com/hiphops-io/hops/logs"
+	"github.com/hiphops-io/hops/nats"
+	"github.com/hiphops-io/hops/nats/natstest"
 )
 
 // type LeaseStub struct {
@@ -39,6 +60,1244 @@ func TestTaskDispatch(t *testing.T) {
 	t.Skip("No actual tests implemented yet")
 }
 
+func TestRunSensorsConcurrently(t *testing.T) {
+	sensors := []dsl.OnAST{{Slug: "first"}, {Slug: "slowest"}, {Slug: "third"}, {Slug: "fourth"}}
+	delays := map[string]time.Duration{
+		"first":   100 * time.Millisecond,
+		"slowest": 300 * time.Millisecond,
+		"third":   200 * time.Millisecond,
+		"fourth":  100 * time.Millisecond,
+	}
+
+	var mu sync.Mutex
+	ran := map[string]bool{}
+
+	startedAt := time.Now()
+	err := runSensors(context.Background(), sensors, maxConcurrentSensors, false, func(ctx context.Context, sensor *dsl.OnAST) error {
+		time.Sleep(delays[sensor.Slug])
+
+		mu.Lock()
+		ran[sensor.Slug] = true
+		mu.Unlock()
+
+		// These finish in the opposite order to which they're declared
+		if sensor.Slug == "first" || sensor.Slug == "third" {
+			return fmt.Errorf("%s failed", sensor.Slug)
+		}
+		return nil
+	})
+	elapsed := time.Since(startedAt)
+
+	assert.Len(t, ran, len(sensors), "Every sensor should run")
+	assert.Less(t, elapsed, 500*time.Millisecond, "Sensors should run concurrently, taking close to the slowest sensor rather than the sum")
+
+	var merged *multierror.Error
+	require.True(t, errors.As(err, &merged), "Errors should be merged")
+	require.Len(t, merged.Errors, 2)
+	assert.EqualError(t, merged.Errors[0], "first failed", "Errors should be in the order sensors are declared")
+	assert.EqualError(t, merged.Errors[1], "third failed", "Errors should be in the order sensors are declared")
+}
+
+func TestRunSensorsLimit(t *testing.T) {
+	sensors := make([]dsl.OnAST, 6)
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+
+	err := runSensors(context.Background(), sensors, 2, false, func(ctx context.Context, sensor *dsl.OnAST) error {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, maxRunning, "No more than the limit of sensors should run at once")
+}
+
+func TestRunSensorsCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sensors := make([]dsl.OnAST, 4)
+
+	ran := 0
+	err := runSensors(ctx, sensors, 1, false, func(ctx context.Context, sensor *dsl.OnAST) error {
+		ran++
+		cancel()
+		return nil
+	})
+
+	assert.ErrorIs(t, err, context.Canceled, "Sensors not started before cancellation should error")
+	assert.Less(t, ran, len(sensors), "Sensors should stop being started once cancelled")
+}
+
+func TestRunSensorsFailFast(t *testing.T) {
+	sensors := []dsl.OnAST{{Slug: "failing"}, {Slug: "running"}, {Slug: "waiting"}}
+
+	var mu sync.Mutex
+	ran := map[string]bool{}
+
+	err := runSensors(context.Background(), sensors, 2, true, func(ctx context.Context, sensor *dsl.OnAST) error {
+		mu.Lock()
+		ran[sensor.Slug] = true
+		mu.Unlock()
+
+		switch sensor.Slug {
+		case "failing":
+			time.Sleep(20 * time.Millisecond)
+			return errors.New("failing failed")
+		case "running":
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("Call not dispatched: %w", ctx.Err())
+			case <-time.After(time.Second):
+				return errors.New("running was not cancelled")
+			}
+		}
+
+		return nil
+	})
+
+	var merged *multierror.Error
+	require.True(t, errors.As(err, &merged), "Errors should be merged")
+	require.Len(t, merged.Errors, 1, "Only the error failing fast should be returned")
+	assert.EqualError(t, merged.Errors[0], "failing failed")
+	assert.True(t, ran["running"], "Sensors already running should be started")
+	assert.False(t, ran["waiting"], "Sensors not yet started should be skipped")
+
+	ran = map[string]bool{}
+	err = runSensors(context.Background(), sensors[:1], 2, true, func(ctx context.Context, sensor *dsl.OnAST) error {
+		return nil
+	})
+	assert.NoError(t, err, "Fail fast should make no difference without errors")
+}
+
+func TestRunnerDispatchTimeout(t *testing.T) {
+	logger := logs.NoOpLogger()
+	natsClient, localNats := setupRunnerClient(t)
+
+	hopsDir := t.TempDir()
+	err := os.WriteFile(testHopsPath(t, hopsDir), []byte("on testevent {}\n"), 0o644)
+	require.NoError(t, err, "Test setup: Should write hops file")
+
+	hopsLoader, err := NewHopsFileLoader(hopsDir, false)
+	require.NoError(t, err, "Test setup: Hops files should load without error")
+
+	runner, err := NewRunner(natsClient, hopsLoader, logger, WithDispatchTimeout(200*time.Millisecond))
+	require.NoError(t, err, "Test setup: Runner should initialise without error")
+
+	// Publishes wait for an ack that never comes whilst the client tries to reconnect
+	localNats.Close()
+
+	sensor := &dsl.OnAST{
+		Slug: "pipeline",
+		Calls: []dsl.CallAST{
+			{Slug: "pipeline-slow", TaskType: "app_handler", Inputs: []byte("{}")},
+			{Slug: "pipeline-slower", TaskType: "app_handler", Inputs: []byte("{}")},
+		},
+	}
+
+	t.Run("Dispatch timeout", func(t *testing.T) {
+		startedAt := time.Now()
+		err := runner.dispatchCalls(context.Background(), sensor, "SEQ_ID", nats.MessageBundle{}, nats.CallMeta{}, false, nil, nil, logger)
+		elapsed := time.Since(startedAt)
+
+		assert.ErrorIs(t, err, context.DeadlineExceeded, "Timed out calls should error, so the message is retried")
+		assert.ErrorContains(t, err, "pipeline-slow", "Error should name the call that timed out")
+		assert.ErrorContains(t, err, "pipeline-slower", "Error should name the call that timed out")
+		assert.Less(t, elapsed, time.Second, "Calls should be dispatched concurrently, each within the timeout")
+	})
+
+	t.Run("Parent cancelled", func(t *testing.T) {
+		runner.dispatchTimeout = time.Minute
+
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+
+		startedAt := time.Now()
+		err := runner.dispatchCalls(ctx, sensor, "SEQ_ID", nats.MessageBundle{}, nats.CallMeta{}, false, nil, nil, logger)
+		elapsed := time.Since(startedAt)
+
+		assert.Error(t, err)
+		assert.Less(t, elapsed, time.Second, "Dispatch should stop promptly when the parent context is done")
+	})
+}
+
+// flakyPublisher fails its first `failures` publishes, then succeeds
+type flakyPublisher struct {
+	attempts  atomic.Int32
+	duplicate bool
+	failures  int32
+}
+
+func (f *flakyPublisher) PublishCall(ctx context.Context, data []byte, meta nats.CallMeta, subjTokens ...string) (*jetstream.PubAck, bool, error) {
+	attempt := f.attempts.Add(1)
+	if attempt <= f.failures {
+		return nil, false, errors.New("nats: no responders available for request")
+	}
+
+	return &jetstream.PubAck{Duplicate: f.duplicate}, !f.duplicate, nil
+}
+
+func TestRunnerDispatchRetry(t *testing.T) {
+	logger := logs.NoOpLogger()
+
+	sensor := &dsl.OnAST{
+		Slug: "pipeline",
+		Calls: []dsl.CallAST{
+			{Slug: "pipeline-flaky", TaskType: "app_handler", Inputs: []byte("{}")},
+		},
+	}
+
+	tests := []struct {
+		name             string
+		publisher        *flakyPublisher
+		expectedAttempts int32
+		expectErr        bool
+	}{
+		{
+			name:             "Succeeds first time",
+			publisher:        &flakyPublisher{},
+			expectedAttempts: 1,
+		},
+		{
+			name:             "Fails first attempt",
+			publisher:        &flakyPublisher{failures: 1},
+			expectedAttempts: 2,
+		},
+		{
+			name:             "Fails every attempt",
+			publisher:        &flakyPublisher{failures: 10},
+			expectedAttempts: dispatchAttempts,
+			expectErr:        true,
+		},
+		{
+			name:             "Duplicate is not retried",
+			publisher:        &flakyPublisher{duplicate: true},
+			expectedAttempts: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			runner := &Runner{dispatchTimeout: DefaultDispatchTimeout, dispatcher: &PublishDispatcher{publisher: tc.publisher}}
+
+			err := runner.dispatchCalls(context.Background(), sensor, "SEQ_ID", nats.MessageBundle{}, nats.CallMeta{}, false, nil, nil, logger)
+			if tc.expectErr {
+				assert.ErrorContains(t, err, "pipeline-flaky", "Error should name the call that failed")
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, tc.expectedAttempts, tc.publisher.attempts.Load())
+		})
+	}
+
+	t.Run("Cancelled whilst backing off", func(t *testing.T) {
+		publisher := &flakyPublisher{failures: 10}
+		runner := &Runner{dispatchTimeout: DefaultDispatchTimeout, dispatcher: &PublishDispatcher{publisher: publisher}}
+
+		ctx, cancel := context.WithTimeout(context.Background(), dispatchBackoff/2)
+		defer cancel()
+
+		err := runner.dispatchCalls(ctx, sensor, "SEQ_ID", nats.MessageBundle{}, nats.CallMeta{}, false, nil, nil, logger)
+
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, int32(1), publisher.attempts.Load(), "Retries should stop once the context is done")
+	})
+}
+
+// blockingPublisher holds every publish until released
+type blockingPublisher struct {
+	release chan struct{}
+	started chan struct{}
+	once    sync.Once
+}
+
+func (b *blockingPublisher) PublishCall(ctx context.Context, data []byte, meta nats.CallMeta, subjTokens ...string) (*jetstream.PubAck, bool, error) {
+	b.once.Do(func() { close(b.started) })
+
+	select {
+	case <-b.release:
+		return &jetstream.PubAck{}, true, nil
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+}
+
+func TestRunnerStop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger := logs.NoOpLogger()
+	natsClient, _ := setupRunnerClient(t)
+
+	hopsDir := t.TempDir()
+	content, err := os.ReadFile("./testdata/simple.hops")
+	require.NoError(t, err, "Test setup: Should read hops file")
+	err = os.WriteFile(testHopsPath(t, hopsDir), content, 0o644)
+	require.NoError(t, err, "Test setup: Should write hops file")
+
+	hopsLoader, err := NewHopsFileLoader(hopsDir, false)
+	require.NoError(t, err, "Test setup: Hops files should load without error")
+
+	runner, err := NewRunner(natsClient, hopsLoader, logger)
+	require.NoError(t, err, "Test setup: Runner should initialise without error")
+
+	assert.NoError(t, runner.Stop(ctx), "Stopping a runner that isn't running should do nothing")
+
+	publisher := &blockingPublisher{release: make(chan struct{}), started: make(chan struct{})}
+	runner.dispatcher = &PublishDispatcher{publisher: publisher}
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- runner.Run(ctx, nats.DefaultConsumerName)
+	}()
+
+	eventData, err := os.ReadFile("./testdata/source_testevent.json")
+	require.NoError(t, err, "Test setup: Should read source event")
+	_, _, err = natsClient.Publish(ctx, eventData, nats.ChannelNotify, "SEQ_ID", nats.SourceEventId)
+	require.NoError(t, err, "Test setup: Source event should be published")
+
+	select {
+	case <-publisher.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Call should be dispatched")
+	}
+
+	stopCtx, stopCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer stopCancel()
+
+	stopErr := make(chan error, 1)
+	go func() {
+		stopErr <- runner.Stop(stopCtx)
+	}()
+
+	select {
+	case <-stopErr:
+		t.Fatal("Stop should wait for the sequence being evaluated")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(publisher.release)
+
+	select {
+	case err := <-stopErr:
+		assert.NoError(t, err, "Runner should stop without error")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop should return once the sequence is evaluated")
+	}
+
+	select {
+	case err := <-runErr:
+		assert.NoError(t, err, "Run should return once stopped")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run should return once stopped")
+	}
+}
+
+func TestRunnerSequenceHops(t *testing.T) {
+	ctx := context.Background()
+	logger := logs.NoOpLogger()
+	natsClient, _ := setupRunnerClient(t)
+
+	hopsDir := t.TempDir()
+	hopsPath := testHopsPath(t, hopsDir)
+	writeHops := func(pipelineName string) {
+		content := fmt.Sprintf("on testevent {\n  name = \"%s\"\n}\n", pipelineName)
+		err := os.WriteFile(hopsPath, []byte(content), 0o644)
+		require.NoError(t, err, "Test setup: Should write hops file")
+	}
+
+	writeHops("old_pipeline")
+	hopsLoader, err := NewHopsFileLoader(hopsDir, false)
+	require.NoError(t, err, "Test setup: Hops files should load without error")
+
+	oldRunner, err := NewRunner(natsClient, hopsLoader, logger)
+	require.NoError(t, err, "Test setup: Runner should initialise without error")
+	oldHash := oldRunner.hopsFiles.Hash
+
+	// A fresh runner holding newer content, which has never seen the old config locally
+	writeHops("new_pipeline")
+	err = hopsLoader.Reload(ctx, false)
+	require.NoError(t, err, "Test setup: Hops files should reload without error")
+
+	runner, err := NewRunner(natsClient, hopsLoader, logger)
+	require.NoError(t, err, "Test setup: Runner should initialise without error")
+	newHash := runner.hopsFiles.Hash
+	require.NotEqual(t, oldHash, newHash, "Test setup: Hops config should have changed")
+
+	hopsFiles, err := runner.sequenceHops(ctx, "SEQ_ID", nats.MessageBundle{"hops": []byte(fmt.Sprintf("%q", oldHash))})
+	require.NoError(t, err, "Assigned hops config should be resolved")
+	assert.Equal(t, oldHash, hopsFiles.Hash, "Sequence should use the hops config it was assigned")
+	assert.Contains(t, string(hopsFiles.Files[0].Content), "old_pipeline")
+
+	hopsFiles, err = runner.sequenceHops(ctx, "SEQ_ID", nats.MessageBundle{"hops": []byte(`"missing-hash"`)})
+	require.NoError(t, err, "Unresolvable hops config should fall back to the current config")
+	assert.Equal(t, newHash, hopsFiles.Hash, "Sequence should use the current hops config when the assigned one can't be found")
+
+	_, err = natsClient.PutSysObject("corrupt-hash", []byte("not json"))
+	require.NoError(t, err, "Test setup: Should store corrupt hops config")
+
+	_, err = runner.sequenceHops(ctx, "SEQ_ID", nats.MessageBundle{"hops": []byte(`"corrupt-hash"`)})
+	assert.Error(t, err, "Only hops configs that aren't stored should fall back to the current config")
+}
+
+func TestRunnerSkipsDispatchedCalls(t *testing.T) {
+	ctx := context.Background()
+	logger := logs.NoOpLogger()
+	natsClient, _ := setupRunnerClient(t)
+
+	hopsDir := t.TempDir()
+	content, err := os.ReadFile("./testdata/simple.hops")
+	require.NoError(t, err, "Test setup: Should read hops file")
+	err = os.WriteFile(testHopsPath(t, hopsDir), content, 0o644)
+	require.NoError(t, err, "Test setup: Should write hops file")
+
+	hopsLoader, err := NewHopsFileLoader(hopsDir, false)
+	require.NoError(t, err, "Test setup: Hops files should load without error")
+
+	runner, err := NewRunner(natsClient, hopsLoader, logger)
+	require.NoError(t, err, "Test setup: Runner should initialise without error")
+
+	// Core NATS subscribers see every publish, including any the stream would reject as duplicates
+	requestFilter := strings.Join([]string{natsClient.AccountId(), natsClient.InterestTopic(), nats.ChannelRequest, "SEQ_ID", ">"}, ".")
+	sub, err := natsClient.NatsConn.SubscribeSync(requestFilter)
+	require.NoError(t, err, "Test setup: Should subscribe to requests")
+	defer sub.Unsubscribe()
+
+	eventData, err := os.ReadFile("./testdata/source_testevent.json")
+	require.NoError(t, err, "Test setup: Should read source event")
+
+	msgs := []struct {
+		data  []byte
+		msgId string
+	}{
+		{data: eventData, msgId: "event"},
+		{data: []byte(`{"completed": true}`), msgId: "unrelated_one"},
+		{data: []byte(`{"completed": true}`), msgId: "unrelated_two"},
+	}
+
+	for _, msg := range msgs {
+		puback, _, err := natsClient.Publish(ctx, msg.data, nats.ChannelNotify, "SEQ_ID", msg.msgId)
+		require.NoError(t, err, "Test setup: Message should be published")
+
+		incomingMsg := &nats.MsgMeta{
+			AccountId:      natsClient.AccountId(),
+			InterestTopic:  natsClient.InterestTopic(),
+			SequenceId:     "SEQ_ID",
+			StreamSequence: puback.Sequence,
+		}
+		msgBundle, err := natsClient.FetchMessageBundle(ctx, incomingMsg)
+		require.NoError(t, err, "Message bundle should be fetched without error")
+
+		err = runner.SequenceCallback(ctx, "SEQ_ID", msgBundle)
+		require.NoError(t, err, "Sequence should be processed without error")
+	}
+
+	err = natsClient.NatsConn.Flush()
+	require.NoError(t, err)
+
+	published := 0
+	for {
+		_, err := sub.NextMsg(100 * time.Millisecond)
+		if err != nil {
+			break
+		}
+		published++
+	}
+
+	assert.Equal(t, 1, published, "The call should be published once, however many messages are in the sequence")
+}
+
+func TestRunnerCallHeaders(t *testing.T) {
+	ctx := context.Background()
+	logger := logs.NoOpLogger()
+	natsClient, _ := setupRunnerClient(t)
+
+	hopsDir := t.TempDir()
+	content, err := os.ReadFile("./testdata/simple.hops")
+	require.NoError(t, err, "Test setup: Should read hops file")
+	err = os.WriteFile(testHopsPath(t, hopsDir), content, 0o644)
+	require.NoError(t, err, "Test setup: Should write hops file")
+
+	hopsLoader, err := NewHopsFileLoader(hopsDir, false)
+	require.NoError(t, err, "Test setup: Hops files should load without error")
+
+	runner, err := NewRunner(natsClient, hopsLoader, logger)
+	require.NoError(t, err, "Test setup: Runner should initialise without error")
+
+	eventData, err := os.ReadFile("./testdata/source_testevent.json")
+	require.NoError(t, err, "Test setup: Should read source event")
+
+	msgBundle := nats.MessageBundle{"event": eventData}
+	err = runner.SequenceCallback(ctx, "SEQ_ID", msgBundle)
+	require.NoError(t, err, "Sequence should be processed without error")
+
+	requestMsg, err := natsClient.GetMsg(ctx, nats.ChannelRequest, "SEQ_ID", "simple_pipeline-should_dispatch", "app", "anything")
+	require.NoError(t, err, "Call should be dispatched")
+
+	assert.Equal(t, "testevent", requestMsg.Header.Get(nats.EventHeader))
+	assert.Equal(t, "foo", requestMsg.Header.Get(nats.ActionHeader))
+	assert.Equal(t, "simple_pipeline", requestMsg.Header.Get(nats.OnHeader))
+	assert.Equal(t, "SEQ_ID", requestMsg.Header.Get(nats.SequenceIdHeader))
+	assert.Equal(t, runner.hopsFiles.Hash, requestMsg.Header.Get(nats.HopsHashHeader))
+}
+
+func TestRunnerOnDispatch(t *testing.T) {
+	ctx := context.Background()
+	logger := logs.NoOpLogger()
+	natsClient, _ := setupRunnerClient(t)
+
+	hopsDir := t.TempDir()
+	content, err := os.ReadFile("./testdata/simple.hops")
+	require.NoError(t, err, "Test setup: Should read hops file")
+	err = os.WriteFile(testHopsPath(t, hopsDir), content, 0o644)
+	require.NoError(t, err, "Test setup: Should write hops file")
+
+	hopsLoader, err := NewHopsFileLoader(hopsDir, false)
+	require.NoError(t, err, "Test setup: Hops files should load without error")
+
+	eventData, err := os.ReadFile("./testdata/source_testevent.json")
+	require.NoError(t, err, "Test setup: Should read source event")
+
+	type observedCall struct {
+		inputs  string
+		slug    string
+		subject string
+	}
+
+	tests := []struct {
+		name   string
+		hook   func(observed chan<- observedCall) OnDispatchFunc
+		expect bool
+	}{
+		{
+			name: "Hook observes dispatched call",
+			hook: func(observed chan<- observedCall) OnDispatchFunc {
+				return func(ctx context.Context, call dsl.CallAST, subject string, inputs []byte) {
+					observed <- observedCall{inputs: string(inputs), slug: call.Slug, subject: subject}
+				}
+			},
+			expect: true,
+		},
+		{
+			name: "Panicking hook",
+			hook: func(observed chan<- observedCall) OnDispatchFunc {
+				return func(ctx context.Context, call dsl.CallAST, subject string, inputs []byte) {
+					panic("Boom")
+				}
+			},
+		},
+		{
+			name: "Slow hook",
+			hook: func(observed chan<- observedCall) OnDispatchFunc {
+				return func(ctx context.Context, call dsl.CallAST, subject string, inputs []byte) {
+					<-ctx.Done()
+				}
+			},
+		},
+	}
+
+	for i, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sequenceId := fmt.Sprintf("SEQ_AUDIT_%d", i)
+			observed := make(chan observedCall, 1)
+			dispatcher := &countingDispatcher{}
+
+			runner, err := NewRunner(natsClient, hopsLoader, logger, WithDispatcher(dispatcher), WithOnDispatch(tc.hook(observed)))
+			require.NoError(t, err, "Test setup: Runner should initialise without error")
+
+			msgBundle := nats.MessageBundle{nats.SourceEventId: eventData}
+			err = runner.SequenceCallback(ctx, sequenceId, msgBundle)
+			require.NoError(t, err, "A misbehaving hook should not fail the sequence")
+			assert.Equal(t, int32(1), dispatcher.dispatched.Load(), "Call should be dispatched regardless of the hook")
+
+			if !tc.expect {
+				return
+			}
+
+			require.Len(t, observed, 1, "Hook should have observed the call before dispatch returned")
+			call := <-observed
+			assert.Equal(t, "simple_pipeline-should_dispatch", call.slug)
+			assert.Equal(t, fmt.Sprintf("%s.%s.request.%s.simple_pipeline-should_dispatch.app.anything", natsClient.AccountId(), natsClient.InterestTopic(), sequenceId), call.subject)
+			assert.JSONEq(t, `{"foo": "bar"}`, call.inputs)
+		})
+	}
+}
+
+// countingDispatcher records how many dispatches are in flight at once, holding
+// each for a short time so concurrent evaluations overlap
+type countingDispatcher struct {
+	dispatched atomic.Int32
+	inFlight   atomic.Int32
+	peak       atomic.Int32
+}
+
+func (c *countingDispatcher) Dispatch(ctx context.Context, sequenceId string, call dsl.CallAST) error {
+	inFlight := c.inFlight.Add(1)
+	defer c.inFlight.Add(-1)
+
+	for {
+		peak := c.peak.Load()
+		if inFlight <= peak || c.peak.CompareAndSwap(peak, inFlight) {
+			break
+		}
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	c.dispatched.Add(1)
+
+	return nil
+}
+
+func TestRunnerMaxEvaluations(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const (
+		maxEvaluations = 5
+		numSequences   = 200
+	)
+
+	logger := logs.NoOpLogger()
+	natsClient, _ := setupRunnerClient(t, nats.WithRunner(nats.DefaultConsumerName), nats.WithSequenceConcurrency(50))
+
+	hopsDir := t.TempDir()
+	content, err := os.ReadFile("./testdata/simple.hops")
+	require.NoError(t, err, "Test setup: Should read hops file")
+	err = os.WriteFile(testHopsPath(t, hopsDir), content, 0o644)
+	require.NoError(t, err, "Test setup: Should write hops file")
+
+	hopsLoader, err := NewHopsFileLoader(hopsDir, false)
+	require.NoError(t, err, "Test setup: Hops files should load without error")
+
+	dispatcher := &countingDispatcher{}
+	runner, err := NewRunner(natsClient, hopsLoader, logger, WithDispatcher(dispatcher), WithMaxEvaluations(maxEvaluations))
+	require.NoError(t, err, "Test setup: Runner should initialise without error")
+
+	eventData, err := os.ReadFile("./testdata/source_testevent.json")
+	require.NoError(t, err, "Test setup: Should read source event")
+
+	for i := 0; i < numSequences; i++ {
+		_, _, err := natsClient.Publish(ctx, eventData, nats.ChannelNotify, fmt.Sprintf("SEQ_%d", i), nats.SourceEventId)
+		require.NoError(t, err, "Test setup: Source event should be published")
+	}
+
+	go runner.Run(ctx, nats.DefaultConsumerName)
+
+	require.Eventually(t, func() bool {
+		return dispatcher.dispatched.Load() == numSequences
+	}, 30*time.Second, 50*time.Millisecond, "Every sequence should be evaluated")
+
+	peak := dispatcher.peak.Load()
+	assert.LessOrEqual(t, peak, int32(maxEvaluations), "No more than the max sequences should be evaluated at once")
+	assert.Greater(t, peak, int32(1), "Sequences should be evaluated concurrently")
+}
+
+func TestRunnerEvaluateOnlyReplay(t *testing.T) {
+	ctx := context.Background()
+	logger := logs.NoOpLogger()
+	natsClient, _ := setupRunnerClient(t)
+
+	hopsDir := t.TempDir()
+	content, err := os.ReadFile("./testdata/simple.hops")
+	require.NoError(t, err, "Test setup: Should read hops file")
+	err = os.WriteFile(testHopsPath(t, hopsDir), content, 0o644)
+	require.NoError(t, err, "Test setup: Should write hops file")
+
+	hopsLoader, err := NewHopsFileLoader(hopsDir, false)
+	require.NoError(t, err, "Test setup: Hops files should load without error")
+
+	runner, err := NewRunner(natsClient, hopsLoader, logger)
+	require.NoError(t, err, "Test setup: Runner should initialise without error")
+
+	eventData, err := os.ReadFile("./testdata/source_testevent.json")
+	require.NoError(t, err, "Test setup: Should read source event")
+
+	event := map[string]any{}
+	err = json.Unmarshal(eventData, &event)
+	require.NoError(t, err, "Test setup: Should decode source event")
+	event["hops"].(map[string]any)["replay"] = nats.ReplayMeta{Mode: nats.ReplayModeEvaluate, SequenceId: "SEQ_ID"}
+	eventData, err = json.Marshal(event)
+	require.NoError(t, err, "Test setup: Should encode replayed source event")
+
+	requests, err := natsClient.NatsConn.SubscribeSync(nats.RequestFilterSubject(natsClient.AccountId(), natsClient.InterestTopic()))
+	require.NoError(t, err, "Test setup: Should subscribe to requests")
+	defer requests.Unsubscribe()
+
+	replaySeqId := nats.ReplaySequencePrefix + "SEQ_ID"
+	msgBundle := nats.MessageBundle{"event": eventData}
+
+	// Evaluating more than once should still only record the call once
+	for i := 0; i < 2; i++ {
+		err = runner.SequenceCallback(ctx, replaySeqId, msgBundle)
+		require.NoError(t, err, "Sequence should be processed without error")
+	}
+
+	err = natsClient.NatsConn.Flush()
+	require.NoError(t, err)
+
+	pending, _, err := requests.Pending()
+	require.NoError(t, err)
+	assert.Zero(t, pending, "No requests should be published when evaluating only")
+
+	wouldDispatch, err := natsClient.GetMsg(ctx, nats.ChannelNotify, replaySeqId, "simple_pipeline-should_dispatch", nats.WouldDispatchMessageId)
+	require.NoError(t, err, "Call should be recorded as would dispatch")
+	assert.Equal(t, "simple_pipeline", wouldDispatch.Header.Get(nats.OnHeader))
+
+	recorded := nats.WouldDispatchMsg{}
+	err = json.Unmarshal(wouldDispatch.Data, &recorded)
+	require.NoError(t, err)
+	assert.Equal(t, "app", recorded.App)
+	assert.Equal(t, "anything", recorded.Handler)
+}
+
+func TestRunnerDependentCalls(t *testing.T) {
+	logger := logs.NoOpLogger()
+	natsClient, _ := setupRunnerClient(t)
+
+	tests := []struct {
+		name   string
+		second string
+	}{
+		{
+			name: "Referencing result in if",
+			second: `if = first.completed
+
+    inputs = {
+      from_first = first.json.value
+    }`,
+		},
+		{
+			name: "Referencing result via depends_on",
+			second: `depends_on = ["first"]
+
+    inputs = {
+      from_first = calls.first.json.value
+    }`,
+		},
+	}
+
+	for i, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			sequenceId := fmt.Sprintf("SEQ_DEPENDENT_%d", i)
+
+			hopsDir := t.TempDir()
+			content := fmt.Sprintf(`on testevent {
+  name = "two_step"
+
+  call app_first {
+    name = "first"
+  }
+
+  call app_second {
+    name = "second"
+    %s
+  }
+}
+`, tc.second)
+			err := os.WriteFile(testHopsPath(t, hopsDir), []byte(content), 0o644)
+			require.NoError(t, err, "Test setup: Should write hops file")
+
+			hopsLoader, err := NewHopsFileLoader(hopsDir, false)
+			require.NoError(t, err, "Test setup: Hops files should load without error")
+
+			runner, err := NewRunner(natsClient, hopsLoader, logger)
+			require.NoError(t, err, "Test setup: Runner should initialise without error")
+
+			type dispatchedCall struct {
+				inputs []byte
+				slug   string
+			}
+			dispatched := make(chan dispatchedCall, 10)
+
+			// A fake worker, completing every request it receives
+			requestFilter := strings.Join([]string{natsClient.AccountId(), natsClient.InterestTopic(), nats.ChannelRequest, sequenceId, ">"}, ".")
+			sub, err := natsClient.NatsConn.SubscribeSync(requestFilter)
+			require.NoError(t, err, "Test setup: Should subscribe to requests")
+			defer sub.Unsubscribe()
+
+			go func() {
+				for {
+					msg, err := sub.NextMsg(5 * time.Second)
+					if err != nil {
+						return
+					}
+
+					callSlug := strings.Split(msg.Subject, ".")[4]
+					dispatched <- dispatchedCall{inputs: msg.Data, slug: callSlug}
+
+					result := map[string]string{"value": fmt.Sprintf("from %s", callSlug)}
+					err, _ = natsClient.PublishResult(ctx, time.Now(), result, nil, nats.ChannelNotify, sequenceId, callSlug)
+					assert.NoError(t, err, "Fake worker should publish result")
+				}
+			}()
+
+			go runner.Run(ctx, nats.DefaultConsumerName)
+
+			eventData, err := os.ReadFile("./testdata/source_testevent.json")
+			require.NoError(t, err, "Test setup: Should read source event")
+			_, _, err = natsClient.Publish(ctx, eventData, nats.ChannelNotify, sequenceId, "event")
+			require.NoError(t, err, "Test setup: Source event should be published")
+
+			nextDispatch := func() dispatchedCall {
+				select {
+				case call := <-dispatched:
+					return call
+				case <-time.After(5 * time.Second):
+					require.FailNow(t, "Timed out waiting for call to be dispatched")
+					return dispatchedCall{}
+				}
+			}
+
+			first := nextDispatch()
+			assert.Equal(t, "two_step-first", first.slug, "Call without dependencies should be dispatched first")
+
+			second := nextDispatch()
+			assert.Equal(t, "two_step-second", second.slug, "Dependent call should be dispatched once the result it references arrives")
+			assert.JSONEq(t, `{"from_first": "from two_step-first"}`, string(second.inputs))
+
+			assert.Eventually(t, func() bool {
+				_, err := natsClient.GetMsg(ctx, nats.ChannelNotify, sequenceId, "two_step", nats.DoneMessageId)
+				return err == nil
+			}, 5*time.Second, 50*time.Millisecond, "Pipeline should be done once both calls have results")
+
+			select {
+			case call := <-dispatched:
+				assert.Failf(t, "No further calls should be dispatched", "Dispatched %s", call.slug)
+			case <-time.After(200 * time.Millisecond):
+			}
+
+			err = runner.Stop(context.Background())
+			require.NoError(t, err, "Runner should stop cleanly")
+		})
+	}
+}
+
+func TestRunnerReportsSequenceErrors(t *testing.T) {
+	ctx := context.Background()
+	logger := logs.NoOpLogger()
+	natsClient, _ := setupRunnerClient(t)
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{
+			name: "If fails to evaluate",
+			content: `on testevent {
+  name = "broken"
+  if   = tonumber("not a number") > 1
+
+  call app_anything {
+    name = "never_dispatched"
+  }
+}
+`,
+		},
+		{
+			name: "Inputs aren't an object",
+			content: `on testevent {
+  name = "broken"
+
+  call app_anything {
+    name   = "never_dispatched"
+    inputs = "text"
+  }
+}
+`,
+		},
+	}
+
+	eventData, err := os.ReadFile("./testdata/source_testevent.json")
+	require.NoError(t, err, "Test setup: Should read source event")
+
+	for i, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sequenceId := fmt.Sprintf("SEQ_%d", i)
+
+			hopsDir := t.TempDir()
+			err := os.WriteFile(testHopsPath(t, hopsDir), []byte(tc.content), 0o644)
+			require.NoError(t, err, "Test setup: Should write hops file")
+
+			hopsLoader, err := NewHopsFileLoader(hopsDir, false)
+			require.NoError(t, err, "Test setup: Hops files should load without error")
+
+			runner, err := NewRunner(natsClient, hopsLoader, logger)
+			require.NoError(t, err, "Test setup: Runner should initialise without error")
+
+			puback, _, err := natsClient.Publish(ctx, eventData, nats.ChannelNotify, sequenceId, "event")
+			require.NoError(t, err, "Test setup: Source event should be published")
+
+			incomingMsg := &nats.MsgMeta{
+				AccountId:      natsClient.AccountId(),
+				InterestTopic:  natsClient.InterestTopic(),
+				SequenceId:     sequenceId,
+				StreamSequence: puback.Sequence,
+			}
+
+			// Messages may still be evaluated again (e.g. when another arrives), so
+			// every evaluation fails the same way
+			for attempt := 0; attempt < 3; attempt++ {
+				msgBundle, err := natsClient.FetchMessageBundle(ctx, incomingMsg)
+				require.NoError(t, err, "Test setup: Message bundle should be fetched without error")
+
+				err = runner.SequenceCallback(ctx, sequenceId, msgBundle)
+				require.NoError(t, err, "Hops config errors should be reported rather than have the message redelivered")
+			}
+
+			errorMsg, err := natsClient.GetMsg(ctx, nats.ChannelNotify, sequenceId, nats.ErrorMessageId)
+			require.NoError(t, err, "Error event should be published to the sequence")
+
+			seqErr := nats.SequenceErrorMsg{}
+			err = json.Unmarshal(errorMsg.Data, &seqErr)
+			require.NoError(t, err, "Error event should be valid")
+
+			assert.Contains(t, seqErr.Error, "Error parsing hops config")
+			assert.Equal(t, runner.hopsFiles.Hash, seqErr.HopsHash)
+
+			stream, err := natsClient.JetStream.Stream(ctx, natsClient.StreamName())
+			require.NoError(t, err)
+
+			errorSubject := errorMsg.Subject
+			info, err := stream.Info(ctx, jetstream.WithSubjectFilter(errorSubject))
+			require.NoError(t, err)
+			assert.Equal(t, uint64(1), info.State.Subjects[errorSubject], "Only one error event should be published, however often evaluation fails")
+		})
+	}
+}
+
+func TestRunnerIsolatesSensorFailures(t *testing.T) {
+	ctx := context.Background()
+	logger := logs.NoOpLogger()
+	natsClient, _ := setupRunnerClient(t)
+
+	// The bad call has no app/handler, so can never be dispatched
+	content := `on testevent {
+  name = "bad"
+
+  call notanapp {
+    name = "invalid"
+  }
+}
+
+on testevent {
+  name = "good"
+
+  call app_handler {
+    name = "valid"
+  }
+}
+`
+
+	tests := []struct {
+		name               string
+		dispatcher         Dispatcher
+		expectSystemErr    bool
+		expectedDispatches int32
+	}{
+		{
+			name:               "Bad on block alongside good one",
+			dispatcher:         &countingDispatcher{},
+			expectedDispatches: 1,
+		},
+		{
+			name:            "System failure dispatching good one",
+			dispatcher:      &PublishDispatcher{publisher: &flakyPublisher{failures: 10}},
+			expectSystemErr: true,
+		},
+	}
+
+	eventData, err := os.ReadFile("./testdata/source_testevent.json")
+	require.NoError(t, err, "Test setup: Should read source event")
+
+	for i, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sequenceId := fmt.Sprintf("SEQ_%d", i)
+
+			hopsDir := t.TempDir()
+			err := os.WriteFile(testHopsPath(t, hopsDir), []byte(content), 0o644)
+			require.NoError(t, err, "Test setup: Should write hops file")
+
+			hopsLoader, err := NewHopsFileLoader(hopsDir, false)
+			require.NoError(t, err, "Test setup: Hops files should load without error")
+
+			metrics := NewCounterMetrics()
+			runner, err := NewRunner(natsClient, hopsLoader, logger, WithDispatcher(tc.dispatcher), WithMetrics(metrics))
+			require.NoError(t, err, "Test setup: Runner should initialise without error")
+
+			puback, _, err := natsClient.Publish(ctx, eventData, nats.ChannelNotify, sequenceId, nats.SourceEventId)
+			require.NoError(t, err, "Test setup: Source event should be published")
+
+			msgBundle, err := natsClient.FetchMessageBundle(ctx, &nats.MsgMeta{
+				AccountId:      natsClient.AccountId(),
+				InterestTopic:  natsClient.InterestTopic(),
+				SequenceId:     sequenceId,
+				StreamSequence: puback.Sequence,
+			})
+			require.NoError(t, err, "Test setup: Message bundle should be fetched without error")
+
+			err = runner.SequenceCallback(ctx, sequenceId, msgBundle)
+			if tc.expectSystemErr {
+				assert.ErrorAs(t, err, &ErrSystem{}, "System failures should be returned so the message is redelivered")
+				assert.ErrorContains(t, err, "good-valid")
+				assert.NotContains(t, err.Error(), "Unable to parse app/handler", "Hops config errors should not be returned")
+			} else {
+				assert.NoError(t, err, "Hops config errors should not fail the message")
+
+				dispatcher := tc.dispatcher.(*countingDispatcher)
+				assert.Equal(t, tc.expectedDispatches, dispatcher.dispatched.Load(), "Good on block should still dispatch its calls")
+			}
+
+			assert.Equal(t, uint64(1), metrics.Snapshot().SensorsFailed, "Bad on block should be counted")
+
+			errorMsg, err := natsClient.GetMsg(ctx, nats.ChannelNotify, sequenceId, nats.ErrorMessageId)
+			require.NoError(t, err, "Error event should be published to the sequence")
+
+			seqErr := nats.SequenceErrorMsg{}
+			err = json.Unmarshal(errorMsg.Data, &seqErr)
+			require.NoError(t, err, "Error event should be valid")
+			assert.Equal(t, "bad", seqErr.On)
+			assert.Equal(t, "bad-invalid", seqErr.Call)
+		})
+	}
+}
+
+func TestRunnerSequenceCompletion(t *testing.T) {
+	ctx := context.Background()
+	logger := logs.NoOpLogger()
+	natsClient, _ := setupRunnerClient(t)
+
+	twoCalls := `on testevent {
+  name = "pipeline"
+
+  call app_first {
+    name = "first"
+  }
+
+  call app_second {
+    name = "second"
+  }
+}
+`
+
+	result := func(err error) []byte {
+		resultB, marshalErr := json.Marshal(nats.NewResultMsg(time.Now(), "output", err))
+		require.NoError(t, marshalErr, "Test setup: Result should be encoded")
+		return resultB
+	}
+
+	tests := []struct {
+		name           string
+		hops           string
+		bundle         nats.MessageBundle
+		expectComplete bool
+		expectedCalls  int
+		expectedFailed []string
+		expectedStatus string
+	}{
+		{
+			name: "All calls succeeded",
+			hops: twoCalls,
+			bundle: nats.MessageBundle{
+				nats.RequestBundleKey("pipeline-first"):  []byte("{}"),
+				nats.RequestBundleKey("pipeline-second"): []byte("{}"),
+				"pipeline-first":                         result(nil),
+				"pipeline-second":                        result(nil),
+			},
+			expectComplete: true,
+			expectedCalls:  2,
+			expectedStatus: nats.StatusSuccess,
+		},
+		{
+			name: "One call failed",
+			hops: twoCalls,
+			bundle: nats.MessageBundle{
+				nats.RequestBundleKey("pipeline-first"):  []byte("{}"),
+				nats.RequestBundleKey("pipeline-second"): []byte("{}"),
+				"pipeline-first":                         result(nil),
+				"pipeline-second":                        result(errors.New("Boom")),
+			},
+			expectComplete: true,
+			expectedCalls:  2,
+			expectedFailed: []string{"pipeline-second"},
+			expectedStatus: nats.StatusFailure,
+		},
+		{
+			name: "No matching calls",
+			hops: `on testevent {
+  name = "pipeline"
+
+  call app_first {
+    if = false
+  }
+}
+`,
+			bundle:         nats.MessageBundle{},
+			expectComplete: true,
+			expectedCalls:  0,
+			expectedStatus: nats.StatusSuccess,
+		},
+		{
+			name: "Calls still running",
+			hops: twoCalls,
+			bundle: nats.MessageBundle{
+				nats.RequestBundleKey("pipeline-first"):  []byte("{}"),
+				nats.RequestBundleKey("pipeline-second"): []byte("{}"),
+				"pipeline-first":                         result(nil),
+			},
+			expectComplete: false,
+		},
+	}
+
+	eventData, err := os.ReadFile("./testdata/source_testevent.json")
+	require.NoError(t, err, "Test setup: Should read source event")
+
+	for i, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sequenceId := fmt.Sprintf("SEQ_%d", i)
+
+			hopsDir := t.TempDir()
+			err := os.WriteFile(testHopsPath(t, hopsDir), []byte(tc.hops), 0o644)
+			require.NoError(t, err, "Test setup: Should write hops file")
+
+			hopsLoader, err := NewHopsFileLoader(hopsDir, false)
+			require.NoError(t, err, "Test setup: Hops files should load without error")
+
+			runner, err := NewRunner(natsClient, hopsLoader, logger)
+			require.NoError(t, err, "Test setup: Runner should initialise without error")
+
+			_, _, err = natsClient.Publish(ctx, eventData, nats.ChannelNotify, sequenceId, nats.SourceEventId)
+			require.NoError(t, err, "Test setup: Source event should be published")
+
+			tc.bundle[nats.SourceEventId] = eventData
+
+			// Processing the sequence repeatedly should only ever complete it once
+			for n := 0; n < 2; n++ {
+				err = runner.SequenceCallback(ctx, sequenceId, tc.bundle)
+				require.NoError(t, err, "Sequence should be processed without error")
+			}
+
+			msg, err := natsClient.GetMsg(ctx, nats.ChannelNotify, sequenceId, nats.SequenceMessageId, nats.CompletedMessageId)
+			if !tc.expectComplete {
+				assert.Error(t, err, "Sequence should not be complete")
+				return
+			}
+			require.NoError(t, err, "Sequence completion should be published")
+
+			completion := nats.CompletionMsg{}
+			err = json.Unmarshal(msg.Data, &completion)
+			require.NoError(t, err, "Sequence completion should be decoded")
+
+			assert.Equal(t, tc.expectedStatus, completion.Status)
+			assert.Equal(t, tc.expectedCalls, completion.Calls)
+			assert.Equal(t, tc.expectedFailed, completion.Failed)
+			sourceMsg, err := natsClient.GetMsg(ctx, nats.ChannelNotify, sequenceId, nats.SourceEventId)
+			require.NoError(t, err)
+			assert.True(t, sourceMsg.Time.Equal(completion.StartedAt), "Sequence should start with its source event")
+			assert.False(t, completion.CompletedAt.Before(completion.StartedAt))
+
+			stream, err := natsClient.JetStream.Stream(ctx, natsClient.StreamName())
+			require.NoError(t, err)
+			info, err := stream.Info(ctx, jetstream.WithSubjectFilter(msg.Subject))
+			require.NoError(t, err)
+			assert.Equal(t, uint64(1), info.State.Subjects[msg.Subject], "Sequence should only be completed once")
+		})
+	}
+}
+
+func TestRunnerSkipsNonMatchingEvents(t *testing.T) {
+	ctx := context.Background()
+	logger := logs.NoOpLogger()
+	natsClient, _ := setupRunnerClient(t)
+
+	tests := []struct {
+		name           string
+		hops           string
+		expectEvaluate bool
+	}{
+		{
+			name: "Matching event type",
+			hops: `on testevent {
+  call app_handler {}
+}
+`,
+			expectEvaluate: true,
+		},
+		{
+			name: "Matching event type and action",
+			hops: `on testevent_foo {
+  call app_handler {}
+}
+`,
+			expectEvaluate: true,
+		},
+		{
+			name: "Non-matching action",
+			hops: `on testevent_bar {
+  call app_handler {}
+}
+`,
+			expectEvaluate: false,
+		},
+		{
+			name: "Non-matching event type",
+			hops: `on otherevent {
+  call app_handler {}
+}
+`,
+			expectEvaluate: false,
+		},
+	}
+
+	eventData, err := os.ReadFile("./testdata/source_testevent.json")
+	require.NoError(t, err, "Test setup: Should read source event")
+
+	for i, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sequenceId := fmt.Sprintf("SEQ_SKIP_%d", i)
+
+			hopsDir := t.TempDir()
+			err := os.WriteFile(testHopsPath(t, hopsDir), []byte(tc.hops), 0o644)
+			require.NoError(t, err, "Test setup: Should write hops file")
+
+			hopsLoader, err := NewHopsFileLoader(hopsDir, false)
+			require.NoError(t, err, "Test setup: Hops files should load without error")
+
+			dispatcher := &countingDispatcher{}
+			runner, err := NewRunner(natsClient, hopsLoader, logger, WithDispatcher(dispatcher))
+			require.NoError(t, err, "Test setup: Runner should initialise without error")
+
+			msgBundle := nats.MessageBundle{nats.SourceEventId: eventData}
+			err = runner.SequenceCallback(ctx, sequenceId, msgBundle)
+			require.NoError(t, err, "Sequence should be processed without error")
+
+			_, hopsErr := natsClient.GetMsg(ctx, nats.ChannelNotify, sequenceId, nats.HopsMessageId)
+			if !tc.expectEvaluate {
+				assert.Error(t, hopsErr, "Skipped sequence should not be assigned a hops config")
+				assert.Equal(t, int32(0), dispatcher.dispatched.Load(), "Skipped sequence should not dispatch calls")
+				return
+			}
+
+			assert.NoError(t, hopsErr, "Evaluated sequence should be assigned a hops config")
+			assert.Equal(t, int32(1), dispatcher.dispatched.Load(), "Evaluated sequence should dispatch its calls")
+		})
+	}
+}
+
 func initTestEventBundle() (map[string][]byte, error) {
 	eventFile := "./testdata/source_testevent.json"
 
@@ -53,3 +1312,212 @@ func initTestEventBundle() (map[string][]byte, error) {
 
 	return eventBundle, nil
 }
+
+// setupRunnerClient starts an embedded NATS server and returns a client connected to it
+func setupRunnerClient(t *testing.T, clientOpts ...nats.ClientOpt) (*nats.Client, *nats.LocalServer) {
+	localNats := natstest.NewLocalServer(t)
+
+	return natstest.NewClient(t, localNats, clientOpts...), localNats
+}
+
+// testHopsPath returns the path of a hops file within hopsDir, creating its
+// directory, as hops files are only read from the first level of subdirectories
+func testHopsPath(t *testing.T, hopsDir string) string {
+	automationDir := filepath.Join(hopsDir, "automation")
+
+	err := os.MkdirAll(automationDir, 0o755)
+	require.NoError(t, err, "Test setup: Should create automation dir")
+
+	return filepath.Join(automationDir, "main.hops")
+}
+
+func TestRunnerLogInputs(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	defer zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	call := dsl.CallAST{
+		Slug:   "deploy",
+		Inputs: []byte(`{"ref":"main","password":"hunter2","token":"Bearer abc\"123"}`),
+	}
+	// Escaped in the JSON, so only found once the inputs are decoded
+	secrets := []string{`abc"123`}
+
+	logInputs := func(opts ...RunnerOpt) map[string]any {
+		runner := &Runner{redactor: logs.NewRedactor("password")}
+		for _, opt := range opts {
+			opt(runner)
+		}
+
+		var logBuf bytes.Buffer
+		runner.logInputs(call, secrets, zerolog.New(&logBuf))
+		if logBuf.Len() == 0 {
+			return nil
+		}
+
+		entry := map[string]any{}
+		err := json.Unmarshal(logBuf.Bytes(), &entry)
+		require.NoError(t, err, "Log entry should be valid JSON")
+		return entry
+	}
+
+	t.Run("Not logged by default", func(t *testing.T) {
+		assert.Nil(t, logInputs())
+	})
+
+	t.Run("Logs redacted inputs", func(t *testing.T) {
+		entry := logInputs(WithInputLogging(0))
+		require.NotNil(t, entry)
+
+		assert.Equal(t, "deploy", entry["call"])
+		assert.Equal(t, float64(len(call.Inputs)), entry["inputs_size"])
+		assert.NotContains(t, entry, "inputs_truncated")
+
+		inputs := entry["inputs"].(map[string]any)
+		assert.Equal(t, "main", inputs["ref"])
+		assert.NotEqual(t, "hunter2", inputs["password"], "Sensitive keys should be redacted")
+		assert.Equal(t, "Bearer "+logs.RedactedValue, inputs["token"], "Secrets should be redacted")
+	})
+
+	t.Run("Truncates over the limit", func(t *testing.T) {
+		entry := logInputs(WithInputLogging(10))
+		require.NotNil(t, entry)
+
+		assert.Equal(t, true, entry["inputs_truncated"])
+		assert.Equal(t, float64(len(call.Inputs)), entry["inputs_size"])
+		assert.Len(t, entry["inputs"], 10)
+	})
+}
+
+func TestRunnerLogBundle(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	defer zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	// Escaped in the JSON, so only found once the bundle is decoded
+	secret := `abc"123`
+	t.Setenv("HOPS_SECRET_DEPLOY_TOKEN", secret)
+
+	hopsDir := t.TempDir()
+	hopsContent := "on testevent {\n  call app_handler {\n    inputs = { token = secret(\"deploy_token\") }\n  }\n}\n"
+	err := os.WriteFile(testHopsPath(t, hopsDir), []byte(hopsContent), 0o644)
+	require.NoError(t, err, "Test setup: Should write hops file")
+
+	hopsLoader, err := NewHopsFileLoader(hopsDir, false)
+	require.NoError(t, err, "Test setup: Hops files should load without error")
+	hops, err := hopsLoader.Get()
+	require.NoError(t, err, "Test setup: Hops files should be available")
+
+	eventData, err := os.ReadFile("./testdata/source_testevent.json")
+	require.NoError(t, err, "Test setup: Should read source event")
+
+	msgBundle := nats.MessageBundle{"event": eventData}
+	hop, err := dsl.ParseHops(context.Background(), hops, msgBundle, nil, zerolog.Nop())
+	require.NoError(t, err, "Test setup: Hops should parse without error")
+
+	resultData, err := json.Marshal(map[string]any{"body": "Bearer " + secret, "password": "hunter2"})
+	require.NoError(t, err, "Test setup: Should marshal result")
+	msgBundle["app_handler"] = resultData
+
+	var logBuf bytes.Buffer
+	runner := &Runner{redactor: logs.NewRedactor()}
+	runner.logBundle(hop, msgBundle, zerolog.New(&logBuf))
+
+	assert.NotContains(t, logBuf.String(), "hunter2", "Sensitive keys should be redacted")
+	assert.NotContains(t, logBuf.String(), `abc\"123`, "Secrets should be redacted")
+	assert.Contains(t, logBuf.String(), `"body":"Bearer [REDACTED]"`)
+}
+
+func TestRunnerCoreClient(t *testing.T) {
+	ctx := context.Background()
+	logger := logs.NoOpLogger()
+	natsClient := natstest.NewCoreClient(t, natstest.NewLocalServer(t))
+
+	hops := `on testevent {
+  name    = "pipeline"
+  timeout = "%s"
+
+  call app_first {
+    name = "first"
+  }
+}
+`
+
+	tests := []struct {
+		name           string
+		timeout        string
+		respond        bool
+		expectedStatus string
+	}{
+		{
+			name:           "Completes",
+			timeout:        "1h",
+			respond:        true,
+			expectedStatus: nats.StatusSuccess,
+		},
+		{
+			name:           "Times out",
+			timeout:        "200ms",
+			expectedStatus: nats.StatusTimeout,
+		},
+	}
+
+	eventData, err := os.ReadFile("./testdata/source_testevent.json")
+	require.NoError(t, err, "Test setup: Should read source event")
+
+	for i, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sequenceId := fmt.Sprintf("CORE_SEQ_%d", i)
+
+			hopsDir := t.TempDir()
+			err := os.WriteFile(testHopsPath(t, hopsDir), []byte(fmt.Sprintf(hops, tc.timeout)), 0o644)
+			require.NoError(t, err, "Test setup: Should write hops file")
+
+			hopsLoader, err := NewHopsFileLoader(hopsDir, false)
+			require.NoError(t, err, "Test setup: Hops files should load without error")
+
+			runner, err := NewRunner(natsClient, hopsLoader, logger)
+			require.NoError(t, err, "Test setup: Runner should initialise without error")
+
+			requestFilter := strings.Join([]string{natsClient.AccountId(), natsClient.InterestTopic(), nats.ChannelRequest, sequenceId, ">"}, ".")
+			worker, err := natsClient.NatsConn.SubscribeSync(requestFilter)
+			require.NoError(t, err, "Test setup: Fake worker should subscribe to requests")
+			defer worker.Unsubscribe()
+
+			subscriptions := natsClient.NatsConn.NumSubscriptions()
+			runCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			go runner.Run(runCtx, nats.DefaultConsumerName)
+			defer runner.Stop(context.Background())
+
+			require.Eventually(t, func() bool {
+				return natsClient.NatsConn.NumSubscriptions() > subscriptions
+			}, time.Second, 10*time.Millisecond, "Test setup: Runner should subscribe")
+			require.NoError(t, natsClient.NatsConn.Flush())
+
+			_, _, err = natsClient.Publish(ctx, eventData, nats.ChannelNotify, sequenceId, nats.SourceEventId)
+			require.NoError(t, err, "Test setup: Source event should be published")
+
+			_, err = worker.NextMsg(5 * time.Second)
+			require.NoError(t, err, "Call should be dispatched")
+
+			if tc.respond {
+				err, _ = natsClient.PublishResult(ctx, time.Now(), "output", nil, nats.ChannelNotify, sequenceId, "pipeline-first")
+				require.NoError(t, err, "Test setup: Result should be published")
+			}
+
+			var completionMsg *jetstream.RawStreamMsg
+			assert.Eventually(t, func() bool {
+				completionMsg, err = natsClient.GetMsg(ctx, nats.ChannelNotify, sequenceId, nats.SequenceMessageId, nats.CompletedMessageId)
+				return err == nil
+			}, 5*time.Second, 50*time.Millisecond, "Sequence should complete")
+			require.NotNil(t, completionMsg)
+
+			completion := nats.CompletionMsg{}
+			err = json.Unmarshal(completionMsg.Data, &completion)
+			require.NoError(t, err, "Sequence completion should be decoded")
+			assert.Equal(t, tc.expectedStatus, completion.Status)
+
+			_, err = natsClient.GetMsg(ctx, nats.ChannelNotify, sequenceId, nats.SequenceMessageId, nats.TimeoutMessageId)
+			assert.Equal(t, tc.expectedStatus == nats.StatusTimeout, err == nil, "Timeout should only be published if the sequence timed out")
+		})
+	}
+}
@@ -0,0 +1,389 @@
+package hops
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/goccy/go-json"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/rs/zerolog"
+
+	"github.com/hiphops-io/hops/logs"
+	"github.com/hiphops-io/hops/nats"
+)
+
+// SequencePayloadLimit is the max size in bytes of each payload included in a
+// sequence's detail, beyond which payloads are truncated
+const SequencePayloadLimit = 32 * 1024
+
+type (
+	SequencesClient interface {
+		GetMsg(ctx context.Context, subjTokens ...string) (*jetstream.RawStreamMsg, error)
+		GetSequenceMessages(ctx context.Context, sequenceId string) ([]*nats.MsgMeta, error)
+		ReplaySourceEvent(ctx context.Context, sequenceId string, data []byte, mode string) (string, error)
+	}
+	sequenceController struct {
+		logger            zerolog.Logger
+		redactor          *logs.Redactor
+		replayMiddlewares []func(http.Handler) http.Handler
+		replayReplays     bool
+		sequencesClient   SequencesClient
+	}
+
+	SequenceRouterOpt func(*sequenceController)
+
+	// ReplayConf configures replaying sequences with POST /sequences/{sequenceId}/replay
+	ReplayConf struct {
+		// AllowReplayOfReplays lets sequences that are themselves replays be replayed
+		AllowReplayOfReplays bool
+	}
+
+	// ReplayRequest is the optional body of a request to replay a sequence
+	ReplayRequest struct {
+		// EvaluateOnly evaluates the replay without dispatching any calls, see nats.ReplayModeEvaluate
+		EvaluateOnly bool `json:"evaluate_only"`
+	}
+
+	// ReplayResponse describes the sequence started by replaying another
+	ReplayResponse struct {
+		Mode       string `json:"mode"`
+		ReplayOf   string `json:"replay_of"`
+		SequenceId string `json:"sequence_id"`
+	}
+
+	// SequenceDetail is the full story of a sequence: its source event, the
+	// calls dispatched and their results, each in the order they were published
+	SequenceDetail struct {
+		SequenceId string           `json:"sequence_id"`
+		Event      *SequenceEvent   `json:"event"`
+		Calls      []SequenceCall   `json:"calls"`
+		Results    []SequenceResult `json:"results"`
+		IsReplay   bool             `json:"is_replay"`
+		Replay     *nats.ReplayMeta `json:"replay,omitempty"`
+	}
+
+	// SequenceEvent is the source event that started a sequence
+	SequenceEvent struct {
+		Action    string          `json:"action"`
+		EventType string          `json:"event_type"`
+		Payload   SequencePayload `json:"payload"`
+		Timestamp time.Time       `json:"timestamp"`
+	}
+
+	// SequenceCall is a call request dispatched in a sequence
+	SequenceCall struct {
+		AppName     string          `json:"app_name"`
+		HandlerName string          `json:"handler_name"`
+		Inputs      SequencePayload `json:"inputs"`
+		Slug        string          `json:"slug"`
+		Timestamp   time.Time       `json:"timestamp"`
+	}
+
+	// SequenceResult is the result of a call in a sequence
+	SequenceResult struct {
+		DurationMs int64           `json:"duration_ms"`
+		Error      string          `json:"error,omitempty"`
+		FinishedAt time.Time       `json:"finished_at"`
+		Data       SequencePayload `json:"data"`
+		Slug       string          `json:"slug"`
+		StartedAt  time.Time       `json:"started_at"`
+		Status     string          `json:"status"`
+		Timestamp  time.Time       `json:"timestamp"`
+	}
+
+	// SequencePayload is a JSON payload of a sequence, with the values of
+	// sensitive keys redacted, truncated to SequencePayloadLimit bytes. Truncated
+	// payloads are no longer valid JSON, so Data holds their first bytes as a
+	// string instead. Payloads that aren't JSON can't be scrubbed, so are redacted
+	// entirely. Size is the size of the payload as published.
+	SequencePayload struct {
+		Data      json.RawMessage `json:"data"`
+		Size      int             `json:"size"`
+		Truncated bool            `json:"truncated"`
+	}
+)
+
+func SequenceRouter(sequencesClient SequencesClient, logger zerolog.Logger, opts ...SequenceRouterOpt) chi.Router {
+	r := chi.NewRouter()
+	controller := &sequenceController{
+		logger:          logger,
+		redactor:        logs.NewRedactor(),
+		sequencesClient: sequencesClient,
+	}
+
+	for _, opt := range opts {
+		opt(controller)
+	}
+
+	r.Get("/{sequenceId}", controller.getSequence)
+	r.With(controller.replayMiddlewares...).Post("/{sequenceId}/replay", controller.replaySequence)
+
+	return r
+}
+
+// WithRedactor sets the redactor scrubbing sensitive values from the payloads of
+// sequences (defaults to logs.DefaultRedactKeys)
+func WithRedactor(redactor *logs.Redactor) SequenceRouterOpt {
+	return func(c *sequenceController) {
+		c.redactor = redactor
+	}
+}
+
+// WithReplayMiddleware applies middlewares to replay requests only, e.g. auth,
+// as replays can dispatch calls again
+func WithReplayMiddleware(middlewares ...func(http.Handler) http.Handler) SequenceRouterOpt {
+	return func(c *sequenceController) {
+		c.replayMiddlewares = append(c.replayMiddlewares, middlewares...)
+	}
+}
+
+// WithReplayOfReplays allows sequences that are themselves replays to be
+// replayed, which are otherwise rejected with 409 Conflict
+func WithReplayOfReplays() SequenceRouterOpt {
+	return func(c *sequenceController) {
+		c.replayReplays = true
+	}
+}
+
+// getSequence returns the detail of a sequence, or 404 if it has no messages
+func (c *sequenceController) getSequence(w http.ResponseWriter, r *http.Request) {
+	sequenceId := chi.URLParam(r, "sequenceId")
+	logger := c.logger.With().Str("sequence_id", sequenceId).Logger()
+
+	// Sequence IDs are part of the subjects filtered on, so mustn't hold wildcards
+	if strings.ContainsAny(sequenceId, ".*> ") {
+		writeError(w, r, http.StatusBadRequest, ErrorCodeBadRequest, "Invalid sequence ID")
+		return
+	}
+
+	msgs, err := c.sequencesClient.GetSequenceMessages(r.Context(), sequenceId)
+	if err != nil {
+		logger.Error().Err(err).Msg("Error getting sequence messages")
+		writeError(w, r, http.StatusInternalServerError, ErrorCodeInternal, "Unable to get sequence")
+		return
+	}
+
+	if len(msgs) == 0 {
+		writeError(w, r, http.StatusNotFound, ErrorCodeNotFound, "Sequence not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sequenceDetail(sequenceId, msgs, c.redactor))
+}
+
+// replaySequence republishes the source event of a sequence under a new replay
+// sequence ID, responding 202 Accepted with a ReplayResponse
+//
+// The body may be a ReplayRequest, to evaluate the replay only. Responds 404 if
+// the sequence has no source event, and 409 if it's a replay itself unless
+// allowed with WithReplayOfReplays.
+func (c *sequenceController) replaySequence(w http.ResponseWriter, r *http.Request) {
+	sequenceId := chi.URLParam(r, "sequenceId")
+	logger := c.logger.With().Str("sequence_id", sequenceId).Logger()
+
+	if strings.ContainsAny(sequenceId, ".*> ") {
+		writeError(w, r, http.StatusBadRequest, ErrorCodeBadRequest, "Invalid sequence ID")
+		return
+	}
+
+	request := ReplayRequest{}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, r, http.StatusBadRequest, ErrorCodeBadRequest, "Unable to parse payload JSON")
+		return
+	}
+
+	sourceMsg, err := c.sequencesClient.GetMsg(r.Context(), nats.ChannelNotify, sequenceId, nats.SourceEventId)
+	if errors.Is(err, jetstream.ErrMsgNotFound) {
+		writeError(w, r, http.StatusNotFound, ErrorCodeNotFound, "No source event found for sequence")
+		return
+	}
+	if err != nil {
+		logger.Error().Err(err).Msg("Error getting source event to replay")
+		writeError(w, r, http.StatusInternalServerError, ErrorCodeInternal, "Unable to get source event")
+		return
+	}
+
+	sourceMeta, _ := nats.ParseSourceMeta(sourceMsg.Data)
+	isReplay := sourceMeta.Replay != nil || strings.HasPrefix(sequenceId, nats.ReplaySequencePrefix)
+	if isReplay && !c.replayReplays {
+		writeError(w, r, http.StatusConflict, ErrorCodeConflict, "Sequence is a replay, replay the original sequence instead")
+		return
+	}
+
+	mode := nats.ReplayModeFull
+	if request.EvaluateOnly {
+		mode = nats.ReplayModeEvaluate
+	}
+
+	// The source event was fetched to check it, so is replayed as is rather than fetched again
+	replaySequenceId, err := c.sequencesClient.ReplaySourceEvent(r.Context(), sequenceId, sourceMsg.Data, mode)
+	if err != nil {
+		logger.Error().Err(err).Msg("Error replaying sequence")
+		writeError(w, r, http.StatusInternalServerError, ErrorCodeInternal, "Unable to replay sequence")
+		return
+	}
+
+	logger.Info().Str("replay_sequence_id", replaySequenceId).Str("mode", mode).Msg("Replaying sequence")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(ReplayResponse{
+		Mode:       mode,
+		ReplayOf:   sequenceId,
+		SequenceId: replaySequenceId,
+	})
+}
+
+// sequenceDetail projects the messages of a sequence, in stream order, into its
+// detail, with their payloads redacted by redactor
+func sequenceDetail(sequenceId string, msgs []*nats.MsgMeta, redactor *logs.Redactor) SequenceDetail {
+	detail := SequenceDetail{
+		SequenceId: sequenceId,
+		Calls:      []SequenceCall{},
+		Results:    []SequenceResult{},
+	}
+
+	for _, m := range msgs {
+		data := m.Msg().Data()
+
+		switch {
+		case m.Channel == nats.ChannelRequest:
+			detail.Calls = append(detail.Calls, SequenceCall{
+				AppName:     m.AppName,
+				HandlerName: m.HandlerName,
+				Inputs:      newSequencePayload(data, redactor),
+				Slug:        m.MessageId,
+				Timestamp:   m.Timestamp,
+			})
+		case m.Done:
+			detail.Results = append(detail.Results, sequenceResult(m, data, redactor))
+		case m.MessageId == nats.SourceEventId:
+			detail.Event = &SequenceEvent{
+				Payload:   newSequencePayload(data, redactor),
+				Timestamp: m.Timestamp,
+			}
+
+			sourceMeta, err := nats.ParseSourceMeta(data)
+			if err != nil {
+				continue
+			}
+			detail.Event.EventType = sourceMeta.Event
+			detail.Event.Action = sourceMeta.Action
+			detail.Replay = sourceMeta.Replay
+		}
+	}
+
+	// Replayed events that couldn't be marked are replayed in full
+	if detail.Replay == nil && strings.HasPrefix(sequenceId, nats.ReplaySequencePrefix) {
+		detail.Replay = &nats.ReplayMeta{Mode: nats.ReplayModeFull}
+	}
+	detail.IsReplay = detail.Replay != nil
+
+	return detail
+}
+
+func sequenceResult(m *nats.MsgMeta, data []byte, redactor *logs.Redactor) SequenceResult {
+	result := SequenceResult{
+		Slug:      m.MessageId,
+		Status:    nats.StatusSuccess,
+		Timestamp: m.Timestamp,
+	}
+
+	resultMsg, err := nats.ParseResultMsg(data)
+	if err != nil {
+		result.Data = newSequencePayload(data, redactor)
+		return result
+	}
+
+	if resultMsg.Errored {
+		result.Status = nats.StatusFailure
+	}
+	result.Error = resultMsg.Hops.Error
+	result.StartedAt = resultMsg.Hops.StartedAt
+	result.FinishedAt = resultMsg.Hops.FinishedAt
+	if !result.StartedAt.IsZero() && !result.FinishedAt.IsZero() {
+		result.DurationMs = result.FinishedAt.Sub(result.StartedAt).Milliseconds()
+	}
+
+	// Structured output is preferred, as it's what hops configs reference
+	var output any = resultMsg.Body
+	if resultMsg.JSON != nil {
+		output = resultMsg.JSON
+	}
+	outputData, err := json.Marshal(output)
+	if err != nil {
+		outputData = data
+	}
+	result.Data = newSequencePayload(outputData, redactor)
+
+	return result
+}
+
+// newSequencePayload creates a SequencePayload from data redacted by redactor,
+// truncating it if it's larger than SequencePayloadLimit
+func newSequencePayload(data []byte, redactor *logs.Redactor) SequencePayload {
+	payload := SequencePayload{Size: len(data)}
+
+	if len(data) == 0 {
+		payload.Data = json.RawMessage("null")
+		return payload
+	}
+
+	data = redactor.RedactJSON(data)
+	payload.Data = json.RawMessage(data)
+
+	if len(data) <= SequencePayloadLimit && json.Valid(data) {
+		return payload
+	}
+
+	// Anything that isn't valid JSON as it is is included as a string
+	if len(data) > SequencePayloadLimit {
+		data = data[:SequencePayloadLimit]
+		payload.Truncated = true
+	}
+	payload.Data, _ = json.Marshal(strings.ToValidUTF8(string(data), ""))
+
+	return payload
+}
+
+// sequenceOpenAPIPaths describes the routes of SequenceRouter, served under /sequences
+func sequenceOpenAPIPaths() openAPIPaths {
+	sequenceId := pathParam("sequenceId", "The ID of the sequence")
+
+	return openAPIPaths{
+		"/sequences/{sequenceId}": {
+			"get": {
+				OperationID: "getSequence",
+				Summary:     "Get the detail of a sequence: its source event, calls and their results",
+				Tags:        []string{"sequences"},
+				Parameters:  []openAPIParameter{sequenceId},
+				Responses: responses(
+					map[int]openAPIResponse{http.StatusOK: jsonResponse("The sequence's detail", SequenceDetail{})},
+					http.StatusBadRequest, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError,
+				),
+				protected: true,
+			},
+		},
+		"/sequences/{sequenceId}/replay": {
+			"post": {
+				OperationID: "replaySequence",
+				Summary:     "Replay the source event of a sequence in a new sequence",
+				Tags:        []string{"sequences"},
+				Parameters:  []openAPIParameter{sequenceId},
+				RequestBody: &openAPIRequestBody{Content: jsonContent(schemaOf(ReplayRequest{}))},
+				Responses: responses(
+					map[int]openAPIResponse{http.StatusAccepted: jsonResponse("The replay's event is published, starting a sequence", ReplayResponse{})},
+					http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusTooManyRequests, http.StatusInternalServerError,
+				),
+				protected: true,
+			},
+		},
+	}
+}
@@ -0,0 +1,272 @@
+package hops
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hiphops-io/hops/logs"
+	"github.com/hiphops-io/hops/nats"
+)
+
+func TestSequenceRouterGetSequence(t *testing.T) {
+	ctx := context.Background()
+	natsClient, _ := setupRunnerClient(t)
+	router := SequenceRouter(natsClient, logs.NoOpLogger())
+
+	getSequence := func(t *testing.T, sequenceId string) (*httptest.ResponseRecorder, map[string]any) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+sequenceId, nil))
+
+		detail := map[string]any{}
+		if rec.Code == http.StatusOK {
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			err := json.Unmarshal(rec.Body.Bytes(), &detail)
+			require.NoError(t, err, "Response should be valid JSON")
+		}
+
+		return rec, detail
+	}
+
+	t.Run("Describes the sequence in stream order", func(t *testing.T) {
+		sourceEvent, sequenceId, err := nats.CreateSourceEvent(map[string]any{"ref": "main"}, "test", "github", "push", "")
+		require.NoError(t, err, "Test setup: Should create source event")
+		_, _, err = natsClient.Publish(ctx, sourceEvent, nats.ChannelNotify, sequenceId, nats.SourceEventId)
+		require.NoError(t, err, "Test setup: Should publish source event")
+
+		for _, slug := range []string{"build", "deploy"} {
+			_, _, err = natsClient.PublishCall(ctx, []byte(`{"ref":"main"}`), nats.CallMeta{}, nats.ChannelRequest, sequenceId, slug, "ci", "run")
+			require.NoError(t, err, "Test setup: Should publish call")
+		}
+
+		startedAt := time.Now().Add(-time.Second)
+		err, _ = natsClient.PublishResult(ctx, startedAt, map[string]any{"artifact": "app.tar"}, nil, nats.ChannelNotify, sequenceId, "build", nats.DoneMessageId)
+		require.NoError(t, err, "Test setup: Should publish result")
+		err, _ = natsClient.PublishResult(ctx, startedAt, nil, errors.New("Deploy failed"), nats.ChannelNotify, sequenceId, "deploy", nats.DoneMessageId)
+		require.NoError(t, err, "Test setup: Should publish result")
+
+		rec, detail := getSequence(t, sequenceId)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		assert.Equal(t, sequenceId, detail["sequence_id"])
+		assert.Equal(t, false, detail["is_replay"])
+		assert.NotContains(t, detail, "replay")
+
+		event := detail["event"].(map[string]any)
+		assert.Equal(t, "github", event["event_type"])
+		assert.Equal(t, "push", event["action"])
+		payload := event["payload"].(map[string]any)
+		assert.Equal(t, "main", payload["data"].(map[string]any)["ref"])
+		assert.Equal(t, false, payload["truncated"])
+		assert.Equal(t, float64(len(sourceEvent)), payload["size"])
+
+		calls := detail["calls"].([]any)
+		require.Len(t, calls, 2)
+		for i, slug := range []string{"build", "deploy"} {
+			call := calls[i].(map[string]any)
+			assert.Equal(t, slug, call["slug"])
+			assert.Equal(t, "ci", call["app_name"])
+			assert.Equal(t, "run", call["handler_name"])
+			assert.Equal(t, map[string]any{"ref": "main"}, call["inputs"].(map[string]any)["data"])
+		}
+
+		results := detail["results"].([]any)
+		require.Len(t, results, 2)
+
+		build := results[0].(map[string]any)
+		assert.Equal(t, "build", build["slug"])
+		assert.Equal(t, nats.StatusSuccess, build["status"])
+		assert.Equal(t, map[string]any{"artifact": "app.tar"}, build["data"].(map[string]any)["data"])
+		assert.GreaterOrEqual(t, build["duration_ms"], float64(1000))
+		assert.NotEmpty(t, build["started_at"])
+		assert.NotEmpty(t, build["finished_at"])
+
+		deploy := results[1].(map[string]any)
+		assert.Equal(t, "deploy", deploy["slug"])
+		assert.Equal(t, nats.StatusFailure, deploy["status"])
+		assert.Equal(t, "Deploy failed", deploy["error"])
+	})
+
+	t.Run("Truncates large payloads", func(t *testing.T) {
+		large := strings.Repeat("x", SequencePayloadLimit*2)
+		sourceEvent, sequenceId, err := nats.CreateSourceEvent(map[string]any{"large": large}, "test", "github", "push", "")
+		require.NoError(t, err, "Test setup: Should create source event")
+		_, _, err = natsClient.Publish(ctx, sourceEvent, nats.ChannelNotify, sequenceId, nats.SourceEventId)
+		require.NoError(t, err, "Test setup: Should publish source event")
+
+		rec, detail := getSequence(t, sequenceId)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		payload := detail["event"].(map[string]any)["payload"].(map[string]any)
+		assert.Equal(t, true, payload["truncated"])
+		assert.Equal(t, float64(len(sourceEvent)), payload["size"])
+		data, ok := payload["data"].(string)
+		require.True(t, ok, "Truncated payloads should be strings")
+		assert.Len(t, data, SequencePayloadLimit)
+	})
+
+	t.Run("Marks replays", func(t *testing.T) {
+		sourceEvent, _, err := nats.CreateSourceEventWithMeta(map[string]any{}, nats.SourceMeta{
+			Source: "test",
+			Event:  "github",
+			Action: "push",
+			Replay: &nats.ReplayMeta{Mode: nats.ReplayModeEvaluate, SequenceId: "original"},
+		})
+		require.NoError(t, err, "Test setup: Should create source event")
+		sequenceId := nats.ReplaySequencePrefix + "marked"
+		_, _, err = natsClient.Publish(ctx, sourceEvent, nats.ChannelNotify, sequenceId, nats.SourceEventId)
+		require.NoError(t, err, "Test setup: Should publish source event")
+
+		rec, detail := getSequence(t, sequenceId)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, true, detail["is_replay"])
+		assert.Equal(t, map[string]any{"mode": nats.ReplayModeEvaluate, "sequence_id": "original"}, detail["replay"])
+	})
+
+	t.Run("Redacts sensitive values", func(t *testing.T) {
+		sourceEvent, sequenceId, err := nats.CreateSourceEvent(map[string]any{"ref": "main", "github_token": "secret"}, "test", "github", "push", "")
+		require.NoError(t, err, "Test setup: Should create source event")
+		_, _, err = natsClient.Publish(ctx, sourceEvent, nats.ChannelNotify, sequenceId, nats.SourceEventId)
+		require.NoError(t, err, "Test setup: Should publish source event")
+		_, _, err = natsClient.PublishCall(ctx, []byte(`{"password":"secret"}`), nats.CallMeta{}, nats.ChannelRequest, sequenceId, "login", "ci", "run")
+		require.NoError(t, err, "Test setup: Should publish call")
+
+		rec, detail := getSequence(t, sequenceId)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.NotContains(t, rec.Body.String(), "secret")
+
+		payload := detail["event"].(map[string]any)["payload"].(map[string]any)
+		assert.Equal(t, "main", payload["data"].(map[string]any)["ref"])
+		assert.Equal(t, logs.RedactedValue, payload["data"].(map[string]any)["github_token"])
+
+		inputs := detail["calls"].([]any)[0].(map[string]any)["inputs"].(map[string]any)
+		assert.Equal(t, map[string]any{"password": logs.RedactedValue}, inputs["data"])
+	})
+
+	t.Run("Not found", func(t *testing.T) {
+		rec, _ := getSequence(t, "missing")
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("Invalid sequence ID", func(t *testing.T) {
+		for _, sequenceId := range []string{"*", ">", "a.b"} {
+			rec, _ := getSequence(t, sequenceId)
+			assert.Equal(t, http.StatusBadRequest, rec.Code, "Sequence ID '%s' should be rejected", sequenceId)
+		}
+	})
+}
+
+// countingSequencesClient counts the messages fetched through a SequencesClient
+type countingSequencesClient struct {
+	SequencesClient
+	getMsgs int
+}
+
+func (c *countingSequencesClient) GetMsg(ctx context.Context, subjTokens ...string) (*jetstream.RawStreamMsg, error) {
+	c.getMsgs++
+	return c.SequencesClient.GetMsg(ctx, subjTokens...)
+}
+
+func TestSequenceRouterReplay(t *testing.T) {
+	ctx := context.Background()
+	natsClient, _ := setupRunnerClient(t)
+
+	sourceEvent, sequenceId, err := nats.CreateSourceEvent(map[string]any{"ref": "main"}, "test", "github", "push", "")
+	require.NoError(t, err, "Test setup: Should create source event")
+	_, _, err = natsClient.Publish(ctx, sourceEvent, nats.ChannelNotify, sequenceId, nats.SourceEventId)
+	require.NoError(t, err, "Test setup: Should publish source event")
+
+	replay := func(t *testing.T, router http.Handler, sequenceId string, body string) (*httptest.ResponseRecorder, ReplayResponse) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/"+sequenceId+"/replay", strings.NewReader(body)))
+
+		response := ReplayResponse{}
+		if rec.Code == http.StatusAccepted {
+			err := json.Unmarshal(rec.Body.Bytes(), &response)
+			require.NoError(t, err, "Response should be valid JSON")
+		}
+
+		return rec, response
+	}
+
+	replayedMeta := func(t *testing.T, replaySequenceId string) nats.SourceMeta {
+		msg, err := natsClient.GetMsg(ctx, nats.ChannelNotify, replaySequenceId, nats.SourceEventId)
+		require.NoError(t, err, "Source event should be republished")
+		assert.Equal(t, nats.SourceEventSubject(natsClient.AccountId(), natsClient.InterestTopic(), replaySequenceId), msg.Subject)
+
+		meta, err := nats.ParseSourceMeta(msg.Data)
+		require.NoError(t, err, "Republished source event should be valid JSON")
+		return meta
+	}
+
+	router := SequenceRouter(natsClient, logs.NoOpLogger())
+
+	t.Run("Replays the source event", func(t *testing.T) {
+		rec, response := replay(t, router, sequenceId, "")
+		require.Equal(t, http.StatusAccepted, rec.Code)
+		assert.Equal(t, sequenceId, response.ReplayOf)
+		assert.Equal(t, nats.ReplayModeFull, response.Mode)
+		assert.True(t, strings.HasPrefix(response.SequenceId, nats.ReplaySequencePrefix))
+
+		meta := replayedMeta(t, response.SequenceId)
+		assert.Equal(t, "github", meta.Event)
+		assert.Equal(t, &nats.ReplayMeta{Mode: nats.ReplayModeFull, SequenceId: sequenceId}, meta.Replay)
+	})
+
+	t.Run("Evaluates only", func(t *testing.T) {
+		rec, response := replay(t, router, sequenceId, `{"evaluate_only": true}`)
+		require.Equal(t, http.StatusAccepted, rec.Code)
+		assert.Equal(t, nats.ReplayModeEvaluate, response.Mode)
+		assert.True(t, replayedMeta(t, response.SequenceId).EvaluateOnly())
+	})
+
+	t.Run("Replays of replays", func(t *testing.T) {
+		_, response := replay(t, router, sequenceId, "")
+		require.NotEmpty(t, response.SequenceId, "Test setup: Should replay sequence")
+
+		rec, _ := replay(t, router, response.SequenceId, "")
+		assert.Equal(t, http.StatusConflict, rec.Code, "Replays should not be replayed by default")
+
+		allowing := SequenceRouter(natsClient, logs.NoOpLogger(), WithReplayOfReplays())
+		rec, replayed := replay(t, allowing, response.SequenceId, "")
+		require.Equal(t, http.StatusAccepted, rec.Code, "Replays should be replayed if allowed")
+		assert.Equal(t, response.SequenceId, replayedMeta(t, replayed.SequenceId).Replay.SequenceId)
+	})
+
+	t.Run("Fetches the source event once", func(t *testing.T) {
+		counting := &countingSequencesClient{SequencesClient: natsClient}
+		rec, _ := replay(t, SequenceRouter(counting, logs.NoOpLogger()), sequenceId, "")
+		require.Equal(t, http.StatusAccepted, rec.Code)
+		assert.Equal(t, 1, counting.getMsgs, "The checked source event should be the one replayed")
+	})
+
+	t.Run("Protected", func(t *testing.T) {
+		protected := SequenceRouter(natsClient, logs.NoOpLogger(), WithReplayMiddleware(Auth(NewBearerTokenValidator("secret"))))
+		rec, _ := replay(t, protected, sequenceId, "")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+		rec = httptest.NewRecorder()
+		protected.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+sequenceId, nil))
+		assert.Equal(t, http.StatusOK, rec.Code, "Only replays should be protected")
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		rec, _ := replay(t, router, "missing", "")
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+
+		rec, _ = replay(t, router, sequenceId, "{")
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		rec, _ = replay(t, router, "*", "")
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
@@ -0,0 +1,127 @@
+package hops
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/hiphops-io/hops/nats"
+)
+
+var _ SequenceStateStore = (*nats.KVSequenceStateStore)(nil)
+
+type (
+	// SequenceStateStore persists what the runner knows about each sequence, so
+	// it can be consulted before re-evaluating the sequence, even by another runner
+	//
+	// State is advisory. If it can't be read or written the runner falls back to
+	// the message bundle alone, which remains the source of truth.
+	SequenceStateStore interface {
+		// Get returns the state of a sequence, or nil if it has none
+		Get(ctx context.Context, sequenceId string) (*nats.SequenceState, error)
+		// Put stores the state of a sequence, replacing any previous state
+		Put(ctx context.Context, state *nats.SequenceState) error
+	}
+
+	// trackedSequenceState records changes to a sequence's state whilst it's being
+	// evaluated, which happens concurrently across its on blocks
+	//
+	// A nil trackedSequenceState tracks nothing, so callers needn't check whether
+	// state is enabled.
+	trackedSequenceState struct {
+		mu    sync.Mutex
+		state *nats.SequenceState
+	}
+)
+
+// isComplete returns whether the sequence is recorded as complete
+func (t *trackedSequenceState) isComplete() bool {
+	if t == nil {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.state.Complete
+}
+
+// isDispatched returns whether a call is recorded as dispatched
+func (t *trackedSequenceState) isDispatched(callSlug string) bool {
+	if t == nil {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.state.IsDispatched(callSlug)
+}
+
+// markComplete records that the sequence is complete
+func (t *trackedSequenceState) markComplete() {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.state.Complete = true
+}
+
+// markDispatched records that a call has been dispatched
+func (t *trackedSequenceState) markDispatched(callSlug string) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.state.Dispatched[callSlug] = time.Now().UTC()
+}
+
+// loadSequenceState returns the tracked state of a sequence, or nil if the runner
+// has no state store
+//
+// Dry runs neither read nor write state, as they mustn't affect live runners.
+// State that can't be read is started afresh.
+func (r *Runner) loadSequenceState(ctx context.Context, sequenceId string, logger zerolog.Logger) *trackedSequenceState {
+	if r.sequenceState == nil || r.dryRun {
+		return nil
+	}
+
+	state, err := r.sequenceState.Get(ctx, sequenceId)
+	if err != nil {
+		logger.Warn().Err(err).Msg("Unable to read sequence state, evaluating from message bundle alone")
+	}
+	if state == nil {
+		state = nats.NewSequenceState(sequenceId)
+	}
+	if state.Dispatched == nil {
+		state.Dispatched = map[string]time.Time{}
+	}
+
+	return &trackedSequenceState{state: state}
+}
+
+// saveSequenceState stores the tracked state of a sequence along with the time
+// it was evaluated. Failures are logged rather than returned, as state is advisory.
+func (r *Runner) saveSequenceState(ctx context.Context, tracked *trackedSequenceState, logger zerolog.Logger) {
+	if tracked == nil {
+		return
+	}
+
+	tracked.mu.Lock()
+	defer tracked.mu.Unlock()
+
+	tracked.state.EvaluatedAt = time.Now().UTC()
+
+	err := r.sequenceState.Put(ctx, tracked.state)
+	if err != nil {
+		logger.Warn().Err(err).Msg("Unable to store sequence state")
+	}
+}
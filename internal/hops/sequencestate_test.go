@@ -0,0 +1,91 @@
+package hops
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hiphops-io/hops/logs"
+	"github.com/hiphops-io/hops/nats"
+)
+
+func TestRunnerSequenceState(t *testing.T) {
+	ctx := context.Background()
+	logger := logs.NoOpLogger()
+	natsClient, _ := setupRunnerClient(t)
+
+	hopsDir := t.TempDir()
+	content, err := os.ReadFile("./testdata/simple.hops")
+	require.NoError(t, err, "Test setup: Should read hops file")
+	err = os.WriteFile(testHopsPath(t, hopsDir), content, 0o644)
+	require.NoError(t, err, "Test setup: Should write hops file")
+
+	hopsLoader, err := NewHopsFileLoader(hopsDir, false)
+	require.NoError(t, err, "Test setup: Hops files should load without error")
+
+	store, err := nats.NewKVSequenceStateStore(natsClient, time.Hour)
+	require.NoError(t, err, "Test setup: Sequence state store should initialise without error")
+
+	// The dispatcher doesn't publish requests, so bundles never show calls as
+	// dispatched and only the sequence state can prevent duplicates
+	dispatcher := &countingDispatcher{}
+	newRunner := func() *Runner {
+		runner, err := NewRunner(natsClient, hopsLoader, logger, WithDispatcher(dispatcher), WithSequenceState(store))
+		require.NoError(t, err, "Test setup: Runner should initialise without error")
+		return runner
+	}
+
+	eventData, err := os.ReadFile("./testdata/source_testevent.json")
+	require.NoError(t, err, "Test setup: Should read source event")
+	eventBundle := nats.MessageBundle{"event": eventData}
+
+	err = newRunner().SequenceCallback(ctx, "SEQ_ID", eventBundle)
+	require.NoError(t, err, "Sequence should be processed without error")
+	require.Equal(t, int32(1), dispatcher.dispatched.Load(), "Call should be dispatched")
+
+	state, err := store.Get(ctx, "SEQ_ID")
+	require.NoError(t, err)
+	require.NotNil(t, state, "Sequence state should be recorded")
+	assert.True(t, state.IsDispatched("simple_pipeline-should_dispatch"), "Dispatched call should be recorded")
+	assert.False(t, state.EvaluatedAt.IsZero(), "Evaluation time should be recorded")
+	assert.False(t, state.Complete)
+
+	// A restarted runner re-evaluating the same bundle must not dispatch again
+	err = newRunner().SequenceCallback(ctx, "SEQ_ID", eventBundle)
+	require.NoError(t, err, "Sequence should be processed without error")
+	assert.Equal(t, int32(1), dispatcher.dispatched.Load(), "Restarted runner should not dispatch the call again")
+
+	resultB, err := json.Marshal(nats.NewResultMsg(time.Now(), "output", nil))
+	require.NoError(t, err, "Test setup: Result should be encoded")
+
+	completeBundle := nats.MessageBundle{
+		"event":                           eventData,
+		"simple_pipeline-should_dispatch": resultB,
+	}
+	err = newRunner().SequenceCallback(ctx, "SEQ_ID", completeBundle)
+	require.NoError(t, err, "Sequence should be completed without error")
+
+	state, err = store.Get(ctx, "SEQ_ID")
+	require.NoError(t, err)
+	require.NotNil(t, state)
+	assert.True(t, state.Complete, "Completion should be recorded")
+
+	err = newRunner().SequenceCallback(ctx, "SEQ_ID", eventBundle)
+	require.NoError(t, err, "Completed sequence should be skipped without error")
+	assert.Equal(t, int32(1), dispatcher.dispatched.Load(), "Completed sequence should not be evaluated again")
+
+	// Losing the state degrades to evaluating from the bundle alone
+	js, err := natsClient.NatsConn.JetStream()
+	require.NoError(t, err)
+	err = js.DeleteKeyValue(nats.SequenceStateBucket)
+	require.NoError(t, err, "Test setup: Sequence state bucket should be deleted")
+
+	err = newRunner().SequenceCallback(ctx, "SEQ_ID", eventBundle)
+	require.NoError(t, err, "Sequence should be processed without its state")
+	assert.Equal(t, int32(2), dispatcher.dispatched.Load(), "Call should be dispatched from the bundle alone")
+}
@@ -3,12 +3,17 @@ package hops
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/oklog/run"
 	"github.com/rs/zerolog"
 	"github.com/slok/reload"
+	"github.com/zclconf/go-cty/cty"
 
+	"github.com/hiphops-io/hops/dsl"
 	"github.com/hiphops-io/hops/internal/httpapp"
 	"github.com/hiphops-io/hops/internal/k8sapp"
 	"github.com/hiphops-io/hops/logs"
@@ -18,18 +23,42 @@ import (
 
 type (
 	HTTPServerConf struct {
-		Address string
-		Serve   bool
+		// AccessLog sets which requests are left out of the access log, and which are slow
+		AccessLog AccessLogConf
+		Address   string
+		// AuthTokens are the bearer tokens accepted by the tasks API, any of which
+		// may be used (empty leaves it unauthenticated)
+		AuthTokens []string
+		// BasePath is the path prefix every route is served under (e.g. "/hops")
+		BasePath string
+		// Console sets where the console's UI assets are served from (default: embedded)
+		Console   ConsoleConf
+		CORS      CORSConf
+		RateLimit RateLimitConf
+		// Replay configures replaying sequences through the API
+		Replay ReplayConf
+		Serve  bool
+		// ShutdownTimeout is how long in-flight requests are given to complete on shutdown
+		ShutdownTimeout time.Duration
+		// TLS serves over TLS if a cert file is given
+		TLS TLSConf
 	}
 
 	HopsServer struct {
-		HopsPath      string
-		KeyFilePath   string
-		Logger        zerolog.Logger
-		ReplayEvent   string
-		Watch         bool
-		reloadManager reload.Manager
-		runGroup      run.Group
+		HopsPath     string
+		KeyFilePath  string
+		Logger       zerolog.Logger
+		ReplayEvent  string
+		ReplayFull   bool
+		ReplayMode   string
+		ReplayTiming bool
+		// StreamName is the JetStream stream used, if not named after the account ID
+		StreamName       string
+		Watch            bool
+		WebhookFunctions bool
+		reloadManager    reload.Manager
+		runGroup         run.Group
+		runnerMetrics    *CounterMetrics
 
 		HTTPServerConf
 		HTTPAppConf
@@ -48,8 +77,31 @@ type (
 	}
 
 	RunnerConf struct {
-		Serve bool
-		Local bool
+		// BundleCacheSize is the bytes of messages cached for recent sequences (0 disables), see nats.WithBundleCache
+		BundleCacheSize int
+		// Concurrency is the number of sequences processed at once (0 processes one at a time)
+		Concurrency     int
+		DispatchTimeout time.Duration
+		DryRun          bool
+		// DryRunShadowSubject is where dry runs publish records of the calls they would dispatch (empty only logs them)
+		DryRunShadowSubject string
+		// FailFast stops evaluating a sequence's on blocks once one fails, see WithFailFast
+		FailFast bool
+		// Globals are NAME=VALUE pairs available to hops configs as `global.NAME`
+		Globals []string
+		Serve   bool
+		Local   bool
+		// LogInputs logs the inputs of dispatched calls at debug level, redacted and
+		// truncated to LogInputsLimit bytes (0 uses DefaultInputLogLimit)
+		LogInputs      bool
+		LogInputsLimit int
+		// MaxEvaluations is the number of sequences evaluated at once (0 uses Concurrency)
+		MaxEvaluations int
+		RedactKeys     []string
+		// SequenceState records each sequence's state in KV, consulted before re-evaluating it
+		SequenceState bool
+		// SequenceTimeout is how long on blocks may run for unless they set their own timeout (0 is no timeout)
+		SequenceTimeout time.Duration
 	}
 )
 
@@ -65,6 +117,14 @@ func (h *HopsServer) Start(ctx context.Context) error {
 		h.reloadManager = reload.NewManager()
 	}
 
+	if h.WebhookFunctions {
+		err := dsl.RegisterFunctions(dsl.WebhookFunctions)
+		if err != nil {
+			h.Logger.Error().Err(err).Msg("Failed to register webhook functions")
+			return err
+		}
+	}
+
 	natsClient, err := h.startNATSClient()
 	if natsClient != nil {
 		defer natsClient.Close()
@@ -85,7 +145,7 @@ func (h *HopsServer) Start(ctx context.Context) error {
 		return err
 	}
 
-	err = h.startHTTPServer(hopsLoader, natsClient)
+	err = h.startHTTPServer(ctx, hopsLoader, natsClient)
 	if err != nil {
 		return err
 	}
@@ -123,7 +183,10 @@ func (h *HopsServer) startHTTPApp(ctx context.Context, natsClient *nats.Client)
 		}
 
 		zlogger := logs.NewNatsZeroLogger(logger)
-		worker := worker.NewWorker(natsClient, httpApp, &zlogger)
+		worker, err := worker.NewWorker(natsClient, httpApp, &zlogger)
+		if err != nil {
+			return err
+		}
 
 		// Blocks until complete or errored
 		return worker.Run(ctx)
@@ -141,12 +204,49 @@ func (h *HopsServer) startHTTPApp(ctx context.Context, natsClient *nats.Client)
 	return nil
 }
 
-func (h *HopsServer) startHTTPServer(hopsLoader *HopsFileLoader, natsClient *nats.Client) error {
+func (h *HopsServer) startHTTPServer(ctx context.Context, hopsLoader *HopsFileLoader, natsClient *nats.Client) error {
 	if !h.HTTPServerConf.Serve {
 		return nil
 	}
 
-	httpServer, err := NewHTTPServer(h.Address, hopsLoader, h.Watch, natsClient, h.Logger)
+	httpServerOpts := []HTTPServerOpt{WithAccessLog(h.HTTPServerConf.AccessLog), WithCORS(h.HTTPServerConf.CORS)}
+	if len(h.HTTPServerConf.AuthTokens) > 0 {
+		httpServerOpts = append(httpServerOpts, WithAuth(NewBearerTokenValidator(h.HTTPServerConf.AuthTokens...)))
+	}
+	if h.HTTPServerConf.BasePath != "" {
+		httpServerOpts = append(httpServerOpts, WithBasePath(h.HTTPServerConf.BasePath))
+	}
+	if h.HTTPServerConf.Console != (ConsoleConf{}) {
+		httpServerOpts = append(httpServerOpts, WithConsole(h.HTTPServerConf.Console))
+	}
+	if h.HTTPServerConf.CORS.AllowAll {
+		h.Logger.Warn().Msg("CORS allows requests from any origin, this should only be used for local development")
+	}
+	if h.HTTPServerConf.RateLimit.Rate > 0 {
+		store := NewMemoryRateLimitStore(h.HTTPServerConf.RateLimit.Rate, h.HTTPServerConf.RateLimit.Burst)
+		httpServerOpts = append(httpServerOpts, WithRateLimit(store, nil))
+	}
+	if h.HTTPServerConf.RateLimit.GlobalRate > 0 {
+		store := NewMemoryRateLimitStore(h.HTTPServerConf.RateLimit.GlobalRate, h.HTTPServerConf.RateLimit.GlobalBurst)
+		httpServerOpts = append(httpServerOpts, WithGlobalRateLimit(store))
+	}
+	if len(h.RunnerConf.RedactKeys) > 0 {
+		httpServerOpts = append(httpServerOpts, WithRedactKeys(h.RunnerConf.RedactKeys...))
+	}
+	if h.HTTPServerConf.Replay != (ReplayConf{}) {
+		httpServerOpts = append(httpServerOpts, WithReplays(h.HTTPServerConf.Replay))
+	}
+	if h.runnerMetrics != nil {
+		httpServerOpts = append(httpServerOpts, WithRunnerMetrics(h.runnerMetrics))
+	}
+	if h.HTTPServerConf.ShutdownTimeout > 0 {
+		httpServerOpts = append(httpServerOpts, WithShutdownTimeout(h.HTTPServerConf.ShutdownTimeout))
+	}
+	if h.HTTPServerConf.TLS.CertFile != "" || h.HTTPServerConf.TLS.KeyFile != "" {
+		httpServerOpts = append(httpServerOpts, WithTLS(h.HTTPServerConf.TLS))
+	}
+
+	httpServer, err := NewHTTPServer(h.Address, hopsLoader, h.Watch, natsClient, h.Logger, httpServerOpts...)
 	if err != nil {
 		return err
 	}
@@ -164,18 +264,19 @@ func (h *HopsServer) startHTTPServer(hopsLoader *HopsFileLoader, natsClient *nat
 		}))
 	}
 
+	ctx, cancel := context.WithCancel(ctx)
 	h.runGroup.Add(
 		func() error {
-			return httpServer.Serve()
-		},
-		func(_ error) {
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
-
-			err := httpServer.Shutdown(ctx)
+			// Blocks until cancelled, then lets in-flight requests complete
+			err := httpServer.Serve(ctx)
 			if err != nil {
-				h.Logger.Error().Err(err).Msg("Unable to shut down http server")
+				h.Logger.Error().Err(err).Msg("Unable to shut down http server cleanly")
 			}
+
+			return err
+		},
+		func(_ error) {
+			cancel()
 		},
 	)
 
@@ -205,7 +306,10 @@ func (h *HopsServer) startK8sApp(ctx context.Context, natsClient *nats.Client) e
 		}
 
 		zlogger := logs.NewNatsZeroLogger(logger)
-		worker := worker.NewWorker(natsClient, k8s, &zlogger)
+		worker, err := worker.NewWorker(natsClient, k8s, &zlogger)
+		if err != nil {
+			return err
+		}
 
 		// Blocks until complete or errored
 		return worker.Run(ctx)
@@ -233,16 +337,39 @@ func (h *HopsServer) startNATSClient() (*nats.Client, error) {
 	}
 
 	clientOpts := []nats.ClientOpt{}
-	if h.ReplayEvent != "" {
+	// The stream is named first, as the opts that follow create consumers on it
+	if h.StreamName != "" {
+		clientOpts = append(clientOpts, nats.WithStreamName(h.StreamName))
+	}
+
+	if h.ReplayEvent != "" && h.ReplayMode != "" {
+		clientOpts = append(clientOpts, nats.WithReplayMode(h.ReplayMode))
+	}
+
+	if h.ReplayEvent != "" && h.ReplayFull {
+		clientOpts = append(clientOpts, nats.WithSequenceReplay(nats.DefaultConsumerName, h.ReplayEvent, h.ReplayTiming))
+		h.Logger.Info().Str("mode", h.ReplayMode).Msgf("Replaying sequence: %s", h.ReplayEvent)
+	} else if h.ReplayEvent != "" {
 		clientOpts = append(clientOpts, nats.WithReplay(nats.DefaultConsumerName, h.ReplayEvent))
-		h.Logger.Info().Msgf("Replaying source event: %s", h.ReplayEvent)
+		h.Logger.Info().Str("mode", h.ReplayMode).Msgf("Replaying source event: %s", h.ReplayEvent)
 	} else if h.RunnerConf.Local && h.RunnerConf.Serve {
 		clientOpts = append(clientOpts, nats.WithLocalRunner(nats.DefaultConsumerName))
 		h.Logger.Info().Msgf("Running in local mode")
+	} else if h.RunnerConf.DryRun && h.RunnerConf.Serve {
+		// Dry runs consume with their own consumer, so live runners still get every message
+		clientOpts = append(clientOpts, nats.WithDryRunner(nats.DefaultConsumerName))
 	} else if h.RunnerConf.Serve {
 		clientOpts = append(clientOpts, nats.WithRunner(nats.DefaultConsumerName))
 	}
 
+	if h.RunnerConf.Serve && h.RunnerConf.BundleCacheSize > 0 {
+		clientOpts = append(clientOpts, nats.WithBundleCache(h.RunnerConf.BundleCacheSize, nats.DefaultBundleCacheTTL))
+	}
+
+	if h.RunnerConf.Serve && h.RunnerConf.Concurrency > 0 {
+		clientOpts = append(clientOpts, nats.WithSequenceConcurrency(h.RunnerConf.Concurrency))
+	}
+
 	if h.HTTPAppConf.Serve {
 		clientOpts = append(clientOpts, nats.WithWorker("http"))
 	}
@@ -329,11 +456,62 @@ func (h *HopsServer) startRunner(ctx context.Context, hopsLoader *HopsFileLoader
 		return nil
 	}
 
-	runner, err := NewRunner(natsClient, hopsLoader, h.Logger)
+	// Collected metrics are served by the HTTP server, if also running
+	h.runnerMetrics = NewCounterMetrics()
+	runnerOpts := []RunnerOpt{WithMetrics(h.runnerMetrics)}
+	if h.RunnerConf.DispatchTimeout > 0 {
+		runnerOpts = append(runnerOpts, WithDispatchTimeout(h.RunnerConf.DispatchTimeout))
+	}
+	if h.RunnerConf.FailFast {
+		runnerOpts = append(runnerOpts, WithFailFast())
+	}
+	if h.RunnerConf.LogInputs {
+		runnerOpts = append(runnerOpts, WithInputLogging(h.RunnerConf.LogInputsLimit))
+	}
+	maxEvaluations := h.RunnerConf.MaxEvaluations
+	if maxEvaluations == 0 {
+		maxEvaluations = h.RunnerConf.Concurrency
+	}
+	if maxEvaluations > 0 {
+		runnerOpts = append(runnerOpts, WithMaxEvaluations(maxEvaluations))
+	}
+	if h.RunnerConf.DryRun {
+		h.Logger.Warn().Msg("Runner is in dry run mode, calls will be logged but not dispatched")
+		runnerOpts = append(runnerOpts, WithDryRun())
+
+		if h.RunnerConf.DryRunShadowSubject != "" {
+			runnerOpts = append(runnerOpts, WithShadowSubject(h.RunnerConf.DryRunShadowSubject))
+		}
+	}
+	if len(h.RunnerConf.Globals) > 0 {
+		globals, err := parseGlobals(h.RunnerConf.Globals)
+		if err != nil {
+			return err
+		}
+
+		runnerOpts = append(runnerOpts, WithGlobals(globals))
+	}
+	if h.RunnerConf.SequenceTimeout > 0 {
+		runnerOpts = append(runnerOpts, WithSequenceTimeout(h.RunnerConf.SequenceTimeout))
+	}
+	if h.RunnerConf.SequenceState {
+		stateStore, err := nats.NewKVSequenceStateStore(natsClient, nats.DefaultSequenceStateTTL)
+		if err != nil {
+			return err
+		}
+
+		runnerOpts = append(runnerOpts, WithSequenceState(stateStore))
+	}
+
+	runner, err := NewRunner(natsClient, hopsLoader, h.Logger, runnerOpts...)
 	if err != nil {
 		return err
 	}
 
+	if len(h.RunnerConf.RedactKeys) > 0 {
+		runner.SetRedactKeys(h.RunnerConf.RedactKeys...)
+	}
+
 	if h.Watch {
 		h.reloadManager.Add(10, reload.ReloaderFunc(func(ctx context.Context, id string) error {
 			return runner.Reload(ctx)
@@ -346,9 +524,35 @@ func (h *HopsServer) startRunner(ctx context.Context, hopsLoader *HopsFileLoader
 			return runner.Run(ctx, nats.DefaultConsumerName)
 		},
 		func(_ error) {
-			cancel()
+			defer cancel()
+
+			// Let sequences being evaluated finish, rather than abandoning them part way
+			stopCtx, stopCancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer stopCancel()
+
+			err := runner.Stop(stopCtx)
+			if err != nil {
+				h.Logger.Error().Err(err).Msg("Unable to stop runner cleanly")
+			}
 		},
 	)
 
 	return nil
 }
+
+// parseGlobals parses NAME=VALUE pairs into string globals
+func parseGlobals(pairs []string) (map[string]cty.Value, error) {
+	globals := make(map[string]cty.Value, len(pairs))
+
+	for _, pair := range pairs {
+		name, value, found := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !found || !hclsyntax.ValidIdentifier(name) {
+			return nil, fmt.Errorf("Invalid global '%s', must be NAME=VALUE with a valid identifier as NAME", pair)
+		}
+
+		globals[name] = cty.StringVal(value)
+	}
+
+	return globals, nil
+}
@@ -0,0 +1,80 @@
+package hops
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestParseGlobals(t *testing.T) {
+	tests := []struct {
+		name            string
+		pairs           []string
+		expectedGlobals map[string]cty.Value
+		expectErr       bool
+	}{
+		{
+			name:            "No globals",
+			pairs:           []string{},
+			expectedGlobals: map[string]cty.Value{},
+		},
+		{
+			name:  "Simple values",
+			pairs: []string{"env=production", "region=eu-west-1"},
+			expectedGlobals: map[string]cty.Value{
+				"env":    cty.StringVal("production"),
+				"region": cty.StringVal("eu-west-1"),
+			},
+		},
+		{
+			name:            "Value containing commas and equals signs",
+			pairs:           []string{"owners=alice,bob", "query=a=1&b=2"},
+			expectedGlobals: map[string]cty.Value{"owners": cty.StringVal("alice,bob"), "query": cty.StringVal("a=1&b=2")},
+		},
+		{
+			name:            "Value spaces are kept",
+			pairs:           []string{" greeting = Hello there "},
+			expectedGlobals: map[string]cty.Value{"greeting": cty.StringVal(" Hello there ")},
+		},
+		{
+			name:            "Empty value",
+			pairs:           []string{"empty="},
+			expectedGlobals: map[string]cty.Value{"empty": cty.StringVal("")},
+		},
+		{
+			name:            "Later value wins",
+			pairs:           []string{"env=staging", "env=production"},
+			expectedGlobals: map[string]cty.Value{"env": cty.StringVal("production")},
+		},
+		{
+			name:      "Missing equals sign",
+			pairs:     []string{"env"},
+			expectErr: true,
+		},
+		{
+			name:      "Missing name",
+			pairs:     []string{"=production"},
+			expectErr: true,
+		},
+		{
+			name:      "Invalid identifier",
+			pairs:     []string{"2fa=enabled"},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			globals, err := parseGlobals(tc.pairs)
+			if tc.expectErr {
+				assert.Error(t, err, "Invalid globals should be rejected")
+				return
+			}
+
+			require.NoError(t, err, "Globals should parse without error")
+			assert.Equal(t, tc.expectedGlobals, globals)
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package hops
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hiphops-io/hops/dsl"
+)
+
+// TaskListLimit is the default and max number of tasks listed per page
+const TaskListLimit = 100
+
+type (
+	// TaskList is a page of tasks, ordered by name
+	TaskList struct {
+		Tasks []dsl.TaskAST `json:"tasks"`
+		// Total is the number of tasks matching the query, across all pages
+		Total int `json:"total"`
+		// NextCursor fetches the next page of tasks, if there are any
+		NextCursor string `json:"next_cursor,omitempty"`
+	}
+
+	// taskListQuery selects a page of tasks from the query params:
+	//   - q: only list tasks whose name, display name, summary or description
+	//     contain the text, ignoring case
+	//   - limit: the page size, up to TaskListLimit
+	//   - cursor: the next_cursor of the previous page
+	//   - filepath: only list tasks in files under the path (see dsl.HopAST.ListFileTasks)
+	//
+	// Without q, limit or cursor, every task is listed as a bare array rather
+	// than a TaskList, as it was before tasks were paginated.
+	taskListQuery struct {
+		after  string
+		limit  int
+		paged  bool
+		search string
+	}
+)
+
+func taskListQueryFromRequest(r *http.Request) (taskListQuery, error) {
+	params := r.URL.Query()
+
+	query := taskListQuery{
+		limit:  TaskListLimit,
+		paged:  params.Has("q") || params.Has("limit") || params.Has("cursor"),
+		search: strings.ToLower(strings.TrimSpace(params.Get("q"))),
+	}
+
+	if limitParam := params.Get("limit"); limitParam != "" {
+		limit, err := strconv.Atoi(limitParam)
+		if err != nil || limit < 1 || limit > TaskListLimit {
+			return query, fmt.Errorf("limit must be a number from 1 to %d", TaskListLimit)
+		}
+		query.limit = limit
+	}
+
+	if cursorParam := params.Get("cursor"); cursorParam != "" {
+		after, err := base64.RawURLEncoding.DecodeString(cursorParam)
+		if err != nil || len(after) == 0 {
+			return query, errors.New("Invalid cursor")
+		}
+		query.after = string(after)
+	}
+
+	return query, nil
+}
+
+// page returns the page of tasks matching the query
+//
+// Tasks are ordered by name, with cursors holding the name of the last task of
+// a page, so pages stay stable as tasks are added or removed. Unpaged queries
+// give a single page of every task.
+func (q taskListQuery) page(tasks []dsl.TaskAST) TaskList {
+	matched := []dsl.TaskAST{}
+	for _, task := range tasks {
+		if q.matches(task) {
+			matched = append(matched, task)
+		}
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].Name < matched[j].Name
+	})
+
+	list := TaskList{Tasks: []dsl.TaskAST{}, Total: len(matched)}
+
+	start := sort.Search(len(matched), func(i int) bool {
+		return matched[i].Name > q.after
+	})
+	limit := q.limit
+	if !q.paged {
+		limit = len(matched)
+	}
+
+	end := start + limit
+	if end >= len(matched) {
+		end = len(matched)
+	} else {
+		list.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(matched[end-1].Name))
+	}
+
+	list.Tasks = append(list.Tasks, matched[start:end]...)
+
+	return list
+}
+
+func (q taskListQuery) matches(task dsl.TaskAST) bool {
+	if q.search == "" {
+		return true
+	}
+
+	for _, text := range []string{task.Name, task.DisplayName, task.Summary, task.Description} {
+		if strings.Contains(strings.ToLower(text), q.search) {
+			return true
+		}
+	}
+
+	return false
+}
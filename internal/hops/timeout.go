@@ -0,0 +1,136 @@
+package hops
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/hiphops-io/hops/dsl"
+	"github.com/hiphops-io/hops/nats"
+)
+
+// sequenceExpiry decides which of a sequence's on blocks have passed their
+// timeout, recording the calls they leave pending and when the next will pass
+//
+// On blocks are checked concurrently. The sequence's start time is only looked
+// up once an on block with a timeout needs checking.
+type sequenceExpiry struct {
+	defaultTimeout time.Duration
+	expired        map[string][]string
+	fetchStart     func() (time.Time, error)
+	logger         zerolog.Logger
+	mu             sync.Mutex
+	next           time.Time
+	now            time.Time
+	once           sync.Once
+	startErr       error
+	startedAt      time.Time
+}
+
+// newSequenceExpiry returns the expiry of a sequence being evaluated now, which
+// started when its source event was published
+func (r *Runner) newSequenceExpiry(ctx context.Context, sequenceId string, logger zerolog.Logger) *sequenceExpiry {
+	return &sequenceExpiry{
+		defaultTimeout: r.sequenceTimeout,
+		expired:        map[string][]string{},
+		fetchStart: func() (time.Time, error) {
+			sourceMsg, err := r.natsClient.GetMsg(ctx, nats.ChannelNotify, sequenceId, nats.SourceEventId)
+			if err != nil {
+				return time.Time{}, err
+			}
+
+			return sourceMsg.Time, nil
+		},
+		logger: logger,
+		now:    time.Now(),
+	}
+}
+
+// expire returns whether an on block has passed its timeout, recording its
+// calls without results as expired if so
+//
+// If the sequence's start time can't be found, on blocks never expire.
+func (e *sequenceExpiry) expire(sensor *dsl.OnAST, msgBundle nats.MessageBundle) bool {
+	timeout := sensor.Timeout
+	if timeout == 0 {
+		timeout = e.defaultTimeout
+	}
+	if timeout == 0 {
+		return false
+	}
+
+	e.once.Do(func() {
+		e.startedAt, e.startErr = e.fetchStart()
+		if e.startErr != nil {
+			e.logger.Warn().Err(e.startErr).Msg("Unable to find source event of sequence, timeouts will not be applied")
+		}
+	})
+	if e.startErr != nil {
+		return false
+	}
+
+	deadline := e.startedAt.Add(timeout)
+	if e.now.Before(deadline) {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+
+		if e.next.IsZero() || deadline.Before(e.next) {
+			e.next = deadline
+		}
+		return false
+	}
+
+	pending := []string{}
+	for _, calls := range [][]dsl.CallAST{sensor.Calls, sensor.Waiting} {
+		for _, call := range calls {
+			if _, ok := msgBundle[call.Slug]; !ok {
+				pending = append(pending, call.Slug)
+			}
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.expired[sensor.Slug] = pending
+	return true
+}
+
+// nextDeadline returns the earliest timeout of the on blocks yet to expire, or the
+// zero time if none have one
+func (e *sequenceExpiry) nextDeadline() time.Time {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.next
+}
+
+// isExpired returns whether an on block was found to have passed its timeout
+func (e *sequenceExpiry) isExpired(onSlug string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, ok := e.expired[onSlug]
+	return ok
+}
+
+// summary returns the slugs of the expired on blocks and of their pending calls
+func (e *sequenceExpiry) summary() ([]string, []string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ons := []string{}
+	calls := []string{}
+	for onSlug, pending := range e.expired {
+		ons = append(ons, onSlug)
+		calls = append(calls, pending...)
+	}
+
+	sort.Strings(ons)
+	sort.Strings(calls)
+
+	return ons, calls
+}
@@ -0,0 +1,141 @@
+package hops
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hiphops-io/hops/logs"
+	"github.com/hiphops-io/hops/nats"
+)
+
+func TestRunnerSequenceTimeout(t *testing.T) {
+	ctx := context.Background()
+	logger := logs.NoOpLogger()
+	natsClient, _ := setupRunnerClient(t)
+
+	const shortTimeout = 200 * time.Millisecond
+
+	hopsTemplate := `on testevent {
+  name = "pipeline"
+  %s
+
+  call app_first {
+    name = "first"
+  }
+
+  call app_second {
+    name = "second"
+    if   = first.completed
+  }
+}
+`
+
+	tests := []struct {
+		name           string
+		onTimeout      string
+		runnerOpts     []RunnerOpt
+		expectTimeout  bool
+		expectedStatus string
+	}{
+		{
+			name:           "Runner default",
+			runnerOpts:     []RunnerOpt{WithSequenceTimeout(shortTimeout)},
+			expectTimeout:  true,
+			expectedStatus: nats.StatusTimeout,
+		},
+		{
+			name:           "On block timeout",
+			onTimeout:      fmt.Sprintf(`timeout = "%s"`, shortTimeout),
+			expectTimeout:  true,
+			expectedStatus: nats.StatusTimeout,
+		},
+		{
+			name:          "On block overrides runner default",
+			onTimeout:     `timeout = "1h"`,
+			runnerOpts:    []RunnerOpt{WithSequenceTimeout(shortTimeout)},
+			expectTimeout: false,
+		},
+		{
+			name:          "No timeout",
+			expectTimeout: false,
+		},
+	}
+
+	eventData, err := os.ReadFile("./testdata/source_testevent.json")
+	require.NoError(t, err, "Test setup: Should read source event")
+
+	for i, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sequenceId := fmt.Sprintf("SEQ_%d", i)
+
+			hopsDir := t.TempDir()
+			err := os.WriteFile(testHopsPath(t, hopsDir), []byte(fmt.Sprintf(hopsTemplate, tc.onTimeout)), 0o644)
+			require.NoError(t, err, "Test setup: Should write hops file")
+
+			hopsLoader, err := NewHopsFileLoader(hopsDir, false)
+			require.NoError(t, err, "Test setup: Hops files should load without error")
+
+			runner, err := NewRunner(natsClient, hopsLoader, logger, tc.runnerOpts...)
+			require.NoError(t, err, "Test setup: Runner should initialise without error")
+
+			// The fake worker receives requests but never responds
+			requestFilter := strings.Join([]string{natsClient.AccountId(), natsClient.InterestTopic(), nats.ChannelRequest, sequenceId, ">"}, ".")
+			worker, err := natsClient.NatsConn.SubscribeSync(requestFilter)
+			require.NoError(t, err, "Test setup: Fake worker should subscribe to requests")
+			defer worker.Unsubscribe()
+
+			go runner.Run(ctx, nats.DefaultConsumerName)
+			defer runner.Stop(context.Background())
+
+			_, _, err = natsClient.Publish(ctx, eventData, nats.ChannelNotify, sequenceId, nats.SourceEventId)
+			require.NoError(t, err, "Test setup: Source event should be published")
+
+			_, err = worker.NextMsg(5 * time.Second)
+			require.NoError(t, err, "First call should be dispatched")
+
+			// Nothing else is published, so the sequence must time out by itself
+			if tc.expectTimeout {
+				assert.Eventually(t, func() bool {
+					_, err := natsClient.GetMsg(ctx, nats.ChannelNotify, sequenceId, nats.SequenceMessageId, nats.CompletedMessageId)
+					return err == nil
+				}, 5*time.Second, 50*time.Millisecond, "Sequence should complete once its timeout passes")
+			} else {
+				time.Sleep(2 * shortTimeout)
+			}
+
+			_, err = worker.NextMsg(100 * time.Millisecond)
+			assert.Error(t, err, "No further calls should be dispatched")
+
+			timeoutMsg, err := natsClient.GetMsg(ctx, nats.ChannelNotify, sequenceId, nats.SequenceMessageId, nats.TimeoutMessageId)
+			completionMsg, completionErr := natsClient.GetMsg(ctx, nats.ChannelNotify, sequenceId, nats.SequenceMessageId, nats.CompletedMessageId)
+			if !tc.expectTimeout {
+				assert.Error(t, err, "Sequence should not time out")
+				assert.Error(t, completionErr, "Sequence should not be complete")
+				return
+			}
+
+			require.NoError(t, err, "Sequence timeout should be published")
+			timeout := nats.TimeoutMsg{}
+			err = json.Unmarshal(timeoutMsg.Data, &timeout)
+			require.NoError(t, err, "Sequence timeout should be decoded")
+			assert.Equal(t, []string{"pipeline"}, timeout.Ons)
+			assert.Equal(t, []string{"pipeline-first", "pipeline-second"}, timeout.Expired)
+
+			require.NoError(t, completionErr, "Sequence completion should be published")
+			completion := nats.CompletionMsg{}
+			err = json.Unmarshal(completionMsg.Data, &completion)
+			require.NoError(t, err, "Sequence completion should be decoded")
+			assert.Equal(t, tc.expectedStatus, completion.Status)
+			assert.Equal(t, []string{"pipeline-first", "pipeline-second"}, completion.Expired)
+			assert.Equal(t, 1, completion.Calls)
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package hops
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// How often the cert files are checked for changes, at most
+const certReloadInterval = 10 * time.Second
+
+type (
+	// TLSConf configures the HTTP server to serve over TLS
+	TLSConf struct {
+		CertFile string
+		KeyFile  string
+		// RedirectAddress is where to serve redirects from HTTP to HTTPS (empty disables)
+		RedirectAddress string
+	}
+
+	// certReloader serves a TLS certificate, reloading it once its files change so
+	// short-lived certs can be renewed without restarting
+	certReloader struct {
+		cert      *tls.Certificate
+		certFile  string
+		checkedAt time.Time
+		keyFile   string
+		logger    zerolog.Logger
+		modTimes  [2]time.Time
+		mu        sync.Mutex
+		now       func() time.Time
+	}
+)
+
+// newCertReloader loads the certificate in certFile and keyFile, erroring if
+// either file is missing or they don't form a valid pair
+func newCertReloader(certFile string, keyFile string, logger zerolog.Logger) (*certReloader, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("Both a TLS cert file and key file are required")
+	}
+
+	c := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		logger:   logger,
+		now:      time.Now,
+	}
+
+	modTimes, err := c.stat()
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.load(modTimes)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// GetCertificate returns the current certificate, for use as tls.Config.GetCertificate
+//
+// Failed reloads keep serving the previous certificate, so a renewal caught
+// half-written is picked up on a later check.
+func (c *certReloader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if now.Sub(c.checkedAt) < certReloadInterval {
+		return c.cert, nil
+	}
+	c.checkedAt = now
+
+	modTimes, err := c.stat()
+	if err != nil {
+		c.logger.Warn().Err(err).Msg("Unable to check TLS cert files for changes")
+		return c.cert, nil
+	}
+	if modTimes == c.modTimes {
+		return c.cert, nil
+	}
+
+	err = c.load(modTimes)
+	if err != nil {
+		c.logger.Warn().Err(err).Msg("Unable to reload TLS cert, serving previous cert")
+		return c.cert, nil
+	}
+
+	c.logger.Info().Msg("TLS cert reloaded")
+	return c.cert, nil
+}
+
+func (c *certReloader) load(modTimes [2]time.Time) error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("Unable to load TLS cert: %w", err)
+	}
+
+	c.cert = &cert
+	c.checkedAt = c.now()
+	c.modTimes = modTimes
+
+	return nil
+}
+
+func (c *certReloader) stat() ([2]time.Time, error) {
+	modTimes := [2]time.Time{}
+
+	for i, file := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return modTimes, fmt.Errorf("Unable to read TLS cert file: %w", err)
+		}
+
+		modTimes[i] = info.ModTime()
+	}
+
+	return modTimes, nil
+}
+
+// httpsRedirect redirects requests to the same host and path over HTTPS, on the
+// port of httpsAddr
+func httpsRedirect(httpsAddr string) http.Handler {
+	_, httpsPort, _ := net.SplitHostPort(httpsAddr)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
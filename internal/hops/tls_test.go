@@ -0,0 +1,201 @@
+package hops
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hiphops-io/hops/logs"
+)
+
+func TestHTTPServerTLS(t *testing.T) {
+	logger := logs.NoOpLogger()
+	natsClient, _ := setupRunnerClient(t)
+
+	hopsDir := t.TempDir()
+	err := os.WriteFile(testHopsPath(t, hopsDir), []byte("task deploy {}\n"), 0o644)
+	require.NoError(t, err, "Test setup: Should write hops file")
+
+	hopsLoader, err := NewHopsFileLoader(hopsDir, false)
+	require.NoError(t, err, "Test setup: Hops files should load without error")
+
+	certDir := t.TempDir()
+	certPEM := writeTestCert(t, certDir, "first")
+
+	addr, redirectAddr := freeAddr(t), freeAddr(t)
+	tlsConf := TLSConf{
+		CertFile:        filepath.Join(certDir, "tls.crt"),
+		KeyFile:         filepath.Join(certDir, "tls.key"),
+		RedirectAddress: redirectAddr,
+	}
+
+	server, err := NewHTTPServer(addr, hopsLoader, false, natsClient, logger, WithTLS(tlsConf))
+	require.NoError(t, err, "Server should initialise with a valid cert")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Serve(ctx)
+
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(certPEM), "Test setup: Should trust test cert")
+
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+		Timeout: time.Second,
+	}
+
+	var resp *http.Response
+	require.Eventually(t, func() bool {
+		resp, err = client.Get("https://" + addr + "/healthz")
+		return err == nil
+	}, 5*time.Second, 50*time.Millisecond, "Server should serve over TLS")
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "OK", string(body))
+
+	redirectResp, err := client.Get("http://" + redirectAddr + "/health?verbose=1")
+	require.NoError(t, err, "HTTP should be redirected")
+	defer redirectResp.Body.Close()
+
+	assert.Equal(t, http.StatusPermanentRedirect, redirectResp.StatusCode)
+	_, port, _ := net.SplitHostPort(addr)
+	assert.Equal(t, "https://127.0.0.1:"+port+"/health?verbose=1", redirectResp.Header.Get("Location"))
+}
+
+func TestHTTPServerTLSInvalidCert(t *testing.T) {
+	certDir := t.TempDir()
+	writeTestCert(t, certDir, "first")
+
+	tests := []struct {
+		name string
+		conf TLSConf
+	}{
+		{
+			name: "Missing key file",
+			conf: TLSConf{CertFile: filepath.Join(certDir, "tls.crt")},
+		},
+		{
+			name: "Nonexistent cert file",
+			conf: TLSConf{CertFile: filepath.Join(certDir, "missing.crt"), KeyFile: filepath.Join(certDir, "tls.key")},
+		},
+		{
+			name: "Key file is not a key",
+			conf: TLSConf{CertFile: filepath.Join(certDir, "tls.crt"), KeyFile: filepath.Join(certDir, "tls.crt")},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newCertReloader(tc.conf.CertFile, tc.conf.KeyFile, logs.NoOpLogger())
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	certDir := t.TempDir()
+	writeTestCert(t, certDir, "first")
+
+	certs, err := newCertReloader(filepath.Join(certDir, "tls.crt"), filepath.Join(certDir, "tls.key"), logs.NoOpLogger())
+	require.NoError(t, err)
+
+	now := time.Now()
+	certs.now = func() time.Time { return now }
+
+	commonName := func() string {
+		cert, err := certs.GetCertificate(nil)
+		require.NoError(t, err)
+
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		require.NoError(t, err)
+
+		return leaf.Subject.CommonName
+	}
+
+	assert.Equal(t, "first", commonName())
+
+	// Renew the cert, making sure its files look modified
+	writeTestCert(t, certDir, "second")
+	modified := time.Now().Add(time.Minute)
+	for _, file := range []string{"tls.crt", "tls.key"} {
+		err := os.Chtimes(filepath.Join(certDir, file), modified, modified)
+		require.NoError(t, err, "Test setup: Should update cert file times")
+	}
+
+	assert.Equal(t, "first", commonName(), "Cert files should not be checked again within the reload interval")
+
+	now = now.Add(certReloadInterval)
+	assert.Equal(t, "second", commonName(), "Changed cert should be reloaded")
+
+	// A half-written renewal keeps the previous cert
+	err = os.WriteFile(filepath.Join(certDir, "tls.crt"), []byte("partial"), 0o644)
+	require.NoError(t, err)
+	now = now.Add(certReloadInterval)
+	assert.Equal(t, "second", commonName(), "Invalid cert should not replace the current one")
+}
+
+// freeAddr returns a localhost address with a port that's free to listen on
+func freeAddr(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "Test setup: Should find a free port")
+	defer listener.Close()
+
+	return listener.Addr().String()
+}
+
+// writeTestCert writes a self-signed cert for 127.0.0.1 to tls.crt and tls.key
+// in dir, returning the cert PEM
+func writeTestCert(t *testing.T, dir string, commonName string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err, "Test setup: Should generate key")
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err, "Test setup: Should create cert")
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err, "Test setup: Should marshal key")
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	err = os.WriteFile(filepath.Join(dir, "tls.crt"), certPEM, 0o644)
+	require.NoError(t, err, "Test setup: Should write cert")
+	err = os.WriteFile(filepath.Join(dir, "tls.key"), keyPEM, 0o600)
+	require.NoError(t, err, "Test setup: Should write key")
+
+	return certPEM
+}
@@ -0,0 +1,165 @@
+package hops
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-json"
+
+	"github.com/hiphops-io/hops/dsl"
+)
+
+const (
+	// ValidateBodyLimit is the max size in bytes of the content sent to POST /validate
+	ValidateBodyLimit = 1 << 20
+	// validateDefaultFile names raw hops content sent to POST /validate, unless
+	// given with the file query param
+	validateDefaultFile = "main.hops"
+)
+
+// validateHops checks hops content against the running version of hops,
+// responding with its diagnostics (see dsl.Validate)
+//
+// The body is either the content of a single hops file, named with the file
+// query param, or a multipart form of files. With strict=true warnings fail
+// validation, along with errors. Responds 200 OK if valid, otherwise 422.
+//
+// Content is only held in memory whilst validating, never stored or run.
+func (h *HTTPServer) validateHops(w http.ResponseWriter, r *http.Request) {
+	strict := false
+	if strictParam := r.URL.Query().Get("strict"); strictParam != "" {
+		var err error
+		strict, err = strconv.ParseBool(strictParam)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, ErrorCodeBadRequest, "strict must be true or false")
+			return
+		}
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, ValidateBodyLimit)
+
+	files, err := readValidateFiles(r)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeError(w, r, http.StatusRequestEntityTooLarge, ErrorCodePayloadTooLarge, fmt.Sprintf("Content must be at most %d bytes", ValidateBodyLimit))
+			return
+		}
+
+		writeError(w, r, http.StatusBadRequest, ErrorCodeBadRequest, err.Error())
+		return
+	}
+
+	diagnostics := dsl.Validate(r.Context(), files)
+
+	status := http.StatusOK
+	for _, diagnostic := range diagnostics {
+		if diagnostic.Severity == dsl.SeverityError || strict {
+			status = http.StatusUnprocessableEntity
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(diagnostics)
+}
+
+// readValidateFiles reads the files sent to POST /validate
+func readValidateFiles(r *http.Request) ([]dsl.FileContent, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		content, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		name := r.URL.Query().Get("file")
+		if name == "" {
+			name = validateDefaultFile
+		}
+
+		return []dsl.FileContent{{File: name, Content: content, Type: dsl.HopsFile}}, nil
+	}
+
+	// Parts are read directly, as parsing the form may write files to disk
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read multipart form: %w", err)
+	}
+
+	files := []dsl.FileContent{}
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read multipart form: %w", err)
+		}
+
+		// Part.FileName drops directories, which file functions resolve paths from
+		_, params, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+		name := params["filename"]
+		if name == "" {
+			name = part.FormName()
+		}
+
+		content, err := io.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+
+		fileType := dsl.OtherFile
+		if filepath.Ext(name) == dsl.HopsExt {
+			fileType = dsl.HopsFile
+		}
+
+		files = append(files, dsl.FileContent{File: name, Content: content, Type: fileType})
+	}
+
+	if len(files) == 0 {
+		return nil, errors.New("At least one file is required")
+	}
+
+	return files, nil
+}
+
+// validateOpenAPIPaths describes the route validating hops content
+func validateOpenAPIPaths() openAPIPaths {
+	return openAPIPaths{
+		"/validate": {
+			"post": {
+				OperationID: "validateHops",
+				Summary:     "Validate hops content without loading it",
+				Description: "The body is either the content of a single hops file, named with the file param, or a multipart form of files.",
+				Tags:        []string{"validate"},
+				Parameters: []openAPIParameter{
+					queryParam("file", "The name of the hops file sent as the body, defaulting to "+validateDefaultFile, "string"),
+					queryParam("strict", "Fail validation on warnings, as well as errors", "boolean"),
+				},
+				RequestBody: &openAPIRequestBody{
+					Required: true,
+					Content: map[string]openAPIMediaType{
+						"multipart/form-data": {Schema: &jsonSchema{Type: "object"}},
+						"text/plain":          {Schema: &jsonSchema{Type: "string"}},
+					},
+				},
+				Responses: responses(
+					map[int]openAPIResponse{
+						http.StatusOK:                  jsonResponse("The content is valid, with any warnings", []dsl.Diagnostic{}),
+						http.StatusUnprocessableEntity: jsonResponse("The content is invalid", []dsl.Diagnostic{}),
+					},
+					http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusTooManyRequests,
+				),
+				protected: true,
+			},
+		},
+	}
+}
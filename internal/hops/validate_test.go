@@ -0,0 +1,134 @@
+package hops
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hiphops-io/hops/dsl"
+	"github.com/hiphops-io/hops/logs"
+)
+
+func TestHTTPServerValidateHops(t *testing.T) {
+	natsClient, _ := setupRunnerClient(t)
+
+	hopsDir := t.TempDir()
+	err := os.WriteFile(testHopsPath(t, hopsDir), []byte("task deploy {}\n"), 0o644)
+	require.NoError(t, err, "Test setup: Should write hops file")
+
+	hopsLoader, err := NewHopsFileLoader(hopsDir, false)
+	require.NoError(t, err, "Test setup: Hops files should load without error")
+
+	server, err := NewHTTPServer("127.0.0.1:0", hopsLoader, false, natsClient, logs.NoOpLogger())
+	require.NoError(t, err, "Test setup: Server should initialise")
+
+	validate := func(t *testing.T, query string, contentType string, body []byte) (int, []map[string]any) {
+		req := httptest.NewRequest(http.MethodPost, "/validate?"+query, bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+
+		rec := httptest.NewRecorder()
+		server.server.Handler.ServeHTTP(rec, req)
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+		diagnostics := []map[string]any{}
+		if rec.Code == http.StatusOK || rec.Code == http.StatusUnprocessableEntity {
+			err := json.Unmarshal(rec.Body.Bytes(), &diagnostics)
+			require.NoError(t, err, "Response should be a JSON array of diagnostics")
+		}
+
+		return rec.Code, diagnostics
+	}
+
+	validHops := "on push {\n  call github_comment {}\n}\n"
+	brokenHops := "on push {\n  name = \"Bad Name\"\n  call github_comment {}\n}\n"
+	warningHops := "on push {}\n"
+
+	t.Run("Valid content", func(t *testing.T) {
+		status, diagnostics := validate(t, "", "text/plain", []byte(validHops))
+		assert.Equal(t, http.StatusOK, status)
+		assert.Empty(t, diagnostics)
+	})
+
+	t.Run("Broken content", func(t *testing.T) {
+		status, diagnostics := validate(t, "file=automation/main.hops", "text/plain", []byte(brokenHops))
+		assert.Equal(t, http.StatusUnprocessableEntity, status)
+		require.Len(t, diagnostics, 1)
+
+		diagnostic := diagnostics[0]
+		assert.Equal(t, dsl.SeverityError, diagnostic["severity"])
+		assert.Equal(t, "automation/main.hops", diagnostic["file"])
+		assert.Contains(t, diagnostic["message"], "Invalid label")
+		rng := diagnostic["range"].(map[string]any)
+		assert.Equal(t, float64(1), rng["start"].(map[string]any)["line"])
+		assert.Contains(t, rng["end"], "column")
+	})
+
+	t.Run("Multipart files", func(t *testing.T) {
+		body := &bytes.Buffer{}
+		form := multipart.NewWriter(body)
+		for name, content := range map[string]string{
+			"valid/main.hops":  validHops,
+			"broken/main.hops": brokenHops,
+			"valid/notes.txt":  "on {{ not hops",
+		} {
+			part, err := form.CreateFormFile("files", name)
+			require.NoError(t, err, "Test setup: Should create form file")
+			part.Write([]byte(content))
+		}
+		require.NoError(t, form.Close())
+
+		status, diagnostics := validate(t, "", form.FormDataContentType(), body.Bytes())
+		assert.Equal(t, http.StatusUnprocessableEntity, status)
+		require.Len(t, diagnostics, 1, "Only hops files should be validated")
+		assert.Equal(t, "broken/main.hops", diagnostics[0]["file"], "Files should keep their directories")
+	})
+
+	t.Run("Strict", func(t *testing.T) {
+		status, diagnostics := validate(t, "", "text/plain", []byte(warningHops))
+		assert.Equal(t, http.StatusOK, status, "Warnings should only fail strict validation")
+		require.Len(t, diagnostics, 1)
+		assert.Equal(t, dsl.SeverityWarning, diagnostics[0]["severity"])
+
+		status, _ = validate(t, "strict=true", "text/plain", []byte(warningHops))
+		assert.Equal(t, http.StatusUnprocessableEntity, status)
+
+		status, _ = validate(t, "strict=maybe", "text/plain", []byte(warningHops))
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+
+	t.Run("Never reveals env vars", func(t *testing.T) {
+		secretValue := "sup3r-s3cr3t-value"
+		t.Setenv("HOPS_TEST_SECRET", secretValue)
+		t.Setenv("HOPS_SECRET_DEPLOY_TOKEN", secretValue)
+
+		leakyHops := `schedule from_env {
+  cron = env("HOPS_TEST_SECRET", "never")
+}
+
+schedule from_secret {
+  cron = secret("deploy_token")
+}
+`
+		req := httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(leakyHops))
+		req.Header.Set("Content-Type", "text/plain")
+
+		rec := httptest.NewRecorder()
+		server.server.Handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		assert.NotContains(t, rec.Body.String(), secretValue)
+	})
+
+	t.Run("Too large", func(t *testing.T) {
+		status, _ := validate(t, "", "text/plain", []byte(strings.Repeat("#", ValidateBodyLimit+1)))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, status)
+	})
+}
@@ -118,9 +118,9 @@ func (h *HTTPHandler) AppName() string {
 func (h *HTTPHandler) Do(ctx context.Context, msg jetstream.Msg) error {
 	startedAt := time.Now()
 
-	parsedMsg, err := nats.Parse(msg)
-	if err != nil {
-		return fmt.Errorf("Unable to parse response subject from message %s", msg.Subject())
+	parsedMsg, ok := nats.MsgMetaFromContext(ctx)
+	if !ok {
+		return fmt.Errorf("Unable to get response subject for message %s", msg.Subject())
 	}
 
 	job := doJob{
@@ -122,9 +122,9 @@ func (k *K8sHandler) RunPod(ctx context.Context, msg jetstream.Msg) error {
 		return fmt.Errorf("Failed to parse memory limit: %w", err)
 	}
 
-	parsedMsg, err := nats.Parse(msg)
-	if err != nil {
-		return fmt.Errorf("Unable to parse response subject from message %s", msg.Subject())
+	parsedMsg, ok := nats.MsgMetaFromContext(ctx)
+	if !ok {
+		return fmt.Errorf("Unable to get response subject for message %s", msg.Subject())
 	}
 
 	pods := k.clientset.CoreV1().Pods(runPodInput.Namespace)
@@ -1,33 +1,155 @@
 package logs
 
 import (
+	"context"
 	"net/http"
+	"regexp"
 	"strings"
 	"time"
 
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
 	"github.com/justinas/alice"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/hlog"
 )
 
-func AccessLogMiddleware(logger zerolog.Logger) func(http.Handler) http.Handler {
+// DefaultSlowRequestThreshold is a sensible duration beyond which requests are
+// logged as slow, see WithSlowThreshold
+const DefaultSlowRequestThreshold = 5 * time.Second
+
+// RequestIDHeader is the header a request's ID may be given in, and is returned in
+const RequestIDHeader = "X-Request-Id"
+
+var requestIDRegex = regexp.MustCompile(`^[\w.:/-]{1,128}$`)
+
+type (
+	// AccessLogOpt configures AccessLogMiddleware
+	AccessLogOpt func(*accessLog)
+
+	accessLog struct {
+		skipPaths     []string
+		slowThreshold time.Duration
+	}
+
+	requestIDCtxKey struct{}
+)
+
+// AccessLogMiddleware logs every request once it's been handled, with its
+// status, response size in bytes and duration
+//
+// Requests are given IDs by RequestIDMiddleware, which are included in every
+// line logged for them. Requests taking longer than the threshold set with
+// WithSlowThreshold are logged at warn level, other than streamed responses
+// (i.e. server-sent events), which are expected to last.
+func AccessLogMiddleware(logger zerolog.Logger, opts ...AccessLogOpt) func(http.Handler) http.Handler {
+	conf := &accessLog{}
+	for _, opt := range opts {
+		opt(conf)
+	}
+
 	chain := alice.New()
 	chain = chain.Append(hlog.NewHandler(logger))
-	chain = chain.Append(hlog.AccessHandler(func(r *http.Request, status, size int, duration time.Duration) {
-		if strings.HasPrefix(r.URL.Path, "/console") {
-			return
-		}
+	chain = chain.Append(RequestIDMiddleware)
+	chain = chain.Append(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			next.ServeHTTP(ww, r)
+
+			if conf.skips(r.URL.Path) {
+				return
+			}
+
+			duration := time.Since(start)
+			// Handlers that never write respond 200 OK
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
 
-		hlog.FromRequest(r).Info().
-			Int("status", status).
-			Str("method", r.Method).
-			Str("path", r.URL.Path).
-			Str("query", r.URL.RawQuery).
-			Str("ip", r.RemoteAddr).
-			Str("user-agent", r.UserAgent()).
-			Dur("duration", time.Duration(duration)).
-			Msg("")
-	}))
+			event := hlog.FromRequest(r).Info()
+			if conf.isSlow(duration, ww.Header()) {
+				event = hlog.FromRequest(r).Warn().Dur("slow_threshold", conf.slowThreshold)
+			}
+
+			event.
+				Int("status", status).
+				Int("size", ww.BytesWritten()).
+				Str("method", r.Method).
+				Str("path", r.URL.Path).
+				Str("query", r.URL.RawQuery).
+				Str("ip", r.RemoteAddr).
+				Str("user-agent", r.UserAgent()).
+				Dur("duration", duration).
+				Msg("")
+		})
+	})
 
 	return chain.Then
 }
+
+// WithSkipPaths leaves requests for paths, or anything under them, out of the
+// access log, e.g. the console's assets or health checks polled by a load balancer
+func WithSkipPaths(paths ...string) AccessLogOpt {
+	return func(a *accessLog) {
+		a.skipPaths = append(a.skipPaths, paths...)
+	}
+}
+
+// WithSlowThreshold logs requests taking longer than threshold at warn level,
+// e.g. DefaultSlowRequestThreshold (0 never does)
+func WithSlowThreshold(threshold time.Duration) AccessLogOpt {
+	return func(a *accessLog) {
+		a.slowThreshold = threshold
+	}
+}
+
+func (a *accessLog) isSlow(duration time.Duration, header http.Header) bool {
+	if a.slowThreshold <= 0 || duration <= a.slowThreshold {
+		return false
+	}
+
+	mediaType, _, _ := strings.Cut(header.Get("Content-Type"), ";")
+	return mediaType != "text/event-stream"
+}
+
+func (a *accessLog) skips(path string) bool {
+	for _, skipPath := range a.skipPaths {
+		skipPath = strings.TrimSuffix(skipPath, "/")
+		if path == skipPath || strings.HasPrefix(path, skipPath+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RequestIDFromContext returns the ID given to the request by RequestIDMiddleware, if any
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDCtxKey{}).(string)
+	return id, ok
+}
+
+// RequestIDMiddleware gives every request an ID, returned in the X-Request-Id
+// header and added to the request's logger (see hlog.FromRequest) as request_id
+//
+// An ID given in the request's X-Request-Id header (e.g. by a proxy) is kept if
+// it's up to 128 letters, digits or ._:/- characters, otherwise a UUID is used.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !requestIDRegex.MatchString(id) {
+			id = uuid.NewString()
+		}
+
+		w.Header().Set(RequestIDHeader, id)
+
+		ctx := context.WithValue(r.Context(), requestIDCtxKey{}, id)
+		logger := hlog.FromRequest(r).With().Str("request_id", id).Logger()
+		ctx = logger.WithContext(ctx)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
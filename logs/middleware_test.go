@@ -0,0 +1,176 @@
+package logs
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/hlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessLogMiddlewareRequestID(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	defer zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	type testCase struct {
+		name      string
+		given     string
+		expectNew bool
+	}
+
+	tests := []testCase{
+		{name: "Generated", expectNew: true},
+		{name: "Given", given: "req-123"},
+		{name: "Given by a proxy", given: "proxy/host:1234.5"},
+		{name: "Invalid", given: "bad id\n", expectNew: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var logBuf bytes.Buffer
+			logger := zerolog.New(&logBuf)
+
+			var handlerID string
+			handler := AccessLogMiddleware(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handlerID, _ = RequestIDFromContext(r.Context())
+				hlog.FromRequest(r).Info().Msg("handled")
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/tasks", nil)
+			if tc.given != "" {
+				req.Header.Set(RequestIDHeader, tc.given)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			id := rec.Header().Get(RequestIDHeader)
+			if tc.expectNew {
+				_, err := uuid.Parse(id)
+				assert.NoError(t, err, "Generated request IDs should be UUIDs")
+			} else {
+				assert.Equal(t, tc.given, id, "Given request ID should be returned")
+			}
+			assert.Equal(t, id, handlerID, "Handlers should get the request ID")
+
+			lines := bytes.Split(bytes.TrimSpace(logBuf.Bytes()), []byte("\n"))
+			require.Len(t, lines, 2, "Handler and access log lines should be logged")
+			for _, line := range lines {
+				assert.Contains(t, string(line), `"request_id":"`+id+`"`, "Every line should carry the request ID")
+			}
+		})
+	}
+}
+
+func TestAccessLogMiddlewareFields(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	defer zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	var logBuf bytes.Buffer
+	logger := zerolog.New(&logBuf)
+
+	handler := AccessLogMiddleware(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/tasks/deploy?dry=true", nil)
+	req.Header.Set(RequestIDHeader, "req-123")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	line := map[string]any{}
+	err := json.Unmarshal(logBuf.Bytes(), &line)
+	require.NoError(t, err, "Access log line should be JSON")
+
+	assert.Equal(t, "info", line["level"])
+	assert.Equal(t, float64(http.StatusCreated), line["status"])
+	assert.Equal(t, float64(len("created")), line["size"], "Size should be the bytes of the response")
+	assert.Equal(t, "req-123", line["request_id"])
+	assert.Equal(t, http.MethodPost, line["method"])
+	assert.Equal(t, "/tasks/deploy", line["path"])
+	assert.Equal(t, "dry=true", line["query"])
+	assert.Contains(t, line, "duration")
+}
+
+func TestAccessLogMiddlewareSkipPaths(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	defer zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	type testCase struct {
+		name   string
+		path   string
+		logged bool
+	}
+
+	tests := []testCase{
+		{name: "Skipped path", path: "/health"},
+		{name: "Under skipped path", path: "/console/assets/app.js"},
+		{name: "Skipped path with trailing slash", path: "/metrics/"},
+		{name: "Sharing a prefix with a skipped path", path: "/healthz", logged: true},
+		{name: "Other path", path: "/tasks", logged: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var logBuf bytes.Buffer
+			logger := zerolog.New(&logBuf)
+
+			handler := AccessLogMiddleware(logger, WithSkipPaths("/health", "/console", "/metrics/"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+			if tc.logged {
+				assert.Contains(t, logBuf.String(), `"path":"`+tc.path+`"`, "Request should be logged")
+			} else {
+				assert.Empty(t, logBuf.String(), "Request should not be logged")
+			}
+		})
+	}
+}
+
+func TestAccessLogMiddlewareSlowThreshold(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	defer zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	type testCase struct {
+		name          string
+		threshold     time.Duration
+		delay         time.Duration
+		contentType   string
+		expectedLevel string
+	}
+
+	tests := []testCase{
+		{name: "Fast", threshold: time.Second, expectedLevel: "info"},
+		{name: "Slow", threshold: time.Millisecond, delay: 10 * time.Millisecond, expectedLevel: "warn"},
+		{name: "Slow stream", threshold: time.Millisecond, delay: 10 * time.Millisecond, contentType: "text/event-stream", expectedLevel: "info"},
+		{name: "No threshold", delay: 10 * time.Millisecond, expectedLevel: "info"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var logBuf bytes.Buffer
+			logger := zerolog.New(&logBuf)
+
+			handler := AccessLogMiddleware(logger, WithSlowThreshold(tc.threshold))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tc.contentType != "" {
+					w.Header().Set("Content-Type", tc.contentType)
+				}
+				time.Sleep(tc.delay)
+			}))
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/events", nil))
+
+			line := map[string]any{}
+			err := json.Unmarshal(logBuf.Bytes(), &line)
+			require.NoError(t, err, "Access log line should be JSON")
+
+			assert.Equal(t, tc.expectedLevel, line["level"])
+			assert.Equal(t, float64(http.StatusOK), line["status"], "Handlers that never write should respond 200 OK")
+		})
+	}
+}
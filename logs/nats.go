@@ -43,3 +43,8 @@ func (n *NatsZeroLogger) Tracef(format string, v ...interface{}) {
 func (n *NatsZeroLogger) Warnf(format string, v ...interface{}) {
 	n.Warn().Msgf(format, v...)
 }
+
+// WithFields returns a child logger that adds the given fields to every log line
+func (n *NatsZeroLogger) WithFields(fields map[string]interface{}) *NatsZeroLogger {
+	return &NatsZeroLogger{n.With().Fields(fields).Logger()}
+}
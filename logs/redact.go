@@ -0,0 +1,110 @@
+package logs
+
+import (
+	"strings"
+
+	"github.com/goccy/go-json"
+)
+
+// RedactedValue replaces the values of sensitive keys in logged content
+const RedactedValue = "[REDACTED]"
+
+// DefaultRedactKeys are the keys redacted by a Redactor when none are given
+var DefaultRedactKeys = []string{"authorization", "password", "token"}
+
+// Redactor scrubs the values of sensitive keys from JSON payloads before they are logged
+//
+// Keys are matched case insensitively, and any key containing one of the redacted keys
+// is redacted (e.g. "token" matches both "Token" and "github_token").
+type Redactor struct {
+	keys []string
+}
+
+// NewRedactor returns a Redactor for the given keys, or DefaultRedactKeys if none are given
+func NewRedactor(keys ...string) *Redactor {
+	if len(keys) == 0 {
+		keys = DefaultRedactKeys
+	}
+
+	lowerKeys := make([]string, len(keys))
+	for i, key := range keys {
+		lowerKeys[i] = strings.ToLower(key)
+	}
+
+	return &Redactor{keys: lowerKeys}
+}
+
+// RedactJSON returns a copy of a JSON payload with the values of sensitive keys
+// replaced with RedactedValue, at any depth
+//
+// Any values given (e.g. resolved secrets) are also replaced with RedactedValue
+// wherever they appear in the payload's keys and strings. This is done to the
+// decoded payload, so values can't escape redaction by being escaped in the JSON.
+//
+// Payloads that are not valid JSON can't be scrubbed, so RedactedValue is returned in their place.
+func (r *Redactor) RedactJSON(data []byte, values ...string) []byte {
+	var payload interface{}
+
+	err := json.Unmarshal(data, &payload)
+	if err != nil {
+		return []byte(`"` + RedactedValue + `"`)
+	}
+
+	redacted, err := json.Marshal(r.redactValue(payload, values))
+	if err != nil {
+		return []byte(`"` + RedactedValue + `"`)
+	}
+
+	return redacted
+}
+
+func (r *Redactor) isSensitive(key string) bool {
+	key = strings.ToLower(key)
+
+	for _, sensitive := range r.keys {
+		if strings.Contains(key, sensitive) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Redactor) redactValue(value interface{}, values []string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for key, nested := range v {
+			if r.isSensitive(key) {
+				redacted[redactString(key, values)] = RedactedValue
+				continue
+			}
+
+			redacted[redactString(key, values)] = r.redactValue(nested, values)
+		}
+		return redacted
+
+	case []interface{}:
+		for i, nested := range v {
+			v[i] = r.redactValue(nested, values)
+		}
+		return v
+
+	case string:
+		return redactString(v, values)
+
+	default:
+		return v
+	}
+}
+
+// redactString replaces each of values in s with RedactedValue
+func redactString(s string, values []string) string {
+	for _, value := range values {
+		if value != "" {
+			s = strings.ReplaceAll(s, value, RedactedValue)
+		}
+	}
+
+	return s
+}
@@ -0,0 +1,61 @@
+package logs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactorRedactJSON(t *testing.T) {
+	type testCase struct {
+		name     string
+		keys     []string
+		values   []string
+		payload  string
+		expected string
+	}
+
+	tests := []testCase{
+		{
+			name:     "Default keys",
+			payload:  `{"user": "casey", "password": "hunter2", "headers": {"Authorization": "Bearer abc"}}`,
+			expected: `{"user": "casey", "password": "[REDACTED]", "headers": {"Authorization": "[REDACTED]"}}`,
+		},
+		{
+			name:     "Keys containing a redacted key",
+			payload:  `{"github_token": "abc", "tokens": ["a", "b"]}`,
+			expected: `{"github_token": "[REDACTED]", "tokens": "[REDACTED]"}`,
+		},
+		{
+			name:     "Nested in arrays",
+			payload:  `{"items": [{"token": "abc", "id": 1}]}`,
+			expected: `{"items": [{"token": "[REDACTED]", "id": 1}]}`,
+		},
+		{
+			name:     "Custom keys",
+			keys:     []string{"Email"},
+			payload:  `{"email": "casey@example.com", "token": "abc"}`,
+			expected: `{"email": "[REDACTED]", "token": "abc"}`,
+		},
+		{
+			name:     "Values",
+			values:   []string{`s3"cr3t`, ""},
+			payload:  `{"header": "Bearer s3\"cr3t", "s3\"cr3t": ["s3\"cr3t"], "id": 1}`,
+			expected: `{"header": "Bearer [REDACTED]", "[REDACTED]": ["[REDACTED]"], "id": 1}`,
+		},
+		{
+			name:     "Invalid JSON",
+			payload:  `password=hunter2`,
+			expected: `"[REDACTED]"`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			redactor := NewRedactor(tc.keys...)
+
+			redacted := redactor.RedactJSON([]byte(tc.payload), tc.values...)
+			assert.JSONEq(t, tc.expected, string(redacted))
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package nats
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Activity types describe what a message published to a sequence is
+const (
+	ActivityCompleted     = "completed"
+	ActivityDeadline      = "deadline"
+	ActivityError         = "error"
+	ActivityEvent         = "event"
+	ActivityHops          = "hops"
+	ActivityProgress      = "progress"
+	ActivityRequest       = "request"
+	ActivityResult        = "result"
+	ActivityTimeout       = "timeout"
+	ActivityWouldDispatch = "would_dispatch"
+	// ActivityMessage is any other message, e.g. published to a sequence with Publish
+	ActivityMessage = "message"
+)
+
+type (
+	// Activity is a message published to a sequence, as seen live by SubscribeActivity
+	Activity struct {
+		Channel    string    `json:"channel"`
+		MessageId  string    `json:"message_id"`
+		SequenceId string    `json:"sequence_id"`
+		Subject    string    `json:"subject"`
+		Timestamp  time.Time `json:"timestamp"`
+		Type       string    `json:"type"`
+	}
+
+	// ActivityHandler is called with each Activity seen by SubscribeActivity
+	ActivityHandler func(activity Activity)
+)
+
+// SubscribeActivity calls handler with every message published to the account's
+// sequences (or only the sequence sequenceId, if set) until ctx is done
+//
+// Messages are seen via a core NATS subscription, so only those published whilst
+// subscribed are seen and nothing is acked. Handler is called for one message
+// at a time and should return quickly, as slow handlers delay later messages.
+func (c *Client) SubscribeActivity(ctx context.Context, sequenceId string, handler ActivityHandler) error {
+	if sequenceId == "" {
+		sequenceId = "*"
+	} else if strings.ContainsAny(sequenceId, ".*> ") {
+		return fmt.Errorf("Invalid sequence ID '%s'", sequenceId)
+	}
+
+	// Every channel of the account is matched, but newActivity only describes
+	// notify and request messages, as Parse rejects any other channel
+	subject := strings.Join([]string{c.accountId, c.interestTopic, "*", sequenceId, ">"}, ".")
+
+	sub, err := c.NatsConn.Subscribe(subject, func(msg *nats.Msg) {
+		activity, ok := newActivity(msg)
+		if !ok {
+			return
+		}
+
+		handler(activity)
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to subscribe to activity: %w", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		sub.Unsubscribe()
+	}()
+
+	return nil
+}
+
+// newActivity describes a message published to a sequence, returning false if
+// it isn't a hops message (i.e. on the notify or request channel)
+func newActivity(msg *nats.Msg) (Activity, bool) {
+	receivedAt := time.Now()
+
+	m, err := Parse(&memoryMsg{
+		data:      msg.Data,
+		header:    msg.Header,
+		subject:   msg.Subject,
+		timestamp: receivedAt,
+	})
+	if err != nil {
+		return Activity{}, false
+	}
+
+	return Activity{
+		Channel:    m.Channel,
+		MessageId:  m.MessageId,
+		SequenceId: m.SequenceId,
+		Subject:    msg.Subject,
+		Timestamp:  receivedAt,
+		Type:       activityType(m),
+	}, true
+}
+
+func activityType(m *MsgMeta) string {
+	switch {
+	case m.Channel == ChannelRequest:
+		return ActivityRequest
+	case m.Done:
+		return ActivityResult
+	case m.Progress:
+		return ActivityProgress
+	case m.Completed:
+		return ActivityCompleted
+	case m.Deadline:
+		return ActivityDeadline
+	case m.TimedOut:
+		return ActivityTimeout
+	case m.WouldDispatch:
+		return ActivityWouldDispatch
+	case m.SequenceError:
+		return ActivityError
+	case m.MessageId == SourceEventId:
+		return ActivityEvent
+	case m.MessageId == HopsMessageId:
+		return ActivityHops
+	default:
+		return ActivityMessage
+	}
+}
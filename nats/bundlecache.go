@@ -0,0 +1,171 @@
+package nats
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultBundleCacheTTL is how long the messages of a sequence stay cached after
+// it was last fetched, unless set with WithBundleCache
+const DefaultBundleCacheTTL = 10 * time.Minute
+
+type (
+	// bundleCache holds the messages already fetched for recent sequences, so
+	// FetchOrderedMessages only fetches those published since
+	//
+	// Stream sequences only increase, so every message in a sequence up to the
+	// last one fetched is already held. Sequences not fetched for ttl are
+	// dropped, as are the least recently fetched once the messages held exceed
+	// maxBytes.
+	bundleCache struct {
+		maxBytes  int
+		mu        sync.Mutex
+		now       func() time.Time
+		order     []string
+		sequences map[string]*cachedBundle
+		size      int
+		ttl       time.Duration
+	}
+
+	// cachedBundle is the messages of a sequence, fetched up to a stream sequence
+	cachedBundle struct {
+		entries   []MessageEntry
+		fetchedAt time.Time
+		fetchedTo uint64
+		size      int
+	}
+)
+
+// newBundleCache returns a cache of up to maxBytes of messages, each sequence
+// kept for ttl, or nil if maxBytes is below 1 (a nil cache holds nothing)
+func newBundleCache(maxBytes int, ttl time.Duration) *bundleCache {
+	if maxBytes < 1 {
+		return nil
+	}
+	if ttl <= 0 {
+		ttl = DefaultBundleCacheTTL
+	}
+
+	return &bundleCache{
+		maxBytes:  maxBytes,
+		now:       time.Now,
+		sequences: map[string]*cachedBundle{},
+		ttl:       ttl,
+	}
+}
+
+// get returns a copy of the cached messages of a sequence up to stream sequence
+// upTo, along with the stream sequence they were fetched to (0 if none are cached)
+func (b *bundleCache) get(key string, upTo uint64) ([]MessageEntry, uint64) {
+	if b == nil {
+		return nil, 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.evictExpired()
+
+	cached, ok := b.sequences[key]
+	if !ok {
+		return nil, 0
+	}
+
+	// A redelivered message is given the sequence as it was when it was published
+	fetchedTo := cached.fetchedTo
+	if fetchedTo > upTo {
+		fetchedTo = upTo
+	}
+
+	entries := make([]MessageEntry, 0, len(cached.entries))
+	for _, entry := range cached.entries {
+		if entry.StreamSequence > fetchedTo {
+			break
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, fetchedTo
+}
+
+// put caches the messages of a sequence fetched up to stream sequence fetchedTo,
+// unless more recent messages are already cached
+//
+// Sequences larger than the whole cache aren't cached at all.
+func (b *bundleCache) put(key string, entries []MessageEntry, fetchedTo uint64) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if cached, ok := b.sequences[key]; ok && cached.fetchedTo > fetchedTo {
+		return
+	}
+
+	size := 0
+	for _, entry := range entries {
+		size += len(entry.Key) + len(entry.Data)
+	}
+
+	b.drop(key)
+	if size > b.maxBytes {
+		return
+	}
+
+	b.sequences[key] = &cachedBundle{
+		entries:   append([]MessageEntry(nil), entries...),
+		fetchedAt: b.now(),
+		fetchedTo: fetchedTo,
+		size:      size,
+	}
+	b.order = append(b.order, key)
+	b.size += size
+
+	b.evictExpired()
+	for b.size > b.maxBytes {
+		b.drop(b.order[0])
+	}
+}
+
+// invalidate drops the cached messages of a sequence, e.g. once it's purged
+func (b *bundleCache) invalidate(key string) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.drop(key)
+}
+
+// evictExpired drops the sequences not fetched for ttl, which are at the least
+// recently fetched end of order
+func (b *bundleCache) evictExpired() {
+	expiredBefore := b.now().Add(-b.ttl)
+
+	for len(b.order) > 0 && b.sequences[b.order[0]].fetchedAt.Before(expiredBefore) {
+		b.drop(b.order[0])
+	}
+}
+
+// drop removes a sequence from the cache, if it's cached
+func (b *bundleCache) drop(key string) {
+	cached, ok := b.sequences[key]
+	if !ok {
+		return
+	}
+
+	delete(b.sequences, key)
+	b.size -= cached.size
+
+	for i, k := range b.order {
+		if k == key {
+			b.order = append(b.order[:i], b.order[i+1:]...)
+			return
+		}
+	}
+}
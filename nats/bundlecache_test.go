@@ -0,0 +1,159 @@
+package nats
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBundleCache(t *testing.T) {
+	cache := newBundleCache(1024, time.Minute)
+	entries := []MessageEntry{
+		{Key: "event", StreamSequence: 1},
+		{Key: "first", StreamSequence: 3},
+		{Key: "second", StreamSequence: 5},
+	}
+
+	cache.put("a", entries, 6)
+
+	cached, fetchedTo := cache.get("a", 8)
+	assert.Equal(t, entries, cached)
+	assert.Equal(t, uint64(6), fetchedTo)
+
+	cached, fetchedTo = cache.get("a", 4)
+	assert.Equal(t, entries[:2], cached, "Earlier messages should get the sequence as it was")
+	assert.Equal(t, uint64(4), fetchedTo)
+
+	cached[0].Key = "changed"
+	cached, _ = cache.get("a", 6)
+	assert.Equal(t, "event", cached[0].Key, "Cached entries should not be changed by callers")
+
+	cache.put("a", entries[:1], 2)
+	_, fetchedTo = cache.get("a", 6)
+	assert.Equal(t, uint64(6), fetchedTo, "Older fetches should not replace newer ones")
+
+	cache.invalidate("a")
+	cached, fetchedTo = cache.get("a", 7)
+	assert.Nil(t, cached)
+	assert.Zero(t, fetchedTo)
+	assert.Zero(t, cache.size, "Invalidated sequences should not count towards the cache's size")
+
+	var disabled *bundleCache
+	disabled.put("a", entries, 6)
+	_, fetchedTo = disabled.get("a", 6)
+	assert.Zero(t, fetchedTo, "Nil caches should hold nothing")
+	assert.Nil(t, newBundleCache(0, time.Minute), "Caches without any bytes should be disabled")
+}
+
+func TestBundleCacheMaxBytes(t *testing.T) {
+	// Each sequence is 10 bytes, so only two fit
+	cache := newBundleCache(25, time.Minute)
+	entries := []MessageEntry{{Key: "event", Data: []byte("12345"), StreamSequence: 1}}
+
+	cache.put("a", entries, 1)
+	cache.put("b", entries, 1)
+	cache.put("a", entries, 2)
+	cache.put("c", entries, 1)
+
+	_, fetchedTo := cache.get("b", 1)
+	assert.Zero(t, fetchedTo, "Least recently fetched sequence should be dropped")
+	_, fetchedTo = cache.get("a", 2)
+	assert.Equal(t, uint64(2), fetchedTo)
+	_, fetchedTo = cache.get("c", 1)
+	assert.Equal(t, uint64(1), fetchedTo)
+	assert.Equal(t, 20, cache.size)
+
+	large := []MessageEntry{{Key: "event", Data: make([]byte, 30), StreamSequence: 1}}
+	cache.put("a", large, 3)
+	_, fetchedTo = cache.get("a", 3)
+	assert.Zero(t, fetchedTo, "Sequences larger than the cache should not be cached")
+	_, fetchedTo = cache.get("c", 1)
+	assert.Equal(t, uint64(1), fetchedTo, "Sequences too large to cache should not evict others")
+	assert.Equal(t, 10, cache.size)
+}
+
+func TestBundleCacheTTL(t *testing.T) {
+	now := time.Now()
+	cache := newBundleCache(1024, time.Minute)
+	cache.now = func() time.Time { return now }
+	entries := []MessageEntry{{Key: "event", StreamSequence: 1}}
+
+	cache.put("a", entries, 1)
+	now = now.Add(30 * time.Second)
+	cache.put("b", entries, 1)
+
+	now = now.Add(45 * time.Second)
+	_, fetchedTo := cache.get("a", 1)
+	assert.Zero(t, fetchedTo, "Sequences not fetched within the TTL should be dropped")
+	_, fetchedTo = cache.get("b", 1)
+	assert.Equal(t, uint64(1), fetchedTo)
+
+	// Fetching again keeps a sequence for another TTL
+	cache.put("b", entries, 2)
+	now = now.Add(45 * time.Second)
+	_, fetchedTo = cache.get("b", 2)
+	assert.Equal(t, uint64(2), fetchedTo)
+	assert.Len(t, cache.sequences, 1)
+}
+
+func TestClientFetchOrderedMessagesCached(t *testing.T) {
+	ctx := context.Background()
+	hopsNats, cleanup := setupClient(ctx, t)
+	defer cleanup()
+
+	arrivals := []*MsgMeta{}
+	publish := func(channel string, tokens ...string) {
+		pubAck, _, err := hopsNats.Publish(ctx, []byte(tokens[0]), append([]string{channel, "SEQ_ID"}, tokens...)...)
+		require.NoError(t, err, "Test setup: Message should be published")
+
+		// Another sequence is interleaved, which should never be included
+		_, _, err = hopsNats.Publish(ctx, []byte("other"), channel, "OTHER_SEQ_ID", tokens[0])
+		require.NoError(t, err, "Test setup: Message should be published")
+
+		arrivals = append(arrivals, &MsgMeta{
+			AccountId:      hopsNats.AccountId(),
+			InterestTopic:  hopsNats.InterestTopic(),
+			SequenceId:     "SEQ_ID",
+			StreamSequence: pubAck.Sequence,
+		})
+	}
+
+	publish(ChannelNotify, SourceEventId)
+	publish(ChannelRequest, "first", "app", "handler")
+	publish(ChannelRequest, "second", "app", "handler")
+	publish(ChannelNotify, "first", DoneMessageId)
+	publish(ChannelNotify, "second", ProgressMessageId)
+	publish(ChannelNotify, "second", DoneMessageId)
+
+	fetch := func(incomingMsg *MsgMeta) []MessageEntry {
+		entries, err := hopsNats.FetchOrderedMessages(ctx, incomingMsg)
+		require.NoError(t, err, "Messages should be fetched without error")
+		return entries
+	}
+	fetchUncached := func(incomingMsg *MsgMeta) []MessageEntry {
+		cache := hopsNats.bundleCache
+		defer func() { hopsNats.bundleCache = cache }()
+
+		hopsNats.bundleCache = nil
+		return fetch(incomingMsg)
+	}
+
+	hopsNats.bundleCache = newBundleCache(1<<20, DefaultBundleCacheTTL)
+
+	for _, incomingMsg := range arrivals {
+		assert.Equal(t, fetchUncached(incomingMsg), fetch(incomingMsg), "Cached and uncached fetches should match")
+	}
+
+	// Redelivered messages are given the sequence as it was when they were published
+	assert.Equal(t, fetchUncached(arrivals[2]), fetch(arrivals[2]))
+	assert.Len(t, fetch(arrivals[len(arrivals)-1]), 5, "Progress should be left out, requests included")
+
+	_, err := hopsNats.PurgeSequence(ctx, "SEQ_ID")
+	require.NoError(t, err)
+	cached, fetchedTo := hopsNats.bundleCache.get(arrivals[0].SequenceFilter(), arrivals[len(arrivals)-1].StreamSequence)
+	assert.Nil(t, cached, "Purging should invalidate the cached sequence")
+	assert.Zero(t, fetchedTo)
+}
@@ -3,8 +3,12 @@ package nats
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -19,81 +23,249 @@ const (
 	DefaultConsumerName = "runner"
 	// How far back to look for events by default
 	DefaultEventLookback = -time.Hour
+	// How far back from when events are listed up to they can be listed from
+	MaxEventLookback = -24 * time.Hour
 
 	// Interest topic which is used by default
 	DefaultInterestTopic = "default"
 
+	// Namespace that system subjects are published under by default
+	DefaultSystemNamespace = "hiphops-system"
+
 	// Number of events returned max
 	GetEventHistoryEventLimit = 100
 
+	// Max number of messages in a sequence that can be replayed by WithSequenceReplay
+	MaxReplayMessages = 500
+
+	// Max number of messages in a sequence returned by GetSequenceMessages
+	MaxSequenceMessages = 500
+
+	// Key/value bucket that results of handled requests are stored in (see WithIdempotencyStore)
+	IdempotencyBucket = "idempotency"
+
+	// AckWait of the consumers created by WithWorker, unless configured otherwise
+	DefaultWorkerAckWait = time.Minute
+
+	// Key/value bucket that worker heartbeats are stored in
+	WorkersBucket = "workers"
+	// How long heartbeats are kept for, after which a worker is no longer listed
+	WorkersBucketTTL = time.Hour
+
+	// Prefix of the MessageBundle keys that request messages are held under.
+	// Subject tokens can't contain '.', so these never clash with message IDs
+	requestBundlePrefix = "request."
+
 	// limits for GetEventHistory
 	defaultBatchSize = 160
 	maxWaitTime      = time.Second
+
+	ConnectionClosed       ConnectionState = "closed"
+	ConnectionDisconnected ConnectionState = "disconnected"
+	ConnectionReconnected  ConnectionState = "reconnected"
 )
 
 var nameReplacer = strings.NewReplacer("*", "all", ".", "dot", ">", "children")
 
+// ErrWorkerScopeOverlap is returned by WithWorker when another of the app's
+// worker consumers already receives some of the same requests
+var ErrWorkerScopeOverlap = errors.New("Worker consumers of an app can't share handlers")
+
 type (
 	Client struct {
-		Consumers     map[string]jetstream.Consumer
-		JetStream     jetstream.JetStream
-		NatsConn      *nats.Conn
-		SysObjStore   nats.ObjectStore
-		accountId     string
-		interestTopic string
-		logger        Logger
-		streamName    string
+		Consumers      map[string]jetstream.Consumer
+		JetStream      jetstream.JetStream
+		NatsConn       *nats.Conn
+		SysObjStore    nats.ObjectStore
+		accountId      string
+		ackPolicy      jetstream.AckPolicy
+		bundleCache    *bundleCache
+		connHandlers   []ConnectionStateHandler
+		connHandlersMu sync.RWMutex
+		connectOpts    []nats.Option
+		deadLetterSubj string
+		idempotencyKV  nats.KeyValue
+		interestTopic  string
+		logger         Logger
+		memStore       *memoryStore
+		namePrefix     string
+		pause          pauseState
+		replayMode     string
+		seqConcurrency int
+		servers        []string
+		streamName     string
+		systemNs       string
+		workerConsConf WorkerConsumerConfig
+		workerCreated  map[string]bool
+		workerScopes   map[string][]string
+		workersKV      nats.KeyValue
+		workersKVMu    sync.Mutex
 	}
 
 	// ClientOpt functions configure a nats.Client via NewClient()
 	ClientOpt func(*Client) error
 
+	// ConnectionState is the state a NATS connection has transitioned to
+	ConnectionState string
+
+	// ConnectionStateHandler is called whenever the NATS connection changes state,
+	// along with the underlying error (if any)
+	ConnectionStateHandler func(state ConnectionState, err error)
+
 	// MessageBundle is a map of messageIDs and the data that message contained
 	//
 	// MessageBundle is designed to be passed to a runner to ensure it has the aggregate state
-	// of a hiphops sequence of messages.
+	// of a hiphops sequence of messages. Requests dispatched in the sequence are included
+	// too, under keys built with RequestBundleKey. Any error event a runner has
+	// reported for the sequence is under ErrorMessageId.
+	//
+	// Being a map, iteration order is random. FetchOrderedMessages (or
+	// BundleOrderFromContext in a SequenceHandler) gives the messages in order.
 	MessageBundle map[string][]byte
 
+	// MessageEntry is a single message of a MessageBundle, along with the stream
+	// sequence that orders it within the sequence of messages
+	MessageEntry struct {
+		Data           []byte
+		Key            string
+		StreamSequence uint64
+	}
+
+	// PublishItem is a single message to be published via PublishBatch
+	PublishItem struct {
+		Data       []byte
+		SubjTokens []string
+	}
+
+	// PublishResult is the outcome of publishing a single PublishItem
+	PublishResult struct {
+		Err     error
+		PubAck  *jetstream.PubAck
+		Sent    bool
+		Subject string
+	}
+
 	// SequenceHandler is a function that receives the sequenceId and message bundle for a sequence of messages
+	//
+	// Returning an error naks the message, so it's redelivered later. Errors that
+	// would happen again however often the message is retried should be reported
+	// by the handler and nil returned instead.
 	SequenceHandler interface {
 		SequenceCallback(context.Context, string, MessageBundle) error
 	}
+
+	// WorkerConsumerConfig is the delivery config of the consumers created by WithWorker
+	//
+	// Zero values are left to the server's defaults (or DefaultWorkerAckWait for
+	// AckWait), and aren't compared against the config of an existing consumer.
+	// If BackOff is set, the server uses its first value in place of AckWait, and
+	// MaxDeliver must exceed its length.
+	WorkerConsumerConfig struct {
+		AckWait       time.Duration
+		BackOff       []time.Duration
+		MaxAckPending int
+		MaxDeliver    int
+		// Reconfigure updates an existing consumer whose config differs, rather than
+		// leaving it unchanged. This changes delivery for every worker of the app.
+		Reconfigure bool
+	}
+
+	// WorkerHeartbeat is the liveness report periodically published by a running worker
+	WorkerHeartbeat struct {
+		AppName    string    `json:"app_name"`
+		Handlers   []string  `json:"handlers"`
+		InFlight   int64     `json:"in_flight"`
+		InstanceId string    `json:"instance_id"`
+		Paused     bool      `json:"paused"`
+		Stale      bool      `json:"stale"`
+		Timestamp  time.Time `json:"timestamp"`
+		Version    string    `json:"version,omitempty"`
+	}
+
+	bundleFetchCtxKey struct{}
+
+	bundleOrderCtxKey struct{}
+
+	consumeStopCtxKey struct{}
 )
 
 // NewClient returns a new hiphops specific NATS client
 //
+// natsUrl may be a comma separated list of server URLs, allowing the client to fail
+// over across a cluster. It may be empty if servers are given via WithServers instead.
+//
+// logger may be nil, in which case nothing is logged.
+//
 // By default it is configured as a runner consumer (listening for incoming source events)
 // Passing *any* ClientOpts will override this default.
 func NewClient(natsUrl string, accountId string, interestTopic string, logger Logger, clientOpts ...ClientOpt) (*Client, error) {
-	ctx := context.Background()
+	if logger == nil {
+		logger = noopLogger{}
+	}
 
 	natsClient := &Client{
 		Consumers:     map[string]jetstream.Consumer{},
 		accountId:     accountId,
 		interestTopic: interestTopic,
+		servers:       parseServers(natsUrl),
 		// Override this using WithStreamName ClientOpt if required.
 		streamName: nameReplacer.Replace(accountId),
 		logger:     logger,
+		// Override this using WithSystemNamespace ClientOpt if required.
+		systemNs: DefaultSystemNamespace,
 	}
-	err := natsClient.initNatsConnection(natsUrl)
-	if err != nil {
-		return nil, err
+
+	if len(clientOpts) == 0 {
+		clientOpts = DefaultClientOpts()
 	}
 
-	err = natsClient.initJetStream()
-	if err != nil {
-		defer natsClient.Close()
-		return nil, err
+	// The connection is opened by the first ClientOpt that requires it,
+	// or after all ClientOpts have been applied
+	for _, opt := range clientOpts {
+		err := opt(natsClient)
+		if err != nil {
+			defer natsClient.Close()
+			return nil, err
+		}
 	}
 
-	err = natsClient.initObjectStore(ctx, accountId)
+	err := natsClient.connect()
 	if err != nil {
 		defer natsClient.Close()
 		return nil, err
 	}
 
-	if len(clientOpts) == 0 {
-		clientOpts = DefaultClientOpts()
+	logger.Debugf("Interest topic is: %s", natsClient.interestTopic)
+
+	return natsClient, err
+}
+
+// NewCoreClient returns a client that uses plain core NATS rather than JetStream,
+// for lightweight deployments without streams or pre-provisioned consumers
+//
+// Sequences are consumed with a queue subscription to the account's notify
+// subjects, and their messages held in memory (up to DefaultCoreSequenceLimit
+// sequences) in place of a stream. Delivery is at-most-once: messages published
+// whilst nothing is subscribed, or that fail to be processed, are lost, as is all
+// sequence history when the process exits. Runners sharing a queue group only see
+// the messages delivered to them, so a single runner per account is recommended.
+//
+// Only what the runner uses is supported, i.e. consuming sequences, publishing,
+// getting messages and the system object store. Workers still require JetStream.
+func NewCoreClient(natsUrl string, accountId string, interestTopic string, logger Logger, clientOpts ...ClientOpt) (*Client, error) {
+	if logger == nil {
+		logger = noopLogger{}
+	}
+
+	natsClient := &Client{
+		Consumers:     map[string]jetstream.Consumer{},
+		accountId:     accountId,
+		interestTopic: interestTopic,
+		logger:        logger,
+		memStore:      newMemoryStore(DefaultCoreSequenceLimit),
+		servers:       parseServers(natsUrl),
+		streamName:    nameReplacer.Replace(accountId),
+		systemNs:      DefaultSystemNamespace,
 	}
 
 	for _, opt := range clientOpts {
@@ -104,9 +276,149 @@ func NewClient(natsUrl string, accountId string, interestTopic string, logger Lo
 		}
 	}
 
-	logger.Debugf("Interest topic is: %s", natsClient.interestTopic)
+	err := natsClient.connect()
+	if err != nil {
+		defer natsClient.Close()
+		return nil, err
+	}
 
-	return natsClient, err
+	logger.Debugf("Using core NATS, interest topic is: %s", natsClient.interestTopic)
+
+	return natsClient, nil
+}
+
+// NewMessageBundle returns a message bundle holding the given messages, with later
+// messages replacing earlier ones of the same key
+func NewMessageBundle(entries []MessageEntry) MessageBundle {
+	msgBundle := MessageBundle{}
+	for _, entry := range entries {
+		msgBundle[entry.Key] = entry.Data
+	}
+
+	return msgBundle
+}
+
+// BundleFetchDurationFromContext returns how long the message bundle given to a
+// SequenceHandler took to fetch, if known
+func BundleFetchDurationFromContext(ctx context.Context) (time.Duration, bool) {
+	duration, ok := ctx.Value(bundleFetchCtxKey{}).(time.Duration)
+	return duration, ok
+}
+
+// BundleOrderFromContext returns the messages of the bundle given to a
+// SequenceHandler in the order they were published, if known
+//
+// MessageBundle is a map, so iterating it gives a different order each time.
+// Handlers depending on message order should iterate these instead.
+func BundleOrderFromContext(ctx context.Context) ([]MessageEntry, bool) {
+	entries, ok := ctx.Value(bundleOrderCtxKey{}).([]MessageEntry)
+	return entries, ok
+}
+
+// ContextWithStop returns a copy of ctx along with a function that stops any
+// Consume or ConsumeSequences call given it, without cancelling ctx itself
+//
+// Messages already received are still processed with ctx once consuming stops,
+// so they can finish and be acked. This allows for graceful shutdown, as
+// cancelling ctx instead abandons messages part way through.
+func ContextWithStop(ctx context.Context) (context.Context, context.CancelFunc) {
+	stop := make(chan struct{})
+	var once sync.Once
+
+	stopFunc := func() {
+		once.Do(func() { close(stop) })
+	}
+
+	return context.WithValue(ctx, consumeStopCtxKey{}, (<-chan struct{})(stop)), stopFunc
+}
+
+// RequestBundleKey returns the key the request message for a call is held under
+// in a MessageBundle
+func RequestBundleKey(callSlug string) string {
+	return requestBundlePrefix + callSlug
+}
+
+// DispatchedCalls returns the slugs of the calls that requests have been dispatched
+// for, in alphabetical order
+func (m MessageBundle) DispatchedCalls() []string {
+	callSlugs := []string{}
+	for k := range m {
+		callSlug, ok := strings.CutPrefix(k, requestBundlePrefix)
+		if ok {
+			callSlugs = append(callSlugs, callSlug)
+		}
+	}
+
+	sort.Strings(callSlugs)
+	return callSlugs
+}
+
+// HasRequest returns whether a request has already been dispatched for a call
+func (m MessageBundle) HasRequest(callSlug string) bool {
+	_, ok := m[RequestBundleKey(callSlug)]
+	return ok
+}
+
+// WithoutRequests returns a copy of the bundle holding only the events and results
+// of the sequence, as a hops config is evaluated against
+func (m MessageBundle) WithoutRequests() MessageBundle {
+	bundle := MessageBundle{}
+	for k, v := range m {
+		if !strings.HasPrefix(k, requestBundlePrefix) {
+			bundle[k] = v
+		}
+	}
+
+	return bundle
+}
+
+// apply sets the configured fields on a consumer's config
+func (w WorkerConsumerConfig) apply(cfg *jetstream.ConsumerConfig) {
+	if w.AckWait > 0 {
+		cfg.AckWait = w.AckWait
+	}
+	if len(w.BackOff) > 0 {
+		cfg.BackOff = w.BackOff
+	}
+	if w.MaxAckPending != 0 {
+		cfg.MaxAckPending = w.MaxAckPending
+	}
+	if w.MaxDeliver != 0 {
+		cfg.MaxDeliver = w.MaxDeliver
+	}
+}
+
+// diff describes how an existing consumer's config differs from the requested
+// config, for the fields that are configured
+func (w WorkerConsumerConfig) diff(requested jetstream.ConsumerConfig, existing jetstream.ConsumerConfig) []string {
+	diffs := []string{}
+
+	if requested.FilterSubject != existing.FilterSubject {
+		diffs = append(diffs, fmt.Sprintf("FilterSubject %s != %s", existing.FilterSubject, requested.FilterSubject))
+	}
+	if strings.Join(requested.FilterSubjects, ",") != strings.Join(existing.FilterSubjects, ",") {
+		diffs = append(diffs, fmt.Sprintf("FilterSubjects %v != %v", existing.FilterSubjects, requested.FilterSubjects))
+	}
+	// The server replaces AckWait with the first BackOff, so it can't be compared
+	if w.AckWait > 0 && len(w.BackOff) == 0 && w.AckWait != existing.AckWait {
+		diffs = append(diffs, fmt.Sprintf("AckWait %s != %s", existing.AckWait, w.AckWait))
+	}
+	if len(w.BackOff) > 0 && !equalDurations(w.BackOff, existing.BackOff) {
+		diffs = append(diffs, fmt.Sprintf("BackOff %v != %v", existing.BackOff, w.BackOff))
+	}
+	if w.MaxAckPending != 0 && w.MaxAckPending != existing.MaxAckPending {
+		diffs = append(diffs, fmt.Sprintf("MaxAckPending %d != %d", existing.MaxAckPending, w.MaxAckPending))
+	}
+	if w.MaxDeliver != 0 && w.MaxDeliver != existing.MaxDeliver {
+		diffs = append(diffs, fmt.Sprintf("MaxDeliver %d != %d", existing.MaxDeliver, w.MaxDeliver))
+	}
+
+	return diffs
+}
+
+// AccountId returns the ID of the account the client publishes and consumes for
+func (c *Client) AccountId() string {
+	return c.accountId
 }
 
 func (c *Client) CheckConnection() bool {
@@ -115,42 +427,92 @@ func (c *Client) CheckConnection() bool {
 }
 
 func (c *Client) Close() {
+	if c.NatsConn == nil {
+		return
+	}
+
 	c.NatsConn.Drain()
 }
 
 // Consume consumes messages from the HopsNats.Consumers[fromConsumer]
 //
-// This will block the calling goroutine until the context is cancelled
-// and can be ran as a long-lived service
-func (c *Client) Consume(ctx context.Context, fromConsumer string, callback jetstream.MessageHandler) error {
+// This will block the calling goroutine until the context is cancelled (or
+// stopped, see ContextWithStop) and can be ran as a long-lived service. No
+// messages are pulled whilst the client is paused (see Pause).
+//
+// Messages from consumers with AckNonePolicy are never redelivered, so acking
+// them does nothing (see WithAckPolicy).
+func (c *Client) Consume(ctx context.Context, fromConsumer string, callback jetstream.MessageHandler, opts ...jetstream.PullConsumeOpt) error {
+	if c.memStore != nil {
+		return c.consumeCore(ctx, fromConsumer, callback)
+	}
+
 	consumer, found := c.Consumers[fromConsumer]
 	if !found {
 		return fmt.Errorf("Consumer '%s' not found on client", fromConsumer)
 	}
 
-	consumerCtx, err := consumer.Consume(callback)
-	if err != nil {
-		return err
+	if consumerAckPolicy(consumer) == jetstream.AckNonePolicy {
+		ackedCallback := callback
+		callback = func(msg jetstream.Msg) {
+			ackedCallback(noAckMsg{msg})
+		}
 	}
-	defer consumerCtx.Stop()
 
-	// Run until context cancelled
-	<-ctx.Done()
+	defer c.pause.startConsuming()()
+
+	// Consuming stops whilst paused, starting again once resumed
+	for c.waitUntilResumed(ctx) {
+		stopPulling := c.pause.startPulling()
+
+		consumerCtx, err := consumer.Consume(callback, opts...)
+		if err != nil {
+			stopPulling()
+			return err
+		}
+
+		paused := c.waitUntilPausedOrStopped(ctx)
+		consumerCtx.Stop()
+		stopPulling()
+
+		if !paused {
+			break
+		}
+	}
 
 	return nil
 }
 
 // ConsumeSequences is a wrapper around consume that presents the aggregate state of a sequence to the callback
 // instead of individual messages.
+//
+// Messages are processed one at a time unless the client is created
+// WithSequenceConcurrency. Messages of the same sequence are never processed at once.
+//
+// Failed messages are retried by redelivery, so consumers with AckNonePolicy
+// are rejected.
 func (c *Client) ConsumeSequences(ctx context.Context, fromConsumer string, handler SequenceHandler) error {
+	if consumer, ok := c.Consumers[fromConsumer]; ok && consumerAckPolicy(consumer) == jetstream.AckNonePolicy {
+		return fmt.Errorf("Consumer '%s' has AckNonePolicy, so can't be used to consume sequences", fromConsumer)
+	}
+
+	// Core messages can't be naked with a delay, so deadlines are held back here
+	var pooledCB jetstream.MessageHandler
+	held := &heldMsgs{}
+
 	wrappedCB := func(msg jetstream.Msg) {
 		hopsMsg, err := Parse(msg)
-		if err != nil {
-			// If parsing is failing, there's no point retrying the message
+		if errors.Is(err, ErrMalformedSubject) {
+			// If the subject is malformed, there's no point retrying the message
 			msg.Term()
 			c.logger.Errf(err, "Unable to parse message")
 			return
 		}
+		if err != nil {
+			msg.NakWithDelay(3 * time.Second)
+			c.logger.Errf(err, "Unable to parse message")
+			return
+		}
 
 		if hopsMsg.MessageId == HopsMessageId {
 			c.logger.Debugf("Skipping 'hops assignment' message")
@@ -163,6 +525,98 @@ func (c *Client) ConsumeSequences(ctx context.Context, fromConsumer string, hand
 			return
 		}
 
+		if hopsMsg.Progress {
+			c.logger.Debugf("Skipping 'progress' message")
+
+			err := DoubleAck(ctx, msg)
+			if err != nil {
+				c.logger.Errf(err, "Unable to ack 'progress' message")
+			}
+
+			return
+		}
+
+		// Would dispatch events are recorded by runners evaluating a replay, so are
+		// skipped for the same reason as error events
+		if hopsMsg.WouldDispatch {
+			c.logger.Debugf("Skipping 'would dispatch' message")
+
+			err := DoubleAck(ctx, msg)
+			if err != nil {
+				c.logger.Errf(err, "Unable to ack 'would dispatch' message")
+			}
+
+			return
+		}
+
+		// Deadlines are held back until due, then evaluated against the whole
+		// sequence, as messages published since may have been evaluated before the
+		// deadline passed. They're never superseded, as that's what they're for.
+		if hopsMsg.Deadline {
+			deadline := DeadlineMsg{}
+			err := json.Unmarshal(msg.Data(), &deadline)
+			if err != nil {
+				msg.Term()
+				c.logger.Errf(err, "Unable to read 'sequence deadline' message")
+				return
+			}
+
+			if wait := time.Until(deadline.Deadline); wait > 0 {
+				if c.memStore != nil {
+					held.hold(wait, func() { pooledCB(msg) })
+					return
+				}
+
+				msg.NakWithDelay(wait)
+				return
+			}
+
+			lastMsg, err := c.lastSequenceMsg(ctx, hopsMsg)
+			if err != nil {
+				msg.NakWithDelay(3 * time.Second)
+				c.logger.Errf(err, "Unable to find latest message in sequence")
+				return
+			}
+			if lastMsg.Sequence > hopsMsg.StreamSequence {
+				hopsMsg.StreamSequence = lastMsg.Sequence
+			}
+		}
+
+		if hopsMsg.TimedOut {
+			c.logger.Debugf("Skipping 'sequence timeout' message")
+
+			err := DoubleAck(ctx, msg)
+			if err != nil {
+				c.logger.Errf(err, "Unable to ack 'sequence timeout' message")
+			}
+
+			return
+		}
+
+		if hopsMsg.Completed {
+			c.logger.Debugf("Skipping 'sequence completed' message")
+
+			err := DoubleAck(ctx, msg)
+			if err != nil {
+				c.logger.Errf(err, "Unable to ack 'sequence completed' message")
+			}
+
+			return
+		}
+
+		// Error events are reported by runners, so must never be evaluated themselves
+		// or they could lead to further errors being reported
+		if hopsMsg.SequenceError {
+			c.logger.Debugf("Skipping 'sequence error' message")
+
+			err := DoubleAck(ctx, msg)
+			if err != nil {
+				c.logger.Errf(err, "Unable to ack 'sequence error' message")
+			}
+
+			return
+		}
+
 		if hopsMsg.Done {
 			// TODO: Actually finalise the pipeline here
 			c.logger.Debugf("Skipping 'pipeline done' message")
@@ -175,14 +629,43 @@ func (c *Client) ConsumeSequences(ctx context.Context, fromConsumer string, hand
 			return
 		}
 
-		msgBundle, err := c.FetchMessageBundle(ctx, hopsMsg)
+		// A newer message in the sequence will be processed with this message's
+		// state included, so there's no need to process this one too
+		superseded := false
+		if !hopsMsg.Deadline {
+			superseded, err = c.isSuperseded(ctx, hopsMsg)
+			if err != nil {
+				c.logger.Errf(err, "Unable to check for newer messages in sequence, processing anyway")
+			}
+		}
+		if superseded {
+			c.logger.Debugf("Skipping message superseded by newer message in sequence")
+
+			err := DoubleAck(ctx, msg)
+			if err != nil {
+				c.logger.Errf(err, "Unable to ack superseded message")
+			}
+
+			return
+		}
+
+		fetchStartedAt := time.Now()
+		entries, err := c.FetchOrderedMessages(ctx, hopsMsg)
 		if err != nil {
 			msg.NakWithDelay(3 * time.Second)
 			c.logger.Errf(err, "Unable to fetch message bundle")
 			return
 		}
 
-		err = handler.SequenceCallback(ctx, hopsMsg.SequenceId, msgBundle)
+		// Handlers can read the fetch time with BundleFetchDurationFromContext, the
+		// message order with BundleOrderFromContext and the account with AccountIdFromContext
+		handlerCtx := context.WithValue(ctx, bundleFetchCtxKey{}, time.Since(fetchStartedAt))
+		handlerCtx = context.WithValue(handlerCtx, bundleOrderCtxKey{}, entries)
+		handlerCtx = context.WithValue(handlerCtx, accountIdCtxKey{}, hopsMsg.AccountId)
+
+		msgBundle := NewMessageBundle(entries)
+
+		err = handler.SequenceCallback(handlerCtx, hopsMsg.SequenceId, msgBundle)
 		if err != nil {
 			c.logger.Errf(err, "Failed to process message")
 			msg.NakWithDelay(3 * time.Second)
@@ -192,18 +675,76 @@ func (c *Client) ConsumeSequences(ctx context.Context, fromConsumer string, hand
 		DoubleAck(ctx, msg)
 	}
 
-	return c.Consume(ctx, fromConsumer, wrappedCB)
+	pool := newSequencePool(c.seqConcurrency)
+	defer pool.Wait()
+	defer held.stop()
+
+	// Blocking until a slot is free stops further messages being delivered,
+	// leaving them unacked in the stream
+	pooledCB = func(msg jetstream.Msg) {
+		pool.Go(sequenceIdFromSubject(msg.Subject()), func() {
+			wrappedCB(msg)
+		})
+	}
+
+	opts := []jetstream.PullConsumeOpt{}
+	if c.seqConcurrency > 0 {
+		// Only fetch as many messages as can be processed, as their AckWait
+		// starts as soon as they're fetched
+		opts = append(opts, jetstream.PullMaxMessages(c.seqConcurrency))
+	}
+
+	return c.Consume(ctx, fromConsumer, pooledCB, opts...)
 }
 
 // FetchMessageBundle pulls all historic messages for a sequenceId from the stream, converting them to a message bundle
 //
+// Request messages are included so callers can tell which calls have already
+// been dispatched (see MessageBundle.HasRequest)
+//
 // The returned message bundle will contain all previous messages in addition to the newly received message
 func (c *Client) FetchMessageBundle(ctx context.Context, incomingMsg *MsgMeta) (MessageBundle, error) {
-	filter := incomingMsg.SequenceFilter()
+	entries, err := c.FetchOrderedMessages(ctx, incomingMsg)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewMessageBundle(entries), nil
+}
+
+// FetchOrderedMessages pulls all historic messages for a sequenceId from the
+// stream, as FetchMessageBundle does, returning them in the order they were published
+//
+// Messages are ordered by stream sequence. If a key is published more than once,
+// each message is included, with the last being the one held in a bundle.
+//
+// Clients created WithBundleCache cache the messages of recent sequences, so only
+// those published since the sequence was last fetched are read from the stream.
+func (c *Client) FetchOrderedMessages(ctx context.Context, incomingMsg *MsgMeta) ([]MessageEntry, error) {
+	if c.memStore != nil {
+		return c.fetchMemoryMessages(incomingMsg)
+	}
+
+	cacheKey := incomingMsg.SequenceFilter()
+	cached, fetchedTo := c.bundleCache.get(cacheKey, incomingMsg.StreamSequence)
+	if fetchedTo == incomingMsg.StreamSequence {
+		return cached, nil
+	}
+	if fetchedTo > 0 {
+		newEntries, err := c.fetchNewMessages(ctx, incomingMsg, fetchedTo)
+		if err != nil {
+			return nil, err
+		}
+
+		entries := append(cached, newEntries...)
+		c.bundleCache.put(cacheKey, entries, incomingMsg.StreamSequence)
+
+		return entries, nil
+	}
 
 	// TODO: Create a deadline for the context
 	consumerConf := jetstream.OrderedConsumerConfig{
-		FilterSubjects:    []string{filter},
+		FilterSubjects:    []string{incomingMsg.SequenceFilter(), incomingMsg.SequenceRequestFilter()},
 		DeliverPolicy:     jetstream.DeliverAllPolicy,
 		InactiveThreshold: time.Millisecond * 500,
 	}
@@ -212,7 +753,7 @@ func (c *Client) FetchMessageBundle(ctx context.Context, incomingMsg *MsgMeta) (
 		return nil, fmt.Errorf("Unable to create ordered consumer: %w", err)
 	}
 
-	msgBundle := MessageBundle{}
+	entries := []MessageEntry{}
 
 	msgCtx, err := cons.Messages()
 	if msgCtx != nil {
@@ -240,8 +781,9 @@ func (c *Client) FetchMessageBundle(ctx context.Context, incomingMsg *MsgMeta) (
 			return nil, fmt.Errorf("Unable to find original message with NATS sequence of: %d", incomingMsg.StreamSequence)
 		}
 
-		// Add to the message bundle
-		msgBundle[msg.MessageId] = m.Data()
+		if entry, ok := bundleEntry(msg, m.Data()); ok {
+			entries = append(entries, entry)
+		}
 
 		// If we're at the newMsg, we can stop
 		if msg.StreamSequence == incomingMsg.StreamSequence {
@@ -249,7 +791,9 @@ func (c *Client) FetchMessageBundle(ctx context.Context, incomingMsg *MsgMeta) (
 		}
 	}
 
-	return msgBundle, nil
+	c.bundleCache.put(cacheKey, entries, incomingMsg.StreamSequence)
+
+	return entries, nil
 }
 
 // GetEventHistory pulls historic events, most recent first, from now back to start time.
@@ -339,43 +883,287 @@ func (c *Client) GetEventHistory(ctx context.Context, start time.Time, sourceOnl
 	return events, nil
 }
 
+// GetHandledResult returns the result stored for a request that has already been handled
+//
+// Returns false if the request has not been handled, or its record has expired.
+// Requires the client to be created WithIdempotencyStore.
+func (c *Client) GetHandledResult(ctx context.Context, requestMsg *MsgMeta) ([]byte, bool, error) {
+	if c.idempotencyKV == nil {
+		return nil, false, errors.New("Client has no idempotency store configured")
+	}
+
+	entry, err := c.idempotencyKV.Get(c.idempotencyKey(requestMsg))
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return entry.Value(), true, nil
+}
+
 func (c *Client) GetMsg(ctx context.Context, subjTokens ...string) (*jetstream.RawStreamMsg, error) {
+	subject := c.buildSubject(subjTokens...)
+
+	if c.memStore != nil {
+		msg, ok := c.memStore.get(subject)
+		if !ok {
+			return nil, jetstream.ErrMsgNotFound
+		}
+
+		return msg.rawMsg(), nil
+	}
+
 	stream, err := c.JetStream.Stream(ctx, c.streamName)
 	if err != nil {
 		return nil, err
 	}
 
-	subject := c.buildSubject(subjTokens...)
-
 	return stream.GetLastMsgForSubject(ctx, subject)
 }
 
+// GetSysObject returns the system object stored under key
+//
+// Errors wrap nats.ErrObjectNotFound if no object is stored under key.
 func (c *Client) GetSysObject(key string) ([]byte, error) {
+	if c.memStore != nil {
+		data, ok := c.memStore.getObject(key)
+		if !ok {
+			return nil, nats.ErrObjectNotFound
+		}
+
+		return data, nil
+	}
+
 	return c.SysObjStore.GetBytes(key)
 }
 
-func (c *Client) Publish(ctx context.Context, data []byte, subjTokens ...string) (*jetstream.PubAck, bool, error) {
-	sent := true
-	subject := ""
-	isFullSubject := len(subjTokens) == 1 && strings.Contains(subjTokens[0], ".")
+// InterestTopic returns the interest topic the client publishes and consumes for
+func (c *Client) InterestTopic() string {
+	return c.interestTopic
+}
 
-	// If we have individual subject tokens, construct into string and prefix with accountId and interestTopic
-	if !isFullSubject {
-		subject = c.buildSubject(subjTokens...)
-	} else {
-		subject = subjTokens[0]
+// ListWorkers returns the latest heartbeat of each running instance of a worker app
+//
+// Instances that have not sent a heartbeat within staleAfter are marked as stale.
+// Instances that have not sent a heartbeat within WorkersBucketTTL are not returned.
+func (c *Client) ListWorkers(ctx context.Context, appName string, staleAfter time.Duration) ([]WorkerHeartbeat, error) {
+	kv, err := c.workerStore()
+	if err != nil {
+		return nil, err
 	}
 
-	puback, err := c.JetStream.Publish(ctx, subject, data)
-	if err != nil && strings.Contains(err.Error(), "maximum messages per subject exceeded") {
-		err = nil
-		sent = false
-		c.logger.Debugf("Skipping duplicate message %s", subject)
-	} else if err == nil {
-		c.logger.Debugf("Message sent %s", subject)
+	workers := []WorkerHeartbeat{}
+
+	keys, err := kv.Keys(nats.Context(ctx))
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return workers, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list workers: %w", err)
 	}
 
-	return puback, sent, err
+	prefix := c.workerKey(appName, "")
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		entry, err := kv.Get(key)
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Unable to get worker heartbeat '%s': %w", key, err)
+		}
+
+		heartbeat := WorkerHeartbeat{}
+		err = json.Unmarshal(entry.Value(), &heartbeat)
+		if err != nil {
+			c.logger.Errf(err, "Unable to decode worker heartbeat '%s'", key)
+			continue
+		}
+
+		heartbeat.Stale = time.Since(heartbeat.Timestamp) > staleAfter
+		workers = append(workers, heartbeat)
+	}
+
+	return workers, nil
+}
+
+func (c *Client) Publish(ctx context.Context, data []byte, subjTokens ...string) (*jetstream.PubAck, bool, error) {
+	msg := &nats.Msg{
+		Subject: c.publishSubject(subjTokens...),
+		Data:    data,
+	}
+
+	return c.publishMsg(ctx, msg)
+}
+
+// PublishBatch publishes many messages asynchronously, waiting for all to be acknowledged
+//
+// Results are returned in the same order as the given items. As with Publish,
+// duplicate messages are skipped rather than treated as errors and have Sent set to false.
+// The returned error joins the errors of all items that failed.
+func (c *Client) PublishBatch(ctx context.Context, items []PublishItem) ([]PublishResult, error) {
+	results := make([]PublishResult, len(items))
+
+	// Core NATS publishes aren't acknowledged, so there's nothing to wait for
+	if c.memStore != nil {
+		var errs error
+		for i, item := range items {
+			results[i].Subject = c.publishSubject(item.SubjTokens...)
+			msg := &nats.Msg{Subject: results[i].Subject, Data: item.Data}
+
+			results[i].PubAck, results[i].Sent, results[i].Err = c.publishCore(msg)
+			errs = errors.Join(errs, results[i].Err)
+		}
+
+		return results, errs
+	}
+
+	futures := make([]jetstream.PubAckFuture, len(items))
+
+	for i, item := range items {
+		results[i].Subject = c.publishSubject(item.SubjTokens...)
+
+		future, err := c.JetStream.PublishAsync(results[i].Subject, item.Data)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		futures[i] = future
+	}
+
+	var errs error
+	for i, future := range futures {
+		if future == nil {
+			errs = errors.Join(errs, results[i].Err)
+			continue
+		}
+
+		select {
+		case puback := <-future.Ok():
+			results[i].PubAck = puback
+			results[i].Sent = true
+			c.logger.Debugf("Message sent %s", results[i].Subject)
+
+		case err := <-future.Err():
+			if isDuplicateErr(err) {
+				c.logger.Debugf("Skipping duplicate message %s", results[i].Subject)
+				continue
+			}
+
+			results[i].Err = err
+			errs = errors.Join(errs, err)
+
+		case <-ctx.Done():
+			return results, ctx.Err()
+		}
+	}
+
+	return results, errs
+}
+
+// PublishCall publishes a call request, with headers describing where the call
+// came from (see CallMeta)
+//
+// As with Publish, duplicate messages are skipped rather than treated as errors.
+func (c *Client) PublishCall(ctx context.Context, data []byte, meta CallMeta, subjTokens ...string) (*jetstream.PubAck, bool, error) {
+	msg := &nats.Msg{
+		Subject: c.publishSubject(subjTokens...),
+		Data:    data,
+		Header:  meta.header(),
+	}
+
+	return c.publishMsg(ctx, msg)
+}
+
+// PublishDeadLetter copies a message to the client's dead letter subject, returning
+// whether it was sent
+//
+// The message is published to `dead_letter_subject.original_subject` with its
+// original headers, so a stream must be configured to capture the dead letter subject.
+// Nothing is sent if no dead letter subject is configured (see WithDeadLetterSubject).
+// Core clients have no stream to capture dead letters, so return an error.
+func (c *Client) PublishDeadLetter(ctx context.Context, msg jetstream.Msg) (bool, error) {
+	if c.deadLetterSubj == "" {
+		return false, nil
+	}
+	if c.memStore != nil {
+		return false, errors.New("Dead letters require JetStream")
+	}
+
+	deadLetter := nats.NewMsg(fmt.Sprintf("%s.%s", c.deadLetterSubj, msg.Subject()))
+	deadLetter.Data = msg.Data()
+	for key, values := range msg.Headers() {
+		for _, value := range values {
+			deadLetter.Header.Add(key, value)
+		}
+	}
+
+	_, err := c.JetStream.PublishMsg(ctx, deadLetter)
+	if err != nil {
+		return false, err
+	}
+
+	c.logger.Debugf("Dead letter sent %s", deadLetter.Subject)
+	return true, nil
+}
+
+// PublishHeartbeat stores a worker's heartbeat as the latest for its instance
+func (c *Client) PublishHeartbeat(ctx context.Context, heartbeat WorkerHeartbeat) error {
+	kv, err := c.workerStore()
+	if err != nil {
+		return err
+	}
+
+	heartbeatBytes, err := json.Marshal(heartbeat)
+	if err != nil {
+		return err
+	}
+
+	_, err = kv.Put(c.workerKey(heartbeat.AppName, heartbeat.InstanceId), heartbeatBytes)
+	return err
+}
+
+// PublishProgress publishes an interim progress message for a request, returning
+// whether it was sent
+//
+// Each progress message is published to a unique subject beneath the request's
+// response subject (`response_subject.progress.unique_id`), as the account stream
+// only keeps a single message per subject.
+func (c *Client) PublishProgress(ctx context.Context, progress ProgressMsg, responseSubject string) (bool, error) {
+	progressBytes, err := json.Marshal(progress)
+	if err != nil {
+		return false, err
+	}
+
+	subject := strings.Join(
+		[]string{responseSubject, ProgressMessageId, strconv.FormatInt(progress.ReportedAt.UnixNano(), 10)},
+		".",
+	)
+
+	_, sent, err := c.Publish(ctx, progressBytes, subject)
+	return sent, err
+}
+
+// PublishCompletion publishes the completion event of a sequence
+//
+// A sequence can only be completed once. Returns false without error if it
+// already has been, even by another client (or, for core clients, by this one).
+func (c *Client) PublishCompletion(ctx context.Context, completion CompletionMsg, sequenceId string) (bool, error) {
+	completionBytes, err := json.Marshal(completion)
+	if err != nil {
+		return false, err
+	}
+
+	// Deduplicated by message ID as well as by subject, so completion is never
+	// repeated within the stream's duplicate window, even if the subject is purged
+	_, sent, err := c.publishMsg(ctx, c.dedupedMsg(completionBytes, ChannelNotify, sequenceId, SequenceMessageId, CompletedMessageId))
+	return sent, err
 }
 
 // Deprecated: PublishResult is a convenience wrapper that json encodes a ResultMsg and publishes it
@@ -394,6 +1182,17 @@ func (c *Client) PublishResult(ctx context.Context, startedAt time.Time, result
 		return err, false
 	}
 
+	maxPayload := c.NatsConn.MaxPayload()
+	if int64(len(resultBytes)) > maxPayload {
+		err = fmt.Errorf(
+			"%w: result is %d bytes but the max payload is %d bytes, the handler's output should be trimmed",
+			ErrResultTooLarge,
+			len(resultBytes),
+			maxPayload,
+		)
+		return err, false
+	}
+
 	_, sent, err := c.Publish(ctx, resultBytes, subjTokens...)
 	return err, sent
 }
@@ -405,61 +1204,1033 @@ func (c *Client) PublishResultWithAck(ctx context.Context, msg jetstream.Msg, st
 		err = DoubleAck(ctx, msg)
 	}
 
-	return sent, err
+	return sent, err
+}
+
+// PublishSequenceError publishes the error event of a sequence
+//
+// Only the first error in a sequence is kept. Returns false without error if
+// the sequence already has one.
+func (c *Client) PublishSequenceError(ctx context.Context, seqErr SequenceErrorMsg, sequenceId string) (bool, error) {
+	seqErrBytes, err := json.Marshal(seqErr)
+	if err != nil {
+		return false, err
+	}
+
+	_, sent, err := c.Publish(ctx, seqErrBytes, ChannelNotify, sequenceId, ErrorMessageId)
+	return sent, err
+}
+
+// PublishShadow publishes a record of a call a dry run would have dispatched to
+// shadowSubject.SEQUENCE_ID.CALL_SLUG, with the headers the request would have had
+//
+// Shadow records are published via core NATS, so aren't retained unless a stream
+// captures the subject, and are never seen by the runner or workers.
+func (c *Client) PublishShadow(ctx context.Context, shadowSubject string, wouldDispatch WouldDispatchMsg, meta CallMeta, sequenceId string, callSlug string) error {
+	data, err := json.Marshal(wouldDispatch)
+	if err != nil {
+		return err
+	}
+
+	msg := &nats.Msg{
+		Subject: strings.Join([]string{shadowSubject, sequenceId, callSlug}, "."),
+		Data:    data,
+		Header:  meta.header(),
+	}
+
+	return c.NatsConn.PublishMsg(msg)
+}
+
+// PublishDeadline schedules a sequence to be evaluated again at deadline, returning
+// false if it has already been scheduled for then
+//
+// When consumed by ConsumeSequences, the event is held back until the deadline,
+// then evaluated with every message in the sequence. Core clients hold it back
+// in memory, so deadlines are lost if the runner restarts.
+func (c *Client) PublishDeadline(ctx context.Context, deadline time.Time, sequenceId string) (bool, error) {
+	data, err := json.Marshal(DeadlineMsg{Deadline: deadline})
+	if err != nil {
+		return false, err
+	}
+
+	_, sent, err := c.Publish(ctx, data, ChannelNotify, sequenceId, SequenceMessageId, DeadlineMessageId, strconv.FormatInt(deadline.UnixMilli(), 10))
+	return sent, err
+}
+
+// PublishSystem publishes a system-level message, outside of any account
+//
+// System subjects are those used for control of hops itself rather than the
+// events and requests of an account's automations, and take the form
+// `system_namespace.tokens...` (e.g. `hiphops-system.TOKEN.TOKEN` by default).
+// The account ID and interest topic are never prepended. Worker heartbeats
+// aren't system messages, they're kept in the workers key/value bucket.
+//
+// System messages are published via core NATS, as they are not retained
+// in the account stream.
+func (c *Client) PublishSystem(ctx context.Context, data []byte, subjTokens ...string) error {
+	if len(subjTokens) == 0 {
+		return errors.New("At least one subject token is required for system messages")
+	}
+
+	tokens := append([]string{c.systemNs}, subjTokens...)
+	subject := strings.Join(tokens, ".")
+
+	err := c.NatsConn.Publish(subject, data)
+	if err != nil {
+		return err
+	}
+
+	c.logger.Debugf("System message sent %s", subject)
+	return nil
+}
+
+// PublishTimeout publishes the timeout event of a sequence, returning false if it
+// has already been published
+func (c *Client) PublishTimeout(ctx context.Context, timeout TimeoutMsg, sequenceId string) (bool, error) {
+	timeoutBytes, err := json.Marshal(timeout)
+	if err != nil {
+		return false, err
+	}
+
+	_, sent, err := c.publishMsg(ctx, c.dedupedMsg(timeoutBytes, ChannelNotify, sequenceId, SequenceMessageId, TimeoutMessageId))
+	return sent, err
+}
+
+// PublishWouldDispatch records the call a runner would have dispatched had it not
+// been evaluating a replay only, returning whether it was sent
+//
+// The event is published to `notify.sequence_id.call_slug.would_dispatch` with
+// the headers the call request would have had. Only the first event for each
+// call is kept, so re-evaluating the sequence doesn't record the call again.
+func (c *Client) PublishWouldDispatch(ctx context.Context, wouldDispatch WouldDispatchMsg, meta CallMeta, sequenceId string, callSlug string) (bool, error) {
+	data, err := json.Marshal(wouldDispatch)
+	if err != nil {
+		return false, err
+	}
+
+	msg := &nats.Msg{
+		Subject: c.publishSubject(ChannelNotify, sequenceId, callSlug, WouldDispatchMessageId),
+		Data:    data,
+		Header:  meta.header(),
+	}
+
+	_, sent, err := c.publishMsg(ctx, msg)
+	return sent, err
+}
+
+// PurgeSequence permanently deletes every message in a sequence from the stream,
+// returning the number of messages removed
+//
+// This includes the sequence's source event, results and dispatched requests. It
+// is intended for test teardown and for operators cleaning up bad sequences, and
+// should never be called as part of normal processing. Core clients drop the
+// sequence from their memory store.
+func (c *Client) PurgeSequence(ctx context.Context, sequenceId string) (uint64, error) {
+	if sequenceId == "" || strings.ContainsAny(sequenceId, ".*>") {
+		return 0, fmt.Errorf("Refusing to purge invalid sequence ID '%s'", sequenceId)
+	}
+
+	if c.memStore != nil {
+		return c.memStore.purge(sequenceId), nil
+	}
+
+	stream, err := c.JetStream.Stream(ctx, c.streamName)
+	if err != nil {
+		return 0, err
+	}
+
+	seqMeta := &MsgMeta{
+		AccountId:     c.accountId,
+		InterestTopic: c.interestTopic,
+		SequenceId:    sequenceId,
+	}
+
+	var purged uint64
+	for _, filter := range []string{seqMeta.SequenceFilter(), seqMeta.SequenceRequestFilter()} {
+		info, err := stream.Info(ctx, jetstream.WithSubjectFilter(filter))
+		if err != nil {
+			return purged, fmt.Errorf("Unable to count messages in sequence '%s': %w", sequenceId, err)
+		}
+
+		count := uint64(0)
+		for _, n := range info.State.Subjects {
+			count += n
+		}
+		if count == 0 {
+			continue
+		}
+
+		// Messages published after the count are left alone, so the count is exact
+		err = stream.Purge(
+			ctx,
+			jetstream.WithPurgeSubject(filter),
+			jetstream.WithPurgeSequence(info.State.LastSeq+1),
+		)
+		if err != nil {
+			return purged, fmt.Errorf("Unable to purge sequence '%s': %w", sequenceId, err)
+		}
+
+		purged += count
+	}
+
+	c.bundleCache.invalidate(c.sequenceCacheKey(sequenceId))
+
+	c.logger.Infof("Purged %d messages from sequence %s", purged, sequenceId)
+
+	return purged, nil
+}
+
+// PutHandledResult records that a request has been handled along with its result
+//
+// Requires the client to be created WithIdempotencyStore.
+func (c *Client) PutHandledResult(ctx context.Context, requestMsg *MsgMeta, result []byte) error {
+	if c.idempotencyKV == nil {
+		return errors.New("Client has no idempotency store configured")
+	}
+
+	_, err := c.idempotencyKV.Put(c.idempotencyKey(requestMsg), result)
+	return err
+}
+
+func (c *Client) PutSysObject(name string, data []byte) (*nats.ObjectInfo, error) {
+	if c.memStore != nil {
+		c.memStore.putObject(name, data)
+		return &nats.ObjectInfo{ObjectMeta: nats.ObjectMeta{Name: name}, Size: uint64(len(data))}, nil
+	}
+
+	return c.SysObjStore.PutBytes(name, data)
+}
+
+// ReplayEvent republishes the source event of a sequence under a new replay
+// sequence ID, returning the new ID
+//
+// The replay is evaluated by whichever runner consumes the account's notify
+// messages, in mode (ReplayModeFull if empty), so calls are dispatched again
+// unless mode is ReplayModeEvaluate. Unlike WithReplay, no consumer is created.
+// Errors wrap jetstream.ErrMsgNotFound if the sequence has no source event.
+func (c *Client) ReplayEvent(ctx context.Context, sequenceId string, mode string) (string, error) {
+	if sequenceId == "" || strings.ContainsAny(sequenceId, ".*> ") {
+		return "", fmt.Errorf("Invalid sequence ID '%s'", sequenceId)
+	}
+
+	rawMsg, err := c.GetMsg(ctx, ChannelNotify, sequenceId, SourceEventId)
+	if err != nil {
+		return "", fmt.Errorf("Unable to fetch source event of sequence '%s': %w", sequenceId, err)
+	}
+
+	return c.ReplaySourceEvent(ctx, sequenceId, rawMsg.Data, mode)
+}
+
+// ReplaySourceEvent is ReplayEvent for a source event already fetched (e.g. to
+// check it before replaying), given its data
+func (c *Client) ReplaySourceEvent(ctx context.Context, sequenceId string, data []byte, mode string) (string, error) {
+	if mode == "" {
+		mode = ReplayModeFull
+	}
+	if !ValidReplayMode(mode) {
+		return "", fmt.Errorf("Invalid replay mode '%s', must be '%s' or '%s'", mode, ReplayModeFull, ReplayModeEvaluate)
+	}
+	if sequenceId == "" || strings.ContainsAny(sequenceId, ".*> ") {
+		return "", fmt.Errorf("Invalid sequence ID '%s'", sequenceId)
+	}
+
+	data, err := c.replayEvent(data, sequenceId, mode)
+	if err != nil {
+		return "", err
+	}
+
+	replaySequenceId := newReplaySequenceId()
+	c.bundleCache.invalidate(c.sequenceCacheKey(replaySequenceId))
+
+	_, _, err = c.Publish(ctx, data, ChannelNotify, replaySequenceId, SourceEventId)
+	if err != nil {
+		return "", fmt.Errorf("Unable to publish replayed event: %w", err)
+	}
+
+	return replaySequenceId, nil
+}
+
+// SetConsumerAckWait updates the ack wait of a consumer on the client, if it differs
+//
+// As with WithWorker, a worker consumer that already existed is shared with the
+// app's other workers, so is left unchanged with a warning unless Reconfigure
+// is set in WithWorkerConsumerConfig.
+func (c *Client) SetConsumerAckWait(ctx context.Context, name string, ackWait time.Duration) error {
+	consumer, found := c.Consumers[name]
+	if !found {
+		return fmt.Errorf("Consumer '%s' not found on client", name)
+	}
+
+	consumerCfg := consumer.CachedInfo().Config
+	conf := WorkerConsumerConfig{AckWait: ackWait, BackOff: c.workerConsConf.BackOff}
+	conf.apply(&consumerCfg)
+
+	consumer, err := c.reconcileWorkerConsumer(ctx, conf, consumerCfg, consumer, c.workerCreated[name])
+	if err != nil {
+		return fmt.Errorf("Unable to update ack wait for consumer '%s': %w", name, err)
+	}
+
+	c.Consumers[name] = consumer
+	return nil
+}
+
+// StreamName returns the name of the JetStream stream used by the client
+func (c *Client) StreamName() string {
+	return c.streamName
+}
+
+// WorkerHandlers returns the handlers the worker consumer for appName is scoped
+// to (see WithWorker), or nil if it receives requests for all of them
+func (c *Client) WorkerHandlers(appName string) []string {
+	return append([]string(nil), c.workerScopes[appName]...)
+}
+
+// connect opens the NATS connection and initialises JetStream and the system object store,
+// if not already connected
+func (c *Client) connect() error {
+	if c.NatsConn != nil {
+		return nil
+	}
+
+	if len(c.servers) == 0 {
+		return errors.New("At least one NATS server URL is required")
+	}
+
+	err := c.initNatsConnection(c.servers)
+	if err != nil {
+		return err
+	}
+
+	// Core clients hold everything in memory
+	if c.memStore != nil {
+		return nil
+	}
+
+	err = c.initJetStream()
+	if err != nil {
+		return err
+	}
+
+	return c.initObjectStore(context.Background(), c.accountId)
+}
+
+// consumeCore consumes the account's notify messages with a core NATS queue
+// subscription, adding them to the memory store before calling callback
+//
+// Messages are delivered to callback one at a time, in the order they're received.
+func (c *Client) consumeCore(ctx context.Context, fromConsumer string, callback jetstream.MessageHandler) error {
+	sub, err := c.NatsConn.QueueSubscribe(
+		NotifyFilterSubject(c.accountId, c.interestTopic),
+		c.consumerName(c.accountId, c.interestTopic, fromConsumer),
+		func(msg *nats.Msg) {
+			memMsg, ok := c.memStore.receive(msg.Subject, msg.Data, msg.Header)
+			if !ok {
+				return
+			}
+
+			callback(memMsg)
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("Unable to subscribe to notify messages: %w", err)
+	}
+	defer sub.Unsubscribe()
+
+	waitUntilStopped(ctx)
+
+	return nil
+}
+
+// createReplayConsumer creates an ephemeral consumer filtered by a replayed sequence ID
+func (c *Client) createReplayConsumer(ctx context.Context, sequenceId string, replaySequenceId string) (jetstream.Consumer, error) {
+	consumerCfg := jetstream.ConsumerConfig{
+		Name:          c.consumerName(replaySequenceId),
+		Description:   fmt.Sprintf("Replay request for sequence: '%s'", sequenceId),
+		FilterSubject: ReplayFilterSubject(c.accountId, c.interestTopic, replaySequenceId),
+		DeliverPolicy: jetstream.DeliverAllPolicy,
+	}
+
+	return c.JetStream.CreateConsumer(ctx, c.streamName, consumerCfg)
+}
+
+// fetchMemoryMessages reads a sequence's messages from the memory store, as
+// FetchOrderedMessages does from the stream
+func (c *Client) fetchMemoryMessages(incomingMsg *MsgMeta) ([]MessageEntry, error) {
+	entries := []MessageEntry{}
+
+	for _, m := range c.memStore.messages(incomingMsg.SequenceId) {
+		msg, err := Parse(m)
+		if err != nil {
+			return nil, err
+		}
+
+		if msg.StreamSequence > incomingMsg.StreamSequence {
+			break
+		}
+
+		if entry, ok := bundleEntry(msg, m.Data()); ok {
+			entries = append(entries, entry)
+		}
+
+		if msg.StreamSequence == incomingMsg.StreamSequence {
+			return entries, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Unable to find original message with sequence of: %d", incomingMsg.StreamSequence)
+}
+
+// fetchSequence reads every notify message of a sequence in stream order,
+// erroring without fetching if there are more than limit messages
+func (c *Client) fetchSequence(ctx context.Context, sequenceId string, limit int) ([]jetstream.Msg, error) {
+	return c.fetchSequenceChannels(ctx, sequenceId, limit, ChannelNotify)
+}
+
+// fetchSequenceChannels fetches the messages of a sequence published to any of
+// channels, in stream order, erroring if there are more than limit
+func (c *Client) fetchSequenceChannels(ctx context.Context, sequenceId string, limit int, channels ...string) ([]jetstream.Msg, error) {
+	filters := []string{}
+	for _, channel := range channels {
+		filters = append(filters, strings.Join([]string{c.accountId, c.interestTopic, channel, sequenceId, ">"}, "."))
+	}
+
+	consumerConf := jetstream.OrderedConsumerConfig{
+		FilterSubjects:    filters,
+		DeliverPolicy:     jetstream.DeliverAllPolicy,
+		InactiveThreshold: time.Millisecond * 500,
+	}
+	cons, err := c.JetStream.OrderedConsumer(ctx, c.streamName, consumerConf)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create ordered consumer: %w", err)
+	}
+
+	info, err := cons.Info(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get consumer info: %w", err)
+	}
+
+	numPending := int(info.NumPending)
+	if numPending > limit {
+		return nil, fmt.Errorf("Sequence '%s' has %d messages, more than the limit of %d", sequenceId, numPending, limit)
+	}
+
+	sequenceMsgs := []jetstream.Msg{}
+	for numPending > 0 {
+		batchSize := numPending
+		if batchSize > defaultBatchSize {
+			batchSize = defaultBatchSize
+		}
+
+		msgs, err := cons.Fetch(batchSize, jetstream.FetchMaxWait(maxWaitTime))
+		if err != nil {
+			return nil, fmt.Errorf("Unable to fetch messages: %w", err)
+		}
+
+		for m := range msgs.Messages() {
+			numPending--
+			sequenceMsgs = append(sequenceMsgs, m)
+		}
+
+		if msgs.Error() != nil {
+			return nil, fmt.Errorf("Unable to fetch messages: %w", msgs.Error())
+		}
+	}
+
+	return sequenceMsgs, nil
+}
+
+// fetchNewMessages fetches the messages of a sequence published after stream
+// sequence afterSeq, up to and including incomingMsg, in stream order
+//
+// Each message is got directly from the stream by subject. For the few messages
+// typically published to a sequence between fetches, this is much quicker than
+// creating a consumer.
+func (c *Client) fetchNewMessages(ctx context.Context, incomingMsg *MsgMeta, afterSeq uint64) ([]MessageEntry, error) {
+	stream, err := c.JetStream.Stream(ctx, c.streamName)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []MessageEntry{}
+	foundIncoming := false
+
+	for _, filter := range []string{incomingMsg.SequenceFilter(), incomingMsg.SequenceRequestFilter()} {
+		seq := afterSeq + 1
+		for seq <= incomingMsg.StreamSequence {
+			rawMsg, err := stream.GetMsg(ctx, seq, jetstream.WithGetMsgSubject(filter))
+			if errors.Is(err, jetstream.ErrMsgNotFound) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("Unable to get message: %w", err)
+			}
+			if rawMsg.Sequence > incomingMsg.StreamSequence {
+				break
+			}
+
+			// Messages got from the stream are handled as consumed ones are
+			msg, err := Parse(&memoryMsg{
+				data:      rawMsg.Data,
+				header:    rawMsg.Header,
+				sequence:  rawMsg.Sequence,
+				subject:   rawMsg.Subject,
+				timestamp: rawMsg.Time,
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			if entry, ok := bundleEntry(msg, rawMsg.Data); ok {
+				entries = append(entries, entry)
+			}
+			foundIncoming = foundIncoming || rawMsg.Sequence == incomingMsg.StreamSequence
+			seq = rawMsg.Sequence + 1
+		}
+	}
+
+	if !foundIncoming {
+		return nil, fmt.Errorf("Unable to find original message with NATS sequence of: %d", incomingMsg.StreamSequence)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].StreamSequence < entries[j].StreamSequence
+	})
+
+	return entries, nil
+}
+
+// isSuperseded checks whether a newer message that will itself be processed
+// (i.e. anything but the hops assignment message) exists in the message's sequence
+func (c *Client) isSuperseded(ctx context.Context, incomingMsg *MsgMeta) (bool, error) {
+	lastMsg, err := c.lastSequenceMsg(ctx, incomingMsg)
+	if err != nil {
+		return false, err
+	}
+
+	if lastMsg.Sequence <= incomingMsg.StreamSequence {
+		return false, nil
+	}
+
+	// The hops assignment message is written whilst processing the sequence's
+	// first message and is skipped when consumed, so doesn't supersede anything
+	tokens := strings.Split(lastMsg.Subject, ".")
+	if len(tokens) > 4 && tokens[4] == HopsMessageId {
+		return false, nil
+	}
+
+	// Error events are written whilst processing a message that is then retried,
+	// and are skipped when consumed
+	if len(tokens) == 5 && tokens[4] == ErrorMessageId {
+		return false, nil
+	}
+
+	// Progress, completion, done, timeout and would dispatch messages are skipped when consumed too
+	if len(tokens) > 5 && (tokens[5] == ProgressMessageId || tokens[5] == CompletedMessageId || tokens[5] == DoneMessageId || tokens[5] == TimeoutMessageId || tokens[5] == WouldDispatchMessageId) {
+		return false, nil
+	}
+
+	// Deadlines are written whilst processing a message, and are held back when consumed
+	if len(tokens) > 5 && tokens[5] == DeadlineMessageId {
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// lastSequenceMsg returns the latest notify message in the message's sequence
+func (c *Client) lastSequenceMsg(ctx context.Context, incomingMsg *MsgMeta) (*jetstream.RawStreamMsg, error) {
+	if c.memStore != nil {
+		prefix := strings.TrimSuffix(incomingMsg.SequenceFilter(), ">")
+		msgs := c.memStore.messages(incomingMsg.SequenceId)
+
+		for i := len(msgs) - 1; i >= 0; i-- {
+			if strings.HasPrefix(msgs[i].subject, prefix) {
+				return msgs[i].rawMsg(), nil
+			}
+		}
+
+		return nil, jetstream.ErrMsgNotFound
+	}
+
+	stream, err := c.JetStream.Stream(ctx, c.streamName)
+	if err != nil {
+		return nil, err
+	}
+
+	return stream.GetLastMsgForSubject(ctx, incomingMsg.SequenceFilter())
+}
+
+func (c *Client) initJetStream() error {
+	js, err := jetstream.New(c.NatsConn)
+	if err != nil {
+		return err
+	}
+
+	c.JetStream = js
+	return nil
+}
+
+func (c *Client) initNatsConnection(servers []string) error {
+	opts := []nats.Option{
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(5),
+		nats.ReconnectWait(time.Second),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			c.onConnectionState(ConnectionDisconnected, err)
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			c.onConnectionState(ConnectionReconnected, nc.LastError())
+		}),
+		nats.ClosedHandler(func(nc *nats.Conn) {
+			c.onConnectionState(ConnectionClosed, nc.LastError())
+		}),
+	}
+	opts = append(opts, c.connectOpts...)
+
+	nc, err := nats.Connect(strings.Join(servers, ","), opts...)
+	if err != nil {
+		return err
+	}
+
+	c.NatsConn = nc
+	return nil
+}
+
+// initObjectStore initialises required system object store in NATS for a client
+func (c *Client) initObjectStore(ctx context.Context, accountId string) error {
+	js, err := c.NatsConn.JetStream()
+	if err != nil {
+		return err
+	}
+
+	sysObjConf := nats.ObjectStoreConfig{
+		Bucket: "system",
+	}
+	sysObj, err := js.CreateObjectStore(&sysObjConf)
+	if err != nil {
+		return err
+	}
+
+	// Set the system object store on the client
+	c.SysObjStore = sysObj
+	return nil
+}
+
+func (c *Client) buildSubject(subjTokens ...string) string {
+	tokens := append([]string{c.accountId, c.interestTopic}, subjTokens...)
+	return strings.Join(tokens, ".")
+}
+
+// publishMsg publishes a message, returning false without error if it's a duplicate
+//
+// Duplicates are messages whose subject already has one, or (with JetStream) whose
+// Nats-Msg-Id was published within the stream's duplicate window.
+func (c *Client) publishMsg(ctx context.Context, msg *nats.Msg) (*jetstream.PubAck, bool, error) {
+	if c.memStore != nil {
+		return c.publishCore(msg)
+	}
+
+	sent := true
+
+	puback, err := c.JetStream.PublishMsg(ctx, msg)
+	if isDuplicateErr(err) || (err == nil && puback.Duplicate) {
+		err = nil
+		sent = false
+		c.logger.Debugf("Skipping duplicate message %s", msg.Subject)
+	} else if err == nil {
+		c.logger.Debugf("Message sent %s", msg.Subject)
+	}
+
+	return puback, sent, err
+}
+
+// dedupedMsg returns a message for the given subject tokens, with its subject
+// as its Nats-Msg-Id, so the stream drops any repeat within its duplicate window
+func (c *Client) dedupedMsg(data []byte, subjTokens ...string) *nats.Msg {
+	msg := nats.NewMsg(c.publishSubject(subjTokens...))
+	msg.Data = data
+	msg.Header.Set(jetstream.MsgIDHeader, msg.Subject)
+
+	return msg
+}
+
+// publishCore publishes a message with core NATS, adding it to the memory store
+//
+// Duplicates are only detected against the memory store, i.e. amongst the
+// messages this client has published or received.
+func (c *Client) publishCore(msg *nats.Msg) (*jetstream.PubAck, bool, error) {
+	memMsg, added := c.memStore.add(msg.Subject, msg.Data, msg.Header)
+	if !added {
+		c.logger.Debugf("Skipping duplicate message %s", msg.Subject)
+		return nil, false, nil
+	}
+
+	err := c.NatsConn.PublishMsg(msg)
+	if err != nil {
+		if memMsg != nil {
+			c.memStore.remove(memMsg)
+		}
+		return nil, false, err
+	}
+
+	c.logger.Debugf("Message sent %s", msg.Subject)
+
+	puback := &jetstream.PubAck{}
+	if memMsg != nil {
+		puback.Sequence = memMsg.sequence
+	}
+
+	return puback, true, nil
+}
+
+// publishSubject returns the subject to publish to for the given tokens
+//
+// Individual subject tokens are prefixed with accountId and interestTopic,
+// whereas a single dotted token is treated as a full subject.
+func (c *Client) publishSubject(subjTokens ...string) string {
+	isFullSubject := len(subjTokens) == 1 && strings.Contains(subjTokens[0], ".")
+	if isFullSubject {
+		return subjTokens[0]
+	}
+
+	return c.buildSubject(subjTokens...)
+}
+
+// consumerName builds a consumer name from the given tokens, prefixed with the
+// client's name prefix if one is set
+func (c *Client) consumerName(tokens ...string) string {
+	if c.namePrefix != "" {
+		tokens = append([]string{c.namePrefix}, tokens...)
+	}
+
+	return nameReplacer.Replace(strings.Join(tokens, "-"))
+}
+
+// onConnectionState logs a change in connection state and notifies any registered handlers
+func (c *Client) onConnectionState(state ConnectionState, err error) {
+	switch {
+	case err != nil && state != ConnectionReconnected:
+		c.logger.Errf(err, "NATS connection %s", state)
+	case state == ConnectionDisconnected:
+		c.logger.Warnf("NATS connection %s", state)
+	default:
+		c.logger.Infof("NATS connection %s", state)
+	}
+
+	c.connHandlersMu.RLock()
+	defer c.connHandlersMu.RUnlock()
+
+	for _, handler := range c.connHandlers {
+		handler(state, err)
+	}
+}
+
+// replayEvent marks a source event as replayed from sequenceId, in mode (or the
+// client's replay mode if empty)
+//
+// Events that can't be marked are replayed as they are in full replays, as
+// runners treat unmarked events as full replays anyway. Evaluated replays must
+// be marked, or the runner would dispatch their calls.
+func (c *Client) replayEvent(data []byte, sequenceId string, mode string) ([]byte, error) {
+	if mode == "" {
+		mode = c.replayMode
+	}
+	if mode == "" {
+		mode = ReplayModeFull
+	}
+
+	marked, err := markReplayEvent(data, ReplayMeta{Mode: mode, SequenceId: sequenceId})
+	if err != nil && mode == ReplayModeEvaluate {
+		return nil, fmt.Errorf("Unable to mark event for evaluated replay: %w", err)
+	}
+	if err != nil {
+		c.logger.Warnf("Unable to mark replayed event, replaying unmarked: %s", err)
+		return data, nil
+	}
+
+	return marked, nil
+}
+
+// replaySequence publishes the messages of a sequence under the replay sequence ID,
+// optionally waiting for the original gaps between messages
+func (c *Client) replaySequence(ctx context.Context, sequenceMsgs []jetstream.Msg, replaySequenceId string, preserveTiming bool) error {
+	var previous time.Time
+
+	// Replays are new sequences, so nothing should be cached for them already
+	c.bundleCache.invalidate(c.sequenceCacheKey(replaySequenceId))
+
+	for _, m := range sequenceMsgs {
+		meta, err := m.Metadata()
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrMalformedPayload, err)
+		}
+
+		if preserveTiming && !previous.IsZero() {
+			select {
+			case <-time.After(meta.Timestamp.Sub(previous)):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		previous = meta.Timestamp
+
+		// Keep every token after the sequence ID, e.g. the message ID and any done marker
+		tokens := strings.Split(m.Subject(), ".")
+		if len(tokens) < 5 {
+			return fmt.Errorf("%w: %s", ErrMalformedSubject, m.Subject())
+		}
+
+		data := m.Data()
+		if len(tokens) == 5 && tokens[4] == SourceEventId {
+			data, err = c.replayEvent(data, tokens[3], "")
+			if err != nil {
+				return err
+			}
+		}
+
+		subjTokens := append([]string{ChannelNotify, replaySequenceId}, tokens[4:]...)
+		_, _, err = c.Publish(ctx, data, subjTokens...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkWorkerScope returns ErrWorkerScopeOverlap if a worker consumer of appName,
+// other than the one named, receives requests for any of handlers (or any at
+// all if handlers is empty, as the consumer would be unscoped)
+func (c *Client) checkWorkerScope(ctx context.Context, appName string, name string, handlers []string) error {
+	stream, err := c.JetStream.Stream(ctx, c.streamName)
+	if err != nil {
+		return err
+	}
+
+	scope := map[string]bool{}
+	for _, handler := range handlers {
+		scope[handler] = true
+	}
+	appPrefix := WorkerRequestFilterSubject(c.accountId, c.interestTopic, appName, "")
+
+	consumers := stream.ListConsumers(ctx)
+	for info := range consumers.Info() {
+		if info.Name == name {
+			continue
+		}
+
+		filters := info.Config.FilterSubjects
+		if info.Config.FilterSubject != "" {
+			filters = append(filters, info.Config.FilterSubject)
+		}
+
+		for _, filter := range filters {
+			handler, ok := strings.CutPrefix(filter, appPrefix)
+			if !ok {
+				continue
+			}
+
+			if len(scope) == 0 || handler == "*" || scope[handler] {
+				return fmt.Errorf(
+					"%w: consumer '%s' of app '%s' already receives requests for '%s'",
+					ErrWorkerScopeOverlap,
+					info.Name,
+					appName,
+					handler,
+				)
+			}
+		}
+	}
+
+	return consumers.Err()
+}
+
+// reconcileWorkerConsumer creates a worker consumer with cfg, or updates the
+// existing consumer if it differs in the fields configured by conf
+//
+// Every worker of an app shares its consumer, so an existing consumer is only
+// changed when explicitly asked or created by this client. Otherwise one deploy
+// could change delivery for all of its peers.
+func (c *Client) reconcileWorkerConsumer(ctx context.Context, conf WorkerConsumerConfig, cfg jetstream.ConsumerConfig, existing jetstream.Consumer, created bool) (jetstream.Consumer, error) {
+	if existing != nil {
+		diffs := conf.diff(cfg, existing.CachedInfo().Config)
+		if len(diffs) == 0 {
+			return existing, nil
+		}
+
+		if !created {
+			if !c.workerConsConf.Reconfigure {
+				c.logger.Warnf(
+					"Worker consumer '%s' already exists with a different config (%s), leaving it unchanged. Set Reconfigure in WithWorkerConsumerConfig to update it",
+					cfg.Name,
+					strings.Join(diffs, ", "),
+				)
+				return existing, nil
+			}
+
+			c.logger.Warnf("Reconfiguring worker consumer '%s' (%s)", cfg.Name, strings.Join(diffs, ", "))
+		}
+	}
+
+	return c.JetStream.CreateOrUpdateConsumer(ctx, c.streamName, cfg)
+}
+
+// sequenceCacheKey returns the key of a sequence's messages in the bundle cache
+func (c *Client) sequenceCacheKey(sequenceId string) string {
+	seqMeta := &MsgMeta{
+		AccountId:     c.accountId,
+		InterestTopic: c.interestTopic,
+		SequenceId:    sequenceId,
+	}
+
+	return seqMeta.SequenceFilter()
+}
+
+// idempotencyKey returns the key of a request's record in the idempotency bucket
+func (c *Client) idempotencyKey(requestMsg *MsgMeta) string {
+	return fmt.Sprintf(
+		"%s.%s.%s.%s",
+		nameReplacer.Replace(c.accountId),
+		requestMsg.InterestTopic,
+		requestMsg.SequenceId,
+		requestMsg.MessageId,
+	)
+}
+
+// workerKey returns the key of a worker instance's heartbeat in the workers bucket
+func (c *Client) workerKey(appName string, instanceId string) string {
+	return fmt.Sprintf("%s.%s.%s", nameReplacer.Replace(c.accountId), appName, instanceId)
+}
+
+// workerStore returns the workers key/value bucket, creating it on first use
+func (c *Client) workerStore() (nats.KeyValue, error) {
+	c.workersKVMu.Lock()
+	defer c.workersKVMu.Unlock()
+
+	if c.workersKV != nil {
+		return c.workersKV, nil
+	}
+
+	js, err := c.NatsConn.JetStream()
+	if err != nil {
+		return nil, err
+	}
+
+	kv, err := js.CreateKeyValue(&nats.KeyValueConfig{
+		Bucket:      WorkersBucket,
+		Description: "Worker heartbeats",
+		TTL:         WorkersBucketTTL,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create workers bucket: %w", err)
+	}
+
+	c.workersKV = kv
+	return kv, nil
+}
+
+// bundleEntry returns a parsed message as an entry of a message bundle, or false
+// if it's left out. Progress messages share their call's message ID, so are left
+// out to avoid being mistaken for the call's result.
+func bundleEntry(msg *MsgMeta, data []byte) (MessageEntry, bool) {
+	entry := MessageEntry{
+		Data:           data,
+		Key:            msg.MessageId,
+		StreamSequence: msg.StreamSequence,
+	}
+
+	switch {
+	case msg.Channel == ChannelRequest:
+		entry.Key = RequestBundleKey(msg.MessageId)
+		return entry, true
+	case msg.Completed, msg.Deadline, msg.TimedOut:
+		// Completion, deadline and timeout events are about the sequence, rather than part of its state
+		return entry, false
+	case msg.WouldDispatch:
+		// Would dispatch events share their call's message ID, but are never results
+		return entry, false
+	default:
+		return entry, !msg.Progress
+	}
+}
+
+// sequenceIdFromSubject returns the sequence ID from a message subject, or an
+// empty string if the subject is malformed
+func sequenceIdFromSubject(subject string) string {
+	tokens := strings.SplitN(subject, ".", 5)
+	if len(tokens) < 5 {
+		return ""
+	}
+
+	return tokens[3]
+}
+
+func equalDurations(a []time.Duration, b []time.Duration) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isDuplicateErr returns true if a publish error was caused by the message being a duplicate
+func isDuplicateErr(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "maximum messages per subject exceeded")
 }
 
-func (c *Client) PutSysObject(name string, data []byte) (*nats.ObjectInfo, error) {
-	return c.SysObjStore.PutBytes(name, data)
+func newReplaySequenceId() string {
+	return ReplaySequencePrefix + uuid.NewString()[:20]
 }
 
-func (c *Client) initJetStream() error {
-	js, err := jetstream.New(c.NatsConn)
-	if err != nil {
-		return err
+// parseServers splits a comma separated list of server URLs, dropping empty entries
+func parseServers(natsUrl string) []string {
+	servers := []string{}
+	for _, server := range strings.Split(natsUrl, ",") {
+		server = strings.TrimSpace(server)
+		if server != "" {
+			servers = append(servers, server)
+		}
 	}
 
-	c.JetStream = js
-	return nil
+	return servers
 }
 
-func (c *Client) initNatsConnection(natsUrl string) error {
-	nc, err := nats.Connect(
-		natsUrl,
-		nats.RetryOnFailedConnect(true),
-		nats.MaxReconnects(5),
-		nats.ReconnectWait(time.Second),
-	)
-	if err != nil {
-		return err
-	}
+// waitUntilStopped blocks until ctx is cancelled or stopped (see ContextWithStop)
+func waitUntilStopped(ctx context.Context) {
+	// A nil stop channel never fires
+	stop, _ := ctx.Value(consumeStopCtxKey{}).(<-chan struct{})
 
-	c.NatsConn = nc
-	return nil
+	select {
+	case <-ctx.Done():
+	case <-stop:
+	}
 }
 
-// initObjectStore initialises required system object store in NATS for a client
-func (c *Client) initObjectStore(ctx context.Context, accountId string) error {
-	js, err := c.NatsConn.JetStream()
-	if err != nil {
-		return err
-	}
+// workerScope returns the sorted, deduplicated handlers a worker consumer is
+// scoped to, or an error if any can't be a subject token
+func workerScope(handlers []string) ([]string, error) {
+	scope := []string{}
+	seen := map[string]bool{}
 
-	sysObjConf := nats.ObjectStoreConfig{
-		Bucket: "system",
-	}
-	sysObj, err := js.CreateObjectStore(&sysObjConf)
-	if err != nil {
-		return err
-	}
+	for _, handler := range handlers {
+		if handler == "" || strings.ContainsAny(handler, ".*> ") {
+			return nil, fmt.Errorf("Invalid handler name '%s'", handler)
+		}
 
-	// Set the system object store on the client
-	c.SysObjStore = sysObj
-	return nil
-}
+		if !seen[handler] {
+			seen[handler] = true
+			scope = append(scope, handler)
+		}
+	}
 
-func (c *Client) buildSubject(subjTokens ...string) string {
-	tokens := append([]string{c.accountId, c.interestTopic}, subjTokens...)
-	return strings.Join(tokens, ".")
+	sort.Strings(scope)
+	return scope, nil
 }
 
 // ClientOpts - passed through to NewClient() to configure the client setup
@@ -471,9 +2242,38 @@ func DefaultClientOpts() []ClientOpt {
 	}
 }
 
+// WithNamePrefix prefixes the names of all consumers created or looked up by the client
+//
+// This allows multiple environments (e.g. dev/staging/prod) to share an account
+// without their consumers colliding. The stream name is not prefixed, use
+// WithStreamName if a separate stream is also required.
+//
+// Must be given before any ClientOpts that create consumers, as they would
+// otherwise already be named without the prefix.
+func WithNamePrefix(prefix string) ClientOpt {
+	return func(c *Client) error {
+		if len(c.Consumers) > 0 {
+			return errors.New("WithNamePrefix must be given before any ClientOpts that create consumers")
+		}
+
+		c.namePrefix = prefix
+		return nil
+	}
+}
+
 // WithReplay initialises the client with a consumer for replaying a sequence
 func WithReplay(name string, sequenceId string) ClientOpt {
 	return func(c *Client) error {
+		err := c.requireAcks("WithReplay")
+		if err != nil {
+			return err
+		}
+
+		err = c.connect()
+		if err != nil {
+			return err
+		}
+
 		ctx := context.Background() // TODO: Move all context creation in ClientOpts to argument rather than in function
 
 		// Get the source message from the stream
@@ -492,24 +2292,25 @@ func WithReplay(name string, sequenceId string) ClientOpt {
 			return fmt.Errorf("No source event found for subject '%s'", sourceMsgSubject)
 		}
 
+		// The replay mode is carried in the event, so any runner picking it up
+		// evaluates it the same way
+		data, err := c.replayEvent(rawMsg.Data, sequenceId, "")
+		if err != nil {
+			return err
+		}
+
 		// Create a new, random replay sequence ID
-		replaySequenceId := fmt.Sprintf("replay-%s", uuid.NewString()[:20])
+		replaySequenceId := newReplaySequenceId()
+		c.bundleCache.invalidate(c.sequenceCacheKey(replaySequenceId))
 
-		// Create ephemeral consumer filtered by replayed sequence ID
-		consumerCfg := jetstream.ConsumerConfig{
-			Name:          replaySequenceId,
-			Description:   fmt.Sprintf("Replay request for sequence: '%s'", sequenceId),
-			FilterSubject: ReplayFilterSubject(c.accountId, c.interestTopic, replaySequenceId),
-			DeliverPolicy: jetstream.DeliverAllPolicy,
-		}
-		consumer, err := c.JetStream.CreateConsumer(ctx, c.streamName, consumerCfg)
+		consumer, err := c.createReplayConsumer(ctx, sequenceId, replaySequenceId)
 		if err != nil {
 			return err
 		}
 
 		// Publish the source message with replayed sequence ID so it's picked up by
 		// ephemeral consumer
-		c.Publish(ctx, rawMsg.Data, ChannelNotify, replaySequenceId, "event")
+		c.Publish(ctx, data, ChannelNotify, replaySequenceId, "event")
 
 		// Set the consumer on the client
 		c.Consumers[name] = consumer
@@ -517,13 +2318,37 @@ func WithReplay(name string, sequenceId string) ClientOpt {
 	}
 }
 
+// WithReplayMode sets the mode that replays created by WithReplay and WithSequenceReplay
+// are evaluated in, either ReplayModeFull (the default) or ReplayModeEvaluate
+//
+// Should be given before WithReplay or WithSequenceReplay.
+func WithReplayMode(mode string) ClientOpt {
+	return func(c *Client) error {
+		if !ValidReplayMode(mode) {
+			return fmt.Errorf("Invalid replay mode '%s', must be '%s' or '%s'", mode, ReplayModeFull, ReplayModeEvaluate)
+		}
+
+		c.replayMode = mode
+		return nil
+	}
+}
+
 // WithRunner initialises the client with a consumer for running pipelines
 func WithRunner(name string) ClientOpt {
 	return func(c *Client) error {
+		err := c.requireAcks("WithRunner")
+		if err != nil {
+			return err
+		}
+
+		err = c.connect()
+		if err != nil {
+			return err
+		}
+
 		ctx := context.Background()
 
-		consumerName := fmt.Sprintf("%s-%s-%s", c.accountId, c.interestTopic, ChannelNotify)
-		consumerName = nameReplacer.Replace(consumerName)
+		consumerName := c.consumerName(c.accountId, c.interestTopic, ChannelNotify)
 
 		consumer, err := c.JetStream.Consumer(ctx, c.streamName, consumerName)
 		if err != nil {
@@ -535,18 +2360,122 @@ func WithRunner(name string) ClientOpt {
 	}
 }
 
+// WithConnectionStateHandler registers a handler to be notified of disconnect,
+// reconnect and closed events on the NATS connection
+//
+// Connection state changes are always logged, regardless of whether handlers are registered.
+func WithConnectionStateHandler(handler ConnectionStateHandler) ClientOpt {
+	return func(c *Client) error {
+		c.connHandlersMu.Lock()
+		defer c.connHandlersMu.Unlock()
+
+		c.connHandlers = append(c.connHandlers, handler)
+		return nil
+	}
+}
+
+// WithBundleCache caches the messages of recent sequences fetched by
+// FetchOrderedMessages, trading memory for fetching only the messages published
+// since a sequence was last fetched
+//
+// At most maxBytes of messages are held, dropping the least recently fetched
+// sequences beyond that, and sequences not fetched for ttl are dropped (a ttl of
+// 0 uses DefaultBundleCacheTTL). Without it, every message of a sequence is
+// fetched each time.
+func WithBundleCache(maxBytes int, ttl time.Duration) ClientOpt {
+	return func(c *Client) error {
+		if maxBytes < 1 {
+			return fmt.Errorf("Invalid bundle cache size %d, must be at least 1 byte", maxBytes)
+		}
+		if ttl < 0 {
+			return fmt.Errorf("Invalid bundle cache TTL %s, must not be negative", ttl)
+		}
+
+		c.bundleCache = newBundleCache(maxBytes, ttl)
+		return nil
+	}
+}
+
+// WithCoreSequenceLimit sets the max number of sequences held in memory by a client
+// created with NewCoreClient (defaults to DefaultCoreSequenceLimit)
+//
+// The oldest sequences are dropped once the limit is reached, after which messages
+// in them are evaluated without their earlier history.
+func WithCoreSequenceLimit(limit int) ClientOpt {
+	return func(c *Client) error {
+		if c.memStore == nil {
+			return errors.New("WithCoreSequenceLimit can only be given to clients created with NewCoreClient")
+		}
+		if limit < 1 {
+			return fmt.Errorf("Invalid core sequence limit %d, must be at least 1", limit)
+		}
+
+		c.memStore.limit = limit
+		return nil
+	}
+}
+
+// WithDeadLetterSubject sets the subject that requests which exhaust their retries are
+// copied to (see PublishDeadLetter)
+func WithDeadLetterSubject(subject string) ClientOpt {
+	return func(c *Client) error {
+		c.deadLetterSubj = subject
+		return nil
+	}
+}
+
+// WithIdempotencyStore initialises the key/value bucket used to record handled requests,
+// with records expiring after ttl
+//
+// The ttl is only applied when the bucket is first created.
+func WithIdempotencyStore(ttl time.Duration) ClientOpt {
+	return func(c *Client) error {
+		err := c.connect()
+		if err != nil {
+			return err
+		}
+
+		js, err := c.NatsConn.JetStream()
+		if err != nil {
+			return err
+		}
+
+		kv, err := js.KeyValue(IdempotencyBucket)
+		if errors.Is(err, nats.ErrBucketNotFound) {
+			kv, err = js.CreateKeyValue(&nats.KeyValueConfig{
+				Bucket:      IdempotencyBucket,
+				Description: "Results of handled requests",
+				TTL:         ttl,
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to initialise idempotency store: %w", err)
+		}
+
+		c.idempotencyKV = kv
+		return nil
+	}
+}
+
 // WithLocalRunner initialises a runner with a randomised interest topic and ephemeral consumer
 func WithLocalRunner(name string) ClientOpt {
 	return func(c *Client) error {
+		err := c.requireAcks("WithLocalRunner")
+		if err != nil {
+			return err
+		}
+
+		err = c.connect()
+		if err != nil {
+			return err
+		}
+
 		ctx := context.Background()
 
 		c.interestTopic = fmt.Sprintf("local-%s", uuid.NewString()[:7])
 
-		consumerName := fmt.Sprintf("%s-%s-%s", c.accountId, c.interestTopic, ChannelNotify)
-		consumerName = nameReplacer.Replace(consumerName)
-
 		cfg := jetstream.ConsumerConfig{
-			Name:          c.interestTopic,
+			Name:          c.consumerName(c.interestTopic),
 			FilterSubject: NotifyFilterSubject(c.accountId, c.interestTopic),
 			DeliverPolicy: jetstream.DeliverAllPolicy,
 			AckPolicy:     jetstream.AckExplicitPolicy,
@@ -564,8 +2493,138 @@ func WithLocalRunner(name string) ClientOpt {
 	}
 }
 
+// WithDryRunner initialises the client with an ephemeral consumer of the account's
+// notify messages, for a runner that evaluates sequences without dispatching calls
+//
+// Unlike WithRunner, messages are acked on a consumer of the client's own, so dry
+// runs never take messages from the live runner's durable consumer. Only messages
+// published after the consumer is created are received, and the consumer is
+// removed by the server once the client stops consuming.
+func WithDryRunner(name string) ClientOpt {
+	return func(c *Client) error {
+		err := c.requireAcks("WithDryRunner")
+		if err != nil {
+			return err
+		}
+
+		err = c.connect()
+		if err != nil {
+			return err
+		}
+
+		cfg := jetstream.ConsumerConfig{
+			FilterSubject:     NotifyFilterSubject(c.accountId, c.interestTopic),
+			DeliverPolicy:     jetstream.DeliverNewPolicy,
+			AckPolicy:         jetstream.AckExplicitPolicy,
+			AckWait:           time.Minute * 1,
+			MaxDeliver:        5,
+			InactiveThreshold: time.Minute,
+		}
+		consumer, err := c.JetStream.CreateOrUpdateConsumer(context.Background(), c.streamName, cfg)
+		if err != nil {
+			return fmt.Errorf("Unable to create dry run consumer: %w", err)
+		}
+
+		c.Consumers[name] = consumer
+		return nil
+	}
+}
+
+// WithSequenceReplay initialises the client with a consumer for replaying every notify
+// message of a sequence, in their original order
+//
+// Where WithReplay only replays the source event, this also replays call results,
+// which is useful when debugging workflows that depend on the state of a sequence.
+// If preserveTiming is true, messages are published in the background and spaced
+// by their original gaps. Sequences of more than MaxReplayMessages messages are rejected.
+func WithSequenceReplay(name string, sequenceId string, preserveTiming bool) ClientOpt {
+	return func(c *Client) error {
+		err := c.requireAcks("WithSequenceReplay")
+		if err != nil {
+			return err
+		}
+
+		err = c.connect()
+		if err != nil {
+			return err
+		}
+
+		ctx := context.Background()
+
+		sequenceMsgs, err := c.fetchSequence(ctx, sequenceId, MaxReplayMessages)
+		if err != nil {
+			return fmt.Errorf("Failed to fetch sequence: %w", err)
+		}
+		if len(sequenceMsgs) == 0 {
+			return fmt.Errorf("No messages found for sequence '%s'", sequenceId)
+		}
+
+		replaySequenceId := newReplaySequenceId()
+
+		consumer, err := c.createReplayConsumer(ctx, sequenceId, replaySequenceId)
+		if err != nil {
+			return err
+		}
+
+		c.Consumers[name] = consumer
+
+		if !preserveTiming {
+			return c.replaySequence(ctx, sequenceMsgs, replaySequenceId, false)
+		}
+
+		go func() {
+			err := c.replaySequence(ctx, sequenceMsgs, replaySequenceId, true)
+			if err != nil {
+				c.logger.Errf(err, "Failed to replay sequence '%s'", sequenceId)
+			}
+		}()
+
+		return nil
+	}
+}
+
+// WithConnectOptions adds options to the NATS connection, after (so overriding)
+// the client's own, e.g. to connect to a LocalServer with its ConnectOptions
+//
+// Should be given before any ClientOpts that create consumers or stores, as they
+// open the connection.
+func WithConnectOptions(opts ...nats.Option) ClientOpt {
+	return func(c *Client) error {
+		if c.NatsConn != nil {
+			return errors.New("WithConnectOptions must be given before any ClientOpts that connect to NATS")
+		}
+
+		c.connectOpts = append(c.connectOpts, opts...)
+		return nil
+	}
+}
+
+// WithServers sets the NATS server URLs the client connects to, allowing it to fail
+// over across the servers of a cluster
+//
+// Replaces any servers given to NewClient. Should be given before any ClientOpts that
+// create consumers or stores, as they open the connection.
+func WithServers(servers []string) ClientOpt {
+	return func(c *Client) error {
+		if c.NatsConn != nil {
+			return errors.New("WithServers must be given before any ClientOpts that connect to NATS")
+		}
+
+		c.servers = parseServers(strings.Join(servers, ","))
+		if len(c.servers) == 0 {
+			return errors.New("At least one NATS server URL is required")
+		}
+
+		return nil
+	}
+}
+
 // WithStreamName overrides the stream name to be used (which defaults to accountId otherwise)
 //
+// The stream may be shared by several accounts, as their subjects and consumer
+// names are prefixed with their account ID, so long as it captures the subjects
+// of each of them.
+//
 // Should be given before any ClientOpts that use the stream,
 // as otherwise they will be initialised with the default stream name
 func WithStreamName(name string) ClientOpt {
@@ -575,22 +2634,82 @@ func WithStreamName(name string) ClientOpt {
 	}
 }
 
+// WithSystemNamespace overrides the namespace used for system subjects by PublishSystem
+// (which defaults to DefaultSystemNamespace otherwise)
+func WithSystemNamespace(namespace string) ClientOpt {
+	return func(c *Client) error {
+		c.systemNs = namespace
+		return nil
+	}
+}
+
 // WithWorker initialises the client with a consumer to receive call requests for a worker
-func WithWorker(appName string) ClientOpt {
+//
+// The consumer receives requests for every handler of the app, unless handlers
+// are given to scope it to only those, e.g. to run an expensive handler on its
+// own workers. Every worker with the same scope shares a consumer, whilst each
+// scope has its own. Scopes must not overlap, or requests would be handled by
+// workers of each, so ErrWorkerScopeOverlap is returned if another of the app's
+// consumers shares any handlers (an unscoped consumer shares them all). Workers
+// for the rest of the app's handlers should be scoped to them, rather than
+// left unscoped, and a consumer no longer used must be deleted before its
+// handlers can be given to another scope.
+func WithWorker(appName string, handlers ...string) ClientOpt {
 	return func(c *Client) error {
+		handlers, err := workerScope(handlers)
+		if err != nil {
+			return fmt.Errorf("Invalid handlers for worker '%s': %w", appName, err)
+		}
+
+		err = c.requireAcks("WithWorker")
+		if err != nil {
+			return err
+		}
+
+		err = c.connect()
+		if err != nil {
+			return err
+		}
+
 		ctx := context.Background()
 
-		name := fmt.Sprintf("%s-%s-%s-%s", c.accountId, c.interestTopic, ChannelRequest, appName)
-		name = nameReplacer.Replace(name)
+		name := c.consumerName(append([]string{c.accountId, c.interestTopic, ChannelRequest, appName}, handlers...)...)
+
+		err = c.checkWorkerScope(ctx, appName, name, handlers)
+		if err != nil {
+			return err
+		}
 
-		// Create or update the consumer, since these are created dynamically
 		consumerCfg := jetstream.ConsumerConfig{
 			Name:          name,
 			Durable:       name,
 			FilterSubject: WorkerRequestFilterSubject(c.accountId, c.interestTopic, appName, "*"),
-			AckWait:       1 * time.Minute,
+			AckWait:       DefaultWorkerAckWait,
+		}
+		if len(handlers) > 0 {
+			consumerCfg.FilterSubject = ""
+			for _, handler := range handlers {
+				consumerCfg.FilterSubjects = append(consumerCfg.FilterSubjects, WorkerRequestFilterSubject(c.accountId, c.interestTopic, appName, handler))
+			}
+
+			if c.workerScopes == nil {
+				c.workerScopes = map[string][]string{}
+			}
+			c.workerScopes[appName] = handlers
+		}
+		c.workerConsConf.apply(&consumerCfg)
+
+		consumer, err := c.JetStream.Consumer(ctx, c.streamName, name)
+		if errors.Is(err, jetstream.ErrConsumerNotFound) {
+			if c.workerCreated == nil {
+				c.workerCreated = map[string]bool{}
+			}
+			c.workerCreated[appName] = true
+		} else if err != nil {
+			return err
 		}
-		consumer, err := c.JetStream.CreateOrUpdateConsumer(ctx, c.streamName, consumerCfg)
+
+		consumer, err = c.reconcileWorkerConsumer(ctx, c.workerConsConf, consumerCfg, consumer, false)
 		if err != nil {
 			return err
 		}
@@ -599,3 +2718,32 @@ func WithWorker(appName string) ClientOpt {
 		return nil
 	}
 }
+
+// WithWorkerConsumerConfig sets the delivery config of the consumers created by WithWorker
+//
+// Existing consumers whose config differs are left unchanged with a warning,
+// unless conf.Reconfigure is set. Should be given before WithWorker.
+func WithWorkerConsumerConfig(conf WorkerConsumerConfig) ClientOpt {
+	return func(c *Client) error {
+		c.workerConsConf = conf
+		return nil
+	}
+}
+
+// WithSequenceConcurrency allows ConsumeSequences to process up to limit messages
+// at once, each from a different sequence
+//
+// Messages are fetched limit at a time and wait for a free slot before being
+// processed. As a message's AckWait runs whilst it waits, the consumer's AckWait
+// should comfortably exceed the time taken to process limit messages, or messages
+// will be redelivered and processed twice.
+func WithSequenceConcurrency(limit int) ClientOpt {
+	return func(c *Client) error {
+		if limit < 1 {
+			return fmt.Errorf("Sequence concurrency must be at least 1, got %d", limit)
+		}
+
+		c.seqConcurrency = limit
+		return nil
+	}
+}
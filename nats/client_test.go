@@ -2,8 +2,13 @@ package nats
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/hiphops-io/hops/dsl"
 	"github.com/hiphops-io/hops/logs"
 	"github.com/nats-io/nats.go/jetstream"
 	"github.com/stretchr/testify/assert"
@@ -27,6 +32,167 @@ func TestNewClient(t *testing.T) {
 	assert.NotNil(t, hopsNats.Consumers[DefaultConsumerName], "HopsNats should initialise the Consumer")
 }
 
+func TestNewClientServers(t *testing.T) {
+	localNats := setupLocalNatsServer(t)
+	defer localNats.Close()
+
+	logger := logs.NoOpLogger()
+	natsLogger := logs.NewNatsZeroLogger(logger)
+
+	authUrl, err := localNats.AuthUrl("")
+	require.NoError(t, err, "Test setup: Should have valid auth URL for NATS")
+
+	user, err := localNats.User("")
+	require.NoError(t, err, "Test setup: Should have valid NATS user")
+
+	hopsNats, err := NewClient(
+		"",
+		user.Account.Name,
+		DefaultInterestTopic,
+		&natsLogger,
+		WithServers([]string{authUrl, " ", authUrl}),
+		WithRunner(DefaultConsumerName),
+	)
+	require.NoError(t, err, "Client should connect using servers from WithServers")
+	defer hopsNats.Close()
+
+	assert.True(t, hopsNats.NatsConn.IsConnected(), "Client should be connected to NATS server")
+
+	_, err = NewClient(" , ", user.Account.Name, DefaultInterestTopic, &natsLogger)
+	assert.Error(t, err, "Client should require at least one server URL")
+
+	_, err = NewClient(authUrl, user.Account.Name, DefaultInterestTopic, &natsLogger, WithServers([]string{}))
+	assert.Error(t, err, "WithServers should require at least one server URL")
+
+	_, err = NewClient(authUrl, user.Account.Name, DefaultInterestTopic, &natsLogger, WithRunner(DefaultConsumerName), WithServers([]string{authUrl}))
+	assert.Error(t, err, "WithServers should not be accepted once connected")
+}
+
+func TestNewClientWithoutLogger(t *testing.T) {
+	localNats := setupLocalNatsServer(t)
+	defer localNats.Close()
+
+	authUrl, err := localNats.AuthUrl("")
+	require.NoError(t, err, "Test setup: Should have valid auth URL for NATS")
+
+	user, err := localNats.User("")
+	require.NoError(t, err, "Test setup: Should have valid NATS user")
+
+	hopsNats, err := NewClient(authUrl, user.Account.Name, DefaultInterestTopic, nil)
+	require.NoError(t, err, "Client should initialise without a logger")
+	defer hopsNats.Close()
+
+	assert.Equal(t, user.Account.Name, hopsNats.AccountId())
+	assert.Equal(t, DefaultInterestTopic, hopsNats.InterestTopic())
+	assert.Equal(t, nameReplacer.Replace(user.Account.Name), hopsNats.StreamName())
+
+	_, _, err = hopsNats.Publish(context.Background(), []byte(`{}`), ChannelNotify, "SEQ_ID", SourceEventId)
+	assert.NoError(t, err, "Client without a logger should publish without error")
+}
+
+func TestNewClientStreamName(t *testing.T) {
+	ctx := context.Background()
+
+	localNats := setupLocalNatsServer(t)
+	defer localNats.Close()
+
+	logger := logs.NoOpLogger()
+	natsLogger := logs.NewNatsZeroLogger(logger)
+
+	authUrl, err := localNats.AuthUrl("")
+	require.NoError(t, err, "Test setup: Should have valid auth URL for NATS")
+
+	nc, err := localNats.Connect("")
+	require.NoError(t, err, "Test setup: Should connect to NATS")
+	defer nc.Drain()
+
+	js, err := jetstream.New(nc)
+	require.NoError(t, err, "Test setup: Should create JetStream context")
+
+	// One stream shared by two accounts, named after neither
+	accountIds := []string{"team-one", "team-two"}
+	stream, err := js.CreateStream(ctx, jetstream.StreamConfig{
+		Name:     "shared",
+		Subjects: []string{"team-one.>", "team-two.>"},
+	})
+	require.NoError(t, err, "Test setup: Should create shared stream")
+
+	for _, accountId := range accountIds {
+		_, err = stream.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
+			Name:          fmt.Sprintf("%s-%s-%s", accountId, DefaultInterestTopic, ChannelNotify),
+			FilterSubject: NotifyFilterSubject(accountId, DefaultInterestTopic),
+			AckPolicy:     jetstream.AckExplicitPolicy,
+		})
+		require.NoError(t, err, "Test setup: Should create runner consumer")
+	}
+
+	clients := map[string]*Client{}
+	for _, accountId := range accountIds {
+		client, err := NewClient(authUrl, accountId, DefaultInterestTopic, &natsLogger, WithStreamName("shared"), WithRunner(DefaultConsumerName))
+		require.NoError(t, err, "Client should initialise with a stream named apart from its account")
+		defer client.Close()
+
+		assert.Equal(t, "shared", client.StreamName())
+		assert.Equal(t, accountId, client.AccountId())
+
+		_, _, err = client.Publish(ctx, []byte(`{}`), ChannelNotify, "SEQ_"+accountId, SourceEventId)
+		require.NoError(t, err, "Client should publish to the shared stream")
+
+		clients[accountId] = client
+	}
+
+	for _, accountId := range accountIds {
+		client := clients[accountId]
+
+		_, err = client.GetMsg(ctx, ChannelNotify, "SEQ_"+accountId, SourceEventId)
+		assert.NoError(t, err, "Client should get its account's messages from the shared stream")
+
+		msgs, err := client.GetSequenceMessages(ctx, "SEQ_"+accountId)
+		require.NoError(t, err, "Client should get its account's sequences from the shared stream")
+		assert.Len(t, msgs, 1)
+	}
+
+	_, err = clients["team-one"].GetMsg(ctx, ChannelNotify, "SEQ_team-two", SourceEventId)
+	assert.ErrorIs(t, err, jetstream.ErrMsgNotFound, "Accounts sharing a stream should only see their own messages")
+}
+
+func TestParseServers(t *testing.T) {
+	type testCase struct {
+		name     string
+		natsUrl  string
+		expected []string
+	}
+
+	tests := []testCase{
+		{
+			name:     "Single URL",
+			natsUrl:  "nats://one:4222",
+			expected: []string{"nats://one:4222"},
+		},
+		{
+			name:     "Comma separated URLs",
+			natsUrl:  "nats://one:4222, nats://two:4222,nats://three:4222",
+			expected: []string{"nats://one:4222", "nats://two:4222", "nats://three:4222"},
+		},
+		{
+			name:     "Empty entries dropped",
+			natsUrl:  ",nats://one:4222,, ",
+			expected: []string{"nats://one:4222"},
+		},
+		{
+			name:     "Empty",
+			natsUrl:  "",
+			expected: []string{},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, parseServers(tc.natsUrl))
+		})
+	}
+}
+
 func TestClientConsume(t *testing.T) {
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
@@ -60,6 +226,220 @@ func TestClientConsume(t *testing.T) {
 	}
 }
 
+func TestClientConnectionStateHandler(t *testing.T) {
+	localNats := setupLocalNatsServer(t)
+	defer localNats.Close()
+
+	logger := logs.NoOpLogger()
+	natsLogger := logs.NewNatsZeroLogger(logger)
+
+	authUrl, err := localNats.AuthUrl("")
+	require.NoError(t, err, "Test setup: Should have valid auth URL for NATS")
+
+	user, err := localNats.User("")
+	require.NoError(t, err, "Test setup: Should have valid NATS user")
+
+	states := make(chan ConnectionState, 10)
+	hopsNats, err := NewClient(
+		authUrl,
+		user.Account.Name,
+		DefaultInterestTopic,
+		&natsLogger,
+		WithConnectionStateHandler(func(state ConnectionState, err error) {
+			states <- state
+		}),
+	)
+	require.NoError(t, err, "Test setup: Client should initialise without error")
+
+	waitForState := func(expected ConnectionState) {
+		t.Helper()
+
+		select {
+		case state := <-states:
+			assert.Equal(t, expected, state)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Handler should be notified the connection %s", expected)
+		}
+	}
+
+	clientId, err := hopsNats.NatsConn.GetClientID()
+	require.NoError(t, err, "Test setup: Should have a client ID")
+
+	err = localNats.NatsServer.DisconnectClientByID(clientId)
+	require.NoError(t, err, "Test setup: Server should disconnect the client")
+
+	waitForState(ConnectionDisconnected)
+	waitForState(ConnectionReconnected)
+	assert.True(t, hopsNats.NatsConn.IsConnected(), "Client should be connected again")
+
+	// Closing disconnects the client before it's closed
+	hopsNats.Close()
+	waitForState(ConnectionDisconnected)
+	waitForState(ConnectionClosed)
+}
+
+// warnRecorder is a Logger that records warnings, discarding everything else
+type warnRecorder struct {
+	noopLogger
+	warnings []string
+}
+
+func (w *warnRecorder) Warnf(format string, v ...interface{}) {
+	w.warnings = append(w.warnings, fmt.Sprintf(format, v...))
+}
+
+func TestClientNamePrefix(t *testing.T) {
+	localNats := setupLocalNatsServer(t)
+	defer localNats.Close()
+
+	logger := logs.NoOpLogger()
+	natsLogger := logs.NewNatsZeroLogger(logger)
+
+	authUrl, err := localNats.AuthUrl("")
+	require.NoError(t, err, "Test setup: Should have valid auth URL for NATS")
+
+	user, err := localNats.User("")
+	require.NoError(t, err, "Test setup: Should have valid NATS user")
+
+	client, err := NewClient(authUrl, user.Account.Name, DefaultInterestTopic, &natsLogger, WithNamePrefix("staging"), WithWorker("app"))
+	require.NoError(t, err, "Client should initialise with a name prefix")
+	defer client.Close()
+
+	expectedName := nameReplacer.Replace(fmt.Sprintf("staging-%s-%s-%s-app", user.Account.Name, DefaultInterestTopic, ChannelRequest))
+	assert.Equal(t, expectedName, client.Consumers["app"].CachedInfo().Name, "Consumer name should be prefixed")
+
+	_, err = NewClient(authUrl, user.Account.Name, DefaultInterestTopic, &natsLogger, WithWorker("app"), WithNamePrefix("staging"))
+	assert.Error(t, err, "WithNamePrefix should not be accepted once consumers are created")
+}
+
+func TestClientWorkerConsumerConfig(t *testing.T) {
+	localNats := setupLocalNatsServer(t)
+	defer localNats.Close()
+
+	authUrl, err := localNats.AuthUrl("")
+	require.NoError(t, err, "Test setup: Should have valid auth URL for NATS")
+
+	user, err := localNats.User("")
+	require.NoError(t, err, "Test setup: Should have valid NATS user")
+
+	newWorkerClient := func(conf WorkerConsumerConfig) (*Client, *warnRecorder) {
+		logger := &warnRecorder{}
+		client, err := NewClient(
+			authUrl,
+			user.Account.Name,
+			DefaultInterestTopic,
+			logger,
+			WithWorkerConsumerConfig(conf),
+			WithWorker("app"),
+		)
+		require.NoError(t, err, "Worker client should initialise without error")
+		t.Cleanup(client.Close)
+
+		return client, logger
+	}
+
+	conf := WorkerConsumerConfig{
+		AckWait:       30 * time.Second,
+		BackOff:       []time.Duration{time.Second, 5 * time.Second},
+		MaxAckPending: 10,
+		MaxDeliver:    5,
+	}
+
+	client, logger := newWorkerClient(conf)
+	created := client.Consumers["app"].CachedInfo().Config
+	assert.Equal(t, conf.BackOff, created.BackOff)
+	assert.Equal(t, 10, created.MaxAckPending)
+	assert.Equal(t, 5, created.MaxDeliver)
+	assert.Empty(t, logger.warnings, "Creating a consumer should not warn")
+
+	_, logger = newWorkerClient(conf)
+	assert.Empty(t, logger.warnings, "Matching config should not warn")
+
+	_, logger = newWorkerClient(WorkerConsumerConfig{})
+	assert.Empty(t, logger.warnings, "Unset fields should not be compared")
+
+	client, logger = newWorkerClient(WorkerConsumerConfig{MaxDeliver: 10, BackOff: conf.BackOff})
+	assert.Len(t, logger.warnings, 1, "Differing config should warn")
+	assert.Equal(t, 5, client.Consumers["app"].CachedInfo().Config.MaxDeliver, "Existing consumer should be unchanged")
+
+	client, _ = newWorkerClient(WorkerConsumerConfig{MaxDeliver: 10, BackOff: conf.BackOff, Reconfigure: true})
+	assert.Equal(t, 10, client.Consumers["app"].CachedInfo().Config.MaxDeliver, "Existing consumer should be reconfigured when asked")
+}
+
+func TestClientWorkerScope(t *testing.T) {
+	ctx := context.Background()
+	localNats := setupLocalNatsServer(t)
+	defer localNats.Close()
+
+	authUrl, err := localNats.AuthUrl("")
+	require.NoError(t, err, "Test setup: Should have valid auth URL for NATS")
+
+	user, err := localNats.User("")
+	require.NoError(t, err, "Test setup: Should have valid NATS user")
+
+	newWorkerClient := func(appName string, handlers ...string) (*Client, error) {
+		client, err := NewClient(authUrl, user.Account.Name, DefaultInterestTopic, nil, WithWorker(appName, handlers...))
+		if err == nil {
+			t.Cleanup(client.Close)
+		}
+
+		return client, err
+	}
+
+	unscoped, err := newWorkerClient("app")
+	require.NoError(t, err, "Unscoped worker client should initialise without error")
+	assert.Nil(t, unscoped.WorkerHandlers("app"))
+
+	scoped, err := newWorkerClient("scoped", "deploy", "build", "deploy")
+	require.NoError(t, err, "Scoped worker client should initialise without error")
+	assert.Equal(t, []string{"build", "deploy"}, scoped.WorkerHandlers("scoped"), "Handlers should be sorted and deduplicated")
+
+	scopedInfo := scoped.Consumers["scoped"].CachedInfo()
+	assert.Len(t, scopedInfo.Config.FilterSubjects, 2)
+
+	rest, err := newWorkerClient("scoped", "test")
+	require.NoError(t, err, "Worker client scoped to the rest of the handlers should initialise without error")
+	assert.NotEqual(t, scopedInfo.Name, rest.Consumers["scoped"].CachedInfo().Name, "Each scope should have its own consumer")
+
+	peer, err := newWorkerClient("scoped", "build", "deploy")
+	require.NoError(t, err, "Workers with the same scope should share a consumer")
+	assert.Equal(t, scopedInfo.Name, peer.Consumers["scoped"].CachedInfo().Name)
+
+	for _, appName := range []string{"app", "scoped"} {
+		for _, handler := range []string{"build", "deploy", "test"} {
+			_, _, err := scoped.Publish(ctx, []byte("{}"), ChannelRequest, "SEQ_ID", appName+handler, appName, handler)
+			require.NoError(t, err, "Test setup: Request should be published")
+		}
+	}
+
+	info, err := scoped.Consumers["scoped"].Info(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), info.NumPending, "Scoped consumer should only receive requests for its handlers")
+
+	info, err = rest.Consumers["scoped"].Info(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), info.NumPending, "Scoped consumers shouldn't receive each other's requests")
+
+	info, err = unscoped.Consumers["app"].Info(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), info.NumPending, "Unscoped consumer should receive requests for every handler of its app")
+
+	overlapping := map[string][]string{
+		"Scoped when unscoped exists": {"app", "deploy"},
+		"Unscoped when scoped exists": {"scoped"},
+		"Overlapping scopes":          {"scoped", "deploy", "lint"},
+	}
+	for name, worker := range overlapping {
+		_, err := newWorkerClient(worker[0], worker[1:]...)
+		assert.ErrorIs(t, err, ErrWorkerScopeOverlap, name)
+	}
+
+	for _, handler := range []string{"", "de.ploy", "*"} {
+		_, err := newWorkerClient("invalid", handler)
+		assert.Error(t, err, "Handler '%s' should be invalid", handler)
+	}
+}
+
 type testSequenceHandler struct {
 	receivedChan chan MessageBundle
 }
@@ -70,54 +450,218 @@ func (t *testSequenceHandler) SequenceCallback(ctx context.Context, sequenceId s
 }
 
 func TestClientConsumeSequences(t *testing.T) {
-	ctx := context.Background()
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-
-	hopsNats, cleanup := setupClient(ctx, t)
-	defer cleanup()
+	type testCase struct {
+		name   string
+		client func(ctx context.Context, t testing.TB) (*Client, func())
+	}
 
-	receivedChan := make(chan MessageBundle)
-	expectedBundleOne := MessageBundle{
-		"event": []byte("One"),
+	tests := []testCase{
+		{
+			name:   "JetStream",
+			client: setupClient,
+		},
+		{
+			name:   "Core NATS",
+			client: setupCoreClient,
+		},
 	}
-	expectedBundleTwo := MessageBundle{
-		"event":     []byte("One"),
-		"event-two": []byte("Two"),
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			hopsNats, cleanup := tc.client(ctx, t)
+			defer cleanup()
+
+			receivedChan := make(chan MessageBundle)
+			expectedBundleOne := MessageBundle{
+				"event": []byte("One"),
+			}
+			expectedBundleTwo := MessageBundle{
+				"event":     []byte("One"),
+				"event-two": []byte("Two"),
+			}
+			expectedBundleThree := MessageBundle{
+				"event":       []byte("One"),
+				"event-two":   []byte("Two"),
+				"event-three": []byte("Three"),
+			}
+
+			sqncHandler := &testSequenceHandler{receivedChan: receivedChan}
+
+			go func() {
+				hopsNats.ConsumeSequences(ctx, DefaultConsumerName, sqncHandler)
+			}()
+
+			// Core NATS doesn't retain messages, so anything published before
+			// subscribing would be lost
+			if hopsNats.memStore != nil {
+				require.Eventually(t, func() bool {
+					return hopsNats.NatsConn.NumSubscriptions() > 0
+				}, time.Second, 10*time.Millisecond, "Test setup: Client should subscribe")
+				require.NoError(t, hopsNats.NatsConn.Flush())
+			}
+
+			_, _, err := hopsNats.Publish(ctx, []byte("One"), ChannelNotify, "SEQ_ID", "event")
+			if assert.NoError(t, err, "Message should be published without error") {
+				receivedMsgBundle := <-receivedChan
+				assert.Equal(t, receivedMsgBundle, expectedBundleOne)
+			}
+
+			_, _, err = hopsNats.Publish(ctx, []byte("Two"), ChannelNotify, "SEQ_ID", "event-two")
+			if assert.NoError(t, err, "Second message in sequence should be published without error") {
+				receivedMsgBundle := <-receivedChan
+				assert.Equal(t, receivedMsgBundle, expectedBundleTwo)
+			}
+
+			_, _, err = hopsNats.Publish(ctx, []byte("Three"), ChannelNotify, "SEQ_ID", "event-three")
+			if assert.NoError(t, err, "Third message in sequence should be published without error") {
+				receivedMsgBundle := <-receivedChan
+				assert.Equal(t, receivedMsgBundle, expectedBundleThree)
+			}
+
+			_, sent, err := hopsNats.Publish(ctx, []byte("Again"), ChannelNotify, "SEQ_ID", "event-three")
+			assert.NoError(t, err, "Duplicate message should be skipped without error")
+			assert.False(t, sent, "Duplicate message should not be sent")
+
+			rawMsg, err := hopsNats.GetMsg(ctx, ChannelNotify, "SEQ_ID", "event-two")
+			if assert.NoError(t, err, "Message should be retrieved without error") {
+				assert.Equal(t, []byte("Two"), rawMsg.Data)
+			}
+		})
 	}
-	expectedBundleThree := MessageBundle{
-		"event":       []byte("One"),
-		"event-two":   []byte("Two"),
-		"event-three": []byte("Three"),
+}
+
+type orderRecordingHandler struct {
+	received chan []MessageEntry
+}
+
+func (o *orderRecordingHandler) SequenceCallback(ctx context.Context, sequenceId string, msgBundle MessageBundle) error {
+	entries, _ := BundleOrderFromContext(ctx)
+	o.received <- entries
+	return nil
+}
+
+func TestClientFetchOrderedMessages(t *testing.T) {
+	tests := []struct {
+		name   string
+		client func(ctx context.Context, t testing.TB) (*Client, func())
+	}{
+		{
+			name:   "JetStream",
+			client: setupClient,
+		},
+		{
+			name:   "Core NATS",
+			client: setupCoreClient,
+		},
 	}
 
-	sqncHandler := &testSequenceHandler{receivedChan: receivedChan}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
 
-	go func() {
-		hopsNats.ConsumeSequences(ctx, DefaultConsumerName, sqncHandler)
-	}()
+			hopsNats, cleanup := tc.client(ctx, t)
+			defer cleanup()
+
+			handler := &orderRecordingHandler{received: make(chan []MessageEntry, 3)}
+			go hopsNats.ConsumeSequences(ctx, DefaultConsumerName, handler)
+
+			if hopsNats.memStore != nil {
+				require.Eventually(t, func() bool {
+					return hopsNats.NatsConn.NumSubscriptions() > 0
+				}, time.Second, 10*time.Millisecond, "Test setup: Client should subscribe")
+				require.NoError(t, hopsNats.NatsConn.Flush())
+			}
+
+			// Keys are chosen so map and alphabetical order differ from publish order
+			keys := []string{"zulu", "alpha", "mike"}
+			var entries []MessageEntry
+			for _, key := range keys {
+				_, _, err := hopsNats.Publish(ctx, []byte(key), ChannelNotify, "SEQ_ID", key)
+				require.NoError(t, err, "Test setup: Message should be published")
+
+				select {
+				case entries = <-handler.received:
+				case <-time.After(5 * time.Second):
+					require.FailNow(t, "Timed out waiting for sequence to be consumed")
+				}
+			}
+
+			require.Len(t, entries, len(keys), "Handler should be given every message of the bundle in order")
+			for i, entry := range entries {
+				assert.Equal(t, keys[i], entry.Key)
+				assert.Equal(t, []byte(keys[i]), entry.Data)
+				if i > 0 {
+					assert.Greater(t, entry.StreamSequence, entries[i-1].StreamSequence, "Messages should be ordered by stream sequence")
+				}
+			}
+
+			fetched, err := hopsNats.FetchOrderedMessages(ctx, &MsgMeta{
+				AccountId:      hopsNats.AccountId(),
+				InterestTopic:  hopsNats.InterestTopic(),
+				SequenceId:     "SEQ_ID",
+				StreamSequence: entries[len(entries)-1].StreamSequence,
+			})
+			require.NoError(t, err, "Messages should be fetched without error")
+			assert.Equal(t, entries, fetched, "Fetching should give the same order every time")
+			assert.Equal(t, MessageBundle{"zulu": []byte("zulu"), "alpha": []byte("alpha"), "mike": []byte("mike")}, NewMessageBundle(fetched))
+		})
+	}
+}
 
-	_, _, err := hopsNats.Publish(ctx, []byte("One"), ChannelNotify, "SEQ_ID", "event")
-	if assert.NoError(t, err, "Message should be published without error") {
-		receivedMsgBundle := <-receivedChan
-		assert.Equal(t, receivedMsgBundle, expectedBundleOne)
+// BenchmarkFetchOrderedMessages measures fetching the bundle of a sequence as
+// each of its messages arrives, i.e. the fetching done over a sequence's lifetime
+//
+// Compare with and without the bundle cache by running with -bench=FetchOrderedMessages
+func BenchmarkFetchOrderedMessages(b *testing.B) {
+	for _, cacheSize := range []int{0, 64 << 20} {
+		for _, size := range []int{10, 100, 500} {
+			b.Run(fmt.Sprintf("cache=%d/messages=%d", cacheSize, size), func(b *testing.B) {
+				benchmarkFetchOrderedMessages(b, cacheSize, size)
+			})
+		}
 	}
+}
+
+func benchmarkFetchOrderedMessages(b *testing.B, cacheSize int, size int) {
+	ctx := context.Background()
+	hopsNats, cleanup := setupClient(ctx, b)
+	defer cleanup()
+	hopsNats.bundleCache = newBundleCache(cacheSize, DefaultBundleCacheTTL)
 
-	_, _, err = hopsNats.Publish(ctx, []byte("Two"), ChannelNotify, "SEQ_ID", "event-two")
-	if assert.NoError(t, err, "Second message in sequence should be published without error") {
-		receivedMsgBundle := <-receivedChan
-		assert.Equal(t, receivedMsgBundle, expectedBundleTwo)
+	arrivals := []*MsgMeta{}
+	for i := 0; i < size; i++ {
+		pubAck, _, err := hopsNats.Publish(ctx, []byte(`{"value": 1}`), ChannelNotify, "SEQ_ID", fmt.Sprintf("call-%d", i), DoneMessageId)
+		require.NoError(b, err, "Test setup: Message should be published")
+
+		arrivals = append(arrivals, &MsgMeta{
+			AccountId:      hopsNats.AccountId(),
+			InterestTopic:  hopsNats.InterestTopic(),
+			SequenceId:     "SEQ_ID",
+			StreamSequence: pubAck.Sequence,
+		})
 	}
 
-	_, _, err = hopsNats.Publish(ctx, []byte("Three"), ChannelNotify, "SEQ_ID", "event-three")
-	if assert.NoError(t, err, "Third message in sequence should be published without error") {
-		receivedMsgBundle := <-receivedChan
-		assert.Equal(t, receivedMsgBundle, expectedBundleThree)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		// Each iteration is a fresh lifetime of the sequence
+		hopsNats.bundleCache.invalidate(arrivals[0].SequenceFilter())
+
+		for _, incomingMsg := range arrivals {
+			_, err := hopsNats.FetchOrderedMessages(ctx, incomingMsg)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
 	}
 }
 
 // setupClient is a test helper to create an instance of HopsNats with a local NATS server
-func setupClient(ctx context.Context, t *testing.T) (*Client, func()) {
+func setupClient(ctx context.Context, t testing.TB) (*Client, func()) {
 	localNats := setupLocalNatsServer(t)
 
 	logger := logs.NoOpLogger()
@@ -139,3 +683,479 @@ func setupClient(ctx context.Context, t *testing.T) (*Client, func()) {
 
 	return hopsNats, cleanup
 }
+
+// setupCoreClient is a test helper to create a core NATS client connected to a local NATS server
+func setupCoreClient(ctx context.Context, t testing.TB) (*Client, func()) {
+	localNats := setupLocalNatsServer(t)
+
+	logger := logs.NoOpLogger()
+	natsLogger := logs.NewNatsZeroLogger(logger)
+
+	authUrl, err := localNats.AuthUrl("")
+	require.NoError(t, err, "Test setup: Should have valid auth URL for NATS")
+
+	user, err := localNats.User("")
+	require.NoError(t, err, "Test setup: Should have valid NATS user")
+
+	hopsNats, err := NewCoreClient(authUrl, user.Account.Name, DefaultInterestTopic, &natsLogger)
+	require.NoError(t, err, "Test setup: Core client should initialise without error")
+
+	cleanup := func() {
+		hopsNats.Close()
+		localNats.Close()
+	}
+
+	return hopsNats, cleanup
+}
+
+func TestClientPublishBatch(t *testing.T) {
+	ctx := context.Background()
+
+	hopsNats, cleanup := setupClient(ctx, t)
+	defer cleanup()
+
+	items := []PublishItem{
+		{Data: []byte("One"), SubjTokens: []string{ChannelNotify, "SEQ_ONE", "event"}},
+		{Data: []byte("Two"), SubjTokens: []string{ChannelNotify, "SEQ_TWO", "event"}},
+		{Data: []byte("Duplicate"), SubjTokens: []string{ChannelNotify, "SEQ_ONE", "event"}},
+	}
+
+	results, err := hopsNats.PublishBatch(ctx, items)
+	require.NoError(t, err, "Batch should be published without error")
+	require.Len(t, results, len(items), "A result should be returned for every item")
+
+	expectedSent := []bool{true, true, false}
+	for i, result := range results {
+		assert.NoError(t, result.Err)
+		assert.Equal(t, expectedSent[i], result.Sent, "Only duplicate messages should be skipped")
+		assert.Contains(t, result.Subject, items[i].SubjTokens[1])
+	}
+
+	msg, err := hopsNats.GetMsg(ctx, ChannelNotify, "SEQ_ONE", "event")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []byte("One"), msg.Data, "Duplicate message should not replace the original")
+	}
+}
+
+func TestClientPublishSystem(t *testing.T) {
+	ctx := context.Background()
+
+	hopsNats, cleanup := setupClient(ctx, t)
+	defer cleanup()
+
+	sub, err := hopsNats.NatsConn.SubscribeSync(DefaultSystemNamespace + ".>")
+	require.NoError(t, err, "Test setup: Should subscribe to system subjects")
+
+	err = hopsNats.PublishSystem(ctx, []byte("Hello system"), "worker", "heartbeat")
+	require.NoError(t, err, "System message should be published without error")
+
+	msg, err := sub.NextMsg(time.Second)
+	if assert.NoError(t, err, "System message should be received") {
+		assert.Equal(t, "hiphops-system.worker.heartbeat", msg.Subject)
+		assert.Equal(t, []byte("Hello system"), msg.Data)
+	}
+
+	err = hopsNats.PublishSystem(ctx, []byte("No tokens"))
+	assert.Error(t, err, "System messages require subject tokens")
+}
+
+func TestClientPublishResult(t *testing.T) {
+	ctx := context.Background()
+	hopsNats, cleanup := setupClient(ctx, t)
+	defer cleanup()
+
+	output := map[string]interface{}{"number": 42, "url": "https://example.com/42"}
+	err, sent := hopsNats.PublishResult(ctx, time.Now(), output, nil, ChannelNotify, "SEQ_ID", "a_sensor-call")
+	require.NoError(t, err, "Result should be published without error")
+	require.True(t, sent)
+
+	rawMsg, err := hopsNats.GetMsg(ctx, ChannelNotify, "SEQ_ID", "a_sensor-call")
+	require.NoError(t, err)
+
+	incomingMsg := &MsgMeta{
+		AccountId:      hopsNats.accountId,
+		InterestTopic:  hopsNats.interestTopic,
+		SequenceId:     "SEQ_ID",
+		StreamSequence: rawMsg.Sequence,
+	}
+	bundle, err := hopsNats.FetchMessageBundle(ctx, incomingMsg)
+	require.NoError(t, err, "Message bundle should be fetched without error")
+	require.Contains(t, bundle, "a_sensor-call")
+
+	resultMsg, err := ParseResultMsg(bundle["a_sensor-call"])
+	require.NoError(t, err, "Result message should be parsed without error")
+	assert.True(t, resultMsg.Completed)
+	assert.Equal(t, "https://example.com/42", resultMsg.JSON.(map[string]interface{})["url"])
+
+	// Result output should be available to hops configs as `call.json.field`
+	ctyVal, err := dsl.AnyJSONToCtyValue(bundle["a_sensor-call"])
+	require.NoError(t, err, "Result message should convert to a cty value")
+	number, _ := ctyVal.GetAttr("json").GetAttr("number").AsBigFloat().Int64()
+	assert.Equal(t, int64(42), number)
+}
+
+func TestClientPublishCompletion(t *testing.T) {
+	ctx := context.Background()
+	hopsNats, cleanup := setupClient(ctx, t)
+	defer cleanup()
+
+	sent, err := hopsNats.PublishCompletion(ctx, NewCompletionMsg(time.Now(), 1, nil), "SEQ_ID")
+	require.NoError(t, err, "Completion should be published without error")
+	assert.True(t, sent)
+
+	sent, err = hopsNats.PublishCompletion(ctx, NewCompletionMsg(time.Now(), 1, []string{"a_sensor-call"}), "SEQ_ID")
+	require.NoError(t, err, "Repeated completion should not error")
+	assert.False(t, sent, "A sequence should only be completed once")
+
+	msg, err := hopsNats.GetMsg(ctx, ChannelNotify, "SEQ_ID", SequenceMessageId, CompletedMessageId)
+	require.NoError(t, err)
+
+	completion := CompletionMsg{}
+	err = json.Unmarshal(msg.Data, &completion)
+	require.NoError(t, err)
+	assert.Equal(t, StatusSuccess, completion.Status, "The first completion should be kept")
+}
+
+func TestClientPublishTimeout(t *testing.T) {
+	ctx := context.Background()
+	hopsNats, cleanup := setupClient(ctx, t)
+	defer cleanup()
+
+	timeout := NewTimeoutMsg(time.Now(), []string{"a_sensor"}, []string{"a_sensor-call"})
+	sent, err := hopsNats.PublishTimeout(ctx, timeout, "SEQ_ID")
+	require.NoError(t, err, "Timeout should be published without error")
+	assert.True(t, sent)
+
+	sent, err = hopsNats.PublishTimeout(ctx, timeout, "SEQ_ID")
+	require.NoError(t, err, "Repeated timeout should not error")
+	assert.False(t, sent, "A sequence should only time out once")
+
+	msgs, err := hopsNats.fetchSequence(ctx, "SEQ_ID", 10)
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+
+	timeoutMeta, err := Parse(msgs[0])
+	require.NoError(t, err, "Timeout message should parse without error")
+	assert.True(t, timeoutMeta.TimedOut)
+}
+
+func TestClientPublishDeadline(t *testing.T) {
+	ctx := context.Background()
+	hopsNats, cleanup := setupClient(ctx, t)
+	defer cleanup()
+
+	deadline := time.Now().Add(time.Minute).Truncate(time.Millisecond)
+	sent, err := hopsNats.PublishDeadline(ctx, deadline, "SEQ_ID")
+	require.NoError(t, err, "Deadline should be published without error")
+	assert.True(t, sent)
+
+	sent, err = hopsNats.PublishDeadline(ctx, deadline, "SEQ_ID")
+	require.NoError(t, err, "Repeated deadline should not error")
+	assert.False(t, sent, "A deadline should only be scheduled once")
+
+	sent, err = hopsNats.PublishDeadline(ctx, deadline.Add(time.Minute), "SEQ_ID")
+	require.NoError(t, err, "Later deadline should be published without error")
+	assert.True(t, sent, "Each deadline of a sequence should be scheduled")
+
+	msgs, err := hopsNats.fetchSequence(ctx, "SEQ_ID", 10)
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+
+	deadlineMeta, err := Parse(msgs[0])
+	require.NoError(t, err, "Deadline message should parse without error")
+	assert.True(t, deadlineMeta.Deadline)
+
+	_, include := bundleEntry(deadlineMeta, msgs[0].Data())
+	assert.False(t, include, "Deadlines should not be part of the message bundle")
+
+	deadlineMsg := DeadlineMsg{}
+	err = json.Unmarshal(msgs[0].Data(), &deadlineMsg)
+	require.NoError(t, err)
+	assert.True(t, deadline.Equal(deadlineMsg.Deadline))
+}
+
+func TestClientSequencesWithResults(t *testing.T) {
+	ctx := context.Background()
+	hopsNats, cleanup := setupClient(ctx, t)
+	defer cleanup()
+
+	for _, call := range []string{"first", "second"} {
+		_, _, err := hopsNats.Publish(ctx, []byte(`{}`), ChannelNotify, "SEQ_RESULTS", call, DoneMessageId)
+		require.NoError(t, err, "Test setup: Result should be published")
+	}
+	_, _, err := hopsNats.Publish(ctx, []byte(`{}`), ChannelNotify, "SEQ_NONE", SourceEventId)
+	require.NoError(t, err, "Test setup: Source event should be published")
+
+	withResults, err := hopsNats.SequencesWithResults(ctx, []string{"SEQ_RESULTS", "SEQ_NONE", "SEQ_RESULTS", "SEQ_MISSING"})
+	require.NoError(t, err, "Sequences should be checked without error")
+	assert.Equal(t, map[string]bool{"SEQ_RESULTS": true}, withResults)
+
+	withResults, err = hopsNats.SequencesWithResults(ctx, nil)
+	require.NoError(t, err, "No sequences should be checked without error")
+	assert.Empty(t, withResults)
+}
+
+func TestClientPublishProgress(t *testing.T) {
+	ctx := context.Background()
+	hopsNats, cleanup := setupClient(ctx, t)
+	defer cleanup()
+
+	responseSubject := hopsNats.buildSubject(ChannelNotify, "SEQ_ID", "a_sensor-call")
+
+	for _, percent := range []int{10, 50} {
+		sent, err := hopsNats.PublishProgress(ctx, NewProgressMsg(percent, "Working"), responseSubject)
+		require.NoError(t, err, "Progress should be published without error")
+		require.True(t, sent, "Each progress message should be sent")
+	}
+
+	err, sent := hopsNats.PublishResult(ctx, time.Now(), "Finished", nil, responseSubject)
+	require.NoError(t, err, "Result should be published without error")
+	require.True(t, sent, "Result should be sent after progress messages")
+
+	msgs, err := hopsNats.fetchSequence(ctx, "SEQ_ID", 10)
+	require.NoError(t, err)
+	require.Len(t, msgs, 3, "Progress messages should appear in the sequence")
+
+	progressMeta, err := Parse(msgs[0])
+	require.NoError(t, err, "Progress message should parse without error")
+	assert.True(t, progressMeta.Progress)
+	assert.Equal(t, "a_sensor-call", progressMeta.MessageId)
+
+	progress := ProgressMsg{}
+	err = json.Unmarshal(msgs[0].Data(), &progress)
+	require.NoError(t, err)
+	assert.Equal(t, StatusProgress, progress.Status)
+	assert.Equal(t, 10, progress.Percent)
+
+	resultMeta, err := Parse(msgs[2])
+	require.NoError(t, err)
+
+	bundle, err := hopsNats.FetchMessageBundle(ctx, resultMeta)
+	require.NoError(t, err, "Message bundle should be fetched without error")
+	resultMsg, err := ParseResultMsg(bundle["a_sensor-call"])
+	require.NoError(t, err)
+	assert.Equal(t, "Finished", resultMsg.Body, "Progress messages should not be mistaken for the result")
+
+	// Progress arriving after the result must not cause the result to be skipped
+	_, err = hopsNats.PublishProgress(ctx, NewProgressMsg(100, "Cleaning up"), responseSubject)
+	require.NoError(t, err)
+
+	superseded, err := hopsNats.isSuperseded(ctx, resultMeta)
+	require.NoError(t, err)
+	assert.False(t, superseded, "Progress messages should not supersede a result")
+}
+
+func TestClientPublishResultTooLarge(t *testing.T) {
+	ctx := context.Background()
+	hopsNats, cleanup := setupClient(ctx, t)
+	defer cleanup()
+
+	output := map[string]string{"body": strings.Repeat("a", int(hopsNats.NatsConn.MaxPayload()))}
+	err, sent := hopsNats.PublishResult(ctx, time.Now(), output, nil, ChannelNotify, "SEQ_ID", "MSG_ID")
+	assert.ErrorIs(t, err, ErrResultTooLarge, "Oversized results should not be published")
+	assert.False(t, sent)
+}
+
+func TestClientPurgeSequence(t *testing.T) {
+	ctx := context.Background()
+
+	hopsNats, cleanup := setupClient(ctx, t)
+	defer cleanup()
+
+	items := []PublishItem{
+		{Data: []byte("{}"), SubjTokens: []string{ChannelNotify, "SEQ_PURGE", "event"}},
+		{Data: []byte("{}"), SubjTokens: []string{ChannelRequest, "SEQ_PURGE", "a_call", "app", "handler"}},
+		{Data: []byte("{}"), SubjTokens: []string{ChannelNotify, "SEQ_PURGE", "a_call"}},
+		{Data: []byte("{}"), SubjTokens: []string{ChannelNotify, "SEQ_KEEP", "event"}},
+	}
+	_, err := hopsNats.PublishBatch(ctx, items)
+	require.NoError(t, err, "Test setup: Messages should be published")
+
+	purged, err := hopsNats.PurgeSequence(ctx, "SEQ_PURGE")
+	require.NoError(t, err, "Sequence should be purged without error")
+	assert.Equal(t, uint64(3), purged, "Every message in the sequence should be purged")
+
+	msgs, err := hopsNats.fetchSequence(ctx, "SEQ_PURGE", 10)
+	require.NoError(t, err)
+	assert.Empty(t, msgs, "Purged sequence should have no messages")
+
+	msgs, err = hopsNats.fetchSequence(ctx, "SEQ_KEEP", 10)
+	require.NoError(t, err)
+	assert.Len(t, msgs, 1, "Other sequences should be left alone")
+
+	purged, err = hopsNats.PurgeSequence(ctx, "SEQ_PURGE")
+	assert.NoError(t, err, "Purging an empty sequence should not error")
+	assert.Zero(t, purged)
+
+	for _, sequenceId := range []string{"", "*", ">", "SEQ.*"} {
+		_, err = hopsNats.PurgeSequence(ctx, sequenceId)
+		assert.Error(t, err, "Sequence ID '%s' should be refused", sequenceId)
+	}
+
+	msgs, err = hopsNats.fetchSequence(ctx, "SEQ_KEEP", 10)
+	require.NoError(t, err)
+	assert.Len(t, msgs, 1, "Refused purges should not remove anything")
+}
+
+func TestClientSequenceReplay(t *testing.T) {
+	ctx := context.Background()
+	hopsNats, cleanup := setupClient(ctx, t)
+	defer cleanup()
+
+	messageIds := []string{SourceEventId, "a_sensor-first", "a_sensor-second"}
+	for _, msgId := range messageIds {
+		_, _, err := hopsNats.Publish(ctx, []byte(msgId), ChannelNotify, "SEQ_ID", msgId)
+		require.NoError(t, err, "Test setup: Sequence messages should be published without error")
+	}
+
+	err := WithSequenceReplay("replay", "SEQ_ID", false)(hopsNats)
+	require.NoError(t, err, "Sequence should be replayed without error")
+
+	msgs, err := hopsNats.Consumers["replay"].Fetch(len(messageIds), jetstream.FetchMaxWait(time.Second))
+	require.NoError(t, err)
+
+	replayed := []string{}
+	for m := range msgs.Messages() {
+		parsed, err := Parse(m)
+		require.NoError(t, err)
+
+		assert.NotEqual(t, "SEQ_ID", parsed.SequenceId, "Messages should be replayed under a new sequence ID")
+		assert.Equal(t, parsed.MessageId, string(m.Data()))
+		replayed = append(replayed, parsed.MessageId)
+	}
+
+	assert.Equal(t, messageIds, replayed, "Messages should be replayed in their original order")
+
+	_, err = hopsNats.fetchSequence(ctx, "SEQ_ID", len(messageIds)-1)
+	assert.Error(t, err, "Sequences over the limit should not be fetched")
+}
+
+func TestClientReplayMode(t *testing.T) {
+	ctx := context.Background()
+	hopsNats, cleanup := setupClient(ctx, t)
+	defer cleanup()
+
+	sourceEvent, _, err := CreateSourceEvent(map[string]any{"value": 1}, "fake", "testevent", "foo", "")
+	require.NoError(t, err, "Test setup: Source event should be created without error")
+	_, _, err = hopsNats.Publish(ctx, sourceEvent, ChannelNotify, "SEQ_ID", SourceEventId)
+	require.NoError(t, err, "Test setup: Source event should be published without error")
+
+	err = WithReplayMode("nonsense")(hopsNats)
+	assert.Error(t, err, "Unknown replay modes should be rejected")
+
+	err = WithReplayMode(ReplayModeEvaluate)(hopsNats)
+	require.NoError(t, err)
+	err = WithReplay("replay", "SEQ_ID")(hopsNats)
+	require.NoError(t, err, "Source event should be replayed without error")
+
+	msgs, err := hopsNats.Consumers["replay"].Fetch(1, jetstream.FetchMaxWait(time.Second))
+	require.NoError(t, err)
+
+	replayed := <-msgs.Messages()
+	require.NotNil(t, replayed, "Source event should be replayed")
+
+	parsed, err := Parse(replayed)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(parsed.SequenceId, ReplaySequencePrefix))
+
+	sourceMeta, err := ParseSourceMeta(replayed.Data())
+	require.NoError(t, err)
+	assert.Equal(t, "testevent", sourceMeta.Event, "The rest of the event should be untouched")
+	assert.Equal(t, &ReplayMeta{Mode: ReplayModeEvaluate, SequenceId: "SEQ_ID"}, sourceMeta.Replay)
+	assert.True(t, sourceMeta.EvaluateOnly())
+
+	// Events that can't carry the mode must not be replayed, as they'd be fully evaluated
+	_, _, err = hopsNats.Publish(ctx, []byte("not json"), ChannelNotify, "OTHER_SEQ_ID", SourceEventId)
+	require.NoError(t, err, "Test setup: Source event should be published without error")
+
+	err = WithReplay("other_replay", "OTHER_SEQ_ID")(hopsNats)
+	assert.Error(t, err, "Unmarkable events should not be replayed in evaluate mode")
+}
+
+func TestClientReplayEvent(t *testing.T) {
+	ctx := context.Background()
+	hopsNats, cleanup := setupClient(ctx, t)
+	defer cleanup()
+
+	sourceEvent, _, err := CreateSourceEvent(map[string]any{"value": 1}, "fake", "testevent", "foo", "")
+	require.NoError(t, err, "Test setup: Source event should be created without error")
+	_, _, err = hopsNats.Publish(ctx, sourceEvent, ChannelNotify, "SEQ_ID", SourceEventId)
+	require.NoError(t, err, "Test setup: Source event should be published without error")
+
+	replaySequenceId, err := hopsNats.ReplayEvent(ctx, "SEQ_ID", ReplayModeEvaluate)
+	require.NoError(t, err, "Source event should be replayed without error")
+	assert.True(t, strings.HasPrefix(replaySequenceId, ReplaySequencePrefix))
+
+	replayed, err := hopsNats.GetMsg(ctx, ChannelNotify, replaySequenceId, SourceEventId)
+	require.NoError(t, err, "Source event should be republished under the replay sequence ID")
+	assert.Equal(t, SourceEventSubject(hopsNats.AccountId(), hopsNats.InterestTopic(), replaySequenceId), replayed.Subject)
+
+	sourceMeta, err := ParseSourceMeta(replayed.Data)
+	require.NoError(t, err)
+	assert.Equal(t, &ReplayMeta{Mode: ReplayModeEvaluate, SequenceId: "SEQ_ID"}, sourceMeta.Replay)
+
+	_, err = hopsNats.ReplayEvent(ctx, "MISSING_SEQ_ID", "")
+	assert.ErrorIs(t, err, jetstream.ErrMsgNotFound, "Sequences without a source event should not be replayed")
+
+	for _, sequenceId := range []string{"", "SEQ.ID", "*", "SEQ ID"} {
+		_, err = hopsNats.ReplayEvent(ctx, sequenceId, "")
+		assert.Error(t, err, "Sequence ID '%s' should be refused", sequenceId)
+	}
+
+	_, err = hopsNats.ReplayEvent(ctx, "SEQ_ID", "nonsense")
+	assert.Error(t, err, "Unknown replay modes should be rejected")
+
+	replaySequenceId, err = hopsNats.ReplaySourceEvent(ctx, "SEQ_ID", sourceEvent, "")
+	require.NoError(t, err, "Fetched source event should be replayed without error")
+
+	replayed, err = hopsNats.GetMsg(ctx, ChannelNotify, replaySequenceId, SourceEventId)
+	require.NoError(t, err, "Fetched source event should be republished under the replay sequence ID")
+	sourceMeta, err = ParseSourceMeta(replayed.Data)
+	require.NoError(t, err)
+	assert.Equal(t, &ReplayMeta{Mode: ReplayModeFull, SequenceId: "SEQ_ID"}, sourceMeta.Replay)
+}
+
+func TestClientIsSuperseded(t *testing.T) {
+	ctx := context.Background()
+	hopsNats, cleanup := setupClient(ctx, t)
+	defer cleanup()
+
+	msgMeta := func(msgId string) *MsgMeta {
+		rawMsg, err := hopsNats.GetMsg(ctx, ChannelNotify, "SEQ_ID", msgId)
+		require.NoError(t, err)
+
+		return &MsgMeta{
+			AccountId:      hopsNats.accountId,
+			InterestTopic:  hopsNats.interestTopic,
+			SequenceId:     "SEQ_ID",
+			StreamSequence: rawMsg.Sequence,
+		}
+	}
+
+	for _, msgId := range []string{SourceEventId, HopsMessageId} {
+		_, _, err := hopsNats.Publish(ctx, []byte(`{}`), ChannelNotify, "SEQ_ID", msgId)
+		require.NoError(t, err)
+	}
+
+	superseded, err := hopsNats.isSuperseded(ctx, msgMeta(SourceEventId))
+	require.NoError(t, err)
+	assert.False(t, superseded, "Hops assignment message should not supersede the source event")
+
+	_, _, err = hopsNats.Publish(ctx, []byte(`{}`), ChannelNotify, "SEQ_ID", "a_sensor-call")
+	require.NoError(t, err)
+
+	superseded, err = hopsNats.isSuperseded(ctx, msgMeta(SourceEventId))
+	require.NoError(t, err)
+	assert.True(t, superseded, "Call result should supersede the source event")
+
+	superseded, err = hopsNats.isSuperseded(ctx, msgMeta("a_sensor-call"))
+	require.NoError(t, err)
+	assert.False(t, superseded, "Latest message in the sequence should not be superseded")
+
+	_, _, err = hopsNats.Publish(ctx, []byte(`{}`), ChannelNotify, "SEQ_ID", "a_sensor-call", DoneMessageId)
+	require.NoError(t, err)
+
+	superseded, err = hopsNats.isSuperseded(ctx, msgMeta("a_sensor-call"))
+	require.NoError(t, err)
+	assert.False(t, superseded, "Done message should not supersede the call result")
+}
@@ -0,0 +1,272 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// ErrEventLookbackTooLong is returned when listing events over more time than
+// MaxEventLookback allows, which would scan too much of the stream
+var ErrEventLookbackTooLong = fmt.Errorf("Events can be listed over %s at most", -MaxEventLookback)
+
+type (
+	// EventHistoryQuery selects a page of events to list with ListEventHistory
+	EventHistoryQuery struct {
+		// After is when events are listed from (zero is DefaultEventLookback before Before)
+		After time.Time
+		// Before is when events are listed up to, exclusive (zero is now)
+		Before time.Time
+		// BeforeSequence excludes events from this stream sequence onwards, so a
+		// page can continue from the last event of the previous one
+		BeforeSequence uint64
+		// EventType only includes source events of a type, optionally with an
+		// action in the same form as on blocks, e.g. "github" or "github_push"
+		EventType string
+		// Limit is the number of events listed, up to GetEventHistoryEventLimit
+		// (zero is the max)
+		Limit      int
+		SourceOnly bool
+	}
+
+	// EventHistoryPage is a page of events, most recent first
+	EventHistoryPage struct {
+		Events []*MsgMeta
+		// More is true if further events matched, which are before the last
+		// event of the page
+		More bool
+	}
+)
+
+// ListEventHistory lists a page of the events matching query, most recent first
+//
+// Only the events received between the query's After and Before are scanned,
+// which may be at most MaxEventLookback apart (or ErrEventLookbackTooLong is
+// returned), and at most GetEventHistoryEventLimit are returned, so large
+// accounts don't cause unbounded scans of the stream. Page through events by setting Before and
+// BeforeSequence to the timestamp and stream sequence of the last event listed.
+func (c *Client) ListEventHistory(ctx context.Context, query EventHistoryQuery) (*EventHistoryPage, error) {
+	if c.JetStream == nil {
+		return nil, errors.New("Event history requires JetStream")
+	}
+
+	limit := query.Limit
+	if limit <= 0 || limit > GetEventHistoryEventLimit {
+		limit = GetEventHistoryEventLimit
+	}
+
+	before := query.Before
+	if before.IsZero() {
+		before = time.Now()
+	}
+	after := query.After
+	if after.IsZero() {
+		after = before.Add(DefaultEventLookback)
+	}
+
+	if after.Before(before.Add(MaxEventLookback)) {
+		return nil, ErrEventLookbackTooLong
+	}
+
+	page := &EventHistoryPage{Events: []*MsgMeta{}}
+	if !after.Before(before) {
+		return page, nil
+	}
+
+	eventId := AllEventId
+	if query.SourceOnly || query.EventType != "" {
+		eventId = SourceEventId
+	}
+
+	consumerConf := jetstream.OrderedConsumerConfig{
+		FilterSubjects:    []string{EventLogFilterSubject(c.accountId, c.interestTopic, eventId)},
+		DeliverPolicy:     jetstream.DeliverByStartTimePolicy,
+		InactiveThreshold: time.Millisecond * 500,
+		OptStartTime:      &after,
+	}
+	cons, err := c.JetStream.OrderedConsumer(ctx, c.streamName, consumerConf)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create ordered consumer: %w", err)
+	}
+
+	info, err := cons.Info(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get consumer info: %w", err)
+	}
+
+	// Only the most recent limit+1 matches are kept, the extra telling us
+	// whether there's another page
+	matched := []*MsgMeta{}
+	numPending := int(info.NumPending)
+	pastBefore := false
+
+	for numPending > 0 && !pastBefore {
+		// Don't call more than is in the stream (otherwise have to wait for timeout)
+		batchSize := numPending
+		if batchSize > defaultBatchSize {
+			batchSize = defaultBatchSize
+		}
+
+		msgs, err := cons.Fetch(batchSize, jetstream.FetchMaxWait(maxWaitTime))
+		if err != nil {
+			return nil, fmt.Errorf("Unable to fetch messages: %w", err)
+		}
+
+		for rawM := range msgs.Messages() {
+			numPending--
+
+			// Remaining messages in the batch are drained, but not kept
+			if pastBefore {
+				continue
+			}
+
+			m, err := Parse(rawM)
+			if err != nil {
+				return nil, err
+			}
+
+			if !m.Timestamp.Before(before) || (query.BeforeSequence > 0 && m.StreamSequence >= query.BeforeSequence) {
+				pastBefore = true
+				continue
+			}
+			if query.EventType != "" && !matchesEventType(m, query.EventType) {
+				continue
+			}
+
+			matched = append(matched, m)
+			if len(matched) > limit+1 {
+				matched = matched[1:]
+			}
+		}
+	}
+
+	if len(matched) > limit {
+		page.More = true
+		matched = matched[1:]
+	}
+
+	for i := len(matched) - 1; i >= 0; i-- {
+		page.Events = append(page.Events, matched[i])
+	}
+
+	return page, nil
+}
+
+// SequencesWithResults returns which of the given sequences have a result for
+// any call, e.g. to describe a page of events
+//
+// Only the last done message of each in the sequences is scanned, without
+// payloads, so a whole page of events is checked at once.
+func (c *Client) SequencesWithResults(ctx context.Context, sequenceIds []string) (map[string]bool, error) {
+	withResults := map[string]bool{}
+	if len(sequenceIds) == 0 {
+		return withResults, nil
+	}
+	if c.JetStream == nil {
+		return nil, errors.New("Sequence results require JetStream")
+	}
+
+	filters := []string{}
+	seen := map[string]bool{}
+	for _, sequenceId := range sequenceIds {
+		if seen[sequenceId] {
+			continue
+		}
+		seen[sequenceId] = true
+
+		filters = append(filters, c.buildSubject(ChannelNotify, sequenceId, "*", DoneMessageId))
+	}
+
+	consumerConf := jetstream.OrderedConsumerConfig{
+		FilterSubjects:    filters,
+		DeliverPolicy:     jetstream.DeliverLastPerSubjectPolicy,
+		HeadersOnly:       true,
+		InactiveThreshold: time.Millisecond * 500,
+	}
+	cons, err := c.JetStream.OrderedConsumer(ctx, c.streamName, consumerConf)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create ordered consumer: %w", err)
+	}
+
+	info, err := cons.Info(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get consumer info: %w", err)
+	}
+
+	numPending := int(info.NumPending)
+	for numPending > 0 {
+		batchSize := numPending
+		if batchSize > defaultBatchSize {
+			batchSize = defaultBatchSize
+		}
+
+		msgs, err := cons.Fetch(batchSize, jetstream.FetchMaxWait(maxWaitTime))
+		if err != nil {
+			return nil, fmt.Errorf("Unable to fetch messages: %w", err)
+		}
+
+		for m := range msgs.Messages() {
+			numPending--
+
+			// Subjects are <account>.<topic>.notify.<sequence>.<call>.done
+			tokens := strings.Split(m.Subject(), ".")
+			if len(tokens) > 3 {
+				withResults[tokens[3]] = true
+			}
+		}
+
+		if msgs.Error() != nil {
+			return nil, fmt.Errorf("Unable to fetch messages: %w", msgs.Error())
+		}
+	}
+
+	return withResults, nil
+}
+
+// GetSequenceMessages returns every message in a sequence, including call
+// requests, in the order they were published
+//
+// Sequences of more than MaxSequenceMessages messages are rejected. A sequence
+// that doesn't exist (or has been purged) has no messages.
+func (c *Client) GetSequenceMessages(ctx context.Context, sequenceId string) ([]*MsgMeta, error) {
+	if c.JetStream == nil {
+		return nil, errors.New("Sequence history requires JetStream")
+	}
+
+	rawMsgs, err := c.fetchSequenceChannels(ctx, sequenceId, MaxSequenceMessages, ChannelNotify, ChannelRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := make([]*MsgMeta, 0, len(rawMsgs))
+	for _, rawM := range rawMsgs {
+		m, err := Parse(rawM)
+		if err != nil {
+			return nil, err
+		}
+
+		msgs = append(msgs, m)
+	}
+
+	return msgs, nil
+}
+
+// matchesEventType returns whether a source event is of eventType, which may
+// include an action e.g. "github_push"
+func matchesEventType(m *MsgMeta, eventType string) bool {
+	sourceMeta, err := ParseSourceMeta(m.Msg().Data())
+	if err != nil {
+		return false
+	}
+
+	wantEvent, wantAction, hasAction := strings.Cut(eventType, "_")
+	if sourceMeta.Event != wantEvent {
+		return false
+	}
+
+	return !hasAction || sourceMeta.Action == wantAction
+}
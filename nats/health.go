@@ -0,0 +1,134 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Health statuses of a component, as reported by Health
+const (
+	HealthDown = "down"
+	HealthOK   = "ok"
+)
+
+// Names of the components checked by Health. Consumers are named
+// HealthConsumer/<consumer>.
+const (
+	HealthConsumer  = "consumer"
+	HealthJetStream = "jetstream"
+	HealthNats      = "nats"
+	HealthStream    = "stream"
+)
+
+// ComponentHealth is the status of a component hops depends on
+type ComponentHealth struct {
+	// Detail explains why the component is down, or describes it if healthy
+	Detail string `json:"detail,omitempty"`
+	Name   string `json:"name"`
+	// RTTMs is the round trip time to the component in milliseconds, if measured
+	RTTMs  float64 `json:"rtt_ms,omitempty"`
+	Status string  `json:"status"`
+}
+
+// Health checks the NATS connection (measuring its round trip time) and, unless
+// it's a core client, JetStream, the account stream and the client's consumers
+//
+// Each check makes a request to the NATS server, bounded by ctx. If the client
+// isn't connected, or JetStream is unavailable, the checks that depend on it are
+// reported as down without being made.
+func (c *Client) Health(ctx context.Context) []ComponentHealth {
+	components := []ComponentHealth{}
+
+	connHealth := ComponentHealth{Name: HealthNats, Status: HealthOK}
+	rtt, err := c.connectionRTT()
+	if err != nil {
+		connHealth.Status = HealthDown
+		connHealth.Detail = err.Error()
+	} else {
+		connHealth.Detail = c.NatsConn.ConnectedUrlRedacted()
+		connHealth.RTTMs = float64(rtt.Microseconds()) / 1000
+	}
+	components = append(components, connHealth)
+
+	// Core clients don't use JetStream
+	if c.JetStream == nil {
+		return components
+	}
+
+	unavailable := ""
+	if connHealth.Status != HealthOK {
+		unavailable = "Not connected to NATS"
+	}
+
+	check := func(name string, checkFn func() (string, error)) ComponentHealth {
+		component := ComponentHealth{Name: name, Status: HealthOK}
+
+		if unavailable != "" {
+			component.Status = HealthDown
+			component.Detail = unavailable
+		} else if detail, err := checkFn(); err != nil {
+			component.Status = HealthDown
+			component.Detail = err.Error()
+		} else {
+			component.Detail = detail
+		}
+
+		components = append(components, component)
+		return component
+	}
+
+	jsHealth := check(HealthJetStream, func() (string, error) {
+		info, err := c.JetStream.AccountInfo(ctx)
+		if err != nil {
+			return "", fmt.Errorf("JetStream unavailable: %w", err)
+		}
+
+		return fmt.Sprintf("%d streams, %d consumers", info.Streams, info.Consumers), nil
+	})
+	if jsHealth.Status != HealthOK && unavailable == "" {
+		unavailable = "JetStream unavailable"
+	}
+
+	check(HealthStream, func() (string, error) {
+		_, err := c.JetStream.Stream(ctx, c.streamName)
+		if err != nil {
+			return "", fmt.Errorf("Unable to get stream '%s': %w", c.streamName, err)
+		}
+
+		return c.streamName, nil
+	})
+
+	consumerKeys := make([]string, 0, len(c.Consumers))
+	for key := range c.Consumers {
+		consumerKeys = append(consumerKeys, key)
+	}
+	sort.Strings(consumerKeys)
+
+	for _, key := range consumerKeys {
+		consumer := c.Consumers[key]
+
+		check(fmt.Sprintf("%s/%s", HealthConsumer, key), func() (string, error) {
+			info, err := consumer.Info(ctx)
+			if err != nil {
+				return "", fmt.Errorf("Unable to get consumer '%s': %w", consumer.CachedInfo().Name, err)
+			}
+
+			return fmt.Sprintf("%d pending, %d awaiting ack", info.NumPending, info.NumAckPending), nil
+		})
+	}
+
+	return components
+}
+
+// connectionRTT returns the round trip time to the NATS server, erroring if
+// not connected
+func (c *Client) connectionRTT() (time.Duration, error) {
+	if c.NatsConn == nil || !c.NatsConn.IsConnected() {
+		return 0, errors.New("Not connected to NATS")
+	}
+
+	return c.NatsConn.RTT()
+}
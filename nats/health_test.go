@@ -0,0 +1,86 @@
+package nats
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hiphops-io/hops/logs"
+)
+
+func TestClientHealth(t *testing.T) {
+	ctx := context.Background()
+	localNats := setupLocalNatsServer(t)
+	defer localNats.Close()
+
+	authUrl, err := localNats.AuthUrl("")
+	require.NoError(t, err, "Test setup: Should have valid auth URL for NATS")
+	user, err := localNats.User("")
+	require.NoError(t, err, "Test setup: Should have valid NATS user")
+
+	natsLogger := logs.NewNatsZeroLogger(logs.NoOpLogger())
+	hopsNats, err := NewClient(authUrl, user.Account.Name, DefaultInterestTopic, &natsLogger)
+	require.NoError(t, err, "Test setup: Client should initialise without error")
+	defer hopsNats.Close()
+
+	statuses := func(components []ComponentHealth) map[string]string {
+		result := map[string]string{}
+		for _, component := range components {
+			result[component.Name] = component.Status
+		}
+		return result
+	}
+
+	t.Run("Healthy", func(t *testing.T) {
+		components := hopsNats.Health(ctx)
+		assert.Equal(t, map[string]string{
+			HealthNats:      HealthOK,
+			HealthJetStream: HealthOK,
+			HealthStream:    HealthOK,
+			HealthConsumer + "/" + DefaultConsumerName: HealthOK,
+		}, statuses(components))
+		assert.Greater(t, components[0].RTTMs, float64(0), "Round trip time should be measured")
+	})
+
+	t.Run("JetStream down", func(t *testing.T) {
+		err := localNats.NatsServer.DisableJetStream()
+		require.NoError(t, err, "Test setup: Should disable JetStream")
+
+		ctx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+		defer cancel()
+
+		components := hopsNats.Health(ctx)
+		assert.Equal(t, map[string]string{
+			HealthNats:      HealthOK,
+			HealthJetStream: HealthDown,
+			HealthStream:    HealthDown,
+			HealthConsumer + "/" + DefaultConsumerName: HealthDown,
+		}, statuses(components))
+
+		for _, component := range components[1:] {
+			assert.NotEmpty(t, component.Detail, "Down components should say why")
+		}
+	})
+
+	t.Run("Disconnected", func(t *testing.T) {
+		localNats.Close()
+
+		components := hopsNats.Health(ctx)
+		for _, component := range components {
+			assert.Equal(t, HealthDown, component.Status, "%s should be down", component.Name)
+		}
+	})
+}
+
+func TestCoreClientHealth(t *testing.T) {
+	hopsNats, cleanup := setupCoreClient(context.Background(), t)
+	defer cleanup()
+
+	components := hopsNats.Health(context.Background())
+	require.Len(t, components, 1, "Core clients should only check the connection")
+	assert.Equal(t, HealthNats, components[0].Name)
+	assert.Equal(t, HealthOK, components[0].Status)
+}
@@ -1,27 +1,48 @@
 package nats
 
-type Logger interface {
-	// Log a debug statement
-	Debugf(format string, v ...interface{})
+type (
+	Logger interface {
+		// Log a debug statement
+		Debugf(format string, v ...interface{})
 
-	// Log an error with exact error
-	Errf(err error, format string, v ...interface{})
+		// Log an error with exact error
+		Errf(err error, format string, v ...interface{})
 
-	// Log an error
-	Errorf(format string, v ...interface{})
+		// Log an error
+		Errorf(format string, v ...interface{})
 
-	// Log a fatal error
-	Fatalf(format string, v ...interface{})
+		// Log a fatal error
+		Fatalf(format string, v ...interface{})
 
-	// Log an info statement
-	Infof(format string, v ...interface{})
+		// Log an info statement
+		Infof(format string, v ...interface{})
 
-	// Log a notice statement
-	Noticef(format string, v ...interface{})
+		// Log a notice statement
+		Noticef(format string, v ...interface{})
 
-	// Log a trace statement
-	Tracef(format string, v ...interface{})
+		// Log a trace statement
+		Tracef(format string, v ...interface{})
 
-	// Log a warning statement
-	Warnf(format string, v ...interface{})
-}
+		// Log a warning statement
+		Warnf(format string, v ...interface{})
+	}
+
+	// noopLogger discards everything logged, used when NewClient is given no logger
+	noopLogger struct{}
+)
+
+func (noopLogger) Debugf(format string, v ...interface{}) {}
+
+func (noopLogger) Errf(err error, format string, v ...interface{}) {}
+
+func (noopLogger) Errorf(format string, v ...interface{}) {}
+
+func (noopLogger) Fatalf(format string, v ...interface{}) {}
+
+func (noopLogger) Infof(format string, v ...interface{}) {}
+
+func (noopLogger) Noticef(format string, v ...interface{}) {}
+
+func (noopLogger) Tracef(format string, v ...interface{}) {}
+
+func (noopLogger) Warnf(format string, v ...interface{}) {}
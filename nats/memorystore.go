@@ -0,0 +1,347 @@
+package nats
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// DefaultCoreSequenceLimit is the max number of sequences held in memory by
+// clients created with NewCoreClient, unless set with WithCoreSequenceLimit
+const DefaultCoreSequenceLimit = 1000
+
+var _ jetstream.Msg = (*memoryMsg)(nil)
+
+type (
+	// memoryMsg is a message held in a memoryStore, implementing jetstream.Msg so
+	// it can be handled in the same way as messages consumed from a stream
+	//
+	// Messages aren't redelivered, so acks and naks do nothing.
+	memoryMsg struct {
+		data      []byte
+		delivered bool
+		header    nats.Header
+		sequence  uint64
+		subject   string
+		timestamp time.Time
+	}
+
+	// memorySequence holds the messages of a sequence in the order they were added
+	memorySequence struct {
+		msgs     []*memoryMsg
+		subjects map[string]*memoryMsg
+	}
+
+	// heldMsgs holds back the messages of core clients until they're due, as
+	// they can't be naked with a delay (see ConsumeSequences)
+	heldMsgs struct {
+		mu      sync.Mutex
+		stopped bool
+		timers  map[*time.Timer]bool
+	}
+
+	// memoryStore stands in for the stream and object store of clients created
+	// with NewCoreClient, holding the messages of the most recent sequences
+	//
+	// Once limit is reached, the oldest sequence is dropped for each new one.
+	// As with the stream, only one message is kept per subject.
+	memoryStore struct {
+		limit     int
+		mu        sync.Mutex
+		objects   map[string][]byte
+		order     []string
+		sequence  uint64
+		sequences map[string]*memorySequence
+	}
+)
+
+func newMemoryStore(limit int) *memoryStore {
+	return &memoryStore{
+		limit:     limit,
+		objects:   map[string][]byte{},
+		sequences: map[string]*memorySequence{},
+	}
+}
+
+func (m *memoryMsg) Ack() error {
+	return nil
+}
+
+func (m *memoryMsg) Data() []byte {
+	return m.data
+}
+
+func (m *memoryMsg) DoubleAck(ctx context.Context) error {
+	return nil
+}
+
+func (m *memoryMsg) Headers() nats.Header {
+	return m.header
+}
+
+func (m *memoryMsg) InProgress() error {
+	return nil
+}
+
+func (m *memoryMsg) Metadata() (*jetstream.MsgMetadata, error) {
+	return &jetstream.MsgMetadata{
+		Sequence: jetstream.SequencePair{
+			Consumer: m.sequence,
+			Stream:   m.sequence,
+		},
+		NumDelivered: 1,
+		Timestamp:    m.timestamp,
+	}, nil
+}
+
+func (m *memoryMsg) Nak() error {
+	return nil
+}
+
+func (m *memoryMsg) NakWithDelay(delay time.Duration) error {
+	return nil
+}
+
+func (m *memoryMsg) Reply() string {
+	return ""
+}
+
+func (m *memoryMsg) Subject() string {
+	return m.subject
+}
+
+func (m *memoryMsg) Term() error {
+	return nil
+}
+
+// rawMsg returns the message in the form returned when getting messages from a stream
+func (m *memoryMsg) rawMsg() *jetstream.RawStreamMsg {
+	return &jetstream.RawStreamMsg{
+		Data:     m.data,
+		Header:   m.header,
+		Sequence: m.sequence,
+		Subject:  m.subject,
+		Time:     m.timestamp,
+	}
+}
+
+// add adds a message that has not yet been delivered, returning false if the
+// store already holds a message for the subject
+//
+// Messages without a sequence ID aren't held, but are still reported as added.
+func (s *memoryStore) add(subject string, data []byte, header nats.Header) (*memoryMsg, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seq := s.sequenceFor(subject)
+	if seq == nil {
+		return nil, true
+	}
+
+	if _, ok := seq.subjects[subject]; ok {
+		return nil, false
+	}
+
+	return s.append(seq, subject, data, header), true
+}
+
+// get returns the message held for a subject, if any
+func (s *memoryStore) get(subject string) (*memoryMsg, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seq, ok := s.sequences[sequenceIdFromSubject(subject)]
+	if !ok {
+		return nil, false
+	}
+
+	msg, ok := seq.subjects[subject]
+	return msg, ok
+}
+
+// getObject returns the data of an object, if any
+func (s *memoryStore) getObject(name string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.objects[name]
+	return data, ok
+}
+
+// messages returns a copy of the messages of a sequence, in the order they were added
+func (s *memoryStore) messages(sequenceId string) []*memoryMsg {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seq, ok := s.sequences[sequenceId]
+	if !ok {
+		return nil
+	}
+
+	return append([]*memoryMsg{}, seq.msgs...)
+}
+
+// purge drops every message of a sequence, returning how many were dropped
+func (s *memoryStore) purge(sequenceId string) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seq, ok := s.sequences[sequenceId]
+	if !ok {
+		return 0
+	}
+
+	delete(s.sequences, sequenceId)
+	for i := range s.order {
+		if s.order[i] == sequenceId {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+
+	return uint64(len(seq.msgs))
+}
+
+// putObject sets the data of an object, replacing any existing data
+func (s *memoryStore) putObject(name string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.objects[name] = data
+}
+
+// receive records that a message has been received from a subscription, returning
+// the message to deliver, or false if the message has already been delivered
+//
+// Messages added by this process are delivered once they're received too, so
+// that its own events are processed as they would be when consuming from a stream.
+func (s *memoryStore) receive(subject string, data []byte, header nats.Header) (*memoryMsg, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seq := s.sequenceFor(subject)
+	if seq == nil {
+		return nil, false
+	}
+
+	msg, ok := seq.subjects[subject]
+	if !ok {
+		msg = s.append(seq, subject, data, header)
+	}
+
+	if msg.delivered {
+		return nil, false
+	}
+
+	msg.delivered = true
+	return msg, true
+}
+
+// remove drops a message from the store, e.g. when publishing it failed
+func (s *memoryStore) remove(msg *memoryMsg) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seq, ok := s.sequences[sequenceIdFromSubject(msg.subject)]
+	if !ok || seq.subjects[msg.subject] != msg {
+		return
+	}
+
+	delete(seq.subjects, msg.subject)
+	for i := range seq.msgs {
+		if seq.msgs[i] == msg {
+			seq.msgs = append(seq.msgs[:i], seq.msgs[i+1:]...)
+			break
+		}
+	}
+}
+
+// append adds a message to a sequence
+//
+// This function should only ever be called within a lock on s.mu
+func (s *memoryStore) append(seq *memorySequence, subject string, data []byte, header nats.Header) *memoryMsg {
+	s.sequence++
+
+	msg := &memoryMsg{
+		data:      data,
+		header:    header,
+		sequence:  s.sequence,
+		subject:   subject,
+		timestamp: time.Now(),
+	}
+
+	seq.msgs = append(seq.msgs, msg)
+	seq.subjects[subject] = msg
+
+	return msg
+}
+
+// sequenceFor returns the sequence a subject belongs to, creating it (and
+// dropping the oldest sequence if over the limit) if necessary. Returns nil
+// if the subject has no sequence ID.
+//
+// This function should only ever be called within a lock on s.mu
+func (s *memoryStore) sequenceFor(subject string) *memorySequence {
+	sequenceId := sequenceIdFromSubject(subject)
+	if sequenceId == "" {
+		return nil
+	}
+
+	seq, ok := s.sequences[sequenceId]
+	if ok {
+		return seq
+	}
+
+	seq = &memorySequence{subjects: map[string]*memoryMsg{}}
+	s.sequences[sequenceId] = seq
+	s.order = append(s.order, sequenceId)
+
+	if s.limit > 0 && len(s.order) > s.limit {
+		delete(s.sequences, s.order[0])
+		s.order = s.order[1:]
+	}
+
+	return seq
+}
+
+// hold calls deliver once wait has passed, unless stopped first
+func (h *heldMsgs) hold(wait time.Duration, deliver func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.stopped {
+		return
+	}
+	if h.timers == nil {
+		h.timers = map[*time.Timer]bool{}
+	}
+
+	// The timer can't fire until the lock is released, so is always set by then
+	var timer *time.Timer
+	timer = time.AfterFunc(wait, func() {
+		h.mu.Lock()
+		stopped := h.stopped
+		delete(h.timers, timer)
+		h.mu.Unlock()
+
+		if !stopped {
+			deliver()
+		}
+	})
+	h.timers[timer] = true
+}
+
+// stop drops every message still held back
+func (h *heldMsgs) stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.stopped = true
+	for timer := range h.timers {
+		timer.Stop()
+	}
+	h.timers = nil
+}
@@ -0,0 +1,49 @@
+package nats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStoreDelivery(t *testing.T) {
+	store := newMemoryStore(DefaultCoreSequenceLimit)
+
+	published, added := store.add("acc.default.notify.SEQ_ID.event", []byte("One"), nil)
+	require.True(t, added, "Message should be added")
+
+	_, added = store.add("acc.default.notify.SEQ_ID.event", []byte("Again"), nil)
+	assert.False(t, added, "Only one message should be kept per subject")
+
+	received, ok := store.receive("acc.default.notify.SEQ_ID.event", []byte("One"), nil)
+	require.True(t, ok, "Published messages should be delivered once received")
+	assert.Same(t, published, received)
+
+	_, ok = store.receive("acc.default.notify.SEQ_ID.event", []byte("One"), nil)
+	assert.False(t, ok, "Messages should only be delivered once")
+
+	received, ok = store.receive("acc.default.notify.SEQ_ID.a_sensor-call", []byte("Two"), nil)
+	require.True(t, ok, "Messages from elsewhere should be delivered")
+	assert.Greater(t, received.sequence, published.sequence)
+
+	_, added = store.add("no_sequence", []byte("Three"), nil)
+	assert.True(t, added, "Messages without a sequence should be reported as added")
+	assert.Len(t, store.messages("SEQ_ID"), 2)
+}
+
+func TestMemoryStoreLimit(t *testing.T) {
+	store := newMemoryStore(2)
+
+	for _, sequenceId := range []string{"SEQ_ONE", "SEQ_TWO", "SEQ_THREE"} {
+		_, added := store.add("acc.default.notify."+sequenceId+".event", []byte(sequenceId), nil)
+		require.True(t, added)
+	}
+
+	assert.Empty(t, store.messages("SEQ_ONE"), "Oldest sequence should be dropped")
+	assert.Len(t, store.messages("SEQ_TWO"), 1)
+	assert.Len(t, store.messages("SEQ_THREE"), 1)
+
+	_, ok := store.get("acc.default.notify.SEQ_ONE.event")
+	assert.False(t, ok)
+}
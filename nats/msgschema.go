@@ -1,24 +1,126 @@
 package nats
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/goccy/go-json"
 	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 )
 
 const AllEventId = ">"
 const HopsMessageId = "hops"
 const DoneMessageId = "done"
+const ProgressMessageId = "progress"
 const SourceEventId = "event"
 
+// WouldDispatchMessageId is the message ID suffix of the events recorded in place
+// of call requests when a replay is evaluated only (`notify.sequence_id.call_slug.would_dispatch`)
+const WouldDispatchMessageId = "would_dispatch"
+
+// ReplayModeFull and ReplayModeEvaluate are the modes a sequence can be replayed in.
+// Full replays dispatch calls as normal, whilst evaluated replays only record the
+// calls that would have been dispatched, so have no side effects.
+const ReplayModeFull = "full"
+const ReplayModeEvaluate = "evaluate"
+
+// ReplaySequencePrefix prefixes the IDs of sequences created by replays
+const ReplaySequencePrefix = "replay-"
+
+// SequenceMessageId and CompletedMessageId make up the subject a sequence's
+// completion event is published to (`notify.sequence_id.sequence.completed`)
+const SequenceMessageId = "sequence"
+const CompletedMessageId = "completed"
+
+// TimeoutMessageId is the message ID suffix of the event published when on blocks
+// in a sequence pass their timeout (`notify.sequence_id.sequence.timeout`)
+const TimeoutMessageId = "timeout"
+
+// DeadlineMessageId is the message ID suffix of the events runners publish to have
+// a sequence evaluated again once an on block's timeout passes, even if nothing
+// else arrives (`notify.sequence_id.sequence.deadline.unix_millis`)
+const DeadlineMessageId = "deadline"
+
+// ErrorMessageId is the message ID of the event published when a runner fails
+// to evaluate a sequence (`notify.sequence_id.error`)
+const ErrorMessageId = "error"
+
+// StatusProgress is the status of interim progress messages sent by long-running handlers
+const StatusProgress = "PROGRESS"
+
+// StatusSuccess and StatusFailure are the aggregate statuses of a completed sequence
+const StatusSuccess = "SUCCESS"
+const StatusFailure = "FAILURE"
+
+// StatusTimeout is the status of a sequence completed with calls still pending,
+// as their on blocks passed their timeout
+const StatusTimeout = "TIMEOUT"
+
+// Headers set by the runner on every call request it dispatches, describing
+// where the call came from. These are stable, so workers and handlers may rely
+// on them. Parse reads them into MsgMeta.Call.
+const ActionHeader = "Hops-Action"
+const EventHeader = "Hops-Event"
+const HopsHashHeader = "Hops-Hash"
+const OnHeader = "Hops-On"
+const RequestIdHeader = "Hops-Request-Id"
+const SequenceIdHeader = "Hops-Sequence-Id"
+
+var (
+	// ErrMalformedSubject is returned by Parse when a message subject does not
+	// match the hops subject grammar. Retrying such a message will never succeed.
+	ErrMalformedSubject = errors.New("Malformed message subject")
+
+	// ErrMalformedPayload is returned by Parse when a message with a valid subject
+	// can't be read, e.g. its JetStream metadata is missing. Unlike a malformed
+	// subject, retrying such a message may succeed.
+	ErrMalformedPayload = errors.New("Unable to read message")
+
+	// ErrResultTooLarge is returned when publishing a result message that exceeds
+	// the max payload size of the NATS server
+	ErrResultTooLarge = errors.New("Result is too large to publish")
+)
+
 type (
+	// CallMeta describes where a dispatched call came from: the source event's
+	// type and action, the hops config and on block that matched it, and the sequence
+	//
+	// It's carried in the headers of call requests, so is empty for requests not
+	// dispatched by the runner.
+	CallMeta struct {
+		Action   string
+		Event    string
+		HopsHash string
+		On       string
+		// RequestId is the ID of the HTTP request that created the sequence's source event, if any
+		RequestId  string
+		SequenceId string
+	}
+
+	// CompletionMsg is the schema for the event published once all work in a sequence is done
+	//
+	// Failed holds the slugs of the calls that errored and of any on blocks whose
+	// done block set an error.
+	// Expired holds the slugs of calls still pending when their on block timed out.
+	CompletionMsg struct {
+		Calls       int       `json:"calls"`
+		CompletedAt time.Time `json:"completed_at"`
+		Expired     []string  `json:"expired,omitempty"`
+		Failed      []string  `json:"failed,omitempty"`
+		StartedAt   time.Time `json:"started_at"`
+		Status      string    `json:"status"`
+	}
+
 	// HopsResultMeta is metadata included in the top level of a result message
 	HopsResultMeta struct {
-		Error      string    `json:"error,omitempty"`
+		Error string `json:"error,omitempty"`
+		// Exhausted is set when a request failed and will not be retried again
+		Exhausted  bool      `json:"exhausted,omitempty"`
 		FinishedAt time.Time `json:"finished_at"`
 		StartedAt  time.Time `json:"started_at"`
 	}
@@ -26,19 +128,51 @@ type (
 	MsgMeta struct {
 		AccountId        string
 		AppName          string
+		Call             CallMeta
 		Channel          string
+		Completed        bool
 		ConsumerSequence uint64
+		Deadline         bool
 		Done             bool
 		HandlerName      string
 		InterestTopic    string
 		MessageId        string
+		NumDelivered     uint64
+		Progress         bool
+		SequenceError    bool
 		SequenceId       string
 		StreamSequence   uint64
+		TimedOut         bool
 		Timestamp        time.Time
+		WouldDispatch    bool
 		msg              jetstream.Msg
 	}
 
+	// ProgressMsg is the schema for interim progress messages sent by a handler
+	// before its result
+	//
+	// Progress messages are published alongside the call's response subject,
+	// but are not included in message bundles so they are never mistaken for results.
+	ProgressMsg struct {
+		Message    string    `json:"message,omitempty"`
+		Percent    int       `json:"percent"`
+		ReportedAt time.Time `json:"reported_at"`
+		Status     string    `json:"status"`
+	}
+
+	// ReplayMeta marks a source event as replayed from another sequence, and how
+	// the replay should be evaluated (ReplayModeFull or ReplayModeEvaluate)
+	ReplayMeta struct {
+		Mode       string `json:"mode"`
+		SequenceId string `json:"sequence_id"`
+	}
+
 	// ResultMsg is the schema for handler call result messages
+	//
+	// Structured (non-string) output from a handler is held in JSON, and string
+	// output in Body. As result messages are added to the sequence's message bundle
+	// under the call's slug, hops configs can reference a call's output as
+	// `call_slug.json.field`.
 	ResultMsg struct {
 		Body       string            `json:"body"`
 		Completed  bool              `json:"completed"`
@@ -51,23 +185,81 @@ type (
 		URL        string            `json:"url,omitempty"`
 	}
 
+	// SequenceErrorMsg is the schema for the event published when a runner fails
+	// to evaluate a sequence
+	//
+	// On and Call identify the on block and call the error relates to, if known.
+	SequenceErrorMsg struct {
+		Call       string    `json:"call,omitempty"`
+		Error      string    `json:"error"`
+		HopsHash   string    `json:"hops_hash"`
+		OccurredAt time.Time `json:"occurred_at"`
+		On         string    `json:"on,omitempty"`
+	}
+
+	// DeadlineMsg is the schema for the event published to have a sequence evaluated
+	// again once an on block's timeout passes. It's held back until the deadline
+	// by the runner consuming it, so is evaluated no sooner.
+	DeadlineMsg struct {
+		Deadline time.Time `json:"deadline"`
+	}
+
+	// TimeoutMsg is the schema for the event published when on blocks in a sequence
+	// pass their timeout. Their pending calls will never be dispatched, or their
+	// results never waited on.
+	TimeoutMsg struct {
+		Expired    []string  `json:"expired"`
+		Ons        []string  `json:"ons"`
+		StartedAt  time.Time `json:"started_at"`
+		TimedOutAt time.Time `json:"timed_out_at"`
+	}
+
 	SourceMeta struct {
 		Source string `json:"source"`
 		Event  string `json:"event"`
 		Action string `json:"action"`
-		Unique string `json:"unique,omitempty"`
+		// HopsHash is the hash of the hops config the event was created against, if any
+		HopsHash string `json:"hops_hash,omitempty"`
+		// RequestId is the ID of the HTTP request the event was created by, if any,
+		// allowing its logs to be correlated with those of the sequence
+		RequestId string      `json:"request_id,omitempty"`
+		Unique    string      `json:"unique,omitempty"`
+		Replay    *ReplayMeta `json:"replay,omitempty"`
 	}
+
+	// WouldDispatchMsg is the schema for the event recorded in place of a call
+	// request when a replayed sequence is evaluated only
+	WouldDispatchMsg struct {
+		App        string          `json:"app"`
+		Handler    string          `json:"handler"`
+		Inputs     json.RawMessage `json:"inputs"`
+		RecordedAt time.Time       `json:"recorded_at"`
+	}
+
+	msgMetaCtxKey struct{}
 )
 
 func CreateSourceEvent(rawEvent map[string]any, source string, event string, action string, unique string) ([]byte, string, error) {
-	rawEvent["hops"] = SourceMeta{
+	return CreateSourceEventWithMeta(rawEvent, SourceMeta{
 		Source: source,
 		Event:  event,
 		Action: action,
 		// unique is used when we want identical input to be regarded as a different message.
 		// Any random string will do the job of changing the hash result.
 		Unique: unique,
-	}
+	})
+}
+
+// CreateSourceEventWithMeta creates a source event from rawEvent with the given
+// hops metadata, returning it along with its sequence ID
+//
+// The sequence ID is derived from the event's content, so identical events share
+// a sequence unless given a distinct Unique. RequestId is left out, as identical
+// events created by different requests are still the same event.
+func CreateSourceEventWithMeta(rawEvent map[string]any, meta SourceMeta) ([]byte, string, error) {
+	requestId := meta.RequestId
+	meta.RequestId = ""
+	rawEvent["hops"] = meta
 
 	sourceBytes, err := json.Marshal(rawEvent)
 	if err != nil {
@@ -78,25 +270,101 @@ func CreateSourceEvent(rawEvent map[string]any, source string, event string, act
 	sourceUUID := uuid.NewSHA1(uuid.NameSpaceDNS, sourceBytes)
 	hash := sourceUUID.String()
 
+	if requestId != "" {
+		meta.RequestId = requestId
+		rawEvent["hops"] = meta
+
+		sourceBytes, err = json.Marshal(rawEvent)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
 	return sourceBytes, hash, nil
 }
 
+// ContextWithMsgMeta returns a copy of ctx carrying the parsed metadata of a message
+func ContextWithMsgMeta(ctx context.Context, msgMeta *MsgMeta) context.Context {
+	return context.WithValue(ctx, msgMetaCtxKey{}, msgMeta)
+}
+
+// MsgMetaFromContext returns the parsed message metadata carried by ctx, if any
+//
+// Workers add the metadata of the request being handled to the context given to handlers.
+func MsgMetaFromContext(ctx context.Context) (*MsgMeta, bool) {
+	msgMeta, ok := ctx.Value(msgMetaCtxKey{}).(*MsgMeta)
+	return msgMeta, ok
+}
+
+// Parse reads the hops specific tokens and metadata from a message
+//
+// Errors wrap either ErrMalformedSubject or ErrMalformedPayload, allowing callers
+// to decide whether a message should be terminated or retried.
 func Parse(msg jetstream.Msg) (*MsgMeta, error) {
 	message := &MsgMeta{msg: msg}
 
 	err := message.initTokens()
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %w", ErrMalformedSubject, err)
 	}
 
 	err = message.initMetadata()
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %w", ErrMalformedPayload, err)
 	}
 
+	message.Call = callMetaFromHeader(msg.Headers())
+
 	return message, nil
 }
 
+// ParseResultMsg decodes the data of a result message, as found in a MessageBundle
+func ParseResultMsg(data []byte) (ResultMsg, error) {
+	resultMsg := ResultMsg{}
+
+	err := json.Unmarshal(data, &resultMsg)
+	if err != nil {
+		return resultMsg, fmt.Errorf("Unable to decode result message: %w", err)
+	}
+
+	return resultMsg, nil
+}
+
+// ParseSourceMeta decodes the hops metadata of a source event, as found in a MessageBundle
+func ParseSourceMeta(data []byte) (SourceMeta, error) {
+	sourceEvent := struct {
+		Hops SourceMeta `json:"hops"`
+	}{}
+
+	err := json.Unmarshal(data, &sourceEvent)
+	if err != nil {
+		return sourceEvent.Hops, fmt.Errorf("Unable to decode source event: %w", err)
+	}
+
+	return sourceEvent.Hops, nil
+}
+
+// header returns the call metadata as message headers, leaving out empty values
+func (c CallMeta) header() nats.Header {
+	header := nats.Header{}
+	values := map[string]string{
+		ActionHeader:     c.Action,
+		EventHeader:      c.Event,
+		HopsHashHeader:   c.HopsHash,
+		OnHeader:         c.On,
+		RequestIdHeader:  c.RequestId,
+		SequenceIdHeader: c.SequenceId,
+	}
+
+	for key, value := range values {
+		if value != "" {
+			header.Set(key, value)
+		}
+	}
+
+	return header
+}
+
 func (m *MsgMeta) Msg() jetstream.Msg {
 	return m.msg
 }
@@ -125,6 +393,20 @@ func (m *MsgMeta) SequenceFilter() string {
 	return strings.Join(tokens, ".")
 }
 
+// SequenceRequestFilter returns the subject filter matching the requests dispatched
+// in the message's sequence
+func (m *MsgMeta) SequenceRequestFilter() string {
+	tokens := []string{
+		m.AccountId,
+		m.InterestTopic,
+		ChannelRequest,
+		m.SequenceId,
+		">",
+	}
+
+	return strings.Join(tokens, ".")
+}
+
 func (m *MsgMeta) initMetadata() error {
 	meta, err := m.msg.Metadata()
 	if err != nil {
@@ -133,6 +415,7 @@ func (m *MsgMeta) initMetadata() error {
 
 	m.StreamSequence = meta.Sequence.Stream
 	m.ConsumerSequence = meta.Sequence.Consumer
+	m.NumDelivered = meta.NumDelivered
 	m.Timestamp = meta.Timestamp
 
 	return nil
@@ -144,6 +427,8 @@ func (m *MsgMeta) initMetadata() error {
 // `account_id.interest_topic.notify.sequence_id.event`
 // `account_id.interest_topic.notify.sequence_id.hops`
 // `account_id.interest_topic.notify.sequence_id.message_id`
+// `account_id.interest_topic.notify.sequence_id.message_id.progress.unique_id`
+// `account_id.interest_topic.notify.sequence_id.message_id.would_dispatch`
 // `account_id.interest_topic.request.sequence_id.message_id.app.handler`
 func (m *MsgMeta) initTokens() error {
 	subjectTokens := strings.Split(m.msg.Subject(), ".")
@@ -157,8 +442,17 @@ func (m *MsgMeta) initTokens() error {
 	m.SequenceId = subjectTokens[3]
 	m.MessageId = subjectTokens[4]
 
-	if len(subjectTokens) == 6 {
+	if len(subjectTokens) == 5 {
+		m.SequenceError = m.Channel == ChannelNotify && m.MessageId == ErrorMessageId
+	}
+
+	if len(subjectTokens) >= 6 {
+		m.Completed = subjectTokens[5] == CompletedMessageId
+		m.Deadline = subjectTokens[5] == DeadlineMessageId
 		m.Done = subjectTokens[5] == DoneMessageId
+		m.Progress = subjectTokens[5] == ProgressMessageId
+		m.TimedOut = subjectTokens[5] == TimeoutMessageId
+		m.WouldDispatch = subjectTokens[5] == WouldDispatchMessageId
 	}
 
 	switch m.Channel {
@@ -178,6 +472,37 @@ func (m *MsgMeta) initTokens() error {
 	}
 }
 
+// EvaluateOnly returns true if the source event is a replay that must not dispatch calls
+func (s SourceMeta) EvaluateOnly() bool {
+	return s.Replay != nil && s.Replay.Mode == ReplayModeEvaluate
+}
+
+// NewCompletionMsg creates a CompletionMsg for a sequence that started at startedAt,
+// with a status of StatusFailure if anything failed
+func NewCompletionMsg(startedAt time.Time, calls int, failed []string) CompletionMsg {
+	status := StatusSuccess
+	if len(failed) > 0 {
+		status = StatusFailure
+	}
+
+	return CompletionMsg{
+		Calls:       calls,
+		CompletedAt: time.Now(),
+		Failed:      failed,
+		StartedAt:   startedAt,
+		Status:      status,
+	}
+}
+
+func NewProgressMsg(percent int, message string) ProgressMsg {
+	return ProgressMsg{
+		Message:    message,
+		Percent:    percent,
+		ReportedAt: time.Now(),
+		Status:     StatusProgress,
+	}
+}
+
 func NewResultMsg(startedAt time.Time, result interface{}, err error) ResultMsg {
 	var resultJson interface{}
 	resultStr, ok := result.(string)
@@ -206,6 +531,34 @@ func NewResultMsg(startedAt time.Time, result interface{}, err error) ResultMsg
 	return resultMsg
 }
 
+func NewSequenceErrorMsg(err error, hopsHash string, on string, call string) SequenceErrorMsg {
+	return SequenceErrorMsg{
+		Call:       call,
+		Error:      err.Error(),
+		HopsHash:   hopsHash,
+		OccurredAt: time.Now(),
+		On:         on,
+	}
+}
+
+func NewTimeoutMsg(startedAt time.Time, ons []string, expired []string) TimeoutMsg {
+	return TimeoutMsg{
+		Expired:    expired,
+		Ons:        ons,
+		StartedAt:  startedAt,
+		TimedOutAt: time.Now(),
+	}
+}
+
+func NewWouldDispatchMsg(app string, handler string, inputs []byte) WouldDispatchMsg {
+	return WouldDispatchMsg{
+		App:        app,
+		Handler:    handler,
+		Inputs:     inputs,
+		RecordedAt: time.Now(),
+	}
+}
+
 // EventLogFilterSubject returns the subject used to get events for display to the
 // user in the UI.
 //
@@ -279,6 +632,11 @@ func SourceEventSubject(accountId string, interestTopic string, sequenceId strin
 	return strings.Join(tokens, ".")
 }
 
+// ValidReplayMode returns true if mode is ReplayModeFull or ReplayModeEvaluate
+func ValidReplayMode(mode string) bool {
+	return mode == ReplayModeFull || mode == ReplayModeEvaluate
+}
+
 // WorkerRequestFilterSubject returns the filter subject for the worker consumer
 func WorkerRequestFilterSubject(accountId string, interestTopic string, appName string, handler string) string {
 	tokens := []string{
@@ -293,3 +651,39 @@ func WorkerRequestFilterSubject(accountId string, interestTopic string, appName
 
 	return strings.Join(tokens, ".")
 }
+
+// callMetaFromHeader reads the call metadata set in the headers of a call request
+func callMetaFromHeader(header nats.Header) CallMeta {
+	if header == nil {
+		return CallMeta{}
+	}
+
+	return CallMeta{
+		Action:     header.Get(ActionHeader),
+		Event:      header.Get(EventHeader),
+		HopsHash:   header.Get(HopsHashHeader),
+		On:         header.Get(OnHeader),
+		RequestId:  header.Get(RequestIdHeader),
+		SequenceId: header.Get(SequenceIdHeader),
+	}
+}
+
+// markReplayEvent adds replay metadata to the hops envelope of a source event,
+// leaving the rest of the event untouched
+func markReplayEvent(data []byte, replay ReplayMeta) ([]byte, error) {
+	event := map[string]any{}
+	err := json.Unmarshal(data, &event)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode source event: %w", err)
+	}
+
+	hopsMeta, ok := event[HopsMessageId].(map[string]any)
+	if !ok {
+		hopsMeta = map[string]any{}
+	}
+
+	hopsMeta["replay"] = replay
+	event[HopsMessageId] = hopsMeta
+
+	return json.Marshal(event)
+}
@@ -0,0 +1,182 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testMsg is a stub jetstream.Msg, only implementing the methods used by Parse
+type testMsg struct {
+	jetstream.Msg
+	headers nats.Header
+	subject string
+	metaErr error
+}
+
+func (t *testMsg) Headers() nats.Header {
+	return t.headers
+}
+
+func (t *testMsg) Metadata() (*jetstream.MsgMetadata, error) {
+	if t.metaErr != nil {
+		return nil, t.metaErr
+	}
+
+	meta := &jetstream.MsgMetadata{
+		Sequence:  jetstream.SequencePair{Consumer: 1, Stream: 2},
+		Timestamp: time.Now(),
+	}
+	return meta, nil
+}
+
+func (t *testMsg) Subject() string {
+	return t.subject
+}
+
+func TestParse(t *testing.T) {
+	type testCase struct {
+		name        string
+		subject     string
+		metaErr     error
+		expectedErr error
+	}
+
+	tests := []testCase{
+		{
+			name:    "Valid notify message",
+			subject: "account.default.notify.SEQ_ID.event",
+		},
+		{
+			name:    "Valid request message",
+			subject: "account.default.request.SEQ_ID.MSG_ID.app.handler",
+		},
+		{
+			name:        "Too few tokens",
+			subject:     "account.default.notify.SEQ_ID",
+			expectedErr: ErrMalformedSubject,
+		},
+		{
+			name:        "Unknown channel",
+			subject:     "account.default.other.SEQ_ID.event",
+			expectedErr: ErrMalformedSubject,
+		},
+		{
+			name:        "Request missing app and handler",
+			subject:     "account.default.request.SEQ_ID.MSG_ID",
+			expectedErr: ErrMalformedSubject,
+		},
+		{
+			name:        "Missing metadata",
+			subject:     "account.default.notify.SEQ_ID.event",
+			metaErr:     errors.New("Not a JetStream message"),
+			expectedErr: ErrMalformedPayload,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			msg := &testMsg{subject: tc.subject, metaErr: tc.metaErr}
+
+			parsed, err := Parse(msg)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				assert.Nil(t, parsed)
+				return
+			}
+
+			if assert.NoError(t, err) {
+				assert.Equal(t, "SEQ_ID", parsed.SequenceId)
+				assert.Equal(t, uint64(2), parsed.StreamSequence)
+			}
+		})
+	}
+}
+
+func TestParseSequenceError(t *testing.T) {
+	tests := []struct {
+		name     string
+		subject  string
+		expected bool
+	}{
+		{
+			name:     "Sequence error event",
+			subject:  "account.default.notify.SEQ_ID.error",
+			expected: true,
+		},
+		{
+			name:     "Done message of on block named error",
+			subject:  "account.default.notify.SEQ_ID.error.done",
+			expected: false,
+		},
+		{
+			name:     "Request for call named error",
+			subject:  "account.default.request.SEQ_ID.error.app.handler",
+			expected: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			parsed, err := Parse(&testMsg{subject: tc.subject})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, parsed.SequenceError)
+		})
+	}
+}
+
+func TestParseCallMeta(t *testing.T) {
+	callMeta := CallMeta{
+		Action:     "opened",
+		Event:      "pull_request",
+		HopsHash:   "HASH",
+		On:         "pipeline",
+		RequestId:  "REQ_ID",
+		SequenceId: "SEQ_ID",
+	}
+
+	parsed, err := Parse(&testMsg{
+		headers: callMeta.header(),
+		subject: "account.default.request.SEQ_ID.pipeline-call.app.handler",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, callMeta, parsed.Call, "Call metadata should survive a round trip through headers")
+
+	parsed, err = Parse(&testMsg{subject: "account.default.request.SEQ_ID.MSG_ID.app.handler"})
+	require.NoError(t, err)
+	assert.Equal(t, CallMeta{}, parsed.Call, "Requests without headers should have empty call metadata")
+}
+
+func TestMsgMetaContext(t *testing.T) {
+	_, ok := MsgMetaFromContext(context.Background())
+	assert.False(t, ok, "Context without metadata should not return any")
+
+	msgMeta := &MsgMeta{SequenceId: "SEQ_ID", HandlerName: "handler"}
+	ctx := ContextWithMsgMeta(context.Background(), msgMeta)
+
+	fromCtx, ok := MsgMetaFromContext(ctx)
+	if assert.True(t, ok, "Context should carry metadata") {
+		assert.Same(t, msgMeta, fromCtx)
+	}
+}
+
+func TestCreateSourceEventRequestId(t *testing.T) {
+	meta := SourceMeta{Source: "hiphops", Event: "task", Action: "deploy"}
+	_, sequenceId, err := CreateSourceEventWithMeta(map[string]any{"env": "prod"}, meta)
+	require.NoError(t, err)
+
+	meta.RequestId = "REQ_ID"
+	event, withRequestSequenceId, err := CreateSourceEventWithMeta(map[string]any{"env": "prod"}, meta)
+	require.NoError(t, err)
+	assert.Equal(t, sequenceId, withRequestSequenceId, "Request ID should not change the sequence ID")
+
+	parsed, err := ParseSourceMeta(event)
+	require.NoError(t, err)
+	assert.Equal(t, meta, parsed, "Request ID should be kept in the event")
+}
@@ -0,0 +1,129 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type (
+	// MultiAccountClient consumes and publishes across several accounts, e.g. for
+	// tools that aggregate or monitor the events of many accounts at once
+	//
+	// Each account has its own Client, and so its own connection, as NATS accounts
+	// are isolated from one another. Single account use should stick to Client.
+	MultiAccountClient struct {
+		accountIds []string
+		clients    map[string]*Client
+	}
+
+	accountIdCtxKey struct{}
+)
+
+// NewMultiAccountClient returns a client for every account in natsUrls, which maps
+// account IDs to the URL(s) to connect to the account with, as given to NewClient
+//
+// Every account's client is created with the same interest topic and ClientOpts,
+// so opts naming things after a single account shouldn't be given. WithStreamName
+// may be, to use a stream shared by accounts connecting to the same NATS account.
+// Only JetStream is supported.
+func NewMultiAccountClient(natsUrls map[string]string, interestTopic string, logger Logger, clientOpts ...ClientOpt) (*MultiAccountClient, error) {
+	if len(natsUrls) == 0 {
+		return nil, errors.New("At least one account is required")
+	}
+
+	m := &MultiAccountClient{
+		clients: map[string]*Client{},
+	}
+
+	for accountId, natsUrl := range natsUrls {
+		client, err := NewClient(natsUrl, accountId, interestTopic, logger, clientOpts...)
+		if err != nil {
+			m.Close()
+			return nil, fmt.Errorf("Unable to create client for account '%s': %w", accountId, err)
+		}
+
+		m.accountIds = append(m.accountIds, accountId)
+		m.clients[accountId] = client
+	}
+
+	sort.Strings(m.accountIds)
+
+	return m, nil
+}
+
+// AccountIdFromContext returns the ID of the account the sequence given to a
+// SequenceHandler is from
+func AccountIdFromContext(ctx context.Context) (string, bool) {
+	accountId, ok := ctx.Value(accountIdCtxKey{}).(string)
+	return accountId, ok
+}
+
+// AccountIds returns the IDs of the accounts the client is for, in alphabetical order
+func (m *MultiAccountClient) AccountIds() []string {
+	return append([]string{}, m.accountIds...)
+}
+
+// Client returns the client of a single account
+func (m *MultiAccountClient) Client(accountId string) (*Client, error) {
+	client, ok := m.clients[accountId]
+	if !ok {
+		return nil, fmt.Errorf("Account '%s' not found on client", accountId)
+	}
+
+	return client, nil
+}
+
+// Close closes the connection of every account
+func (m *MultiAccountClient) Close() {
+	for _, client := range m.clients {
+		client.Close()
+	}
+}
+
+// ConsumeSequences consumes sequences from every account's fromConsumer at once,
+// passing them all to handler. Handlers can tell which account a sequence is
+// from with AccountIdFromContext.
+//
+// Blocks until ctx is cancelled (or stopped, see ContextWithStop). If consuming
+// any account fails, the others are stopped too and the errors returned.
+func (m *MultiAccountClient) ConsumeSequences(ctx context.Context, fromConsumer string, handler SequenceHandler) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(m.accountIds))
+
+	for i, accountId := range m.accountIds {
+		i, client := i, m.clients[accountId]
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := client.ConsumeSequences(ctx, fromConsumer, handler)
+			if err != nil {
+				errs[i] = fmt.Errorf("Unable to consume sequences of account '%s': %w", client.AccountId(), err)
+				cancel()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// Publish publishes a message to an account, as Client.Publish
+func (m *MultiAccountClient) Publish(ctx context.Context, accountId string, data []byte, subjTokens ...string) (*jetstream.PubAck, bool, error) {
+	client, err := m.Client(accountId)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return client.Publish(ctx, data, subjTokens...)
+}
@@ -0,0 +1,113 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hiphops-io/hops/logs"
+)
+
+type accountSequence struct {
+	accountId  string
+	sequenceId string
+}
+
+type accountSequenceHandler struct {
+	received chan accountSequence
+}
+
+func (a *accountSequenceHandler) SequenceCallback(ctx context.Context, sequenceId string, msgBundle MessageBundle) error {
+	accountId, _ := AccountIdFromContext(ctx)
+	a.received <- accountSequence{accountId: accountId, sequenceId: sequenceId}
+	return nil
+}
+
+func TestMultiAccountClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	accountIds := []string{"account-one", "account-two"}
+
+	logger := logs.NoOpLogger()
+	natsLogger := logs.NewNatsZeroLogger(logger)
+
+	localNats, err := NewLocalServer("./testdata/multi-account-nats.conf", t.TempDir(), false, &natsLogger)
+	require.NoError(t, err, "Test setup: Embedded NATS server should start without errors")
+	defer localNats.Close()
+
+	natsUrls := map[string]string{}
+	for _, accountId := range accountIds {
+		setupAccountStream(ctx, t, localNats, accountId)
+
+		natsUrls[accountId], err = localNats.AuthUrl(accountId)
+		require.NoError(t, err, "Test setup: Should have valid auth URL for NATS")
+	}
+
+	client, err := NewMultiAccountClient(natsUrls, DefaultInterestTopic, &natsLogger)
+	require.NoError(t, err, "Multi account client should initialise without error")
+	defer client.Close()
+
+	assert.Equal(t, accountIds, client.AccountIds())
+
+	handler := &accountSequenceHandler{received: make(chan accountSequence, len(accountIds))}
+	go client.ConsumeSequences(ctx, DefaultConsumerName, handler)
+
+	for _, accountId := range accountIds {
+		_, _, err := client.Publish(ctx, accountId, []byte("{}"), ChannelNotify, "SEQ_"+accountId, SourceEventId)
+		require.NoError(t, err, "Message should be published to the account")
+	}
+
+	received := []accountSequence{}
+	for range accountIds {
+		select {
+		case seq := <-handler.received:
+			received = append(received, seq)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "Timed out waiting for sequences to be consumed")
+		}
+	}
+
+	assert.ElementsMatch(t, []accountSequence{
+		{accountId: "account-one", sequenceId: "SEQ_account-one"},
+		{accountId: "account-two", sequenceId: "SEQ_account-two"},
+	}, received, "Each sequence should be consumed with the account it's from")
+
+	_, _, err = client.Publish(ctx, "unknown-account", []byte("{}"), ChannelNotify, "SEQ_ID", SourceEventId)
+	assert.Error(t, err, "Publishing to an account without a client should fail")
+}
+
+// setupAccountStream creates the stream and runner consumer of an account, as
+// NewLocalServer only does so for a single account
+func setupAccountStream(ctx context.Context, t *testing.T, localNats *LocalServer, accountId string) {
+	nc, err := localNats.Connect(accountId)
+	require.NoError(t, err, "Test setup: Should connect to account")
+	defer nc.Drain()
+
+	js, err := jetstream.New(nc)
+	require.NoError(t, err, "Test setup: Should create JetStream context")
+
+	stream, err := js.CreateStream(ctx, jetstream.StreamConfig{
+		Name:     accountId,
+		Subjects: []string{fmt.Sprintf("%s.>", accountId)},
+	})
+	if errors.Is(err, jetstream.ErrStreamNameAlreadyInUse) {
+		stream, err = js.Stream(ctx, accountId)
+	}
+	require.NoError(t, err, "Test setup: Should create account stream")
+
+	_, err = stream.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
+		Name:          fmt.Sprintf("%s-%s-%s", accountId, DefaultInterestTopic, ChannelNotify),
+		FilterSubject: NotifyFilterSubject(accountId, DefaultInterestTopic),
+		DeliverPolicy: jetstream.DeliverNewPolicy,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		MaxDeliver:    3,
+	})
+	require.NoError(t, err, "Test setup: Should create runner consumer")
+}
@@ -0,0 +1,102 @@
+// Starts embedded NATS servers and clients for tests of code built on hops
+//
+// Servers are in-process and JetStream enabled, set up as hiphops.io would be for
+// a single account. They don't listen on the network, so tests can run in
+// parallel and in sandboxes without ports.
+package natstest
+
+import (
+	_ "embed"
+	"os"
+	"path/filepath"
+	"testing"
+
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hiphops-io/hops/logs"
+	"github.com/hiphops-io/hops/nats"
+)
+
+// natsConf is the config of the embedded servers, which the nats package's own
+// tests also start servers with
+//
+//go:embed nats.conf
+var natsConf []byte
+
+// NewServer starts an embedded NATS server and returns a client connected to it
+//
+// The client is created with clientOpts, as with nats.NewClient, so is a runner
+// consumer unless any are given. Both are closed when the test finishes.
+func NewServer(t testing.TB, clientOpts ...nats.ClientOpt) *nats.Client {
+	return NewClient(t, NewLocalServer(t), clientOpts...)
+}
+
+// NewLocalServer starts an embedded NATS server, closed when the test finishes
+//
+// Use NewClient to connect clients to it, e.g. to run a runner and worker
+// against the same server.
+func NewLocalServer(t testing.TB) *nats.LocalServer {
+	t.Helper()
+
+	dir := t.TempDir()
+	confPath := filepath.Join(dir, "nats.conf")
+	err := os.WriteFile(confPath, natsConf, 0o644)
+	require.NoError(t, err, "Test setup: Should write NATS config")
+
+	localNats, err := nats.NewInProcessServer(confPath, filepath.Join(dir, "data"), false, natsLogger())
+	require.NoError(t, err, "Test setup: Embedded NATS server should start without errors")
+	t.Cleanup(localNats.Close)
+
+	return localNats
+}
+
+// NewClient returns a client connected to localNats, closed when the test finishes
+func NewClient(t testing.TB, localNats *nats.LocalServer, clientOpts ...nats.ClientOpt) *nats.Client {
+	t.Helper()
+
+	authUrl, err := localNats.AuthUrl("")
+	require.NoError(t, err, "Test setup: Should have valid auth URL for NATS")
+
+	user, err := localNats.User("")
+	require.NoError(t, err, "Test setup: Should have valid NATS user")
+
+	if len(clientOpts) == 0 {
+		clientOpts = nats.DefaultClientOpts()
+	}
+	// Async errors are otherwise printed, e.g. as in-process connections close
+	connectOpts := append(localNats.ConnectOptions(), natsgo.ErrorHandler(func(*natsgo.Conn, *natsgo.Subscription, error) {}))
+	clientOpts = append([]nats.ClientOpt{nats.WithConnectOptions(connectOpts...)}, clientOpts...)
+
+	natsClient, err := nats.NewClient(authUrl, user.Account.Name, nats.DefaultInterestTopic, natsLogger(), clientOpts...)
+	require.NoError(t, err, "Test setup: NATS client should initialise without error")
+	t.Cleanup(natsClient.Close)
+
+	return natsClient
+}
+
+// NewCoreClient returns a core NATS client (see nats.NewCoreClient) connected to
+// localNats, closed when the test finishes
+func NewCoreClient(t testing.TB, localNats *nats.LocalServer, clientOpts ...nats.ClientOpt) *nats.Client {
+	t.Helper()
+
+	authUrl, err := localNats.AuthUrl("")
+	require.NoError(t, err, "Test setup: Should have valid auth URL for NATS")
+
+	user, err := localNats.User("")
+	require.NoError(t, err, "Test setup: Should have valid NATS user")
+
+	connectOpts := append(localNats.ConnectOptions(), natsgo.ErrorHandler(func(*natsgo.Conn, *natsgo.Subscription, error) {}))
+	clientOpts = append([]nats.ClientOpt{nats.WithConnectOptions(connectOpts...)}, clientOpts...)
+
+	natsClient, err := nats.NewCoreClient(authUrl, user.Account.Name, nats.DefaultInterestTopic, natsLogger(), clientOpts...)
+	require.NoError(t, err, "Test setup: Core NATS client should initialise without error")
+	t.Cleanup(natsClient.Close)
+
+	return natsClient
+}
+
+func natsLogger() *logs.NatsZeroLogger {
+	logger := logs.NewNatsZeroLogger(logs.NoOpLogger())
+	return &logger
+}
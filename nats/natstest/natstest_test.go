@@ -0,0 +1,43 @@
+package natstest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hiphops-io/hops/nats"
+)
+
+func TestNewServer(t *testing.T) {
+	ctx := context.Background()
+	natsClient := NewServer(t)
+
+	assert.True(t, natsClient.NatsConn.IsConnected(), "Client should be connected")
+	assert.Contains(t, natsClient.Consumers, nats.DefaultConsumerName, "Client should default to a runner consumer")
+
+	_, sent, err := natsClient.Publish(ctx, []byte("data"), nats.ChannelNotify, "SEQ_ID", "event")
+	require.NoError(t, err, "Messages should be published to the account stream")
+	assert.True(t, sent)
+
+	msg, err := natsClient.GetMsg(ctx, nats.ChannelNotify, "SEQ_ID", "event")
+	require.NoError(t, err, "Published messages should be fetched")
+	assert.Equal(t, []byte("data"), msg.Data)
+}
+
+func TestNewClient(t *testing.T) {
+	ctx := context.Background()
+	localNats := NewLocalServer(t)
+	assert.Nil(t, localNats.NatsServer.Addr(), "Server shouldn't listen on the network")
+
+	runner := NewClient(t, localNats)
+	worker := NewClient(t, localNats, nats.WithWorker("app"))
+
+	_, _, err := runner.Publish(ctx, []byte("data"), nats.ChannelNotify, "SEQ_ID", "event")
+	require.NoError(t, err, "Test setup: Message should be published")
+
+	msg, err := worker.GetMsg(ctx, nats.ChannelNotify, "SEQ_ID", "event")
+	require.NoError(t, err, "Clients of the same server should share the account stream")
+	assert.Equal(t, []byte("data"), msg.Data)
+}
@@ -0,0 +1,115 @@
+package nats
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+var _ jetstream.Msg = (*noAckMsg)(nil)
+
+// noAckMsg is a message consumed with AckNonePolicy, which is never redelivered,
+// so acks and naks do nothing rather than making requests the server ignores
+type noAckMsg struct {
+	jetstream.Msg
+}
+
+func (m noAckMsg) Ack() error {
+	return nil
+}
+
+func (m noAckMsg) DoubleAck(ctx context.Context) error {
+	return nil
+}
+
+func (m noAckMsg) InProgress() error {
+	return nil
+}
+
+func (m noAckMsg) Nak() error {
+	return nil
+}
+
+func (m noAckMsg) NakWithDelay(delay time.Duration) error {
+	return nil
+}
+
+func (m noAckMsg) Term() error {
+	return nil
+}
+
+// consumerAckPolicy returns the ack policy of a consumer, as it was when it was created or fetched
+func consumerAckPolicy(consumer jetstream.Consumer) jetstream.AckPolicy {
+	info := consumer.CachedInfo()
+	if info == nil {
+		return jetstream.AckExplicitPolicy
+	}
+
+	return info.Config.AckPolicy
+}
+
+// requireAcks errors if the client's ack policy is AckNonePolicy, as messages
+// consumed by the option given must be redelivered when they fail
+func (c *Client) requireAcks(option string) error {
+	if c.ackPolicy == jetstream.AckNonePolicy {
+		return fmt.Errorf("%s can't be used with AckNonePolicy, as failed messages must be redelivered", option)
+	}
+
+	return nil
+}
+
+// WithAckPolicy sets the ack policy of consumers created by WithObserver
+// (defaults to jetstream.AckExplicitPolicy)
+//
+// AckNonePolicy gives at-most-once delivery: messages count as delivered once
+// sent, so they're never redelivered, even if the client fails to handle them
+// or disconnects before they arrive. In return there are no acks to send and no
+// redelivery overhead. This suits observers such as monitoring, which can miss
+// messages, but never the runner or workers, so it can't be combined with
+// WithRunner, WithLocalRunner, WithWorker, WithReplay or WithSequenceReplay.
+//
+// Must be given before any option creating a consumer.
+func WithAckPolicy(policy jetstream.AckPolicy) ClientOpt {
+	return func(c *Client) error {
+		if len(c.Consumers) > 0 {
+			return fmt.Errorf("WithAckPolicy must be given before any option creating a consumer")
+		}
+
+		c.ackPolicy = policy
+		return nil
+	}
+}
+
+// WithObserver initialises the client with an ephemeral consumer of every new
+// message published to the account's sequences, both notify and request, using
+// the ack policy set by WithAckPolicy
+//
+// Observers see messages alongside the runner and workers, without affecting
+// their delivery. Consume the messages with Consume, rather than ConsumeSequences.
+func WithObserver(name string) ClientOpt {
+	return func(c *Client) error {
+		err := c.connect()
+		if err != nil {
+			return err
+		}
+
+		cfg := jetstream.ConsumerConfig{
+			FilterSubjects: []string{
+				NotifyFilterSubject(c.accountId, c.interestTopic),
+				RequestFilterSubject(c.accountId, c.interestTopic),
+			},
+			DeliverPolicy:     jetstream.DeliverNewPolicy,
+			AckPolicy:         c.ackPolicy,
+			InactiveThreshold: time.Minute,
+		}
+		consumer, err := c.JetStream.CreateOrUpdateConsumer(context.Background(), c.streamName, cfg)
+		if err != nil {
+			return fmt.Errorf("Unable to create observer consumer: %w", err)
+		}
+
+		c.Consumers[name] = consumer
+		return nil
+	}
+}
@@ -0,0 +1,93 @@
+package nats
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientObserverAckNone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	localNats := setupLocalNatsServer(t)
+	defer localNats.Close()
+
+	authUrl, err := localNats.AuthUrl("")
+	require.NoError(t, err, "Test setup: Should have valid auth URL for NATS")
+	user, err := localNats.User("")
+	require.NoError(t, err, "Test setup: Should have valid NATS user")
+
+	observer, err := NewClient(authUrl, user.Account.Name, DefaultInterestTopic, nil, WithAckPolicy(jetstream.AckNonePolicy), WithObserver("observer"))
+	require.NoError(t, err, "Observer client should initialise")
+	defer observer.Close()
+
+	assert.Equal(t, jetstream.AckNonePolicy, consumerAckPolicy(observer.Consumers["observer"]))
+
+	received := make(chan *MsgMeta, 2)
+	go observer.Consume(ctx, "observer", func(msg jetstream.Msg) {
+		assert.NoError(t, msg.Ack(), "Acking should do nothing")
+		assert.NoError(t, msg.Nak(), "Naking should do nothing")
+
+		m, err := Parse(msg)
+		if assert.NoError(t, err) {
+			received <- m
+		}
+	})
+
+	_, _, err = observer.Publish(ctx, []byte(`{}`), ChannelNotify, "SEQ_ID", SourceEventId)
+	require.NoError(t, err, "Test setup: Should publish source event")
+	_, _, err = observer.Publish(ctx, []byte(`{}`), ChannelRequest, "SEQ_ID", "call", "app", "handler")
+	require.NoError(t, err, "Test setup: Should publish request")
+
+	for _, channel := range []string{ChannelNotify, ChannelRequest} {
+		select {
+		case m := <-received:
+			assert.Equal(t, channel, m.Channel)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "Timed out waiting for observed messages")
+		}
+	}
+
+	select {
+	case m := <-received:
+		assert.Fail(t, "Messages should not be redelivered", "Received %s again", m.MessageId)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	err = observer.ConsumeSequences(ctx, "observer", nil)
+	assert.ErrorContains(t, err, "AckNonePolicy", "Sequences need retries, so can't use AckNone")
+}
+
+func TestClientAckNoneGuards(t *testing.T) {
+	localNats := setupLocalNatsServer(t)
+	defer localNats.Close()
+
+	authUrl, err := localNats.AuthUrl("")
+	require.NoError(t, err, "Test setup: Should have valid auth URL for NATS")
+	user, err := localNats.User("")
+	require.NoError(t, err, "Test setup: Should have valid NATS user")
+
+	guarded := map[string]ClientOpt{
+		"WithRunner":      WithRunner(DefaultConsumerName),
+		"WithLocalRunner": WithLocalRunner(DefaultConsumerName),
+		"WithWorker":      WithWorker("app"),
+	}
+
+	for name, opt := range guarded {
+		_, err := NewClient(authUrl, user.Account.Name, DefaultInterestTopic, nil, WithAckPolicy(jetstream.AckNonePolicy), opt)
+		assert.ErrorContains(t, err, "AckNonePolicy", "%s should reject AckNone", name)
+	}
+
+	_, err = NewClient(authUrl, user.Account.Name, DefaultInterestTopic, nil, WithObserver("observer"), WithAckPolicy(jetstream.AckNonePolicy))
+	assert.Error(t, err, "WithAckPolicy should be given before consumers are created")
+
+	client, err := NewClient(authUrl, user.Account.Name, DefaultInterestTopic, nil, WithObserver("observer"))
+	require.NoError(t, err)
+	defer client.Close()
+	assert.Equal(t, jetstream.AckExplicitPolicy, consumerAckPolicy(client.Consumers["observer"]), "Observers should ack explicitly by default")
+}
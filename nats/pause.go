@@ -0,0 +1,188 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// pauseState tracks whether consumption is paused, along with how many Consume
+// calls are running and how many of those are pulling messages
+type pauseState struct {
+	// changed is closed (and replaced) whenever the state changes
+	changed   chan struct{}
+	consuming int
+	mu        sync.Mutex
+	paused    bool
+	pulling   int
+}
+
+// Pause stops Consume (and so ConsumeSequences) pulling messages until Resume is
+// called, waiting until every consumer has stopped or ctx is done
+//
+// Messages already received are still handled, whilst those not yet pulled are
+// left on the server along with the consumers' state, so consuming carries on
+// from where it stopped once resumed. Messages that were pulled but not yet
+// handed over are redelivered after their ack wait. The JetStream consumer
+// pause API isn't used, as it needs a newer NATS server than hops supports.
+//
+// Core clients can't be paused, as messages published whilst not subscribed are lost.
+func (c *Client) Pause(ctx context.Context) error {
+	if c.memStore != nil {
+		return errors.New("Core clients can't be paused without losing messages")
+	}
+
+	c.pause.set(true)
+
+	return c.pause.wait(ctx, func() bool {
+		return c.pause.pulling == 0
+	})
+}
+
+// Resume restarts consuming after Pause, waiting until every consumer is pulling
+// messages again or ctx is done. It does nothing if not paused.
+func (c *Client) Resume(ctx context.Context) error {
+	c.pause.set(false)
+
+	return c.pause.wait(ctx, func() bool {
+		return c.pause.pulling == c.pause.consuming
+	})
+}
+
+// Paused returns true if consuming is paused (see Pause)
+func (c *Client) Paused() bool {
+	c.pause.mu.Lock()
+	defer c.pause.mu.Unlock()
+
+	return c.pause.paused
+}
+
+// set pauses or resumes consumption
+func (p *pauseState) set(paused bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.paused == paused {
+		return
+	}
+
+	p.paused = paused
+	p.notify()
+}
+
+// startConsuming records a Consume call running, returning a function to
+// record it returning
+func (p *pauseState) startConsuming() func() {
+	return p.track(&p.consuming)
+}
+
+// startPulling records a consumer pulling messages, returning a function to
+// record it stopping
+func (p *pauseState) startPulling() func() {
+	return p.track(&p.pulling)
+}
+
+// track increments count, returning a function to decrement it again
+func (p *pauseState) track(count *int) func() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	*count++
+	p.notify()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+
+			*count--
+			p.notify()
+		})
+	}
+}
+
+// changes returns a channel closed once the state next changes
+func (p *pauseState) changes() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.changed == nil {
+		p.changed = make(chan struct{})
+	}
+
+	return p.changed
+}
+
+// wait blocks until done returns true (called with the lock held) or ctx is done
+func (p *pauseState) wait(ctx context.Context, done func() bool) error {
+	for {
+		changed := p.changes()
+
+		p.mu.Lock()
+		isDone := done()
+		p.mu.Unlock()
+
+		if isDone {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// notify wakes anything waiting on a change, and must be called with the lock held
+func (p *pauseState) notify() {
+	if p.changed != nil {
+		close(p.changed)
+	}
+
+	p.changed = make(chan struct{})
+}
+
+// waitUntilPausedOrStopped blocks until consuming is paused, returning true, or
+// ctx is cancelled or stopped (see ContextWithStop), returning false
+func (c *Client) waitUntilPausedOrStopped(ctx context.Context) bool {
+	// A nil stop channel never fires
+	stop, _ := ctx.Value(consumeStopCtxKey{}).(<-chan struct{})
+
+	for {
+		changed := c.pause.changes()
+		if c.Paused() {
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-stop:
+			return false
+		case <-changed:
+		}
+	}
+}
+
+// waitUntilResumed blocks until consuming isn't paused, returning true, or ctx
+// is cancelled or stopped (see ContextWithStop), returning false
+func (c *Client) waitUntilResumed(ctx context.Context) bool {
+	stop, _ := ctx.Value(consumeStopCtxKey{}).(<-chan struct{})
+
+	for {
+		changed := c.pause.changes()
+		if !c.Paused() {
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-stop:
+			return false
+		case <-changed:
+		}
+	}
+}
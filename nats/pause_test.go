@@ -0,0 +1,82 @@
+package nats
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientPause(t *testing.T) {
+	ctx := context.Background()
+	hopsNats, cleanup := setupClient(ctx, t)
+	defer cleanup()
+
+	var mu sync.Mutex
+	received := []string{}
+	receivedCount := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received)
+	}
+
+	consumeCtx, stop := ContextWithStop(ctx)
+	consumed := make(chan error)
+	go func() {
+		consumed <- hopsNats.Consume(consumeCtx, DefaultConsumerName, func(msg jetstream.Msg) {
+			mu.Lock()
+			received = append(received, msg.Subject())
+			mu.Unlock()
+			msg.Ack()
+		})
+	}()
+
+	publish := func(sequenceId string) {
+		_, _, err := hopsNats.Publish(ctx, []byte("{}"), ChannelNotify, sequenceId, SourceEventId)
+		require.NoError(t, err, "Test setup: Message should be published")
+	}
+
+	publish("BEFORE")
+	require.Eventually(t, func() bool { return receivedCount() == 1 }, 2*time.Second, 10*time.Millisecond, "Messages should be consumed before pausing")
+
+	pauseCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	err := hopsNats.Pause(pauseCtx)
+	require.NoError(t, err, "Pause should return once consuming has stopped")
+	assert.True(t, hopsNats.Paused())
+
+	publish("WHILST_PAUSED")
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, 1, receivedCount(), "Messages shouldn't be consumed whilst paused")
+
+	err = hopsNats.Resume(pauseCtx)
+	require.NoError(t, err, "Resume should return once consuming has restarted")
+	assert.False(t, hopsNats.Paused())
+	require.Eventually(t, func() bool { return receivedCount() == 2 }, 2*time.Second, 10*time.Millisecond, "Messages left whilst paused should be consumed once resumed")
+
+	// Consuming can still be stopped whilst paused
+	err = hopsNats.Pause(pauseCtx)
+	require.NoError(t, err)
+	stop()
+
+	select {
+	case err := <-consumed:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Consume should return once stopped, even if paused")
+	}
+}
+
+func TestCoreClientPause(t *testing.T) {
+	hopsNats, cleanup := setupCoreClient(context.Background(), t)
+	defer cleanup()
+
+	err := hopsNats.Pause(context.Background())
+	assert.Error(t, err, "Core clients shouldn't be paused, as messages would be lost")
+	assert.False(t, hopsNats.Paused())
+}
@@ -0,0 +1,196 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// Key/value bucket that worker result history is stored in (see NewKVResultStore)
+	ResultsBucket = "results"
+	// Max number of results returned by a ResultQuery without a limit
+	DefaultResultQueryLimit = 100
+)
+
+type (
+	// KVResultStore stores worker result history in a JetStream key/value bucket
+	//
+	// Records are keyed by app, handler, sequence and call, so storing a record
+	// for the same call again replaces it.
+	KVResultStore struct {
+		kv nats.KeyValue
+	}
+
+	// ResultQuery filters the results returned when querying result history.
+	// Empty fields match any value.
+	ResultQuery struct {
+		App        string
+		Handler    string
+		Limit      int
+		SequenceId string
+	}
+
+	// ResultRecord is a result sent by a worker in response to a request
+	ResultRecord struct {
+		App        string    `json:"app"`
+		Call       string    `json:"call"`
+		FinishedAt time.Time `json:"finished_at"`
+		Handler    string    `json:"handler"`
+		Result     ResultMsg `json:"result"`
+		SequenceId string    `json:"sequence_id"`
+	}
+)
+
+// NewKVResultStore returns a KVResultStore using the client's connection, creating
+// the results bucket if it doesn't exist
+//
+// Records are kept for ttl, or indefinitely if ttl is 0. The ttl of an existing
+// bucket is not changed.
+func NewKVResultStore(c *Client, ttl time.Duration) (*KVResultStore, error) {
+	js, err := c.NatsConn.JetStream()
+	if err != nil {
+		return nil, err
+	}
+
+	kv, err := js.KeyValue(ResultsBucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{
+			Bucket:      ResultsBucket,
+			Description: "Result history of handled requests",
+			TTL:         ttl,
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to initialise result store: %w", err)
+	}
+
+	return &KVResultStore{kv: kv}, nil
+}
+
+// NewResultRecord returns the record of a result sent in response to a request
+func NewResultRecord(requestMsg *MsgMeta, result ResultMsg) ResultRecord {
+	return ResultRecord{
+		App:        requestMsg.AppName,
+		Call:       requestMsg.MessageId,
+		FinishedAt: result.Hops.FinishedAt,
+		Handler:    requestMsg.HandlerName,
+		Result:     result,
+		SequenceId: requestMsg.SequenceId,
+	}
+}
+
+// Put stores a record, replacing any previous record for the same call
+func (k *KVResultStore) Put(ctx context.Context, record ResultRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	_, err = k.kv.Put(resultKey(record.App, record.Handler, record.SequenceId, record.Call), data)
+	if err != nil {
+		return fmt.Errorf("Unable to store result: %w", err)
+	}
+
+	return nil
+}
+
+// Recent returns the records matching the query, most recently finished first
+//
+// Only the keys matching the query are listed, without their values, and only the
+// values of the most recently stored limit of them are fetched. As results are
+// stored once finished, these are the most recently finished.
+func (k *KVResultStore) Recent(ctx context.Context, query ResultQuery) ([]ResultRecord, error) {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultResultQueryLimit
+	}
+
+	watcher, err := k.kv.Watch(query.keyPattern(), nats.MetaOnly(), nats.IgnoreDeletes(), nats.Context(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list results: %w", err)
+	}
+	defer watcher.Stop()
+
+	entries := []nats.KeyValueEntry{}
+	for entry := range watcher.Updates() {
+		// A nil entry marks the end of the stored keys
+		if entry == nil {
+			break
+		}
+
+		entries = append(entries, entry)
+	}
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("Unable to list results: %w", ctx.Err())
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Created().After(entries[j].Created())
+	})
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	records := []ResultRecord{}
+	for _, meta := range entries {
+		entry, err := k.kv.Get(meta.Key())
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Unable to get result '%s': %w", meta.Key(), err)
+		}
+
+		record := ResultRecord{}
+		err = json.Unmarshal(entry.Value(), &record)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to decode result '%s': %w", meta.Key(), err)
+		}
+
+		records = append(records, record)
+	}
+
+	return LimitResultRecords(records, limit), nil
+}
+
+// keyPattern returns the pattern of the keys in the results bucket matching the query
+func (q ResultQuery) keyPattern() string {
+	tokens := []string{}
+	for _, want := range []string{q.App, q.Handler, q.SequenceId} {
+		if want == "" {
+			want = "*"
+		}
+		tokens = append(tokens, want)
+	}
+
+	return resultKey(tokens[0], tokens[1], tokens[2], "*")
+}
+
+// LimitResultRecords sorts records with the most recently finished first, returning
+// at most limit records (or DefaultResultQueryLimit if limit is not positive)
+func LimitResultRecords(records []ResultRecord, limit int) []ResultRecord {
+	if limit <= 0 {
+		limit = DefaultResultQueryLimit
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].FinishedAt.After(records[j].FinishedAt)
+	})
+
+	if len(records) > limit {
+		records = records[:limit]
+	}
+
+	return records
+}
+
+// resultKey returns the key of a record in the results bucket
+func resultKey(app, handler, sequenceId, call string) string {
+	return fmt.Sprintf("%s.%s.%s.%s", app, handler, sequenceId, call)
+}
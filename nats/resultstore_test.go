@@ -0,0 +1,96 @@
+package nats
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKVResultStore(t *testing.T) {
+	ctx := context.Background()
+	hopsNats, cleanup := setupClient(ctx, t)
+	defer cleanup()
+
+	store, err := NewKVResultStore(hopsNats, time.Hour)
+	require.NoError(t, err, "Result store should initialise without error")
+
+	// Records are stored as results finish
+	now := time.Now().UTC()
+	records := []ResultRecord{
+		{App: "slack", Handler: "post", SequenceId: "SEQ_ONE", Call: "call_three", FinishedAt: now.Add(-3 * time.Minute)},
+		{App: "github", Handler: "create_issue", SequenceId: "SEQ_ONE", Call: "call_one", FinishedAt: now.Add(-2 * time.Minute)},
+		{App: "github", Handler: "create_issue", SequenceId: "SEQ_TWO", Call: "call_one", FinishedAt: now.Add(-time.Minute)},
+		{App: "github", Handler: "merge_pr", SequenceId: "SEQ_ONE", Call: "call_two", FinishedAt: now},
+	}
+	for _, record := range records {
+		record.Result = ResultMsg{Body: record.Call, Completed: true, Done: true}
+		err := store.Put(ctx, record)
+		require.NoError(t, err, "Test setup: Result should be stored without error")
+	}
+
+	// Storing a call again replaces its record
+	replaced := records[1]
+	replaced.FinishedAt = now.Add(time.Minute)
+	replaced.Result = ResultMsg{Body: "replaced", Errored: true, Done: true}
+	err = store.Put(ctx, replaced)
+	require.NoError(t, err, "Test setup: Result should be replaced without error")
+
+	tests := []struct {
+		name      string
+		query     ResultQuery
+		wantCalls []string
+	}{
+		{
+			name:      "All results, most recent first",
+			query:     ResultQuery{},
+			wantCalls: []string{"call_one", "call_two", "call_one", "call_three"},
+		},
+		{
+			name:      "By app",
+			query:     ResultQuery{App: "github"},
+			wantCalls: []string{"call_one", "call_two", "call_one"},
+		},
+		{
+			name:      "By handler",
+			query:     ResultQuery{App: "github", Handler: "create_issue"},
+			wantCalls: []string{"call_one", "call_one"},
+		},
+		{
+			name:      "By sequence",
+			query:     ResultQuery{SequenceId: "SEQ_ONE"},
+			wantCalls: []string{"call_one", "call_two", "call_three"},
+		},
+		{
+			name:      "With limit",
+			query:     ResultQuery{Limit: 2},
+			wantCalls: []string{"call_one", "call_two"},
+		},
+		{
+			name:      "No matches",
+			query:     ResultQuery{App: "jira"},
+			wantCalls: []string{},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := store.Recent(ctx, tc.query)
+			require.NoError(t, err, "Results should be queried without error")
+
+			calls := []string{}
+			for _, record := range got {
+				calls = append(calls, record.Call)
+			}
+			assert.Equal(t, tc.wantCalls, calls)
+		})
+	}
+
+	got, err := store.Recent(ctx, ResultQuery{SequenceId: "SEQ_ONE", Handler: "create_issue"})
+	require.NoError(t, err, "Results should be queried without error")
+	require.Len(t, got, 1)
+	assert.Equal(t, "replaced", got[0].Result.Body, "Latest result for a call should be returned")
+	assert.True(t, got[0].Result.Errored)
+}
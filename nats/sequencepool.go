@@ -0,0 +1,86 @@
+package nats
+
+import (
+	"sync"
+)
+
+type (
+	// sequencePool runs functions concurrently up to a limit, whilst never running
+	// two functions for the same sequence at once
+	sequencePool struct {
+		active    sync.WaitGroup
+		mu        sync.Mutex
+		sequences map[string]*sequenceLock
+		slots     chan struct{}
+	}
+
+	sequenceLock struct {
+		mu   sync.Mutex
+		refs int
+	}
+)
+
+func newSequencePool(limit int) *sequencePool {
+	if limit < 1 {
+		limit = 1
+	}
+
+	return &sequencePool{
+		sequences: map[string]*sequenceLock{},
+		slots:     make(chan struct{}, limit),
+	}
+}
+
+// Go blocks until a slot is free, then runs fn in a new goroutine once no other
+// function for the same sequence is running
+func (p *sequencePool) Go(sequenceId string, fn func()) {
+	p.slots <- struct{}{}
+	p.active.Add(1)
+
+	lock := p.acquire(sequenceId)
+
+	go func() {
+		defer func() {
+			p.release(sequenceId)
+			<-p.slots
+			p.active.Done()
+		}()
+
+		lock.mu.Lock()
+		defer lock.mu.Unlock()
+
+		fn()
+	}()
+}
+
+// Wait blocks until all running functions have returned
+func (p *sequencePool) Wait() {
+	p.active.Wait()
+}
+
+// acquire returns the lock for a sequence, creating it if necessary
+func (p *sequencePool) acquire(sequenceId string) *sequenceLock {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	lock, ok := p.sequences[sequenceId]
+	if !ok {
+		lock = &sequenceLock{}
+		p.sequences[sequenceId] = lock
+	}
+	lock.refs++
+
+	return lock
+}
+
+// release removes the lock for a sequence once nothing is waiting on it
+func (p *sequencePool) release(sequenceId string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	lock := p.sequences[sequenceId]
+	lock.refs--
+	if lock.refs == 0 {
+		delete(p.sequences, sequenceId)
+	}
+}
@@ -0,0 +1,51 @@
+package nats
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSequencePool(t *testing.T) {
+	pool := newSequencePool(3)
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	runningSequences := map[string]int{}
+	overlapped := false
+
+	// Two functions per sequence, so up to two could overlap within a sequence
+	for i := 0; i < 10; i++ {
+		sequenceId := fmt.Sprintf("SEQ_%d", i/2)
+
+		pool.Go(sequenceId, func() {
+			mu.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			runningSequences[sequenceId]++
+			if runningSequences[sequenceId] > 1 {
+				overlapped = true
+			}
+			mu.Unlock()
+
+			time.Sleep(20 * time.Millisecond)
+
+			mu.Lock()
+			running--
+			runningSequences[sequenceId]--
+			mu.Unlock()
+		})
+	}
+
+	pool.Wait()
+
+	assert.LessOrEqual(t, maxRunning, 3, "No more than the limit should run at once")
+	assert.Greater(t, maxRunning, 1, "Functions for different sequences should run concurrently")
+	assert.False(t, overlapped, "Functions for the same sequence should never run at once")
+	assert.Empty(t, pool.sequences, "Sequence locks should be released")
+}
@@ -0,0 +1,112 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// Key/value bucket that runner sequence state is stored in (see NewKVSequenceStateStore)
+	SequenceStateBucket = "sequence_state"
+	// How long sequence state is kept for by default, after which sequences are
+	// evaluated from their message bundle alone
+	DefaultSequenceStateTTL = 7 * 24 * time.Hour
+)
+
+type (
+	// KVSequenceStateStore stores the runner's state for each sequence in a
+	// JetStream key/value bucket, keyed by sequence ID
+	KVSequenceStateStore struct {
+		kv nats.KeyValue
+	}
+
+	// SequenceState is what a runner knows about a sequence from evaluating it
+	//
+	// State is advisory, the message bundle remains the source of truth. It may
+	// lag behind the bundle, or be lost entirely.
+	SequenceState struct {
+		Complete    bool                 `json:"complete"`
+		Dispatched  map[string]time.Time `json:"dispatched"`
+		EvaluatedAt time.Time            `json:"evaluated_at"`
+		SequenceId  string               `json:"sequence_id"`
+	}
+)
+
+// NewKVSequenceStateStore returns a KVSequenceStateStore using the client's
+// connection, creating the sequence state bucket if it doesn't exist
+//
+// State is kept for ttl, or indefinitely if ttl is 0. The ttl of an existing
+// bucket is not changed.
+func NewKVSequenceStateStore(c *Client, ttl time.Duration) (*KVSequenceStateStore, error) {
+	js, err := c.NatsConn.JetStream()
+	if err != nil {
+		return nil, err
+	}
+
+	kv, err := js.KeyValue(SequenceStateBucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{
+			Bucket:      SequenceStateBucket,
+			Description: "Runner state of each sequence",
+			TTL:         ttl,
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to initialise sequence state store: %w", err)
+	}
+
+	return &KVSequenceStateStore{kv: kv}, nil
+}
+
+// NewSequenceState returns the empty state of a sequence
+func NewSequenceState(sequenceId string) *SequenceState {
+	return &SequenceState{
+		Dispatched: map[string]time.Time{},
+		SequenceId: sequenceId,
+	}
+}
+
+// Get returns the state of a sequence, or nil if it has none
+func (k *KVSequenceStateStore) Get(ctx context.Context, sequenceId string) (*SequenceState, error) {
+	entry, err := k.kv.Get(sequenceId)
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get sequence state: %w", err)
+	}
+
+	state := NewSequenceState(sequenceId)
+	err = json.Unmarshal(entry.Value(), state)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode sequence state: %w", err)
+	}
+
+	return state, nil
+}
+
+// Put stores the state of a sequence, replacing any previous state
+func (k *KVSequenceStateStore) Put(ctx context.Context, state *SequenceState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	_, err = k.kv.Put(state.SequenceId, data)
+	if err != nil {
+		return fmt.Errorf("Unable to store sequence state: %w", err)
+	}
+
+	return nil
+}
+
+// IsDispatched returns whether a call is recorded as dispatched
+func (s *SequenceState) IsDispatched(callSlug string) bool {
+	_, ok := s.Dispatched[callSlug]
+	return ok
+}
@@ -0,0 +1,40 @@
+package nats
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKVSequenceStateStore(t *testing.T) {
+	ctx := context.Background()
+	hopsNats, cleanup := setupClient(ctx, t)
+	defer cleanup()
+
+	store, err := NewKVSequenceStateStore(hopsNats, time.Hour)
+	require.NoError(t, err, "Sequence state store should initialise without error")
+
+	state, err := store.Get(ctx, "SEQ_ID")
+	require.NoError(t, err, "Missing state should not be an error")
+	assert.Nil(t, state, "Sequences without state should return nil")
+
+	state = NewSequenceState("SEQ_ID")
+	state.Dispatched["pipeline-first"] = time.Now().UTC()
+	err = store.Put(ctx, state)
+	require.NoError(t, err, "State should be stored without error")
+
+	state.Complete = true
+	err = store.Put(ctx, state)
+	require.NoError(t, err, "State should be replaced without error")
+
+	stored, err := store.Get(ctx, "SEQ_ID")
+	require.NoError(t, err, "State should be fetched without error")
+	require.NotNil(t, stored)
+	assert.Equal(t, "SEQ_ID", stored.SequenceId)
+	assert.True(t, stored.Complete, "Latest state should be returned")
+	assert.True(t, stored.IsDispatched("pipeline-first"))
+	assert.False(t, stored.IsDispatched("pipeline-second"))
+}
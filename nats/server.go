@@ -16,18 +16,39 @@ import (
 	"github.com/nats-io/nats.go/jetstream"
 )
 
+// inProcessUrl stands in for the address of servers that don't listen on the
+// network, as connections to them are made in-process instead
+const inProcessUrl = "nats://in-process"
+
 // LocalServer is an in-process hiphops.io style NATS server instance
 // created from a NATS config file.
 type LocalServer struct {
 	NatsServer *server.Server
 	ServerOpts *server.Options
+
+	inProcess bool
 }
 
 // NewLocalServer starts an in-process nats server from a config file
 //
 // LocalServer.Close() should be called when finished with the server
 func NewLocalServer(natsConfigPath string, dataDir string, debug bool, logger server.Logger) (*LocalServer, error) {
-	localNats := &LocalServer{}
+	return newLocalServer(natsConfigPath, dataDir, debug, logger, false)
+}
+
+// NewInProcessServer starts an in-process nats server from a config file that
+// doesn't listen on the network, e.g. for tests
+//
+// Clients can only connect from the same process, with the options given by
+// ConnectOptions (which Connect already uses). Any port in the config is ignored.
+//
+// LocalServer.Close() should be called when finished with the server
+func NewInProcessServer(natsConfigPath string, dataDir string, debug bool, logger server.Logger) (*LocalServer, error) {
+	return newLocalServer(natsConfigPath, dataDir, debug, logger, true)
+}
+
+func newLocalServer(natsConfigPath string, dataDir string, debug bool, logger server.Logger, inProcess bool) (*LocalServer, error) {
+	localNats := &LocalServer{inProcess: inProcess}
 
 	err := localNats.initServerOpts(natsConfigPath, dataDir)
 	if err != nil {
@@ -48,6 +69,11 @@ func NewLocalServer(natsConfigPath string, dataDir string, debug bool, logger se
 	return localNats, nil
 }
 
+// AuthUrl returns the URL of the server, including the credentials of the
+// account's user
+//
+// In-process servers have no address, so their URL is only useful alongside
+// ConnectOptions, which connects without it.
 func (l *LocalServer) AuthUrl(accountName string) (string, error) {
 	user, err := l.User(accountName)
 	if err != nil {
@@ -55,6 +81,9 @@ func (l *LocalServer) AuthUrl(accountName string) (string, error) {
 	}
 
 	clientUrl := l.NatsServer.ClientURL()
+	if l.inProcess {
+		clientUrl = inProcessUrl
+	}
 	baseUrl, err := url.Parse(clientUrl)
 	if err != nil {
 		return "", err
@@ -78,7 +107,20 @@ func (l *LocalServer) Connect(accountName string) (*nats.Conn, error) {
 		return nil, err
 	}
 
-	return nats.Connect(natsurl)
+	return nats.Connect(natsurl, l.ConnectOptions()...)
+}
+
+// ConnectOptions returns the options needed to connect to the server, on top of
+// its AuthUrl
+//
+// These are only needed for in-process servers, and can be given to a Client
+// with WithConnectOptions.
+func (l *LocalServer) ConnectOptions() []nats.Option {
+	if !l.inProcess {
+		return nil
+	}
+
+	return []nats.Option{nats.InProcessServer(l.NatsServer)}
 }
 
 func (l *LocalServer) User(accountName string) (*server.User, error) {
@@ -203,6 +245,10 @@ func (l *LocalServer) initServerOpts(natsConfigPath string, dataDir string) erro
 		opts.StoreDir = dataDir
 	}
 
+	if l.inProcess {
+		opts.DontListen = true
+	}
+
 	l.ServerOpts = opts
 	return nil
 }
@@ -51,18 +51,34 @@ func TestLocalServerAuthUrl(t *testing.T) {
 	}
 }
 
+func TestInProcessServerConnect(t *testing.T) {
+	natsLogger := logs.NewNatsZeroLogger(logs.NoOpLogger())
+	localNats, err := NewInProcessServer("./natstest/nats.conf", t.TempDir(), false, &natsLogger)
+	require.NoError(t, err, "In-process NATS server should start without errors")
+	defer localNats.Close()
+
+	assert.Nil(t, localNats.NatsServer.Addr(), "In-process server shouldn't listen on the network")
+
+	nc, err := localNats.Connect("")
+	if assert.NotNil(t, nc) {
+		defer nc.Drain()
+	}
+	require.NoError(t, err, "Local NATS client should connect in-process without errors")
+	assert.True(t, nc.IsConnected(), "Local NATS client connection should be active")
+}
+
 func TestLocalServerClose(t *testing.T) {
 	t.Skip("Not implemented: Ensure calling close shuts down the server")
 }
 
 // setupLocalNatsServer is a test helper to create a local NATS server with a silent logger
-func setupLocalNatsServer(t *testing.T) *LocalServer {
+func setupLocalNatsServer(t testing.TB) *LocalServer {
 	natsDir := t.TempDir()
 	// Create no-op logger
 	logger := logs.NoOpLogger()
 	natsLogger := logs.NewNatsZeroLogger(logger)
 
-	localNats, err := NewLocalServer("./testdata/hub-nats.conf", natsDir, false, &natsLogger)
+	localNats, err := NewLocalServer("./natstest/nats.conf", natsDir, false, &natsLogger)
 	require.NoError(t, err, "Test setup: Embedded NATS server should start without errors")
 
 	return localNats
@@ -2,6 +2,7 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -11,12 +12,13 @@ import (
 
 type (
 	AppWorker struct {
-		ackWait    time.Duration
-		appName    string
-		handlers   Handlers
-		logger     Logger
-		natsClient *nats.Client
-		workChan   chan requestMsg
+		ackWait     time.Duration
+		appName     string
+		handlers    Handlers
+		logger      Logger
+		natsClient  *nats.Client
+		resultStore ResultStore
+		workChan    chan requestMsg
 	}
 
 	HandlerFunc func([]byte, *nats.MsgMeta) (Executor, error)
@@ -26,10 +28,10 @@ type (
 	Handlers map[string]HandlerFunc
 
 	requestMsg struct {
-		executor        Executor
-		msg             jetstream.Msg
-		responseSubject string
-		startedAt       time.Time
+		executor  Executor
+		msg       jetstream.Msg
+		parsedMsg *nats.MsgMeta
+		startedAt time.Time
 	}
 )
 
@@ -46,6 +48,15 @@ func NewAppWorker(appName string, handlers Handlers, bufferSize int, natsClient
 	return a
 }
 
+// SetResultStore enables storing the result of each handled request, so recent
+// results can be queried with the store's Recent method
+//
+// Results are stored once sent, including failures. Failing to store a result is
+// logged but doesn't affect the request. Should be called before Run.
+func (a *AppWorker) SetResultStore(store ResultStore) {
+	a.resultStore = store
+}
+
 func (a *AppWorker) Run(ctx context.Context) {
 	go a.listenForRequests(ctx)
 	go a.processWork(ctx)
@@ -60,17 +71,17 @@ func (a *AppWorker) listenForRequests(ctx context.Context) {
 		subject := msg.Subject()
 		a.logger.Infof("Received request %s", subject)
 
+		// Without a parsed subject there's nowhere to send a result, so we can
+		// only terminate or retry the message
 		parsedMsg, err := nats.Parse(msg)
+		if errors.Is(err, nats.ErrMalformedSubject) {
+			a.logger.Errf(err, "Unable to handle request message: %s", subject)
+			msg.Term()
+			return
+		}
 		if err != nil {
 			a.logger.Errf(err, "Unable to handle request message: %s", subject)
-			a.natsClient.PublishResultWithAck(
-				ctx,
-				msg,
-				startedAt,
-				nil,
-				err,
-				parsedMsg.ResponseSubject(),
-			)
+			msg.Nak()
 			return
 		}
 
@@ -80,14 +91,7 @@ func (a *AppWorker) listenForRequests(ctx context.Context) {
 			handlerErr := fmt.Errorf("Unknown handler call '%s' in msg '%s'", parsedMsg.HandlerName, subject)
 			a.logger.Errf(handlerErr, "Failed to handle request")
 
-			a.natsClient.PublishResultWithAck(
-				ctx,
-				msg,
-				startedAt,
-				nil,
-				handlerErr,
-				parsedMsg.ResponseSubject(),
-			)
+			a.sendResult(ctx, msg, parsedMsg, startedAt, nil, handlerErr)
 			return
 		}
 
@@ -95,22 +99,15 @@ func (a *AppWorker) listenForRequests(ctx context.Context) {
 		executor, err := handler(msg.Data(), parsedMsg)
 		if err != nil {
 			a.logger.Errf(err, "Failed to parse request")
-			a.natsClient.PublishResultWithAck(
-				ctx,
-				msg,
-				startedAt,
-				nil,
-				err,
-				parsedMsg.ResponseSubject(),
-			)
+			a.sendResult(ctx, msg, parsedMsg, startedAt, nil, err)
 			return
 		}
 
 		request := requestMsg{
-			msg:             msg,
-			startedAt:       startedAt,
-			executor:        executor,
-			responseSubject: parsedMsg.ResponseSubject(),
+			msg:       msg,
+			parsedMsg: parsedMsg,
+			startedAt: startedAt,
+			executor:  executor,
 		}
 
 		a.workChan <- request
@@ -155,7 +152,8 @@ func (a *AppWorker) executeRequest(ctx context.Context, request requestMsg) {
 
 	// Execute the actual request handling code
 	go func() {
-		result, err := request.executor(ctx)
+		executorCtx := contextWithProgress(ctx, a.natsClient, request.parsedMsg.ResponseSubject())
+		result, err := request.executor(executorCtx)
 		if err != nil {
 			errChan <- err
 		}
@@ -178,25 +176,15 @@ runRequest:
 			}
 
 		case result = <-resultChan:
-			_, responseErr = a.natsClient.PublishResultWithAck(
-				ctx,
-				request.msg,
-				request.startedAt,
-				result,
-				nil,
-				request.responseSubject,
-			)
+			responseErr = a.sendResult(ctx, request.msg, request.parsedMsg, request.startedAt, result, nil)
+			// The caller still needs to know the request failed if the output can't be sent
+			if errors.Is(responseErr, nats.ErrResultTooLarge) {
+				responseErr = a.sendResult(ctx, request.msg, request.parsedMsg, request.startedAt, nil, responseErr)
+			}
 			break runRequest
 
 		case err = <-errChan:
-			_, responseErr = a.natsClient.PublishResultWithAck(
-				ctx,
-				request.msg,
-				request.startedAt,
-				nil,
-				err,
-				request.responseSubject,
-			)
+			responseErr = a.sendResult(ctx, request.msg, request.parsedMsg, request.startedAt, nil, err)
 			break runRequest
 		}
 	}
@@ -205,3 +193,21 @@ runRequest:
 		a.logger.Warnf("Failed to send result: %s", responseErr.Error())
 	}
 }
+
+// sendResult sends the result of a request and acks it, then adds the result to
+// the result store, if set
+func (a *AppWorker) sendResult(ctx context.Context, msg jetstream.Msg, parsedMsg *nats.MsgMeta, startedAt time.Time, result interface{}, err error) error {
+	resultMsg := nats.NewResultMsg(startedAt, result, err)
+
+	sent, err := a.natsClient.PublishResultWithAck(ctx, msg, startedAt, resultMsg, nil, parsedMsg.ResponseSubject())
+	if !sent || a.resultStore == nil {
+		return err
+	}
+
+	storeErr := a.resultStore.Put(ctx, nats.NewResultRecord(parsedMsg, resultMsg))
+	if storeErr != nil {
+		a.logger.Errf(storeErr, "Unable to store result for request: %s", msg.Subject())
+	}
+
+	return err
+}
@@ -0,0 +1,69 @@
+package worker
+
+import (
+	"time"
+)
+
+const (
+	// Delay before the first retry of a failed request, doubling for each subsequent delivery
+	retryBaseDelay = time.Second
+	// Longest delay between retries of a failed request
+	retryMaxDelay = time.Minute
+)
+
+type (
+	// FatalError marks a handler error as permanent, e.g. a 404 from an API
+	//
+	// The request receives a failure result and is terminated without being retried,
+	// regardless of the worker's max deliveries.
+	FatalError struct {
+		Err error
+	}
+
+	// RetryableError marks a handler error as temporary, e.g. a 429 from an API
+	//
+	// The request is nak'd with an exponential backoff and no result is sent until it
+	// succeeds, fails with another kind of error, or exhausts the worker's max deliveries.
+	RetryableError struct {
+		Err error
+	}
+)
+
+// Fatal wraps err as a FatalError
+func Fatal(err error) error {
+	return &FatalError{Err: err}
+}
+
+// Retryable wraps err as a RetryableError
+func Retryable(err error) error {
+	return &RetryableError{Err: err}
+}
+
+func (f *FatalError) Error() string {
+	return f.Err.Error()
+}
+
+func (f *FatalError) Unwrap() error {
+	return f.Err
+}
+
+func (r *RetryableError) Error() string {
+	return r.Err.Error()
+}
+
+func (r *RetryableError) Unwrap() error {
+	return r.Err
+}
+
+// retryDelay returns the backoff before redelivering a request that has been delivered numDelivered times
+func retryDelay(numDelivered uint64) time.Duration {
+	delay := retryBaseDelay
+	for i := uint64(1); i < numDelivered; i++ {
+		delay *= 2
+		if delay >= retryMaxDelay {
+			return retryMaxDelay
+		}
+	}
+
+	return delay
+}
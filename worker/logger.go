@@ -1,27 +1,131 @@
 package worker
 
-type Logger interface {
-	// Log a debug statement
-	Debugf(format string, v ...interface{})
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
 
-	// Log an error with exact error
-	Errf(err error, format string, v ...interface{})
+	"github.com/hiphops-io/hops/logs"
+	"github.com/hiphops-io/hops/nats"
+)
 
-	// Log an error
-	Errorf(format string, v ...interface{})
+type (
+	Logger interface {
+		// Log a debug statement
+		Debugf(format string, v ...interface{})
 
-	// Log a fatal error
-	Fatalf(format string, v ...interface{})
+		// Log an error with exact error
+		Errf(err error, format string, v ...interface{})
 
-	// Log an info statement
-	Infof(format string, v ...interface{})
+		// Log an error
+		Errorf(format string, v ...interface{})
 
-	// Log a notice statement
-	Noticef(format string, v ...interface{})
+		// Log a fatal error
+		Fatalf(format string, v ...interface{})
 
-	// Log a trace statement
-	Tracef(format string, v ...interface{})
+		// Log an info statement
+		Infof(format string, v ...interface{})
 
-	// Log a warning statement
-	Warnf(format string, v ...interface{})
+		// Log a notice statement
+		Noticef(format string, v ...interface{})
+
+		// Log a trace statement
+		Tracef(format string, v ...interface{})
+
+		// Log a warning statement
+		Warnf(format string, v ...interface{})
+	}
+
+	// fieldLogger appends fields to the messages of a Logger that doesn't support
+	// structured fields
+	fieldLogger struct {
+		Logger
+		suffix string
+	}
+
+	loggerCtxKey struct{}
+)
+
+// LoggerFromContext returns the logger scoped to the request being handled, if any
+//
+// Workers add a logger carrying the request's sequence_id, message_id, app, handler
+// and num_delivered (and request_id, if the call has one) to the context given to handlers.
+func LoggerFromContext(ctx context.Context) (Logger, bool) {
+	logger, ok := ctx.Value(loggerCtxKey{}).(Logger)
+	return logger, ok
+}
+
+func (f *fieldLogger) Debugf(format string, v ...interface{}) {
+	f.Logger.Debugf(format+f.suffix, v...)
+}
+
+func (f *fieldLogger) Errf(err error, format string, v ...interface{}) {
+	f.Logger.Errf(err, format+f.suffix, v...)
+}
+
+func (f *fieldLogger) Errorf(format string, v ...interface{}) {
+	f.Logger.Errorf(format+f.suffix, v...)
+}
+
+func (f *fieldLogger) Fatalf(format string, v ...interface{}) {
+	f.Logger.Fatalf(format+f.suffix, v...)
+}
+
+func (f *fieldLogger) Infof(format string, v ...interface{}) {
+	f.Logger.Infof(format+f.suffix, v...)
+}
+
+func (f *fieldLogger) Noticef(format string, v ...interface{}) {
+	f.Logger.Noticef(format+f.suffix, v...)
+}
+
+func (f *fieldLogger) Tracef(format string, v ...interface{}) {
+	f.Logger.Tracef(format+f.suffix, v...)
+}
+
+func (f *fieldLogger) Warnf(format string, v ...interface{}) {
+	f.Logger.Warnf(format+f.suffix, v...)
+}
+
+// contextWithLogger returns a copy of ctx carrying a logger scoped to a request
+func contextWithLogger(ctx context.Context, logger Logger) context.Context {
+	return context.WithValue(ctx, loggerCtxKey{}, logger)
+}
+
+// newMsgLogger returns a child of logger carrying the correlation fields of a request
+//
+// Fields are added as structured fields for zerolog based loggers, otherwise they
+// are appended to each message.
+func newMsgLogger(logger Logger, parsedMsg *nats.MsgMeta) Logger {
+	fields := map[string]interface{}{
+		"app":           parsedMsg.AppName,
+		"handler":       parsedMsg.HandlerName,
+		"message_id":    parsedMsg.MessageId,
+		"num_delivered": parsedMsg.NumDelivered,
+		"sequence_id":   parsedMsg.SequenceId,
+	}
+	if parsedMsg.Call.RequestId != "" {
+		fields["request_id"] = parsedMsg.Call.RequestId
+	}
+
+	if zeroLogger, ok := logger.(*logs.NatsZeroLogger); ok {
+		return zeroLogger.WithFields(fields)
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", key, fields[key]))
+	}
+
+	// Escaped, as the suffix becomes part of each format string
+	suffix := strings.ReplaceAll(" ["+strings.Join(pairs, " ")+"]", "%", "%%")
+
+	return &fieldLogger{Logger: logger, suffix: suffix}
 }
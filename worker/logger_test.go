@@ -0,0 +1,116 @@
+package worker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hiphops-io/hops/logs"
+	"github.com/hiphops-io/hops/nats"
+)
+
+// syncBuffer is a bytes.Buffer that is safe to write to from the worker's goroutines
+type syncBuffer struct {
+	buf bytes.Buffer
+	mu  sync.Mutex
+}
+
+func (s *syncBuffer) Bytes() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]byte{}, s.buf.Bytes()...)
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.buf.Write(p)
+}
+
+func TestWorkerScopedLogger(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	natsClient, _, cleanup := setupWorkerClient(t)
+	defer cleanup()
+
+	// The NATS test setup disables logging globally, so re-enable it for this test
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	defer zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	output := &syncBuffer{}
+	logger := logs.NewNatsZeroLogger(zerolog.New(output))
+
+	app := &testApp{
+		handlers: map[string]Handler{
+			"deploy": func(ctx context.Context, msg jetstream.Msg) error {
+				handlerLogger, ok := LoggerFromContext(ctx)
+				if assert.True(t, ok, "Handler context should carry a scoped logger") {
+					handlerLogger.Infof("Deploying")
+				}
+
+				meta, _ := nats.MsgMetaFromContext(ctx)
+				natsClient.PublishResult(ctx, time.Now(), "Deployed", nil, meta.ResponseSubject())
+				return nil
+			},
+		},
+	}
+
+	w, err := NewWorker(natsClient, app, &logger)
+	require.NoError(t, err, "Worker should initialise without error")
+
+	go w.Run(ctx)
+
+	_, _, err = natsClient.Publish(ctx, []byte(`{}`), nats.ChannelRequest, "SEQ_ID", "MSG_ID", testAppName, "deploy")
+	require.NoError(t, err, "Request should be published without error")
+
+	waitForResult(ctx, t, natsClient, "SEQ_ID", "MSG_ID")
+
+	// findLine returns the first log line with a message starting with prefix
+	findLine := func(prefix string) map[string]interface{} {
+		var line map[string]interface{}
+
+		require.Eventually(t, func() bool {
+			scanner := bufio.NewScanner(bytes.NewReader(output.Bytes()))
+			for scanner.Scan() {
+				candidate := map[string]interface{}{}
+				if json.Unmarshal(scanner.Bytes(), &candidate) != nil {
+					continue
+				}
+
+				message, _ := candidate["message"].(string)
+				if strings.HasPrefix(message, prefix) {
+					line = candidate
+					return true
+				}
+			}
+
+			return false
+		}, 5*time.Second, 50*time.Millisecond, "Log line '%s' should be written", prefix)
+
+		return line
+	}
+
+	handlerLine := findLine("Deploying")
+	workerLine := findLine("Request message acknowledged")
+
+	for _, line := range []map[string]interface{}{handlerLine, workerLine} {
+		assert.Equal(t, "SEQ_ID", line["sequence_id"])
+		assert.Equal(t, "MSG_ID", line["message_id"])
+		assert.Equal(t, testAppName, line["app"])
+		assert.Equal(t, "deploy", line["handler"])
+		assert.EqualValues(t, 1, line["num_delivered"])
+	}
+}
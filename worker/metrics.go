@@ -0,0 +1,202 @@
+package worker
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	OutcomeFailure = "failure"
+	OutcomeSuccess = "success"
+)
+
+// DefaultDurationBuckets are the histogram bucket upper bounds used by NewCounterMetrics
+// when none are given
+var DefaultDurationBuckets = []time.Duration{
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	30 * time.Second,
+	time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+}
+
+type (
+	// CounterMetrics is an in-memory Metrics implementation using atomic counters
+	//
+	// Snapshot can be used to expose the collected metrics however the host process likes.
+	CounterMetrics struct {
+		buckets  []time.Duration
+		handlers map[string]*handlerMetrics
+		mu       sync.RWMutex
+		queueAge *Histogram
+	}
+
+	// HandlerSnapshot is a point in time copy of the metrics for a single handler
+	HandlerSnapshot struct {
+		Durations HistogramSnapshot `json:"durations"`
+		Outcomes  map[string]uint64 `json:"outcomes"`
+	}
+
+	// Histogram counts durations into buckets, and is safe for concurrent use
+	//
+	// It backs CounterMetrics, and can be reused by other in-memory Metrics
+	// implementations, such as the runner's.
+	Histogram struct {
+		buckets []time.Duration
+		count   atomic.Uint64
+		counts  []atomic.Uint64
+		sum     atomic.Int64
+	}
+
+	// HistogramSnapshot is a point in time copy of a duration histogram
+	//
+	// Counts holds the number of observations falling into each bucket, with a
+	// final additional count for observations greater than the largest bucket.
+	HistogramSnapshot struct {
+		Buckets []time.Duration `json:"buckets"`
+		Count   uint64          `json:"count"`
+		Counts  []uint64        `json:"counts"`
+		Sum     time.Duration   `json:"sum"`
+	}
+
+	// Metrics receives observations about the work done by a Worker
+	Metrics interface {
+		// ObserveHandler is called after a handler completes with the outcome
+		// (OutcomeSuccess or OutcomeFailure)
+		ObserveHandler(name string, duration time.Duration, outcome string)
+
+		// ObserveQueueAge is called with the time a request spent in the stream
+		// before being received by the worker
+		ObserveQueueAge(age time.Duration)
+	}
+
+	// MetricsSnapshot is a point in time copy of all metrics held by CounterMetrics
+	MetricsSnapshot struct {
+		Handlers map[string]HandlerSnapshot `json:"handlers"`
+		QueueAge HistogramSnapshot          `json:"queue_age"`
+	}
+
+	handlerMetrics struct {
+		durations *Histogram
+		outcomes  sync.Map // map[string]*atomic.Uint64
+	}
+)
+
+// NewCounterMetrics creates a CounterMetrics with the given histogram bucket
+// upper bounds, or DefaultDurationBuckets if none are given
+func NewCounterMetrics(buckets ...time.Duration) *CounterMetrics {
+	if len(buckets) == 0 {
+		buckets = DefaultDurationBuckets
+	}
+
+	buckets = append([]time.Duration{}, buckets...)
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+
+	return &CounterMetrics{
+		buckets:  buckets,
+		handlers: map[string]*handlerMetrics{},
+		queueAge: NewHistogram(buckets),
+	}
+}
+
+func (c *CounterMetrics) ObserveHandler(name string, duration time.Duration, outcome string) {
+	handler := c.handler(name)
+	handler.durations.Observe(duration)
+
+	counter, _ := handler.outcomes.LoadOrStore(outcome, &atomic.Uint64{})
+	counter.(*atomic.Uint64).Add(1)
+}
+
+func (c *CounterMetrics) ObserveQueueAge(age time.Duration) {
+	c.queueAge.Observe(age)
+}
+
+// Snapshot returns a copy of the current metrics
+func (c *CounterMetrics) Snapshot() MetricsSnapshot {
+	snapshot := MetricsSnapshot{
+		Handlers: map[string]HandlerSnapshot{},
+		QueueAge: c.queueAge.Snapshot(),
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for name, handler := range c.handlers {
+		outcomes := map[string]uint64{}
+		handler.outcomes.Range(func(key, value any) bool {
+			outcomes[key.(string)] = value.(*atomic.Uint64).Load()
+			return true
+		})
+
+		snapshot.Handlers[name] = HandlerSnapshot{
+			Durations: handler.durations.Snapshot(),
+			Outcomes:  outcomes,
+		}
+	}
+
+	return snapshot
+}
+
+// handler returns the metrics for a handler, creating them if necessary
+func (c *CounterMetrics) handler(name string) *handlerMetrics {
+	c.mu.RLock()
+	handler, ok := c.handlers[name]
+	c.mu.RUnlock()
+
+	if ok {
+		return handler
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Another goroutine may have created the handler metrics whilst we waited for the lock
+	if handler, ok := c.handlers[name]; ok {
+		return handler
+	}
+
+	handler = &handlerMetrics{durations: NewHistogram(c.buckets)}
+	c.handlers[name] = handler
+
+	return handler
+}
+
+// NewHistogram creates a Histogram with the given bucket upper bounds, which
+// must be sorted in ascending order
+func NewHistogram(buckets []time.Duration) *Histogram {
+	return &Histogram{
+		buckets: buckets,
+		counts:  make([]atomic.Uint64, len(buckets)+1),
+	}
+}
+
+// Observe counts duration into the first bucket it doesn't exceed
+func (h *Histogram) Observe(duration time.Duration) {
+	idx := sort.Search(len(h.buckets), func(i int) bool {
+		return duration <= h.buckets[i]
+	})
+
+	h.counts[idx].Add(1)
+	h.count.Add(1)
+	h.sum.Add(int64(duration))
+}
+
+// Snapshot returns a copy of the current counts
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	counts := make([]uint64, len(h.counts))
+	for i := range h.counts {
+		counts[i] = h.counts[i].Load()
+	}
+
+	return HistogramSnapshot{
+		Buckets: h.buckets,
+		Count:   h.count.Load(),
+		Counts:  counts,
+		Sum:     time.Duration(h.sum.Load()),
+	}
+}
@@ -0,0 +1,84 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hiphops-io/hops/nats"
+)
+
+func TestCounterMetrics(t *testing.T) {
+	metrics := NewCounterMetrics(time.Second, time.Minute)
+
+	metrics.ObserveHandler("do", 10*time.Millisecond, OutcomeSuccess)
+	metrics.ObserveHandler("do", 2*time.Second, OutcomeSuccess)
+	metrics.ObserveHandler("do", 2*time.Hour, OutcomeFailure)
+	metrics.ObserveQueueAge(time.Second)
+
+	snapshot := metrics.Snapshot()
+
+	require.Contains(t, snapshot.Handlers, "do")
+	handler := snapshot.Handlers["do"]
+	assert.Equal(t, map[string]uint64{OutcomeSuccess: 2, OutcomeFailure: 1}, handler.Outcomes)
+	assert.Equal(t, uint64(3), handler.Durations.Count)
+	assert.Equal(t, []uint64{1, 1, 1}, handler.Durations.Counts, "Durations should be counted in the correct buckets")
+
+	assert.Equal(t, uint64(1), snapshot.QueueAge.Count)
+	assert.Equal(t, []uint64{1, 0, 0}, snapshot.QueueAge.Counts, "Bucket upper bounds should be inclusive")
+}
+
+func TestWorkerMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	natsClient, logger, cleanup := setupWorkerClient(t)
+	defer cleanup()
+
+	app := &testApp{
+		handlers: map[string]Handler{
+			"succeed": func(ctx context.Context, msg jetstream.Msg) error {
+				return nil
+			},
+			"fail": func(ctx context.Context, msg jetstream.Msg) error {
+				return errors.New("Failed")
+			},
+		},
+	}
+
+	w, err := NewWorker(natsClient, app, logger)
+	require.NoError(t, err, "Worker should initialise without error")
+
+	metrics := NewCounterMetrics()
+	w.SetMetrics(metrics)
+
+	go w.Run(ctx)
+
+	requests := []struct {
+		msgId   string
+		handler string
+	}{
+		{"ONE", "succeed"},
+		{"TWO", "fail"},
+		{"THREE", "succeed"},
+	}
+	for _, r := range requests {
+		_, _, err := natsClient.Publish(ctx, []byte(`{}`), nats.ChannelRequest, "SEQ_ID", r.msgId, testAppName, r.handler)
+		require.NoError(t, err, "Request should be published without error")
+	}
+
+	require.Eventually(t, func() bool {
+		return metrics.Snapshot().QueueAge.Count == uint64(len(requests))
+	}, 5*time.Second, 50*time.Millisecond, "All requests should be observed")
+
+	require.Eventually(t, func() bool {
+		snapshot := metrics.Snapshot()
+		return snapshot.Handlers["succeed"].Outcomes[OutcomeSuccess] == 2 &&
+			snapshot.Handlers["fail"].Outcomes[OutcomeFailure] == 1
+	}, 5*time.Second, 50*time.Millisecond, "Handler outcomes should be counted")
+}
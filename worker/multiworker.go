@@ -0,0 +1,127 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/hiphops-io/hops/nats"
+)
+
+type (
+	// MultiWorker serves several apps from a single process and NATS connection
+	//
+	// Each app is handled by its own Worker, consuming from the app's worker consumer,
+	// so requests are routed to an app by the app token of their subject. Handler
+	// names only need to be unique within an app.
+	MultiWorker struct {
+		appNames []string
+		logger   Logger
+		workers  map[string]*Worker
+	}
+)
+
+// NewMultiWorker creates a MultiWorker for the given apps
+//
+// The client must have a worker consumer for every app, created with nats.WithWorker.
+func NewMultiWorker(natsClient *nats.Client, logger Logger, apps ...App) (*MultiWorker, error) {
+	if len(apps) == 0 {
+		return nil, errors.New("At least one app is required")
+	}
+
+	m := &MultiWorker{
+		logger:  logger,
+		workers: map[string]*Worker{},
+	}
+
+	for _, app := range apps {
+		appName := app.AppName()
+
+		if _, ok := m.workers[appName]; ok {
+			return nil, fmt.Errorf("Duplicate app '%s'", appName)
+		}
+
+		if _, ok := natsClient.Consumers[appName]; !ok {
+			return nil, fmt.Errorf("No worker consumer for app '%s', the client must be created with nats.WithWorker(\"%s\")", appName, appName)
+		}
+
+		w, err := NewWorker(natsClient, app, logger)
+		if err != nil {
+			return nil, err
+		}
+
+		m.appNames = append(m.appNames, appName)
+		m.workers[appName] = w
+	}
+
+	return m, nil
+}
+
+// Run runs the workers for every app, blocking until the context is cancelled
+// or any worker fails
+//
+// All workers are stopped and their received requests allowed to finish before
+// Run returns.
+func (m *MultiWorker) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(m.appNames))
+
+	for i, appName := range m.appNames {
+		i, appName := i, appName
+		w := m.workers[appName]
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := w.Run(ctx)
+			if err != nil {
+				errs[i] = fmt.Errorf("Worker for app '%s' failed: %w", appName, err)
+				// Stop the remaining apps, rather than silently serving a subset
+				cancel()
+			}
+		}()
+	}
+
+	m.logger.Infof("Serving apps: %v", m.appNames)
+
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// Stop stops the workers for every app at once, waiting for their requests to
+// finish (see Worker.Stop) and returning their errors joined
+func (m *MultiWorker) Stop(ctx context.Context) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(m.appNames))
+
+	for i, appName := range m.appNames {
+		i, appName := i, appName
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := m.workers[appName].Stop(ctx)
+			if err != nil {
+				errs[i] = fmt.Errorf("Unable to stop worker for app '%s': %w", appName, err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// Worker returns the worker for an app, allowing it to be configured individually
+//
+// Returns nil if the app is not served by the MultiWorker.
+func (m *MultiWorker) Worker(appName string) *Worker {
+	return m.workers[appName]
+}
@@ -0,0 +1,93 @@
+package worker
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hiphops-io/hops/nats"
+)
+
+func TestMultiWorker(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	natsClient, logger, cleanup := setupWorkerClient(t, nats.WithWorker("alpha"), nats.WithWorker("beta"))
+	defer cleanup()
+
+	var alphaCalls, betaCalls atomic.Int32
+
+	// Both apps share a handler name, as routing is per app
+	alpha := &testApp{
+		name: "alpha",
+		handlers: map[string]Handler{
+			"do": func(ctx context.Context, msg jetstream.Msg) error {
+				alphaCalls.Add(1)
+				meta, _ := nats.MsgMetaFromContext(ctx)
+				natsClient.PublishResult(ctx, time.Now(), "alpha", nil, meta.ResponseSubject())
+				return nil
+			},
+		},
+	}
+	beta := &testApp{
+		name: "beta",
+		handlers: map[string]Handler{
+			"do": func(ctx context.Context, msg jetstream.Msg) error {
+				betaCalls.Add(1)
+				meta, _ := nats.MsgMetaFromContext(ctx)
+				natsClient.PublishResult(ctx, time.Now(), "beta", nil, meta.ResponseSubject())
+				return nil
+			},
+		},
+	}
+
+	m, err := NewMultiWorker(natsClient, logger, alpha, beta)
+	require.NoError(t, err, "MultiWorker should initialise without error")
+	require.NotNil(t, m.Worker("alpha"))
+	require.NotNil(t, m.Worker("beta"))
+
+	done := make(chan error)
+	workerCtx, stopWorker := context.WithCancel(ctx)
+	go func() {
+		done <- m.Run(workerCtx)
+	}()
+
+	_, _, err = natsClient.Publish(ctx, []byte(`{}`), nats.ChannelRequest, "SEQ_ID", "ALPHA_MSG", "alpha", "do")
+	require.NoError(t, err, "Request should be published without error")
+	_, _, err = natsClient.Publish(ctx, []byte(`{}`), nats.ChannelRequest, "SEQ_ID", "BETA_MSG", "beta", "do")
+	require.NoError(t, err, "Request should be published without error")
+
+	alphaResult := waitForResult(ctx, t, natsClient, "SEQ_ID", "ALPHA_MSG")
+	betaResult := waitForResult(ctx, t, natsClient, "SEQ_ID", "BETA_MSG")
+
+	assert.Equal(t, "alpha", alphaResult.Body)
+	assert.Equal(t, "beta", betaResult.Body)
+	assert.Equal(t, int32(1), alphaCalls.Load())
+	assert.Equal(t, int32(1), betaCalls.Load())
+
+	stopWorker()
+	assert.NoError(t, <-done, "MultiWorker should stop cleanly")
+}
+
+func TestMultiWorkerValidation(t *testing.T) {
+	natsClient, logger, cleanup := setupWorkerClient(t, nats.WithWorker("alpha"))
+	defer cleanup()
+
+	noop := func(ctx context.Context, msg jetstream.Msg) error { return nil }
+
+	_, err := NewMultiWorker(natsClient, logger)
+	assert.Error(t, err, "MultiWorker should require at least one app")
+
+	alpha := &testApp{name: "alpha", handlers: map[string]Handler{"do": noop}}
+	_, err = NewMultiWorker(natsClient, logger, alpha, alpha)
+	assert.Error(t, err, "MultiWorker should reject duplicate apps")
+
+	beta := &testApp{name: "beta", handlers: map[string]Handler{"do": noop}}
+	_, err = NewMultiWorker(natsClient, logger, alpha, beta)
+	assert.Error(t, err, "MultiWorker should reject apps without a worker consumer")
+}
@@ -0,0 +1,78 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/hiphops-io/hops/nats"
+)
+
+// progressInterval is the minimum time between progress reports sent for a request
+const progressInterval = time.Second
+
+type (
+	// ProgressReporter sends interim progress updates for the request being handled
+	//
+	// Reports sent within a second of the previous report are dropped, so handlers
+	// are free to report as often as is convenient.
+	ProgressReporter interface {
+		Report(percent int, message string) error
+	}
+
+	noopReporter struct{}
+
+	progressCtxKey struct{}
+
+	progressReporter struct {
+		lastReport      time.Time
+		mu              sync.Mutex
+		natsClient      *nats.Client
+		responseSubject string
+	}
+)
+
+// ProgressFromContext returns the ProgressReporter for the request being handled
+//
+// Outside of a handler a reporter that discards all reports is returned, so
+// the result can always be used.
+func ProgressFromContext(ctx context.Context) ProgressReporter {
+	reporter, ok := ctx.Value(progressCtxKey{}).(ProgressReporter)
+	if !ok {
+		return noopReporter{}
+	}
+
+	return reporter
+}
+
+func (noopReporter) Report(percent int, message string) error {
+	return nil
+}
+
+func (p *progressReporter) Report(percent int, message string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if time.Since(p.lastReport) < progressInterval {
+		return nil
+	}
+
+	progress := nats.NewProgressMsg(percent, message)
+	_, err := p.natsClient.PublishProgress(context.Background(), progress, p.responseSubject)
+	if err != nil {
+		return err
+	}
+
+	p.lastReport = progress.ReportedAt
+	return nil
+}
+
+// contextWithProgress returns a copy of ctx carrying a ProgressReporter for a request
+func contextWithProgress(ctx context.Context, natsClient *nats.Client, responseSubject string) context.Context {
+	reporter := &progressReporter{
+		natsClient:      natsClient,
+		responseSubject: responseSubject,
+	}
+
+	return context.WithValue(ctx, progressCtxKey{}, ProgressReporter(reporter))
+}
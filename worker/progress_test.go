@@ -0,0 +1,68 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hiphops-io/hops/nats"
+)
+
+func TestProgressReporter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	natsClient, logger, cleanup := setupWorkerClient(t)
+	defer cleanup()
+
+	sub, err := natsClient.NatsConn.SubscribeSync("*.*.notify.SEQ_ID.MSG_ID.progress.*")
+	require.NoError(t, err, "Test setup: Should subscribe to progress messages")
+
+	app := &testApp{
+		handlers: map[string]Handler{
+			"deploy": func(ctx context.Context, msg jetstream.Msg) error {
+				reporter := ProgressFromContext(ctx)
+				reporter.Report(25, "Quarter done")
+				reporter.Report(30, "Dropped by rate limit")
+
+				meta, _ := nats.MsgMetaFromContext(ctx)
+				natsClient.PublishResult(ctx, time.Now(), "Deployed", nil, meta.ResponseSubject())
+				return nil
+			},
+		},
+	}
+
+	w, err := NewWorker(natsClient, app, logger)
+	require.NoError(t, err, "Worker should initialise without error")
+
+	go w.Run(ctx)
+
+	_, _, err = natsClient.Publish(ctx, []byte(`{}`), nats.ChannelRequest, "SEQ_ID", "MSG_ID", testAppName, "deploy")
+	require.NoError(t, err, "Request should be published without error")
+
+	msg, err := sub.NextMsg(5 * time.Second)
+	require.NoError(t, err, "First progress report should be published")
+
+	progress := nats.ProgressMsg{}
+	err = json.Unmarshal(msg.Data, &progress)
+	require.NoError(t, err)
+	assert.Equal(t, nats.StatusProgress, progress.Status)
+	assert.Equal(t, 25, progress.Percent)
+	assert.Equal(t, "Quarter done", progress.Message)
+
+	_, err = sub.NextMsg(200 * time.Millisecond)
+	assert.Error(t, err, "Reports within the rate limit should be dropped")
+
+	result := waitForResult(ctx, t, natsClient, "SEQ_ID", "MSG_ID")
+	assert.Equal(t, "Deployed", result.Body, "Progress should not replace the result")
+}
+
+func TestProgressFromContextWithoutReporter(t *testing.T) {
+	reporter := ProgressFromContext(context.Background())
+	assert.NoError(t, reporter.Report(50, "Nowhere to go"), "Reports outside of a handler should be discarded")
+}
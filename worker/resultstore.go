@@ -0,0 +1,233 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	"github.com/hiphops-io/hops/nats"
+)
+
+// DefaultResultTable is the table SQLResultStore uses unless set with WithResultTable
+const DefaultResultTable = "hops_results"
+
+var tableNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+var _ ResultStore = (*nats.KVResultStore)(nil)
+var _ ResultStore = (*SQLResultStore)(nil)
+
+type (
+	// ResultStore persists the results sent by a worker, allowing recent results
+	// to be queried after they've aged out of the stream
+	//
+	// nats.KVResultStore and SQLResultStore are provided. Set on a worker with
+	// AppWorker.SetResultStore.
+	ResultStore interface {
+		// Put stores a record, replacing any previous record for the same call
+		Put(context.Context, nats.ResultRecord) error
+		// Recent returns the records matching the query, most recently finished first
+		Recent(context.Context, nats.ResultQuery) ([]nats.ResultRecord, error)
+	}
+
+	// SQLResultStore stores worker result history in a SQL database
+	//
+	// Any database/sql driver can be used, as long as the database supports
+	// CREATE TABLE IF NOT EXISTS and LIMIT. Queries use '?' placeholders unless
+	// created WithDollarPlaceholders (e.g. for Postgres).
+	SQLResultStore struct {
+		db                 *sql.DB
+		dollarPlaceholders bool
+		table              string
+	}
+
+	SQLResultStoreOpt func(*SQLResultStore)
+)
+
+// NewSQLResultStore returns a SQLResultStore using db, which the caller remains
+// responsible for closing. Call Init to create its table if necessary.
+func NewSQLResultStore(db *sql.DB, opts ...SQLResultStoreOpt) (*SQLResultStore, error) {
+	s := &SQLResultStore{
+		db:    db,
+		table: DefaultResultTable,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if !tableNameRegex.MatchString(s.table) {
+		return nil, fmt.Errorf("Invalid result table name '%s', must be alphanumeric with underscores", s.table)
+	}
+
+	return s, nil
+}
+
+// Init creates the store's table if it doesn't exist
+func (s *SQLResultStore) Init(ctx context.Context) error {
+	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	app VARCHAR(255) NOT NULL,
+	handler VARCHAR(255) NOT NULL,
+	sequence_id VARCHAR(255) NOT NULL,
+	call_id VARCHAR(255) NOT NULL,
+	finished_at BIGINT NOT NULL,
+	result TEXT NOT NULL,
+	PRIMARY KEY (app, handler, sequence_id, call_id)
+)`, s.table)
+
+	_, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("Unable to create result table: %w", err)
+	}
+
+	return nil
+}
+
+// Put stores a record, replacing any previous record for the same call
+func (s *SQLResultStore) Put(ctx context.Context, record nats.ResultRecord) error {
+	result, err := json.Marshal(record.Result)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Unable to store result: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Deleting then inserting replaces the record without relying on
+	// database specific upsert syntax
+	_, err = tx.ExecContext(
+		ctx,
+		s.rebind(fmt.Sprintf("DELETE FROM %s WHERE app = ? AND handler = ? AND sequence_id = ? AND call_id = ?", s.table)),
+		record.App, record.Handler, record.SequenceId, record.Call,
+	)
+	if err != nil {
+		return fmt.Errorf("Unable to store result: %w", err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		s.rebind(fmt.Sprintf("INSERT INTO %s (app, handler, sequence_id, call_id, finished_at, result) VALUES (?, ?, ?, ?, ?, ?)", s.table)),
+		record.App, record.Handler, record.SequenceId, record.Call, record.FinishedAt.UnixNano(), string(result),
+	)
+	if err != nil {
+		return fmt.Errorf("Unable to store result: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("Unable to store result: %w", err)
+	}
+
+	return nil
+}
+
+// Recent returns the records matching the query, most recently finished first
+func (s *SQLResultStore) Recent(ctx context.Context, query nats.ResultQuery) ([]nats.ResultRecord, error) {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = nats.DefaultResultQueryLimit
+	}
+
+	conditions := []string{}
+	args := []interface{}{}
+	filters := []struct{ column, value string }{
+		{column: "app", value: query.App},
+		{column: "handler", value: query.Handler},
+		{column: "sequence_id", value: query.SequenceId},
+	}
+	for _, filter := range filters {
+		if filter.value == "" {
+			continue
+		}
+
+		conditions = append(conditions, filter.column+" = ?")
+		args = append(args, filter.value)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, limit)
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		s.rebind(fmt.Sprintf("SELECT app, handler, sequence_id, call_id, finished_at, result FROM %s%s ORDER BY finished_at DESC LIMIT ?", s.table, where)),
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to query results: %w", err)
+	}
+	defer rows.Close()
+
+	records := []nats.ResultRecord{}
+	for rows.Next() {
+		record := nats.ResultRecord{}
+		var finishedAt int64
+		var result string
+
+		err := rows.Scan(&record.App, &record.Handler, &record.SequenceId, &record.Call, &finishedAt, &result)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read result: %w", err)
+		}
+
+		err = json.Unmarshal([]byte(result), &record.Result)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to decode result for call '%s': %w", record.Call, err)
+		}
+
+		record.FinishedAt = time.Unix(0, finishedAt).UTC()
+		records = append(records, record)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to query results: %w", err)
+	}
+
+	return records, nil
+}
+
+// rebind converts the '?' placeholders in a query to the store's placeholder style
+func (s *SQLResultStore) rebind(query string) string {
+	if !s.dollarPlaceholders {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r != '?' {
+			b.WriteRune(r)
+			continue
+		}
+
+		n++
+		b.WriteString("$" + strconv.Itoa(n))
+	}
+
+	return b.String()
+}
+
+// WithDollarPlaceholders uses numbered placeholders ($1, $2...) in queries, as
+// required by Postgres drivers
+func WithDollarPlaceholders() SQLResultStoreOpt {
+	return func(s *SQLResultStore) {
+		s.dollarPlaceholders = true
+	}
+}
+
+// WithResultTable sets the table results are stored in
+func WithResultTable(table string) SQLResultStoreOpt {
+	return func(s *SQLResultStore) {
+		s.table = table
+	}
+}
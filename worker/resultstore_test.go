@@ -0,0 +1,426 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hiphops-io/hops/nats"
+)
+
+func TestSQLResultStoreOpts(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      []SQLResultStoreOpt
+		query     string
+		expected  string
+		expectErr bool
+	}{
+		{
+			name:     "Default placeholders",
+			query:    "SELECT * FROM hops_results WHERE app = ? LIMIT ?",
+			expected: "SELECT * FROM hops_results WHERE app = ? LIMIT ?",
+		},
+		{
+			name:     "Dollar placeholders",
+			opts:     []SQLResultStoreOpt{WithDollarPlaceholders()},
+			query:    "SELECT * FROM hops_results WHERE app = ? AND handler = ? LIMIT ?",
+			expected: "SELECT * FROM hops_results WHERE app = $1 AND handler = $2 LIMIT $3",
+		},
+		{
+			name:     "Custom table",
+			opts:     []SQLResultStoreOpt{WithResultTable("worker_results")},
+			query:    "DELETE FROM worker_results WHERE app = ?",
+			expected: "DELETE FROM worker_results WHERE app = ?",
+		},
+		{
+			name:      "Invalid table",
+			opts:      []SQLResultStoreOpt{WithResultTable("results; DROP TABLE users")},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			store, err := NewSQLResultStore(nil, tc.opts...)
+			if tc.expectErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, store.rebind(tc.query))
+		})
+	}
+}
+
+func TestSQLResultStore(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []SQLResultStoreOpt
+	}{
+		{
+			name: "Default placeholders",
+		},
+		{
+			name: "Dollar placeholders",
+			opts: []SQLResultStoreOpt{WithDollarPlaceholders(), WithResultTable("worker_results")},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			testSQLResultStore(t, tc.opts...)
+		})
+	}
+}
+
+func testSQLResultStore(t *testing.T, opts ...SQLResultStoreOpt) {
+	ctx := context.Background()
+
+	db := openFakeResultDB(t)
+	store, err := NewSQLResultStore(db, opts...)
+	require.NoError(t, err, "Test setup: Result store should be created without error")
+
+	err = store.Put(ctx, nats.ResultRecord{App: "github", Handler: "merge_pr", SequenceId: "SEQ_ONE", Call: "call_one"})
+	assert.Error(t, err, "Results can't be stored before the table is created")
+
+	err = store.Init(ctx)
+	require.NoError(t, err, "Result table should be created without error")
+	err = store.Init(ctx)
+	require.NoError(t, err, "Creating an existing result table should be a no-op")
+
+	// Records are stored as results finish
+	now := time.Now().UTC()
+	records := []nats.ResultRecord{
+		{App: "slack", Handler: "post", SequenceId: "SEQ_ONE", Call: "call_three", FinishedAt: now.Add(-3 * time.Minute)},
+		{App: "github", Handler: "create_issue", SequenceId: "SEQ_ONE", Call: "call_one", FinishedAt: now.Add(-2 * time.Minute)},
+		{App: "github", Handler: "create_issue", SequenceId: "SEQ_TWO", Call: "call_one", FinishedAt: now.Add(-time.Minute)},
+		{App: "github", Handler: "merge_pr", SequenceId: "SEQ_ONE", Call: "call_two", FinishedAt: now},
+	}
+	for _, record := range records {
+		record.Result = nats.ResultMsg{Body: record.Call, Completed: true, Done: true}
+		err := store.Put(ctx, record)
+		require.NoError(t, err, "Test setup: Result should be stored without error")
+	}
+
+	// Storing a call again replaces its record
+	replaced := records[1]
+	replaced.FinishedAt = now.Add(time.Minute)
+	replaced.Result = nats.ResultMsg{Body: "replaced", Errored: true, Done: true}
+	err = store.Put(ctx, replaced)
+	require.NoError(t, err, "Test setup: Result should be replaced without error")
+
+	tests := []struct {
+		name      string
+		query     nats.ResultQuery
+		wantCalls []string
+	}{
+		{
+			name:      "All results, most recent first",
+			query:     nats.ResultQuery{},
+			wantCalls: []string{"call_one", "call_two", "call_one", "call_three"},
+		},
+		{
+			name:      "By app",
+			query:     nats.ResultQuery{App: "github"},
+			wantCalls: []string{"call_one", "call_two", "call_one"},
+		},
+		{
+			name:      "By handler",
+			query:     nats.ResultQuery{App: "github", Handler: "create_issue"},
+			wantCalls: []string{"call_one", "call_one"},
+		},
+		{
+			name:      "By sequence",
+			query:     nats.ResultQuery{SequenceId: "SEQ_ONE"},
+			wantCalls: []string{"call_one", "call_two", "call_three"},
+		},
+		{
+			name:      "With limit",
+			query:     nats.ResultQuery{Limit: 2},
+			wantCalls: []string{"call_one", "call_two"},
+		},
+		{
+			name:      "No matches",
+			query:     nats.ResultQuery{App: "jira"},
+			wantCalls: []string{},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := store.Recent(ctx, tc.query)
+			require.NoError(t, err, "Results should be queried without error")
+
+			calls := []string{}
+			for _, record := range got {
+				calls = append(calls, record.Call)
+			}
+			assert.Equal(t, tc.wantCalls, calls)
+		})
+	}
+
+	got, err := store.Recent(ctx, nats.ResultQuery{SequenceId: "SEQ_ONE", Handler: "create_issue"})
+	require.NoError(t, err, "Results should be queried without error")
+	require.Len(t, got, 1)
+	assert.Equal(t, "replaced", got[0].Result.Body, "Latest result for a call should be returned")
+	assert.True(t, got[0].Result.Errored)
+	assert.Equal(t, replaced.FinishedAt, got[0].FinishedAt, "Finish time should be stored to the nanosecond")
+}
+
+// fakeResultDriver is a database/sql driver holding tables in memory, which
+// understands just the statements SQLResultStore makes
+//
+// Each DSN opened is a separate database, shared by its connections.
+type fakeResultDriver struct {
+	mu  sync.Mutex
+	dbs map[string]*fakeResultDB
+}
+
+type fakeResultDB struct {
+	mu     sync.Mutex
+	tables map[string][]map[string]driver.Value
+}
+
+type fakeResultConn struct {
+	db *fakeResultDB
+}
+
+type fakeResultStmt struct {
+	db    *fakeResultDB
+	query string
+}
+
+type fakeResultRows struct {
+	columns []string
+	rows    []map[string]driver.Value
+}
+
+var (
+	fakeResults     = &fakeResultDriver{dbs: map[string]*fakeResultDB{}}
+	fakeResultsOnce sync.Once
+
+	createTableRegex = regexp.MustCompile(`^CREATE TABLE IF NOT EXISTS (\w+) `)
+	deleteRegex      = regexp.MustCompile(`^DELETE FROM (\w+)(?: WHERE (.+))?$`)
+	insertRegex      = regexp.MustCompile(`^INSERT INTO (\w+) \(([^)]+)\) VALUES \(([^)]+)\)$`)
+	selectRegex      = regexp.MustCompile(`^SELECT (.+) FROM (\w+)(?: WHERE (.+))? ORDER BY (\w+) DESC LIMIT (?:\?|\$\d+)$`)
+	conditionRegex   = regexp.MustCompile(`^(\w+) = (?:\?|\$\d+)$`)
+)
+
+func openFakeResultDB(t *testing.T) *sql.DB {
+	fakeResultsOnce.Do(func() {
+		sql.Register("fakeresults", fakeResults)
+	})
+
+	db, err := sql.Open("fakeresults", t.Name())
+	require.NoError(t, err, "Test setup: Fake database should open")
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func (d *fakeResultDriver) Open(name string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.dbs[name] == nil {
+		d.dbs[name] = &fakeResultDB{tables: map[string][]map[string]driver.Value{}}
+	}
+
+	return &fakeResultConn{db: d.dbs[name]}, nil
+}
+
+func (c *fakeResultConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeResultStmt{db: c.db, query: strings.Join(strings.Fields(query), " ")}, nil
+}
+
+func (c *fakeResultConn) Close() error {
+	return nil
+}
+
+// Begin starts a transaction, though statements aren't isolated or rolled back
+func (c *fakeResultConn) Begin() (driver.Tx, error) {
+	return c, nil
+}
+
+func (c *fakeResultConn) Commit() error {
+	return nil
+}
+
+func (c *fakeResultConn) Rollback() error {
+	return nil
+}
+
+func (s *fakeResultStmt) Close() error {
+	return nil
+}
+
+func (s *fakeResultStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeResultStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if match := createTableRegex.FindStringSubmatch(s.query); match != nil {
+		if _, ok := s.db.tables[match[1]]; !ok {
+			s.db.tables[match[1]] = []map[string]driver.Value{}
+		}
+		return driver.RowsAffected(0), nil
+	}
+
+	if match := deleteRegex.FindStringSubmatch(s.query); match != nil {
+		rows, ok := s.db.tables[match[1]]
+		if !ok {
+			return nil, fmt.Errorf("No such table: %s", match[1])
+		}
+
+		matches, err := rowMatcher(match[2], args)
+		if err != nil {
+			return nil, err
+		}
+
+		kept := []map[string]driver.Value{}
+		for _, row := range rows {
+			if !matches(row) {
+				kept = append(kept, row)
+			}
+		}
+		s.db.tables[match[1]] = kept
+
+		return driver.RowsAffected(len(rows) - len(kept)), nil
+	}
+
+	if match := insertRegex.FindStringSubmatch(s.query); match != nil {
+		rows, ok := s.db.tables[match[1]]
+		if !ok {
+			return nil, fmt.Errorf("No such table: %s", match[1])
+		}
+
+		columns := strings.Split(match[2], ", ")
+		if len(columns) != len(args) {
+			return nil, fmt.Errorf("Expected %d values, got %d", len(columns), len(args))
+		}
+
+		row := map[string]driver.Value{}
+		for i, column := range columns {
+			row[column] = args[i]
+		}
+		s.db.tables[match[1]] = append(rows, row)
+
+		return driver.RowsAffected(1), nil
+	}
+
+	return nil, fmt.Errorf("Unsupported statement: %s", s.query)
+}
+
+func (s *fakeResultStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	match := selectRegex.FindStringSubmatch(s.query)
+	if match == nil {
+		return nil, fmt.Errorf("Unsupported query: %s", s.query)
+	}
+
+	rows, ok := s.db.tables[match[2]]
+	if !ok {
+		return nil, fmt.Errorf("No such table: %s", match[2])
+	}
+
+	if len(args) == 0 {
+		return nil, errors.New("Expected a limit")
+	}
+	limit, ok := args[len(args)-1].(int64)
+	if !ok {
+		return nil, fmt.Errorf("Invalid limit %v", args[len(args)-1])
+	}
+
+	matches, err := rowMatcher(match[3], args[:len(args)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	selected := []map[string]driver.Value{}
+	for _, row := range rows {
+		if matches(row) {
+			selected = append(selected, row)
+		}
+	}
+
+	orderBy := match[4]
+	sort.SliceStable(selected, func(i, j int) bool {
+		return selected[i][orderBy].(int64) > selected[j][orderBy].(int64)
+	})
+	if int64(len(selected)) > limit {
+		selected = selected[:limit]
+	}
+
+	return &fakeResultRows{columns: strings.Split(match[1], ", "), rows: selected}, nil
+}
+
+func (r *fakeResultRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeResultRows) Close() error {
+	return nil
+}
+
+func (r *fakeResultRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+
+	for i, column := range r.columns {
+		dest[i] = r.rows[0][column]
+	}
+	r.rows = r.rows[1:]
+
+	return nil
+}
+
+// rowMatcher returns whether rows match a WHERE clause of equality conditions
+// joined by AND, given the clause's args
+func rowMatcher(where string, args []driver.Value) (func(map[string]driver.Value) bool, error) {
+	if where == "" {
+		return func(map[string]driver.Value) bool { return true }, nil
+	}
+
+	conditions := strings.Split(where, " AND ")
+	if len(conditions) != len(args) {
+		return nil, fmt.Errorf("Expected %d args, got %d", len(conditions), len(args))
+	}
+
+	columns := []string{}
+	for _, condition := range conditions {
+		match := conditionRegex.FindStringSubmatch(condition)
+		if match == nil {
+			return nil, fmt.Errorf("Unsupported condition: %s", condition)
+		}
+		columns = append(columns, match[1])
+	}
+
+	return func(row map[string]driver.Value) bool {
+		for i, column := range columns {
+			if row[column] != args[i] {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
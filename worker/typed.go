@@ -0,0 +1,63 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/goccy/go-json"
+
+	"github.com/hiphops-io/hops/nats"
+)
+
+type (
+	// TypedFunc is a handler that receives its request payload decoded into T
+	TypedFunc[T any] func(context.Context, T) (interface{}, error)
+
+	// TypedOpt functions configure how a typed handler decodes its input
+	TypedOpt func(*typedConfig)
+
+	typedConfig struct {
+		disallowUnknownFields bool
+	}
+)
+
+// Typed creates a HandlerFunc that decodes the request payload into T before
+// calling fn, with the value returned by fn used as the success result.
+//
+// Payloads that cannot be decoded fail immediately with an error result, as
+// there is no point retrying a malformed request.
+func Typed[T any](fn TypedFunc[T], opts ...TypedOpt) HandlerFunc {
+	conf := &typedConfig{}
+	for _, opt := range opts {
+		opt(conf)
+	}
+
+	return func(data []byte, msgMeta *nats.MsgMeta) (Executor, error) {
+		var input T
+
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		if conf.disallowUnknownFields {
+			decoder.DisallowUnknownFields()
+		}
+
+		err := decoder.Decode(&input)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to decode input: %w", err)
+		}
+
+		executor := func(ctx context.Context) (interface{}, error) {
+			return fn(ctx, input)
+		}
+
+		return executor, nil
+	}
+}
+
+// WithStrictDecoding makes a typed handler reject payloads containing fields
+// that are not present on the input type
+func WithStrictDecoding() TypedOpt {
+	return func(c *typedConfig) {
+		c.disallowUnknownFields = true
+	}
+}
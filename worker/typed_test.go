@@ -0,0 +1,202 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hiphops-io/hops/nats"
+)
+
+type (
+	addInput struct {
+		A int `json:"a"`
+		B int `json:"b"`
+	}
+
+	addOutput struct {
+		Sum int `json:"sum"`
+	}
+
+	greetInput struct {
+		Name string `json:"name"`
+	}
+
+	// calculatorApp is an example app made up of typed handlers
+	calculatorApp struct{}
+)
+
+func (c *calculatorApp) Handlers() Handlers {
+	return Handlers{
+		"add":   Typed(c.add),
+		"greet": Typed(c.greet, WithStrictDecoding()),
+	}
+}
+
+func (c *calculatorApp) add(ctx context.Context, input addInput) (interface{}, error) {
+	return addOutput{Sum: input.A + input.B}, nil
+}
+
+func (c *calculatorApp) greet(ctx context.Context, input greetInput) (interface{}, error) {
+	if input.Name == "" {
+		return nil, fmt.Errorf("Name is required")
+	}
+
+	return fmt.Sprintf("Hello, %s", input.Name), nil
+}
+
+func TestTyped(t *testing.T) {
+	type testCase struct {
+		name           string
+		handler        string
+		payload        string
+		expectedResult interface{}
+		expectDecodeOk bool
+		expectErr      bool
+	}
+
+	tests := []testCase{
+		{
+			name:           "Valid input",
+			handler:        "add",
+			payload:        `{"a": 1, "b": 2}`,
+			expectedResult: addOutput{Sum: 3},
+			expectDecodeOk: true,
+		},
+		{
+			name:           "Unknown fields are ignored by default",
+			handler:        "add",
+			payload:        `{"a": 1, "b": 2, "c": 3}`,
+			expectedResult: addOutput{Sum: 3},
+			expectDecodeOk: true,
+		},
+		{
+			name:           "Malformed JSON",
+			handler:        "add",
+			payload:        `{"a": 1,`,
+			expectDecodeOk: false,
+		},
+		{
+			name:           "Wrong input types",
+			handler:        "add",
+			payload:        `{"a": "one"}`,
+			expectDecodeOk: false,
+		},
+		{
+			name:           "Unknown fields rejected when strict",
+			handler:        "greet",
+			payload:        `{"name": "Casey", "age": 3}`,
+			expectDecodeOk: false,
+		},
+		{
+			name:           "Valid input when strict",
+			handler:        "greet",
+			payload:        `{"name": "Casey"}`,
+			expectedResult: "Hello, Casey",
+			expectDecodeOk: true,
+		},
+		{
+			name:           "Handler error",
+			handler:        "greet",
+			payload:        `{}`,
+			expectDecodeOk: true,
+			expectErr:      true,
+		},
+	}
+
+	app := &calculatorApp{}
+	handlers := app.Handlers()
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			executor, err := handlers[tc.handler]([]byte(tc.payload), nil)
+			if !tc.expectDecodeOk {
+				assert.Error(t, err, "Invalid payload should fail to decode")
+				return
+			}
+			require.NoError(t, err, "Valid payload should decode without error")
+
+			result, err := executor(context.Background())
+			if tc.expectErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedResult, result)
+		})
+	}
+}
+
+func TestTypedAppWorker(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	natsClient, logger, cleanup := setupWorkerClient(t)
+	defer cleanup()
+
+	app := &calculatorApp{}
+	appWorker := NewAppWorker(testAppName, app.Handlers(), 10, natsClient, logger)
+	go appWorker.Run(ctx)
+
+	_, _, err := natsClient.Publish(ctx, []byte(`{"a": 2, "b": 3}`), nats.ChannelRequest, "SEQ_ID", "ADD_ID", testAppName, "add")
+	require.NoError(t, err, "Request should be published without error")
+
+	result := waitForResult(ctx, t, natsClient, "SEQ_ID", "ADD_ID")
+	assert.True(t, result.Completed, "Typed handler should produce a success result")
+	assert.Equal(t, map[string]interface{}{"sum": float64(5)}, result.JSON)
+
+	_, _, err = natsClient.Publish(ctx, []byte(`not json`), nats.ChannelRequest, "SEQ_ID", "GREET_ID", testAppName, "greet")
+	require.NoError(t, err, "Request should be published without error")
+
+	result = waitForResult(ctx, t, natsClient, "SEQ_ID", "GREET_ID")
+	assert.True(t, result.Errored, "Malformed payload should produce a failure result")
+	assert.Contains(t, result.Hops.Error, "Unable to decode input")
+}
+
+func TestAppWorkerResultStore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	natsClient, logger, cleanup := setupWorkerClient(t)
+	defer cleanup()
+
+	store, err := nats.NewKVResultStore(natsClient, time.Minute)
+	require.NoError(t, err, "Test setup: Result store should initialise without error")
+
+	app := &calculatorApp{}
+	appWorker := NewAppWorker(testAppName, app.Handlers(), 10, natsClient, logger)
+	appWorker.SetResultStore(store)
+	go appWorker.Run(ctx)
+
+	_, _, err = natsClient.Publish(ctx, []byte(`{"a": 2, "b": 3}`), nats.ChannelRequest, "SEQ_ID", "ADD_ID", testAppName, "add")
+	require.NoError(t, err, "Request should be published without error")
+	_, _, err = natsClient.Publish(ctx, []byte(`not json`), nats.ChannelRequest, "SEQ_ID", "GREET_ID", testAppName, "greet")
+	require.NoError(t, err, "Request should be published without error")
+
+	waitForResult(ctx, t, natsClient, "SEQ_ID", "ADD_ID")
+	waitForResult(ctx, t, natsClient, "SEQ_ID", "GREET_ID")
+
+	var records []nats.ResultRecord
+	require.Eventually(t, func() bool {
+		records, err = store.Recent(ctx, nats.ResultQuery{App: testAppName, SequenceId: "SEQ_ID"})
+		return err == nil && len(records) == 2
+	}, 5*time.Second, 10*time.Millisecond, "Successful and failed results should be stored")
+
+	byCall := map[string]nats.ResultRecord{}
+	for _, record := range records {
+		byCall[record.Call] = record
+	}
+
+	assert.Equal(t, "add", byCall["ADD_ID"].Handler)
+	assert.Equal(t, map[string]interface{}{"sum": float64(5)}, byCall["ADD_ID"].Result.JSON)
+	assert.False(t, byCall["ADD_ID"].Result.Errored)
+
+	assert.Equal(t, "greet", byCall["GREET_ID"].Handler)
+	assert.True(t, byCall["GREET_ID"].Result.Errored)
+	assert.Contains(t, byCall["GREET_ID"].Result.Hops.Error, "Unable to decode input")
+}
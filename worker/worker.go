@@ -5,113 +5,734 @@ package worker
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/hiphops-io/hops/nats"
 	"github.com/nats-io/nats.go/jetstream"
 )
 
+// ErrAckExtensionFailed is the cause of a handler's context being cancelled when the
+// worker could not extend the message's ack deadline, meaning it will be redelivered
+var ErrAckExtensionFailed = errors.New("Unable to extend ack deadline")
+
+var (
+	appNameRegex     = regexp.MustCompile(`^[a-z\d]+$`)
+	handlerNameRegex = regexp.MustCompile(`^[a-z\d]+(?:_[a-z\d]+)*$`)
+)
+
 type (
+	// App is a set of handlers that can be called from hops
+	//
+	// Calls in hops are labelled `app_handler`, which is split on the first
+	// underscore to find the app and handler. For calls to reach a handler:
+	//   - AppName must be lowercase alphanumeric, without underscores (e.g. "github")
+	//   - Handler names must be lowercase alphanumeric, optionally separated by
+	//     single underscores (e.g. "create_issue")
+	//
+	// NewWorker returns an error for apps that don't follow these rules.
 	App interface {
 		AppName() string
 		Handlers() map[string]Handler
 	}
 
+	// ConfiguredApp is an App that declares per-handler configuration
+	ConfiguredApp interface {
+		App
+		HandlerConfigs() map[string]HandlerConfig
+	}
+
+	// DefaultHandlerApp is an App with a fallback handler for calls that don't match
+	// any of its handlers by name, allowing gateway-style apps (e.g. proxying every
+	// `http_*` call to a single function)
+	//
+	// The name of the handler that was called is available from the request's metadata,
+	// via nats.MsgMetaFromContext.
+	DefaultHandlerApp interface {
+		App
+		DefaultHandler() Handler
+	}
+
+	// Handler handles a request message for an App
+	//
+	// The context carries the parsed message metadata, retrievable via nats.MsgMetaFromContext,
+	// a logger scoped to the request, retrievable via LoggerFromContext, and a reporter
+	// for interim progress updates, retrievable via ProgressFromContext.
 	// TODO: Update function to return a pointer to a ResultMsg
 	Handler func(context.Context, jetstream.Msg) error
 
+	// HandlerConfig holds the per-handler preferences declared by a ConfiguredApp
+	HandlerConfig struct {
+		// MaxDuration is the longest a handler is allowed to run for. It is used as
+		// the handler's context deadline and sets how often the message's ack
+		// deadline is extended whilst the handler runs.
+		//
+		// Handlers without a MaxDuration are allowed to run for the consumer's AckWait.
+		MaxDuration time.Duration
+	}
+
+	// Middleware wraps a Handler, allowing cross-cutting behaviour to be added
+	// to every handler registered on a Worker
+	Middleware func(Handler) Handler
+
 	// Deprecated: Use AppWorker instead
 	Worker struct {
-		app        App
-		logger     Logger
-		natsClient *nats.Client
-		handlers   map[string]Handler
+		active            sync.WaitGroup
+		app               App
+		cancelRun         context.CancelFunc
+		defaultHandler    Handler
+		deregistered      map[string]bool
+		handlerConfigs    map[string]HandlerConfig
+		heartbeatInterval time.Duration
+		idempotent        bool
+		inFlight          atomic.Int64
+		instanceId        string
+		logger            Logger
+		maxDeliveries     int
+		metrics           Metrics
+		middleware        []Middleware
+		mu                sync.RWMutex
+		natsClient        *nats.Client
+		handlers          map[string]Handler
+		runErr            error
+		runMu             sync.Mutex
+		scope             map[string]bool
+		stopRun           context.CancelFunc
+		stopped           chan struct{}
+		version           string
 	}
 )
 
 // Deprecated: Use NewAppWorker instead
-func NewWorker(natsClient *nats.Client, app App, logger Logger) *Worker {
+func NewWorker(natsClient *nats.Client, app App, logger Logger) (*Worker, error) {
 	w := &Worker{
-		app:        app,
-		logger:     logger,
-		natsClient: natsClient,
+		app:          app,
+		deregistered: map[string]bool{},
+		instanceId:   uuid.NewString(),
+		logger:       logger,
+		natsClient:   natsClient,
+	}
+
+	// Copy the handlers, as they may be changed whilst the worker is running
+	w.handlers = map[string]Handler{}
+	for name, handler := range app.Handlers() {
+		w.handlers[name] = handler
+	}
+
+	err := validateNames(app.AppName(), w.handlers)
+	if err != nil {
+		return nil, err
+	}
+
+	if defaultApp, ok := app.(DefaultHandlerApp); ok {
+		w.defaultHandler = defaultApp.DefaultHandler()
+	}
+
+	var scope []string
+	if natsClient != nil {
+		scope = natsClient.WorkerHandlers(app.AppName())
+	}
+	err = validateScope(app.AppName(), scope, w.handlers, w.defaultHandler != nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(scope) > 0 {
+		w.scope = map[string]bool{}
+		for _, name := range scope {
+			w.scope[name] = true
+		}
 	}
 
-	w.handlers = app.Handlers()
+	err = w.initHandlerConfigs()
+	if err != nil {
+		return nil, err
+	}
 
-	return w
+	return w, nil
 }
 
+// DeregisterHandler removes a handler from the worker
+//
+// It is safe to call whilst the worker is running. Any requests for the handler
+// received afterwards are nak'd so they can be picked up by another worker.
+func (w *Worker) DeregisterHandler(name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delete(w.handlers, name)
+	w.deregistered[name] = true
+}
+
+// RegisterHandler adds or replaces a handler on the worker, erroring if its
+// name can't be called from hops (as checked by NewWorker)
+//
+// It is safe to call whilst the worker is running.
+func (w *Worker) RegisterHandler(name string, handler Handler) error {
+	err := validateHandlerName(w.app.AppName(), name)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.handlers[name] = handler
+	delete(w.deregistered, name)
+
+	return nil
+}
+
+// Run handles requests until ctx is cancelled or Stop is called, letting requests
+// that were already received finish before returning
 func (w *Worker) Run(ctx context.Context) error {
+	stopped := make(chan struct{})
+	defer close(stopped)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ctx, stop := nats.ContextWithStop(ctx)
+
+	w.runMu.Lock()
+	w.cancelRun = cancel
+	w.stopRun = stop
+	w.stopped = stopped
+	w.runMu.Unlock()
+
 	consumerName := w.app.AppName()
 
 	// Get the ack deadline
 	ackDeadline := w.natsClient.Consumers[consumerName].CachedInfo().Config.AckWait
 
 	callback := func(msg jetstream.Msg) {
-		startedAt := time.Now()
+		w.active.Add(1)
+		defer w.active.Done()
 
-		subject := msg.Subject()
-		w.logger.Infof("Received request %s", subject)
+		w.handleRequest(ctx, msg, ackDeadline)
+	}
 
-		parsedMsg, err := nats.Parse(msg)
-		if err != nil {
-			w.logger.Errf(err, "Unable to handle request message: %s", subject)
-			msg.Nak()
-			return
+	if w.heartbeatInterval > 0 {
+		go w.runHeartbeat(ctx)
+	}
+
+	w.logger.Infof("Listening for requests")
+
+	// Blocks until cancelled or errors
+	err := w.natsClient.Consume(ctx, consumerName, callback)
+
+	// Let requests that were already received finish before returning
+	w.active.Wait()
+
+	w.runMu.Lock()
+	w.runErr = err
+	w.runMu.Unlock()
+
+	return err
+}
+
+// Pause stops the worker receiving requests until Resume is called, e.g. for
+// maintenance, returning once it has stopped or ctx is done
+//
+// Requests already received are still handled, whilst the rest are left on the
+// server for when the worker resumes (or for other workers). Pausing pauses
+// everything consuming with the worker's NATS client (see nats.Client.Pause).
+func (w *Worker) Pause(ctx context.Context) error {
+	return w.natsClient.Pause(ctx)
+}
+
+// Paused returns true if the worker is paused (see Pause)
+func (w *Worker) Paused() bool {
+	return w.natsClient.Paused()
+}
+
+// Resume starts the worker receiving requests again after Pause, returning once
+// it has or ctx is done
+func (w *Worker) Resume(ctx context.Context) error {
+	return w.natsClient.Resume(ctx)
+}
+
+// SetHeartbeat enables periodic heartbeats whilst the worker is running, allowing
+// running instances to be listed with nats.Client.ListWorkers
+//
+// Heartbeats report the worker's instance ID, app name, handlers, number of in-flight
+// requests and the given version. Should be called before Run.
+func (w *Worker) SetHeartbeat(interval time.Duration, version string) {
+	w.heartbeatInterval = interval
+	w.version = version
+}
+
+// SetIdempotent enables skipping handlers for requests that have already been handled
+//
+// After a handler succeeds, the request's result is recorded in the client's idempotency
+// store. If the request is redelivered (e.g. the worker crashed before acking it), the
+// handler is not run again and the recorded result is republished instead. Failures are
+// not recorded, so retries still run the handler.
+//
+// Requires the worker's client to be created with nats.WithIdempotencyStore.
+// Should be called before Run.
+func (w *Worker) SetIdempotent(idempotent bool) {
+	w.idempotent = idempotent
+}
+
+// SetMaxDeliveries sets how many times a request is attempted before it is failed
+//
+// When set, a failing handler's request is nak'd so it is redelivered with an
+// exponential backoff, until it has been attempted maxDeliveries times. The request
+// then receives a failure result marked as exhausted, is terminated and is copied
+// to the client's dead letter subject (if one is configured).
+//
+// Defaults to 0, which responds with a failure result on the first failed
+// attempt without retrying. Should be called before Run.
+func (w *Worker) SetMaxDeliveries(maxDeliveries int) {
+	w.maxDeliveries = maxDeliveries
+}
+
+// SetMetrics sets the metrics implementation that receives observations
+// about handled requests. Metrics are not collected unless set.
+//
+// Should be called before Run.
+func (w *Worker) SetMetrics(metrics Metrics) {
+	w.metrics = metrics
+}
+
+// Stop stops the worker consuming, then waits for the requests it's handling
+// to finish, returning any error Run returned
+//
+// If ctx is done first, the contexts of handlers still running are cancelled and
+// the context's error is returned. The NATS client is left open as it may be
+// shared, but can be closed once Stop returns. Does nothing if the worker has not been run.
+func (w *Worker) Stop(ctx context.Context) error {
+	w.runMu.Lock()
+	cancel, stop, stopped := w.cancelRun, w.stopRun, w.stopped
+	w.runMu.Unlock()
+
+	if stopped == nil {
+		return nil
+	}
+
+	stop()
+
+	select {
+	case <-stopped:
+		w.runMu.Lock()
+		defer w.runMu.Unlock()
+
+		return w.runErr
+	case <-ctx.Done():
+		cancel()
+		return fmt.Errorf("Timed out waiting for requests to finish: %w", ctx.Err())
+	}
+}
+
+// Use appends middleware to the worker, wrapping every registered handler
+//
+// Middleware is applied in the order given, so the first middleware is the
+// outermost and will be called first.
+func (w *Worker) Use(middleware ...Middleware) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.middleware = append(w.middleware, middleware...)
+}
+
+// ackOrNak acknowledges a handled request, retrying once on failure
+//
+// If the ack can't be made the message is nak'd, so it is promptly redelivered rather
+// than waiting for the ack deadline. As the handler has already run, the redelivery
+// will repeat its side effects, so the failure is logged along with the delivery count.
+func (w *Worker) ackOrNak(ctx context.Context, msg jetstream.Msg, numDelivered uint64) {
+	logger := w.loggerFor(ctx)
+	subject := msg.Subject()
+
+	err := nats.DoubleAck(ctx, msg)
+	if err != nil {
+		logger.Warnf("Unable to acknowledge request message, retrying: %s", subject)
+		err = nats.DoubleAck(ctx, msg)
+	}
+	if err != nil {
+		logger.Errf(err, "Unable to acknowledge request message (delivery %d), handler will be re-run on redelivery: %s", numDelivered, subject)
+
+		nakErr := msg.NakWithDelay(3 * time.Second)
+		if nakErr != nil {
+			logger.Errf(nakErr, "Unable to nak request message: %s", subject)
 		}
+		return
+	}
 
-		// Get the handler function if it exists. Terminate if not as there's nothing
-		// to be done.
-		handler, ok := w.handlers[parsedMsg.HandlerName]
-		if !ok {
-			w.logger.Warnf("Unknown handler call '%s' in msg '%s'", parsedMsg.HandlerName, subject)
-			msg.Term()
-			return
+	logger.Debugf("Request message acknowledged (will not be re-sent) %s", subject)
+}
+
+// terminate sends a final failure result for a request that will not be retried,
+// then terminates it. Exhausted requests are also copied to the dead letter subject.
+func (w *Worker) terminate(ctx context.Context, msg jetstream.Msg, parsedMsg *nats.MsgMeta, startedAt time.Time, err error, exhausted bool) {
+	logger := w.loggerFor(ctx)
+	subject := msg.Subject()
+
+	resultMsg := nats.NewResultMsg(startedAt, nil, err)
+	resultMsg.Hops.Exhausted = exhausted
+
+	replyErr, _ := w.natsClient.PublishResult(ctx, startedAt, resultMsg, err, parsedMsg.ResponseSubject())
+	if replyErr != nil {
+		logger.Errf(replyErr, "Unable to send reply to request message: %s", subject)
+		msg.Nak()
+		return
+	}
+
+	if exhausted {
+		sent, dlErr := w.natsClient.PublishDeadLetter(ctx, msg)
+		if dlErr != nil {
+			logger.Errf(dlErr, "Unable to send request message to dead letter subject: %s", subject)
+		}
+		if sent {
+			logger.Infof("Request message sent to dead letter subject %s", subject)
 		}
+	}
+
+	err = msg.Term()
+	if err != nil {
+		logger.Errf(err, "Unable to terminate request message: %s", subject)
+		return
+	}
 
-		// Attempt to run the task's handler, immediately respond with failure if not
-		var replyErr error
-		err = w.runHandler(ctx, msg, handler, ackDeadline)
+	logger.Debugf("Request message terminated (will not be re-sent) %s", subject)
+}
+
+// handleRequest runs the handler for a request message, responding and acking as appropriate
+func (w *Worker) handleRequest(ctx context.Context, msg jetstream.Msg, ackDeadline time.Duration) {
+	startedAt := time.Now()
+
+	subject := msg.Subject()
+	w.logger.Infof("Received request %s", subject)
+
+	parsedMsg, err := nats.Parse(msg)
+	if errors.Is(err, nats.ErrMalformedSubject) {
+		w.logger.Errf(err, "Unable to handle request message: %s", subject)
+		msg.Term()
+		return
+	}
+	if err != nil {
+		w.logger.Errf(err, "Unable to handle request message: %s", subject)
+		msg.Nak()
+		return
+	}
+
+	// Everything logged for the request from here on, including by the handler,
+	// carries the request's correlation fields
+	logger := newMsgLogger(w.logger, parsedMsg)
+	ctx = contextWithLogger(ctx, logger)
+
+	if w.metrics != nil {
+		w.metrics.ObserveQueueAge(startedAt.Sub(parsedMsg.Timestamp))
+	}
+
+	// Get the handler function if it exists, falling back to the app's default handler.
+	// If it has been deregistered, another worker may still be able to handle it.
+	// Otherwise terminate as there's nothing to be done.
+	handler, ok, deregistered := w.lookupHandler(parsedMsg.HandlerName)
+	if !ok && deregistered {
+		logger.Warnf("Deregistered handler call '%s' in msg '%s'", parsedMsg.HandlerName, subject)
+		msg.Nak()
+		return
+	}
+	if !ok {
+		logger.Warnf("Unknown handler call '%s' in msg '%s'", parsedMsg.HandlerName, subject)
+		msg.Term()
+		return
+	}
+
+	// Requests which have already used up their deliveries (e.g. redelivered after
+	// the worker crashed mid-handler) are failed without running again
+	maxDeliveries := w.maxDeliveries
+	if maxDeliveries > 0 && parsedMsg.NumDelivered > uint64(maxDeliveries) {
+		err := fmt.Errorf("Request exceeded maximum deliveries (%d)", maxDeliveries)
+		w.terminate(ctx, msg, parsedMsg, startedAt, err, true)
+		return
+	}
+
+	// Requests that have been handled before (e.g. redelivered after an ack was lost)
+	// get their previous result rather than running the handler again
+	if w.idempotent && w.replayHandledResult(ctx, parsedMsg) {
+		w.ackOrNak(ctx, msg, parsedMsg.NumDelivered)
+		return
+	}
+
+	// Handlers have until the consumer's ack wait to finish, unless they declare a max duration
+	handlerDeadline := ackDeadline
+	if conf, ok := w.handlerConfigs[parsedMsg.HandlerName]; ok {
+		handlerDeadline = conf.MaxDuration
+	}
+
+	handlerCtx, cancel := context.WithTimeout(ctx, handlerDeadline)
+	defer cancel()
+
+	// Handlers get the parsed message via nats.MsgMetaFromContext rather than parsing it again,
+	// and can report progress via ProgressFromContext
+	handlerCtx = nats.ContextWithMsgMeta(handlerCtx, parsedMsg)
+	handlerCtx = contextWithProgress(handlerCtx, w.natsClient, parsedMsg.ResponseSubject())
+
+	// Attempt to run the task's handler, immediately respond with failure if not
+	var replyErr error
+	handlerStartedAt := time.Now()
+	w.inFlight.Add(1)
+	err = w.runHandler(handlerCtx, msg, w.wrapHandler(handler), handlerDeadline)
+	w.inFlight.Add(-1)
+	if w.metrics != nil {
+		outcome := OutcomeSuccess
 		if err != nil {
-			w.logger.Errf(err, "Failed to handle request %s", subject)
-			err, _ := w.natsClient.PublishResult(ctx, startedAt, nil, err, parsedMsg.ResponseSubject())
-			replyErr = err
+			outcome = OutcomeFailure
 		}
+		w.metrics.ObserveHandler(parsedMsg.HandlerName, time.Since(handlerStartedAt), outcome)
+	}
 
-		if replyErr != nil {
-			w.logger.Errf(err, "Unable to send reply to request message: %s", subject)
-			msg.Nak()
+	// Requests cancelled part way through (e.g. by the worker shutting down) are
+	// left for redelivery, rather than failing for reasons unrelated to the request
+	if err != nil && ctx.Err() != nil {
+		logger.Warnf("Handling request %s cancelled, will be redelivered", subject)
+		msg.Nak()
+		return
+	}
+
+	// Handlers that succeeded as the worker began shutting down are still acked,
+	// so they aren't run again
+	if ctx.Err() != nil {
+		ctx = contextWithLogger(context.Background(), logger)
+	}
+
+	// Requests whose ack deadline lapsed may already have been redelivered to
+	// another worker, so are neither failed nor acked (or nak'd) here
+	if errors.Is(err, ErrAckExtensionFailed) {
+		logger.Errf(err, "Lost the ack deadline of request %s, will be redelivered", subject)
+		return
+	}
+
+	var fatalErr *FatalError
+	if errors.As(err, &fatalErr) {
+		logger.Errf(err, "Failed to handle request %s with fatal error, not retrying", subject)
+		w.terminate(ctx, msg, parsedMsg, startedAt, err, false)
+		return
+	}
+
+	var retryableErr *RetryableError
+	canRetry := maxDeliveries == 0 || parsedMsg.NumDelivered < uint64(maxDeliveries)
+	if errors.As(err, &retryableErr) && canRetry {
+		delay := retryDelay(parsedMsg.NumDelivered)
+		logger.Errf(err, "Failed to handle request %s (attempt %d), retrying in %s", subject, parsedMsg.NumDelivered, delay)
+		msg.NakWithDelay(delay)
+		return
+	}
+
+	if err != nil && maxDeliveries > 0 {
+		if parsedMsg.NumDelivered < uint64(maxDeliveries) {
+			delay := retryDelay(parsedMsg.NumDelivered)
+			logger.Errf(err, "Failed to handle request %s (attempt %d of %d), retrying in %s", subject, parsedMsg.NumDelivered, maxDeliveries, delay)
+			msg.NakWithDelay(delay)
 			return
 		}
 
-		// Ack the original message even in case of error (since we received it and processed regardless)
-		err = nats.DoubleAck(ctx, msg)
-		if err != nil {
-			w.logger.Errf(err, "Unable to acknowledge request message: %s", subject)
-			msg.NakWithDelay(3 * time.Second)
+		logger.Errf(err, "Failed to handle request %s, no retries remaining", subject)
+		w.terminate(ctx, msg, parsedMsg, startedAt, err, true)
+		return
+	}
+	if err != nil {
+		logger.Errf(err, "Failed to handle request %s", subject)
+		err, _ := w.natsClient.PublishResult(ctx, startedAt, nil, err, parsedMsg.ResponseSubject())
+		replyErr = err
+	}
+	if err == nil && w.idempotent {
+		w.recordHandledResult(ctx, parsedMsg)
+	}
+
+	if replyErr != nil {
+		logger.Errf(err, "Unable to send reply to request message: %s", subject)
+		msg.Nak()
+		return
+	}
+
+	// Ack the original message even in case of error (since we received it and processed regardless)
+	w.ackOrNak(ctx, msg, parsedMsg.NumDelivered)
+}
+
+// heartbeat returns the current liveness report for the worker
+func (w *Worker) heartbeat() nats.WorkerHeartbeat {
+	w.mu.RLock()
+	handlerNames := make([]string, 0, len(w.handlers))
+	for name := range w.handlers {
+		// Scoped workers only receive requests for the handlers in their scope
+		if w.scope != nil && !w.scope[name] {
+			continue
 		}
+		handlerNames = append(handlerNames, name)
+	}
+	w.mu.RUnlock()
+
+	sort.Strings(handlerNames)
 
-		w.logger.Debugf("Request message acknowledged (will not be re-sent) %s", subject)
+	return nats.WorkerHeartbeat{
+		AppName:    w.app.AppName(),
+		Handlers:   handlerNames,
+		InFlight:   w.inFlight.Load(),
+		InstanceId: w.instanceId,
+		Paused:     w.natsClient.Paused(),
+		Timestamp:  time.Now(),
+		Version:    w.version,
 	}
+}
 
-	w.logger.Infof("Listening for requests")
+// initHandlerConfigs validates any per-handler config declared by the app,
+// setting the consumer's ack wait to the longest max duration declared
+func (w *Worker) initHandlerConfigs() error {
+	configuredApp, ok := w.app.(ConfiguredApp)
+	if !ok {
+		return nil
+	}
 
-	// Blocks until cancelled or errors
-	return w.natsClient.Consume(ctx, consumerName, callback)
+	w.handlerConfigs = configuredApp.HandlerConfigs()
+
+	var maxDuration time.Duration
+	for name, conf := range w.handlerConfigs {
+		if _, ok := w.handlers[name]; !ok {
+			return fmt.Errorf("Config given for unknown handler '%s'", name)
+		}
+
+		if conf.MaxDuration <= 0 {
+			return fmt.Errorf("Invalid max duration for handler '%s': must be greater than zero", name)
+		}
+
+		if conf.MaxDuration > maxDuration {
+			maxDuration = conf.MaxDuration
+		}
+	}
+
+	if maxDuration == 0 {
+		return nil
+	}
+
+	return w.natsClient.SetConsumerAckWait(context.Background(), w.app.AppName(), maxDuration)
+}
+
+// loggerFor returns the logger scoped to the request being handled in ctx,
+// falling back to the worker's logger
+func (w *Worker) loggerFor(ctx context.Context) Logger {
+	if logger, ok := LoggerFromContext(ctx); ok {
+		return logger
+	}
+
+	return w.logger
+}
+
+// lookupHandler returns the handler registered with name, or the default handler
+// if there is one, and whether a handler of that name was deregistered
+func (w *Worker) lookupHandler(name string) (Handler, bool, bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.deregistered[name] {
+		return nil, false, true
+	}
+
+	handler, ok := w.handlers[name]
+	if !ok && w.defaultHandler != nil {
+		return w.defaultHandler, true, false
+	}
+
+	return handler, ok, false
+}
+
+// recordHandledResult stores the result of a successfully handled request, if it
+// has been published, so redeliveries of the request can be skipped
+func (w *Worker) recordHandledResult(ctx context.Context, parsedMsg *nats.MsgMeta) {
+	logger := w.loggerFor(ctx)
+	var result []byte
+
+	rawMsg, err := w.natsClient.GetMsg(ctx, nats.ChannelNotify, parsedMsg.SequenceId, parsedMsg.MessageId)
+	if err == nil && rawMsg != nil {
+		result = rawMsg.Data
+	}
+
+	err = w.natsClient.PutHandledResult(ctx, parsedMsg, result)
+	if err != nil {
+		logger.Errf(err, "Unable to record handled request: %s", parsedMsg.Msg().Subject())
+	}
+}
+
+// replayHandledResult republishes the recorded result of a request if it has already
+// been handled, returning whether it had been
+//
+// If the record can't be read, the request is treated as not handled.
+func (w *Worker) replayHandledResult(ctx context.Context, parsedMsg *nats.MsgMeta) bool {
+	logger := w.loggerFor(ctx)
+	subject := parsedMsg.Msg().Subject()
+
+	result, found, err := w.natsClient.GetHandledResult(ctx, parsedMsg)
+	if err != nil {
+		logger.Errf(err, "Unable to check whether request was already handled: %s", subject)
+		return false
+	}
+	if !found {
+		return false
+	}
+
+	logger.Infof("Request already handled, skipping handler: %s", subject)
+
+	// Results published by the handler asynchronously may not have been recorded
+	if len(result) == 0 {
+		return true
+	}
+
+	_, _, err = w.natsClient.Publish(ctx, result, parsedMsg.ResponseSubject())
+	if err != nil {
+		logger.Errf(err, "Unable to republish result for request: %s", subject)
+	}
+
+	return true
+}
+
+// runHeartbeat publishes a heartbeat immediately, then once per interval until ctx is cancelled
+func (w *Worker) runHeartbeat(ctx context.Context) {
+	ticker := time.NewTicker(w.heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		err := w.natsClient.PublishHeartbeat(ctx, w.heartbeat())
+		if err != nil {
+			w.logger.Errf(err, "Unable to publish worker heartbeat")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
 
 // runHandler runs a WorkHandler function whilst automatically extending the ack deadline until completion
+//
+// The handler is given a context that is cancelled as soon as runHandler returns, including
+// when the ack deadline can no longer be extended (with ErrAckExtensionFailed as the cause).
+// Handlers that respect ctx.Done() will therefore stop work once the message would be redelivered.
+//
+// Once ctx is done the deadline is no longer extended, but the handler's result is
+// still waited for so it can finish unwinding.
 func (w *Worker) runHandler(ctx context.Context, msg jetstream.Msg, handler Handler, deadline time.Duration) error {
-	doneChan := make(chan bool)
-	errChan := make(chan error)
+	// Buffered so the handler's goroutine can always exit, even if we've stopped waiting
+	doneChan := make(chan bool, 1)
+	errChan := make(chan error, 1)
+
+	handlerCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
 
 	// We'll extend the deadline when there's a third of the duration left
 	ticker := time.NewTicker(deadline - (deadline / 3))
 	defer ticker.Stop()
 
 	go func() {
-		err := handler(ctx, msg)
+		err := handler(handlerCtx, msg)
 		if err != nil {
 			errChan <- err
 			return
@@ -123,12 +744,23 @@ func (w *Worker) runHandler(ctx context.Context, msg jetstream.Msg, handler Hand
 	// Immediately extend redelivery window so we can start from a known duration
 	msg.InProgress()
 
+	extend := ticker.C
+	cancelled := handlerCtx.Done()
+
 	for {
 		select {
+		// Stop extending the deadline once cancelled, without waiting for the next tick
+		case <-cancelled:
+			ticker.Stop()
+			extend = nil
+			cancelled = nil
+
 		// Periodically extend the ack deadline whilst we work
-		case <-ticker.C:
+		case <-extend:
 			err := msg.InProgress()
 			if err != nil {
+				err = fmt.Errorf("%w: %w", ErrAckExtensionFailed, err)
+				cancel(err)
 				return err
 			}
 
@@ -140,3 +772,95 @@ func (w *Worker) runHandler(ctx context.Context, msg jetstream.Msg, handler Hand
 		}
 	}
 }
+
+// wrapHandler applies the worker's middleware to a handler
+func (w *Worker) wrapHandler(handler Handler) Handler {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	for i := len(w.middleware) - 1; i >= 0; i-- {
+		handler = w.middleware[i](handler)
+	}
+
+	return handler
+}
+
+// LoggingMiddleware logs the subject, duration and outcome of each handled request
+//
+// The request's scoped logger is used when available, so lines carry its correlation fields.
+func LoggingMiddleware(logger Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg jetstream.Msg) error {
+			startedAt := time.Now()
+
+			reqLogger := logger
+			if scoped, ok := LoggerFromContext(ctx); ok {
+				reqLogger = scoped
+			}
+
+			err := next(ctx, msg)
+			if err != nil {
+				reqLogger.Errf(err, "Handler failed for %s after %s", msg.Subject(), time.Since(startedAt))
+				return err
+			}
+
+			reqLogger.Infof("Handler completed for %s in %s", msg.Subject(), time.Since(startedAt))
+			return nil
+		}
+	}
+}
+
+// RecoverMiddleware converts a panicking handler into a handler error,
+// so the request receives a failure result rather than crashing the worker
+func RecoverMiddleware(next Handler) Handler {
+	return func(ctx context.Context, msg jetstream.Msg) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("Handler panicked: %v", r)
+			}
+		}()
+
+		return next(ctx, msg)
+	}
+}
+
+// validateNames checks the app and handler names can be called from hops,
+// as a misnamed handler would otherwise never receive requests
+func validateNames(appName string, handlers map[string]Handler) error {
+	if !appNameRegex.MatchString(appName) {
+		return fmt.Errorf("Invalid app name '%s': must be lowercase alphanumeric without underscores", appName)
+	}
+
+	for name := range handlers {
+		err := validateHandlerName(appName, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateHandlerName(appName string, name string) error {
+	if !handlerNameRegex.MatchString(name) {
+		return fmt.Errorf("Invalid handler name '%s' for app '%s': must be lowercase alphanumeric separated by single underscores", name, appName)
+	}
+
+	return nil
+}
+
+// validateScope returns an error if a worker consumer is scoped to handlers the
+// app doesn't declare, unless the app has a default handler to receive them
+func validateScope(appName string, scope []string, handlers map[string]Handler, hasDefault bool) error {
+	for _, name := range scope {
+		if !handlerNameRegex.MatchString(name) {
+			return fmt.Errorf("Invalid handler name '%s' in worker scope for app '%s': must be lowercase alphanumeric separated by single underscores", name, appName)
+		}
+
+		if _, ok := handlers[name]; !ok && !hasDefault {
+			return fmt.Errorf("Worker consumer for app '%s' is scoped to handler '%s', which the app doesn't declare", appName, name)
+		}
+	}
+
+	return nil
+}
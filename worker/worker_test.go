@@ -0,0 +1,1297 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hiphops-io/hops/logs"
+	"github.com/hiphops-io/hops/nats"
+	"github.com/hiphops-io/hops/nats/natstest"
+)
+
+const testAppName = "testapp"
+
+type (
+	testApp struct {
+		handlers map[string]Handler
+		name     string
+	}
+
+	testConfiguredApp struct {
+		testApp
+		configs map[string]HandlerConfig
+	}
+
+	testDefaultHandlerApp struct {
+		testApp
+		defaultHandler Handler
+	}
+
+	// testMsg is a stub jetstream.Msg, only implementing the methods used by runHandler and ackOrNak
+	testMsg struct {
+		jetstream.Msg
+		ackCalls        atomic.Int32
+		ackErr          error
+		inProgressCalls atomic.Int32
+		inProgressErr   error
+		nakCalls        atomic.Int32
+	}
+)
+
+func (t *testApp) AppName() string {
+	if t.name != "" {
+		return t.name
+	}
+
+	return testAppName
+}
+
+func (t *testApp) Handlers() map[string]Handler {
+	return t.handlers
+}
+
+func (t *testConfiguredApp) HandlerConfigs() map[string]HandlerConfig {
+	return t.configs
+}
+
+func (t *testDefaultHandlerApp) DefaultHandler() Handler {
+	return t.defaultHandler
+}
+
+func (t *testMsg) DoubleAck(ctx context.Context) error {
+	t.ackCalls.Add(1)
+	return t.ackErr
+}
+
+// InProgress succeeds on the first call, then returns inProgressErr
+func (t *testMsg) InProgress() error {
+	if t.inProgressCalls.Add(1) == 1 {
+		return nil
+	}
+
+	return t.inProgressErr
+}
+
+func (t *testMsg) NakWithDelay(delay time.Duration) error {
+	t.nakCalls.Add(1)
+	return nil
+}
+
+func (t *testMsg) Subject() string {
+	return "account.default.request.SEQ_ID.MSG_ID.testapp.do"
+}
+
+func TestWorkerHandlerConfigValidation(t *testing.T) {
+	type testCase struct {
+		name    string
+		configs map[string]HandlerConfig
+	}
+
+	noop := func(ctx context.Context, msg jetstream.Msg) error { return nil }
+
+	tests := []testCase{
+		{
+			name:    "Zero max duration",
+			configs: map[string]HandlerConfig{"do": {MaxDuration: 0}},
+		},
+		{
+			name:    "Negative max duration",
+			configs: map[string]HandlerConfig{"do": {MaxDuration: -time.Second}},
+		},
+		{
+			name:    "Unknown handler",
+			configs: map[string]HandlerConfig{"missing": {MaxDuration: time.Minute}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			app := &testConfiguredApp{
+				testApp: testApp{handlers: map[string]Handler{"do": noop}},
+				configs: tc.configs,
+			}
+
+			_, err := NewWorker(nil, app, nil)
+			assert.Error(t, err, "Misconfigured handlers should fail worker creation")
+		})
+	}
+}
+
+func TestWorkerNameValidation(t *testing.T) {
+	type testCase struct {
+		name      string
+		appName   string
+		handler   string
+		expectErr bool
+	}
+
+	tests := []testCase{
+		{
+			name:    "Valid names",
+			appName: "github",
+			handler: "create_issue",
+		},
+		{
+			name:      "App name with underscore",
+			appName:   "git_hub",
+			handler:   "create",
+			expectErr: true,
+		},
+		{
+			name:      "Uppercase app name",
+			appName:   "GitHub",
+			handler:   "create",
+			expectErr: true,
+		},
+		{
+			name:      "Handler name with dot",
+			appName:   "github",
+			handler:   "create.issue",
+			expectErr: true,
+		},
+		{
+			name:      "Handler name with leading underscore",
+			appName:   "github",
+			handler:   "_create",
+			expectErr: true,
+		},
+		{
+			name:      "Empty handler name",
+			appName:   "github",
+			handler:   "",
+			expectErr: true,
+		},
+	}
+
+	noop := func(ctx context.Context, msg jetstream.Msg) error { return nil }
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			app := &testApp{
+				handlers: map[string]Handler{tc.handler: noop},
+				name:     tc.appName,
+			}
+
+			_, err := NewWorker(nil, app, nil)
+			if tc.expectErr {
+				assert.Error(t, err, "Invalid names should fail worker creation")
+				return
+			}
+
+			assert.NoError(t, err, "Valid names should create a worker")
+		})
+	}
+}
+
+func TestWorkerHandlerConfigAckWait(t *testing.T) {
+	natsClient, logger, cleanup := setupWorkerClient(t)
+	defer cleanup()
+
+	noop := func(ctx context.Context, msg jetstream.Msg) error { return nil }
+	app := &testConfiguredApp{
+		testApp: testApp{handlers: map[string]Handler{"quick": noop, "deploy": noop}},
+		configs: map[string]HandlerConfig{
+			"quick":  {MaxDuration: 10 * time.Second},
+			"deploy": {MaxDuration: 15 * time.Minute},
+		},
+	}
+
+	_, err := NewWorker(natsClient, app, logger)
+	require.NoError(t, err, "Worker should initialise without error")
+
+	ackWait := natsClient.Consumers[testAppName].CachedInfo().Config.AckWait
+	assert.Equal(t, 15*time.Minute, ackWait, "Consumer ack wait should be the longest max duration")
+}
+
+func TestWorkerHandlerConfigAckWaitShared(t *testing.T) {
+	localNats := natstest.NewLocalServer(t)
+	logger := logs.NewNatsZeroLogger(logs.NoOpLogger())
+	noop := func(ctx context.Context, msg jetstream.Msg) error { return nil }
+
+	newWorker := func(maxDuration time.Duration, clientOpts ...nats.ClientOpt) *nats.Client {
+		natsClient := natstest.NewClient(t, localNats, clientOpts...)
+		app := &testConfiguredApp{
+			testApp: testApp{handlers: map[string]Handler{"do": noop}},
+			configs: map[string]HandlerConfig{"do": {MaxDuration: maxDuration}},
+		}
+
+		_, err := NewWorker(natsClient, app, &logger)
+		require.NoError(t, err, "Worker should initialise without error")
+
+		return natsClient
+	}
+
+	newWorker(10*time.Minute, nats.WithWorker(testAppName))
+
+	natsClient := newWorker(20*time.Minute, nats.WithWorker(testAppName))
+	ackWait := natsClient.Consumers[testAppName].CachedInfo().Config.AckWait
+	assert.Equal(t, 10*time.Minute, ackWait, "Ack wait of a consumer shared with running workers should be unchanged")
+
+	natsClient = newWorker(
+		20*time.Minute,
+		nats.WithWorkerConsumerConfig(nats.WorkerConsumerConfig{Reconfigure: true}),
+		nats.WithWorker(testAppName),
+	)
+	ackWait = natsClient.Consumers[testAppName].CachedInfo().Config.AckWait
+	assert.Equal(t, 20*time.Minute, ackWait, "Ack wait of a shared consumer should be updated when asked")
+}
+
+func TestWorkerHandlerDeadline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	natsClient, logger, cleanup := setupWorkerClient(t)
+	defer cleanup()
+
+	deadlineChan := make(chan time.Time, 1)
+	app := &testConfiguredApp{
+		testApp: testApp{
+			handlers: map[string]Handler{
+				"do": func(ctx context.Context, msg jetstream.Msg) error {
+					deadline, ok := ctx.Deadline()
+					if !ok {
+						return errors.New("No deadline set")
+					}
+
+					deadlineChan <- deadline
+					return nil
+				},
+			},
+		},
+		configs: map[string]HandlerConfig{"do": {MaxDuration: 10 * time.Second}},
+	}
+
+	w, err := NewWorker(natsClient, app, logger)
+	require.NoError(t, err, "Worker should initialise without error")
+
+	go w.Run(ctx)
+
+	publishedAt := time.Now()
+	_, _, err = natsClient.Publish(ctx, []byte(`{}`), nats.ChannelRequest, "SEQ_ID", "MSG_ID", testAppName, "do")
+	require.NoError(t, err, "Request should be published without error")
+
+	select {
+	case deadline := <-deadlineChan:
+		assert.WithinDuration(t, publishedAt.Add(10*time.Second), deadline, 2*time.Second, "Handler deadline should match its max duration")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Handler should be called with a deadline")
+	}
+}
+
+func TestWorkerHandlerDefaultDeadline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	natsClient, logger, cleanup := setupWorkerClient(t)
+	defer cleanup()
+
+	deadlineChan := make(chan time.Time, 1)
+	app := &testApp{
+		handlers: map[string]Handler{
+			"do": func(ctx context.Context, msg jetstream.Msg) error {
+				deadline, ok := ctx.Deadline()
+				if !ok {
+					return errors.New("No deadline set")
+				}
+
+				deadlineChan <- deadline
+				return nil
+			},
+		},
+	}
+
+	w, err := NewWorker(natsClient, app, logger)
+	require.NoError(t, err, "Worker should initialise without error")
+
+	go w.Run(ctx)
+
+	publishedAt := time.Now()
+	_, _, err = natsClient.Publish(ctx, []byte(`{}`), nats.ChannelRequest, "SEQ_ID", "MSG_ID", testAppName, "do")
+	require.NoError(t, err, "Request should be published without error")
+
+	select {
+	case deadline := <-deadlineChan:
+		assert.WithinDuration(t, publishedAt.Add(nats.DefaultWorkerAckWait), deadline, 2*time.Second, "Handler deadline should match the consumer's ack wait")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Handler should be called with a deadline")
+	}
+}
+
+func TestWorkerRunHandlerExtensionFailure(t *testing.T) {
+	msg := &testMsg{inProgressErr: errors.New("Connection lost")}
+
+	causeChan := make(chan error, 1)
+	handler := func(ctx context.Context, msg jetstream.Msg) error {
+		<-ctx.Done()
+		causeChan <- context.Cause(ctx)
+		return ctx.Err()
+	}
+
+	w := &Worker{}
+	err := w.runHandler(context.Background(), msg, handler, 30*time.Millisecond)
+	assert.ErrorIs(t, err, ErrAckExtensionFailed, "Failing to extend the ack should fail the handler")
+
+	select {
+	case cause := <-causeChan:
+		assert.ErrorIs(t, cause, ErrAckExtensionFailed, "Handler context should be cancelled with the extension failure")
+	case <-time.After(time.Second):
+		t.Fatal("Handler context should be cancelled when the ack can't be extended")
+	}
+}
+
+func TestWorkerHandleRequestExtensionFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	natsClient, logger, cleanup := setupWorkerClient(t)
+	defer cleanup()
+
+	app := &testApp{
+		handlers: map[string]Handler{
+			"do": func(ctx context.Context, msg jetstream.Msg) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		},
+	}
+
+	w, err := NewWorker(natsClient, app, logger)
+	require.NoError(t, err, "Worker should initialise without error")
+
+	_, _, err = natsClient.Publish(ctx, []byte(`{}`), nats.ChannelRequest, "SEQ_ID", "MSG_ID", testAppName, "do")
+	require.NoError(t, err, "Request should be published without error")
+
+	batch, err := natsClient.Consumers[testAppName].Fetch(1, jetstream.FetchMaxWait(5*time.Second))
+	require.NoError(t, err, "Request should be fetched without error")
+	fetched := <-batch.Messages()
+	require.NotNil(t, fetched, "Request should be received")
+
+	msg := &extensionFailingMsg{Msg: fetched}
+	w.handleRequest(ctx, msg, 30*time.Millisecond)
+
+	assert.False(t, msg.acked.Load(), "Request should not be acked once its ack deadline is lost")
+	assert.False(t, msg.naked.Load(), "Request should be left for the lapsed deadline to redeliver, not nak'd")
+
+	_, err = natsClient.GetMsg(ctx, nats.ChannelNotify, "SEQ_ID", "MSG_ID")
+	assert.ErrorIs(t, err, jetstream.ErrMsgNotFound, "No failure result should be published")
+}
+
+// extensionFailingMsg is a received request whose ack deadline can only be extended once
+type extensionFailingMsg struct {
+	jetstream.Msg
+	acked           atomic.Bool
+	inProgressCalls atomic.Int32
+	naked           atomic.Bool
+}
+
+func (e *extensionFailingMsg) Ack() error {
+	e.acked.Store(true)
+	return nil
+}
+
+func (e *extensionFailingMsg) DoubleAck(ctx context.Context) error {
+	e.acked.Store(true)
+	return nil
+}
+
+func (e *extensionFailingMsg) InProgress() error {
+	if e.inProgressCalls.Add(1) == 1 {
+		return nil
+	}
+
+	return errors.New("Connection lost")
+}
+
+func (e *extensionFailingMsg) Nak() error {
+	e.naked.Store(true)
+	return e.Msg.Nak()
+}
+
+func (e *extensionFailingMsg) NakWithDelay(delay time.Duration) error {
+	e.naked.Store(true)
+	return e.Msg.NakWithDelay(delay)
+}
+
+// recordingMsg is a received request that records how it was responded to
+type recordingMsg struct {
+	jetstream.Msg
+	acked    atomic.Bool
+	nakDelay time.Duration
+	naked    atomic.Bool
+	termed   atomic.Bool
+}
+
+func (r *recordingMsg) DoubleAck(ctx context.Context) error {
+	r.acked.Store(true)
+	return r.Msg.DoubleAck(ctx)
+}
+
+func (r *recordingMsg) Nak() error {
+	r.naked.Store(true)
+	return r.Msg.Nak()
+}
+
+func (r *recordingMsg) NakWithDelay(delay time.Duration) error {
+	r.naked.Store(true)
+	r.nakDelay = delay
+	return r.Msg.NakWithDelay(delay)
+}
+
+func (r *recordingMsg) Term() error {
+	r.termed.Store(true)
+	return r.Msg.Term()
+}
+
+func TestWorkerRunHandlerCancelled(t *testing.T) {
+	msg := &testMsg{inProgressErr: errors.New("Should not be extended")}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	handler := func(ctx context.Context, msg jetstream.Msg) error {
+		cancel()
+		<-ctx.Done()
+
+		// Keep unwinding for several extension intervals
+		time.Sleep(100 * time.Millisecond)
+		return ctx.Err()
+	}
+
+	w := &Worker{}
+	err := w.runHandler(ctx, msg, handler, 30*time.Millisecond)
+	assert.ErrorIs(t, err, context.Canceled, "Handler's own error should be returned")
+	assert.Equal(t, int32(1), msg.inProgressCalls.Load(), "Ack deadline should not be extended once cancelled")
+}
+
+func TestWorkerRunCancelsHandlers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	natsClient, logger, cleanup := setupWorkerClient(t)
+	defer cleanup()
+
+	started := make(chan struct{})
+	handlerErr := make(chan error, 1)
+
+	app := &testApp{
+		handlers: map[string]Handler{
+			"block": func(ctx context.Context, msg jetstream.Msg) error {
+				close(started)
+				<-ctx.Done()
+				handlerErr <- ctx.Err()
+				return ctx.Err()
+			},
+		},
+	}
+
+	w, err := NewWorker(natsClient, app, logger)
+	require.NoError(t, err, "Worker should initialise without error")
+
+	runCtx, runCancel := context.WithCancel(ctx)
+	defer runCancel()
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- w.Run(runCtx)
+	}()
+
+	_, _, err = natsClient.Publish(ctx, []byte(`{}`), nats.ChannelRequest, "SEQ_ID", "MSG_ID", testAppName, "block")
+	require.NoError(t, err, "Request should be published without error")
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Handler should be called")
+	}
+
+	runCancel()
+
+	select {
+	case err := <-handlerErr:
+		assert.ErrorIs(t, err, context.Canceled, "Handler context should be cancelled on shutdown")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Handler should be cancelled on shutdown")
+	}
+
+	select {
+	case err := <-runErr:
+		assert.NoError(t, err, "Run should return once cancelled")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run should return once cancelled")
+	}
+}
+
+func TestWorkerAcksHandlerSucceededOnShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	natsClient, logger, cleanup := setupWorkerClient(t)
+	defer cleanup()
+
+	app := &testApp{
+		handlers: map[string]Handler{
+			"finish": func(ctx context.Context, msg jetstream.Msg) error {
+				// Shutdown begins just as the handler finishes
+				cancel()
+				return nil
+			},
+		},
+	}
+
+	w, err := NewWorker(natsClient, app, logger)
+	require.NoError(t, err, "Worker should initialise without error")
+
+	_, _, err = natsClient.Publish(context.Background(), []byte(`{}`), nats.ChannelRequest, "SEQ_ID", "MSG_ID", testAppName, "finish")
+	require.NoError(t, err, "Request should be published without error")
+
+	msg := &recordingMsg{Msg: fetchRequest(t, natsClient)}
+	w.handleRequest(ctx, msg, time.Minute)
+
+	assert.True(t, msg.acked.Load(), "Request handled successfully should be acked despite shutting down")
+	assert.False(t, msg.naked.Load(), "Request handled successfully should not be redelivered")
+}
+
+func TestWorkerAckOrNak(t *testing.T) {
+	type testCase struct {
+		name             string
+		ackErr           error
+		expectedAckCalls int32
+		expectedNakCalls int32
+	}
+
+	tests := []testCase{
+		{
+			name:             "Ack succeeds",
+			expectedAckCalls: 1,
+			expectedNakCalls: 0,
+		},
+		{
+			name:             "Connection closed before ack",
+			ackErr:           errors.New("nats: connection closed"),
+			expectedAckCalls: 2,
+			expectedNakCalls: 1,
+		},
+	}
+
+	zlog := logs.NoOpLogger()
+	logger := logs.NewNatsZeroLogger(zlog)
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			msg := &testMsg{ackErr: tc.ackErr}
+			w := &Worker{logger: &logger}
+
+			w.ackOrNak(context.Background(), msg, 1)
+
+			assert.Equal(t, tc.expectedAckCalls, msg.ackCalls.Load(), "Failed acks should be retried once")
+			assert.Equal(t, tc.expectedNakCalls, msg.nakCalls.Load(), "Message should be nak'd only if the ack fails")
+		})
+	}
+}
+
+func TestWorkerMiddlewareOrder(t *testing.T) {
+	calls := []string{}
+
+	recordingMiddleware := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, msg jetstream.Msg) error {
+				calls = append(calls, name+":before")
+				err := next(ctx, msg)
+				calls = append(calls, name+":after")
+				return err
+			}
+		}
+	}
+
+	handler := func(ctx context.Context, msg jetstream.Msg) error {
+		calls = append(calls, "handler")
+		return nil
+	}
+
+	w := &Worker{}
+	w.Use(recordingMiddleware("first"), recordingMiddleware("second"))
+	w.Use(recordingMiddleware("third"))
+
+	err := w.wrapHandler(handler)(context.Background(), nil)
+	require.NoError(t, err)
+
+	expected := []string{
+		"first:before",
+		"second:before",
+		"third:before",
+		"handler",
+		"third:after",
+		"second:after",
+		"first:after",
+	}
+	assert.Equal(t, expected, calls, "Middleware should be called in the order it was added")
+}
+
+func TestWorkerMiddlewareShortCircuit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	natsClient, logger, cleanup := setupWorkerClient(t)
+	defer cleanup()
+
+	var handlerCalled atomic.Bool
+	app := &testApp{
+		handlers: map[string]Handler{
+			"do": func(ctx context.Context, msg jetstream.Msg) error {
+				handlerCalled.Store(true)
+				return nil
+			},
+		},
+	}
+
+	w, err := NewWorker(natsClient, app, logger)
+	require.NoError(t, err, "Worker should initialise without error")
+	w.Use(RecoverMiddleware, func(next Handler) Handler {
+		return func(ctx context.Context, msg jetstream.Msg) error {
+			return errors.New("Denied by middleware")
+		}
+	})
+
+	go w.Run(ctx)
+
+	_, _, err = natsClient.Publish(ctx, []byte(`{}`), nats.ChannelRequest, "SEQ_ID", "MSG_ID", testAppName, "do")
+	require.NoError(t, err, "Request should be published without error")
+
+	result := waitForResult(ctx, t, natsClient, "SEQ_ID", "MSG_ID")
+	assert.True(t, result.Errored, "Short-circuited request should produce a failure result")
+	assert.Equal(t, "Denied by middleware", result.Hops.Error)
+	assert.False(t, handlerCalled.Load(), "Handler should not run when middleware short-circuits")
+}
+
+func TestWorkerRegisterHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	natsClient, logger, cleanup := setupWorkerClient(t)
+	defer cleanup()
+
+	app := &testApp{handlers: map[string]Handler{}}
+
+	w, err := NewWorker(natsClient, app, logger)
+	require.NoError(t, err, "Worker should initialise without error")
+
+	go w.Run(ctx)
+
+	err = w.RegisterHandler("late.handler", func(ctx context.Context, msg jetstream.Msg) error { return nil })
+	assert.Error(t, err, "Handlers should not be registered with names that can't be called")
+	err = w.RegisterHandler("*", func(ctx context.Context, msg jetstream.Msg) error { return nil })
+	assert.Error(t, err, "Handlers should not be registered with wildcard names")
+
+	receivedChan := make(chan string, 1)
+	err = w.RegisterHandler("late", func(ctx context.Context, msg jetstream.Msg) error {
+		msgMeta, ok := nats.MsgMetaFromContext(ctx)
+		if !ok {
+			return errors.New("No message metadata in context")
+		}
+
+		receivedChan <- fmt.Sprintf("%s %s %s", msgMeta.SequenceId, msgMeta.HandlerName, msg.Data())
+		return nil
+	})
+	require.NoError(t, err, "Handler should be registered without error")
+
+	_, _, err = natsClient.Publish(ctx, []byte("Hello"), nats.ChannelRequest, "SEQ_ID", "MSG_ID", testAppName, "late")
+	require.NoError(t, err, "Request should be published without error")
+
+	select {
+	case data := <-receivedChan:
+		assert.Equal(t, "SEQ_ID late Hello", data, "Handler should receive message metadata via context")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Handler registered after starting the worker should receive requests")
+	}
+}
+
+func TestWorkerDefaultHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	natsClient, logger, cleanup := setupWorkerClient(t)
+	defer cleanup()
+
+	receivedChan := make(chan string, 2)
+	record := func(kind string) Handler {
+		return func(ctx context.Context, msg jetstream.Msg) error {
+			msgMeta, _ := nats.MsgMetaFromContext(ctx)
+			receivedChan <- fmt.Sprintf("%s %s", kind, msgMeta.HandlerName)
+			return nil
+		}
+	}
+
+	app := &testDefaultHandlerApp{
+		testApp:        testApp{handlers: map[string]Handler{"exact": record("exact")}},
+		defaultHandler: record("default"),
+	}
+
+	w, err := NewWorker(natsClient, app, logger)
+	require.NoError(t, err, "Worker should initialise without error")
+
+	go w.Run(ctx)
+
+	for _, handlerName := range []string{"exact", "get_anything"} {
+		_, _, err = natsClient.Publish(ctx, []byte(`{}`), nats.ChannelRequest, "SEQ_ID", handlerName, testAppName, handlerName)
+		require.NoError(t, err, "Request should be published without error")
+	}
+
+	received := []string{}
+	for i := 0; i < 2; i++ {
+		select {
+		case data := <-receivedChan:
+			received = append(received, data)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Both requests should be handled")
+		}
+	}
+
+	assert.ElementsMatch(t, []string{"exact exact", "default get_anything"}, received, "Unmatched calls should go to the default handler")
+}
+
+func TestWorkerMaxDeliveries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	natsClient, logger, cleanup := setupWorkerClient(t)
+	defer cleanup()
+
+	var attempts atomic.Int32
+	app := &testApp{
+		handlers: map[string]Handler{
+			"fail": func(ctx context.Context, msg jetstream.Msg) error {
+				attempts.Add(1)
+				return errors.New("Always fails")
+			},
+		},
+	}
+
+	w, err := NewWorker(natsClient, app, logger)
+	require.NoError(t, err, "Worker should initialise without error")
+	w.SetMaxDeliveries(3)
+
+	go w.Run(ctx)
+
+	_, _, err = natsClient.Publish(ctx, []byte(`{}`), nats.ChannelRequest, "SEQ_ID", "MSG_ID", testAppName, "fail")
+	require.NoError(t, err, "Request should be published without error")
+
+	result := waitForResult(ctx, t, natsClient, "SEQ_ID", "MSG_ID")
+	assert.True(t, result.Errored, "Exhausted request should produce a failure result")
+	assert.True(t, result.Hops.Exhausted, "Failure result should be marked as exhausted")
+	assert.Equal(t, "Always fails", result.Hops.Error)
+
+	// Give any unexpected redeliveries a chance to arrive
+	time.Sleep(500 * time.Millisecond)
+	assert.Equal(t, int32(3), attempts.Load(), "Handler should be attempted exactly max deliveries times")
+}
+
+func TestWorkerMaxDeliveriesBackoff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	natsClient, logger, cleanup := setupWorkerClient(t)
+	defer cleanup()
+
+	app := &testApp{
+		handlers: map[string]Handler{
+			"fail": func(ctx context.Context, msg jetstream.Msg) error {
+				return errors.New("Always fails")
+			},
+		},
+	}
+
+	w, err := NewWorker(natsClient, app, logger)
+	require.NoError(t, err, "Worker should initialise without error")
+	w.SetMaxDeliveries(3)
+
+	_, _, err = natsClient.Publish(ctx, []byte(`{}`), nats.ChannelRequest, "SEQ_ID", "MSG_ID", testAppName, "fail")
+	require.NoError(t, err, "Request should be published without error")
+
+	msg := &recordingMsg{Msg: fetchRequest(t, natsClient)}
+	w.handleRequest(ctx, msg, time.Minute)
+
+	assert.Equal(t, retryDelay(1), msg.nakDelay, "Failed request should be nak'd with a backoff")
+	assert.False(t, msg.acked.Load(), "Failed request with deliveries remaining should not be acked")
+	assert.False(t, msg.termed.Load(), "Failed request with deliveries remaining should not be terminated")
+
+	_, err = natsClient.GetMsg(ctx, nats.ChannelNotify, "SEQ_ID", "MSG_ID")
+	assert.ErrorIs(t, err, jetstream.ErrMsgNotFound, "No result should be sent whilst the request can be retried")
+}
+
+func TestWorkerMaxDeliveriesExhausted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	localNats := natstest.NewLocalServer(t)
+	user, err := localNats.User("")
+	require.NoError(t, err, "Test setup: Should have valid NATS user")
+
+	deadLetterSubj := user.Account.Name + ".deadletter"
+	natsClient := natstest.NewClient(t, localNats, nats.WithDeadLetterSubject(deadLetterSubj), nats.WithWorker(testAppName))
+	logger := logs.NewNatsZeroLogger(logs.NoOpLogger())
+
+	var attempts atomic.Int32
+	app := &testApp{
+		handlers: map[string]Handler{
+			"fail": func(ctx context.Context, msg jetstream.Msg) error {
+				attempts.Add(1)
+				return errors.New("Always fails")
+			},
+		},
+	}
+
+	w, err := NewWorker(natsClient, app, &logger)
+	require.NoError(t, err, "Worker should initialise without error")
+	w.SetMaxDeliveries(1)
+
+	_, _, err = natsClient.Publish(ctx, []byte(`{"in":"put"}`), nats.ChannelRequest, "SEQ_ID", "MSG_ID", testAppName, "fail")
+	require.NoError(t, err, "Request should be published without error")
+
+	fetched := fetchRequest(t, natsClient)
+	msg := &recordingMsg{Msg: fetched}
+	w.handleRequest(ctx, msg, time.Minute)
+
+	assert.True(t, msg.termed.Load(), "Exhausted request should be terminated")
+	assert.False(t, msg.acked.Load(), "Exhausted request should not be acked")
+	assert.Zero(t, msg.nakDelay, "Exhausted request should not be nak'd")
+
+	result := waitForResult(ctx, t, natsClient, "SEQ_ID", "MSG_ID")
+	assert.True(t, result.Errored, "Exhausted request should produce a failure result")
+	assert.True(t, result.Hops.Exhausted, "Failure result should be marked as exhausted")
+
+	stream, err := natsClient.JetStream.Stream(ctx, natsClient.StreamName())
+	require.NoError(t, err, "Test setup: Should get the account stream")
+	deadLetter, err := stream.GetLastMsgForSubject(ctx, deadLetterSubj+"."+fetched.Subject())
+	require.NoError(t, err, "Exhausted request should be copied to the dead letter subject")
+	assert.Equal(t, []byte(`{"in":"put"}`), deadLetter.Data)
+
+	// Terminated requests are never redelivered
+	go w.Run(ctx)
+	time.Sleep(500 * time.Millisecond)
+	assert.Equal(t, int32(1), attempts.Load(), "Exhausted request should not be attempted again")
+}
+
+func TestWorkerErrorClassification(t *testing.T) {
+	type testCase struct {
+		name             string
+		errs             []error
+		expectedAttempts int32
+		expectedErrored  bool
+	}
+
+	tests := []testCase{
+		{
+			name:             "Fatal error is not retried",
+			errs:             []error{Fatal(errors.New("Not found"))},
+			expectedAttempts: 1,
+			expectedErrored:  true,
+		},
+		{
+			name:             "Retryable error is redelivered without a result",
+			errs:             []error{Retryable(errors.New("Rate limited")), nil},
+			expectedAttempts: 2,
+			expectedErrored:  false,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			natsClient, logger, cleanup := setupWorkerClient(t)
+			defer cleanup()
+
+			var attempts atomic.Int32
+			app := &testApp{
+				handlers: map[string]Handler{
+					"handler": func(ctx context.Context, msg jetstream.Msg) error {
+						attempt := attempts.Add(1)
+						err := tc.errs[attempt-1]
+						if err != nil {
+							return err
+						}
+
+						meta, _ := nats.MsgMetaFromContext(ctx)
+						_, sent := natsClient.PublishResult(ctx, time.Now(), "Done", nil, meta.ResponseSubject())
+						assert.True(t, sent, "Result should be published")
+						return nil
+					},
+				},
+			}
+
+			w, err := NewWorker(natsClient, app, logger)
+			require.NoError(t, err, "Worker should initialise without error")
+			// Max deliveries must not stop fatal errors failing immediately
+			w.SetMaxDeliveries(3)
+
+			go w.Run(ctx)
+
+			_, _, err = natsClient.Publish(ctx, []byte(`{}`), nats.ChannelRequest, "SEQ_ID", "MSG_ID", testAppName, "handler")
+			require.NoError(t, err, "Request should be published without error")
+
+			result := waitForResult(ctx, t, natsClient, "SEQ_ID", "MSG_ID")
+			assert.Equal(t, tc.expectedErrored, result.Errored)
+			assert.False(t, result.Hops.Exhausted, "Result should not be marked as exhausted")
+
+			// Give any unexpected redeliveries a chance to arrive
+			time.Sleep(500 * time.Millisecond)
+			assert.Equal(t, tc.expectedAttempts, attempts.Load())
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	assert.Equal(t, time.Second, retryDelay(1))
+	assert.Equal(t, 2*time.Second, retryDelay(2))
+	assert.Equal(t, 8*time.Second, retryDelay(4))
+	assert.Equal(t, time.Minute, retryDelay(20))
+}
+
+func TestWorkerHeartbeat(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	natsClient, logger, cleanup := setupWorkerClient(t)
+	defer cleanup()
+
+	noop := func(ctx context.Context, msg jetstream.Msg) error { return nil }
+	app := &testApp{handlers: map[string]Handler{"b": noop, "a": noop}}
+
+	w, err := NewWorker(natsClient, app, logger)
+	require.NoError(t, err, "Worker should initialise without error")
+	w.SetHeartbeat(50*time.Millisecond, "v1.2.3")
+
+	workerCtx, stopWorker := context.WithCancel(ctx)
+	go w.Run(workerCtx)
+
+	var workers []nats.WorkerHeartbeat
+	require.Eventually(t, func() bool {
+		workers, err = natsClient.ListWorkers(ctx, testAppName, time.Second)
+		return err == nil && len(workers) == 1
+	}, 5*time.Second, 50*time.Millisecond, "Running worker should publish a heartbeat")
+
+	assert.Equal(t, testAppName, workers[0].AppName)
+	assert.Equal(t, []string{"a", "b"}, workers[0].Handlers)
+	assert.Equal(t, "v1.2.3", workers[0].Version)
+	assert.False(t, workers[0].Stale, "Running worker should not be stale")
+
+	stopWorker()
+
+	require.Eventually(t, func() bool {
+		workers, err = natsClient.ListWorkers(ctx, testAppName, 200*time.Millisecond)
+		return err == nil && len(workers) == 1 && workers[0].Stale
+	}, 5*time.Second, 50*time.Millisecond, "Stopped worker should go stale")
+}
+
+func TestWorkerPause(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	natsClient, logger, cleanup := setupWorkerClient(t)
+	defer cleanup()
+
+	handled := make(chan string, 2)
+	app := &testApp{
+		handlers: map[string]Handler{
+			"handle": func(ctx context.Context, msg jetstream.Msg) error {
+				handled <- msg.Subject()
+				return nil
+			},
+		},
+	}
+
+	w, err := NewWorker(natsClient, app, logger)
+	require.NoError(t, err, "Worker should initialise without error")
+	w.SetHeartbeat(50*time.Millisecond, "")
+	go w.Run(ctx)
+
+	err = w.Pause(ctx)
+	require.NoError(t, err, "Worker should pause without error")
+	assert.True(t, w.Paused())
+
+	require.Eventually(t, func() bool {
+		workers, err := natsClient.ListWorkers(ctx, testAppName, time.Second)
+		return err == nil && len(workers) == 1 && workers[0].Paused
+	}, 5*time.Second, 50*time.Millisecond, "Heartbeats should report the worker is paused")
+
+	_, _, err = natsClient.Publish(ctx, []byte(`{}`), nats.ChannelRequest, "SEQ_ID", "MSG_ID", testAppName, "handle")
+	require.NoError(t, err, "Request should be published without error")
+
+	select {
+	case <-handled:
+		t.Fatal("Requests shouldn't be handled whilst paused")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	err = w.Resume(ctx)
+	require.NoError(t, err, "Worker should resume without error")
+	assert.False(t, w.Paused())
+
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Requests left whilst paused should be handled once resumed")
+	}
+}
+
+func TestWorkerScope(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	natsClient, logger, cleanup := setupWorkerClient(t, nats.WithWorker(testAppName, "deploy"))
+	defer cleanup()
+
+	receivedChan := make(chan string, 2)
+	record := func(ctx context.Context, msg jetstream.Msg) error {
+		msgMeta, _ := nats.MsgMetaFromContext(ctx)
+		receivedChan <- msgMeta.HandlerName
+		return nil
+	}
+
+	app := &testApp{handlers: map[string]Handler{"build": record, "deploy": record}}
+
+	w, err := NewWorker(natsClient, app, logger)
+	require.NoError(t, err, "Worker should initialise without error")
+	assert.Equal(t, []string{"deploy"}, w.heartbeat().Handlers, "Heartbeats should only list handlers in scope")
+
+	go w.Run(ctx)
+
+	for _, handlerName := range []string{"build", "deploy"} {
+		_, _, err = natsClient.Publish(ctx, []byte(`{}`), nats.ChannelRequest, "SEQ_ID", handlerName, testAppName, handlerName)
+		require.NoError(t, err, "Request should be published without error")
+	}
+
+	select {
+	case handlerName := <-receivedChan:
+		assert.Equal(t, "deploy", handlerName)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Request in scope should be handled")
+	}
+
+	select {
+	case handlerName := <-receivedChan:
+		t.Fatalf("Request for '%s' is out of scope, so should not be received", handlerName)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	t.Run("Undeclared handlers", func(t *testing.T) {
+		_, err := NewWorker(natsClient, &testApp{handlers: map[string]Handler{"build": record}}, logger)
+		assert.EqualError(t, err, "Worker consumer for app 'testapp' is scoped to handler 'deploy', which the app doesn't declare")
+
+		_, err = NewWorker(natsClient, &testDefaultHandlerApp{testApp: testApp{handlers: map[string]Handler{"build": record}}, defaultHandler: record}, logger)
+		assert.NoError(t, err, "Apps with a default handler can be scoped to any handler")
+	})
+}
+
+func TestWorkerStop(t *testing.T) {
+	type testCase struct {
+		name        string
+		stopTimeout time.Duration
+		release     bool
+		expectErr   bool
+	}
+
+	tests := []testCase{
+		{
+			name:        "Waits for running handlers",
+			stopTimeout: 5 * time.Second,
+			release:     true,
+		},
+		{
+			name:        "Cancels handlers on timeout",
+			stopTimeout: 100 * time.Millisecond,
+			expectErr:   true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			natsClient, logger, cleanup := setupWorkerClient(t)
+			defer cleanup()
+
+			started := make(chan struct{})
+			release := make(chan struct{})
+			var handlerErr atomic.Value
+
+			app := &testApp{
+				handlers: map[string]Handler{
+					"slow": func(ctx context.Context, msg jetstream.Msg) error {
+						close(started)
+
+						select {
+						case <-release:
+						case <-ctx.Done():
+							handlerErr.Store(ctx.Err())
+						}
+
+						return nil
+					},
+				},
+			}
+
+			w, err := NewWorker(natsClient, app, logger)
+			require.NoError(t, err, "Worker should initialise without error")
+
+			runErr := make(chan error, 1)
+			go func() {
+				runErr <- w.Run(ctx)
+			}()
+
+			_, _, err = natsClient.Publish(ctx, []byte(`{}`), nats.ChannelRequest, "SEQ_ID", "MSG_ID", testAppName, "slow")
+			require.NoError(t, err, "Request should be published without error")
+
+			select {
+			case <-started:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Handler should be called")
+			}
+
+			stopCtx, stopCancel := context.WithTimeout(ctx, tc.stopTimeout)
+			defer stopCancel()
+
+			stopErr := make(chan error, 1)
+			go func() {
+				stopErr <- w.Stop(stopCtx)
+			}()
+
+			if tc.release {
+				select {
+				case <-stopErr:
+					t.Fatal("Stop should wait for the running handler")
+				case <-time.After(100 * time.Millisecond):
+				}
+
+				close(release)
+			}
+
+			select {
+			case err := <-stopErr:
+				if tc.expectErr {
+					assert.ErrorIs(t, err, context.DeadlineExceeded)
+				} else {
+					assert.NoError(t, err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Stop should return")
+			}
+
+			select {
+			case err := <-runErr:
+				assert.NoError(t, err, "Run should return once stopped")
+			case <-time.After(5 * time.Second):
+				t.Fatal("Run should return once stopped")
+			}
+
+			if tc.expectErr {
+				assert.Equal(t, context.Canceled, handlerErr.Load(), "Handler context should be cancelled")
+			} else {
+				assert.Nil(t, handlerErr.Load(), "Handler context should not be cancelled")
+			}
+		})
+	}
+}
+
+func TestWorkerIdempotent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	natsClient, logger, cleanup := setupWorkerClient(t, nats.WithWorker(testAppName), nats.WithIdempotencyStore(time.Minute))
+	defer cleanup()
+
+	var handlerCalls atomic.Int32
+	app := &testApp{
+		handlers: map[string]Handler{
+			"post": func(ctx context.Context, msg jetstream.Msg) error {
+				handlerCalls.Add(1)
+
+				msgMeta, _ := nats.MsgMetaFromContext(ctx)
+				err, _ := natsClient.PublishResult(ctx, time.Now(), "Posted", nil, msgMeta.ResponseSubject())
+				return err
+			},
+		},
+	}
+
+	w, err := NewWorker(natsClient, app, logger)
+	require.NoError(t, err, "Worker should initialise without error")
+	w.SetIdempotent(true)
+
+	_, _, err = natsClient.Publish(ctx, []byte(`{}`), nats.ChannelRequest, "SEQ_ID", "MSG_ID", testAppName, "post")
+	require.NoError(t, err, "Request should be published without error")
+
+	batch, err := natsClient.Consumers[testAppName].Fetch(1, jetstream.FetchMaxWait(5*time.Second))
+	require.NoError(t, err, "Request should be fetched without error")
+	msg := <-batch.Messages()
+	require.NotNil(t, msg, "Request should be received")
+
+	// Handling the same message twice simulates a redelivery after the ack was lost
+	w.handleRequest(ctx, msg, time.Minute)
+	w.handleRequest(ctx, msg, time.Minute)
+
+	assert.Equal(t, int32(1), handlerCalls.Load(), "Handler should only run once for a redelivered request")
+
+	result := waitForResult(ctx, t, natsClient, "SEQ_ID", "MSG_ID")
+	assert.Equal(t, "Posted", result.Body)
+}
+
+func TestRecoverMiddleware(t *testing.T) {
+	handler := RecoverMiddleware(func(ctx context.Context, msg jetstream.Msg) error {
+		panic("oh no")
+	})
+
+	err := handler(context.Background(), nil)
+	assert.EqualError(t, err, "Handler panicked: oh no")
+}
+
+// setupWorkerClient is a test helper to create a worker client connected to a local NATS server
+func setupWorkerClient(t *testing.T, clientOpts ...nats.ClientOpt) (*nats.Client, Logger, func()) {
+	logger := logs.NewNatsZeroLogger(logs.NoOpLogger())
+
+	if len(clientOpts) == 0 {
+		clientOpts = []nats.ClientOpt{nats.WithWorker(testAppName)}
+	}
+
+	natsClient := natstest.NewServer(t, clientOpts...)
+
+	return natsClient, &logger, natsClient.Close
+}
+
+// fetchRequest is a test helper that fetches the next request from the worker's consumer
+func fetchRequest(t *testing.T, natsClient *nats.Client) jetstream.Msg {
+	batch, err := natsClient.Consumers[testAppName].Fetch(1, jetstream.FetchMaxWait(5*time.Second))
+	require.NoError(t, err, "Request should be fetched without error")
+
+	msg := <-batch.Messages()
+	require.NotNil(t, msg, "Request should be received")
+
+	return msg
+}
+
+// waitForResult is a test helper that waits for a result message to be published for a request
+func waitForResult(ctx context.Context, t *testing.T, natsClient *nats.Client, sequenceId string, messageId string) nats.ResultMsg {
+	var rawMsg *jetstream.RawStreamMsg
+
+	require.Eventually(t, func() bool {
+		msg, err := natsClient.GetMsg(ctx, nats.ChannelNotify, sequenceId, messageId)
+		rawMsg = msg
+		return err == nil && msg != nil
+	}, 5*time.Second, 50*time.Millisecond, "Result message should be published")
+
+	result := nats.ResultMsg{}
+	err := json.Unmarshal(rawMsg.Data, &result)
+	require.NoError(t, err, "Result message should be valid JSON")
+
+	return result
+}